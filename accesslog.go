@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log
+//
+// Besides the operational log, the proxy can write one record per HTTP request to a
+// separate access log, for traffic analysis and billing pipelines that should not have
+// to parse the application's messages. Records are written to standard output or
+// error, a file (rotated like the log file), or a UDP collector, one datagram per
+// record:
+//
+//	access_log:
+//	  output: /var/log/jsonrpc-proxy/access.log   # or stdout, stderr, udp://host:port
+//	  format: json                               # or combined
+//	  max_size: 100
+//	  max_backups: 7
+//
+// A JSON record holds the time, client IP, HTTP method and path, status, response size,
+// duration, JSON-RPC methods, and upstreams that served the calls. The combined format
+// follows the Apache combined log, with the methods, upstreams, and duration appended
+// as key="value" pairs. The access log does not depend on logging levels or sinks.
+
+// AccessLogConfig configures the access log.
+type AccessLogConfig struct {
+	Output     string        `yaml:"output"`      // stdout, stderr, a file path, or udp://host:port
+	Format     string        `yaml:"format"`      // json or combined (default: json)
+	MaxSize    int           `yaml:"max_size"`    // Size in megabytes at which a file is rotated (default: 100)
+	MaxBackups int           `yaml:"max_backups"` // Rotated files kept (default: all)
+	MaxAge     time.Duration `yaml:"max_age"`     // Age after which rotated files are removed (default: never)
+	Compress   bool          `yaml:"compress"`    // Gzip rotated files
+}
+
+// accessRecord is an access log record.
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	HTTPMethod string    `json:"http_method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"-"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Methods    []string  `json:"methods,omitempty"`
+	Upstreams  []string  `json:"upstreams,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// accessLog is where access records are written, or nil if the access log is off.
+var accessLog *accessLogWriter
+
+// accessLogWriter formats and writes access records.
+type accessLogWriter struct {
+	out      io.Writer
+	combined bool
+}
+
+// setupAccessLog opens the access log if configured.
+//
+// Returns:
+//   - error: An error if the configuration is invalid or the output cannot be opened
+func setupAccessLog() error {
+	ac := config.AccessLog
+	if ac == nil {
+		return nil
+	}
+	w := &accessLogWriter{}
+	switch ac.Format {
+	case "", "json":
+	case "combined":
+		w.combined = true
+	default:
+		return fmt.Errorf("unknown format %q (expected json or combined)", ac.Format)
+	}
+
+	switch {
+	case ac.Output == "":
+		return fmt.Errorf("output is required")
+	case ac.Output == "stdout":
+		w.out = os.Stdout
+	case ac.Output == "stderr":
+		w.out = os.Stderr
+	case strings.HasPrefix(ac.Output, "udp://"):
+		u, err := url.Parse(ac.Output)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid output %q", ac.Output)
+		}
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		w.out = conn
+	default:
+		if ac.MaxSize < 0 || ac.MaxBackups < 0 || ac.MaxAge < 0 {
+			return fmt.Errorf("max_size, max_backups, and max_age cannot be negative")
+		}
+		f, err := openRotatingFile(&LoggingConfig{
+			File:       ac.Output,
+			MaxSize:    ac.MaxSize,
+			MaxBackups: ac.MaxBackups,
+			MaxAge:     ac.MaxAge,
+			Compress:   ac.Compress,
+		})
+		if err != nil {
+			return err
+		}
+		w.out = f
+	}
+	accessLog = w
+	log.Printf("Writing access log to %s", ac.Output)
+	return nil
+}
+
+// write writes a record as a single line, or a single datagram over UDP.
+func (w *accessLogWriter) write(rec *accessRecord) {
+	var line []byte
+	if w.combined {
+		line = []byte(formatCombined(rec))
+	} else {
+		line, _ = json.Marshal(rec)
+		line = append(line, '\n')
+	}
+	if _, err := w.out.Write(line); err != nil {
+		logError("router", "Error writing access log: %v", err)
+	}
+}
+
+// formatCombined formats a record in the combined log format, with the proxy's
+// fields appended.
+func formatCombined(rec *accessRecord) string {
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %q %q methods=%q upstreams=%q duration=%.3f\n",
+		rec.Client, rec.Time.Format("02/Jan/2006:15:04:05 -0700"), rec.HTTPMethod, rec.Path, rec.Proto,
+		rec.Status, rec.Bytes, orDash(rec.Referer), orDash(rec.UserAgent),
+		strings.Join(rec.Methods, ","), strings.Join(rec.Upstreams, ","), rec.DurationMs/1000)
+}
+
+// orDash returns a value, or "-" if it is empty.
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// accessUpstreams collects the upstreams that served a request.
+type accessUpstreams struct {
+	mu    sync.Mutex
+	names []string
+}
+
+type accessUpstreamsKey struct{}
+
+// noteUpstream records that an upstream served a call of the request, if the request
+// is being access logged.
+func noteUpstream(ctx context.Context, name string) {
+	u, ok := ctx.Value(accessUpstreamsKey{}).(*accessUpstreams)
+	if !ok {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, n := range u.names {
+		if n == name {
+			return
+		}
+	}
+	u.names = append(u.names, name)
+}
+
+// accessResponse captures the status and size of a response.
+type accessResponse struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (a *accessResponse) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (a *accessResponse) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (a *accessResponse) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// withAccessLog wraps a proxy handler to write an access record for every request, and
+// to stream it to live tail subscribers (see livetail.go).
+func withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		al := accessLog
+		if al == nil && !tailActive.Load() {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		var methods []string
+		if body, ok := peekBody(r); ok && checkJSONLimits(body) == nil {
+			for _, c := range parseCalls(body) {
+				methods = append(methods, c.Method)
+			}
+		}
+		upstreams := &accessUpstreams{}
+		r = r.WithContext(context.WithValue(r.Context(), accessUpstreamsKey{}, upstreams))
+		aw := &accessResponse{ResponseWriter: w}
+
+		next(aw, r)
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		upstreams.mu.Lock()
+		names := append([]string(nil), upstreams.names...)
+		upstreams.mu.Unlock()
+		rec := &accessRecord{
+			Time:       start,
+			Client:     clientIP(r),
+			HTTPMethod: r.Method,
+			Path:       r.URL.Path,
+			Proto:      r.Proto,
+			Status:     status,
+			Bytes:      aw.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Methods:    methods,
+			Upstreams:  names,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		if al != nil {
+			al.write(rec)
+		}
+		publishTail(rec)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAccessLogJSON tests that JSON access records hold the request, response, and upstreams
+func TestAccessLogJSON(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x2"}]`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	config = Config{DefaultURL: server.URL, DefaultName: "primary", AccessLog: &AccessLogConfig{Output: path}}
+	buildMethodURLMap()
+	if err := setupAccessLog(); err != nil {
+		t.Fatalf("Failed to set up access log: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		accessLog = nil
+	}()
+
+	// Test
+	body := `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.7:4000"
+	r.Header.Set("User-Agent", "test-client")
+	rec := httptest.NewRecorder()
+	withAccessLog(handleProxy)(rec, r)
+
+	// Verify
+	data, _ := os.ReadFile(path)
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Expected one JSON record, got %q: %v", data, err)
+	}
+	if record["client"] != "192.0.2.7" || record["http_method"] != "POST" || record["status"] != float64(200) {
+		t.Errorf("Unexpected record: %v", record)
+	}
+	if record["bytes"] != float64(rec.Body.Len()) {
+		t.Errorf("Expected %d bytes, got %v", rec.Body.Len(), record["bytes"])
+	}
+	if methods, _ := json.Marshal(record["methods"]); string(methods) != `["eth_blockNumber","eth_chainId"]` {
+		t.Errorf("Expected both methods, got %s", methods)
+	}
+	if upstreams, _ := json.Marshal(record["upstreams"]); string(upstreams) != `["primary"]` {
+		t.Errorf("Expected the upstream once, got %s", upstreams)
+	}
+	if record["user_agent"] != "test-client" {
+		t.Errorf("Expected the user agent, got %v", record["user_agent"])
+	}
+}
+
+// TestAccessLogCombinedUDP tests combined records sent to a UDP collector
+func TestAccessLogCombinedUDP(t *testing.T) {
+	// Setup
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer collector.Close()
+	config = Config{AccessLog: &AccessLogConfig{Output: "udp://" + collector.LocalAddr().String(), Format: "combined"}}
+	if err := setupAccessLog(); err != nil {
+		t.Fatalf("Failed to set up access log: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		accessLog = nil
+	}()
+
+	// Test
+	handler := withAccessLog(func(w http.ResponseWriter, r *http.Request) {
+		noteUpstream(r.Context(), "archive")
+		http.Error(w, "Rate limited", http.StatusTooManyRequests)
+	})
+	r := httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`))
+	r.RemoteAddr = "192.0.2.7:4000"
+	handler(httptest.NewRecorder(), r)
+
+	// Verify
+	buf := make([]byte, 2048)
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := collector.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Expected a datagram: %v", err)
+	}
+	line := string(buf[:n])
+	if !strings.HasPrefix(line, "192.0.2.7 - - [") || !strings.Contains(line, `"POST /rpc HTTP/1.1" 429 `) {
+		t.Errorf("Expected a combined log line, got %q", line)
+	}
+	if !strings.Contains(line, `methods="eth_call" upstreams="archive"`) {
+		t.Errorf("Expected the methods and upstreams, got %q", line)
+	}
+}
+
+// TestAccessLogConfigErrors tests that invalid access log configurations are rejected
+func TestAccessLogConfigErrors(t *testing.T) {
+	defer func() {
+		config = Config{}
+		accessLog = nil
+	}()
+	for _, ac := range []AccessLogConfig{
+		{},
+		{Output: "stdout", Format: "xml"},
+		{Output: "udp://"},
+	} {
+		config = Config{AccessLog: &ac}
+		if err := setupAccessLog(); err == nil {
+			t.Errorf("Expected an error for %+v", ac)
+		}
+	}
+}
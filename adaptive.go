@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Adaptive concurrency
+//
+// A static upstream_max_in_flight has to be tuned for every provider, and is wrong as
+// soon as the provider's capacity changes. The limit of each upstream can instead be
+// found by probing, in the manner of TCP congestion control (additive increase,
+// multiplicative decrease):
+//
+//	concurrency:
+//	  adaptive:
+//	    initial_limit: 20   # calls in flight to an upstream at first (default: 20)
+//	    min_limit: 2        # (default: 1)
+//	    max_limit: 500      # (default: 1000)
+//	    tolerance: 2        # latency over the baseline taken as congestion (default: 2)
+//	    backoff: 0.9        # factor applied to the limit on congestion (default: 0.9)
+//	    window: 1s          # how often the limit is adjusted (default: 1s)
+//
+// Every window, the average latency of the upstream's calls, from taking a slot to
+// closing the response, is compared to its baseline: the lowest average seen, drifting
+// slowly towards higher averages so that the baseline follows a provider that got
+// slower for good. When the average exceeds tolerance times the baseline, or a call
+// failed to connect, timed out, or got HTTP 429 or 5xx, the limit is multiplied by
+// backoff. Otherwise, if the calls in flight reached the limit during the window, it
+// grows by one. Windows with fewer than 5 calls leave the limit as it is.
+//
+// The adaptive limit replaces upstream_max_in_flight; calls beyond it queue and are
+// shed as with a static limit (see limits.go). When the limit shrinks, calls in flight
+// finish, and queued calls wait until the upstream is back under the new limit. The
+// limits are exported as jsonrpc_proxy_upstream_concurrency_limit.
+
+// AdaptiveConcurrencyConfig configures adaptive per-upstream concurrency limits.
+type AdaptiveConcurrencyConfig struct {
+	InitialLimit int           `yaml:"initial_limit"` // Calls in flight to an upstream at first (default: 20)
+	MinLimit     int           `yaml:"min_limit"`     // Lowest limit (default: 1)
+	MaxLimit     int           `yaml:"max_limit"`     // Highest limit (default: 1000)
+	Tolerance    float64       `yaml:"tolerance"`     // Latency over the baseline taken as congestion (default: 2)
+	Backoff      float64       `yaml:"backoff"`       // Factor applied to the limit on congestion (default: 0.9)
+	Window       time.Duration `yaml:"window"`        // How often the limit is adjusted (default: 1s)
+}
+
+// Adaptive concurrency defaults.
+const (
+	defaultAdaptiveInitial   = 20
+	defaultAdaptiveMin       = 1
+	defaultAdaptiveMax       = 1000
+	defaultAdaptiveTolerance = 2
+	defaultAdaptiveBackoff   = 0.9
+	defaultAdaptiveWindow    = time.Second
+	adaptiveMinSamples       = 5    // Calls in a window below which the limit is left alone
+	adaptiveBaselineDrift    = 0.05 // Share of a higher average the baseline moves towards per window
+)
+
+// adaptiveSettings are the adaptive limit settings in effect, or nil if limits are static.
+var adaptiveSettings *AdaptiveConcurrencyConfig
+
+var adaptiveRegistered sync.Once
+
+// setupAdaptiveConcurrency validates the adaptive limit settings and fills in defaults.
+//
+// Returns:
+//   - error: An error if a setting is out of range
+func setupAdaptiveConcurrency(ac *AdaptiveConcurrencyConfig) error {
+	adaptiveSettings = nil
+	if ac == nil {
+		return nil
+	}
+	s := *ac
+	if s.InitialLimit < 0 || s.MinLimit < 0 || s.MaxLimit < 0 || s.Window < 0 {
+		return fmt.Errorf("adaptive: limits and window cannot be negative")
+	}
+	if s.Tolerance != 0 && s.Tolerance <= 1 {
+		return fmt.Errorf("adaptive: tolerance must be above 1")
+	}
+	if s.Backoff != 0 && (s.Backoff <= 0 || s.Backoff >= 1) {
+		return fmt.Errorf("adaptive: backoff must be between 0 and 1")
+	}
+	if s.MinLimit == 0 {
+		s.MinLimit = defaultAdaptiveMin
+	}
+	if s.MaxLimit == 0 {
+		s.MaxLimit = defaultAdaptiveMax
+	}
+	if s.InitialLimit == 0 {
+		s.InitialLimit = min(max(defaultAdaptiveInitial, s.MinLimit), s.MaxLimit)
+	}
+	if s.MinLimit > s.MaxLimit || s.InitialLimit < s.MinLimit || s.InitialLimit > s.MaxLimit {
+		return fmt.Errorf("adaptive: initial_limit must be between min_limit and max_limit")
+	}
+	if s.Tolerance == 0 {
+		s.Tolerance = defaultAdaptiveTolerance
+	}
+	if s.Backoff == 0 {
+		s.Backoff = defaultAdaptiveBackoff
+	}
+	if s.Window == 0 {
+		s.Window = defaultAdaptiveWindow
+	}
+	adaptiveSettings = &s
+	adaptiveRegistered.Do(func() { registerMetric(adaptiveCollector{}) })
+	log.Printf("Adapting upstream concurrency between %d and %d calls, starting at %d", s.MinLimit, s.MaxLimit, s.InitialLimit)
+	return nil
+}
+
+// adaptiveLimit adjusts the size of an upstream's limiter from the calls it serves.
+type adaptiveLimit struct {
+	settings *AdaptiveConcurrencyConfig
+
+	mu          sync.Mutex
+	limit       float64
+	baseline    time.Duration // Lowest average latency, drifting up slowly
+	windowStart time.Time
+	calls       int
+	total       time.Duration // Latency of the window's calls
+	failures    int
+	saturated   bool // Whether the calls in flight reached the limit during the window
+}
+
+// newAdaptiveLimit creates the adaptive limit of an upstream.
+func newAdaptiveLimit(s *AdaptiveConcurrencyConfig) *adaptiveLimit {
+	return &adaptiveLimit{settings: s, limit: float64(s.InitialLimit), windowStart: time.Now()}
+}
+
+// sample records a call, adjusting the limiter at the end of each window.
+//
+// Parameters:
+//   - l: The upstream's limiter
+//   - latency: Time from taking the slot to releasing it
+func (a *adaptiveLimit) sample(l *limiter, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls++
+	a.total += latency
+	now := time.Now()
+	if now.Sub(a.windowStart) < a.settings.Window {
+		return
+	}
+	if a.calls >= adaptiveMinSamples {
+		a.adjust(l)
+	}
+	a.windowStart, a.calls, a.total, a.failures, a.saturated = now, 0, 0, 0, false
+}
+
+// adjust applies the window's verdict to the limit. Callers hold a.mu.
+func (a *adaptiveLimit) adjust(l *limiter) {
+	average := a.total / time.Duration(a.calls)
+	switch {
+	case a.baseline == 0 || average < a.baseline:
+		a.baseline = average
+	default:
+		a.baseline += time.Duration(float64(average-a.baseline) * adaptiveBaselineDrift)
+	}
+	s := a.settings
+	switch {
+	case a.failures > 0 || float64(average) > s.Tolerance*float64(a.baseline):
+		a.limit = math.Max(float64(s.MinLimit), a.limit*s.Backoff)
+	case a.saturated:
+		a.limit = math.Min(float64(s.MaxLimit), a.limit+1)
+	default:
+		return
+	}
+	l.resize(int(a.limit))
+}
+
+// noteInFlight records that the calls in flight reached the limit.
+func (a *adaptiveLimit) noteInFlight(inFlight int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if inFlight >= int(a.limit) {
+		a.saturated = true
+	}
+}
+
+// upstreamCongested records a call to an upstream that failed in a way suggesting
+// congestion: a connection error, a timeout, or HTTP 429 or 5xx.
+func upstreamCongested(url string) {
+	if adaptiveSettings == nil {
+		return
+	}
+	upstreamLimitsMu.Lock()
+	l, ok := upstreamLimiters[url]
+	upstreamLimitsMu.Unlock()
+	if !ok || l.adaptive == nil {
+		return
+	}
+	l.adaptive.mu.Lock()
+	l.adaptive.failures++
+	l.adaptive.mu.Unlock()
+}
+
+// adaptiveCollector exports the adaptive limits of the upstreams.
+type adaptiveCollector struct{}
+
+// write implements metricsCollector.
+func (adaptiveCollector) write(w io.Writer, openMetrics bool) {
+	names := probeTargets()
+	upstreamLimitsMu.Lock()
+	defer upstreamLimitsMu.Unlock()
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_upstream_concurrency_limit Adaptive limit of the calls in flight to an upstream.\n# TYPE jsonrpc_proxy_upstream_concurrency_limit gauge\n")
+	for _, url := range sortedKeys(upstreamLimiters) {
+		l := upstreamLimiters[url]
+		if l.adaptive == nil {
+			continue
+		}
+		l.mu.Lock()
+		size := l.size
+		l.mu.Unlock()
+		fmt.Fprintf(w, "jsonrpc_proxy_upstream_concurrency_limit%s %d\n", encodeLabels([]string{"upstream"}, []string{upstreamLabel(Upstream{Name: names[url], URL: url})}), size)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveLimit tests that the limit grows while the upstream keeps up and backs
+// off on latency and failures.
+func TestAdaptiveLimit(t *testing.T) {
+	// Setup
+	settings := &AdaptiveConcurrencyConfig{InitialLimit: 10, MinLimit: 2, MaxLimit: 11, Tolerance: 2, Backoff: 0.5, Window: time.Hour}
+	a := newAdaptiveLimit(settings)
+	l := newLimiter(10, &ConcurrencyConfig{})
+	l.adaptive = a
+	window := func(latency time.Duration, saturated bool, failures int) int {
+		a.mu.Lock()
+		a.calls, a.total, a.failures, a.saturated = adaptiveMinSamples, latency*adaptiveMinSamples, failures, saturated
+		a.adjust(l)
+		a.calls, a.total, a.failures, a.saturated = 0, 0, 0, false
+		a.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.size
+	}
+
+	// Test
+	grown := window(10*time.Millisecond, true, 0)
+	capped := window(10*time.Millisecond, true, 0)
+	idle := window(10*time.Millisecond, false, 0)
+	slow := window(50*time.Millisecond, true, 0)
+	failed := window(10*time.Millisecond, true, 1)
+	floored := window(10*time.Millisecond, false, 3)
+
+	// Verify
+	expected := []struct {
+		name     string
+		got      int
+		expected int
+	}{
+		{"saturated window", grown, 11},
+		{"window at max_limit", capped, 11},
+		{"window below the limit", idle, 11},
+		{"window over the latency tolerance", slow, 5},
+		{"window with a failure", failed, 2},
+		{"window at min_limit", floored, 2},
+	}
+	for _, e := range expected {
+		if e.got != e.expected {
+			t.Errorf("Expected limit %d after the %s, got %d", e.expected, e.name, e.got)
+		}
+	}
+}
+
+// TestLimiterResize tests that a grown limiter admits waiters and a shrunk one gives up
+// slots as they are released.
+func TestLimiterResize(t *testing.T) {
+	// Setup
+	l := newLimiter(2, &ConcurrencyConfig{QueueTimeout: time.Second})
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(context.Background(), priorityNormal); err != nil {
+			t.Fatalf("Expected a free slot, got %v", err)
+		}
+	}
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.acquire(context.Background(), priorityNormal) }()
+	waitQueued(l, 1)
+
+	// Test: growing admits the waiter
+	l.resize(3)
+	if err := <-acquired; err != nil {
+		t.Fatalf("Expected the waiter to get a new slot, got %v", err)
+	}
+
+	// Test: shrinking holds new callers back until the slots in use fit
+	l.resize(1)
+	go func() { acquired <- l.acquire(context.Background(), priorityNormal) }()
+	waitQueued(l, 1)
+	l.release()
+	l.release()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Expected the waiter to wait while 1 slot of 1 is in use, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release()
+
+	// Verify
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the waiter to get the slot once the limiter is under its size, got %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight != 1 {
+		t.Errorf("Expected 1 slot in use, got %d", l.inFlight)
+	}
+}
+
+// TestAdaptiveConcurrencyConfig tests the validation of the adaptive settings.
+func TestAdaptiveConcurrencyConfig(t *testing.T) {
+	defer func() { adaptiveSettings = nil }()
+	for name, ac := range map[string]*AdaptiveConcurrencyConfig{
+		"tolerance of 1":           {Tolerance: 1},
+		"backoff of 1":             {Backoff: 1},
+		"initial_limit over max":   {InitialLimit: 50, MaxLimit: 10},
+		"min_limit over max_limit": {MinLimit: 20, MaxLimit: 10},
+		"negative window":          {Window: -time.Second},
+	} {
+		if err := setupAdaptiveConcurrency(ac); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if err := setupAdaptiveConcurrency(&AdaptiveConcurrencyConfig{MaxLimit: 10}); err != nil || adaptiveSettings.InitialLimit != 10 {
+		t.Errorf("Expected the initial limit to be capped at max_limit, got %+v (%v)", adaptiveSettings, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// adminTokenHeader carries the admin token on proxied requests using operator headers.
+const adminTokenHeader = "X-Admin-Token"
+
+// Admin API
+//
+// Operational endpoints live under /admin/ and require the configured bearer token.
+// They are served on the proxy port by default, or on a separate listener (such as a
+// loopback address) when admin.listen is set. Features register their endpoints with
+// registerAdminHandler during setup; the API is only mounted when admin is enabled.
+
+// AdminConfig configures the admin API.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"` // Serve the admin API
+	Token   string `yaml:"token"`   // Bearer token required on every admin request
+	Listen  string `yaml:"listen"`  // Separate listen address (default: the proxy port)
+}
+
+// adminHandlers maps admin paths to their handlers.
+var adminHandlers = make(map[string]http.HandlerFunc)
+
+// registerAdminHandler adds an admin endpoint.
+//
+// Parameters:
+//   - path: The endpoint path, starting with /admin/
+//   - handler: The endpoint handler
+func registerAdminHandler(path string, handler http.HandlerFunc) {
+	adminHandlers[path] = handler
+}
+
+// setupAdmin validates the admin configuration.
+//
+// Returns:
+//   - error: An error if the admin API is enabled without a token
+func setupAdmin() error {
+	if config.Admin == nil || !config.Admin.Enabled {
+		return nil
+	}
+	if config.Admin.Token == "" {
+		return fmt.Errorf("admin.token is required when the admin API is enabled")
+	}
+	return nil
+}
+
+// adminHandler returns the handler serving the registered admin endpoints.
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	for path, handler := range adminHandlers {
+		mux.HandleFunc(path, handler)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized reports whether a request carries the admin token.
+func adminAuthorized(r *http.Request) bool {
+	if config.Admin == nil || config.Admin.Token == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])), []byte(config.Admin.Token)) == 1
+}
+
+// operatorAuthorized reports whether a proxied request carries the admin token, in
+// X-Admin-Token or as the Authorization bearer token, and may use the operator
+// headers (see debugroute.go and forceupstream.go). X-Admin-Token leaves the
+// Authorization header to client authentication.
+func operatorAuthorized(r *http.Request) bool {
+	if config.Admin == nil || !config.Admin.Enabled || config.Admin.Token == "" {
+		return false
+	}
+	if token := r.Header.Get(adminTokenHeader); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(config.Admin.Token)) == 1
+	}
+	return adminAuthorized(r)
+}
+
+// startAdmin mounts the admin API on the proxy's handler, or starts its own listener.
+func startAdmin() {
+	if config.Admin == nil || !config.Admin.Enabled {
+		return
+	}
+	if config.Admin.Listen == "" {
+		http.Handle("/admin/", adminHandler())
+		log.Printf("Admin API enabled on the proxy port under /admin/")
+		return
+	}
+	go func() {
+		log.Printf("Starting admin API on %s", config.Admin.Listen)
+		if err := http.ListenAndServe(config.Admin.Listen, adminHandler()); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+	}()
+}
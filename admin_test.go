@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAdminAuth tests that admin endpoints require the bearer token
+func TestAdminAuth(t *testing.T) {
+	// Setup
+	config = Config{Admin: &AdminConfig{Enabled: true, Token: "s3cret"}}
+	defer func() { config = Config{} }()
+	registerAdminHandler("/admin/test", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	defer delete(adminHandlers, "/admin/test")
+	handler := adminHandler()
+
+	tests := []struct {
+		name     string
+		auth     string
+		expected int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			r := httptest.NewRequest("GET", "/admin/test", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			// Verify
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	// Verify an enabled admin API requires a token
+	config.Admin.Token = ""
+	if err := setupAdmin(); err == nil {
+		t.Errorf("Expected an error for a missing token")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client authentication
+//
+// By default the proxy serves anyone who can reach it. The basic mode protects it with
+// user names and passwords (see basicauth.go), and the hmac mode with signed requests
+// (see hmacauth.go). With auth.mode set to introspection,
+// every request must carry a bearer token (or an API key, read like client API keys),
+// which is validated against an OAuth2 token introspection endpoint (RFC 7662). This
+// suits gateways that issue opaque tokens rather than JWTs:
+//
+//	auth:
+//	  mode: introspection
+//	  introspection:
+//	    url: https://gateway.internal/oauth2/introspect
+//	    client_id: jsonrpc-proxy
+//	    client_secret_env: INTROSPECTION_SECRET
+//	    cache_ttl: 1m
+//	  scopes:
+//	    rpc:read: ["eth_*", "net_version"]
+//	    rpc:write: ["eth_sendRawTransaction"]
+//
+// Introspection results are cached by token for cache_ttl, but never past the token's
+// expiry; rejected tokens are cached for negative_cache_ttl. When scopes is set, a
+// token may only call the methods matched by its scopes (names, or prefixes ending in
+// *); a request containing any other call is rejected with HTTP 403. Without scopes,
+// every active token may call every method. Missing and inactive tokens get HTTP 401,
+// and requests are rejected with HTTP 503 while the introspection endpoint fails.
+
+// AuthConfig configures client authentication.
+type AuthConfig struct {
+	Mode          string               `yaml:"mode"`          // "introspection", "basic", or "hmac"
+	Introspection *IntrospectionConfig `yaml:"introspection"` // Token introspection endpoint (for the introspection mode)
+	Scopes        map[string][]string  `yaml:"scopes"`        // Methods allowed by each scope (default: all methods for any active token)
+	Users         map[string]string    `yaml:"users"`         // Bcrypt password hashes by user name (for the basic mode, see basicauth.go)
+	Realm         string               `yaml:"realm"`         // Realm announced to basic auth clients (default: jsonrpc-proxy)
+	Keys          map[string]string    `yaml:"keys"`          // Signing secrets by key ID (for the hmac mode, see hmacauth.go)
+	Replay        *ReplayConfig        `yaml:"replay"`        // Replay protection of signed requests (see replay.go)
+}
+
+// IntrospectionConfig configures the OAuth2 introspection endpoint.
+type IntrospectionConfig struct {
+	URL              string        `yaml:"url"`                // Introspection endpoint
+	ClientID         string        `yaml:"client_id"`          // Client ID the proxy authenticates with (optional)
+	ClientSecret     string        `yaml:"client_secret"`      // Client secret (optional)
+	ClientSecretEnv  string        `yaml:"client_secret_env"`  // Environment variable holding the client secret (optional)
+	CacheTTL         time.Duration `yaml:"cache_ttl"`          // How long active tokens are cached (default: 1m)
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl"` // How long rejected tokens are cached (default: 10s)
+	Timeout          time.Duration `yaml:"timeout"`            // Timeout of introspection requests (default: 5s)
+}
+
+// maxCachedTokens bounds the introspection cache; expired entries are swept beyond it.
+const maxCachedTokens = 10000
+
+// tokenInfo is the cached introspection result of a token.
+type tokenInfo struct {
+	active  bool
+	scopes  []string
+	subject string
+	expires time.Time // When the cache entry expires
+}
+
+// introspector validates client tokens, or is nil when authentication is off.
+var introspector *tokenIntrospector
+
+// tokenIntrospector validates tokens against an introspection endpoint.
+type tokenIntrospector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	cacheTTL     time.Duration
+	negativeTTL  time.Duration
+	client       *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]tokenInfo // By token hash, so tokens are not kept in memory
+}
+
+// setupAuth configures client authentication.
+//
+// Returns:
+//   - error: An error if the configuration is invalid
+func setupAuth() error {
+	introspector, basicAuth, hmacAuth = nil, nil, nil
+	ac := config.Auth
+	if ac == nil || ac.Mode == "" {
+		return nil
+	}
+	switch ac.Mode {
+	case "introspection":
+		return setupIntrospection(ac)
+	case "basic":
+		return setupBasicAuth(ac)
+	case "hmac":
+		return setupHMACAuth(ac)
+	}
+	return fmt.Errorf("unknown mode %q (expected introspection, basic, or hmac)", ac.Mode)
+}
+
+// setupIntrospection configures token introspection.
+func setupIntrospection(ac *AuthConfig) error {
+	ic := ac.Introspection
+	if ic == nil || ic.URL == "" {
+		return fmt.Errorf("introspection.url is required")
+	}
+	if _, err := url.Parse(ic.URL); err != nil {
+		return fmt.Errorf("introspection.url: %w", err)
+	}
+	for scope, methods := range ac.Scopes {
+		if len(methods) == 0 {
+			return fmt.Errorf("scopes.%s: no methods", scope)
+		}
+	}
+
+	ti := &tokenIntrospector{
+		url:          ic.URL,
+		clientID:     ic.ClientID,
+		clientSecret: ic.ClientSecret,
+		cacheTTL:     time.Minute,
+		negativeTTL:  10 * time.Second,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		cache:        make(map[[sha256.Size]byte]tokenInfo),
+	}
+	if ic.ClientSecretEnv != "" {
+		ti.clientSecret = os.Getenv(ic.ClientSecretEnv)
+	}
+	if ic.CacheTTL > 0 {
+		ti.cacheTTL = ic.CacheTTL
+	}
+	if ic.NegativeCacheTTL > 0 {
+		ti.negativeTTL = ic.NegativeCacheTTL
+	}
+	if ic.Timeout > 0 {
+		ti.client.Timeout = ic.Timeout
+	}
+	introspector = ti
+	log.Printf("Authenticating clients with token introspection at %s", displayURL(ic.URL))
+	return nil
+}
+
+// introspect returns the information of a token, from the cache or the endpoint.
+func (ti *tokenIntrospector) introspect(ctx context.Context, token string) (tokenInfo, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	ti.mu.Lock()
+	info, ok := ti.cache[key]
+	ti.mu.Unlock()
+	if ok && now.Before(info.expires) {
+		return info, nil
+	}
+
+	info, err := ti.query(ctx, token)
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	ti.mu.Lock()
+	if len(ti.cache) >= maxCachedTokens {
+		for k, v := range ti.cache {
+			if !now.Before(v.expires) {
+				delete(ti.cache, k)
+			}
+		}
+	}
+	if len(ti.cache) < maxCachedTokens {
+		ti.cache[key] = info
+	}
+	ti.mu.Unlock()
+	return info, nil
+}
+
+// query asks the introspection endpoint about a token.
+func (ti *tokenIntrospector) query(ctx context.Context, token string) (tokenInfo, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ti.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if ti.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(ti.clientID), url.QueryEscape(ti.clientSecret))
+	}
+	resp, err := ti.client.Do(req)
+	if err != nil {
+		return tokenInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return tokenInfo{}, fmt.Errorf("introspection endpoint returned HTTP %d", resp.StatusCode)
+	}
+	var result struct {
+		Active bool   `json:"active"`
+		Scope  string `json:"scope"`
+		Exp    int64  `json:"exp"`
+		Sub    string `json:"sub"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return tokenInfo{}, fmt.Errorf("invalid introspection response: %w", err)
+	}
+
+	now := time.Now()
+	if !result.Active {
+		return tokenInfo{expires: now.Add(ti.negativeTTL)}, nil
+	}
+	info := tokenInfo{active: true, scopes: strings.Fields(result.Scope), subject: result.Sub, expires: now.Add(ti.cacheTTL)}
+	if result.Exp > 0 {
+		exp := time.Unix(result.Exp, 0)
+		if !exp.After(now) {
+			return tokenInfo{expires: now.Add(ti.negativeTTL)}, nil
+		}
+		if exp.Before(info.expires) {
+			info.expires = exp
+		}
+	}
+	return info, nil
+}
+
+// scopesAllow reports whether any of a token's scopes allows a method.
+func scopesAllow(scopes []string, method string) bool {
+	if len(config.Auth.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		for _, pattern := range config.Auth.Scopes[scope] {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+				if strings.HasPrefix(method, prefix) {
+					return true
+				}
+			} else if pattern == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withAuth wraps the proxy handler with client authentication.
+//
+// Parameters:
+//   - next: The proxy handler
+//
+// Returns:
+//   - http.HandlerFunc: The handler rejecting unauthenticated and unauthorized requests
+func withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ba := basicAuth; ba != nil {
+			if ba.check(w, r) {
+				user, _, _ := r.BasicAuth()
+				next(w, withAuthenticatedClient(r, user))
+			}
+			return
+		}
+		if ha := hmacAuth; ha != nil {
+			if ha.check(w, r) {
+				next(w, withAuthenticatedClient(r, r.Header.Get(hmacKeyIDHeader)))
+			}
+			return
+		}
+		ti := introspector
+		if ti == nil {
+			next(w, r)
+			return
+		}
+		token := clientAPIKey(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		info, err := ti.introspect(r.Context(), token)
+		if err != nil {
+			logError("router", "Token introspection failed: %v", err)
+			http.Error(w, "Authorization unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !info.active {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		if len(config.Auth.Scopes) > 0 && r.Method == http.MethodPost {
+			body, ok := peekBody(r)
+			if !ok {
+				http.Error(w, "Error reading request body", http.StatusBadRequest)
+				return
+			}
+			for _, call := range parseCalls(body) {
+				if !scopesAllow(info.scopes, call.Method) {
+					logInfo("router", "Rejecting method '%s' for %s: not allowed by scopes %v", call.Method, info.subject, info.scopes)
+					writeForbidden(w, body, call.Method)
+					return
+				}
+			}
+		}
+		next(w, withAuthenticatedClient(r, token))
+	}
+}
+
+// writeForbidden rejects a request with HTTP 403 and a JSON-RPC error for every call.
+func writeForbidden(w http.ResponseWriter, body []byte, method string) {
+	rpcErr := &JSONRPCError{Code: -32003, Message: fmt.Sprintf("method %s not allowed by token scopes", method)}
+	data := callErrorsBody(body, parseCalls(body), rpcErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(data)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestIntrospectionAuth tests authenticating clients and checking their scopes
+func TestIntrospectionAuth(t *testing.T) {
+	// Setup: an introspection endpoint knowing a read-only token
+	var queries atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		if user, pass, _ := r.BasicAuth(); user != "proxy" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("token") == "reader" {
+			w.Write([]byte(`{"active":true,"scope":"rpc:read","sub":"alice","exp":` + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`))
+			return
+		}
+		w.Write([]byte(`{"active":false}`))
+	}))
+	defer endpoint.Close()
+
+	t.Setenv("INTROSPECTION_SECRET", "s3cret")
+	config = Config{Auth: &AuthConfig{
+		Mode:          "introspection",
+		Introspection: &IntrospectionConfig{URL: endpoint.URL, ClientID: "proxy", ClientSecretEnv: "INTROSPECTION_SECRET"},
+		Scopes:        map[string][]string{"rpc:read": {"eth_get*", "eth_chainId"}, "rpc:write": {"eth_sendRawTransaction"}},
+	}}
+	if err := setupAuth(); err != nil {
+		t.Fatalf("Failed to set up auth: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		introspector = nil
+	}()
+	handler := withAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	})
+	request := func(token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// Test
+	missing := request("", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+	invalid := request("forged", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+	allowed := request("reader", `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance"}`)
+	cached := request("reader", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+	forbidden := request("reader", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction"}]`)
+
+	// Verify
+	if missing.Code != http.StatusUnauthorized || invalid.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for missing and inactive tokens, got %d and %d", missing.Code, invalid.Code)
+	}
+	if allowed.Code != http.StatusOK || cached.Code != http.StatusOK {
+		t.Errorf("Expected allowed methods to be served, got %d and %d", allowed.Code, cached.Code)
+	}
+	if forbidden.Code != http.StatusForbidden || strings.Count(forbidden.Body.String(), `"code":-32003`) != 2 {
+		t.Errorf("Expected every call of the batch to be rejected, got %d %s", forbidden.Code, forbidden.Body.String())
+	}
+	if n := queries.Load(); n != 2 {
+		t.Errorf("Expected each token to be introspected once, got %d queries", n)
+	}
+}
+
+// TestIntrospectionUnavailable tests that requests are rejected while introspection fails
+func TestIntrospectionUnavailable(t *testing.T) {
+	// Setup
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer endpoint.Close()
+	config = Config{Auth: &AuthConfig{Mode: "introspection", Introspection: &IntrospectionConfig{URL: endpoint.URL}}}
+	if err := setupAuth(); err != nil {
+		t.Fatalf("Failed to set up auth: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		introspector = nil
+	}()
+
+	// Test
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	r.Header.Set("X-API-Key", "some-token")
+	w := httptest.NewRecorder()
+	withAuth(handleProxy)(w, r)
+
+	// Verify
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", w.Code)
+	}
+}
+
+// TestAuthConfigErrors tests that invalid auth configurations are rejected
+func TestAuthConfigErrors(t *testing.T) {
+	defer func() { config = Config{} }()
+	testCases := []struct {
+		name     string
+		auth     AuthConfig
+		expected string
+	}{
+		{"Unknown mode", AuthConfig{Mode: "jwt"}, `unknown mode "jwt"`},
+		{"Missing URL", AuthConfig{Mode: "introspection"}, "introspection.url is required"},
+		{"Empty scope", AuthConfig{Mode: "introspection", Introspection: &IntrospectionConfig{URL: "http://idp"}, Scopes: map[string][]string{"rpc:read": nil}}, "scopes.rpc:read: no methods"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{Auth: &tc.auth}
+
+			// Test
+			err := setupAuth()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
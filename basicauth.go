@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Basic auth
+//
+// Small deployments that need a quick access barrier can protect the listener with
+// HTTP basic auth instead of a key-management system. Passwords are stored as bcrypt
+// hashes, which `jsonrpc-proxy hash-password` prints for a password read from standard
+// input:
+//
+//	auth:
+//	  mode: basic
+//	  realm: rpc          # default: jsonrpc-proxy
+//	  users:
+//	    alice: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+//
+// bcrypt is deliberately slow, so a successful check is remembered (as a digest of the
+// user name, password, and hash) and later requests with the same credentials skip
+// it. Requests without valid credentials get HTTP 401 with a WWW-Authenticate
+// challenge. Basic auth sends passwords with every request: serve the proxy over TLS,
+// or behind a TLS-terminating load balancer.
+
+// basicAuth checks client credentials, or is nil when the basic mode is off.
+var basicAuth *basicAuthenticator
+
+// basicAuthenticator checks basic auth credentials against bcrypt hashes.
+type basicAuthenticator struct {
+	users map[string][]byte // Bcrypt hashes by user name
+	realm string
+	dummy []byte // Hash compared for unknown users, so they take as long as wrong passwords
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]bool // Digests of credentials that passed bcrypt
+}
+
+// setupBasicAuth configures basic auth.
+func setupBasicAuth(ac *AuthConfig) error {
+	if len(ac.Users) == 0 {
+		return fmt.Errorf("users is required for the basic mode")
+	}
+	ba := &basicAuthenticator{users: make(map[string][]byte), realm: "jsonrpc-proxy", verified: make(map[[sha256.Size]byte]bool)}
+	if ac.Realm != "" {
+		ba.realm = ac.Realm
+	}
+	for _, user := range sortedKeys(ac.Users) {
+		hash := ac.Users[user]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("users.%s: not a bcrypt hash: %w", user, err)
+		}
+		ba.users[user] = []byte(hash)
+	}
+	ba.dummy, _ = bcrypt.GenerateFromPassword([]byte("jsonrpc-proxy"), bcrypt.DefaultCost)
+	basicAuth = ba
+	log.Printf("Authenticating clients with basic auth (%d user(s))", len(ba.users))
+	return nil
+}
+
+// check verifies the credentials of a request, answering it with HTTP 401 if they are
+// missing or wrong.
+//
+// Returns:
+//   - bool: Whether the request may proceed
+func (ba *basicAuthenticator) check(w http.ResponseWriter, r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if ok && ba.valid(user, password) {
+		return true
+	}
+	if ok {
+		logInfo("router", "Rejecting request from %s: invalid credentials for user %q", clientIP(r), user)
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, ba.realm))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// valid reports whether a password matches the user's hash.
+func (ba *basicAuthenticator) valid(user, password string) bool {
+	hash, ok := ba.users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(ba.dummy, []byte(password))
+		return false
+	}
+	digest := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + string(hash)))
+	ba.mu.Lock()
+	verified := ba.verified[digest]
+	ba.mu.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	ba.mu.Lock()
+	if len(ba.verified) < maxCachedTokens {
+		ba.verified[digest] = true
+	}
+	ba.mu.Unlock()
+	return true
+}
+
+// runHashPassword implements the hash-password subcommand, printing the bcrypt hash of
+// a password read from standard input.
+//
+// Parameters:
+//   - args: The subcommand's arguments
+//   - stdin: Where the password is read from
+//   - stdout: Where the hash is written
+//   - stderr: Where usage and errors are written
+//
+// Returns:
+//   - error: An error if the arguments or the password are invalid
+func runHashPassword(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s (the password is read from standard input)", strings.Join(fs.Args(), " "))
+	}
+
+	password, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(hash))
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestBasicAuth tests protecting the proxy with user names and bcrypt password hashes
+func TestBasicAuth(t *testing.T) {
+	// Setup
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	config = Config{Auth: &AuthConfig{Mode: "basic", Realm: "rpc", Users: map[string]string{"alice": string(hash)}}}
+	if err := setupAuth(); err != nil {
+		t.Fatalf("Failed to set up auth: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		setupAuth()
+	}()
+	var limitKey string
+	handler := withAuth(func(w http.ResponseWriter, r *http.Request) {
+		limitKey = clientFromRequest(r).limitKey()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	})
+	request := func(user, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// Test
+	missing := request("", "")
+	wrong := request("alice", "battery staple")
+	unknown := request("bob", "correct horse")
+	allowed := request("alice", "correct horse")
+	cached := request("alice", "correct horse")
+
+	// Verify
+	for name, w := range map[string]*httptest.ResponseRecorder{"missing": missing, "wrong": wrong, "unknown": unknown} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s credentials, got %d", name, w.Code)
+		}
+	}
+	if challenge := missing.Header().Get("WWW-Authenticate"); challenge != `Basic realm="rpc", charset="UTF-8"` {
+		t.Errorf("Expected a basic challenge, got %q", challenge)
+	}
+	if allowed.Code != http.StatusOK || cached.Code != http.StatusOK {
+		t.Errorf("Expected valid credentials to be served, got %d and %d", allowed.Code, cached.Code)
+	}
+	if limitKey != "auth:alice" {
+		t.Errorf("Expected limits to be charged to the user, got %s", limitKey)
+	}
+	if len(basicAuth.verified) != 1 {
+		t.Errorf("Expected the successful check to be remembered, got %d entries", len(basicAuth.verified))
+	}
+}
+
+// TestBasicAuthConfigErrors tests that invalid basic auth configurations are rejected
+func TestBasicAuthConfigErrors(t *testing.T) {
+	defer func() { config = Config{} }()
+	testCases := []struct {
+		name     string
+		users    map[string]string
+		expected string
+	}{
+		{"No users", nil, "users is required"},
+		{"Plain password", map[string]string{"alice": "hunter2"}, "users.alice: not a bcrypt hash"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{Auth: &AuthConfig{Mode: "basic", Users: tc.users}}
+
+			// Test
+			err := setupAuth()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestHashPassword tests the hash-password subcommand
+func TestHashPassword(t *testing.T) {
+	// Setup
+	var stdout, stderr bytes.Buffer
+
+	// Test
+	err := runHashPassword([]string{"-cost", "4"}, strings.NewReader("correct horse\n"), &stdout, &stderr)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("hash-password failed: %v", err)
+	}
+	hash := strings.TrimSpace(stdout.String())
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("correct horse")) != nil {
+		t.Errorf("Expected a hash of the password, got %q", hash)
+	}
+	if err := runHashPassword(nil, strings.NewReader(""), &stdout, &stderr); err == nil {
+		t.Errorf("Expected an error for an empty password")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batch splitting
+//
+// Providers cap the calls of a batch (Infura at 100, others at 1000 or less) and fail
+// the whole batch beyond it. The calls of a batch sent to the same upstream are grouped
+// into one request, so a client batch within its own limits can still exceed the
+// provider's. The batch sizes of upstreams are configured by upstream name or URL:
+//
+//	max_batch_sizes:
+//	  infura: 100
+//	  https://rpc.ankr.com/eth: 1000
+//
+// Larger groups are split into batches of at most that many calls, sent one after the
+// other, and their responses are merged into the client's response. A batch that
+// fails leaves out its calls only, as a failed group does. Upstreams without a limit
+// receive their group whole.
+//
+// By default the batches of a client batch are sent one at a time, across upstreams as
+// well, which goes easiest on provider rate limits. Sending them concurrently lowers
+// the latency of large and mixed batches:
+//
+//	batch_concurrency:
+//	  max: 8          # batches sent at once across upstreams (default: 1)
+//	  upstreams:
+//	    infura: 2     # batches sent at once to one upstream (default: max)
+//
+// The batches of an upstream are started in order, and the responses are merged in the
+// same order whatever order the batches finish in.
+//
+// A slow upstream need not hold the whole client batch hostage. With a batch timeout,
+// the calls of a batch its upstream has not answered in time are answered with a
+// -32002 "request timed out" error, and the rest of the client batch is served:
+//
+//	batch_timeout:
+//	  default: 10s    # (default: none)
+//	  upstreams:
+//	    archive: 30s  # by upstream name or URL
+
+// BatchConcurrencyConfig configures how many batches of a client batch are sent at once.
+type BatchConcurrencyConfig struct {
+	Max       int            `yaml:"max"`       // Batches sent at once across upstreams (default: 1)
+	Upstreams map[string]int `yaml:"upstreams"` // Batches sent at once to an upstream, by upstream name or URL (default: max)
+}
+
+// BatchTimeoutConfig configures how long the upstream batches of a client batch may take.
+type BatchTimeoutConfig struct {
+	Default   time.Duration            `yaml:"default"`   // Timeout of upstreams not listed (default: none)
+	Upstreams map[string]time.Duration `yaml:"upstreams"` // Timeouts by upstream name or URL
+}
+
+// setupBatchSplitting validates the batch size limits and concurrency of upstreams.
+//
+// Returns:
+//   - error: An error if a limit is not positive
+func setupBatchSplitting() error {
+	if bc := config.BatchConcurrency; bc != nil {
+		if bc.Max < 0 {
+			return fmt.Errorf("batch_concurrency.max cannot be negative")
+		}
+		for upstream, n := range bc.Upstreams {
+			if n <= 0 {
+				return fmt.Errorf("batch_concurrency.upstreams: %s: concurrency must be positive", upstreamKeyLabel(upstream))
+			}
+		}
+		log.Printf("Sending up to %d batches at once", batchConcurrency("", ""))
+	}
+	if bt := config.BatchTimeout; bt != nil {
+		if bt.Default < 0 {
+			return fmt.Errorf("batch_timeout.default cannot be negative")
+		}
+		for upstream, d := range bt.Upstreams {
+			if d <= 0 {
+				return fmt.Errorf("batch_timeout.upstreams: %s: timeout must be positive", upstreamKeyLabel(upstream))
+			}
+		}
+	}
+	for _, upstream := range sortedKeys(config.MaxBatchSizes) {
+		size, label := config.MaxBatchSizes[upstream], upstreamKeyLabel(upstream)
+		if size <= 0 {
+			return fmt.Errorf("%s: batch size must be positive", label)
+		}
+		log.Printf("Splitting batches to %s into batches of at most %d calls", label, size)
+	}
+	return nil
+}
+
+// maxBatchSize returns the most calls an upstream accepts in a batch.
+//
+// Parameters:
+//   - url: The upstream URL
+//   - name: The upstream name
+//
+// Returns:
+//   - int: The limit, or math.MaxInt if the upstream has none
+func maxBatchSize(url, name string) int {
+	if size, ok := config.MaxBatchSizes[name]; ok && size > 0 {
+		return size
+	}
+	if size, ok := config.MaxBatchSizes[url]; ok && size > 0 {
+		return size
+	}
+	return math.MaxInt
+}
+
+// upstreamKeyLabel returns an upstream name or URL keying a setting, as shown in logs
+// and errors.
+func upstreamKeyLabel(upstream string) string {
+	if strings.Contains(upstream, "://") {
+		return displayURL(upstream)
+	}
+	return upstream
+}
+
+// batchConcurrency returns how many batches may be sent at once to an upstream, or
+// across upstreams for an empty url and name.
+func batchConcurrency(url, name string) int {
+	bc := config.BatchConcurrency
+	if bc == nil {
+		return 1
+	}
+	if n, ok := bc.Upstreams[name]; ok && n > 0 && url != "" {
+		return n
+	}
+	if n, ok := bc.Upstreams[url]; ok && n > 0 && url != "" {
+		return n
+	}
+	return max(bc.Max, 1)
+}
+
+// batchTimeout returns how long an upstream may take to answer a batch.
+//
+// Returns:
+//   - time.Duration: The timeout, or 0 for none
+func batchTimeout(url, name string) time.Duration {
+	bt := config.BatchTimeout
+	if bt == nil {
+		return 0
+	}
+	if d, ok := bt.Upstreams[name]; ok {
+		return d
+	}
+	if d, ok := bt.Upstreams[url]; ok {
+		return d
+	}
+	return bt.Default
+}
+
+// timeoutResponses answers calls with a timeout error.
+//
+// Parameters:
+//   - requests: The calls of a batch that timed out
+//   - name: The upstream name
+//   - timeout: The timeout exceeded
+//
+// Returns:
+//   - []json.RawMessage: A -32002 error response for each call
+func timeoutResponses(requests []json.RawMessage, name string, timeout time.Duration) []json.RawMessage {
+	responses := make([]json.RawMessage, len(requests))
+	for i, raw := range requests {
+		responses[i], _ = json.Marshal(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      responseID(raw),
+			Error:   &JSONRPCError{Code: -32002, Message: "request timed out", Data: fmt.Sprintf("%s did not answer within %v", name, timeout)},
+		})
+	}
+	return responses
+}
+
+// fanOutBatches runs the sends of the batches of a client batch, starting each
+// upstream's in order and keeping within the batch concurrency settings. Sends not
+// started when the client disconnects are skipped.
+//
+// Parameters:
+//   - ctx: The client request's context
+//   - sends: The sends of each upstream's batches, by upstream URL
+//   - names: The upstream names by URL
+func fanOutBatches(ctx context.Context, sends map[string][]func(), names map[string]string) {
+	overall := make(chan struct{}, batchConcurrency("", ""))
+	var wg sync.WaitGroup
+	for url, upstreamSends := range sends {
+		queue := make(chan func(), len(upstreamSends))
+		for _, send := range upstreamSends {
+			queue <- send
+		}
+		close(queue)
+		for range min(batchConcurrency(url, names[url]), len(upstreamSends)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for send := range queue {
+					overall <- struct{}{}
+					if ctx.Err() == nil {
+						send()
+					}
+					<-overall
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
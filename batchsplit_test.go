@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBatchSplitting tests splitting batches beyond an upstream's limit and merging the responses
+func TestBatchSplitting(t *testing.T) {
+	// Setup
+	var mu sync.Mutex
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var requests []JSONRPCRequest
+		json.Unmarshal(body, &requests)
+		mu.Lock()
+		sizes = append(sizes, len(requests))
+		mu.Unlock()
+		if len(requests) > 2 {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch too large"}}`))
+			return
+		}
+		responses := make([]string, len(requests))
+		for i, req := range requests {
+			responses[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":"0x%v"}`, req.ID, req.ID)
+		}
+		w.Write([]byte("[" + strings.Join(responses, ",") + "]"))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, DefaultName: "infura", MaxBatchSizes: map[string]int{"infura": 2}}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	calls := make([]string, 5)
+	for i := range calls {
+		calls[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_blockNumber","params":[]}`, i+1)
+	}
+
+	// Test
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader("["+strings.Join(calls, ",")+"]")))
+
+	// Verify
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 5 {
+		t.Fatalf("Expected 5 responses, got %s", w.Body.String())
+	}
+	for i, response := range responses {
+		if response.Result != fmt.Sprintf("0x%d", i+1) {
+			t.Errorf("Expected the result of call %d, got %v", i+1, response.Result)
+		}
+	}
+	if fmt.Sprint(sizes) != "[2 2 1]" {
+		t.Errorf("Expected batches of 2, 2, and 1 calls, got %v", sizes)
+	}
+}
+
+// TestMaxBatchSize tests looking up batch size limits by upstream name and URL
+func TestMaxBatchSize(t *testing.T) {
+	// Setup
+	config = Config{MaxBatchSizes: map[string]int{"infura": 100, "https://rpc.ankr.com/eth": 1000}}
+	defer func() { config = Config{} }()
+
+	// Test & Verify
+	if size := maxBatchSize("https://mainnet.infura.io/v3/key", "infura"); size != 100 {
+		t.Errorf("Expected the limit by name, got %d", size)
+	}
+	if size := maxBatchSize("https://rpc.ankr.com/eth", "ankr"); size != 1000 {
+		t.Errorf("Expected the limit by URL, got %d", size)
+	}
+	if size := maxBatchSize("https://other.example", "other"); size < 1<<30 {
+		t.Errorf("Expected no limit, got %d", size)
+	}
+	config.MaxBatchSizes["infura"] = 0
+	if err := setupBatchSplitting(); err == nil {
+		t.Errorf("Expected a zero limit to be rejected")
+	}
+}
+
+// TestBatchConcurrency tests sending the batches of a client batch concurrently within the limits
+func TestBatchConcurrency(t *testing.T) {
+	// Setup
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		body, _ := io.ReadAll(r.Body)
+		var requests []JSONRPCRequest
+		json.Unmarshal(body, &requests)
+		w.Write([]byte(fmt.Sprintf(`[{"jsonrpc":"2.0","id":%v,"result":"0x%v"}]`, requests[0].ID, requests[0].ID)))
+	}))
+	defer server.Close()
+	calls := make([]string, 6)
+	for i := range calls {
+		calls[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_blockNumber","params":[]}`, i+1)
+	}
+	batch := "[" + strings.Join(calls, ",") + "]"
+	defer func() { config = Config{} }()
+
+	for _, tc := range []struct {
+		name        string
+		concurrency *BatchConcurrencyConfig
+		peak        int32
+	}{
+		{"sequential by default", nil, 1},
+		{"concurrent", &BatchConcurrencyConfig{Max: 3}, 3},
+		{"upstream limit", &BatchConcurrencyConfig{Max: 3, Upstreams: map[string]int{"node": 2}}, 2},
+	} {
+		config = Config{DefaultURL: server.URL, DefaultName: "node", MaxBatchSizes: map[string]int{"node": 1}, BatchConcurrency: tc.concurrency}
+		buildMethodURLMap()
+		peak.Store(0)
+
+		// Test
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(batch)))
+
+		// Verify
+		var responses []JSONRPCResponse
+		json.Unmarshal(w.Body.Bytes(), &responses)
+		if len(responses) != 6 || responses[0].Result != "0x1" || responses[5].Result != "0x6" {
+			t.Errorf("%s: expected the 6 responses in order, got %s", tc.name, w.Body.String())
+		}
+		if peak.Load() != tc.peak {
+			t.Errorf("%s: expected %d batches at once, got %d", tc.name, tc.peak, peak.Load())
+		}
+	}
+}
+
+// TestBatchTimeout tests answering the calls of a slow upstream with timeout errors and serving the rest
+func TestBatchTimeout(t *testing.T) {
+	// Setup
+	answer := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var requests []JSONRPCRequest
+		json.Unmarshal(body, &requests)
+		responses := make([]string, len(requests))
+		for i, req := range requests {
+			responses[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":"ok"}`, req.ID)
+		}
+		w.Write([]byte("[" + strings.Join(responses, ",") + "]"))
+	}
+	fast := httptest.NewServer(http.HandlerFunc(answer))
+	defer fast.Close()
+	stalled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Send the headers, then stall in the middle of the body
+		w.Write([]byte("["))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(stalled)
+	config = Config{
+		DefaultURL:   fast.URL,
+		Routes:       []Route{{Method: "debug_traceTransaction", URL: slow.URL, Name: "archive"}},
+		BatchTimeout: &BatchTimeoutConfig{Default: time.Minute, Upstreams: map[string]time.Duration{"archive": 50 * time.Millisecond}},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+
+	// Test
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction","params":["0x1"]},
+		{"jsonrpc":"2.0","id":3,"method":"debug_traceTransaction","params":["0x2"]}
+	]`)))
+
+	// Verify
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %s", w.Body.String())
+	}
+	byID := make(map[float64]JSONRPCResponse)
+	for _, response := range responses {
+		byID[response.ID.(float64)] = response
+	}
+	if byID[1].Result != "ok" {
+		t.Errorf("Expected the fast upstream's result, got %+v", byID[1])
+	}
+	for _, id := range []float64{2, 3} {
+		if byID[id].Error == nil || byID[id].Error.Code != -32002 {
+			t.Errorf("Expected a timeout error for call %v, got %+v", id, byID[id])
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Streaming batch responses
+//
+// The response of a client batch is normally merged in memory: each upstream response
+// is read whole, parsed, and the merged array marshalled again before anything is sent.
+// For analytic batches of hundreds of megabytes that means holding the data several
+// times over. Large client batches can be streamed instead:
+//
+//	batch_streaming:
+//	  min_calls: 500   # client batches of at least this many calls are streamed (default: 500)
+//
+// A streamed response starts as soon as the calls are routed, with the calls answered by
+// the proxy itself. Each upstream response is then decoded one call at a time and every
+// call's response written as it is decoded, so only one call's response is held at a
+// time; the client's response is flushed whenever an upstream batch is done. Responses
+// come in the order they arrive rather than grouped by upstream, as the JSON-RPC
+// specification allows.
+//
+// The status and headers are sent first, so X-Upstream lists every upstream the batch
+// was routed to, and X-Block-Height the lowest of their heights, whether or not they
+// answered. An upstream response that turns out to be malformed partway through keeps
+// the responses before the fault and leaves out the rest of its calls. One cut short
+// by the batch timeout (see batchsplit.go) keeps the responses streamed in time and
+// answers the rest of its calls with the timeout error. A client that disconnects gets
+// a truncated array.
+
+// BatchStreamingConfig configures the streaming of large batch responses.
+type BatchStreamingConfig struct {
+	MinCalls int `yaml:"min_calls"` // Client batches of at least this many calls are streamed (default: 500)
+}
+
+// defaultStreamMinCalls is the smallest client batch streamed by default.
+const defaultStreamMinCalls = 500
+
+// setupBatchStreaming validates the streaming of batch responses.
+//
+// Returns:
+//   - error: An error if min_calls is negative
+func setupBatchStreaming() error {
+	bs := config.BatchStreaming
+	if bs == nil {
+		return nil
+	}
+	if bs.MinCalls < 0 {
+		return fmt.Errorf("min_calls cannot be negative")
+	}
+	log.Printf("Streaming responses of batches of %d calls or more", streamMinCalls())
+	return nil
+}
+
+// streamMinCalls returns the smallest client batch streamed, or 0 if none are.
+func streamMinCalls() int {
+	bs := config.BatchStreaming
+	if bs == nil {
+		return 0
+	}
+	if bs.MinCalls > 0 {
+		return bs.MinCalls
+	}
+	return defaultStreamMinCalls
+}
+
+// batchStream writes the responses of a client batch as they arrive.
+type batchStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	mu      sync.Mutex
+	written int // Responses written
+}
+
+// startBatchStream sends the headers and the opening bracket of a streamed batch
+// response, or returns nil if the batch is too small to be streamed.
+//
+// Parameters:
+//   - w: The HTTP response writer
+//   - r: The incoming HTTP request
+//   - calls: The calls in the client batch
+//   - targeted: The upstreams the batch was routed to
+//
+// Returns:
+//   - *batchStream: The stream, or nil
+func startBatchStream(w http.ResponseWriter, r *http.Request, calls int, targeted []Upstream) *batchStream {
+	if least := streamMinCalls(); least == 0 || calls < least {
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	setUpstreamHeaders(w.Header(), r, targeted)
+	if responseCache != nil {
+		// Batches are not cached
+		w.Header().Set("X-Cache", cacheMiss)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("["))
+	return &batchStream{w: w, rc: http.NewResponseController(w)}
+}
+
+// write sends the response of a call.
+func (s *batchStream) write(response json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.written > 0 {
+		s.w.Write([]byte(","))
+	}
+	s.w.Write(response)
+	s.written++
+}
+
+// flush sends the responses written so far to the client.
+func (s *batchStream) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rc.Flush()
+}
+
+// finish closes the array of responses.
+func (s *batchStream) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write([]byte("]"))
+}
+
+// decodeBatchResponse decodes the array of an upstream batch response one call at a
+// time.
+//
+// Parameters:
+//   - body: The upstream response body
+//   - deliver: Called with each call's response, as it is decoded
+//
+// Returns:
+//   - error: An error if the response is not a JSON array of responses
+func decodeBatchResponse(body io.Reader, deliver func(json.RawMessage)) error {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array, got %v", tok)
+	}
+	for dec.More() {
+		var response json.RawMessage
+		if err := dec.Decode(&response); err != nil {
+			return err
+		}
+		deliver(response)
+	}
+	_, err := dec.Token()
+	return err
+}
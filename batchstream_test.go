@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoBatchServer answers each call of a batch with its method as the result.
+func echoBatchServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var calls []JSONRPCRequest
+		if err := json.Unmarshal(body, &calls); err != nil {
+			t.Errorf("Expected a batch, got %s", body)
+		}
+		responses := make([]JSONRPCResponse, len(calls))
+		for i, call := range calls {
+			responses[i] = JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Result: call.Method}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+}
+
+// TestBatchStreaming tests that large batches are streamed as a valid array, and that
+// smaller ones are merged as before.
+func TestBatchStreaming(t *testing.T) {
+	// Setup
+	server := echoBatchServer(t)
+	defer server.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":10,"result":"eth_getLogs"},{"jsonrpc":`))
+	}))
+	defer broken.Close()
+	config = Config{
+		DefaultURL:      server.URL,
+		DefaultName:     "main",
+		Routes:          []Route{{Method: "eth_getLogs", URL: broken.URL, Name: "logs"}},
+		BatchStreaming:  &BatchStreamingConfig{MinCalls: 3},
+		ResponseHeaders: &ResponseHeadersConfig{Upstream: true, Trusted: []string{"192.0.2.0/24"}},
+	}
+	buildMethodURLMap()
+	if err := setupResponseHeaders(); err != nil {
+		t.Fatalf("Failed to set up response headers: %v", err)
+	}
+	defer func() { config = Config{} }()
+	request := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w
+	}
+
+	// Test
+	streamed := request(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},` +
+		`{"jsonrpc":"2.0","id":10,"method":"eth_getLogs"},{"jsonrpc":"2.0","id":11,"method":"eth_getLogs"}]`)
+	merged := request(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)
+
+	// Verify
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(streamed.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Expected a valid streamed array, got %s: %v", streamed.Body.String(), err)
+	}
+	results := make(map[string]interface{})
+	for _, response := range responses {
+		results[string(mustMarshal(response.ID))] = response.Result
+	}
+	expected := map[string]interface{}{"1": "eth_blockNumber", "2": "eth_chainId", "10": "eth_getLogs"}
+	if len(results) != len(expected) {
+		t.Errorf("Expected responses %v, got %v", expected, results)
+	}
+	for id, result := range expected {
+		if results[id] != result {
+			t.Errorf("Expected result %v for id %s, got %v", result, id, results[id])
+		}
+	}
+	if got := streamed.Header().Get(upstreamHeader); got != "logs, main" && got != "main, logs" {
+		t.Errorf("Expected %s to list every targeted upstream, got %q", upstreamHeader, got)
+	}
+	if err := json.Unmarshal(merged.Body.Bytes(), &responses); err != nil || len(responses) != 2 {
+		t.Errorf("Expected a merged response of 2 calls, got %s", merged.Body.String())
+	}
+}
+
+// mustMarshal encodes a value to JSON, for comparing decoded ids.
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// TestBatchStreamingTimeout tests answering the calls not streamed before the batch
+// timeout with timeout errors
+func TestBatchStreamingTimeout(t *testing.T) {
+	// Setup
+	stalled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer the first call, then stall in the middle of the body
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(stalled)
+	config = Config{
+		DefaultURL:     slow.URL,
+		DefaultName:    "archive",
+		BatchStreaming: &BatchStreamingConfig{MinCalls: 3},
+		BatchTimeout:   &BatchTimeoutConfig{Default: 50 * time.Millisecond},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+
+	// Test
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},`+
+		`{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},{"jsonrpc":"2.0","id":3,"method":"eth_gasPrice"}]`)))
+
+	// Verify
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %s", w.Body.String())
+	}
+	byID := make(map[float64]JSONRPCResponse)
+	for _, response := range responses {
+		byID[response.ID.(float64)] = response
+	}
+	if byID[1].Result != "0x1" {
+		t.Errorf("Expected the streamed result, got %+v", byID[1])
+	}
+	for _, id := range []float64{2, 3} {
+		if byID[id].Error == nil || byID[id].Error.Code != -32002 {
+			t.Errorf("Expected a timeout error for call %v, got %+v", id, byID[id])
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// Blue/green switchover
+//
+// Moving a route to a new provider in one config push is all or nothing, and rolling
+// it back takes another. A route can instead name two pools, blue and green, and send
+// a share of its calls to green that operators move at runtime:
+//
+//	routes:
+//	  - method: eth_getLogs
+//	    name: logs
+//	    blue_green:
+//	      blue: old-provider    # pool serving the calls not sent to green
+//	      green: new-provider   # pool serving green_percent of the calls
+//	      green_percent: 0      # share of calls sent to green at startup (default: 0)
+//
+// GET /admin/switchover lists the blue/green routes and their current share. POST
+// /admin/switchover with a body of {"route": "logs", "active": "green"} flips all of
+// the route's calls to a pool, and {"route": "logs", "green_percent": 10} shifts a
+// share of them; either applies to the next call routed, and is undone the same way.
+// Switchovers address routes by name (or method, for routes without one), so each
+// blue/green route needs a name of its own. Shares set through the admin API last
+// until the proxy restarts, which starts over
+// from green_percent. Each call picks its pool at random with the current share, then
+// a member of that pool by the pool's strategy; with fallback_to_default, the route
+// falls back when the chosen pool has no healthy member. The share of each route is
+// exported as jsonrpc_proxy_route_green_percent{route="..."}.
+
+// BlueGreenConfig defines the two pools of a blue/green route.
+type BlueGreenConfig struct {
+	Blue         string `yaml:"blue"`          // Pool serving the calls not sent to green
+	Green        string `yaml:"green"`         // Pool serving green_percent of the calls
+	GreenPercent int    `yaml:"green_percent"` // Share of calls sent to green at startup, 0 to 100 (default: 0)
+}
+
+// Blue/green colors
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+)
+
+var (
+	blueGreenMu     sync.RWMutex
+	blueGreenShares = make(map[string]int) // Current share of calls sent to green, by route name
+)
+
+// blueGreenRegistered registers the share gauge with the first blue/green route.
+var blueGreenRegistered sync.Once
+
+// validateBlueGreen checks the pools of a blue/green route.
+func validateBlueGreen(route *Route) error {
+	bg := route.BlueGreen
+	if bg == nil {
+		return nil
+	}
+	if route.URL != "" || route.Pool != "" || route.Stub != nil {
+		return fmt.Errorf("blue_green cannot be combined with url, pool, or stub")
+	}
+	if bg.Blue == "" || bg.Green == "" {
+		return fmt.Errorf("blue and green pools are required")
+	}
+	for _, pool := range []string{bg.Blue, bg.Green} {
+		if config.Pools[pool] == nil {
+			return fmt.Errorf("unknown pool %s", pool)
+		}
+	}
+	if bg.GreenPercent < 0 || bg.GreenPercent > 100 {
+		return fmt.Errorf("green_percent must be between 0 and 100")
+	}
+	routes := 0
+	for i := range config.Routes {
+		if other := &config.Routes[i]; other.BlueGreen != nil && routeName(other) == routeName(route) {
+			routes++
+		}
+	}
+	if routes > 1 {
+		return fmt.Errorf("%d blue/green routes are named %s; switchovers address routes by name, so each needs its own", routes, routeName(route))
+	}
+	return nil
+}
+
+// setupBlueGreen sets the starting share of each blue/green route and registers the
+// switchover admin endpoint.
+func setupBlueGreen() {
+	blueGreenMu.Lock()
+	blueGreenShares = make(map[string]int)
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.BlueGreen == nil {
+			continue
+		}
+		blueGreenShares[routeName(route)] = route.BlueGreen.GreenPercent
+	}
+	count := len(blueGreenShares)
+	blueGreenMu.Unlock()
+	if count == 0 {
+		return
+	}
+	blueGreenRegistered.Do(func() { registerMetric(blueGreenCollector{}) })
+	registerAdminHandler("/admin/switchover", handleAdminSwitchover)
+	log.Printf("Serving %d route(s) from blue/green pools", count)
+}
+
+// greenPercent returns the current share of a blue/green route's calls sent to green.
+func greenPercent(route *Route) int {
+	blueGreenMu.RLock()
+	defer blueGreenMu.RUnlock()
+	if percent, ok := blueGreenShares[routeName(route)]; ok {
+		return percent
+	}
+	return route.BlueGreen.GreenPercent
+}
+
+// blueGreenPool picks the pool serving a call of a blue/green route.
+//
+// Parameters:
+//   - route: The blue/green route
+//
+// Returns:
+//   - string: The name of the pool
+func blueGreenPool(route *Route) string {
+	percent := greenPercent(route)
+	if percent >= 100 || (percent > 0 && rand.Intn(100) < percent) {
+		return route.BlueGreen.Green
+	}
+	return route.BlueGreen.Blue
+}
+
+// blueGreenActivePools returns the pools of a blue/green route receiving calls.
+func blueGreenActivePools(route *Route) []string {
+	switch percent := greenPercent(route); percent {
+	case 0:
+		return []string{route.BlueGreen.Blue}
+	case 100:
+		return []string{route.BlueGreen.Green}
+	}
+	return []string{route.BlueGreen.Blue, route.BlueGreen.Green}
+}
+
+// blueGreenRoute is a blue/green route as listed by the admin API.
+type blueGreenRoute struct {
+	Route        string `json:"route"`
+	Blue         string `json:"blue"`
+	Green        string `json:"green"`
+	GreenPercent int    `json:"green_percent"`
+}
+
+// handleAdminSwitchover lists the blue/green routes on GET, and moves a route's calls
+// between its pools on POST with a body of {"route": "name", "active": "blue|green"}
+// or {"route": "name", "green_percent": 0-100}.
+func handleAdminSwitchover(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Route        string `json:"route"`
+			Active       string `json:"active"`
+			GreenPercent *int   `json:"green_percent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Route == "" || (req.Active == "") == (req.GreenPercent == nil) {
+			http.Error(w, `Expected a body of {"route": "name", "active": "blue|green"} or {"route": "name", "green_percent": 0-100}`, http.StatusBadRequest)
+			return
+		}
+		percent, err := switchoverPercent(req.Active, req.GreenPercent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous, err := setGreenPercent(req.Route, percent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Route %s moved from %d%% to %d%% green via admin API", req.Route, previous, percent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blueGreenRoutes())
+}
+
+// switchoverPercent returns the green share requested by a switchover.
+//
+// Parameters:
+//   - active: The pool taking all calls, "blue" or "green", or empty
+//   - percent: The share of calls sent to green, or nil
+//
+// Returns:
+//   - int: The green share
+//   - error: An error if the pool or share is invalid
+func switchoverPercent(active string, percent *int) (int, error) {
+	switch {
+	case percent != nil:
+		if *percent < 0 || *percent > 100 {
+			return 0, fmt.Errorf("green_percent must be between 0 and 100")
+		}
+		return *percent, nil
+	case active == colorGreen:
+		return 100, nil
+	case active == colorBlue:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unknown pool %q (expected blue or green)", active)
+}
+
+// setGreenPercent sets the share of a blue/green route's calls sent to green.
+//
+// Parameters:
+//   - name: The route name
+//   - percent: The new share
+//
+// Returns:
+//   - int: The previous share
+//   - error: An error if no blue/green route has the name
+func setGreenPercent(name string, percent int) (int, error) {
+	blueGreenMu.Lock()
+	defer blueGreenMu.Unlock()
+	previous, ok := blueGreenShares[name]
+	if !ok {
+		return 0, fmt.Errorf("route %s is not a blue/green route", name)
+	}
+	blueGreenShares[name] = percent
+	return previous, nil
+}
+
+// blueGreenRoutes lists the blue/green routes and their current share, sorted by name.
+func blueGreenRoutes() []blueGreenRoute {
+	blueGreenMu.RLock()
+	defer blueGreenMu.RUnlock()
+	out := make([]blueGreenRoute, 0, len(blueGreenShares))
+	for _, name := range sortedKeys(blueGreenShares) {
+		for i := range config.Routes {
+			route := &config.Routes[i]
+			if route.BlueGreen != nil && routeName(route) == name {
+				out = append(out, blueGreenRoute{Route: name, Blue: route.BlueGreen.Blue, Green: route.BlueGreen.Green, GreenPercent: blueGreenShares[name]})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// blueGreenCollector exports the share of each blue/green route sent to green.
+type blueGreenCollector struct{}
+
+// write implements metricsCollector.
+func (blueGreenCollector) write(w io.Writer, openMetrics bool) {
+	blueGreenMu.RLock()
+	defer blueGreenMu.RUnlock()
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_route_green_percent Share of a blue/green route's calls sent to its green pool.\n# TYPE jsonrpc_proxy_route_green_percent gauge\n")
+	for _, name := range sortedKeys(blueGreenShares) {
+		fmt.Fprintf(w, "jsonrpc_proxy_route_green_percent%s %d\n", encodeLabels([]string{"route"}, []string{name}), blueGreenShares[name])
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBlueGreenSwitchover tests moving a route's calls between its pools through the admin API
+func TestBlueGreenSwitchover(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default",
+		Pools: map[string]*PoolConfig{
+			"old": {Upstreams: []UpstreamConfig{{URL: "http://old.example.com", Name: "old"}}},
+			"new": {Upstreams: []UpstreamConfig{{URL: "http://new.example.com", Name: "new"}}},
+		},
+		Routes: []Route{{Method: "eth_getLogs", Name: "logs", BlueGreen: &BlueGreenConfig{Blue: "old", Green: "new"}}},
+	}
+	if err := validateBlueGreen(&config.Routes[0]); err != nil {
+		t.Fatalf("Invalid blue/green route: %v", err)
+	}
+	buildMethodURLMap()
+	setupBlueGreen()
+	defer func() {
+		config = Config{}
+		blueGreenShares = make(map[string]int)
+	}()
+	route := func() map[string]int {
+		names := make(map[string]int)
+		for i := 0; i < 200; i++ {
+			upstream, err := router.Route(&JSONRPCRequest{Method: "eth_getLogs"})
+			if err != nil {
+				t.Fatalf("Routing failed: %v", err)
+			}
+			names[upstream.Name]++
+		}
+		return names
+	}
+	switchover := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAdminSwitchover(w, httptest.NewRequest("POST", "/admin/switchover", strings.NewReader(body)))
+		return w
+	}
+
+	// Test
+	blue := route()
+	flipped := switchover(`{"route": "logs", "active": "green"}`)
+	green := route()
+	shifted := switchover(`{"route": "logs", "green_percent": 50}`)
+	split := route()
+	var gauge bytes.Buffer
+	blueGreenCollector{}.write(&gauge, false)
+	unknown := switchover(`{"route": "traces", "active": "green"}`)
+	invalid := switchover(`{"route": "logs", "green_percent": 101}`)
+	ambiguous := switchover(`{"route": "logs", "active": "blue", "green_percent": 10}`)
+
+	// Verify
+	if blue["old"] != 200 {
+		t.Errorf("Expected every call on blue at startup, got %v", blue)
+	}
+	if flipped.Code != http.StatusOK || green["new"] != 200 {
+		t.Errorf("Expected every call on green after the flip, got %d %v", flipped.Code, green)
+	}
+	var listed []blueGreenRoute
+	if err := json.Unmarshal(shifted.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].GreenPercent != 50 {
+		t.Errorf("Expected the route listed at 50%% green, got %s", shifted.Body.String())
+	}
+	if split["old"] < 50 || split["new"] < 50 {
+		t.Errorf("Expected calls split between both pools, got %v", split)
+	}
+	if !strings.Contains(gauge.String(), `jsonrpc_proxy_route_green_percent{route="logs"} 50`) {
+		t.Errorf("Expected the share in the gauge, got:\n%s", gauge.String())
+	}
+	if unknown.Code != http.StatusNotFound || invalid.Code != http.StatusBadRequest || ambiguous.Code != http.StatusBadRequest {
+		t.Errorf("Expected 404, 400, and 400 for invalid switchovers, got %d, %d, and %d", unknown.Code, invalid.Code, ambiguous.Code)
+	}
+	if greenPercent(&config.Routes[0]) != 50 {
+		t.Errorf("Expected rejected switchovers to keep the share, got %d", greenPercent(&config.Routes[0]))
+	}
+}
+
+// TestValidateBlueGreen tests rejecting invalid blue/green routes
+func TestValidateBlueGreen(t *testing.T) {
+	// Setup
+	config = Config{Pools: map[string]*PoolConfig{"a": {}, "b": {}}}
+	defer func() { config = Config{} }()
+	cases := map[string]Route{
+		"url":     {URL: "http://a", BlueGreen: &BlueGreenConfig{Blue: "a", Green: "b"}},
+		"missing": {BlueGreen: &BlueGreenConfig{Blue: "a"}},
+		"unknown": {BlueGreen: &BlueGreenConfig{Blue: "a", Green: "c"}},
+		"percent": {BlueGreen: &BlueGreenConfig{Blue: "a", Green: "b", GreenPercent: 120}},
+	}
+
+	// Test and verify
+	for name, route := range cases {
+		if err := validateBlueGreen(&route); err == nil {
+			t.Errorf("Expected the %s route to be rejected", name)
+		}
+	}
+	if err := validateBlueGreen(&Route{BlueGreen: &BlueGreenConfig{Blue: "a", Green: "b", GreenPercent: 10}}); err != nil {
+		t.Errorf("Expected a valid route to pass, got %v", err)
+	}
+}
+
+// TestValidateBlueGreenDuplicateNames tests rejecting blue/green routes sharing a name,
+// which would share one switchover state
+func TestValidateBlueGreenDuplicateNames(t *testing.T) {
+	// Setup
+	bg := &BlueGreenConfig{Blue: "a", Green: "b"}
+	config = Config{
+		Pools: map[string]*PoolConfig{"a": {}, "b": {}},
+		Routes: []Route{
+			{Method: "eth_getLogs", Name: "reads", BlueGreen: bg},
+			{Method: "eth_call", Name: "reads", BlueGreen: bg},
+			{Method: "eth_getBalance", BlueGreen: bg},
+			{Method: "eth_getBalance", Name: "balances", URL: "http://a"},
+		},
+	}
+	defer func() { config = Config{} }()
+
+	// Test
+	named := validateBlueGreen(&config.Routes[0])
+	unique := validateBlueGreen(&config.Routes[2])
+	config.Routes[3] = Route{Method: "eth_getBalance", When: "true", BlueGreen: bg}
+	unnamed := validateBlueGreen(&config.Routes[2])
+
+	// Verify
+	if named == nil {
+		t.Error("Expected blue/green routes sharing a name to be rejected")
+	}
+	if unique != nil {
+		t.Errorf("Expected a blue/green route with a name of its own to pass, got %v", unique)
+	}
+	if unnamed == nil {
+		t.Error("Expected unnamed blue/green routes of the same method to be rejected")
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response caching and warming
+//
+// Calls about the chain head, such as eth_blockNumber or eth_gasPrice, are asked by
+// every client after every block, and their answers only change when the head moves.
+// Responses of the cached methods are kept per upstream until the upstream's head (as
+// tracked by the probe, see probe.go) moves past the block they were stored at, or ttl
+// passes, whichever comes first:
+//
+//	cache:
+//	  methods: [eth_chainId, eth_blockNumber, eth_gasPrice, eth_getBlockByNumber, eth_call]
+//	  ttl: 12s              # default
+//	  max_entries: 10000    # default
+//	  warm:
+//	    - method: eth_blockNumber
+//	    - method: eth_gasPrice
+//	    - method: eth_getBlockByNumber
+//	      params: ["latest", false]
+//	    - method: eth_call
+//	      params: [{"to": "0x5FbDB2315678afecb367f032d93F642f64180aa3", "data": "0x18160ddd"}, "latest"]
+//
+// Right after a block boundary every client misses at once. Warm calls avoid that: when
+// the probe sees an upstream reach a new head, the warm calls routed to it are sent
+// again and their responses stored, so clients hit a warm cache. Warming turns on
+// probing with the default interval if no probe is configured. The methods of warm calls
+// are cached even if methods does not list them.
+//
+// Only single calls are cached; batches and error responses are passed through.
+// Results that never change are kept regardless of the head and ttl, and can be
+// persisted across restarts (see cachestore.go).
+// Entries are keyed by upstream, method, and params exactly as the client sent them,
+// and served with the client's id. Calls of eth_call pinned to a block can be cached
+// across upstreams as well (see callcache.go).
+//
+// Every proxied response carries an X-Cache header: HIT when served from the cache,
+// STALE when the cached result was outdated and fetched again, and MISS otherwise.
+// Clients bypass the cache for a request with Cache-Control: no-cache or an X-No-Cache
+// header; the fresh result still replaces the cached one.
+
+// CacheConfig configures the response cache.
+type CacheConfig struct {
+	Methods     []string                `yaml:"methods"`     // Methods whose responses are cached
+	TTL         time.Duration           `yaml:"ttl"`         // Longest time an entry is served (default: 12s)
+	MaxEntries  int                     `yaml:"max_entries"` // Entries kept before the oldest are evicted (default: 10000)
+	Warm        []WarmCall              `yaml:"warm"`        // Calls refreshed whenever an upstream reaches a new head (optional)
+	Persist     *CachePersistConfig     `yaml:"persist"`     // File keeping immutable entries across restarts (optional, see cachestore.go)
+	Backend     string                  `yaml:"backend"`     // Where entries are kept: "memory" or "memcached" (default: memory)
+	Memcached   *MemcachedConfig        `yaml:"memcached"`   // Memcached servers (for the memcached backend, see memcached.go)
+	Compression *CacheCompressionConfig `yaml:"compression"` // Compression of large results (optional, see cachecompress.go)
+	EthCall     *EthCallCacheConfig     `yaml:"eth_call"`    // Caching of eth_call pinned to a block (optional, see callcache.go)
+	ServeStale  *ServeStaleConfig       `yaml:"serve_stale"` // Serving cached results when upstreams fail (optional, see stale.go)
+}
+
+// WarmCall is a call refreshed on new heads.
+type WarmCall struct {
+	Method string      `yaml:"method"` // The JSON-RPC method
+	Params interface{} `yaml:"params"` // Its params (default: none)
+}
+
+// Cache defaults.
+const (
+	defaultCacheTTL        = 12 * time.Second
+	defaultCacheMaxEntries = 10000
+)
+
+// cacheRequestsTotal counts cache lookups.
+var cacheRequestsTotal = newCounterVec("jsonrpc_proxy_cache_requests_total", "Response cache lookups, by result.", "result")
+
+// cacheEntry is a cached result.
+type cacheEntry struct {
+	result    json.RawMessage
+	deflated  []byte // Result compressed with DEFLATE, instead of result (see cachecompress.go)
+	head      uint64 // Head of the upstream when stored, or 0 if unknown
+	immutable bool   // Whether the result never changes, so that it is served regardless of the head (see cachestore.go)
+	stored    time.Time
+}
+
+// responseCache holds cached results, or is nil when caching is off.
+var responseCache *resultCache
+
+// resultCache caches results by upstream, method, and params.
+type resultCache struct {
+	methods    map[string]bool
+	ttl        time.Duration
+	maxEntries int
+	warm       []WarmCall
+	file       *cacheFile       // Persistent cache, or nil
+	shared     *memcachedClient // Shared backend holding the entries instead of entries, or nil
+	compressor *cacheCompressor // Compressor of large results, or nil
+	pinned     *pinnedCalls     // eth_call calls cached by block, or nil
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	warming map[string]bool // Upstreams being warmed
+	hits    uint64
+	misses  uint64
+	stale   uint64 // Misses of outdated entries
+	warmed  uint64
+}
+
+// setupCache builds the response cache from the configuration.
+//
+// Returns:
+//   - error: An error if a setting or warm call is invalid
+func setupCache() error {
+	responseCache = nil
+	cc := config.Cache
+	if cc == nil {
+		return nil
+	}
+	if cc.TTL < 0 || cc.MaxEntries < 0 {
+		return fmt.Errorf("ttl and max_entries cannot be negative")
+	}
+	if cc.ServeStale != nil && cc.ServeStale.MaxAge < 0 {
+		return fmt.Errorf("serve_stale.max_age cannot be negative")
+	}
+	rc := &resultCache{
+		methods:    make(map[string]bool),
+		ttl:        defaultCacheTTL,
+		maxEntries: defaultCacheMaxEntries,
+		warm:       cc.Warm,
+		entries:    make(map[string]*cacheEntry),
+		warming:    make(map[string]bool),
+	}
+	if cc.TTL > 0 {
+		rc.ttl = cc.TTL
+	}
+	if cc.MaxEntries > 0 {
+		rc.maxEntries = cc.MaxEntries
+	}
+	for _, method := range cc.Methods {
+		rc.methods[method] = true
+	}
+	for i, call := range cc.Warm {
+		if call.Method == "" {
+			return fmt.Errorf("warm[%d]: method is required", i)
+		}
+		if _, err := json.Marshal(call.Params); err != nil {
+			return fmt.Errorf("warm[%d]: params: %w", i, err)
+		}
+		rc.methods[call.Method] = true
+	}
+	pinned, err := newPinnedCalls(cc.EthCall)
+	if err != nil {
+		return err
+	}
+	rc.pinned = pinned
+	if len(rc.methods) == 0 && rc.pinned == nil {
+		return fmt.Errorf("methods, warm, or eth_call is required")
+	}
+	compressor, err := newCacheCompressor(cc.Compression)
+	if err != nil {
+		return err
+	}
+	rc.compressor = compressor
+	switch cc.Backend {
+	case "", "memory":
+	case "memcached":
+		if cc.Persist != nil {
+			return fmt.Errorf("persist is not available with the memcached backend")
+		}
+		shared, err := newMemcachedClient(cc.Memcached)
+		if err != nil {
+			return err
+		}
+		rc.shared = shared
+	default:
+		return fmt.Errorf("unknown backend %q (expected memory or memcached)", cc.Backend)
+	}
+	if cc.Persist != nil {
+		if err := rc.setupCacheFile(cc.Persist); err != nil {
+			return err
+		}
+	}
+	responseCache = rc
+	log.Printf("Caching responses of %d method(s) for up to %v, warming %d call(s) on new heads", len(rc.methods), rc.ttl, len(rc.warm))
+	if rc.pinned != nil {
+		log.Printf("Caching eth_call pinned to a block or %d blocks behind the head", rc.pinned.depth)
+	}
+	if age := staleMaxAge(); age > 0 {
+		log.Printf("Serving cached results up to %v old when upstreams fail", age)
+	}
+	return nil
+}
+
+// cacheKey identifies a call to an upstream.
+func cacheKey(url, method string, params interface{}) (string, bool) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "", false
+	}
+	return url + "\x00" + method + "\x00" + string(encoded), true
+}
+
+// Cache statuses reported in the X-Cache response header.
+const (
+	cacheHit   = "HIT"   // Served from the cache
+	cacheMiss  = "MISS"  // Not cached, or the cache was bypassed
+	cacheStale = "STALE" // Cached, but outdated by a new head or ttl, so fetched again
+)
+
+// lookup returns the cached result of a call, if it is still fresh.
+//
+// Parameters:
+//   - url: The upstream the call is routed to
+//   - req: The call
+//
+// Returns:
+//   - []byte: A response carrying the cached result and the call's id, on a hit
+//   - string: The cache status: cacheHit, cacheMiss, or cacheStale
+func (rc *resultCache) lookup(url string, req *JSONRPCRequest) ([]byte, string) {
+	key, ok := rc.key(url, req.Method, req.Params)
+	if !ok {
+		return nil, cacheMiss
+	}
+	head, _ := trackedHead(url)
+	var entry *cacheEntry
+	if rc.shared != nil {
+		entry, ok = rc.shared.get(key)
+	}
+	rc.mu.Lock()
+	if rc.shared == nil {
+		entry, ok = rc.entries[key]
+	}
+	status := cacheMiss
+	switch {
+	case ok && (entry.immutable || entry.head == head && time.Since(entry.stored) < rc.ttl):
+		status = cacheHit
+		rc.hits++
+	case ok:
+		status = cacheStale
+		rc.misses++
+		rc.stale++
+	default:
+		rc.misses++
+	}
+	rc.mu.Unlock()
+	cacheRequestsTotal.inc(strings.ToLower(status))
+	if status != cacheHit {
+		return nil, status
+	}
+	result, err := entry.value()
+	if err != nil {
+		logWarn("cache", "Dropping corrupt cache entry for method '%s': %v", req.Method, err)
+		return nil, cacheMiss
+	}
+	logDebug("cache", "Serving method '%s' from the cache (block %d)", req.Method, head)
+	data, err := json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      interface{}     `json:"id"`
+		Result  json.RawMessage `json:"result"`
+	}{"2.0", req.ID, result})
+	if err != nil {
+		return nil, cacheMiss
+	}
+	return data, cacheHit
+}
+
+// cacheBypassed reports whether a client asked to bypass the cache, with
+// Cache-Control: no-cache or X-No-Cache. The fresh result still replaces the cached one.
+func cacheBypassed(r *http.Request) bool {
+	if r.Header.Get("X-No-Cache") != "" {
+		return true
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheable reports whether the responses of a method are cached.
+func (rc *resultCache) cacheable(method string) bool {
+	return rc.methods[method] || method == "eth_call" && rc.pinned != nil
+}
+
+// key returns the cache key of a call: the key of an eth_call pinned to a block, or the
+// call to the upstream if its method is cached.
+//
+// Returns:
+//   - string: The cache key
+//   - bool: False if the call is not cached
+func (rc *resultCache) key(url, method string, params interface{}) (string, bool) {
+	if key, ok := rc.pinned.key(url, method, params); ok {
+		return key, true
+	}
+	if !rc.methods[method] {
+		return "", false
+	}
+	return cacheKey(url, method, params)
+}
+
+// store caches the result of a successful response.
+//
+// Parameters:
+//   - url: The upstream that answered
+//   - req: The call
+//   - head: The upstream's head when the call was sent
+//   - response: The upstream's response
+func (rc *resultCache) store(url string, req *JSONRPCRequest, head uint64, response []byte) {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(response, &resp) != nil || resp.Error != nil || resp.Result == nil {
+		return
+	}
+	rc.put(url, req.Method, req.Params, head, resp.Result)
+}
+
+// put adds an entry, evicting the oldest entries when the cache is full.
+func (rc *resultCache) put(url, method string, params interface{}, head uint64, result json.RawMessage) {
+	key, ok := rc.key(url, method, params)
+	if !ok {
+		return
+	}
+	_, pinned := rc.pinned.key(url, method, params)
+	now := time.Now()
+	entry := &cacheEntry{head: head, immutable: pinned || immutableResult(method, result), stored: now}
+	rc.compressor.compress(entry, result)
+	if rc.shared != nil {
+		rc.shared.set(key, entry, rc.retention())
+		return
+	}
+	rc.mu.Lock()
+	if _, exists := rc.entries[key]; !exists && len(rc.entries) >= rc.maxEntries {
+		rc.evict(now)
+	}
+	rc.entries[key] = entry
+	rc.mu.Unlock()
+	rc.persist(key, entry)
+}
+
+// evict drops expired entries, or the oldest tenth of the entries if none expired.
+// Immutable entries do not expire. Callers hold rc.mu.
+func (rc *resultCache) evict(now time.Time) {
+	for key, entry := range rc.entries {
+		if !entry.immutable && now.Sub(entry.stored) >= rc.retention() {
+			delete(rc.entries, key)
+		}
+	}
+	if len(rc.entries) < rc.maxEntries {
+		return
+	}
+	keys := make([]string, 0, len(rc.entries))
+	for key := range rc.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return rc.entries[keys[i]].stored.Before(rc.entries[keys[j]].stored) })
+	for _, key := range keys[:len(keys)/10+1] {
+		delete(rc.entries, key)
+	}
+}
+
+// onNewHead warms the cache for an upstream that reached a new head. It is called by
+// the probe.
+//
+// Parameters:
+//   - url: The upstream URL
+//   - head: The new head
+func onNewHead(url string, head uint64) {
+	rc := responseCache
+	if rc == nil || len(rc.warm) == 0 {
+		return
+	}
+	rc.mu.Lock()
+	if rc.warming[url] {
+		rc.mu.Unlock()
+		return
+	}
+	rc.warming[url] = true
+	rc.mu.Unlock()
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.warming, url)
+		rc.mu.Unlock()
+	}()
+
+	timeout := 3 * time.Second
+	if config.Probe != nil && config.Probe.Timeout > 0 {
+		timeout = config.Probe.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rc.warmUpstream(ctx, url, head)
+}
+
+// warmUpstream refreshes the warm calls routed to an upstream.
+func (rc *resultCache) warmUpstream(ctx context.Context, url string, head uint64) {
+	var wg sync.WaitGroup
+	for _, call := range rc.warm {
+		req := &JSONRPCRequest{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: 1}
+		upstream, err := router.Route(req)
+		if err != nil || upstream.URL != url {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			params := req.Params
+			if params == nil {
+				params = []interface{}{}
+			}
+			result, err := callUpstream(ctx, url, req.Method, params)
+			if err != nil {
+				logWarn("cache", "Failed to warm method '%s' on %s: %v", req.Method, upstream.Name, err)
+				return
+			}
+			rc.put(url, req.Method, req.Params, head, result)
+			rc.mu.Lock()
+			rc.warmed++
+			rc.mu.Unlock()
+			logDebug("cache", "Warmed method '%s' on %s at block %d", req.Method, upstream.Name, head)
+		}()
+	}
+	wg.Wait()
+}
+
+// stats returns the cache statistics served by proxy_cacheStats.
+func (rc *resultCache) stats() map[string]interface{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	hitRate := 0.0
+	if total := rc.hits + rc.misses; total > 0 {
+		hitRate = float64(rc.hits) / float64(total)
+	}
+	stats := map[string]interface{}{
+		"enabled": true,
+		"backend": "memory",
+		"entries": len(rc.entries),
+		"hits":    rc.hits,
+		"misses":  rc.misses,
+		"stale":   rc.stale,
+		"hitRate": hitRate,
+		"warmed":  rc.warmed,
+	}
+	bytes := 0
+	for _, entry := range rc.entries {
+		bytes += entry.size()
+	}
+	stats["bytes"] = bytes
+	if rc.shared != nil {
+		// The entries are on the memcached servers
+		stats["backend"] = "memcached"
+		delete(stats, "entries")
+		delete(stats, "bytes")
+	}
+	return stats
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setTrackedHead records a head for an upstream as if the probe had observed it.
+func setTrackedHead(url string, head uint64) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if upstreamStatuses == nil {
+		upstreamStatuses = make(map[string]*upstreamStatus)
+	}
+	upstreamStatuses[url] = &upstreamStatus{URL: url, Height: head, Healthy: true, LastProbe: time.Now()}
+}
+
+// TestResponseCache tests serving cached results until the head moves
+func TestResponseCache(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == "eth_call" {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": n})
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{Methods: []string{"eth_gasPrice", "eth_call"}}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	setTrackedHead(server.URL, 100)
+	request := func(body string) string {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	// Test
+	first := request(`{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice","params":[]}`)
+	cached := request(`{"jsonrpc":"2.0","id":"a","method":"eth_gasPrice","params":[]}`)
+	setTrackedHead(server.URL, 101)
+	refreshed := request(`{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice","params":[]}`)
+	request(`{"jsonrpc":"2.0","id":3,"method":"eth_call","params":[{"to":"0x1"},"latest"]}`)
+	request(`{"jsonrpc":"2.0","id":4,"method":"eth_call","params":[{"to":"0x1"},"latest"]}`)
+
+	// Verify
+	if !strings.Contains(first, `"result":1`) {
+		t.Errorf("Expected the first call to reach the upstream, got %s", first)
+	}
+	if cached != `{"jsonrpc":"2.0","id":"a","result":1}` {
+		t.Errorf("Expected the cached result with the client's id, got %s", cached)
+	}
+	if !strings.Contains(refreshed, `"result":2`) {
+		t.Errorf("Expected a new head to invalidate the entry, got %s", refreshed)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Expected errors not to be cached, got %d upstream calls", calls.Load())
+	}
+	stats := responseCache.stats()
+	if stats["hits"] != uint64(1) || stats["misses"] != uint64(4) {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+// TestCacheWarming tests refreshing warm calls when an upstream reaches a new head
+func TestCacheWarming(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Method})
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{Warm: []WarmCall{
+		{Method: "eth_blockNumber"},
+		{Method: "eth_getBlockByNumber", Params: []interface{}{"latest", false}},
+	}}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+
+	// Test
+	setTrackedHead(server.URL, 200)
+	onNewHead(server.URL, 200)
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_getBlockByNumber","params":["latest",false]}`)))
+
+	// Verify
+	if calls.Load() != 2 {
+		t.Errorf("Expected both warm calls to be sent, got %d", calls.Load())
+	}
+	if w.Body.String() != `{"jsonrpc":"2.0","id":7,"result":"eth_getBlockByNumber"}` {
+		t.Errorf("Expected a warm cache hit, got %s", w.Body.String())
+	}
+	if probeInterval() != defaultProbeInterval {
+		t.Errorf("Expected warming to turn on probing")
+	}
+}
+
+// TestCacheStatusHeader tests the X-Cache header and client-controlled bypass
+func TestCacheStatusHeader(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Cache", "HIT from provider-cdn")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + string(rune('0'+n)) + `}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{Methods: []string{"eth_gasPrice"}}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	setTrackedHead(server.URL, 100)
+	request := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice","params":[]}`))
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handleProxy(w, r)
+		return w
+	}
+
+	// Test
+	miss := request("", "")
+	hit := request("", "")
+	bypassed := request("Cache-Control", "max-age=0, no-cache")
+	afterBypass := request("", "")
+	custom := request("X-No-Cache", "1")
+	setTrackedHead(server.URL, 101)
+	stale := request("", "")
+
+	// Verify
+	expected := []struct {
+		name   string
+		w      *httptest.ResponseRecorder
+		status string
+		result string
+	}{
+		{"first request", miss, "MISS", `"result":1`},
+		{"repeated request", hit, "HIT", `"result":1`},
+		{"no-cache request", bypassed, "MISS", `"result":2`},
+		{"request after a bypass", afterBypass, "HIT", `"result":2`},
+		{"X-No-Cache request", custom, "MISS", `"result":3`},
+		{"request after a new head", stale, "STALE", `"result":4`},
+	}
+	for _, e := range expected {
+		if got := e.w.Header().Values("X-Cache"); len(got) != 1 || got[0] != e.status {
+			t.Errorf("Expected X-Cache %s for the %s, got %v", e.status, e.name, got)
+		}
+		if !strings.Contains(e.w.Body.String(), e.result) {
+			t.Errorf("Expected %s for the %s, got %s", e.result, e.name, e.w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressed cache storage
+//
+// Logs and traces can run to megabytes, and JSON compresses several-fold. Results at
+// least min_size bytes long can be kept compressed with DEFLATE, in memory, on disk,
+// and on memcached, and are decompressed when they are served:
+//
+//	cache:
+//	  compression:
+//	    min_size: 4096   # default
+//	    level: 1         # 1 (fastest, default) to 9 (smallest)
+//
+// A result is kept uncompressed if compression does not make it smaller. DEFLATE is
+// used because it is in the Go standard library; level 1 keeps the CPU cost low, which
+// matters more than the last few percent of savings for results served many times.
+
+// CacheCompressionConfig configures compressed cache storage.
+type CacheCompressionConfig struct {
+	MinSize int `yaml:"min_size"` // Smallest result compressed, in bytes (default: 4096)
+	Level   int `yaml:"level"`    // DEFLATE level, from 1 (fastest) to 9 (smallest) (default: 1)
+}
+
+// defaultCompressMinSize is the smallest result compressed by default.
+const defaultCompressMinSize = 4096
+
+// cacheCompressor compresses cached results.
+type cacheCompressor struct {
+	minSize int
+	level   int
+	writers sync.Pool // *flate.Writer
+}
+
+// newCacheCompressor creates the compressor of a compression configuration.
+//
+// Returns:
+//   - *cacheCompressor: The compressor, or nil without a configuration
+//   - error: An error if the level or minimum size is invalid
+func newCacheCompressor(cc *CacheCompressionConfig) (*cacheCompressor, error) {
+	if cc == nil {
+		return nil, nil
+	}
+	c := &cacheCompressor{minSize: defaultCompressMinSize, level: flate.BestSpeed}
+	if cc.MinSize < 0 {
+		return nil, fmt.Errorf("compression.min_size cannot be negative")
+	}
+	if cc.MinSize > 0 {
+		c.minSize = cc.MinSize
+	}
+	if cc.Level != 0 {
+		if cc.Level < flate.BestSpeed || cc.Level > flate.BestCompression {
+			return nil, fmt.Errorf("compression.level must be between 1 and 9")
+		}
+		c.level = cc.Level
+	}
+	return c, nil
+}
+
+// compress sets the result of an entry, compressed if it is large enough and shrinks.
+func (c *cacheCompressor) compress(entry *cacheEntry, result json.RawMessage) {
+	entry.result = result
+	if c == nil || len(result) < c.minSize {
+		return
+	}
+	var buf bytes.Buffer
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(&buf, c.level)
+	} else {
+		w.Reset(&buf)
+	}
+	w.Write(result)
+	w.Close()
+	c.writers.Put(w)
+	if buf.Len() < len(result) {
+		entry.result, entry.deflated = nil, buf.Bytes()
+	}
+}
+
+// value returns the result of an entry, decompressing it if needed.
+func (e *cacheEntry) value() (json.RawMessage, error) {
+	if e.deflated == nil {
+		return e.result, nil
+	}
+	return io.ReadAll(flate.NewReader(bytes.NewReader(e.deflated)))
+}
+
+// size returns the bytes an entry's result takes.
+func (e *cacheEntry) size() int {
+	return len(e.result) + len(e.deflated)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCacheCompressor tests compressing large results and leaving small ones alone
+func TestCacheCompressor(t *testing.T) {
+	// Setup
+	c, err := newCacheCompressor(&CacheCompressionConfig{MinSize: 100})
+	if err != nil {
+		t.Fatalf("Failed to create compressor: %v", err)
+	}
+	large := json.RawMessage(`[` + strings.Repeat(`{"address":"0x5FbDB2315678afecb367f032d93F642f64180aa3","data":"0x00"},`, 200) + `{}]`)
+	small := json.RawMessage(`"0x1"`)
+
+	// Test
+	var largeEntry, smallEntry cacheEntry
+	c.compress(&largeEntry, large)
+	c.compress(&smallEntry, small)
+	restored, err := largeEntry.value()
+
+	// Verify
+	if largeEntry.deflated == nil || largeEntry.size() > len(large)/5 {
+		t.Errorf("Expected the large result to shrink several-fold, got %d of %d bytes", largeEntry.size(), len(large))
+	}
+	if err != nil || string(restored) != string(large) {
+		t.Errorf("Expected the large result back, got %v", err)
+	}
+	if smallEntry.deflated != nil || string(smallEntry.result) != `"0x1"` {
+		t.Errorf("Expected the small result to stay uncompressed")
+	}
+	for _, level := range []int{-1, 10} {
+		if _, err := newCacheCompressor(&CacheCompressionConfig{Level: level}); err == nil {
+			t.Errorf("Expected level %d to be rejected", level)
+		}
+	}
+}
+
+// TestCompressedCache tests serving and persisting compressed results
+func TestCompressedCache(t *testing.T) {
+	// Setup
+	block := `{"hash":"0xabc","transactions":[` + strings.Repeat(`"0x5FbDB2315678afecb367f032d93F642f64180aa35FbDB2315678afecb367f032d93F642f",`, 100) + `"0x0"]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + block + `}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{
+		Methods:     []string{"eth_getBlockByHash"},
+		Persist:     &CachePersistConfig{Path: path},
+		Compression: &CacheCompressionConfig{MinSize: 1024},
+	}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		responseCache = nil
+	}()
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":5,"method":"eth_getBlockByHash","params":["0xabc",false]}`)))
+		return w
+	}
+
+	// Test
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	request()
+	stored := responseCache.stats()["bytes"].(int)
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	hit := request()
+
+	// Verify
+	if stored >= len(block)/5 {
+		t.Errorf("Expected the cached block to be compressed, it takes %d of %d bytes", stored, len(block))
+	}
+	if hit.Header().Get("X-Cache") != "HIT" || hit.Body.String() != `{"jsonrpc":"2.0","id":5,"result":`+block+`}` {
+		t.Errorf("Expected the decompressed block from disk, got %s %s", hit.Header().Get("X-Cache"), hit.Body.String())
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"z":`) {
+		t.Errorf("Expected the compressed result on disk, got %s", data)
+	}
+}
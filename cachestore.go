@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Persistent cache
+//
+// A restarted proxy starts with an empty cache, and every client refetching the same
+// blocks and receipts at once can cost real money with paid providers. Immutable
+// results can therefore be kept on disk:
+//
+//	cache:
+//	  methods: [eth_chainId, eth_getBlockByHash, eth_getTransactionReceipt]
+//	  persist:
+//	    path: /var/lib/jsonrpc-proxy/cache.jsonl
+//
+// A result is immutable when its method identifies what it returns by hash (blocks,
+// transactions, receipts) or never changes (chain id), and it is neither null nor
+// pending (without a blockHash). Immutable entries are served regardless of the head
+// and ttl, until evicted. Block lookups by hash are safe across reorganizations, but a
+// transaction or receipt moved to another block by a reorganization keeps naming the
+// orphaned block; leave those methods out of methods where that matters.
+//
+// Entries are appended to the file as JSON lines when they are cached. At startup the
+// file is loaded, keeping the newest max_entries entries, and rewritten without the
+// rest; it is rewritten the same way whenever it grows to twice max_entries lines.
+// Head-scoped entries are not persisted, since the head has moved by the time the proxy
+// is back.
+
+// CachePersistConfig configures the persistent cache.
+type CachePersistConfig struct {
+	Path string `yaml:"path"` // File holding the immutable entries
+}
+
+// immutableMethods are the methods whose non-null, non-pending results never change.
+var immutableMethods = map[string]bool{
+	"eth_chainId":                              true,
+	"net_version":                              true,
+	"eth_getBlockByHash":                       true,
+	"eth_getBlockTransactionCountByHash":       true,
+	"eth_getUncleCountByBlockHash":             true,
+	"eth_getUncleByBlockHashAndIndex":          true,
+	"eth_getTransactionByHash":                 true,
+	"eth_getTransactionByBlockHashAndIndex":    true,
+	"eth_getTransactionReceipt":                true,
+	"eth_getRawTransactionByHash":              true,
+	"eth_getRawTransactionByBlockHashAndIndex": true,
+	"debug_traceTransaction":                   true,
+}
+
+// immutableResult reports whether a result of a method never changes.
+func immutableResult(method string, result json.RawMessage) bool {
+	if !immutableMethods[method] || bytes.Equal(bytes.TrimSpace(result), []byte("null")) {
+		return false
+	}
+	return !bytes.Contains(result, []byte(`"blockHash":null`))
+}
+
+// persistedEntry is a line of the cache file.
+type persistedEntry struct {
+	Key      string          `json:"k"`
+	Result   json.RawMessage `json:"r,omitempty"`
+	Deflated []byte          `json:"z,omitempty"` // Compressed result, base64-encoded (see cachecompress.go)
+	Stored   int64           `json:"t"`           // Unix seconds
+}
+
+// cacheFile appends immutable entries to the cache file.
+type cacheFile struct {
+	path string
+
+	mu    sync.Mutex
+	file  *os.File
+	lines int // Lines in the file
+}
+
+// openCacheFile loads the entries of the cache file, keeping the newest maxEntries, and
+// rewrites it with them.
+//
+// Parameters:
+//   - path: The cache file
+//   - maxEntries: The most entries to load
+//
+// Returns:
+//   - *cacheFile: The file, open for appending
+//   - map[string]*cacheEntry: The loaded entries
+//   - error: An error if the file cannot be read or written
+func openCacheFile(path string, maxEntries int) (*cacheFile, map[string]*cacheEntry, error) {
+	entries := make(map[string]*cacheEntry)
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var pe persistedEntry
+			if json.Unmarshal(scanner.Bytes(), &pe) != nil || pe.Key == "" {
+				continue // A line torn by a crash
+			}
+			entries[pe.Key] = &cacheEntry{result: pe.Result, deflated: pe.Deflated, immutable: true, stored: time.Unix(pe.Stored, 0)}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	trimEntries(entries, maxEntries)
+
+	cf := &cacheFile{path: path}
+	if err := cf.rewrite(entries); err != nil {
+		return nil, nil, err
+	}
+	return cf, entries, nil
+}
+
+// trimEntries drops the oldest entries beyond max.
+func trimEntries(entries map[string]*cacheEntry, max int) {
+	if len(entries) <= max {
+		return
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return entries[keys[i]].stored.Before(entries[keys[j]].stored) })
+	for _, key := range keys[:len(keys)-max] {
+		delete(entries, key)
+	}
+}
+
+// rewrite replaces the file with the immutable entries given, through a temporary file.
+func (cf *cacheFile) rewrite(entries map[string]*cacheEntry) error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(cf.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cf.path), filepath.Base(cf.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	lines := 0
+	for key, entry := range entries {
+		if !entry.immutable {
+			continue
+		}
+		line, _ := json.Marshal(persistedEntry{Key: key, Result: entry.result, Deflated: entry.deflated, Stored: entry.stored.Unix()})
+		w.Write(append(line, '\n'))
+		lines++
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), cf.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if cf.file != nil {
+		cf.file.Close()
+	}
+	cf.file, err = os.OpenFile(cf.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	cf.lines = lines
+	return err
+}
+
+// append writes an entry to the file.
+//
+// Returns:
+//   - int: The lines in the file
+func (cf *cacheFile) append(key string, entry *cacheEntry) int {
+	line, err := json.Marshal(persistedEntry{Key: key, Result: entry.result, Deflated: entry.deflated, Stored: entry.stored.Unix()})
+	if err != nil {
+		return 0
+	}
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	_, err = cf.file.Write(append(line, '\n'))
+	reportDependency("cache file "+cf.path, err)
+	if err != nil {
+		logError("cache", "Failed to persist cache entry: %v", err)
+		return cf.lines
+	}
+	cf.lines++
+	return cf.lines
+}
+
+// setupCacheFile opens the persistent cache of a result cache.
+func (rc *resultCache) setupCacheFile(pc *CachePersistConfig) error {
+	if pc.Path == "" {
+		return fmt.Errorf("persist.path is required")
+	}
+	cf, entries, err := openCacheFile(pc.Path, rc.maxEntries)
+	if err != nil {
+		return fmt.Errorf("persist.path: %w", err)
+	}
+	rc.file = cf
+	rc.entries = entries
+	registerDependency("cache file "+pc.Path, "cache_file", "immutable results are not persisted")
+	log.Printf("Loaded %d cache entries from %s", len(entries), pc.Path)
+	return nil
+}
+
+// persist appends an immutable entry to the cache file, compacting the file when it
+// has grown to twice max_entries lines.
+func (rc *resultCache) persist(key string, entry *cacheEntry) {
+	if rc.file == nil || !entry.immutable {
+		return
+	}
+	if rc.file.append(key, entry) < 2*rc.maxEntries {
+		return
+	}
+	rc.mu.Lock()
+	snapshot := make(map[string]*cacheEntry, len(rc.entries))
+	for k, e := range rc.entries {
+		snapshot[k] = e
+	}
+	rc.mu.Unlock()
+	err := rc.file.rewrite(snapshot)
+	reportDependency("cache file "+rc.file.path, err)
+	if err != nil {
+		logError("cache", "Failed to compact %s: %v", rc.file.path, err)
+	}
+}
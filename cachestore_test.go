@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestImmutableResult tests telling results that never change from the others
+func TestImmutableResult(t *testing.T) {
+	testCases := []struct {
+		method   string
+		result   string
+		expected bool
+	}{
+		{"eth_getBlockByHash", `{"number":"0x1","hash":"0xabc"}`, true},
+		{"eth_getTransactionReceipt", `{"blockHash":"0xabc","status":"0x1"}`, true},
+		{"eth_getTransactionByHash", `{"blockHash":null,"hash":"0xdef"}`, false},
+		{"eth_getTransactionReceipt", `null`, false},
+		{"eth_getBlockByNumber", `{"number":"0x1"}`, false},
+		{"eth_chainId", `"0x1"`, true},
+	}
+
+	for _, tc := range testCases {
+		if got := immutableResult(tc.method, json.RawMessage(tc.result)); got != tc.expected {
+			t.Errorf("Expected immutableResult(%s, %s) to be %v", tc.method, tc.result, tc.expected)
+		}
+	}
+}
+
+// TestPersistentCache tests serving immutable results cached before a restart
+func TestPersistentCache(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		result := map[string]interface{}{"blockHash": "0xabc", "status": "0x1"}
+		if req.Method == "eth_gasPrice" {
+			result = map[string]interface{}{"price": "0x1"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cache", "cache.jsonl")
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{Methods: []string{"eth_getTransactionReceipt", "eth_gasPrice"}, Persist: &CachePersistConfig{Path: path}}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		responseCache = nil
+	}()
+	request := func(body string) string {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w.Body.String()
+	}
+	receipt := `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0xdef"]}`
+
+	// Test
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	request(receipt)
+	request(`{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice","params":[]}`)
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	restarted := request(receipt)
+
+	// Verify
+	if calls.Load() != 2 {
+		t.Errorf("Expected the receipt to be served from disk after a restart, got %d upstream calls", calls.Load())
+	}
+	if !strings.Contains(restarted, `"blockHash":"0xabc"`) {
+		t.Errorf("Expected the persisted receipt, got %s", restarted)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 1 || strings.Contains(string(data), "price") {
+		t.Errorf("Expected only the immutable entry on disk, got %s", data)
+	}
+}
+
+// TestCacheFileCompaction tests keeping the newest entries when loading the cache file
+func TestCacheFileCompaction(t *testing.T) {
+	// Setup
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	lines := `{"k":"a","r":"1","t":100}` + "\n" + `{"k":"b","r":"2","t":200}` + "\n" + `{"k":"a","r":"3","t":300}` + "\n" + `{"k":"c","r` + "\n"
+	os.WriteFile(path, []byte(lines), 0o644)
+
+	// Test
+	cf, entries, err := openCacheFile(path, 1)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to open cache file: %v", err)
+	}
+	if len(entries) != 1 || string(entries["a"].result) != `"3"` {
+		t.Errorf("Expected the newest entry only, got %v", entries)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != `{"k":"a","r":"3","t":300}`+"\n" || cf.lines != 1 {
+		t.Errorf("Expected the file to be rewritten, got %q", data)
+	}
+}
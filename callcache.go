@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pinned eth_call caching
+//
+// An eth_call against a given block always returns the same result: the state it reads
+// is fixed once the block is. Calls pinned to a block by hash, or by a number far
+// enough behind the head that it will not be reorganized, are cached like immutable
+// results, regardless of the head and ttl, and shared between upstreams. Only calls to
+// the allowlisted contracts and functions are cached, since the results of some
+// contracts are large or never asked twice:
+//
+//	cache:
+//	  eth_call:
+//	    contracts: ["0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"]
+//	    selectors: ["0x70a08231", "0x18160ddd"]   # balanceOf, totalSupply
+//	    finality_depth: 64                        # default
+//
+// A call is cached when its to address is one of contracts and the first four bytes of
+// its data are one of selectors; either list left out allows any. At least one is
+// required. The block is a hash, a number, or an EIP-1898 object with blockHash or
+// blockNumber; a number counts as pinned once the upstream's tracked head is at least
+// finality_depth blocks past it, which needs probing (see probe.go). Calls against tags
+// such as "latest" are cached only if methods lists eth_call, as any other call.
+// Entries are keyed by the call object, with addresses and data in lowercase, and the
+// block, so the same call written differently by two clients shares an entry.
+
+// EthCallCacheConfig configures the caching of eth_call pinned to a block.
+type EthCallCacheConfig struct {
+	Contracts     []string `yaml:"contracts"`      // Contract addresses whose calls are cached (default: any)
+	Selectors     []string `yaml:"selectors"`      // 4-byte function selectors cached (default: any)
+	FinalityDepth uint64   `yaml:"finality_depth"` // Blocks behind the head for a block number to count as pinned (default: 64)
+}
+
+// defaultFinalityDepth is the depth past which block numbers are not reorganized.
+const defaultFinalityDepth = 64
+
+// pinnedCalls selects the eth_call calls cached by block.
+type pinnedCalls struct {
+	contracts map[string]bool // Lowercase addresses, or nil for any
+	selectors map[string]bool // Lowercase selectors, or nil for any
+	depth     uint64
+}
+
+// newPinnedCalls creates the eth_call selection of a configuration.
+//
+// Returns:
+//   - *pinnedCalls: The selection, or nil without a configuration
+//   - error: An error if an address or selector is malformed, or neither is listed
+func newPinnedCalls(cc *EthCallCacheConfig) (*pinnedCalls, error) {
+	if cc == nil {
+		return nil, nil
+	}
+	if len(cc.Contracts) == 0 && len(cc.Selectors) == 0 {
+		return nil, fmt.Errorf("eth_call: contracts or selectors is required")
+	}
+	pc := &pinnedCalls{depth: defaultFinalityDepth}
+	if cc.FinalityDepth > 0 {
+		pc.depth = cc.FinalityDepth
+	}
+	if len(cc.Contracts) > 0 {
+		pc.contracts = make(map[string]bool)
+		for _, address := range cc.Contracts {
+			if !isHexString(address, 20) {
+				return nil, fmt.Errorf("eth_call: contracts: %q is not an address", address)
+			}
+			pc.contracts[strings.ToLower(address)] = true
+		}
+	}
+	if len(cc.Selectors) > 0 {
+		pc.selectors = make(map[string]bool)
+		for _, selector := range cc.Selectors {
+			if !isHexString(selector, 4) {
+				return nil, fmt.Errorf("eth_call: selectors: %q is not a 4-byte selector", selector)
+			}
+			pc.selectors[strings.ToLower(selector)] = true
+		}
+	}
+	return pc, nil
+}
+
+// key returns the cache key of an eth_call pinned to a block.
+//
+// Parameters:
+//   - url: The upstream the call is routed to, whose head decides whether a block number is final
+//   - method: The JSON-RPC method
+//   - params: The call's params
+//
+// Returns:
+//   - string: The cache key, shared between upstreams
+//   - bool: False if the call is not an allowlisted eth_call pinned to a block
+func (pc *pinnedCalls) key(url, method string, params interface{}) (string, bool) {
+	if pc == nil || method != "eth_call" {
+		return "", false
+	}
+	args, ok := params.([]interface{})
+	if !ok || len(args) < 2 {
+		return "", false
+	}
+	call, ok := args[0].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	to, _ := call["to"].(string)
+	if pc.contracts != nil && !pc.contracts[strings.ToLower(to)] {
+		return "", false
+	}
+	data, _ := call["data"].(string)
+	if data == "" {
+		data, _ = call["input"].(string)
+	}
+	if pc.selectors != nil && (len(data) < 10 || !pc.selectors[strings.ToLower(data[:10])]) {
+		return "", false
+	}
+	block, ok := pc.block(url, args[1])
+	if !ok {
+		return "", false
+	}
+
+	normalized := make(map[string]interface{}, len(call))
+	for field, value := range call {
+		if s, ok := value.(string); ok {
+			value = strings.ToLower(s)
+		}
+		normalized[field] = value
+	}
+	encoded, err := json.Marshal(append([]interface{}{normalized}, args[2:]...))
+	if err != nil {
+		return "", false
+	}
+	return "eth_call\x00" + block + "\x00" + string(encoded), true
+}
+
+// block identifies the block of an eth_call, if the call is pinned to it.
+//
+// Returns:
+//   - string: The block hash, or "#" and the block number
+//   - bool: False for tags, and numbers not yet final
+func (pc *pinnedCalls) block(url string, param interface{}) (string, bool) {
+	var hash, number string
+	switch p := param.(type) {
+	case string:
+		if isHexString(p, 32) {
+			hash = p
+		} else {
+			number = p
+		}
+	case map[string]interface{}:
+		hash, _ = p["blockHash"].(string)
+		number, _ = p["blockNumber"].(string)
+	}
+	if hash != "" {
+		if !isHexString(hash, 32) {
+			return "", false
+		}
+		return strings.ToLower(hash), true
+	}
+	n, err := parseQuantity(number)
+	if err != nil {
+		return "", false
+	}
+	head, ok := trackedHead(url)
+	if !ok || head < pc.depth || n > head-pc.depth {
+		return "", false
+	}
+	return "#" + strconv.FormatUint(n, 10), true
+}
+
+// isHexString reports whether s is 0x followed by the hex encoding of size bytes.
+func isHexString(s string, size int) bool {
+	if len(s) != 2+2*size || !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return false
+	}
+	for _, c := range s[2:] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestPinnedCallCache tests caching eth_call pinned to a block across head changes
+func TestPinnedCallCache(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": n})
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{EthCall: &EthCallCacheConfig{
+		Contracts: []string{"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"},
+	}}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	setTrackedHead(server.URL, 1000)
+	request := func(params string) string {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":`+params+`}`)))
+		return w.Header().Get("X-Cache")
+	}
+	hash := "0x" + strings.Repeat("ab", 32)
+
+	// Test
+	byHash := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"` + hash + `"]`)
+	setTrackedHead(server.URL, 1001)
+	byHashAgain := request(`[{"data":"0x18160DDD","to":"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"},{"blockHash":"0x` + strings.ToUpper(hash[2:]) + `"}]`)
+	final := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"0x100"]`)
+	finalAgain := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"0x100"]`)
+	recent := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"0x3e8"]`)
+	recentAgain := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"0x3e8"]`)
+	latest := request(`[{"to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","data":"0x18160ddd"},"latest"]`)
+	other := request(`[{"to":"0x0000000000000000000000000000000000000001","data":"0x18160ddd"},"` + hash + `"]`)
+	otherAgain := request(`[{"to":"0x0000000000000000000000000000000000000001","data":"0x18160ddd"},"` + hash + `"]`)
+
+	// Verify
+	if byHash != cacheMiss || byHashAgain != cacheHit {
+		t.Errorf("Expected a call pinned by hash to hit once stored, got %s then %s", byHash, byHashAgain)
+	}
+	if final != cacheMiss || finalAgain != cacheHit {
+		t.Errorf("Expected a final block number to be cached, got %s then %s", final, finalAgain)
+	}
+	if recent != cacheMiss || recentAgain != cacheMiss {
+		t.Errorf("Expected a recent block number not to be cached, got %s then %s", recent, recentAgain)
+	}
+	if latest != cacheMiss {
+		t.Errorf("Expected latest not to be cached, got %s", latest)
+	}
+	if other != cacheMiss || otherAgain != cacheMiss {
+		t.Errorf("Expected contracts not listed not to be cached, got %s then %s", other, otherAgain)
+	}
+	if got := calls.Load(); got != 7 {
+		t.Errorf("Expected 7 upstream calls, got %d", got)
+	}
+}
+
+// TestPinnedCallsConfig tests validating the eth_call allowlist
+func TestPinnedCallsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  EthCallCacheConfig
+		wantErr bool
+	}{
+		{"selectors only", EthCallCacheConfig{Selectors: []string{"0x70a08231"}}, false},
+		{"nothing listed", EthCallCacheConfig{}, true},
+		{"short address", EthCallCacheConfig{Contracts: []string{"0x1234"}}, true},
+		{"long selector", EthCallCacheConfig{Selectors: []string{"0x70a0823100"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			_, err := newPinnedCalls(&tt.config)
+
+			// Verify
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chain presets
+//
+// The proxy knows common chains by name: their chain ID, native currency, and public
+// JSON-RPC endpoints. Naming the chain the proxy serves gives it sane defaults and
+// guards against pointing it at the wrong network:
+//
+//	chain: polygon
+//
+// Without default_url or default_pool, the chain's public endpoints become the members
+// of a pool named after the chain, serving as the default pool. Listing upstreams
+// keeps them in charge; the public endpoints are a starting point, rate limited and
+// without guarantees. Either way, every upstream is asked for eth_chainId at startup,
+// and the proxy refuses to start when one serves another chain. Upstreams that cannot
+// be reached are logged and left unchecked, and members discovered at runtime are not
+// checked. `jsonrpc-proxy init -chain` pre-fills its example configuration from the
+// same presets (see scaffold.go).
+
+// chainPreset describes a known chain.
+type chainPreset struct {
+	Title    string   // Human-readable chain name
+	ChainID  string   // Chain ID as a hex quantity
+	Currency string   // Symbol of the native currency
+	URLs     []string // Public JSON-RPC endpoints, the first being the preferred one
+}
+
+// chainPresets are the known chains, by name.
+var chainPresets = map[string]chainPreset{
+	"ethereum":      {"Ethereum mainnet", "0x1", "ETH", []string{"https://ethereum-rpc.publicnode.com", "https://eth.llamarpc.com", "https://cloudflare-eth.com"}},
+	"sepolia":       {"Ethereum Sepolia", "0xaa36a7", "ETH", []string{"https://ethereum-sepolia-rpc.publicnode.com", "https://rpc.sepolia.org"}},
+	"polygon":       {"Polygon PoS", "0x89", "POL", []string{"https://polygon-rpc.com", "https://polygon-bor-rpc.publicnode.com"}},
+	"linea":         {"Linea mainnet", "0xe708", "ETH", []string{"https://rpc.linea.build", "https://linea-rpc.publicnode.com"}},
+	"linea-sepolia": {"Linea Sepolia", "0xe705", "ETH", []string{"https://rpc.sepolia.linea.build"}},
+	"arbitrum":      {"Arbitrum One", "0xa4b1", "ETH", []string{"https://arb1.arbitrum.io/rpc", "https://arbitrum-one-rpc.publicnode.com"}},
+	"optimism":      {"OP mainnet", "0xa", "ETH", []string{"https://mainnet.optimism.io", "https://optimism-rpc.publicnode.com"}},
+	"base":          {"Base mainnet", "0x2105", "ETH", []string{"https://mainnet.base.org", "https://base-rpc.publicnode.com"}},
+	"bsc":           {"BNB Smart Chain", "0x38", "BNB", []string{"https://bsc-dataseed.bnbchain.org", "https://bsc-rpc.publicnode.com"}},
+	"gnosis":        {"Gnosis Chain", "0x64", "XDAI", []string{"https://rpc.gnosischain.com", "https://gnosis-rpc.publicnode.com"}},
+	"avalanche":     {"Avalanche C-Chain", "0xa86a", "AVAX", []string{"https://api.avax.network/ext/bc/C/rpc", "https://avalanche-c-chain-rpc.publicnode.com"}},
+}
+
+// chainIDTimeout bounds the eth_chainId query sent to each upstream at startup.
+const chainIDTimeout = 5 * time.Second
+
+// chainPresetNames returns the sorted names of the chain presets.
+func chainPresetNames() []string {
+	names := make([]string, 0, len(chainPresets))
+	for name := range chainPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyChainPreset checks the configured chain and, when no default upstream is
+// configured, makes a pool of its public endpoints the default pool.
+//
+// Returns:
+//   - error: An error if the chain is unknown
+func applyChainPreset() error {
+	if config.Chain == "" {
+		return nil
+	}
+	preset, ok := chainPresets[config.Chain]
+	if !ok {
+		return configErrorf("chain", "unknown chain %q (known: %s)", config.Chain, strings.Join(chainPresetNames(), ", "))
+	}
+	if config.DefaultURL != "" || config.DefaultPool != "" {
+		return nil
+	}
+	if config.Pools == nil {
+		config.Pools = make(map[string]*PoolConfig)
+	}
+	if config.Pools[config.Chain] == nil {
+		pool := &PoolConfig{}
+		for _, url := range preset.URLs {
+			pool.Upstreams = append(pool.Upstreams, UpstreamConfig{URL: url})
+		}
+		config.Pools[config.Chain] = pool
+	}
+	config.DefaultPool = config.Chain
+	return nil
+}
+
+// verifyChainID checks that every configured upstream serves the configured chain.
+//
+// Parameters:
+//   - ctx: The parent context of the eth_chainId queries
+//
+// Returns:
+//   - error: An error listing the upstreams serving another chain
+func verifyChainID(ctx context.Context) error {
+	if config.Chain == "" {
+		return nil
+	}
+	preset := chainPresets[config.Chain]
+	want, err := parseQuantity(preset.ChainID)
+	if err != nil {
+		return err
+	}
+
+	targets := probeTargets()
+	var (
+		mu         sync.Mutex
+		mismatches []string
+		wg         sync.WaitGroup
+	)
+	for url, name := range targets {
+		wg.Add(1)
+		go func(u Upstream) {
+			defer wg.Done()
+			callCtx, cancel := context.WithTimeout(ctx, chainIDTimeout)
+			defer cancel()
+			raw, err := callUpstream(callCtx, u.URL, "eth_chainId", nil)
+			var got uint64
+			if err == nil {
+				got, err = parseQuantityResult(raw)
+			}
+			if err != nil {
+				log.Printf("Warning: could not verify the chain ID of %s: %v", upstreamLabel(u), err)
+				return
+			}
+			if got != want {
+				mu.Lock()
+				mismatches = append(mismatches, fmt.Sprintf("%s serves chain ID %d", upstreamLabel(u), got))
+				mu.Unlock()
+			}
+		}(Upstream{Name: name, URL: url})
+	}
+	wg.Wait()
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("expected chain ID %d (%s): %s", want, preset.Title, strings.Join(mismatches, "; "))
+	}
+	log.Printf("Verified that %d upstreams serve %s (chain ID %d)", len(targets), preset.Title, want)
+	return nil
+}
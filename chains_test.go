@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chainIDServer returns an upstream answering eth_chainId with the given chain ID.
+func chainIDServer(chainID string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":%q}`, chainID)
+	}))
+}
+
+// TestChainPresetDefaultPool tests that a chain without upstreams gets a pool of its public endpoints
+func TestChainPresetDefaultPool(t *testing.T) {
+	// Setup
+	config = Config{}
+	defer func() { config = Config{} }()
+
+	// Test
+	err := loadConfig(writeConfigFile(t, "chain: polygon\n"))
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load the configuration: %v", err)
+	}
+	pool := config.Pools["polygon"]
+	if config.DefaultPool != "polygon" || pool == nil || len(pool.Upstreams) != len(chainPresets["polygon"].URLs) {
+		t.Fatalf("Expected a default pool of the public endpoints, got %q %+v", config.DefaultPool, config.Pools)
+	}
+	if pool.Upstreams[0].URL != "https://polygon-rpc.com" {
+		t.Errorf("Expected the preferred endpoint first, got %s", pool.Upstreams[0].URL)
+	}
+}
+
+// TestChainPresetKeepsUpstreams tests that listed upstreams are kept and unknown chains rejected
+func TestChainPresetKeepsUpstreams(t *testing.T) {
+	// Setup
+	config = Config{}
+	defer func() { config = Config{} }()
+
+	// Test
+	err := loadConfig(writeConfigFile(t, "chain: linea\ndefault_url: https://rpc.example.com\n"))
+	pools := len(config.Pools)
+	config = Config{}
+	unknown := loadConfig(writeConfigFile(t, "chain: atlantis\n"))
+
+	// Verify
+	if err != nil || pools != 0 {
+		t.Errorf("Expected default_url to be kept without a pool, got %d pools, %v", pools, err)
+	}
+	if unknown == nil || !strings.Contains(unknown.Error(), "unknown chain") {
+		t.Errorf("Expected an unknown chain error, got %v", unknown)
+	}
+}
+
+// TestVerifyChainID tests that upstreams serving another chain are reported
+func TestVerifyChainID(t *testing.T) {
+	// Setup
+	linea := chainIDServer("0xe708")
+	defer linea.Close()
+	mainnet := chainIDServer("0x1")
+	defer mainnet.Close()
+	config = Config{Chain: "linea", DefaultURL: linea.URL, DefaultName: "linea"}
+	defer func() { config = Config{} }()
+
+	// Test
+	ok := verifyChainID(context.Background())
+	config.Routes = []Route{{Method: "eth_getLogs", URL: mainnet.URL, Name: "logs"}}
+	wrong := verifyChainID(context.Background())
+
+	// Verify
+	if ok != nil {
+		t.Errorf("Expected the chain ID to match, got %v", ok)
+	}
+	if wrong == nil || !strings.Contains(wrong.Error(), "logs serves chain ID 1") {
+		t.Errorf("Expected logs to be reported, got %v", wrong)
+	}
+}
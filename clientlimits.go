@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Per-client concurrency
+//
+// Rate limits bound how much a client asks for over time, not how much it holds at
+// once: a tenant sending a few slow debug traces a second stays within its rate yet
+// can fill the proxy's and upstreams' slots. Each client can also be capped in the
+// requests it has in flight:
+//
+//	concurrency:
+//	  per_client:
+//	    max_in_flight: 10      # requests in flight per client (0: unlimited)
+//	    clients:               # validated identities or client IPs with a cap of their own
+//	      "tenant-api-key": 50
+//	      "10.0.4.17": 0       # unlimited
+//
+// Clients are told apart by the identity auth validated (the token or API key, basic
+// auth user name, or hmac key ID), or by IP otherwise (see clients.go). An API key
+// that auth did not check is ignored, as a client could send a new one with each
+// request to get a cap of its own, and so are clients entries naming one. A client's
+// requests beyond its cap queue like requests beyond max_in_flight, with the same
+// max_queue and queue_timeout, and are rejected with HTTP 429 and a -32005 error when
+// the queue is full or the wait times out. Requests wait for their client's cap before
+// taking a proxy-wide slot, so a client at its cap never holds up others.
+
+// ClientConcurrencyConfig configures per-client concurrency caps.
+type ClientConcurrencyConfig struct {
+	MaxInFlight int            `yaml:"max_in_flight"` // Requests in flight per client (0: unlimited)
+	Clients     map[string]int `yaml:"clients"`       // Caps of particular validated identities or client IPs (0: unlimited)
+}
+
+// clientLimiter is a client's limiter, with the requests holding or waiting for its slots.
+type clientLimiter struct {
+	*limiter
+	users int
+}
+
+var (
+	clientLimitsMu sync.Mutex                // Protects clientLimiters
+	clientLimiters map[string]*clientLimiter // Limiters of the clients with requests in flight
+)
+
+// setupClientConcurrency validates the per-client caps.
+//
+// Parameters:
+//   - pc: The per-client settings, or nil if clients are not capped
+//
+// Returns:
+//   - error: An error if a cap is negative
+func setupClientConcurrency(pc *ClientConcurrencyConfig) error {
+	clientLimitsMu.Lock()
+	clientLimiters = make(map[string]*clientLimiter)
+	clientLimitsMu.Unlock()
+	if pc == nil {
+		return nil
+	}
+	if pc.MaxInFlight < 0 {
+		return fmt.Errorf("per_client.max_in_flight cannot be negative")
+	}
+	for _, client := range sortedKeys(pc.Clients) {
+		if pc.Clients[client] < 0 {
+			return fmt.Errorf("per_client.clients: cap of %s cannot be negative", fingerprint(client))
+		}
+	}
+	if pc.MaxInFlight > 0 {
+		log.Printf("Capping clients at %d requests in flight (%d client(s) with their own cap)", pc.MaxInFlight, len(pc.Clients))
+	} else {
+		log.Printf("Capping the requests in flight of %d client(s)", len(pc.Clients))
+	}
+	return nil
+}
+
+// clientCap returns the cap of a client, or 0 if it is unlimited.
+func clientCap(client clientInfo) int {
+	if config.Concurrency == nil || config.Concurrency.PerClient == nil {
+		return 0
+	}
+	pc := config.Concurrency.PerClient
+	if client.Authenticated != "" {
+		if size, ok := pc.Clients[client.Authenticated]; ok {
+			return size
+		}
+	}
+	if size, ok := pc.Clients[client.IP]; ok {
+		return size
+	}
+	return pc.MaxInFlight
+}
+
+// acquireClient takes one of the client's slots for a request, waiting in its queue if
+// none is free.
+//
+// Parameters:
+//   - ctx: The context of the waiting request
+//   - r: The request, identifying the client
+//   - prio: The priority of the request
+//
+// Returns:
+//   - func(): Releases the slot (a no-op when the client is unlimited)
+//   - error: errSaturated if the client has no capacity, or the context's error
+func acquireClient(ctx context.Context, r *http.Request, prio priority) (func(), error) {
+	client := clientFromRequest(r)
+	size := clientCap(client)
+	if size <= 0 {
+		return func() {}, nil
+	}
+
+	key := client.limitKey()
+	clientLimitsMu.Lock()
+	cl, ok := clientLimiters[key]
+	if !ok {
+		cl = &clientLimiter{limiter: newLimiter(size, config.Concurrency)}
+		clientLimiters[key] = cl
+	}
+	cl.users++
+	clientLimitsMu.Unlock()
+
+	// Forget the limiter once the client has no requests left, so that the map only
+	// holds active clients
+	done := func() {
+		clientLimitsMu.Lock()
+		defer clientLimitsMu.Unlock()
+		cl.users--
+		if cl.users == 0 && clientLimiters[key] == cl {
+			delete(clientLimiters, key)
+		}
+	}
+	if err := cl.acquire(ctx, prio); err != nil {
+		done()
+		return nil, fmt.Errorf("%w (client cap of %d)", err, size)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cl.release()
+			done()
+		})
+	}, nil
+}
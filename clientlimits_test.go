@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClientConcurrency tests capping the requests in flight of each client
+func TestClientConcurrency(t *testing.T) {
+	// Setup
+	config = Config{Concurrency: &ConcurrencyConfig{
+		QueueTimeout: 20 * time.Millisecond,
+		PerClient:    &ClientConcurrencyConfig{MaxInFlight: 1, Clients: map[string]int{"vip-key": 0}},
+	}}
+	defer func() {
+		config = Config{}
+		setupConcurrency()
+	}()
+	if err := setupConcurrency(); err != nil {
+		t.Fatalf("Failed to set up concurrency: %v", err)
+	}
+	request := func(ip, key string) func() {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = ip + ":4000"
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		if key == "vip-key" {
+			r = withAuthenticatedClient(r, key)
+		}
+		release, err := acquireClient(context.Background(), r, priorityNormal)
+		if err != nil {
+			if !errors.Is(err, errSaturated) {
+				t.Errorf("Expected a saturation error, got %v", err)
+			}
+			return nil
+		}
+		return release
+	}
+
+	// Test
+	first := request("192.0.2.1", "")
+	second := request("192.0.2.1", "")
+	other := request("192.0.2.2", "")
+	vip := []func(){request("192.0.2.1", "vip-key"), request("192.0.2.1", "vip-key")}
+	rotated := request("192.0.2.1", "unchecked-key")
+	first()
+	afterRelease := request("192.0.2.1", "")
+
+	// Verify
+	if first == nil || other == nil {
+		t.Fatalf("Expected the first request of each client to be admitted")
+	}
+	if second != nil {
+		t.Errorf("Expected a second request of a client at its cap to be rejected")
+	}
+	if rotated != nil {
+		t.Errorf("Expected an unvalidated API key to share its IP's cap")
+	}
+	if vip[0] == nil || vip[1] == nil {
+		t.Errorf("Expected a client with a cap of 0 to be unlimited")
+	}
+	if afterRelease == nil {
+		t.Fatalf("Expected the client to be admitted again once its request finished")
+	}
+	afterRelease()
+	other()
+	clientLimitsMu.Lock()
+	defer clientLimitsMu.Unlock()
+	if len(clientLimiters) != 0 {
+		t.Errorf("Expected the limiters of idle clients to be forgotten, got %d", len(clientLimiters))
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Client identity
+//
+// Several features need to tell clients apart: sticky load balancing, and later
+// per-client limits. A client is identified by its API key when it sends one, and by
+// its IP address otherwise. API keys are read from the X-API-Key header, a bearer
+// Authorization header, or the api_key query parameter. The IP is the connection's
+// remote address, or the first X-Forwarded-For entry when trust_forwarded_for is set
+// because the proxy runs behind a load balancer.
+//
+// trust_forwarded_for believes any peer, so a client reaching the proxy directly can
+// claim any IP. trusted_proxies restricts forwarding headers to the load balancers and
+// proxies in front of the proxy:
+//
+//	trusted_proxies: [10.0.0.0/8, 192.0.2.7]
+//
+// Requests from a trusted peer are attributed to the rightmost X-Forwarded-For entry
+// that is not itself a trusted proxy, which a client cannot forge by sending its own
+// header, or to X-Real-IP when X-Forwarded-For is absent. Requests from other peers
+// are attributed to the peer, whatever they send. trusted_proxies takes precedence
+// over trust_forwarded_for. The IP found is the one used by rate limits, client
+// priorities and ACLs, pools, and logs.
+
+// clientInfo identifies the client that sent a call.
+type clientInfo struct {
+	IP     string // Client IP address
+	APIKey string // API key sent by the client (empty if none)
+
+	Authenticated string // Identity validated by auth (empty if auth is off)
+}
+
+// key returns the client's identity: its API key if it sent one, its IP otherwise.
+func (c clientInfo) key() string {
+	if c.APIKey != "" {
+		return "key:" + c.APIKey
+	}
+	return "ip:" + c.IP
+}
+
+// limitKey returns the identity limits are charged to: the identity validated by
+// auth, or the client's IP, never an unvalidated API key.
+func (c clientInfo) limitKey() string {
+	if c.Authenticated != "" {
+		return "auth:" + c.Authenticated
+	}
+	return "ip:" + c.IP
+}
+
+// authenticatedClientKey is the context key of the identity validated by auth.
+type authenticatedClientKey struct{}
+
+// withAuthenticatedClient records the identity auth validated for a request.
+func withAuthenticatedClient(r *http.Request, identity string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatedClientKey{}, identity))
+}
+
+// clientFromRequest identifies the client of an HTTP request.
+func clientFromRequest(r *http.Request) clientInfo {
+	identity, _ := r.Context().Value(authenticatedClientKey{}).(string)
+	return clientInfo{IP: clientIP(r), APIKey: clientAPIKey(r), Authenticated: identity}
+}
+
+// trustedProxyNets are the peers whose forwarding headers are believed.
+var trustedProxyNets []*net.IPNet
+
+// setupTrustedProxies parses the trusted proxies.
+//
+// Returns:
+//   - error: An error if an entry is neither an IP nor a CIDR
+func setupTrustedProxies() error {
+	nets, err := parseNets("trusted_proxies", config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	trustedProxyNets = nets
+	if len(nets) > 0 {
+		log.Printf("Trusting forwarding headers from %d proxy address range(s)", len(nets))
+	}
+	return nil
+}
+
+// clientIP returns the client's IP address.
+func clientIP(r *http.Request) string {
+	if len(trustedProxyNets) > 0 {
+		return forwardedClientIP(r)
+	}
+	if config.TrustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+	return remoteIP(r)
+}
+
+// clientAPIKey returns the API key sent by the client, or an empty string.
+func clientAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// forwardedClientIP returns the client's IP address behind the trusted proxies.
+func forwardedClientIP(r *http.Request) string {
+	peer := remoteIP(r)
+	if !inNets(net.ParseIP(peer), trustedProxyNets) {
+		return peer
+	}
+	hops := r.Header.Values("X-Forwarded-For")
+	if len(hops) == 0 {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return peer
+	}
+
+	// Walk the chain back from the peer until a hop that is not a trusted proxy
+	client := peer
+	entries := strings.Split(strings.Join(hops, ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(entries[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !inNets(ip, trustedProxyNets) {
+			break
+		}
+	}
+	return client
+}
+
+// remoteIP returns the IP address of the connection's peer.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseNets parses a list of IPs and CIDRs.
+//
+// Parameters:
+//   - field: The configuration field of the list, for errors
+//   - entries: The IPs and CIDRs
+//
+// Returns:
+//   - []*net.IPNet: The networks, a single IP being a network of one address
+//   - error: An error if an entry is neither an IP nor a CIDR
+func parseNets(field string, entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for i, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %q is neither an IP nor a CIDR", field, i, entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// inNets reports whether an IP belongs to one of the networks.
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTrustedProxies tests believing forwarding headers only from trusted proxies
+func TestTrustedProxies(t *testing.T) {
+	// Setup
+	config = Config{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.7"}}
+	defer func() {
+		config = Config{}
+		trustedProxyNets = nil
+	}()
+	if err := setupTrustedProxies(); err != nil {
+		t.Fatalf("Failed to set up trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		peer     string
+		xff      []string
+		realIP   string
+		expected string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "", "203.0.113.5"},
+		{"direct client forging the header", "203.0.113.5:4000", []string{"198.51.100.1"}, "", "203.0.113.5"},
+		{"client behind the load balancer", "10.1.2.3:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"client prepending a forged entry", "10.1.2.3:4000", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"client behind two proxies", "10.1.2.3:4000", []string{"198.51.100.1, 192.0.2.7", "10.9.9.9"}, "", "198.51.100.1"},
+		{"chain of trusted proxies only", "10.1.2.3:4000", []string{"10.0.0.1"}, "", "10.0.0.1"},
+		{"malformed entry", "10.1.2.3:4000", []string{"garbage, 10.0.0.1"}, "", "10.0.0.1"},
+		{"X-Real-IP", "192.0.2.7:4000", nil, "198.51.100.2", "198.51.100.2"},
+		{"X-Real-IP from an untrusted peer", "203.0.113.5:4000", nil, "198.51.100.2", "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			r := httptest.NewRequest("POST", "/", nil)
+			r.RemoteAddr = tt.peer
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			// Verify
+			if got := clientIP(r); got != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// Verify invalid entries are rejected
+	config.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := setupTrustedProxies(); err == nil {
+		t.Errorf("Expected an error for an invalid CIDR")
+	}
+}
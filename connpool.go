@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Connection pool metrics
+//
+// Pooling settings such as MaxIdleConnsPerHost are hard to tune blind: too few idle
+// connections and every burst pays for new TCP and TLS handshakes, too many and
+// providers close them under the proxy. Each upstream request is traced, and its
+// connections are counted by upstream:
+//
+//	jsonrpc_proxy_upstream_connections{upstream="mainnet",state="active"} 12
+//	jsonrpc_proxy_upstream_connections{upstream="mainnet",state="idle"} 88
+//	jsonrpc_proxy_upstream_connections_used_total{upstream="mainnet",reused="true"} 41250
+//	jsonrpc_proxy_upstream_dial_errors_total{upstream="mainnet"} 3
+//	jsonrpc_proxy_upstream_dns_duration_seconds_bucket{upstream="mainnet",le="0.01"} 7
+//
+// Active connections carry a request, from the moment the request gets one until its
+// response body is closed. Open connections are counted for the upstream whose request
+// dialed them, and are idle when not active; they are only counted on the default
+// transport, egress proxies, TLS policies, and upstreams with timeouts, not on IPC
+// upstreams or transports registered by embedding programs, which report no idle
+// connections. The reuse ratio is
+//
+//	sum by (upstream) (rate(jsonrpc_proxy_upstream_connections_used_total{reused="true"}[5m]))
+//	  / sum by (upstream) (rate(jsonrpc_proxy_upstream_connections_used_total[5m]))
+//
+// DNS lookups are timed when a new connection resolves the upstream's host, through the
+// system resolver or the DNS cache (see dnscache.go).
+
+var (
+	connectionsUsed = newCounterVec("jsonrpc_proxy_upstream_connections_used_total", "Connections used by upstream requests, by whether they were reused.", "upstream", "reused")
+	dialErrors      = newCounterVec("jsonrpc_proxy_upstream_dial_errors_total", "Failed connection attempts to upstreams.", "upstream")
+	dnsDuration     = newHistogramVec("jsonrpc_proxy_upstream_dns_duration_seconds", "Time to resolve upstream hosts for new connections.", durationBuckets, "upstream")
+)
+
+// connCounts counts the connections of an upstream.
+type connCounts struct {
+	label  string
+	open   int64 // Connections dialed by the upstream's requests and not closed
+	active int64 // Connections carrying a request
+}
+
+var (
+	connCountsMu      sync.Mutex
+	connCountsByURL   = make(map[string]*connCounts)
+	connPoolCollected sync.Once
+)
+
+type connCountsKey struct{}
+
+// connCountsFor returns the connection counts of an upstream, creating them on first use.
+func connCountsFor(url string) *connCounts {
+	connPoolCollected.Do(func() { registerMetric(connPoolCollector{}) })
+	connCountsMu.Lock()
+	defer connCountsMu.Unlock()
+	cc, ok := connCountsByURL[url]
+	if !ok {
+		cc = &connCounts{label: upstreamLabel(Upstream{Name: probeTargets()[url], URL: url})}
+		connCountsByURL[url] = cc
+	}
+	return cc
+}
+
+// traceConnections traces the connection use of a request to an upstream.
+//
+// Parameters:
+//   - ctx: The request's context
+//   - url: The upstream URL
+//
+// Returns:
+//   - context.Context: The context to send the request with
+//   - func(): Ends the request's use of its connection, once the response body is closed
+func traceConnections(ctx context.Context, url string) (context.Context, func()) {
+	cc := connCountsFor(url)
+	var mu sync.Mutex
+	holding := false
+	var dnsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			start := dnsStart
+			mu.Unlock()
+			if !start.IsZero() {
+				dnsDuration.observe(time.Since(start).Seconds(), cc.label)
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				dialErrors.inc(cc.label)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			connectionsUsed.inc(cc.label, fmt.Sprint(info.Reused))
+			mu.Lock()
+			defer mu.Unlock()
+			if !holding {
+				// Retries reuse the trace; the request holds one connection at a time
+				holding = true
+				connCountsMu.Lock()
+				cc.active++
+				connCountsMu.Unlock()
+			}
+		},
+	}
+	done := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if holding {
+			holding = false
+			connCountsMu.Lock()
+			cc.active--
+			connCountsMu.Unlock()
+		}
+	}
+	ctx = context.WithValue(ctx, connCountsKey{}, cc)
+	return httptrace.WithClientTrace(ctx, trace), done
+}
+
+// countConnections makes a transport count the connections it dials for upstream
+// requests as open until they are closed.
+func countConnections(t *http.Transport) *http.Transport {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		cc, ok := ctx.Value(connCountsKey{}).(*connCounts)
+		if err != nil || !ok {
+			return conn, err
+		}
+		if _, counted := conn.(*countedConn); counted {
+			return conn, nil
+		}
+		connCountsMu.Lock()
+		cc.open++
+		connCountsMu.Unlock()
+		return &countedConn{Conn: conn, counts: cc}, nil
+	}
+	return t
+}
+
+// countedConn is an upstream connection counted as open until closed.
+type countedConn struct {
+	net.Conn
+	counts *connCounts
+	once   sync.Once
+}
+
+// Close implements net.Conn.
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		connCountsMu.Lock()
+		c.counts.open--
+		connCountsMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// connPoolCollector exports the active and idle connections of the upstreams.
+type connPoolCollector struct{}
+
+// write implements metricsCollector.
+func (connPoolCollector) write(w io.Writer, openMetrics bool) {
+	connCountsMu.Lock()
+	defer connCountsMu.Unlock()
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_upstream_connections Open upstream connections, by state.\n# TYPE jsonrpc_proxy_upstream_connections gauge\n")
+	labels := []string{"upstream", "state"}
+	for _, url := range sortedKeys(connCountsByURL) {
+		cc := connCountsByURL[url]
+		idle := max(cc.open-cc.active, 0)
+		fmt.Fprintf(w, "jsonrpc_proxy_upstream_connections%s %d\n", encodeLabels(labels, []string{cc.label, "active"}), cc.active)
+		fmt.Fprintf(w, "jsonrpc_proxy_upstream_connections%s %d\n", encodeLabels(labels, []string{cc.label, "idle"}), idle)
+	}
+}
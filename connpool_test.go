@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestConnectionPoolMetrics tests that upstream connections are counted as active, idle,
+// and reused, and that failed dials are counted.
+func TestConnectionPoolMetrics(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+	config = Config{DefaultURL: server.URL, DefaultName: "pooled"}
+	defer func() {
+		config = Config{}
+		defaultTransport = http.DefaultTransport
+	}()
+	if err := setupEgressProxies(); err != nil {
+		t.Fatalf("Failed to set up the default transport: %v", err)
+	}
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	send := func() *http.Response {
+		resp, err := forwardRequest(context.Background(), server.URL, body)
+		if err != nil {
+			t.Fatalf("Failed to forward: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		return resp
+	}
+	gauges := func() string {
+		var buf bytes.Buffer
+		connPoolCollector{}.write(&buf, false)
+		return buf.String()
+	}
+
+	// Test
+	first := send()
+	whileActive := gauges()
+	first.Body.Close()
+	send().Body.Close()
+	afterwards := gauges()
+	_, dialErr := forwardRequest(context.Background(), closed.URL, body)
+
+	// Verify
+	if !strings.Contains(whileActive, `jsonrpc_proxy_upstream_connections{upstream="pooled",state="active"} 1`) {
+		t.Errorf("Expected 1 active connection while a response is read, got:\n%s", whileActive)
+	}
+	if !strings.Contains(afterwards, `jsonrpc_proxy_upstream_connections{upstream="pooled",state="active"} 0`) ||
+		!strings.Contains(afterwards, `jsonrpc_proxy_upstream_connections{upstream="pooled",state="idle"} 1`) {
+		t.Errorf("Expected 1 idle connection once the responses are closed, got:\n%s", afterwards)
+	}
+	if got := connectionsUsed.value("pooled", "true"); got < 1 {
+		t.Errorf("Expected the second request to reuse the connection, got %v reused", got)
+	}
+	if dialErr == nil || dialErrors.value(upstreamLabel(Upstream{URL: closed.URL})) < 1 {
+		t.Errorf("Expected a dial error to be counted, got %v", dialErr)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Routing debug header
+//
+// Working out why a call went to a given upstream means reading the routes, the write
+// and private transaction settings, the hooks, and the cache. A request can ask the
+// proxy to explain instead, by sending X-Debug-Route: 1 along with the admin token
+// (see admin.go), either in X-Admin-Token or as the Authorization bearer token:
+//
+//	curl -H 'X-Debug-Route: 1' -H "X-Admin-Token: $ADMIN_TOKEN" \
+//	  -d '{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[...]}' http://proxy:8545
+//
+// The response then carries one X-Debug-Route header per call, in the order the calls
+// were routed:
+//
+//	X-Debug-Route: method="eth_call" rule="archive-calls" upstream="archive" retries=1 cache="MISS"
+//
+// rule is the route that matched the call (its name, or its method pattern), "default"
+// for the default route, "local" for calls answered by the proxy itself, or "forced"
+// for calls pinned to an upstream with X-Force-Upstream (see forceupstream.go).
+// upstream is the upstream the call was sent to, shown like X-Upstream, or "stub" for
+// stubbed calls. retries counts the retries of the call's upstream request, and cache
+// is its X-Cache status when caching is enabled. Streamed batch responses (see
+// batchstream.go) send their headers before the upstreams answer, so they report no
+// retries.
+//
+// Without the admin API enabled, or without a valid token, the header is ignored.
+
+const debugRouteHeader = "X-Debug-Route"
+
+// routeDecision describes how a call was routed.
+type routeDecision struct {
+	method   string
+	rule     string
+	upstream string
+	url      string
+	retries  int
+	cache    string
+}
+
+// routeTrace collects the routing decisions of a request.
+type routeTrace struct {
+	mu    sync.Mutex
+	calls []*routeDecision
+}
+
+type routeTraceKey struct{}
+
+// routeDebugRequested reports whether a request asks for the routing debug header and
+// carries the admin token.
+func routeDebugRequested(r *http.Request) bool {
+	v := r.Header.Get(debugRouteHeader)
+	return (v == "1" || v == "true") && operatorAuthorized(r)
+}
+
+// withRouteDebug wraps a proxy handler to add X-Debug-Route headers to the responses
+// of requests asking for them.
+func withRouteDebug(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !routeDebugRequested(r) {
+			next(w, r)
+			return
+		}
+		trace := &routeTrace{}
+		r = r.WithContext(context.WithValue(r.Context(), routeTraceKey{}, trace))
+		next(&routeDebugResponse{ResponseWriter: w, trace: trace}, r)
+	}
+}
+
+// noteRoute records the routing decision of a call, if the request asked for it.
+//
+// Parameters:
+//   - ctx: The context of the request
+//   - method: The called method
+//   - rule: The rule that routed the call
+//   - upstream: The upstream the call is sent to
+//   - cache: The call's X-Cache status, or "" if caching is off
+func noteRoute(ctx context.Context, method, rule string, upstream Upstream, cache string) {
+	trace, ok := ctx.Value(routeTraceKey{}).(*routeTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.calls = append(trace.calls, &routeDecision{
+		method:   method,
+		rule:     rule,
+		upstream: upstreamLabel(upstream),
+		url:      upstream.URL,
+		cache:    cache,
+	})
+}
+
+// updateRoutes applies a change to the recorded decisions of the calls sent to an
+// upstream, if the request asked for them.
+func updateRoutes(ctx context.Context, url string, update func(d *routeDecision)) {
+	trace, ok := ctx.Value(routeTraceKey{}).(*routeTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	for _, d := range trace.calls {
+		if d.url == url {
+			update(d)
+		}
+	}
+}
+
+// noteRetry records a retry of a request to an upstream.
+func noteRetry(ctx context.Context, url string) {
+	updateRoutes(ctx, url, func(d *routeDecision) { d.retries++ })
+}
+
+// noteRouteCache records the cache status of the calls sent to an upstream.
+func noteRouteCache(ctx context.Context, url, status string) {
+	updateRoutes(ctx, url, func(d *routeDecision) { d.cache = status })
+}
+
+// routeDebugResponse adds the X-Debug-Route headers when the response is started.
+type routeDebugResponse struct {
+	http.ResponseWriter
+	trace   *routeTrace
+	written bool
+}
+
+// addHeaders adds a header per recorded decision, once.
+func (d *routeDebugResponse) addHeaders() {
+	if d.written {
+		return
+	}
+	d.written = true
+	d.trace.mu.Lock()
+	defer d.trace.mu.Unlock()
+	for _, c := range d.trace.calls {
+		value := fmt.Sprintf("method=%q rule=%q upstream=%q retries=%d", c.method, c.rule, c.upstream, c.retries)
+		if c.cache != "" {
+			value += fmt.Sprintf(" cache=%q", c.cache)
+		}
+		d.Header().Add(debugRouteHeader, value)
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (d *routeDebugResponse) WriteHeader(status int) {
+	d.addHeaders()
+	d.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (d *routeDebugResponse) Write(p []byte) (int, error) {
+	d.addHeaders()
+	return d.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (d *routeDebugResponse) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// ruleName returns the rule reported for a routed call.
+func ruleName(route *Route, forced bool) string {
+	if forced {
+		return "forced"
+	}
+	return routeName(route)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRouteDebugHeader tests that authorized requests are told how their calls were routed
+func TestRouteDebugHeader(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"}]`))
+	}))
+	defer server.Close()
+	calls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":2,"result":"0x2"}]`))
+	}))
+	defer calls.Close()
+	config = Config{
+		DefaultURL: server.URL,
+		Routes:     []Route{{Method: "eth_call", URL: calls.URL, Name: "archive"}},
+		Admin:      &AdminConfig{Enabled: true, Token: "s3cret"},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	handler := withRouteDebug(handleProxy)
+	request := func(headers map[string]string) []string {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[]}]`))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Header().Values(debugRouteHeader)
+	}
+
+	// Test
+	debugged := request(map[string]string{debugRouteHeader: "1", adminTokenHeader: "s3cret"})
+	bearer := request(map[string]string{debugRouteHeader: "1", "Authorization": "Bearer s3cret"})
+	wrongToken := request(map[string]string{debugRouteHeader: "1", adminTokenHeader: "nope"})
+	plain := request(nil)
+
+	// Verify
+	expected := []string{
+		`method="eth_blockNumber" rule="default" upstream="default" retries=0`,
+		`method="eth_call" rule="archive" upstream="archive" retries=0`,
+	}
+	if strings.Join(debugged, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, debugged)
+	}
+	if len(bearer) != 2 {
+		t.Errorf("Expected the admin bearer token to be accepted, got %q", bearer)
+	}
+	if len(wrongToken) != 0 || len(plain) != 0 {
+		t.Errorf("Expected no debug headers without the admin token, got %q and %q", wrongToken, plain)
+	}
+}
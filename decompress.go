@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compressed upstream responses
+//
+// Large results such as logs, blocks with transactions, and traces compress several-fold,
+// so the proxy asks upstreams for gzip-encoded responses. Go's client would do so by
+// itself, but only on its default transports, and always decompressing. Instead,
+// Accept-Encoding: gzip is sent on every upstream request, unless header rules set
+// Accept-Encoding, and gzip-encoded responses are decompressed as they are read
+// whenever the proxy needs to see them: batches, cached methods, response transforms,
+// mirror diffs, payload logging, and PostResponse hooks.
+//
+// On the fast path, a single call whose response nothing inspects, from a client
+// accepting gzip, gets the upstream's compressed bytes untouched, with their
+// Content-Encoding and Content-Length. The JSON-RPC error codes counted in
+// jsonrpc_proxy_upstream_errors_total are then read from the decompressed start of the
+// response. Responses are counted by upstream and handling, decompressed or passthrough,
+// in jsonrpc_proxy_upstream_compressed_responses_total.
+
+// upstreamCompressed counts gzip-encoded upstream responses.
+var upstreamCompressed = newCounterVec("jsonrpc_proxy_upstream_compressed_responses_total", "Gzip-encoded upstream responses, by handling.", "upstream", "handling")
+
+type compressedPassthroughKey struct{}
+
+// withCompressedPassthrough returns a context telling forwardRequest whether to keep a
+// gzip-encoded response compressed for the client.
+func withCompressedPassthrough(ctx context.Context, on bool) context.Context {
+	return context.WithValue(ctx, compressedPassthroughKey{}, on)
+}
+
+// acceptsGzip reports whether a client accepts gzip-encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// requestCompression asks the upstream for a gzip-encoded response, unless header rules
+// set Accept-Encoding.
+func requestCompression(req *http.Request) {
+	if _, set := req.Header["Accept-Encoding"]; !set {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+}
+
+// gzipEncoded reports whether a response is gzip-encoded.
+func gzipEncoded(h http.Header) bool {
+	return strings.EqualFold(h.Get("Content-Encoding"), "gzip")
+}
+
+// decodeResponse decompresses a gzip-encoded response as it is read, unless the
+// request's context asks to keep it compressed.
+func decodeResponse(ctx context.Context, url string, resp *http.Response) {
+	if !gzipEncoded(resp.Header) || resp.Body == http.NoBody {
+		return
+	}
+	label := upstreamLabel(Upstream{URL: url})
+	if passthrough, _ := ctx.Value(compressedPassthroughKey{}).(bool); passthrough && !hasPostResponseHooks() {
+		upstreamCompressed.inc(label, "passthrough")
+		return
+	}
+	upstreamCompressed.inc(label, "decompressed")
+	resp.Body = &gzipBody{body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// gzipBody decompresses a response body, reading the gzip header on first use.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+// Read implements io.Reader.
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+// Close implements io.Closer.
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// decompressPrefix returns as much of a gzip stream as can be decompressed from its
+// first bytes.
+func decompressPrefix(data []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	out, _ := io.ReadAll(io.LimitReader(zr, errorPrefixSize))
+	return out
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipServer returns an upstream answering every request with a gzip-encoded result
+// when the request accepts gzip.
+func gzipServer(accepted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*accepted = append(*accepted, r.Header.Get("Accept-Encoding"))
+		body, _ := io.ReadAll(r.Body)
+		response := `{"jsonrpc":"2.0","id":1,"result":"0x2a"}`
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			response = `[` + response + `]`
+		}
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(response))
+			return
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(response))
+		zw.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+}
+
+// TestCompressedResponses tests that compressed responses are passed through on the fast
+// path and decompressed where the proxy inspects them.
+func TestCompressedResponses(t *testing.T) {
+	// Setup
+	var accepted []string
+	server := gzipServer(&accepted)
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{Methods: []string{"eth_chainId"}}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+	}()
+	request := func(body, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handleProxy(w, r)
+		return w
+	}
+
+	// Test
+	compressed := request(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, "gzip, deflate")
+	plain := request(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, "")
+	refused := request(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`, "gzip;q=0")
+	cached := request(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`, "gzip")
+	batch := request(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}]`, "gzip")
+
+	// Verify
+	for i, got := range accepted {
+		if got != "gzip" {
+			t.Errorf("Expected request %d to accept gzip, got %q", i, got)
+		}
+	}
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the fast path to keep the response compressed, got headers %v", compressed.Header())
+	}
+	zr, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatalf("Failed to read the compressed response: %v", err)
+	}
+	if body, _ := io.ReadAll(zr); !strings.Contains(string(body), `"0x2a"`) {
+		t.Errorf("Expected the compressed result, got %s", body)
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{"client not accepting gzip": plain, "client refusing gzip": refused, "cached method": cached, "batch": batch} {
+		if w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), `"0x2a"`) {
+			t.Errorf("Expected a decompressed response for the %s, got %v %q", name, w.Header(), w.Body.String())
+		}
+	}
+	if got := upstreamCompressed.value(upstreamLabel(Upstream{URL: server.URL}), "passthrough"); got != 1 {
+		t.Errorf("Expected 1 response passed through compressed, got %v", got)
+	}
+}
+
+// TestAcceptsGzip tests the parsing of Accept-Encoding.
+func TestAcceptsGzip(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"br, GZIP":             true,
+		"gzip;q=0.5, br":       true,
+		"gzip; q=0":            false,
+		"deflate, br":          false,
+		"x-gzip-not-supported": false,
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != expected {
+			t.Errorf("Expected %v for Accept-Encoding %q, got %v", expected, header, got)
+		}
+	}
+}
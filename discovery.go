@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kubernetes discovery
+//
+// A pool can discover its members from Kubernetes instead of listing them, so
+// self-hosted node pools scale without config edits. The proxy lists the Endpoints
+// objects matching a label selector (Endpoints carry the labels of their Service) or a
+// Service name, and watches them for changes. Every ready address becomes a member,
+// reached on the configured port. Discovered members use the default transport.
+//
+// The Kubernetes API is reached with the pod's service account, which needs permission
+// to list and watch endpoints in the namespace. When the watch breaks, the proxy lists
+// again after a short delay; members are kept as they were meanwhile.
+
+// DiscoveryConfig configures runtime discovery of pool members.
+type DiscoveryConfig struct {
+	Kubernetes *KubernetesDiscovery `yaml:"kubernetes"` // Discover members from Kubernetes Endpoints
+}
+
+// KubernetesDiscovery selects the Endpoints whose addresses become pool members.
+type KubernetesDiscovery struct {
+	Namespace     string `yaml:"namespace"`      // Namespace (default: the proxy's own)
+	LabelSelector string `yaml:"label_selector"` // Label selector of the Endpoints, e.g. "app=geth"
+	Service       string `yaml:"service"`        // Service name, as an alternative to a label selector
+	Port          string `yaml:"port"`           // Port name or number (default: the first port)
+	Scheme        string `yaml:"scheme"`         // URL scheme (default: http)
+	Path          string `yaml:"path"`           // URL path (default: none)
+	APIServer     string `yaml:"api_server"`     // API server URL (default: in-cluster)
+}
+
+// serviceAccountDir holds the in-cluster service account's token, CA, and namespace.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// validate checks the discovery configuration.
+func (d *DiscoveryConfig) validate() error {
+	k := d.Kubernetes
+	if k == nil {
+		return fmt.Errorf("discovery needs a kubernetes section")
+	}
+	if k.LabelSelector == "" && k.Service == "" {
+		return fmt.Errorf("discovery.kubernetes needs a label_selector or a service")
+	}
+	return nil
+}
+
+// kubeEndpoints is the part of a Kubernetes Endpoints object the proxy uses.
+type kubeEndpoints struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP        string `json:"ip"`
+			TargetRef *struct {
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// kubeDiscoverer keeps a pool's members in sync with Kubernetes Endpoints.
+type kubeDiscoverer struct {
+	pool      string
+	cfg       *KubernetesDiscovery
+	target    *PoolConfig
+	client    *http.Client
+	base      string // API server URL
+	tokenFile string
+
+	mu      sync.Mutex
+	objects map[string][]UpstreamConfig // Members by Endpoints object name
+}
+
+// startDiscovery starts discovery for every pool that uses it.
+//
+// Parameters:
+//   - ctx: The context that stops discovery when cancelled
+//
+// Returns:
+//   - error: An error if the Kubernetes API cannot be configured
+func startDiscovery(ctx context.Context) error {
+	for name, pool := range config.Pools {
+		if pool == nil || pool.Discovery == nil || pool.Discovery.Kubernetes == nil {
+			continue
+		}
+		d, err := newKubeDiscoverer(name, pool)
+		if err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+		registerDependency("kubernetes pool "+name, "discovery", "pool members are not updated")
+		go d.run(ctx)
+	}
+	return nil
+}
+
+// newKubeDiscoverer configures access to the Kubernetes API for a pool.
+func newKubeDiscoverer(name string, pool *PoolConfig) (*kubeDiscoverer, error) {
+	cfg := pool.Discovery.Kubernetes
+	d := &kubeDiscoverer{pool: name, cfg: cfg, target: pool, client: &http.Client{}, base: cfg.APIServer, objects: make(map[string][]UpstreamConfig)}
+
+	if d.base == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in Kubernetes; set discovery.kubernetes.api_server")
+		}
+		d.base = "https://" + net.JoinHostPort(host, port)
+		ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+		if err != nil {
+			return nil, fmt.Errorf("reading service account CA: %w", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca)
+		d.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		d.tokenFile = serviceAccountDir + "/token"
+	}
+	if cfg.Namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("discovery.kubernetes.namespace is required outside the cluster")
+		}
+		cfg.Namespace = strings.TrimSpace(string(ns))
+	}
+	return d, nil
+}
+
+// run lists and watches the Endpoints until ctx is done.
+func (d *kubeDiscoverer) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		version, err := d.list(ctx)
+		if err == nil {
+			reportDependency("kubernetes pool "+d.pool, nil)
+			err = d.watch(ctx, version)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			reportDependency("kubernetes pool "+d.pool, err)
+			logWarn("discovery", "Kubernetes discovery for pool %s failed: %v", d.pool, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// list fetches the matching Endpoints and replaces the pool's members.
+//
+// Returns:
+//   - string: The resource version to watch from
+//   - error: An error if the API call fails
+func (d *kubeDiscoverer) list(ctx context.Context) (string, error) {
+	resp, err := d.get(ctx, false, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeEndpoints `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("decoding endpoints: %w", err)
+	}
+
+	d.mu.Lock()
+	d.objects = make(map[string][]UpstreamConfig)
+	for _, ep := range list.Items {
+		d.objects[ep.Metadata.Name] = d.membersOf(ep)
+	}
+	d.mu.Unlock()
+	d.publish()
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch applies Endpoints changes until the watch ends.
+func (d *kubeDiscoverer) watch(ctx context.Context, version string) error {
+	resp, err := d.get(ctx, true, version)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("decoding watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+		var ep kubeEndpoints
+		if err := json.Unmarshal(event.Object, &ep); err != nil {
+			return fmt.Errorf("decoding endpoints: %w", err)
+		}
+
+		d.mu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			d.objects[ep.Metadata.Name] = d.membersOf(ep)
+		case "DELETED":
+			delete(d.objects, ep.Metadata.Name)
+		}
+		d.mu.Unlock()
+		if event.Type != "BOOKMARK" {
+			d.publish()
+		}
+	}
+	return scanner.Err()
+}
+
+// get calls the Endpoints API, listing or watching.
+func (d *kubeDiscoverer) get(ctx context.Context, watch bool, version string) (*http.Response, error) {
+	query := url.Values{}
+	if d.cfg.LabelSelector != "" {
+		query.Set("labelSelector", d.cfg.LabelSelector)
+	}
+	if d.cfg.Service != "" {
+		query.Set("fieldSelector", "metadata.name="+d.cfg.Service)
+	}
+	if watch {
+		query.Set("watch", "true")
+		query.Set("allowWatchBookmarks", "true")
+		query.Set("resourceVersion", version)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/endpoints?%s", strings.TrimSuffix(d.base, "/"), url.PathEscape(d.cfg.Namespace), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.tokenFile != "" {
+		// Re-read the token on every call, since the kubelet rotates it
+		token, err := os.ReadFile(d.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// membersOf returns the pool members for the ready addresses of an Endpoints object.
+func (d *kubeDiscoverer) membersOf(ep kubeEndpoints) []UpstreamConfig {
+	var members []UpstreamConfig
+	for _, subset := range ep.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if d.cfg.Port == "" || p.Name == d.cfg.Port || strconv.Itoa(p.Port) == d.cfg.Port {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		scheme := d.cfg.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		for _, addr := range subset.Addresses {
+			name := addr.IP
+			if addr.TargetRef != nil && addr.TargetRef.Name != "" {
+				name = addr.TargetRef.Name
+			}
+			members = append(members, UpstreamConfig{
+				URL:  scheme + "://" + net.JoinHostPort(addr.IP, strconv.Itoa(port)) + d.cfg.Path,
+				Name: d.pool + "/" + name,
+			})
+		}
+	}
+	return members
+}
+
+// publish replaces the pool's members with the discovered ones.
+func (d *kubeDiscoverer) publish() {
+	d.mu.Lock()
+	var members []UpstreamConfig
+	for _, list := range d.objects {
+		members = append(members, list...)
+	}
+	d.mu.Unlock()
+
+	// Keep a stable order so round-robin and logs do not jump around
+	sort.Slice(members, func(i, j int) bool { return members[i].URL < members[j].URL })
+	if d.target.setSourceMembers("kubernetes", members) {
+		logInfo("discovery", "Pool %s now has %d members discovered from Kubernetes", d.pool, len(members))
+	}
+}
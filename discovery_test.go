@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestKubernetesDiscovery tests discovering pool members from Endpoints and watching changes
+func TestKubernetesDiscovery(t *testing.T) {
+	// Setup: an API server listing one Endpoints object, then reporting a new address
+	endpoints := func(ips ...string) string {
+		addrs := ""
+		for i, ip := range ips {
+			if i > 0 {
+				addrs += ","
+			}
+			addrs += fmt.Sprintf(`{"ip":%q,"targetRef":{"name":"geth-%d"}}`, ip, i)
+		}
+		return `{"metadata":{"name":"geth"},"subsets":[{"addresses":[` + addrs + `],"ports":[{"name":"metrics","port":6060},{"name":"rpc","port":8545}]}]}`
+	}
+	var query string
+	modified := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/eth/endpoints" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("watch") != "true" {
+			query = r.URL.Query().Get("labelSelector")
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"10"},"items":[%s]}`, endpoints("10.0.0.1"))
+			return
+		}
+		<-modified
+		fmt.Fprintf(w, `{"type":"MODIFIED","object":%s}`+"\n", endpoints("10.0.0.1", "10.0.0.2"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer api.Close()
+
+	pool := &PoolConfig{Discovery: &DiscoveryConfig{Kubernetes: &KubernetesDiscovery{
+		Namespace: "eth", LabelSelector: "app=geth", Port: "rpc", APIServer: api.URL,
+	}}}
+	config = Config{DefaultPool: "nodes", Pools: map[string]*PoolConfig{"nodes": pool}}
+	defer func() { config = Config{} }()
+	if err := validatePools(); err != nil {
+		t.Fatalf("Invalid pools: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Test
+	if err := startDiscovery(ctx); err != nil {
+		t.Fatalf("Failed to start discovery: %v", err)
+	}
+
+	// Verify the listed address becomes a member on the named port
+	waitMembers(t, pool, 1)
+	if got := pool.members()[0]; got.URL != "http://10.0.0.1:8545" || got.Name != "nodes/geth-0" {
+		t.Errorf("Unexpected member %+v", got)
+	}
+	if query != "app=geth" {
+		t.Errorf("Expected the label selector to be sent, got %q", query)
+	}
+
+	// Verify watched changes update the members
+	close(modified)
+	waitMembers(t, pool, 2)
+	upstream, err := pickPoolMember("nodes", &JSONRPCRequest{Method: "eth_call"})
+	if err != nil || upstream.URL == "" {
+		t.Errorf("Expected a discovered member to be picked, got %+v, %v", upstream, err)
+	}
+}
+
+// waitMembers waits until a pool has n members.
+func waitMembers(t *testing.T, pool *PoolConfig, n int) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if len(pool.members()) == n {
+			return
+		}
+	}
+	t.Fatalf("Expected %d members, got %+v", n, pool.members())
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream DNS caching
+//
+// Go resolves the upstream's host for every new connection, through whatever the system
+// resolver caches, and keeps pooled connections to the old addresses of a provider
+// that rotated its IPs. Upstream hosts can instead be resolved through a cache of the
+// proxy's own, re-resolved in the background:
+//
+//	dns_cache:
+//	  ttl: 1m   # how long addresses are used before they are resolved again (default: 1m)
+//
+// A host is resolved on its first connection, then every ttl in the background. Until
+// a lookup succeeds, the previous addresses stay in use, so a resolver outage does not
+// take the upstreams down with it. New connections try the addresses in turn, starting
+// with the next one each time, and fall back to the others when one fails. When a
+// host's addresses change, idle connections are closed so that new ones go to the new
+// addresses; busy ones finish their requests first.
+//
+// The cache applies to the default transport, egress proxies (resolving the proxy's
+// host), TLS policies, and upstreams with timeouts, but not to transports registered by
+// embedding programs. Lookups are counted in jsonrpc_proxy_dns_lookups_total, by host and
+// result: resolved, changed, or failed.
+
+// DNSCacheConfig configures the cache of upstream DNS lookups.
+type DNSCacheConfig struct {
+	TTL time.Duration `yaml:"ttl"` // How long addresses are used before they are resolved again (default: 1m)
+}
+
+const (
+	defaultDNSTTL = time.Minute
+	dnsTimeout    = 5 * time.Second // Longest wait for a lookup
+)
+
+// dnsLookups counts upstream DNS lookups.
+var dnsLookups = newCounterVec("jsonrpc_proxy_dns_lookups_total", "Upstream DNS lookups, by host and result.", "host", "result")
+
+// lookupHost resolves a host; tests replace it.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// dnsCache holds the resolved addresses of upstream hosts, or is nil when off.
+var dnsCache *hostCache
+
+// hostCache caches resolved addresses by host.
+type hostCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	hosts      map[string]*hostEntry
+	transports []*http.Transport // Transports dialing through the cache, whose idle connections are closed on changes
+}
+
+// hostEntry holds the addresses of a host.
+type hostEntry struct {
+	addrs []string
+	next  int // Address tried first by the next connection
+}
+
+// setupDNSCache creates the DNS cache. It is called before the transports are built.
+//
+// Returns:
+//   - error: An error if ttl is negative
+func setupDNSCache() error {
+	dnsCache = nil
+	dc := config.DNSCache
+	if dc == nil {
+		return nil
+	}
+	if dc.TTL < 0 {
+		return fmt.Errorf("ttl cannot be negative")
+	}
+	ttl := dc.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+	dnsCache = &hostCache{ttl: ttl, hosts: make(map[string]*hostEntry)}
+	log.Printf("Caching upstream DNS lookups for %v", ttl)
+	return nil
+}
+
+// cachedDialer returns the dial function of a transport: the dialer's own, or one
+// resolving hosts through the DNS cache when it is on.
+func cachedDialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	hc := dnsCache
+	if hc == nil {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+		addrs, err := hc.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range addrs {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, firstErr
+	}
+}
+
+// withDNSCache makes a transport resolve hosts through the DNS cache, when it is on.
+// The transport's idle connections are closed when a host's addresses change.
+func withDNSCache(t *http.Transport) *http.Transport {
+	hc := dnsCache
+	if hc == nil {
+		return t
+	}
+	t.DialContext = cachedDialer(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	trackDNSTransport(t)
+	return t
+}
+
+// trackDNSTransport has a transport's idle connections closed when a host's addresses
+// change.
+func trackDNSTransport(t *http.Transport) {
+	hc := dnsCache
+	if hc == nil {
+		return
+	}
+	hc.mu.Lock()
+	hc.transports = append(hc.transports, t)
+	hc.mu.Unlock()
+}
+
+// resolve returns the addresses of a host, in the order to try them, resolving the host
+// on first use.
+func (hc *hostCache) resolve(ctx context.Context, host string) ([]string, error) {
+	hc.mu.Lock()
+	entry, ok := hc.hosts[host]
+	hc.mu.Unlock()
+	if !ok {
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		err := hc.refresh(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	entry = hc.hosts[host]
+	addrs := make([]string, 0, len(entry.addrs))
+	for i := range entry.addrs {
+		addrs = append(addrs, entry.addrs[(entry.next+i)%len(entry.addrs)])
+	}
+	entry.next = (entry.next + 1) % len(entry.addrs)
+	return addrs, nil
+}
+
+// refresh resolves a host again, keeping its previous addresses if the lookup fails,
+// and closes idle connections if its addresses changed.
+func (hc *hostCache) refresh(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses for %s", host)
+	}
+	if err != nil {
+		dnsLookups.inc(host, "failed")
+		hc.mu.Lock()
+		_, known := hc.hosts[host]
+		hc.mu.Unlock()
+		if known {
+			logWarn("discovery", "Failed to resolve %s, keeping its previous addresses: %v", host, err)
+			return nil
+		}
+		return err
+	}
+	sort.Strings(addrs)
+
+	hc.mu.Lock()
+	entry, known := hc.hosts[host]
+	changed := known && strings.Join(entry.addrs, ",") != strings.Join(addrs, ",")
+	if !known || changed {
+		hc.hosts[host] = &hostEntry{addrs: addrs}
+	}
+	transports := hc.transports
+	hc.mu.Unlock()
+
+	if !changed {
+		dnsLookups.inc(host, "resolved")
+		return nil
+	}
+	dnsLookups.inc(host, "changed")
+	logInfo("discovery", "Addresses of %s changed to %v, closing idle connections", host, addrs)
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+// startDNSRefresh re-resolves the cached hosts every ttl until ctx is done.
+func startDNSRefresh(ctx context.Context) {
+	hc := dnsCache
+	if hc == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(hc.ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				hc.mu.Lock()
+				hosts := make([]string, 0, len(hc.hosts))
+				for host := range hc.hosts {
+					hosts = append(hosts, host)
+				}
+				hc.mu.Unlock()
+				for _, host := range hosts {
+					hc.refresh(ctx, host)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDNSCache tests that hosts are resolved once, rotated, and keep their addresses
+// when a lookup fails.
+func TestDNSCache(t *testing.T) {
+	// Setup
+	answers := [][]string{{"192.0.2.2", "192.0.2.1"}}
+	var failing bool
+	lookups := 0
+	defer func(original func(context.Context, string) ([]string, error)) { lookupHost = original }(lookupHost)
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if failing {
+			return nil, errors.New("resolver unavailable")
+		}
+		return answers[len(answers)-1], nil
+	}
+	config = Config{DNSCache: &DNSCacheConfig{}}
+	defer func() {
+		config = Config{}
+		dnsCache = nil
+	}()
+	if err := setupDNSCache(); err != nil {
+		t.Fatalf("Failed to set up the DNS cache: %v", err)
+	}
+	hc := dnsCache
+
+	// Test
+	first, _ := hc.resolve(context.Background(), "node.example.com")
+	second, _ := hc.resolve(context.Background(), "node.example.com")
+	failing = true
+	errRefresh := hc.refresh(context.Background(), "node.example.com")
+	kept, _ := hc.resolve(context.Background(), "node.example.com")
+	failing = false
+	answers = append(answers, []string{"192.0.2.3"})
+	hc.refresh(context.Background(), "node.example.com")
+	changed, _ := hc.resolve(context.Background(), "node.example.com")
+	failing = true
+	_, errUnknown := hc.resolve(context.Background(), "other.example.com")
+
+	// Verify
+	if strings.Join(first, ",") != "192.0.2.1,192.0.2.2" || strings.Join(second, ",") != "192.0.2.2,192.0.2.1" {
+		t.Errorf("Expected the addresses to rotate, got %v then %v", first, second)
+	}
+	if errRefresh != nil || len(kept) != 2 {
+		t.Errorf("Expected the previous addresses to be kept after a failed lookup, got %v (%v)", kept, errRefresh)
+	}
+	if strings.Join(changed, ",") != "192.0.2.3" {
+		t.Errorf("Expected the new addresses after a change, got %v", changed)
+	}
+	if errUnknown == nil {
+		t.Error("Expected an error for a host that was never resolved")
+	}
+	if lookups != 4 {
+		t.Errorf("Expected 4 lookups, got %d", lookups)
+	}
+	if got := dnsLookups.value("node.example.com", "changed"); got != 1 {
+		t.Errorf("Expected 1 changed lookup, got %v", got)
+	}
+}
+
+// TestDNSCacheTransport tests that requests reach an upstream through the DNS cache,
+// skipping addresses that refuse connections.
+func TestDNSCacheTransport(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	defer func(original func(context.Context, string) ([]string, error)) { lookupHost = original }(lookupHost)
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.2"}, nil // 127.0.0.2 has nothing listening on the port
+	}
+	config = Config{DNSCache: &DNSCacheConfig{}}
+	defer func() {
+		config = Config{}
+		dnsCache = nil
+	}()
+	if err := setupDNSCache(); err != nil {
+		t.Fatalf("Failed to set up the DNS cache: %v", err)
+	}
+	client := &http.Client{Transport: withDNSCache(http.DefaultTransport.(*http.Transport).Clone())}
+
+	// Test
+	var failures int
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://rpc.invalid:" + port + "/")
+		if err != nil {
+			failures++
+			continue
+		}
+		resp.Body.Close()
+		client.CloseIdleConnections()
+	}
+
+	// Verify
+	if failures != 0 {
+		t.Errorf("Expected every request to reach the upstream, got %d failure(s)", failures)
+	}
+}
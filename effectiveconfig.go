@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Effective configuration
+//
+// To answer "why is it routing there?", the proxy can show the configuration it is
+// actually running with: the file after template rendering, flag and environment
+// overrides, route groups, and SRV expansion, with built-in defaults filled in and the
+// current members of discovered pools. `-print-config` prints it as YAML and exits;
+// GET /admin/config serves it through the admin API (as JSON with ?format=json).
+//
+// Secrets are redacted: the admin token, the private relay signing key, the
+// introspection client secret, basic auth password hashes, request signing secrets, the values of headers set on
+// outbound requests, and the API keys among priority and per-client concurrency clients,
+// which are replaced by a fingerprint so that entries can still be told apart. Upstream URLs, including those keying
+// max_batch_sizes and batch_concurrency.upstreams, are reduced to their scheme and host unless
+// meta_methods.expose_urls is set, as for proxy_routes. Empty options are left out.
+
+// redacted replaces secret values in the effective configuration.
+const redacted = "(redacted)"
+
+// configDefault is a built-in default filled into the effective configuration where an
+// option is not set.
+type configDefault struct {
+	path  string      // Dotted option path; * matches every map value or list item
+	value interface{} // The default value
+}
+
+// configDefaults are the built-in defaults of options in configured sections.
+var configDefaults = []configDefault{
+	{"router", "method"},
+	{"srv_refresh_interval", "30s"},
+	{"pools.*.strategy", "round_robin"},
+	{"pools.*.discovery.kubernetes.scheme", "http"},
+	{"routes.*.mirror.sample_rate", 1},
+	{"routes.*.mirror.timeout", "10s"},
+	{"private_tx.methods", []string{"eth_sendRawTransaction"}},
+	{"private_tx.signature_header", "X-Flashbots-Signature"},
+	{"probe.timeout", "3s"},
+	{"filters.ttl", "5m0s"},
+	{"filters.max_filters", 10000},
+	{"filters.max_filters_per_client", 100},
+	{"fee_aggregation.methods", defaultFeeMethods},
+	{"fee_aggregation.timeout", "2s"},
+	{"fee_aggregation.min_responses", 1},
+	{"receipt_wait.poll_interval", "1s"},
+	{"receipt_wait.default_timeout", "1m0s"},
+	{"receipt_wait.max_timeout", "5m0s"},
+	{"metrics.path", "/metrics"},
+	{"openrpc.title", "JSON-RPC proxy"},
+	{"concurrency.max_queue", 100},
+	{"concurrency.queue_timeout", "5s"},
+	{"concurrency.retry_after", "1s"},
+	{"concurrency.adaptive.initial_limit", defaultAdaptiveInitial},
+	{"concurrency.adaptive.min_limit", defaultAdaptiveMin},
+	{"concurrency.adaptive.max_limit", defaultAdaptiveMax},
+	{"concurrency.adaptive.tolerance", defaultAdaptiveTolerance},
+	{"concurrency.adaptive.backoff", defaultAdaptiveBackoff},
+	{"concurrency.adaptive.window", "1s"},
+	{"memory_shedding.heavy_methods", defaultHeavyMethods},
+	{"memory_shedding.interval", "1s"},
+	{"rate_limits.default_cost", 1},
+	{"cache.ttl", "12s"},
+	{"cache.max_entries", defaultCacheMaxEntries},
+	{"cache.backend", "memory"},
+	{"cache.memcached.timeout", "500ms"},
+	{"cache.memcached.key_prefix", "jsonrpc-proxy:"},
+	{"cache.compression.min_size", defaultCompressMinSize},
+	{"cache.compression.level", 1},
+	{"cache.eth_call.finality_depth", defaultFinalityDepth},
+	{"cache.serve_stale.max_age", "1h"},
+	{"batch_concurrency.max", 1},
+	{"batch_streaming.min_calls", defaultStreamMinCalls},
+	{"retries.backoff", "100ms"},
+	{"retries.backoff_strategy", "exponential"},
+	{"retries.max_backoff", "5s"},
+	{"retries.budget.ratio", 0.2},
+	{"retries.budget.min_retries", 10},
+	{"retries.budget.window", "10s"},
+	{"upstream_pacing.max_wait", "1s"},
+	{"upstream_quotas.shift_at", defaultQuotaShiftAt},
+	{"upstream_quotas.alert_at", defaultQuotaAlerts},
+	{"dns_cache.ttl", "1m"},
+	{"failback.policy", "immediate"},
+	{"failback.delay", "1m"},
+	{"stats.window", "15m0s"},
+	{"stats.top", defaultStatsTop},
+	{"micro_batch.window", "5ms"},
+	{"micro_batch.max_size", defaultMicroBatchMaxSize},
+	{"json_limits.max_depth", defaultMaxJSONDepth},
+	{"json_limits.max_array_length", defaultMaxJSONArrayLength},
+	{"json_limits.max_tokens", defaultMaxJSONTokens},
+	{"faults.rules.*.rate", 1},
+	{"recording.sample_rate", 1},
+	{"grpc.stream_concurrency", 16},
+	{"proxy_protocol.header_timeout", "5s"},
+	{"server.read_header_timeout", "10s"},
+	{"server.idle_timeout", "2m"},
+	{"server.max_header_bytes", http.DefaultMaxHeaderBytes},
+	{"logging.level", "info"},
+	{"logging.max_size", defaultLogMaxSize},
+	{"logging.syslog.facility", "daemon"},
+	{"logging.syslog.app_name", "jsonrpc-proxy"},
+	{"tls_policies.*.min_version", "1.2"},
+	{"aws_signers.*.service", "managedblockchain"},
+	{"access_log.format", "json"},
+	{"auth.introspection.cache_ttl", "1m0s"},
+	{"auth.introspection.negative_cache_ttl", "10s"},
+	{"auth.introspection.timeout", "5s"},
+	{"auth.realm", "jsonrpc-proxy"},
+	{"auth.replay.window", "5m0s"},
+	{"auth.replay.store", "memory"},
+	{"access_log.max_size", defaultLogMaxSize},
+}
+
+// poolSnapshot is a pool as shown in the effective configuration, with its current members.
+type poolSnapshot struct {
+	Upstreams []UpstreamConfig `yaml:"upstreams"`
+	Strategy  string           `yaml:"strategy"`
+	Discovery *DiscoveryConfig `yaml:"discovery"`
+}
+
+// effectiveConfig returns the running configuration with defaults filled in and
+// secrets redacted.
+//
+// Returns:
+//   - *yaml.Node: The configuration document
+//   - error: An error if the configuration cannot be encoded
+func effectiveConfig() (*yaml.Node, error) {
+	c := config
+	c.Pools = nil
+	var root yaml.Node
+	if err := root.Encode(&c); err != nil {
+		return nil, err
+	}
+
+	// Pools are encoded from their current members, which discovery may be changing
+	if len(config.Pools) > 0 {
+		pools := make(map[string]poolSnapshot)
+		for name, pool := range config.Pools {
+			if pool != nil {
+				pools[name] = poolSnapshot{Upstreams: pool.members(), Strategy: pool.Strategy, Discovery: pool.Discovery}
+			}
+		}
+		var n yaml.Node
+		if err := n.Encode(pools); err != nil {
+			return nil, err
+		}
+		setNode(&root, []string{"pools"}, &n)
+	}
+
+	for _, d := range configDefaults {
+		var value yaml.Node
+		if err := value.Encode(d.value); err != nil {
+			return nil, err
+		}
+		fillDefault(&root, strings.Split(d.path, "."), &value)
+	}
+	redactConfig(&root, "")
+	pruneEmpty(&root)
+	return &root, nil
+}
+
+// fillDefault sets an option to its default where its section exists and the option
+// is not set.
+func fillDefault(n *yaml.Node, path []string, value *yaml.Node) {
+	if path[0] == "*" {
+		for i, child := range n.Content {
+			if n.Kind == yaml.SequenceNode || i%2 == 1 {
+				fillDefault(child, path[1:], value)
+			}
+		}
+		return
+	}
+	child := mappingNode(n, path[0])
+	if len(path) == 1 {
+		if n.Kind == yaml.MappingNode && (child == nil || isEmptyNode(child) || child.Value == "0" || child.Value == "0s") {
+			setNode(n, path, value)
+		}
+		return
+	}
+	if child != nil {
+		fillDefault(child, path[1:], value)
+	}
+}
+
+// redactConfig replaces the secrets in a configuration document.
+//
+// Parameters:
+//   - n: The node to redact
+//   - key: The mapping key of the node, or "" for list items and the root
+func redactConfig(n *yaml.Node, key string) {
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Value != "" && (key == "token" || key == "signing_key" || key == "client_secret"):
+		n.Value = redacted
+	case n.Kind == yaml.ScalarNode && n.Value != "" && (key == "url" || strings.HasSuffix(key, "_url") || (key == "store" && strings.Contains(n.Value, "://"))):
+		n.Value = displayURL(n.Value)
+	case n.Kind == yaml.MappingNode && (key == "set" || key == "users" || key == "keys"):
+		for i := 1; i < len(n.Content); i += 2 {
+			n.Content[i].Value = redacted
+		}
+	case n.Kind == yaml.MappingNode && (key == "max_batch_sizes" || key == "upstreams"):
+		for i := 0; i < len(n.Content); i += 2 {
+			if strings.Contains(n.Content[i].Value, "://") {
+				n.Content[i].Value = displayURL(n.Content[i].Value)
+			}
+		}
+	case n.Kind == yaml.MappingNode && key == "clients":
+		for i := 0; i < len(n.Content); i += 2 {
+			n.Content[i].Value = fingerprint(n.Content[i].Value)
+		}
+	case n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			redactConfig(n.Content[i+1], n.Content[i].Value)
+		}
+	case n.Kind == yaml.SequenceNode:
+		for _, child := range n.Content {
+			redactConfig(child, "")
+		}
+	}
+}
+
+// fingerprint identifies a secret without revealing it.
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// pruneEmpty removes the options that are not set from a configuration document.
+// Zero numbers and false are kept, since they can be meaningful.
+func pruneEmpty(n *yaml.Node) {
+	if n.Kind == yaml.SequenceNode {
+		for _, child := range n.Content {
+			pruneEmpty(child)
+		}
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	kept := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pruneEmpty(n.Content[i+1])
+		if !isEmptyNode(n.Content[i+1]) {
+			kept = append(kept, n.Content[i], n.Content[i+1])
+		}
+	}
+	n.Content = kept
+}
+
+// isEmptyNode reports whether a node is null, an empty string, or an empty list or map.
+func isEmptyNode(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Tag == "!!null" || (n.Tag == "!!str" && n.Value == "")
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(n.Content) == 0
+	}
+	return false
+}
+
+// printEffectiveConfig writes the effective configuration as YAML.
+func printEffectiveConfig(w io.Writer) error {
+	root, err := effectiveConfig()
+	if err != nil {
+		return err
+	}
+	return writeYAML(w, root)
+}
+
+// setupConfigDump registers the admin endpoint serving the effective configuration.
+func setupConfigDump() {
+	registerAdminHandler("/admin/config", handleAdminConfig)
+}
+
+// handleAdminConfig serves the effective configuration as YAML, or as JSON with
+// ?format=json.
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	root, err := effectiveConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		var v interface{}
+		if err := root.Decode(&v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	writeYAML(w, root)
+}
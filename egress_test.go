@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEgressProxy tests sending upstream requests through an authenticated egress proxy
+func TestEgressProxy(t *testing.T) {
+	// Setup: a forward proxy answering for an upstream that does not resolve
+	var target, auth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+		auth = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer proxy.Close()
+	config = Config{
+		DefaultURL:    "http://rpc.provider.invalid/v1",
+		EgressProxies: map[string]*EgressProxyConfig{"corp": {URL: strings.Replace(proxy.URL, "http://", "http://alice:s3cret@", 1)}},
+		EgressProxy:   "corp",
+	}
+	defer func() {
+		config = Config{}
+		setupEgressProxies()
+	}()
+	if err := setupEgressProxies(); err != nil {
+		t.Fatalf("Invalid egress proxies: %v", err)
+	}
+	if err := buildTransportMap(); err != nil {
+		t.Fatalf("Invalid transports: %v", err)
+	}
+
+	// Test
+	resp, err := forwardRequest(context.Background(), config.DefaultURL, []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Verify
+	if target != "http://rpc.provider.invalid/v1" {
+		t.Errorf("Expected the proxy to receive the upstream URL, got %q", target)
+	}
+	if expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret")); auth != expected {
+		t.Errorf("Expected proxy credentials %q, got %q", expected, auth)
+	}
+	if !strings.Contains(string(body), `"0x1"`) {
+		t.Errorf("Unexpected response %s", body)
+	}
+}
+
+// TestEgressProxyValidation tests rejecting invalid egress proxy configurations
+func TestEgressProxyValidation(t *testing.T) {
+	defer func() {
+		config = Config{}
+		setupEgressProxies()
+	}()
+
+	tests := []struct {
+		name    string
+		proxies map[string]*EgressProxyConfig
+		def     string
+	}{
+		{"unsupported scheme", map[string]*EgressProxyConfig{"p": {URL: "ftp://proxy:21"}}, ""},
+		{"missing url", map[string]*EgressProxyConfig{"p": {}}, ""},
+		{"unknown default", map[string]*EgressProxyConfig{"tor": {URL: "socks5h://127.0.0.1:9050"}}, "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			config = Config{EgressProxies: tt.proxies, EgressProxy: tt.def}
+
+			// Test
+			err := setupEgressProxies()
+
+			// Verify
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
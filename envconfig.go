@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment-only configuration
+//
+// For minimal container deployments and quick experiments the proxy can run without a
+// configuration file. Every option already has an environment variable (see
+// overrides.go); on top of those, method routes can be given one per variable, with the
+// method name keeping its case:
+//
+//	JSONRPC_PROXY_DEFAULT_URL=https://mainnet.example.com
+//	JSONRPC_PROXY_ROUTE_eth_sendRawTransaction=https://relay.example.com
+//	JSONRPC_PROXY_ROUTE_eth_getLogs=pool:archive
+//
+// or with the repeatable -route flag (-route eth_getLogs=https://archive.example.com).
+// A value of the form pool:<name> routes the method to a pool. These routes replace the
+// unconditional routes for the same method from the file or JSONRPC_PROXY_ROUTES, and
+// are added after the other routes.
+//
+// The proxy runs from flags and the environment alone when the configuration file does
+// not exist, or always when the file is given as "none" (-config none or
+// JSONRPC_PROXY_CONFIG=none).
+
+// routeEnvPrefix is the prefix of the variables routing a single method.
+const routeEnvPrefix = envPrefix + "ROUTE_"
+
+// noConfigFile is the configuration file name selecting environment-only configuration.
+const noConfigFile = "none"
+
+// methodRoute is a route for a single method set by a flag or environment variable.
+type methodRoute struct {
+	method string // The JSON-RPC method name
+	target string // The upstream URL, or pool:<name>
+	source string // The flag or variable setting the route, for errors
+}
+
+// parseRouteFlag parses the value of a -route flag.
+//
+// Parameters:
+//   - value: The flag value, as method=url or method=pool:name
+//
+// Returns:
+//   - methodRoute: The route
+//   - error: An error if the method or target is missing
+func parseRouteFlag(value string) (methodRoute, error) {
+	method, target, ok := strings.Cut(value, "=")
+	if !ok || method == "" || target == "" {
+		return methodRoute{}, fmt.Errorf("expected method=url, got %q", value)
+	}
+	return methodRoute{method: method, target: target, source: "-route " + method}, nil
+}
+
+// resolveMethodRoutes combines the -route flags with the route variables, letting flags
+// take precedence. The routes are returned sorted by method.
+//
+// Parameters:
+//   - flags: The routes set by flags, in order
+//   - env: The environment variables with the prefix, by name
+//   - known: The variables that are options, which are not routes
+//
+// Returns:
+//   - []methodRoute: The routes, one per method
+func resolveMethodRoutes(flags []methodRoute, env map[string]string, known map[string]bool) []methodRoute {
+	byMethod := make(map[string]methodRoute)
+	for name, value := range env {
+		method := strings.TrimPrefix(name, routeEnvPrefix)
+		if known[name] || method == name || method == "" {
+			continue
+		}
+		known[name] = true
+		byMethod[method] = methodRoute{method: method, target: value, source: name}
+	}
+	for _, r := range flags {
+		byMethod[r.method] = r
+	}
+
+	routes := make([]methodRoute, 0, len(byMethod))
+	for _, method := range sortedKeys(byMethod) {
+		routes = append(routes, byMethod[method])
+	}
+	return routes
+}
+
+// addMethodRoutes adds single-method routes to a configuration document, replacing the
+// document's unconditional routes for the same methods.
+//
+// Parameters:
+//   - root: The root mapping of the document
+//   - routes: The routes to add
+//
+// Returns:
+//   - error: An error naming the flag or variable whose value is empty
+func addMethodRoutes(root *yaml.Node, routes []methodRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	replaced := make(map[string]bool)
+	for _, r := range routes {
+		if r.target == "" {
+			return fmt.Errorf("%s: empty value", r.source)
+		}
+		replaced[r.method] = true
+	}
+
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "routes" && root.Content[i+1].Kind == yaml.SequenceNode {
+			seq = root.Content[i+1]
+		}
+	}
+	kept := seq.Content[:0]
+	for _, item := range seq.Content {
+		if !replaced[mappingValue(item, "method")] || mappingValue(item, "when") != "" {
+			kept = append(kept, item)
+		}
+	}
+	seq.Content = kept
+
+	for _, r := range routes {
+		key, value := "url", r.target
+		if pool, ok := strings.CutPrefix(r.target, "pool:"); ok {
+			key, value = "pool", pool
+		}
+		seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "method"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: r.method},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+		}})
+	}
+	setNode(root, []string{"routes"}, seq)
+	return nil
+}
+
+// mappingValue returns the scalar value of a key in a mapping node, or "" if the node
+// is not a mapping or the key is missing.
+func mappingValue(m *yaml.Node, key string) string {
+	if n := mappingNode(m, key); n != nil {
+		return n.Value
+	}
+	return ""
+}
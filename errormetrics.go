@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// Upstream error metrics
+//
+// jsonrpc_proxy_calls_total tells that calls failed, but not which provider failed them
+// or how. Errors returned by upstreams are counted by upstream, kind, and code:
+//
+//	jsonrpc_proxy_upstream_errors_total{upstream="infura",kind="jsonrpc",code="-32005"} 12
+//	jsonrpc_proxy_upstream_errors_total{upstream="alchemy",kind="http",code="502"} 3
+//
+// The kind is http for HTTP statuses of 400 or more, and jsonrpc for JSON-RPC error
+// objects, which providers return with any status. A batch counts each error object it
+// contains. The upstream label is the upstream's name, or the scheme and host of its
+// URL if it has none. Only the start of a streamed response is inspected, which is
+// where its error object is.
+
+// upstreamErrorsTotal counts the errors returned by upstreams.
+var upstreamErrorsTotal = newCounterVec("jsonrpc_proxy_upstream_errors_total", "Errors returned by upstreams, by HTTP status or JSON-RPC error code.", "upstream", "kind", "code")
+
+// errorPrefixSize is how much of a streamed response is kept to find its error code.
+const errorPrefixSize = 4096
+
+// observeUpstreamErrors counts the errors of an upstream response.
+//
+// Parameters:
+//   - upstream: The upstream that answered
+//   - status: The HTTP status of the response
+//   - responses: The JSON-RPC responses, or the start of a single response
+func observeUpstreamErrors(upstream Upstream, status int, responses ...json.RawMessage) {
+	label := upstreamLabel(upstream)
+	if status >= 400 {
+		upstreamErrorsTotal.inc(label, "http", strconv.Itoa(status))
+	}
+	for _, response := range responses {
+		if code, ok := responseErrorCode(response); ok {
+			upstreamErrorsTotal.inc(label, "jsonrpc", strconv.Itoa(code))
+		}
+	}
+}
+
+// responseErrorCode finds the error code of a JSON-RPC response. Only the members
+// before the error are decoded, so the start of a response is enough unless a large
+// member comes first.
+//
+// Returns:
+//   - int: The error code
+//   - bool: Whether the response is an error
+func responseErrorCode(response []byte) (int, bool) {
+	dec := json.NewDecoder(bytes.NewReader(response))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return 0, false
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, false
+		}
+		switch key {
+		case "error":
+			return errorObjectCode(dec)
+		case "result":
+			return 0, false
+		}
+		var skipped json.RawMessage
+		if dec.Decode(&skipped) != nil {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// errorObjectCode decodes the code of the error object the decoder is at, without
+// decoding members after it, such as large revert data.
+func errorObjectCode(dec *json.Decoder) (int, bool) {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return 0, false
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, false
+		}
+		if key == "code" {
+			code, err := strconv.Atoi(string(value))
+			return code, err == nil
+		}
+	}
+	return 0, false
+}
+
+// prefixWriter keeps the first bytes written to it.
+type prefixWriter struct {
+	buf   []byte
+	limit int
+}
+
+// Write implements io.Writer, never failing.
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	if room := p.limit - len(p.buf); room > 0 {
+		p.buf = append(p.buf, data[:min(room, len(data))]...)
+	}
+	return len(data), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestResponseErrorCode tests finding the error code of JSON-RPC responses
+func TestResponseErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		code     int
+		isError  bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limited"}}`, -32005, true},
+		{`{"error":{"message":"no code"},"id":1}`, 0, false},
+		{`{"jsonrpc":"2.0","id":1,"result":"0x1"}`, 0, false},
+		{`{"jsonrpc":"2.0","id":1,"result":"0x` + strings.Repeat("0", 100), 0, false},
+		{`{"jsonrpc":"2.0","id":{"nested":[1,2]},"error":{"code":3,"message":"execution reverted","data":"0x`, 3, true},
+		{`not json`, 0, false},
+	} {
+		// Test
+		code, isError := responseErrorCode([]byte(tc.response))
+
+		// Verify
+		if code != tc.code || isError != tc.isError {
+			t.Errorf("For %s: expected %d %v, got %d %v", tc.response, tc.code, tc.isError, code, isError)
+		}
+	}
+}
+
+// TestUpstreamErrorMetrics tests counting upstream errors by upstream, kind, and code
+func TestUpstreamErrorMetrics(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "bad-gateway") {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("bad gateway"))
+			return
+		}
+		if r.URL.Query().Get("batch") == "1" {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"invalid params"}}]`))
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limited"}}`))
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL:  server.URL,
+		DefaultName: "errmetrics-provider",
+		Routes: []Route{
+			{Method: "eth_getLogs", URL: server.URL + "/bad-gateway", Name: "errmetrics-logs"},
+			{Method: "eth_call", URL: server.URL + "/?batch=1", Name: "errmetrics-batch"},
+		},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+
+	// Test
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)))
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[]}`)))
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[]}]`)))
+
+	// Verify
+	for _, tc := range []struct {
+		labels []string
+		want   float64
+	}{
+		{[]string{"errmetrics-provider", "http", "429"}, 1},
+		{[]string{"errmetrics-provider", "jsonrpc", "-32005"}, 1},
+		{[]string{"errmetrics-logs", "http", "502"}, 1},
+		{[]string{"errmetrics-batch", "jsonrpc", "-32602"}, 1},
+	} {
+		if got := upstreamErrorsTotal.value(tc.labels...); got != tc.want {
+			t.Errorf("Expected %v errors for %v, got %v", tc.want, tc.labels, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Trace exemplars
+//
+// A latency spike on a dashboard is easiest to explain with an example of a slow
+// request. When requests carry a W3C traceparent header, set by tracing clients or an
+// ingress, the proxy can attach their trace IDs to latency observations as exemplars:
+//
+//	metrics:
+//	  enabled: true
+//	  exemplars: true
+//
+// Each bucket of jsonrpc_proxy_call_duration_seconds keeps the trace ID and value of
+// its latest traced observation. Exemplars are only part of the OpenMetrics exposition
+// format, which the metrics endpoint serves to scrapers asking for it in their Accept
+// header (Prometheus does with exemplar storage enabled); other scrapers get the
+// Prometheus text format as before. Requests without a valid traceparent, or with an
+// all-zero trace ID, are observed without an exemplar.
+
+// exemplar is a traced observation of a histogram bucket.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+type traceIDKey struct{}
+
+// withTrace returns the request with the trace ID of its traceparent header in its
+// context, when exemplars are enabled.
+func withTrace(r *http.Request) *http.Request {
+	if config.Metrics == nil || !config.Metrics.Exemplars {
+		return r
+	}
+	traceID, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceIDKey{}, traceID))
+}
+
+// traceIDFromContext returns the trace ID carried by a context, or "".
+func traceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// parseTraceparent extracts the trace ID of a W3C traceparent header, of the form
+// version-traceid-parentid-flags.
+//
+// Returns:
+//   - string: The trace ID, 32 lowercase hex digits
+//   - bool: Whether the header is valid
+func parseTraceparent(header string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false
+	}
+	for _, part := range parts[:4] {
+		if _, err := hex.DecodeString(part); err != nil || strings.ToLower(part) != part {
+			return "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// wantsOpenMetrics reports whether a scrape asks for the OpenMetrics format, which
+// carries exemplars.
+func wantsOpenMetrics(r *http.Request) bool {
+	return config.Metrics != nil && config.Metrics.Exemplars && strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+}
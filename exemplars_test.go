@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestTraceExemplars tests that traced calls leave exemplars in the OpenMetrics output only
+func TestTraceExemplars(t *testing.T) {
+	// Setup
+	config = Config{
+		Metrics: &MetricsConfig{Enabled: true, Exemplars: true},
+		Routes:  []Route{{Method: "eth_chainId", Name: "exemplar-stub", Stub: &StubResponse{Result: "0x1"}}},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_chainId","id":1}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// Test
+	handleProxy(httptest.NewRecorder(), req)
+	openMetrics := httptest.NewRecorder()
+	scrape := httptest.NewRequest("GET", "/metrics", nil)
+	scrape.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	handleMetrics(openMetrics, scrape)
+	text := httptest.NewRecorder()
+	handleMetrics(text, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Verify
+	out := openMetrics.Body.String()
+	if ct := openMetrics.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Expected the OpenMetrics content type, got %s", ct)
+	}
+	if !strings.Contains(out, `jsonrpc_proxy_call_duration_seconds_bucket{route="exemplar-stub",tags="",le="0.005"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+		t.Errorf("Expected an exemplar on the first bucket, got:\n%s", out)
+	}
+	if !strings.Contains(out, "# TYPE jsonrpc_proxy_calls counter") || !strings.HasSuffix(out, "# EOF\n") {
+		t.Errorf("Expected OpenMetrics counter families and terminator, got:\n%s", out)
+	}
+	if strings.Contains(text.Body.String(), "trace_id") || strings.Contains(text.Body.String(), "# EOF") {
+		t.Errorf("Expected no exemplars in the text format, got:\n%s", text.Body.String())
+	}
+}
+
+// TestParseTraceparent tests extracting trace IDs from traceparent headers
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		header  string
+		traceID string
+		ok      bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			// Test
+			traceID, ok := parseTraceparent(tc.header)
+
+			// Verify
+			if traceID != tc.traceID || ok != tc.ok {
+				t.Errorf("Expected %q, %v, got %q, %v", tc.traceID, tc.ok, traceID, ok)
+			}
+		})
+	}
+}
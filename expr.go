@@ -0,0 +1,513 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Routing expressions
+//
+// Routes may carry a `when` expression that is evaluated against the parsed JSON-RPC
+// request. The language is intentionally small:
+//
+//	method == "eth_getBlockByNumber" && params[0] == "pending"
+//	params[0].to == "0x00000000219ab540356cbb839cbe05303d7705fa"
+//	len(params) > 2 || !(id == null)
+//	lower(params[0].from) != "0xabc..."
+//
+// Variables: method, params, id, jsonrpc. Values are the decoded JSON (strings, numbers,
+// booleans, null, arrays, and objects). Indexing past the end of an array or reading a
+// missing field yields null rather than an error, so conditions on optional params are
+// simply false when the param is absent.
+//
+// Operators: == != < <= > >= && || ! and parentheses. Functions: len(x), lower(s).
+
+// expr is a compiled routing expression.
+type expr interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+// compileExpr parses a routing expression.
+//
+// Parameters:
+//   - src: The expression source
+//
+// Returns:
+//   - expr: The compiled expression
+//   - error: An error describing the first syntax problem
+func compileExpr(src string) (expr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.tokens[p.pos].text, p.tokens[p.pos].offset)
+	}
+	return e, nil
+}
+
+// evalCondition evaluates a compiled expression against a request and reports whether it is true.
+//
+// Parameters:
+//   - e: The compiled expression
+//   - req: The parsed JSON-RPC request
+//
+// Returns:
+//   - bool: Whether the expression evaluated to true
+//   - error: An error if evaluation fails (for example, a type mismatch)
+func evalCondition(e expr, req *JSONRPCRequest) (bool, error) {
+	v, err := evalExpr(e, req)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluated to %T, not a boolean", v)
+	}
+	return b, nil
+}
+
+// evalExpr evaluates a compiled expression against a request and returns its value.
+func evalExpr(e expr, req *JSONRPCRequest) (interface{}, error) {
+	return e.eval(requestVars(req))
+}
+
+// requestVars returns the variables of expressions evaluated against a request.
+func requestVars(req *JSONRPCRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"method":  req.Method,
+		"params":  req.Params,
+		"id":      req.ID,
+		"jsonrpc": req.JSONRPC,
+	}
+}
+
+// Tokenizer
+
+type exprToken struct {
+	kind   string // "ident", "string", "number", or the operator/punctuation itself
+	text   string
+	offset int
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for end < len(src) && rune(src[end]) != c {
+				if src[end] == '\\' && end+1 < len(src) {
+					end++
+				}
+				sb.WriteByte(src[end])
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: "string", text: sb.String(), offset: i})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			end := i + 1
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: "number", text: src[i:end], offset: i})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: src[i:end], offset: i})
+			i = end
+		default:
+			if i+1 < len(src) {
+				two := src[i : i+2]
+				switch two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, exprToken{kind: two, text: two, offset: i})
+					i += 2
+					continue
+				}
+			}
+			one := string(c)
+			if !strings.Contains("<>!()[].,", one) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", one, i)
+			}
+			tokens = append(tokens, exprToken{kind: one, text: one, offset: i})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// Parser
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *exprParser) expect(kind string) error {
+	if p.peek() != kind {
+		if p.pos < len(p.tokens) {
+			return fmt.Errorf("expected %q at offset %d, got %q", kind, p.tokens[p.pos].offset, p.tokens[p.pos].text)
+		}
+		return fmt.Errorf("expected %q at end of expression", kind)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.peek() == "!" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		right, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		return &compareExpr{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "[":
+			p.pos++
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{target: e, index: index}
+		case ".":
+			p.pos++
+			if p.peek() != "ident" {
+				return nil, fmt.Errorf("expected field name after '.'")
+			}
+			e = &indexExpr{target: e, index: &literalExpr{value: p.tokens[p.pos].text}}
+			p.pos++
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case "string":
+		return &literalExpr{value: tok.text}, nil
+	case "number":
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.offset)
+		}
+		return &literalExpr{value: n}, nil
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case "ident":
+		switch tok.text {
+		case "true":
+			return &literalExpr{value: true}, nil
+		case "false":
+			return &literalExpr{value: false}, nil
+		case "null":
+			return &literalExpr{value: nil}, nil
+		}
+		if p.peek() == "(" {
+			return p.parseCall(tok)
+		}
+		return &varExpr{name: tok.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.offset)
+}
+
+func (p *exprParser) parseCall(name exprToken) (expr, error) {
+	if name.text != "len" && name.text != "lower" {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.offset)
+	}
+	p.pos++ // "("
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &callExpr{name: name.text, arg: arg}, nil
+}
+
+// Expression nodes
+
+type literalExpr struct{ value interface{} }
+
+func (e *literalExpr) eval(map[string]interface{}) (interface{}, error) { return e.value, nil }
+
+type varExpr struct{ name string }
+
+func (e *varExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[e.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", e.name)
+	}
+	return v, nil
+}
+
+type indexExpr struct{ target, index expr }
+
+func (e *indexExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := e.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := e.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := target.(type) {
+	case []interface{}:
+		n, ok := index.(float64)
+		if !ok {
+			return nil, fmt.Errorf("array index must be a number, got %T", index)
+		}
+		if n < 0 || int(n) >= len(t) {
+			return nil, nil
+		}
+		return t[int(n)], nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("object key must be a string, got %T", index)
+		}
+		return t[key], nil
+	}
+	return nil, nil
+}
+
+type notExpr struct{ operand expr }
+
+func (e *notExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := e.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operator ! needs a boolean, got %T", v)
+	}
+	return !b, nil
+}
+
+type logicalExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *logicalExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := e.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := l.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operator %s needs booleans, got %T", e.op, l)
+	}
+	if (e.op == "&&" && !lb) || (e.op == "||" && lb) {
+		return lb, nil
+	}
+	r, err := e.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	rb, ok := r.(bool)
+	if !ok {
+		return nil, fmt.Errorf("operator %s needs booleans, got %T", e.op, r)
+	}
+	return rb, nil
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *compareExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := e.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return valuesEqual(l, r), nil
+	case "!=":
+		return !valuesEqual(l, r), nil
+	}
+
+	// Ordering comparisons between mismatched or missing values are false
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return false, nil
+		}
+		return compareOrdered(e.op, lv, rv), nil
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return false, nil
+		}
+		return compareOrdered(e.op, lv, rv), nil
+	}
+	return false, nil
+}
+
+func compareOrdered[T float64 | string](op string, l, r T) bool {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+	return false
+}
+
+// valuesEqual compares two decoded JSON scalars. Arrays and objects are never equal.
+func valuesEqual(l, r interface{}) bool {
+	switch l.(type) {
+	case nil:
+		return r == nil
+	case string, float64, bool:
+		return l == r
+	}
+	return false
+}
+
+type callExpr struct {
+	name string
+	arg  expr
+}
+
+func (e *callExpr) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := e.arg.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch e.name {
+	case "len":
+		switch t := v.(type) {
+		case string:
+			return float64(len(t)), nil
+		case []interface{}:
+			return float64(len(t)), nil
+		case map[string]interface{}:
+			return float64(len(t)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("len() of %T", v)
+	case "lower":
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		return strings.ToLower(s), nil
+	}
+	return nil, fmt.Errorf("unknown function %q", e.name)
+}
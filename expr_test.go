@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestEvalCondition tests routing expressions against parsed requests
+func TestEvalCondition(t *testing.T) {
+	// Setup
+	var req JSONRPCRequest
+	body := `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["pending",false,{"to":"0xABC"}],"id":7}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	testCases := []struct {
+		expression string
+		expected   bool
+	}{
+		{`params[0] == "pending"`, true},
+		{`params[0] == 'latest'`, false},
+		{`method == "eth_getBlockByNumber" && params[1] == false`, true},
+		{`params[5] == "pending"`, false},
+		{`params[5] == null`, true},
+		{`lower(params[2].to) == "0xabc"`, true},
+		{`params[2].missing.deeper == null`, true},
+		{`len(params) > 2 && id >= 7`, true},
+		{`!(id == 7) || method != "x"`, true},
+		{`id < 3`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			// Test
+			e, err := compileExpr(tc.expression)
+			if err != nil {
+				t.Fatalf("Failed to compile expression: %v", err)
+			}
+			result, err := evalCondition(e, &req)
+			if err != nil {
+				t.Fatalf("Failed to evaluate expression: %v", err)
+			}
+
+			// Verify
+			if result != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+// TestCompileExprErrors tests that malformed expressions are rejected
+func TestCompileExprErrors(t *testing.T) {
+	for _, src := range []string{`params[0] ==`, `"unterminated`, `foo(1)`, `params[0] = 1`, `(method == "a"`} {
+		if _, err := compileExpr(src); err == nil {
+			t.Errorf("Expected error compiling %q, got nil", src)
+		}
+	}
+}
+
+// TestConditionalRouting tests that `when` routes take precedence over method routes
+func TestConditionalRouting(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default-url.com",
+		Routes: []Route{
+			{Method: "eth_getBlockByNumber", URL: "http://archive.com"},
+			{Method: "eth_getBlockByNumber", URL: "http://pending.com", When: `params[0] == "pending"`},
+			{URL: "http://any-method.com", When: `id == "special"`},
+		},
+	}
+	buildMethodURLMap()
+
+	testCases := []struct {
+		body     string
+		expected string
+	}{
+		{`{"method":"eth_getBlockByNumber","params":["pending"],"id":1}`, "http://pending.com"},
+		{`{"method":"eth_getBlockByNumber","params":["latest"],"id":1}`, "http://archive.com"},
+		{`{"method":"eth_chainId","params":[],"id":"special"}`, "http://any-method.com"},
+		{`{"method":"eth_chainId","params":[],"id":1}`, "http://default-url.com"},
+	}
+
+	for _, tc := range testCases {
+		var req JSONRPCRequest
+		if err := json.Unmarshal([]byte(tc.body), &req); err != nil {
+			t.Fatalf("Failed to parse request: %v", err)
+		}
+
+		// Test
+		targetURL, _ := resolveTarget(&req)
+
+		// Verify
+		if targetURL != tc.expected {
+			t.Errorf("For %s: expected %s, got %s", tc.body, tc.expected, targetURL)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default route fallback
+//
+// A method routed to a dedicated upstream fails outright while that upstream is down,
+// even though the default upstream could often answer it, more slowly or at a higher
+// cost. Routes can fall back to the default route while the probes report their
+// upstream as unhealthy:
+//
+//	probe:
+//	  interval: 5s
+//	routes:
+//	  - method: eth_getLogs
+//	    url: https://logs.example.com
+//	    fallback_to_default: true
+//
+// For a pool route, the fallback happens when no member of the pool is healthy. Calls
+// falling back are served as default route calls, without the route's rewriting,
+// header rules, or mirroring, since those are meant for its own upstream. The degraded
+// period is visible as jsonrpc_proxy_route_fallback{route="..."}, 1 while the route
+// falls back, and each call served by the default route instead is counted in
+// jsonrpc_proxy_route_fallback_calls_total.
+//
+// When traffic returns to the route once its upstream recovers is set by the failback
+// policy:
+//
+//	failback:
+//	  policy: delayed   # immediate, delayed, or manual (default: immediate)
+//	  delay: 2m         # how long the upstream must stay healthy with delayed (default: 1m)
+//
+// immediate returns with the first probe the upstream passes. delayed waits until the
+// upstream has passed its probes for the whole delay, so that a flapping upstream does
+// not pull traffic back and forth. manual keeps a route on the default route until an
+// operator releases it with POST /admin/failback and a body of {"route": "logs"}, or
+// {"route": "*"} for every route whose upstream is healthy; GET /admin/failback lists
+// the routes falling back and whether their upstream has recovered.
+
+// FailbackConfig configures when routes return to their own upstream.
+type FailbackConfig struct {
+	Policy string        `yaml:"policy"` // immediate, delayed, or manual (default: immediate)
+	Delay  time.Duration `yaml:"delay"`  // How long the upstream must stay healthy with delayed (default: 1m)
+}
+
+// Failback policies
+const (
+	failbackImmediate = "immediate"
+	failbackDelayed   = "delayed"
+	failbackManual    = "manual"
+)
+
+var (
+	routeFallbacksMu sync.Mutex
+	routeFallbacks   = make(map[string]bool) // Whether each fallback route currently falls back, by route name
+)
+
+// routeFallbackCalls counts the calls served by the default route instead of their own.
+var routeFallbackCalls = newCounterVec("jsonrpc_proxy_route_fallback_calls_total", "Calls served by the default route while their route's upstream was unhealthy.", "route")
+
+// routeFallbackRegistered registers the fallback gauge with the first fallback route.
+var routeFallbackRegistered sync.Once
+
+// validateFallback checks that a route can fall back to the default route.
+func validateFallback(route *Route) error {
+	if !route.FallbackToDefault {
+		return nil
+	}
+	if route.URL == "" && route.Pool == "" && route.BlueGreen == nil {
+		return fmt.Errorf("fallback_to_default requires a url, pool, or blue_green")
+	}
+	if probeInterval() == 0 {
+		return fmt.Errorf("fallback_to_default requires probe.interval, since upstream health comes from the probes")
+	}
+	routeFallbackRegistered.Do(func() { registerMetric(routeFallbackCollector{}) })
+	return nil
+}
+
+// setupFailback validates the failback policy and registers its admin endpoint.
+//
+// Returns:
+//   - error: An error if the policy is unknown or the delay negative
+func setupFailback() error {
+	fc := config.Failback
+	if fc == nil {
+		return nil
+	}
+	switch fc.Policy {
+	case "", failbackImmediate, failbackManual:
+	case failbackDelayed:
+		if fc.Delay < 0 {
+			return fmt.Errorf("delay cannot be negative")
+		}
+		if fc.Delay == 0 {
+			fc.Delay = time.Minute
+		}
+	default:
+		return fmt.Errorf("unknown policy %q (expected immediate, delayed, or manual)", fc.Policy)
+	}
+	if fc.Policy == failbackManual {
+		registerAdminHandler("/admin/failback", handleAdminFailback)
+	}
+	return nil
+}
+
+// failbackPolicy returns the configured failback policy.
+func failbackPolicy() string {
+	if config.Failback == nil || config.Failback.Policy == "" {
+		return failbackImmediate
+	}
+	return config.Failback.Policy
+}
+
+// routeFallsBack decides whether a route falls back to the default route, given the
+// health of the upstream selected for the call and the failback policy, logging when
+// the route starts and stops falling back.
+//
+// Parameters:
+//   - route: The route
+//   - url: The URL of the upstream selected for the call
+//
+// Returns:
+//   - bool: True if the call is served by the default route
+func routeFallsBack(route *Route, url string) bool {
+	name := routeName(route)
+	healthy := upstreamHealthy(url)
+	routeFallbacksMu.Lock()
+	defer routeFallbacksMu.Unlock()
+	previous := routeFallbacks[name]
+	fallback := !healthy
+	if previous && healthy {
+		switch failbackPolicy() {
+		case failbackDelayed:
+			fallback = time.Since(upstreamRecoveredAt(url)) < config.Failback.Delay
+		case failbackManual:
+			fallback = true
+		}
+	}
+	routeFallbacks[name] = fallback
+	switch {
+	case fallback && !previous:
+		logWarn("router", "Upstream of route %s is unhealthy, falling back to the default route", name)
+	case !fallback && previous:
+		logInfo("router", "Route %s is served by its own upstream again", name)
+	}
+	return fallback
+}
+
+// upstreamRecoveredAt returns when an upstream last recovered from a failed probe.
+func upstreamRecoveredAt(url string) time.Time {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	if status, ok := upstreamStatuses[url]; ok {
+		return status.RecoveredAt
+	}
+	return time.Time{}
+}
+
+// failbackRoute is a route falling back, as listed by the admin API.
+type failbackRoute struct {
+	Route     string `json:"route"`
+	Recovered bool   `json:"recovered"` // Whether the route's upstream is healthy again
+}
+
+// handleAdminFailback lists the routes falling back on GET, and returns routes whose
+// upstream is healthy to it on POST with a body of {"route": "name"}, or "*" for all.
+func handleAdminFailback(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Route string `json:"route"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Route == "" {
+			http.Error(w, `Expected a body of {"route": "name"}`, http.StatusBadRequest)
+			return
+		}
+		released, err := releaseFallbacks(req.Route)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		for _, name := range released {
+			log.Printf("Route %s returned to its own upstream via admin API", name)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fallingBackRoutes())
+}
+
+// releaseFallbacks returns falling back routes to their own upstream.
+//
+// Parameters:
+//   - name: The route, or "*" for every route whose upstream is healthy
+//
+// Returns:
+//   - []string: The routes released
+//   - error: An error if the named route does not fall back or its upstream is still unhealthy
+func releaseFallbacks(name string) ([]string, error) {
+	var released []string
+	for _, route := range fallingBackRoutes() {
+		if name != "*" && route.Route != name {
+			continue
+		}
+		if !route.Recovered {
+			if name == "*" {
+				continue
+			}
+			return nil, fmt.Errorf("the upstream of route %s is still unhealthy", name)
+		}
+		routeFallbacksMu.Lock()
+		routeFallbacks[route.Route] = false
+		routeFallbacksMu.Unlock()
+		released = append(released, route.Route)
+	}
+	if name != "*" && len(released) == 0 {
+		return nil, fmt.Errorf("route %s does not fall back", name)
+	}
+	return released, nil
+}
+
+// fallingBackRoutes lists the routes currently falling back, sorted by name.
+func fallingBackRoutes() []failbackRoute {
+	routeFallbacksMu.Lock()
+	var names []string
+	for _, name := range sortedKeys(routeFallbacks) {
+		if routeFallbacks[name] {
+			names = append(names, name)
+		}
+	}
+	routeFallbacksMu.Unlock()
+
+	out := make([]failbackRoute, 0, len(names))
+	for _, name := range names {
+		out = append(out, failbackRoute{Route: name, Recovered: routeUpstreamHealthy(name)})
+	}
+	return out
+}
+
+// routeUpstreamHealthy reports whether the upstream of a named route can serve it: its
+// URL, or any member of its pool, or of the blue/green pools receiving calls.
+func routeUpstreamHealthy(name string) bool {
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if routeName(route) != name {
+			continue
+		}
+		pools := []string{route.Pool}
+		if route.BlueGreen != nil {
+			pools = blueGreenActivePools(route)
+		}
+		if pools[0] == "" {
+			return upstreamHealthy(route.URL)
+		}
+		for _, name := range pools {
+			pool := config.Pools[name]
+			if pool == nil {
+				continue
+			}
+			for _, member := range pool.members() {
+				if upstreamHealthy(member.URL) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// routeFallbackCollector exports whether each fallback route currently falls back.
+type routeFallbackCollector struct{}
+
+// write implements metricsCollector.
+func (routeFallbackCollector) write(w io.Writer, openMetrics bool) {
+	routeFallbacksMu.Lock()
+	defer routeFallbacksMu.Unlock()
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_route_fallback Whether a route falls back to the default route (1) or is served by its own upstream (0).\n# TYPE jsonrpc_proxy_route_fallback gauge\n")
+	for _, name := range sortedKeys(routeFallbacks) {
+		value := 0
+		if routeFallbacks[name] {
+			value = 1
+		}
+		fmt.Fprintf(w, "jsonrpc_proxy_route_fallback%s %d\n", encodeLabels([]string{"route"}, []string{name}), value)
+	}
+}
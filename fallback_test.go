@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRouteFallbackToDefault tests serving a route by the default route while its upstream is unhealthy
+func TestRouteFallbackToDefault(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default",
+		Probe:      &ProbeConfig{Interval: time.Second},
+		Routes:     []Route{{Method: "eth_getLogs", URL: "http://logs", Name: "logs", FallbackToDefault: true}},
+	}
+	buildMethodURLMap()
+	if err := validateFallback(&config.Routes[0]); err != nil {
+		t.Fatalf("Failed to validate the route: %v", err)
+	}
+	upstreamStatuses = map[string]*upstreamStatus{"http://logs": {URL: "http://logs", LastProbe: time.Now(), LastError: "connection refused"}}
+	defer func() {
+		config = Config{}
+		upstreamStatuses = nil
+		routeFallbacks = make(map[string]bool)
+	}()
+	callsBefore := routeFallbackCalls.value("logs")
+	req := &JSONRPCRequest{Method: "eth_getLogs"}
+
+	// Test
+	down, _ := router.Route(req)
+	var gauge bytes.Buffer
+	routeFallbackCollector{}.write(&gauge, false)
+	upstreamStatuses["http://logs"].Healthy = true
+	up, _ := router.Route(req)
+
+	// Verify
+	if down.URL != "http://default" || down.Route != nil {
+		t.Errorf("Expected the default route while logs is down, got %+v", down)
+	}
+	if !strings.Contains(gauge.String(), `jsonrpc_proxy_route_fallback{route="logs"} 1`) {
+		t.Errorf("Expected the route to be marked as falling back, got:\n%s", gauge.String())
+	}
+	if got := routeFallbackCalls.value("logs") - callsBefore; got != 1 {
+		t.Errorf("Expected 1 fallback call, got %v", got)
+	}
+	if up.URL != "http://logs" || routeFallbacks["logs"] {
+		t.Errorf("Expected the route's own upstream once healthy, got %+v", up)
+	}
+}
+
+// TestRouteFallbackRequiresProbes tests that fallback is rejected without health information
+func TestRouteFallbackRequiresProbes(t *testing.T) {
+	// Setup
+	config = Config{DefaultURL: "http://default"}
+	defer func() { config = Config{} }()
+
+	// Test
+	err := validateFallback(&Route{Method: "eth_getLogs", URL: "http://logs", FallbackToDefault: true})
+
+	// Verify
+	if err == nil {
+		t.Errorf("Expected an error without probing")
+	}
+}
+
+// TestRouteFailbackPolicies tests when routes return to their recovered upstream under each failback policy
+func TestRouteFailbackPolicies(t *testing.T) {
+	// Setup
+	defer func() {
+		config = Config{}
+		upstreamStatuses = nil
+		routeFallbacks = make(map[string]bool)
+	}()
+	req := &JSONRPCRequest{Method: "eth_getLogs"}
+	recoveredAgo := func(policy string, ago time.Duration) (Upstream, Upstream) {
+		config = Config{
+			DefaultURL: "http://default",
+			Probe:      &ProbeConfig{Interval: time.Second},
+			Routes:     []Route{{Method: "eth_getLogs", URL: "http://logs", Name: "logs", FallbackToDefault: true}},
+			Failback:   &FailbackConfig{Policy: policy, Delay: time.Minute},
+		}
+		buildMethodURLMap()
+		routeFallbacks = make(map[string]bool)
+		upstreamStatuses = map[string]*upstreamStatus{"http://logs": {URL: "http://logs", LastProbe: time.Now(), LastError: "connection refused"}}
+		down, _ := router.Route(req)
+		upstreamStatuses["http://logs"].Healthy = true
+		upstreamStatuses["http://logs"].RecoveredAt = time.Now().Add(-ago)
+		after, _ := router.Route(req)
+		return down, after
+	}
+
+	// Test
+	_, immediate := recoveredAgo(failbackImmediate, 0)
+	_, delayedEarly := recoveredAgo(failbackDelayed, 10*time.Second)
+	_, delayedLate := recoveredAgo(failbackDelayed, 2*time.Minute)
+	_, manual := recoveredAgo(failbackManual, time.Hour)
+	released, err := releaseFallbacks("logs")
+	afterRelease, _ := router.Route(req)
+
+	// Verify
+	if immediate.URL != "http://logs" {
+		t.Errorf("Expected immediate failback, got %s", immediate.URL)
+	}
+	if delayedEarly.URL != "http://default" {
+		t.Errorf("Expected the default route before the delay has passed, got %s", delayedEarly.URL)
+	}
+	if delayedLate.URL != "http://logs" {
+		t.Errorf("Expected failback once the delay has passed, got %s", delayedLate.URL)
+	}
+	if manual.URL != "http://default" {
+		t.Errorf("Expected the default route until released, got %s", manual.URL)
+	}
+	if err != nil || len(released) != 1 || released[0] != "logs" {
+		t.Errorf("Expected logs to be released, got %v, %v", released, err)
+	}
+	if afterRelease.URL != "http://logs" {
+		t.Errorf("Expected the route's own upstream once released, got %s", afterRelease.URL)
+	}
+}
+
+// TestReleaseFallbackUnhealthy tests that a route cannot be released while its upstream is unhealthy
+func TestReleaseFallbackUnhealthy(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default",
+		Routes:     []Route{{Method: "eth_getLogs", URL: "http://logs", Name: "logs", FallbackToDefault: true}},
+	}
+	upstreamStatuses = map[string]*upstreamStatus{"http://logs": {URL: "http://logs", LastProbe: time.Now()}}
+	routeFallbacks = map[string]bool{"logs": true}
+	defer func() {
+		config = Config{}
+		upstreamStatuses = nil
+		routeFallbacks = make(map[string]bool)
+	}()
+
+	// Test
+	_, err := releaseFallbacks("logs")
+	released, allErr := releaseFallbacks("*")
+
+	// Verify
+	if err == nil {
+		t.Errorf("Expected an error releasing a route with an unhealthy upstream")
+	}
+	if allErr != nil || len(released) != 0 || !routeFallbacks["logs"] {
+		t.Errorf("Expected * to skip unhealthy routes, got %v, %v", released, allErr)
+	}
+}
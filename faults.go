@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fault injection
+//
+// For testing client resiliency, the proxy can behave like a misbehaving RPC endpoint:
+// fault rules add latency, answer with JSON-RPC errors or HTTP error statuses instead of
+// forwarding, or truncate responses, for a fraction of the calls to given methods. Rules
+// are configured up front and switched on and off at runtime through the admin API
+// (GET and POST /admin/faults), so an environment can be degraded on demand. For a batch,
+// a rule applies if it matches any call, and an injected error answers every call.
+
+// FaultConfig configures fault injection.
+type FaultConfig struct {
+	Enabled bool        `yaml:"enabled"` // Inject faults from startup (default: off until enabled via the admin API)
+	Rules   []FaultRule `yaml:"rules"`   // Fault rules, first firing rule applies
+}
+
+// FaultRule describes a fault injected into matching calls.
+type FaultRule struct {
+	Method     string        `yaml:"method"`      // Method to affect (empty or "*": all)
+	Rate       float64       `yaml:"rate"`        // Fraction of matching requests affected, 0 to 1 (default: 1)
+	Latency    time.Duration `yaml:"latency"`     // Delay added before the request is served
+	Jitter     time.Duration `yaml:"jitter"`      // Random extra delay up to this value
+	Error      *JSONRPCError `yaml:"error"`       // JSON-RPC error returned instead of forwarding
+	HTTPStatus int           `yaml:"http_status"` // HTTP status returned instead of forwarding
+	Truncate   bool          `yaml:"truncate"`    // Cut the response body in half
+}
+
+var (
+	faultsMu      sync.RWMutex // Protects faultsEnabled
+	faultsEnabled bool         // Whether faults are currently injected
+)
+
+// setupFaults initializes fault injection and registers its admin endpoint.
+func setupFaults() {
+	faultsMu.Lock()
+	faultsEnabled = config.Faults != nil && config.Faults.Enabled
+	faultsMu.Unlock()
+	if config.Faults != nil && len(config.Faults.Rules) > 0 {
+		registerAdminHandler("/admin/faults", handleAdminFaults)
+		if faultsEnabled {
+			log.Printf("Fault injection enabled with %d rules", len(config.Faults.Rules))
+		}
+	}
+}
+
+// handleAdminFaults reports fault injection status on GET and toggles it on POST with a
+// body of {"enabled": true|false}.
+func handleAdminFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, `Expected a body of {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+		faultsMu.Lock()
+		faultsEnabled = *req.Enabled
+		faultsMu.Unlock()
+		log.Printf("Fault injection %s via admin API", map[bool]string{true: "enabled", false: "disabled"}[*req.Enabled])
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	faultsMu.RLock()
+	status := struct {
+		Enabled bool `json:"enabled"`
+		Rules   int  `json:"rules"`
+	}{faultsEnabled, len(config.Faults.Rules)}
+	faultsMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// pickFault returns the first rule that fires for a request calling the given methods.
+func pickFault(methods []string) *FaultRule {
+	faultsMu.RLock()
+	enabled := faultsEnabled
+	faultsMu.RUnlock()
+	if !enabled || config.Faults == nil {
+		return nil
+	}
+
+	for i := range config.Faults.Rules {
+		rule := &config.Faults.Rules[i]
+		if !faultMatches(rule, methods) {
+			continue
+		}
+		if rule.Rate > 0 && rule.Rate < 1 && rand.Float64() >= rule.Rate {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// faultMatches reports whether a rule applies to any of the methods.
+func faultMatches(rule *FaultRule, methods []string) bool {
+	if rule.Method == "" || rule.Method == "*" {
+		return true
+	}
+	for _, method := range methods {
+		if method == rule.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// withFaults wraps the proxy handler with fault injection.
+//
+// Parameters:
+//   - next: The proxy handler
+//
+// Returns:
+//   - http.HandlerFunc: The handler injecting faults when enabled
+func withFaults(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Faults == nil || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		body, ok := peekBody(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		calls := parseCalls(body)
+		methods := make([]string, len(calls))
+		for i, call := range calls {
+			methods[i] = call.Method
+		}
+		rule := pickFault(methods)
+		if rule == nil {
+			next(w, r)
+			return
+		}
+
+		if !faultDelay(r.Context(), rule) {
+			return
+		}
+		switch {
+		case rule.HTTPStatus != 0:
+			log.Printf("Fault injection: HTTP %d for %v", rule.HTTPStatus, methods)
+			http.Error(w, http.StatusText(rule.HTTPStatus), rule.HTTPStatus)
+		case rule.Error != nil:
+			log.Printf("Fault injection: error %d for %v", rule.Error.Code, methods)
+			writeFaultError(w, body, calls, rule.Error)
+		case rule.Truncate:
+			log.Printf("Fault injection: truncating response for %v", methods)
+			rec := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+			next(rec, r)
+			w.Header().Del("Content-Length")
+			w.WriteHeader(rec.status)
+			w.Write(rec.body[:len(rec.body)/2])
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// faultDelay sleeps for the rule's latency plus jitter.
+//
+// Returns:
+//   - bool: False if the client went away while waiting
+func faultDelay(ctx context.Context, rule *FaultRule) bool {
+	delay := rule.Latency
+	if rule.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(rule.Jitter)))
+	}
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeFaultError answers a request, or every call of a batch, with an injected error.
+func writeFaultError(w http.ResponseWriter, body []byte, calls []JSONRPCRequest, rpcErr *JSONRPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(callErrorsBody(body, calls, rpcErr))
+}
+
+// callErrorsBody builds the response answering a request, or every call of a batch,
+// with an error.
+func callErrorsBody(body []byte, calls []JSONRPCRequest, rpcErr *JSONRPCError) []byte {
+	var data []byte
+	if isBatch(body) {
+		responses := make([]JSONRPCResponse, len(calls))
+		for i, call := range calls {
+			responses[i] = JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Error: rpcErr}
+		}
+		data, _ = json.Marshal(responses)
+	} else {
+		data, _ = json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: responseID(body), Error: rpcErr})
+	}
+	return data
+}
+
+// bufferedResponse captures a handler's response so it can be altered before sending.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+// Header implements http.ResponseWriter.
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+// WriteHeader implements http.ResponseWriter.
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+// Write implements http.ResponseWriter.
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.body = append(b.body, p...)
+	return len(p), nil
+}
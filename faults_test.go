@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFaultInjection tests injected errors, statuses, truncation, and latency
+func TestFaultInjection(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_blockNumber", `{"jsonrpc":"2.0","id":1,"result":"0x10"}`)
+	defer server.Close()
+	defer func() {
+		config = Config{}
+		setupFaults()
+	}()
+
+	call := func(rule FaultRule, body string) *httptest.ResponseRecorder {
+		config = Config{DefaultURL: server.URL, Faults: &FaultConfig{Enabled: true, Rules: []FaultRule{rule}}}
+		buildMethodURLMap()
+		setupFaults()
+		w := httptest.NewRecorder()
+		withFaults(handleProxy)(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w
+	}
+	single := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+
+	// Test: injected JSON-RPC error
+	w := call(FaultRule{Method: "eth_blockNumber", Error: &JSONRPCError{Code: -32000, Message: "injected"}}, single)
+	var resp JSONRPCResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error == nil || resp.Error.Code != -32000 || resp.ID != float64(1) {
+		t.Errorf("Expected an injected error for id 1, got %s", w.Body.String())
+	}
+
+	// Test: injected error for every call of a batch
+	w = call(FaultRule{Error: &JSONRPCError{Code: -32000, Message: "injected"}},
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 2 {
+		t.Errorf("Expected 2 injected errors, got %s", w.Body.String())
+	}
+
+	// Test: injected HTTP status
+	w = call(FaultRule{HTTPStatus: 502}, single)
+	if w.Code != 502 {
+		t.Errorf("Expected status 502, got %d", w.Code)
+	}
+
+	// Test: truncated response
+	w = call(FaultRule{Truncate: true}, single)
+	if json.Valid(w.Body.Bytes()) || w.Body.Len() == 0 {
+		t.Errorf("Expected a truncated response, got %q", w.Body.String())
+	}
+
+	// Test: latency
+	start := time.Now()
+	w = call(FaultRule{Latency: 50 * time.Millisecond}, single)
+	if time.Since(start) < 50*time.Millisecond || !strings.Contains(w.Body.String(), "0x10") {
+		t.Errorf("Expected a delayed successful response, got %q after %s", w.Body.String(), time.Since(start))
+	}
+
+	// Test: rules for other methods do not apply
+	w = call(FaultRule{Method: "eth_getLogs", HTTPStatus: 502}, single)
+	if w.Code != 200 {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+// TestAdminFaultsToggle tests switching fault injection through the admin API
+func TestAdminFaultsToggle(t *testing.T) {
+	// Setup
+	config = Config{
+		Admin:  &AdminConfig{Enabled: true, Token: "t"},
+		Faults: &FaultConfig{Rules: []FaultRule{{HTTPStatus: 503}}},
+	}
+	defer func() {
+		config = Config{}
+		setupFaults()
+	}()
+	setupFaults()
+	handler := adminHandler()
+
+	// Verify faults start disabled
+	if pickFault([]string{"eth_call"}) != nil {
+		t.Fatalf("Expected faults to start disabled")
+	}
+
+	// Test
+	r := httptest.NewRequest("POST", "/admin/faults", strings.NewReader(`{"enabled":true}`))
+	r.Header.Set("Authorization", "Bearer t")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// Verify
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"enabled":true`) {
+		t.Fatalf("Expected faults to be enabled, got %d %s", w.Code, w.Body.String())
+	}
+	if pickFault([]string{"eth_call"}) == nil {
+		t.Errorf("Expected the rule to fire once enabled")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fee aggregation
+//
+// A single provider's fee estimate can be noticeably biased. When fee aggregation is
+// enabled, eth_gasPrice, eth_maxPriorityFeePerGas, and eth_feeHistory are sent to
+// several upstreams in parallel and the results are combined with a configurable
+// strategy (median, min, or max). eth_feeHistory is combined element-wise across the
+// responses that cover the same block range.
+
+// FeeAggregationConfig configures fee aggregation across upstreams.
+type FeeAggregationConfig struct {
+	Enabled      bool          `yaml:"enabled"`       // Aggregate fee methods across upstreams
+	Methods      []string      `yaml:"methods"`       // Methods to aggregate (default: eth_gasPrice, eth_maxPriorityFeePerGas, eth_feeHistory)
+	Upstreams    []string      `yaml:"upstreams"`     // Upstream URLs to query (default: all configured upstreams)
+	Strategy     string        `yaml:"strategy"`      // "median" (default), "min", or "max"
+	Timeout      time.Duration `yaml:"timeout"`       // Per-upstream timeout (default: 2s)
+	MinResponses int           `yaml:"min_responses"` // Minimum successful responses required (default: 1)
+}
+
+// defaultFeeMethods are aggregated when no methods are configured.
+var defaultFeeMethods = []string{"eth_gasPrice", "eth_maxPriorityFeePerGas", "eth_feeHistory"}
+
+// feeMethods records which methods are currently registered for aggregation.
+var feeMethods []string
+
+// setupFeeAggregation validates the configuration and registers the aggregated methods.
+//
+// Returns:
+//   - error: An error if the strategy is unknown
+func setupFeeAggregation() error {
+	for _, m := range feeMethods {
+		unregisterLocalMethod(m)
+	}
+	feeMethods = nil
+
+	fc := config.FeeAggregation
+	if fc == nil || !fc.Enabled {
+		return nil
+	}
+	switch fc.Strategy {
+	case "", "median", "min", "max":
+	default:
+		return fmt.Errorf("unknown strategy %q (expected median, min, or max)", fc.Strategy)
+	}
+
+	methods := fc.Methods
+	if len(methods) == 0 {
+		methods = defaultFeeMethods
+	}
+	for _, m := range methods {
+		switch m {
+		case "eth_gasPrice", "eth_maxPriorityFeePerGas":
+			registerLocalMethod(m, handleAggregatedQuantity)
+		case "eth_feeHistory":
+			registerLocalMethod(m, handleAggregatedFeeHistory)
+		default:
+			return fmt.Errorf("method %s cannot be aggregated", m)
+		}
+		feeMethods = append(feeMethods, m)
+	}
+	return nil
+}
+
+// feeUpstreams returns the upstream URLs to query.
+func feeUpstreams() []string {
+	if len(config.FeeAggregation.Upstreams) > 0 {
+		return config.FeeAggregation.Upstreams
+	}
+	targets := probeTargets()
+	urls := make([]string, 0, len(targets))
+	for url := range targets {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// fanOut calls every fee upstream in parallel and returns the successful raw results.
+func fanOut(ctx context.Context, req *JSONRPCRequest) ([]json.RawMessage, *JSONRPCError) {
+	fc := config.FeeAggregation
+	timeout := fc.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	minResponses := fc.MinResponses
+	if minResponses <= 0 {
+		minResponses = 1
+	}
+
+	urls := feeUpstreams()
+	var (
+		mu      sync.Mutex
+		results []json.RawMessage
+		errs    []string
+		wg      sync.WaitGroup
+	)
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			callCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			raw, err := callUpstream(callCtx, url, req.Method, req.Params)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			results = append(results, raw)
+		}(url)
+	}
+	wg.Wait()
+
+	if len(results) < minResponses {
+		return nil, &JSONRPCError{
+			Code:    -32002,
+			Message: fmt.Sprintf("only %d of %d upstreams answered %s", len(results), len(urls), req.Method),
+			Data:    errs,
+		}
+	}
+	if len(errs) > 0 {
+		logWarn("router", "Fee aggregation for %s: %d of %d upstreams failed", req.Method, len(errs), len(urls))
+	}
+	return results, nil
+}
+
+// handleAggregatedQuantity aggregates a method returning a single hex quantity.
+func handleAggregatedQuantity(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	results, rpcErr := fanOut(ctx, req)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	values := make([]*big.Int, 0, len(results))
+	for _, raw := range results {
+		if v, err := parseBigQuantity(raw); err == nil {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil, &JSONRPCError{Code: -32603, Message: "no upstream returned a valid quantity"}
+	}
+	return toBigQuantity(aggregateBig(values, config.FeeAggregation.Strategy)), nil
+}
+
+// feeHistory is the result of eth_feeHistory.
+type feeHistory struct {
+	OldestBlock   string     `json:"oldestBlock"`
+	BaseFeePerGas []string   `json:"baseFeePerGas"`
+	GasUsedRatio  []float64  `json:"gasUsedRatio"`
+	Reward        [][]string `json:"reward,omitempty"`
+}
+
+// handleAggregatedFeeHistory aggregates eth_feeHistory element-wise across the
+// responses that share the most common oldestBlock and shape.
+func handleAggregatedFeeHistory(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	results, rpcErr := fanOut(ctx, req)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	// Group compatible responses
+	groups := make(map[string][]feeHistory)
+	for _, raw := range results {
+		var fh feeHistory
+		if err := json.Unmarshal(raw, &fh); err != nil {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d/%d", strings.ToLower(fh.OldestBlock), len(fh.BaseFeePerGas), len(fh.Reward))
+		groups[key] = append(groups[key], fh)
+	}
+	var best []feeHistory
+	for _, g := range groups {
+		if len(g) > len(best) {
+			best = g
+		}
+	}
+	if len(best) == 0 {
+		return nil, &JSONRPCError{Code: -32603, Message: "no upstream returned a valid fee history"}
+	}
+
+	strategy := config.FeeAggregation.Strategy
+	out := feeHistory{
+		OldestBlock:   best[0].OldestBlock,
+		BaseFeePerGas: make([]string, len(best[0].BaseFeePerGas)),
+		GasUsedRatio:  make([]float64, len(best[0].GasUsedRatio)),
+	}
+	for i := range out.BaseFeePerGas {
+		out.BaseFeePerGas[i] = aggregateColumn(best, strategy, func(fh feeHistory) string { return fh.BaseFeePerGas[i] })
+	}
+	for i := range out.GasUsedRatio {
+		ratios := make([]float64, 0, len(best))
+		for _, fh := range best {
+			if i < len(fh.GasUsedRatio) {
+				ratios = append(ratios, fh.GasUsedRatio[i])
+			}
+		}
+		out.GasUsedRatio[i] = aggregateFloat(ratios, strategy)
+	}
+	if len(best[0].Reward) > 0 {
+		out.Reward = make([][]string, len(best[0].Reward))
+		for i := range out.Reward {
+			out.Reward[i] = make([]string, len(best[0].Reward[i]))
+			for j := range out.Reward[i] {
+				out.Reward[i][j] = aggregateColumn(best, strategy, func(fh feeHistory) string {
+					if j < len(fh.Reward[i]) {
+						return fh.Reward[i][j]
+					}
+					return ""
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// aggregateColumn aggregates one hex quantity position across fee histories.
+func aggregateColumn(histories []feeHistory, strategy string, pick func(feeHistory) string) string {
+	values := make([]*big.Int, 0, len(histories))
+	for _, fh := range histories {
+		if v, ok := new(big.Int).SetString(strings.TrimPrefix(pick(fh), "0x"), 16); ok {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return "0x0"
+	}
+	return toBigQuantity(aggregateBig(values, strategy))
+}
+
+// aggregateBig combines values using the strategy. The median of an even count is the
+// mean of the two middle values.
+func aggregateBig(values []*big.Int, strategy string) *big.Int {
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+	switch strategy {
+	case "min":
+		return values[0]
+	case "max":
+		return values[len(values)-1]
+	}
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return values[mid]
+	}
+	sum := new(big.Int).Add(values[mid-1], values[mid])
+	return sum.Rsh(sum, 1)
+}
+
+// aggregateFloat combines float values using the strategy.
+func aggregateFloat(values []float64, strategy string) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	switch strategy {
+	case "min":
+		return values[0]
+	case "max":
+		return values[len(values)-1]
+	}
+	mid := len(values) / 2
+	if len(values)%2 == 1 {
+		return values[mid]
+	}
+	return (values[mid-1] + values[mid]) / 2
+}
+
+// parseBigQuantity parses a raw JSON hex quantity of arbitrary size.
+func parseBigQuantity(raw json.RawMessage) (*big.Int, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok || !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("invalid quantity %q", s)
+	}
+	return v, nil
+}
+
+// toBigQuantity encodes a big integer as a hex JSON-RPC quantity.
+func toBigQuantity(v *big.Int) string {
+	return "0x" + v.Text(16)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// mockFeeServer creates a test upstream answering fee methods with fixed values
+func mockFeeServer(t *testing.T, gasPrice string, baseFees []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req JSONRPCRequest
+		json.Unmarshal(body, &req)
+
+		var result interface{}
+		switch req.Method {
+		case "eth_gasPrice":
+			result = gasPrice
+		case "eth_feeHistory":
+			result = feeHistory{OldestBlock: "0x10", BaseFeePerGas: baseFees, GasUsedRatio: []float64{0.5}}
+		}
+		resp, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID})
+		w.Write(resp)
+	}))
+}
+
+// TestFeeAggregation tests aggregating fee estimates across upstreams
+func TestFeeAggregation(t *testing.T) {
+	// Setup
+	s1 := mockFeeServer(t, "0x1", []string{"0x10", "0x20"})
+	defer s1.Close()
+	s2 := mockFeeServer(t, "0x2", []string{"0x30", "0x40"})
+	defer s2.Close()
+	s3 := mockFeeServer(t, "0xa", []string{"0x50", "0x60"})
+	defer s3.Close()
+
+	defer func() {
+		config.FeeAggregation = nil
+		setupFeeAggregation()
+	}()
+
+	testCases := []struct {
+		strategy string
+		gasPrice string
+		baseFees []string
+	}{
+		{"median", "0x2", []string{"0x30", "0x40"}},
+		{"min", "0x1", []string{"0x10", "0x20"}},
+		{"max", "0xa", []string{"0x50", "0x60"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			config = Config{
+				DefaultURL: s1.URL,
+				FeeAggregation: &FeeAggregationConfig{
+					Enabled:   true,
+					Strategy:  tc.strategy,
+					Upstreams: []string{s1.URL, s2.URL, s3.URL},
+				},
+			}
+			buildMethodURLMap()
+			if err := setupFeeAggregation(); err != nil {
+				t.Fatalf("Failed to set up fee aggregation: %v", err)
+			}
+
+			// Test
+			var gasPrice string
+			if err := callProxy(t, "eth_gasPrice", []interface{}{}, &gasPrice); err != nil {
+				t.Fatalf("eth_gasPrice failed: %v", err)
+			}
+			var history feeHistory
+			if err := callProxy(t, "eth_feeHistory", []interface{}{"0x2", "latest", []interface{}{}}, &history); err != nil {
+				t.Fatalf("eth_feeHistory failed: %v", err)
+			}
+
+			// Verify
+			if gasPrice != tc.gasPrice {
+				t.Errorf("Expected gas price %s, got %s", tc.gasPrice, gasPrice)
+			}
+			if len(history.BaseFeePerGas) != 2 || history.BaseFeePerGas[0] != tc.baseFees[0] || history.BaseFeePerGas[1] != tc.baseFees[1] {
+				t.Errorf("Expected base fees %v, got %v", tc.baseFees, history.BaseFeePerGas)
+			}
+		})
+	}
+}
+
+// TestFeeAggregationMinResponses tests failure when too few upstreams answer
+func TestFeeAggregationMinResponses(t *testing.T) {
+	// Setup
+	s1 := mockFeeServer(t, "0x1", nil)
+	defer s1.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	config = Config{
+		DefaultURL: s1.URL,
+		FeeAggregation: &FeeAggregationConfig{
+			Enabled:      true,
+			Upstreams:    []string{s1.URL, down.URL},
+			MinResponses: 2,
+		},
+	}
+	buildMethodURLMap()
+	if err := setupFeeAggregation(); err != nil {
+		t.Fatalf("Failed to set up fee aggregation: %v", err)
+	}
+	defer func() {
+		config.FeeAggregation = nil
+		setupFeeAggregation()
+	}()
+
+	// Test
+	err := callProxy(t, "eth_gasPrice", []interface{}{}, nil)
+
+	// Verify
+	if err == nil || err.Code != -32002 {
+		t.Errorf("Expected -32002 error when too few upstreams answer, got %v", err)
+	}
+}
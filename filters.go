@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Filter API emulation
+//
+// Many providers do not support stateful filters, and the proxy may send a client's
+// calls to different upstreams anyway. When enabled, the proxy answers
+// eth_newFilter, eth_newBlockFilter, eth_getFilterChanges, eth_getFilterLogs, and
+// eth_uninstallFilter itself. Filter state lives in the proxy; changes are computed
+// from the tracked chain head with eth_getLogs and eth_getBlockByNumber calls routed
+// like any other request. The filters held at once are capped overall (max_filters,
+// default 10000) and per client (max_filters_per_client, default 100; clients are told
+// apart as in clients.go); creating one more fails with a -32005 error until filters
+// are uninstalled or expire.
+
+// FiltersConfig configures local filter emulation.
+type FiltersConfig struct {
+	Enabled             bool          `yaml:"enabled"`                // Answer filter methods locally
+	TTL                 time.Duration `yaml:"ttl"`                    // Filters not polled for this long are removed (default: 5m)
+	MaxFilters          int           `yaml:"max_filters"`            // Filters held at once (default: 10000)
+	MaxFiltersPerClient int           `yaml:"max_filters_per_client"` // Filters held at once by one client (default: 100)
+}
+
+// Filter limit defaults.
+const (
+	defaultMaxFilters          = 10000
+	defaultMaxFiltersPerClient = 100
+)
+
+// maxBlockFilterRange bounds how many block hashes one eth_getFilterChanges call returns.
+const maxBlockFilterRange = 256
+
+// localFilter is the state of an emulated filter.
+type localFilter struct {
+	blockFilter bool                   // Block filter (true) or log filter (false)
+	client      string                 // Key of the client that created the filter
+	criteria    map[string]interface{} // Log filter criteria as sent by the client
+	lastBlock   uint64                 // Last block already reported
+	lastPoll    time.Time              // Time of the last access, for expiry
+	done        bool                   // A blockHash filter that has already returned its logs
+}
+
+var (
+	filtersMu    sync.Mutex
+	localFilters = make(map[string]*localFilter) // Emulated filters by ID
+)
+
+// setupFilters registers the filter methods as local methods when emulation is enabled.
+func setupFilters() {
+	methods := map[string]localHandler{
+		"eth_newFilter":        handleNewFilter,
+		"eth_newBlockFilter":   handleNewBlockFilter,
+		"eth_getFilterChanges": handleGetFilterChanges,
+		"eth_getFilterLogs":    handleGetFilterLogs,
+		"eth_uninstallFilter":  handleUninstallFilter,
+	}
+	for method, handler := range methods {
+		if config.Filters != nil && config.Filters.Enabled {
+			registerLocalMethod(method, handler)
+		} else {
+			unregisterLocalMethod(method)
+		}
+	}
+}
+
+// filterTTL returns how long an unpolled filter is kept.
+func filterTTL() time.Duration {
+	if config.Filters != nil && config.Filters.TTL > 0 {
+		return config.Filters.TTL
+	}
+	return 5 * time.Minute
+}
+
+// newFilterID returns a random filter identifier.
+func newFilterID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "0x" + hex.EncodeToString(b)
+}
+
+// expireFilters removes filters that have not been polled within the TTL.
+// The caller must hold filtersMu.
+func expireFilters(now time.Time) {
+	ttl := filterTTL()
+	for id, f := range localFilters {
+		if now.Sub(f.lastPoll) > ttl {
+			delete(localFilters, id)
+		}
+	}
+}
+
+// filterLimits returns the number of filters held at once, overall and per client.
+func filterLimits() (int, int) {
+	total, perClient := defaultMaxFilters, defaultMaxFiltersPerClient
+	if fc := config.Filters; fc != nil {
+		if fc.MaxFilters > 0 {
+			total = fc.MaxFilters
+		}
+		if fc.MaxFiltersPerClient > 0 {
+			perClient = fc.MaxFiltersPerClient
+		}
+	}
+	return total, perClient
+}
+
+// installFilter stores a filter for the client sending a request.
+//
+// Parameters:
+//   - r: The request, identifying the client
+//   - f: The filter
+//
+// Returns:
+//   - interface{}: The filter ID
+//   - *JSONRPCError: A -32005 error if the proxy or the client holds too many filters
+func installFilter(r *http.Request, f *localFilter) (interface{}, *JSONRPCError) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	now := time.Now()
+	expireFilters(now)
+	f.client = clientFromRequest(r).key()
+	total, perClient := filterLimits()
+	if len(localFilters) >= total {
+		return nil, &JSONRPCError{Code: -32005, Message: fmt.Sprintf("too many filters: the proxy holds its limit of %d", total)}
+	}
+	held := 0
+	for _, other := range localFilters {
+		if other.client == f.client {
+			held++
+		}
+	}
+	if held >= perClient {
+		return nil, &JSONRPCError{Code: -32005, Message: fmt.Sprintf("too many filters: the limit is %d per client; uninstall unused filters", perClient)}
+	}
+	f.lastPoll = now
+	id := newFilterID()
+	localFilters[id] = f
+	return id, nil
+}
+
+// lookupFilter returns the filter named by the first param and refreshes its expiry.
+func lookupFilter(req *JSONRPCRequest) (string, *localFilter, *JSONRPCError) {
+	params, _ := req.Params.([]interface{})
+	if len(params) < 1 {
+		return "", nil, &JSONRPCError{Code: -32602, Message: "missing filter id"}
+	}
+	id, ok := params[0].(string)
+	if !ok {
+		return "", nil, &JSONRPCError{Code: -32602, Message: "invalid filter id"}
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	now := time.Now()
+	expireFilters(now)
+	f, ok := localFilters[id]
+	if !ok {
+		return id, nil, &JSONRPCError{Code: -32000, Message: "filter not found"}
+	}
+	f.lastPoll = now
+	return id, f, nil
+}
+
+// handleNewBlockFilter creates a filter that reports new block hashes.
+func handleNewBlockFilter(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	url, err := routeFor("eth_getBlockByNumber", nil)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	head, err := currentHead(ctx, url)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return installFilter(r, &localFilter{blockFilter: true, lastBlock: head})
+}
+
+// handleNewFilter creates a log filter from the client's criteria.
+func handleNewFilter(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	params, _ := req.Params.([]interface{})
+	criteria := map[string]interface{}{}
+	if len(params) > 0 && params[0] != nil {
+		c, ok := params[0].(map[string]interface{})
+		if !ok {
+			return nil, &JSONRPCError{Code: -32602, Message: "invalid filter criteria"}
+		}
+		criteria = c
+	}
+
+	url, err := routeFor("eth_getLogs", []interface{}{criteria})
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	head, err := currentHead(ctx, url)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	// Changes start after the current head, or just before an explicit future fromBlock
+	lastBlock := head
+	if from, ok := criteria["fromBlock"].(string); ok {
+		if n, err := parseQuantity(from); err == nil && n > 0 && n-1 > head {
+			lastBlock = n - 1
+		}
+	}
+	return installFilter(r, &localFilter{criteria: criteria, lastBlock: lastBlock})
+}
+
+// handleUninstallFilter removes a filter.
+func handleUninstallFilter(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	id, _, rpcErr := lookupFilter(req)
+	if rpcErr != nil {
+		if rpcErr.Code == -32000 {
+			return false, nil
+		}
+		return nil, rpcErr
+	}
+
+	filtersMu.Lock()
+	delete(localFilters, id)
+	filtersMu.Unlock()
+	return true, nil
+}
+
+// handleGetFilterChanges returns what happened since the filter was last polled.
+func handleGetFilterChanges(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	_, f, rpcErr := lookupFilter(req)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if f.blockFilter {
+		return blockFilterChanges(ctx, f)
+	}
+	return logFilterChanges(ctx, f)
+}
+
+// handleGetFilterLogs returns all logs matching a log filter's criteria.
+func handleGetFilterLogs(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	_, f, rpcErr := lookupFilter(req)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if f.blockFilter {
+		return nil, &JSONRPCError{Code: -32000, Message: "filter not found"}
+	}
+
+	params := []interface{}{f.criteria}
+	url, err := routeFor("eth_getLogs", params)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	logs, err := callUpstream(ctx, url, "eth_getLogs", params)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return logs, nil
+}
+
+// blockFilterChanges returns the hashes of blocks produced since the last poll.
+func blockFilterChanges(ctx context.Context, f *localFilter) (interface{}, *JSONRPCError) {
+	url, err := routeFor("eth_getBlockByNumber", nil)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	head, err := currentHead(ctx, url)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	filtersMu.Lock()
+	from := f.lastBlock + 1
+	filtersMu.Unlock()
+	if head < from {
+		return []string{}, nil
+	}
+	if head-from >= maxBlockFilterRange {
+		from = head - maxBlockFilterRange + 1
+	}
+
+	hashes := make([]string, 0, head-from+1)
+	for n := from; n <= head; n++ {
+		raw, err := callUpstream(ctx, url, "eth_getBlockByNumber", []interface{}{toQuantity(n), false})
+		if err != nil {
+			return nil, upstreamError(err)
+		}
+		var block struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(raw, &block); err != nil || block.Hash == "" {
+			// The block is not available on this upstream yet; report it next time
+			head = n - 1
+			break
+		}
+		hashes = append(hashes, block.Hash)
+	}
+
+	filtersMu.Lock()
+	if head > f.lastBlock {
+		f.lastBlock = head
+	}
+	filtersMu.Unlock()
+	return hashes, nil
+}
+
+// logFilterChanges returns logs matching the filter in blocks produced since the last poll.
+func logFilterChanges(ctx context.Context, f *localFilter) (interface{}, *JSONRPCError) {
+	filtersMu.Lock()
+	criteria := make(map[string]interface{}, len(f.criteria))
+	for k, v := range f.criteria {
+		criteria[k] = v
+	}
+	from := f.lastBlock + 1
+	done := f.done
+	filtersMu.Unlock()
+
+	// A blockHash filter covers exactly one block and reports it once
+	if _, ok := criteria["blockHash"]; ok {
+		if done {
+			return []interface{}{}, nil
+		}
+		params := []interface{}{criteria}
+		url, err := routeFor("eth_getLogs", params)
+		if err != nil {
+			return nil, upstreamError(err)
+		}
+		logs, err := callUpstream(ctx, url, "eth_getLogs", params)
+		if err != nil {
+			return nil, upstreamError(err)
+		}
+		filtersMu.Lock()
+		f.done = true
+		filtersMu.Unlock()
+		return logs, nil
+	}
+
+	url, err := routeFor("eth_getLogs", []interface{}{criteria})
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	head, err := currentHead(ctx, url)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	to := head
+	if toBlock, ok := criteria["toBlock"].(string); ok {
+		if n, err := parseQuantity(toBlock); err == nil && n < to {
+			to = n
+		}
+	}
+	if to < from {
+		return []interface{}{}, nil
+	}
+
+	criteria["fromBlock"] = toQuantity(from)
+	criteria["toBlock"] = toQuantity(to)
+	logs, err := callUpstream(ctx, url, "eth_getLogs", []interface{}{criteria})
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	filtersMu.Lock()
+	if to > f.lastBlock {
+		f.lastBlock = to
+	}
+	filtersMu.Unlock()
+	return logs, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// mockChainServer creates a test upstream that answers eth_blockNumber, eth_getBlockByNumber,
+// and eth_getLogs from a head that the test can move forward
+func mockChainServer(t *testing.T, head *atomic.Uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req JSONRPCRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Failed to parse request: %v", err)
+			return
+		}
+
+		var result interface{}
+		params, _ := req.Params.([]interface{})
+		switch req.Method {
+		case "eth_blockNumber":
+			result = toQuantity(head.Load())
+		case "eth_getBlockByNumber":
+			n, _ := parseQuantity(params[0].(string))
+			result = map[string]string{"hash": fmt.Sprintf("0xhash%d", n)}
+		case "eth_getLogs":
+			criteria := params[0].(map[string]interface{})
+			result = []map[string]interface{}{{"fromBlock": criteria["fromBlock"], "toBlock": criteria["toBlock"]}}
+		default:
+			t.Errorf("Unexpected upstream method %s", req.Method)
+		}
+
+		resp, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}))
+}
+
+// callProxy sends a single JSON-RPC call through handleProxy and decodes the result
+func callProxy(t *testing.T, method string, params interface{}, result interface{}) *JSONRPCError {
+	t.Helper()
+
+	reqBytes, _ := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(reqBytes))
+	w := httptest.NewRecorder()
+	handleProxy(w, req)
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	body, _ := io.ReadAll(w.Result().Body)
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Failed to parse response for %s: %v\nResponse body: %s", method, err, string(body))
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			t.Fatalf("Failed to decode result for %s: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// setupFilterTest configures filter emulation against a mock chain
+func setupFilterTest(t *testing.T) (*atomic.Uint64, func()) {
+	head := &atomic.Uint64{}
+	head.Store(100)
+	server := mockChainServer(t, head)
+
+	config = Config{DefaultURL: server.URL, Filters: &FiltersConfig{Enabled: true}}
+	buildMethodURLMap()
+	setupFilters()
+
+	return head, func() {
+		server.Close()
+		config.Filters = nil
+		setupFilters()
+	}
+}
+
+// TestBlockFilter tests block filter emulation
+func TestBlockFilter(t *testing.T) {
+	// Setup
+	head, cleanup := setupFilterTest(t)
+	defer cleanup()
+
+	var id string
+	if err := callProxy(t, "eth_newBlockFilter", []interface{}{}, &id); err != nil {
+		t.Fatalf("eth_newBlockFilter failed: %v", err)
+	}
+
+	// Test
+	head.Store(102)
+	var hashes []string
+	if err := callProxy(t, "eth_getFilterChanges", []interface{}{id}, &hashes); err != nil {
+		t.Fatalf("eth_getFilterChanges failed: %v", err)
+	}
+
+	// Verify
+	if len(hashes) != 2 || hashes[0] != "0xhash101" || hashes[1] != "0xhash102" {
+		t.Errorf("Expected hashes of blocks 101 and 102, got %v", hashes)
+	}
+
+	// A second poll without new blocks returns nothing
+	if err := callProxy(t, "eth_getFilterChanges", []interface{}{id}, &hashes); err != nil {
+		t.Fatalf("eth_getFilterChanges failed: %v", err)
+	}
+	if len(hashes) != 0 {
+		t.Errorf("Expected no new hashes, got %v", hashes)
+	}
+}
+
+// TestLogFilter tests log filter emulation and uninstalling filters
+func TestLogFilter(t *testing.T) {
+	// Setup
+	head, cleanup := setupFilterTest(t)
+	defer cleanup()
+
+	var id string
+	criteria := map[string]interface{}{"address": "0x1234"}
+	if err := callProxy(t, "eth_newFilter", []interface{}{criteria}, &id); err != nil {
+		t.Fatalf("eth_newFilter failed: %v", err)
+	}
+
+	// Test
+	head.Store(105)
+	var logs []map[string]string
+	if err := callProxy(t, "eth_getFilterChanges", []interface{}{id}, &logs); err != nil {
+		t.Fatalf("eth_getFilterChanges failed: %v", err)
+	}
+
+	// Verify the range queried covers exactly the new blocks
+	if len(logs) != 1 || logs[0]["fromBlock"] != "0x65" || logs[0]["toBlock"] != "0x69" {
+		t.Errorf("Expected logs for blocks 0x65-0x69, got %v", logs)
+	}
+
+	var removed bool
+	if err := callProxy(t, "eth_uninstallFilter", []interface{}{id}, &removed); err != nil || !removed {
+		t.Errorf("Expected filter to be uninstalled, got %v (error %v)", removed, err)
+	}
+	if err := callProxy(t, "eth_getFilterChanges", []interface{}{id}, nil); err == nil || err.Code != -32000 {
+		t.Errorf("Expected filter not found error, got %v", err)
+	}
+}
+
+// TestFilterLimits tests refusing new filters beyond the per-client and overall limits
+func TestFilterLimits(t *testing.T) {
+	// Setup
+	_, cleanup := setupFilterTest(t)
+	defer cleanup()
+	localFilters = make(map[string]*localFilter)
+	defer func() { localFilters = make(map[string]*localFilter) }()
+	config.Filters.MaxFilters = 3
+	config.Filters.MaxFiltersPerClient = 2
+	newFilter := func(apiKey string) *JSONRPCError {
+		reqBytes, _ := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", Method: "eth_newBlockFilter", Params: []interface{}{}, ID: 1})
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(reqBytes))
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		handleProxy(w, req)
+		var resp struct {
+			Error *JSONRPCError `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Error
+	}
+
+	// Test
+	var errs []*JSONRPCError
+	for _, apiKey := range []string{"alice", "alice", "alice", "bob", "carol"} {
+		errs = append(errs, newFilter(apiKey))
+	}
+
+	// Verify
+	if errs[0] != nil || errs[1] != nil || errs[3] != nil {
+		t.Errorf("Expected filters within the limits to be created, got %v", errs)
+	}
+	if errs[2] == nil || errs[2].Code != -32005 {
+		t.Errorf("Expected a third filter of one client to be refused with -32005, got %v", errs[2])
+	}
+	if errs[4] == nil || errs[4].Code != -32005 {
+		t.Errorf("Expected a filter beyond max_filters to be refused with -32005, got %v", errs[4])
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Forced upstream
+//
+// Provider-specific behavior is easiest to debug by replaying live calls against the
+// provider in question. A request carrying the admin token (see debugroute.go for how
+// it is sent) can pin all of its calls to a configured upstream by name, bypassing
+// routing altogether:
+//
+//	curl -H 'X-Force-Upstream: archive' -H "X-Admin-Token: $ADMIN_TOKEN" \
+//	  -d '{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[...]}' http://proxy:8545
+//
+// The name is that of the default upstream ("default" if it has none), a route, a pool
+// member, or a write upstream. Forced calls skip routes, stubs, param rules and method
+// rewriting, write routing, private transaction routing, and pre-route hooks; caching,
+// retries, and limits apply as usual. An unknown name is rejected with HTTP 400.
+// Without the admin API enabled, or without a valid token, the header is ignored.
+
+const forceUpstreamHeader = "X-Force-Upstream"
+
+type forcedUpstreamKey struct{}
+
+// withForcedUpstream wraps a proxy handler to resolve the upstream forced by an
+// authorized request.
+func withForcedUpstream(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(forceUpstreamHeader)
+		if name == "" || !operatorAuthorized(r) {
+			next(w, r)
+			return
+		}
+		upstream, ok := namedUpstream(name)
+		if !ok {
+			http.Error(w, "Unknown upstream in "+forceUpstreamHeader, http.StatusBadRequest)
+			return
+		}
+		logInfo("router", "Forcing request from %s to %s", clientIP(r), upstream.Name)
+		next(w, r.WithContext(context.WithValue(r.Context(), forcedUpstreamKey{}, upstream)))
+	}
+}
+
+// forcedUpstream returns the upstream a request is pinned to, if any.
+func forcedUpstream(ctx context.Context) (Upstream, bool) {
+	upstream, ok := ctx.Value(forcedUpstreamKey{}).(Upstream)
+	return upstream, ok
+}
+
+// namedUpstream finds a configured upstream by name.
+//
+// Parameters:
+//   - name: The upstream name
+//
+// Returns:
+//   - Upstream: The upstream, without a route
+//   - bool: Whether an upstream has the name
+func namedUpstream(name string) (Upstream, bool) {
+	for url, targetName := range probeTargets() {
+		if targetName == "" && url == config.DefaultURL {
+			targetName = "default"
+		}
+		if targetName == name && targetName != url {
+			return Upstream{Name: name, URL: url}, true
+		}
+	}
+	return Upstream{}, false
+}
+
+// routeCall picks the upstream of a call: the forced upstream, or the router's choice.
+//
+// Parameters:
+//   - ctx: The context of the request
+//   - req: The call to route
+//
+// Returns:
+//   - Upstream: The upstream serving the call
+//   - bool: Whether the upstream was forced, and routing bypassed
+//   - error: An error if the router fails
+func routeCall(ctx context.Context, req *JSONRPCRequest) (Upstream, bool, error) {
+	if upstream, ok := forcedUpstream(ctx); ok {
+		return upstream, true, nil
+	}
+	upstream, err := router.Route(req)
+	return upstream, false, err
+}
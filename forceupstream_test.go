@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestForcedUpstream tests pinning the calls of an authorized request to a named upstream
+func TestForcedUpstream(t *testing.T) {
+	// Setup
+	var defaultCalls, archiveCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultCalls.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"default"}`))
+	}))
+	defer server.Close()
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		archiveCalls.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"archive"}`))
+	}))
+	defer archive.Close()
+	config = Config{
+		DefaultURL: server.URL,
+		Routes:     []Route{{Method: "debug_traceTransaction", URL: archive.URL, Name: "archive"}},
+		Admin:      &AdminConfig{Enabled: true, Token: "s3cret"},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	handler := withForcedUpstream(handleProxy)
+	request := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[]}`))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// Test
+	forced := request("eth_blockNumber", map[string]string{forceUpstreamHeader: "archive", adminTokenHeader: "s3cret"})
+	toDefault := request("debug_traceTransaction", map[string]string{forceUpstreamHeader: "default", adminTokenHeader: "s3cret"})
+	unauthorized := request("eth_blockNumber", map[string]string{forceUpstreamHeader: "archive", adminTokenHeader: "nope"})
+	unknown := request("eth_blockNumber", map[string]string{forceUpstreamHeader: "nowhere", adminTokenHeader: "s3cret"})
+	blocked := request("admin_peers", map[string]string{forceUpstreamHeader: "archive", adminTokenHeader: "s3cret"})
+
+	// Verify
+	if !strings.Contains(forced.Body.String(), `"archive"`) {
+		t.Errorf("Expected the call to be forced to the archive upstream, got %s", forced.Body.String())
+	}
+	if !strings.Contains(toDefault.Body.String(), `"default"`) {
+		t.Errorf("Expected the routed call to be forced to the default upstream, got %s", toDefault.Body.String())
+	}
+	if !strings.Contains(unauthorized.Body.String(), `"default"`) {
+		t.Errorf("Expected the header to be ignored without the admin token, got %s", unauthorized.Body.String())
+	}
+	if unknown.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP 400 for an unknown upstream, got %d", unknown.Code)
+	}
+	if !strings.Contains(blocked.Body.String(), "-32601") {
+		t.Errorf("Expected blocked methods to stay blocked, got %s", blocked.Body.String())
+	}
+	if defaultCalls.Load() != 2 || archiveCalls.Load() != 1 {
+		t.Errorf("Expected 2 default and 1 archive calls, got %d and %d", defaultCalls.Load(), archiveCalls.Load())
+	}
+}
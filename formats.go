@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats
+//
+// The configuration may be written in YAML, JSON, or TOML, selected by the file
+// extension (.json, .toml, and YAML for anything else), ignoring the .tmpl extension
+// of templates (see templating.go). All formats share the same configuration model and
+// field names: each document is turned into a yaml.Node tree and goes through the same
+// strict decoding and validation (see schema.go).
+//
+// JSON is a subset of YAML, so JSON files are parsed by the YAML parser once they are
+// known to be valid JSON, which keeps line and column information for errors. TOML
+// documents are decoded generically and converted, so their errors carry paths only.
+
+// configFormat returns the format of a configuration file from its extension.
+func configFormat(filename string) string {
+	if isConfigTemplate(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+	return "yaml"
+}
+
+// parseConfigDocument parses a configuration file into a YAML node tree.
+//
+// Parameters:
+//   - filename: The name of the file, whose extension selects the format
+//   - data: The contents of the file
+//
+// Returns:
+//   - *yaml.Node: The root node of the document, or nil if the document is empty
+//   - error: A syntax error
+func parseConfigDocument(filename string, data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	switch configFormat(filename) {
+	case "json":
+		if err := checkJSONSyntax(data); err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case "toml":
+		var v map[string]interface{}
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			return nil, err
+		}
+		if err := doc.Encode(v); err != nil {
+			return nil, fmt.Errorf("error converting TOML: %w", err)
+		}
+		return &doc, nil
+	default:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return doc.Content[0], nil
+}
+
+// checkJSONSyntax reports JSON syntax errors with their line and column.
+func checkJSONSyntax(data []byte) error {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	if serr, ok := err.(*json.SyntaxError); ok {
+		prefix := data[:serr.Offset]
+		line := strings.Count(string(prefix), "\n") + 1
+		column := len(prefix) - strings.LastIndex(string(prefix), "\n")
+		return fmt.Errorf("line %d, column %d: %w", line, column, err)
+	}
+	return err
+}
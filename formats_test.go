@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigAs writes a configuration to a temporary file with the given name
+func writeConfigAs(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadJSONConfig tests loading a JSON configuration
+func TestLoadJSONConfig(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigAs(t, "config.json", `{
+	"default_url": "http://default.example.com",
+	"routes": [
+		{"method": "eth_call", "url": "http://archive.example.com", "name": "archive"}
+	],
+	"concurrency": {"max_in_flight": 10, "queue_timeout": "2s"}
+}`)
+
+	// Test
+	err := loadConfig(path)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.DefaultURL != "http://default.example.com" || len(config.Routes) != 1 || config.Routes[0].Name != "archive" {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+	if config.Concurrency == nil || config.Concurrency.MaxInFlight != 10 || config.Concurrency.QueueTimeout != 2*time.Second {
+		t.Errorf("Unexpected concurrency config: %+v", config.Concurrency)
+	}
+}
+
+// TestLoadTOMLConfig tests loading a TOML configuration
+func TestLoadTOMLConfig(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigAs(t, "config.toml", `
+default_pool = "main"
+
+[pools.main]
+strategy = "client_hash"
+
+[[pools.main.upstreams]]
+url = "http://a.example.com"
+name = "a"
+
+[[pools.main.upstreams]]
+url = "http://b.example.com"
+name = "b"
+
+[[routes]]
+method = "eth_chainId"
+stub = { result = "0x1" }
+
+[concurrency]
+queue_timeout = "250ms"
+`)
+
+	// Test
+	err := loadConfig(path)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	pool := config.Pools["main"]
+	if pool == nil || pool.Strategy != "client_hash" || len(pool.Upstreams) != 2 || pool.Upstreams[1].Name != "b" {
+		t.Errorf("Unexpected pool: %+v", pool)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].Stub == nil {
+		t.Errorf("Unexpected routes: %+v", config.Routes)
+	}
+	if config.Concurrency == nil || config.Concurrency.QueueTimeout != 250*time.Millisecond {
+		t.Errorf("Unexpected concurrency config: %+v", config.Concurrency)
+	}
+}
+
+// TestConfigFormatErrors tests that errors in JSON and TOML files are reported precisely
+func TestConfigFormatErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		data     string
+		expected string
+	}{
+		{"JSON unknown field", "config.json", "{\n  \"default_url\": \"http://a.example.com\",\n  \"rotues\": []\n}", "line 3, column 3: unknown field rotues in configuration (did you mean routes?)"},
+		{"JSON syntax error", "config.json", "{\n  \"default_url\": \"http://a.example.com\",\n}", "line 3, column"},
+		{"JSON with YAML syntax", "config.json", "default_url: http://a.example.com\n", "invalid character"},
+		{"TOML unknown field", "config.toml", "default_url = \"http://a.example.com\"\n[[routes]]\nmethod = \"eth_call\"\nurl = \"http://b.example.com\"\nnmae = \"b\"\n", "unknown field nmae in routes[0] (did you mean name?)"},
+		{"TOML invalid url", "config.toml", "default_url = \"ws://a.example.com\"\n", `default_url: invalid url "ws://a.example.com"`},
+		{"TOML syntax error", "config.toml", "default_url = \n", "line 1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+			path := writeConfigAs(t, tc.file, tc.data)
+
+			// Test
+			err := loadConfig(path)
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Global request ceiling
+//
+// Compute-unit rate limits (see ratelimit.go) share an upstream fairly between
+// clients, but a small deployment often just wants to never send its node more than a
+// fixed rate, however many clients there are. The whole instance can be capped in
+// calls per second:
+//
+//	global_rate_limit:
+//	  requests_per_second: 500
+//	  burst: 1000               # calls allowed at once (default: requests_per_second)
+//
+// Every JSON-RPC call counts once, so a batch of 10 calls takes 10 from the shared
+// token bucket, whatever its method, client, or upstream. A request that does not fit
+// is rejected as a whole with HTTP 429, a Retry-After header, and a JSON-RPC -32005
+// error for each call, before it is charged to its client's compute units or queued
+// for capacity. Rejected calls are counted in jsonrpc_proxy_global_rate_limited_total.
+
+// GlobalRateLimitConfig configures the instance-wide call rate ceiling.
+type GlobalRateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // Calls the instance may serve per second
+	Burst             float64 `yaml:"burst"`               // Calls the instance may serve at once (default: requests_per_second)
+}
+
+// globalRateLimitedTotal counts the calls rejected by the global ceiling.
+var globalRateLimitedTotal = newCounterVec("jsonrpc_proxy_global_rate_limited_total", "Calls rejected by the instance-wide rate ceiling.")
+
+// globalLimiter is the instance's token bucket.
+type globalLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	bucket unitBucket
+}
+
+// globalLimit is the global ceiling, or nil if there is none.
+var globalLimit *globalLimiter
+
+// setupGlobalRateLimit builds the global ceiling from the configuration.
+//
+// Returns:
+//   - error: An error if the rate or burst is invalid
+func setupGlobalRateLimit() error {
+	globalLimit = nil
+	gc := config.GlobalRateLimit
+	if gc == nil {
+		return nil
+	}
+	if gc.RequestsPerSecond <= 0 {
+		return fmt.Errorf("requests_per_second must be positive")
+	}
+	if gc.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+	gl := &globalLimiter{rate: gc.RequestsPerSecond, burst: gc.Burst}
+	if gl.burst == 0 {
+		gl.burst = gl.rate
+	}
+	gl.bucket = unitBucket{units: gl.burst, updated: time.Now()}
+	log.Printf("Capping the instance at %g calls per second (burst %g)", gl.rate, gl.burst)
+	globalLimit = gl
+	return nil
+}
+
+// take spends calls from the bucket.
+//
+// Parameters:
+//   - calls: The number of calls of the request
+//   - now: The current time
+//
+// Returns:
+//   - time.Duration: Zero if the calls were taken, or how long until they fit
+//   - bool: Whether the calls were taken
+func (gl *globalLimiter) take(calls float64, now time.Time) (time.Duration, bool) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	b := &gl.bucket
+	b.units = math.Min(gl.burst, b.units+now.Sub(b.updated).Seconds()*gl.rate)
+	b.updated = now
+	if calls > gl.burst {
+		return time.Duration(math.MaxInt64), false
+	}
+	if b.units < calls {
+		return time.Duration((calls - b.units) / gl.rate * float64(time.Second)), false
+	}
+	b.units -= calls
+	return 0, true
+}
+
+// checkGlobalRateLimit takes a request's calls from the global ceiling, answering it
+// with HTTP 429 if they do not fit.
+//
+// Parameters:
+//   - w: The HTTP response writer
+//   - r: The incoming HTTP request
+//   - body: The request body
+//
+// Returns:
+//   - bool: Whether the request may proceed
+func checkGlobalRateLimit(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	gl := globalLimit
+	if gl == nil {
+		return true
+	}
+	calls := parseCalls(body)
+	count := float64(max(len(calls), 1))
+	wait, ok := gl.take(count, time.Now())
+	if ok {
+		return true
+	}
+	globalRateLimitedTotal.add(count)
+
+	message := fmt.Sprintf("global rate limit of %g calls per second exceeded", gl.rate)
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if count > gl.burst {
+		message = fmt.Sprintf("request has %g calls, more than the global limit of %g", count, gl.burst)
+		retryAfter = 0
+	}
+	logWarn("router", "Rejecting request from %s: %s", clientIP(r), message)
+	writeRateLimited(w, body, calls, message, retryAfter)
+	return false
+}
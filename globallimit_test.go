@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGlobalRateLimitTake tests spending and refilling the instance's bucket
+func TestGlobalRateLimitTake(t *testing.T) {
+	// Setup
+	config = Config{GlobalRateLimit: &GlobalRateLimitConfig{RequestsPerSecond: 10, Burst: 20}}
+	if err := setupGlobalRateLimit(); err != nil {
+		t.Fatalf("Failed to set up the global rate limit: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		globalLimit = nil
+	}()
+	gl := globalLimit
+	now := gl.bucket.updated
+
+	// Test and verify
+	if _, ok := gl.take(15, now); !ok {
+		t.Errorf("Expected a full bucket to take 15 calls")
+	}
+	if wait, ok := gl.take(10, now); ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for 10 more calls, got %v %v", wait, ok)
+	}
+	if _, ok := gl.take(10, now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("Expected the bucket to refill")
+	}
+	if _, ok := gl.take(21, now.Add(time.Hour)); ok {
+		t.Errorf("Expected a request with more calls than burst to be rejected")
+	}
+}
+
+// TestGlobalRateLimitConfig tests validating the global rate limit
+func TestGlobalRateLimitConfig(t *testing.T) {
+	defer func() {
+		config = Config{}
+		globalLimit = nil
+	}()
+	for _, gc := range []*GlobalRateLimitConfig{{}, {RequestsPerSecond: -1}, {RequestsPerSecond: 5, Burst: -1}} {
+		config = Config{GlobalRateLimit: gc}
+		if err := setupGlobalRateLimit(); err == nil {
+			t.Errorf("Expected %+v to be rejected", *gc)
+		}
+	}
+	config = Config{GlobalRateLimit: &GlobalRateLimitConfig{RequestsPerSecond: 5}}
+	if err := setupGlobalRateLimit(); err != nil || globalLimit.burst != 5 {
+		t.Errorf("Expected burst to default to requests_per_second, got %v", err)
+	}
+}
+
+// TestGlobalRateLimitProxy tests rejecting requests over the instance's ceiling, whoever sends them
+func TestGlobalRateLimitProxy(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, GlobalRateLimit: &GlobalRateLimitConfig{RequestsPerSecond: 0.5, Burst: 3}}
+	buildMethodURLMap()
+	if err := setupGlobalRateLimit(); err != nil {
+		t.Fatalf("Failed to set up the global rate limit: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		globalLimit = nil
+	}()
+	request := func(apiKey, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		handleProxy(w, r)
+		return w
+	}
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`
+	before := globalRateLimitedTotal.value()
+
+	// Test
+	first := request("alice", batch)
+	second := request("bob", batch)
+
+	// Verify
+	if first.Code != http.StatusOK {
+		t.Errorf("Expected the first batch to be served, got %d", first.Code)
+	}
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2 for another client, got %d %q", second.Code, second.Header().Get("Retry-After"))
+	}
+	if strings.Count(second.Body.String(), `"code":-32005`) != 2 {
+		t.Errorf("Expected an error for each call, got %s", second.Body.String())
+	}
+	if rejected := globalRateLimitedTotal.value() - before; rejected != 2 {
+		t.Errorf("Expected 2 rejected calls to be counted, got %g", rejected)
+	}
+}
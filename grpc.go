@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// gRPC front-end
+//
+// Internal services that prefer gRPC can send JSON-RPC payloads over the
+// jsonrpcproxy.v1.JSONRPC service (see proto/jsonrpc.proto): a unary Call, and a
+// bidirectional Stream carrying many payloads over one connection. Payloads are the same
+// JSON bodies accepted over HTTP, single calls or batches, and they go through the same
+// pipeline, so routing, limits, and every other feature apply. gRPC metadata becomes
+// HTTP headers. HTTP-level rejections map to gRPC status codes, e.g. 429 to
+// RESOURCE_EXHAUSTED.
+
+// GRPCConfig configures the gRPC front-end.
+type GRPCConfig struct {
+	Listen            string `yaml:"listen"`             // Listen address, e.g. ":9545"
+	StreamConcurrency int    `yaml:"stream_concurrency"` // Payloads served at once per stream (default: 16)
+}
+
+// grpcServiceName is the full name of the gRPC service.
+const grpcServiceName = "jsonrpcproxy.v1.JSONRPC"
+
+// grpcFrontend serves the gRPC service through an HTTP handler.
+type grpcFrontend struct {
+	handler     http.HandlerFunc // The proxy handler serving each payload
+	concurrency int              // Payloads served at once per stream
+}
+
+// grpcServiceDesc describes the service for the gRPC server.
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Call",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(wrapperspb.BytesValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			return srv.(*grpcFrontend).call(ctx, in.GetValue())
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(*grpcFrontend).stream(stream)
+		},
+	}},
+	Metadata: "proto/jsonrpc.proto",
+}
+
+// newGRPCServer creates a gRPC server for the service.
+//
+// Parameters:
+//   - handler: The proxy handler serving each payload
+//
+// Returns:
+//   - *grpc.Server: The server, ready to serve on a listener
+func newGRPCServer(handler http.HandlerFunc) *grpc.Server {
+	frontend := &grpcFrontend{handler: handler, concurrency: 16}
+	if config.GRPC != nil && config.GRPC.StreamConcurrency > 0 {
+		frontend.concurrency = config.GRPC.StreamConcurrency
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpcServiceDesc, frontend)
+	return server
+}
+
+// startGRPC starts the gRPC front-end if configured.
+//
+// Parameters:
+//   - handler: The proxy handler serving each payload
+//
+// Returns:
+//   - error: An error if the listen address cannot be bound
+func startGRPC(handler http.HandlerFunc) error {
+	if config.GRPC == nil || config.GRPC.Listen == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", config.GRPC.Listen)
+	if err != nil {
+		return fmt.Errorf("grpc: %w", err)
+	}
+	server := newGRPCServer(handler)
+	go func() {
+		log.Printf("Starting gRPC front-end on %s", config.GRPC.Listen)
+		if err := server.Serve(lis); err != nil {
+			log.Fatalf("gRPC front-end failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// call serves one payload through the proxy handler.
+//
+// Parameters:
+//   - ctx: The gRPC call's context, carrying its metadata and peer
+//   - payload: The JSON-RPC request body
+//
+// Returns:
+//   - *wrapperspb.BytesValue: The JSON-RPC response body
+//   - error: A gRPC status error if the proxy rejected the payload
+func (f *grpcFrontend) call(ctx context.Context, payload []byte) (*wrapperspb.BytesValue, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			// Skip pseudo-headers and gRPC's own headers
+			if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || key == "content-type" {
+				continue
+			}
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+	req.RemoteAddr = "0.0.0.0:0"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	resp := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	f.handler(resp, req)
+	if ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if resp.status != http.StatusOK {
+		return nil, status.Error(grpcCode(resp.status), strings.TrimSpace(string(resp.body)))
+	}
+	return wrapperspb.Bytes(resp.body), nil
+}
+
+// stream serves the payloads of a bidirectional stream concurrently.
+func (f *grpcFrontend) stream(stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	var (
+		sendMu  sync.Mutex
+		wg      sync.WaitGroup
+		sendErr error
+	)
+	slots := make(chan struct{}, f.concurrency)
+	defer wg.Wait()
+
+	for {
+		in := new(wrapperspb.BytesValue)
+		if err := stream.RecvMsg(in); err != nil {
+			// io.EOF ends the stream normally once the pending payloads are answered
+			wg.Wait()
+			if errors.Is(err, io.EOF) {
+				return sendErr
+			}
+			return err
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		wg.Add(1)
+		go func(payload []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			out, err := f.call(ctx, payload)
+			if err != nil {
+				// Report a rejected payload in-band so the stream stays usable
+				st, _ := status.FromError(err)
+				out = wrapperspb.Bytes(grpcErrorPayload(payload, st))
+			}
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			if err := stream.SendMsg(out); err != nil && sendErr == nil {
+				sendErr = err
+			}
+		}(in.GetValue())
+	}
+}
+
+// grpcErrorPayload builds the JSON-RPC error response for a payload rejected on a stream.
+func grpcErrorPayload(payload []byte, st *status.Status) []byte {
+	code := -32603
+	if st.Code() == codes.ResourceExhausted {
+		code = -32005
+	} else if st.Code() == codes.InvalidArgument {
+		code = -32700
+	}
+	data, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: responseID(payload), Error: &JSONRPCError{Code: code, Message: st.Message()}})
+	return data
+}
+
+// grpcCode maps an HTTP status from the proxy handler to a gRPC status code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
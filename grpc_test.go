@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// dialGRPC starts the gRPC front-end on an in-memory listener and connects to it.
+func dialGRPC(t *testing.T, handler http.HandlerFunc) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	server := newGRPCServer(handler)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestGRPCCall tests unary calls through the gRPC front-end
+func TestGRPCCall(t *testing.T) {
+	// Setup
+	var tenant string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Tenant")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Headers: &HeaderRules{Passthrough: []string{"X-Tenant"}}}
+	defer func() { config = Config{} }()
+	buildMethodURLMap()
+	conn := dialGRPC(t, handleProxy)
+
+	// Test
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+	out := new(wrapperspb.BytesValue)
+	err := conn.Invoke(ctx, "/"+grpcServiceName+"/Call",
+		wrapperspb.Bytes([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)), out)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if !strings.Contains(string(out.GetValue()), `"0x10"`) {
+		t.Errorf("Expected the upstream result, got %s", out.GetValue())
+	}
+	if tenant != "acme" {
+		t.Errorf("Expected metadata to be passed as headers, got X-Tenant %q", tenant)
+	}
+
+	// Verify HTTP rejections map to gRPC codes
+	err = conn.Invoke(context.Background(), "/"+grpcServiceName+"/Call", wrapperspb.Bytes([]byte(`{not json`)), out)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected INVALID_ARGUMENT, got %v", err)
+	}
+}
+
+// TestGRPCStream tests serving many payloads over one stream
+func TestGRPCStream(t *testing.T) {
+	// Setup: an upstream echoing each call's id as its result
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.ID})
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL}
+	defer func() { config = Config{} }()
+	buildMethodURLMap()
+	conn := dialGRPC(t, handleProxy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/"+grpcServiceName+"/Stream")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	// Test
+	const n = 20
+	for i := 0; i < n; i++ {
+		payload, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": i, "method": "eth_chainId", "params": []interface{}{}})
+		if err := stream.SendMsg(wrapperspb.Bytes(payload)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	stream.CloseSend()
+
+	// Verify every call is answered once
+	seen := make(map[float64]bool)
+	for i := 0; i < n; i++ {
+		out := new(wrapperspb.BytesValue)
+		if err := stream.RecvMsg(out); err != nil {
+			t.Fatalf("Receive failed after %d responses: %v", i, err)
+		}
+		var resp JSONRPCResponse
+		json.Unmarshal(out.GetValue(), &resp)
+		id, _ := resp.ID.(float64)
+		if resp.Result != id || seen[id] {
+			t.Errorf("Unexpected response %s", out.GetValue())
+		}
+		seen[id] = true
+	}
+}
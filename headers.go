@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Upstream response headers
+//
+// When enabled, responses carry the head block height tracked for the upstream that
+// served them (X-Block-Height) and the upstream's name (X-Upstream), so clients and
+// downstream caches can detect reads served by a lagging node. Batch responses served
+// by several upstreams list every upstream and report the lowest tracked height.
+// The height header is omitted when the upstream's head is not being tracked.
+//
+// Upstream response headers such as Server, Via, CF-Ray, or provider rate limit
+// headers tell clients which provider backs the proxy. Only the headers describing the
+// body (Content-Type, Content-Encoding, Content-Length) and Retry-After are passed on
+// by default; others can be listed, or "*" passes them all. The proxy can present an
+// identity of its own, and X-Upstream is kept to internal clients:
+//
+//	response_headers:
+//	  server: rpc.example.com        # Server header of proxied responses (optional)
+//	  powered_by: example-rpc        # X-Powered-By header of proxied responses (optional)
+//	  pass_upstream: [X-RateLimit-Remaining]
+//	  upstream: true
+//	  trusted: [10.0.0.0/8, 192.0.2.7] # clients shown X-Upstream (required with upstream)
+//
+// X-Upstream names the providers behind the proxy, so it is only shown to the trusted
+// clients, and trusted is required when upstream is set.
+
+const (
+	blockHeightHeader = "X-Block-Height"
+	upstreamHeader    = "X-Upstream"
+)
+
+// ResponseHeadersConfig configures the upstream headers added to responses.
+type ResponseHeadersConfig struct {
+	BlockHeight  bool     `yaml:"block_height"`  // Add X-Block-Height from the serving upstream's tracked head
+	Upstream     bool     `yaml:"upstream"`      // Add X-Upstream with the serving upstream's name
+	Trusted      []string `yaml:"trusted"`       // Client IPs or CIDRs shown X-Upstream (required with upstream)
+	Server       string   `yaml:"server"`        // Server header of proxied responses (optional)
+	PoweredBy    string   `yaml:"powered_by"`    // X-Powered-By header of proxied responses (optional)
+	PassUpstream []string `yaml:"pass_upstream"` // Upstream headers passed to clients besides the defaults, or "*" for all
+}
+
+// passedUpstreamHeaders are the upstream response headers passed to clients by default.
+var passedUpstreamHeaders = []string{"Content-Type", "Content-Encoding", "Content-Length", "Retry-After"}
+
+// trustedNets are the clients shown X-Upstream.
+var trustedNets []*net.IPNet
+
+// setupResponseHeaders parses the clients shown X-Upstream.
+//
+// Returns:
+//   - error: An error if trusted is missing or an entry is neither an IP nor a CIDR
+func setupResponseHeaders() error {
+	trustedNets = nil
+	rc := config.ResponseHeaders
+	if rc == nil {
+		return nil
+	}
+	if rc.Upstream && len(rc.Trusted) == 0 {
+		return fmt.Errorf("trusted is required with upstream, since X-Upstream names the upstreams behind the proxy")
+	}
+	nets, err := parseNets("trusted", rc.Trusted)
+	if err != nil {
+		return err
+	}
+	trustedNets = nets
+	return nil
+}
+
+// trustedClient reports whether a client may be shown X-Upstream.
+func trustedClient(r *http.Request) bool {
+	return inNets(net.ParseIP(clientIP(r)), trustedNets)
+}
+
+// copyUpstreamHeaders copies the upstream response headers passed to clients.
+//
+// Parameters:
+//   - dst: The response headers to modify
+//   - src: The upstream's response headers
+func copyUpstreamHeaders(dst, src http.Header) {
+	var extra []string
+	if rc := config.ResponseHeaders; rc != nil {
+		extra = rc.PassUpstream
+	}
+	for name, values := range src {
+		passed := false
+		for _, list := range [][]string{passedUpstreamHeaders, extra} {
+			for _, allowed := range list {
+				passed = passed || allowed == "*" || strings.EqualFold(allowed, name)
+			}
+		}
+		if passed {
+			for _, value := range values {
+				dst.Add(name, value)
+			}
+		}
+	}
+}
+
+// withBranding sets the configured identity headers on proxied responses.
+func withBranding(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rc := config.ResponseHeaders; rc != nil {
+			if rc.Server != "" {
+				w.Header().Set("Server", rc.Server)
+			}
+			if rc.PoweredBy != "" {
+				w.Header().Set("X-Powered-By", rc.PoweredBy)
+			}
+		}
+		next(w, r)
+	}
+}
+
+// setUpstreamHeaders adds the configured upstream headers for the upstreams that
+// served a response.
+//
+// Parameters:
+//   - h: The response headers to modify
+//   - r: The client request
+//   - served: The upstreams that served the response
+func setUpstreamHeaders(h http.Header, r *http.Request, served []Upstream) {
+	rc := config.ResponseHeaders
+	if rc == nil || len(served) == 0 {
+		return
+	}
+
+	if rc.Upstream && trustedClient(r) {
+		labels := make([]string, 0, len(served))
+		for _, u := range served {
+			labels = append(labels, upstreamLabel(u))
+		}
+		h.Set(upstreamHeader, strings.Join(labels, ", "))
+	}
+
+	if rc.BlockHeight {
+		var lowest uint64
+		for _, u := range served {
+			height, ok := trackedHead(u.URL)
+			if !ok {
+				// A batch is only as fresh as its least-known upstream
+				h.Del(blockHeightHeader)
+				return
+			}
+			if lowest == 0 || height < lowest {
+				lowest = height
+			}
+		}
+		h.Set(blockHeightHeader, strconv.FormatUint(lowest, 10))
+	}
+}
+
+// upstreamLabel returns the name under which an upstream may be shown to clients.
+// Upstreams without a name of their own are shown by scheme and host only, since
+// their URLs may contain API keys.
+func upstreamLabel(u Upstream) string {
+	if u.Name != "" && u.Name != u.URL {
+		return u.Name
+	}
+	return redactURL(u.URL)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestUpstreamHeaders tests the block height and upstream response headers
+func TestUpstreamHeaders(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_call", `{"jsonrpc":"2.0","id":1,"result":"0x"}`)
+	defer server.Close()
+
+	config = Config{
+		DefaultURL:      server.URL,
+		DefaultName:     "mainnet",
+		ResponseHeaders: &ResponseHeadersConfig{BlockHeight: true, Upstream: true, Trusted: []string{"192.0.2.0/24"}},
+	}
+	buildMethodURLMap()
+	if err := setupResponseHeaders(); err != nil {
+		t.Fatalf("Failed to set up response headers: %v", err)
+	}
+	statusMu.Lock()
+	upstreamStatuses = map[string]*upstreamStatus{server.URL: {Name: "mainnet", URL: server.URL, Height: 1234, Healthy: true}}
+	statusMu.Unlock()
+	defer func() {
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"eth_call","params":[],"id":1}`)))
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, req)
+
+	// Verify
+	if h := w.Header().Get(blockHeightHeader); h != "1234" {
+		t.Errorf("Expected %s 1234, got %q", blockHeightHeader, h)
+	}
+	if h := w.Header().Get(upstreamHeader); h != "mainnet" {
+		t.Errorf("Expected %s mainnet, got %q", upstreamHeader, h)
+	}
+}
+
+// TestUpstreamHeadersBatch tests headers for a batch served by several upstreams
+func TestUpstreamHeadersBatch(t *testing.T) {
+	// Setup
+	config = Config{ResponseHeaders: &ResponseHeadersConfig{BlockHeight: true, Upstream: true, Trusted: []string{"192.0.2.0/24"}}}
+	if err := setupResponseHeaders(); err != nil {
+		t.Fatalf("Failed to set up response headers: %v", err)
+	}
+	statusMu.Lock()
+	upstreamStatuses = map[string]*upstreamStatus{
+		"https://a.example.com/key": {Height: 200},
+		"https://b.example.com/key": {Height: 150},
+	}
+	statusMu.Unlock()
+	defer func() {
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+
+	testCases := []struct {
+		name             string
+		served           []Upstream
+		expectedHeight   string
+		expectedUpstream string
+	}{
+		{"Lowest height", []Upstream{{Name: "a", URL: "https://a.example.com/key"}, {Name: "b", URL: "https://b.example.com/key"}}, "150", "a, b"},
+		{"Untracked upstream", []Upstream{{Name: "a", URL: "https://a.example.com/key"}, {URL: "https://c.example.com/key"}}, "", "a, https://c.example.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			// Test
+			setUpstreamHeaders(w.Header(), httptest.NewRequest("POST", "/", nil), tc.served)
+
+			// Verify
+			if h := w.Header().Get(blockHeightHeader); h != tc.expectedHeight {
+				t.Errorf("Expected height %q, got %q", tc.expectedHeight, h)
+			}
+			if h := w.Header().Get(upstreamHeader); h != tc.expectedUpstream {
+				t.Errorf("Expected upstream %q, got %q", tc.expectedUpstream, h)
+			}
+		})
+	}
+}
+
+// TestUpstreamHeaderAnonymization tests that upstream-identifying headers are stripped,
+// the proxy's identity is set, and X-Upstream is only shown to trusted clients
+func TestUpstreamHeaderAnonymization(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "provider-edge")
+		w.Header().Set("CF-Ray", "8a1b2c3d4e5f-AMS")
+		w.Header().Set("X-RateLimit-Remaining", "99")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x"}`))
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL:  server.URL,
+		DefaultName: "mainnet",
+		ResponseHeaders: &ResponseHeadersConfig{
+			Upstream:     true,
+			Trusted:      []string{"10.0.0.0/8", "192.0.2.7"},
+			Server:       "rpc.example.com",
+			PoweredBy:    "example-rpc",
+			PassUpstream: []string{"x-ratelimit-remaining"},
+		},
+	}
+	defer func() { config = Config{} }()
+	buildMethodURLMap()
+	if err := setupResponseHeaders(); err != nil {
+		t.Fatalf("Failed to set up response headers: %v", err)
+	}
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"eth_call","params":[],"id":1}`)))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		withBranding(handleProxy)(w, r)
+		return w
+	}
+
+	// Test
+	internal := request("10.1.2.3:5000")
+	single := request("192.0.2.7:5000")
+	external := request("203.0.113.5:5000")
+
+	// Verify
+	for name, w := range map[string]*httptest.ResponseRecorder{"internal": internal, "single trusted": single, "external": external} {
+		if got := w.Header().Get("Server"); got != "rpc.example.com" {
+			t.Errorf("Expected Server rpc.example.com for the %s client, got %q", name, got)
+		}
+		if got := w.Header().Get("X-Powered-By"); got != "example-rpc" {
+			t.Errorf("Expected X-Powered-By example-rpc for the %s client, got %q", name, got)
+		}
+		if got := w.Header().Get("CF-Ray"); got != "" {
+			t.Errorf("Expected CF-Ray to be stripped for the %s client, got %q", name, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != "99" {
+			t.Errorf("Expected the listed X-RateLimit-Remaining to be passed for the %s client, got %q", name, got)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Expected Content-Type to be passed for the %s client, got %q", name, got)
+		}
+	}
+	if got := internal.Header().Get(upstreamHeader); got != "mainnet" {
+		t.Errorf("Expected %s mainnet for a client in a trusted CIDR, got %q", upstreamHeader, got)
+	}
+	if got := single.Header().Get(upstreamHeader); got != "mainnet" {
+		t.Errorf("Expected %s mainnet for a trusted IP, got %q", upstreamHeader, got)
+	}
+	if got := external.Header().Get(upstreamHeader); got != "" {
+		t.Errorf("Expected no %s for an untrusted client, got %q", upstreamHeader, got)
+	}
+}
+
+// TestResponseHeadersInvalidTrusted tests that trusted entries must be IPs or CIDRs,
+// and are required with X-Upstream
+func TestResponseHeadersInvalidTrusted(t *testing.T) {
+	// Setup
+	config = Config{ResponseHeaders: &ResponseHeadersConfig{Trusted: []string{"10.0.0.0/8", "intranet"}}}
+	defer func() { config = Config{} }()
+
+	// Test
+	err := setupResponseHeaders()
+	config = Config{ResponseHeaders: &ResponseHeadersConfig{Upstream: true}}
+	missingErr := setupResponseHeaders()
+
+	// Verify
+	if err == nil {
+		t.Error("Expected an error for a trusted entry that is neither an IP nor a CIDR")
+	}
+	if missingErr == nil {
+		t.Error("Expected an error for upstream without trusted")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Dependency health
+//
+// Besides its upstreams, the proxy can depend on auxiliary services: memcached servers
+// holding the shared cache, Redis keeping the nonces of signed requests, the file of
+// the persistent cache, and the Kubernetes API for discovered pools. None of them stops
+// the proxy from serving, but each degrades it while it fails. GET /health/details
+// reports them next to the upstream probes:
+//
+//	{"status":"degraded",
+//	 "upstreams":[{"name":"primary","url":"https://rpc.example.com","probed":true,"healthy":true,...}],
+//	 "dependencies":[{"name":"memcached memcached-0:11211","kind":"cache","healthy":false,
+//	   "last_error":"dial tcp 10.0.0.7:11211: connect: connection refused",
+//	   "since":"2026-10-17T09:12:03Z","impact":"cache lookups miss and results are not shared"}]}
+//
+// status is "degraded" while a dependency or a probed upstream is unhealthy, and "ok"
+// otherwise; the endpoint answers 200 either way, since the proxy is still serving.
+// Dependencies are healthy until an operation on them fails, and recover with the next
+// one that succeeds. /health itself stays a plain liveness check.
+
+// dependencyStatus is the state of an auxiliary dependency.
+type dependencyStatus struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"` // cache, nonce_store, cache_file, discovery, or quota_state
+	Healthy   bool      `json:"healthy"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"`            // When the dependency entered its current state
+	Impact    string    `json:"impact,omitempty"` // How the proxy is degraded while it is unhealthy
+}
+
+var (
+	dependencyMu sync.Mutex
+	dependencies = make(map[string]*dependencyStatus) // By name
+)
+
+// registerDependency adds a dependency to the health report, as healthy.
+//
+// Parameters:
+//   - name: The dependency, such as "redis redis:6379"
+//   - kind: What the proxy uses it for
+//   - impact: How the proxy is degraded while it is unhealthy
+func registerDependency(name, kind, impact string) {
+	dependencyMu.Lock()
+	defer dependencyMu.Unlock()
+	dependencies[name] = &dependencyStatus{Name: name, Kind: kind, Healthy: true, Since: time.Now(), Impact: impact}
+}
+
+// reportDependency records the result of an operation on a registered dependency.
+func reportDependency(name string, err error) {
+	dependencyMu.Lock()
+	defer dependencyMu.Unlock()
+	d, ok := dependencies[name]
+	if !ok {
+		return
+	}
+	if healthy := err == nil; healthy != d.Healthy {
+		d.Healthy = healthy
+		d.Since = time.Now()
+	}
+	if err != nil {
+		d.LastError = err.Error()
+	} else {
+		d.LastError = ""
+	}
+}
+
+// dependencySnapshot returns the state of every dependency, sorted by name.
+func dependencySnapshot() []dependencyStatus {
+	dependencyMu.Lock()
+	defer dependencyMu.Unlock()
+	out := make([]dependencyStatus, 0, len(dependencies))
+	for _, name := range sortedKeys(dependencies) {
+		d := *dependencies[name]
+		if d.Healthy {
+			d.Impact = ""
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// handleHealthDetails reports the health of the upstreams and dependencies.
+func handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	upstreams, _ := handleProxyUpstreams(r.Context(), r, nil)
+	deps := dependencySnapshot()
+	status := "ok"
+	for _, u := range upstreams.([]upstreamInfo) {
+		if u.Probed && !u.Healthy {
+			status = "degraded"
+		}
+	}
+	for _, d := range deps {
+		if !d.Healthy {
+			status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       status,
+		"upstreams":    upstreams,
+		"dependencies": deps,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHealthDetails tests reporting a failing dependency as degraded until it recovers
+func TestHealthDetails(t *testing.T) {
+	// Setup
+	config = Config{DefaultURL: "http://127.0.0.1:1"}
+	defer func() {
+		config = Config{}
+		dependencies = make(map[string]*dependencyStatus)
+	}()
+	dependencies = make(map[string]*dependencyStatus)
+	registerDependency("redis redis:6379", "nonce_store", "signed requests are rejected")
+	type report struct {
+		Status       string             `json:"status"`
+		Dependencies []dependencyStatus `json:"dependencies"`
+	}
+	details := func() report {
+		rec := httptest.NewRecorder()
+		handleHealthDetails(rec, httptest.NewRequest("GET", "/health/details", nil))
+		var out report
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("Failed to decode %s: %v", rec.Body.String(), err)
+		}
+		return out
+	}
+
+	// Test
+	healthy := details()
+	reportDependency("redis redis:6379", errors.New("connection refused"))
+	failing := details()
+	reportDependency("redis redis:6379", nil)
+	recovered := details()
+
+	// Verify
+	if healthy.Status != "ok" || len(healthy.Dependencies) != 1 || !healthy.Dependencies[0].Healthy {
+		t.Errorf("Expected a healthy report, got %+v", healthy)
+	}
+	if failing.Status != "degraded" || failing.Dependencies[0].Healthy || failing.Dependencies[0].LastError != "connection refused" || failing.Dependencies[0].Impact == "" {
+		t.Errorf("Expected a degraded report with the error and impact, got %+v", failing)
+	}
+	if recovered.Status != "ok" || recovered.Dependencies[0].LastError != "" || !recovered.Dependencies[0].Since.After(healthy.Dependencies[0].Since) {
+		t.Errorf("Expected the dependency to recover, got %+v", recovered)
+	}
+}
+
+// TestMemcachedDependencyHealth tests that an unreachable memcached server is reported unhealthy
+func TestMemcachedDependencyHealth(t *testing.T) {
+	// Setup
+	defer func() { dependencies = make(map[string]*dependencyStatus) }()
+	dependencies = make(map[string]*dependencyStatus)
+	client, err := newMemcachedClient(&MemcachedConfig{Servers: []string{"127.0.0.1:1"}})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Test
+	client.get("key")
+
+	// Verify
+	deps := dependencySnapshot()
+	if len(deps) != 1 || deps[0].Name != "memcached 127.0.0.1:1" || deps[0].Healthy {
+		t.Errorf("Expected an unhealthy memcached server, got %+v", deps)
+	}
+}
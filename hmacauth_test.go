@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHMACAuth tests accepting signed requests and rejecting forged and replayed ones
+func TestHMACAuth(t *testing.T) {
+	// Setup
+	secret := "0123456789abcdef0123456789abcdef"
+	config = Config{Auth: &AuthConfig{Mode: "hmac", Keys: map[string]string{"partner-a": secret}, Replay: &ReplayConfig{Window: time.Minute}}}
+	if err := setupAuth(); err != nil {
+		t.Fatalf("Failed to set up auth: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		setupAuth()
+	}()
+	handler := withAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	})
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x02"]}`
+	request := func(keyID string, timestamp time.Time, nonce, signedBody, sentBody string) *httptest.ResponseRecorder {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		r := httptest.NewRequest("POST", "/", strings.NewReader(sentBody))
+		r.Header.Set("X-Key-Id", keyID)
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Nonce", nonce)
+		r.Header.Set("X-Signature", hex.EncodeToString(requestSignature([]byte(secret), ts, nonce, []byte(signedBody))))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	now := time.Now()
+
+	// Test
+	allowed := request("partner-a", now, "n1", body, body)
+	replayed := request("partner-a", now, "n1", body, body)
+	other := request("partner-a", now, "n2", body, body)
+	tampered := request("partner-a", now, "n3", body, strings.Replace(body, "0x02", "0x03", 1))
+	stale := request("partner-a", now.Add(-2*time.Minute), "n4", body, body)
+	unknown := request("partner-b", now, "n5", body, body)
+
+	// Verify
+	if allowed.Code != http.StatusOK || other.Code != http.StatusOK {
+		t.Errorf("Expected signed requests to be served, got %d and %d", allowed.Code, other.Code)
+	}
+	if replayed.Code != http.StatusUnauthorized || !strings.Contains(replayed.Body.String(), "Replayed") {
+		t.Errorf("Expected a replay to be rejected, got %d %s", replayed.Code, replayed.Body.String())
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{"tampered": tampered, "stale": stale, "unknown key": unknown} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for a %s request, got %d", name, w.Code)
+		}
+	}
+}
+
+// TestHMACAuthConfigErrors tests that invalid hmac configurations are rejected
+func TestHMACAuthConfigErrors(t *testing.T) {
+	defer func() { config = Config{} }()
+	secret := "0123456789abcdef"
+	testCases := []struct {
+		name     string
+		auth     *AuthConfig
+		expected string
+	}{
+		{"No keys", &AuthConfig{Mode: "hmac"}, "keys is required"},
+		{"Short secret", &AuthConfig{Mode: "hmac", Keys: map[string]string{"a": "short"}}, "keys.a: secrets must be at least 16 characters"},
+		{"Negative window", &AuthConfig{Mode: "hmac", Keys: map[string]string{"a": secret}, Replay: &ReplayConfig{Window: -time.Second}}, "replay.window"},
+		{"Unknown store", &AuthConfig{Mode: "hmac", Keys: map[string]string{"a": secret}, Replay: &ReplayConfig{Store: "memcached://cache:11211"}}, "unsupported store"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{Auth: tc.auth}
+
+			// Test
+			err := setupAuth()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
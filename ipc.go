@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPC upstreams
+//
+// A node running on the same host can be reached over its IPC socket with an upstream
+// URL such as unix:///var/lib/geth/geth.ipc. The socket carries a stream of JSON values:
+// the proxy writes a request (a single call or a batch) and reads exactly one JSON value
+// back as the response. Connections are kept open and reused one request at a time, so
+// collocated nodes are reached without looping through HTTP. IPC upstreams ignore the
+// `transport` option.
+
+// ipcScheme is the URL prefix of IPC upstreams.
+const ipcScheme = "unix://"
+
+// maxIdleIPCConns bounds the idle connections kept per socket.
+const maxIdleIPCConns = 8
+
+// isIPCURL reports whether an upstream URL is an IPC socket.
+func isIPCURL(u string) bool {
+	return strings.HasPrefix(u, ipcScheme)
+}
+
+// ipcConn is a connection to an IPC socket with its response decoder.
+type ipcConn struct {
+	net.Conn
+	decoder *json.Decoder
+}
+
+// ipcTransport sends requests over IPC sockets, reusing idle connections.
+type ipcTransport struct {
+	mu   sync.Mutex
+	idle map[string][]*ipcConn // Idle connections by socket path
+}
+
+// ipcRoundTripper serves every IPC upstream.
+var ipcRoundTripper = &ipcTransport{idle: make(map[string][]*ipcConn)}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ipcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if path == "" {
+		return nil, fmt.Errorf("IPC upstream %s has no socket path", req.URL)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := t.get(req.Context(), path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := conn.exchange(req.Context(), body)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("IPC %s: %w", path, err)
+	}
+	t.put(path, conn)
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(raw)),
+		ContentLength: int64(len(raw)),
+		Request:       req,
+	}, nil
+}
+
+// exchange writes a request and reads one JSON value back.
+func (c *ipcConn) exchange(ctx context.Context, body []byte) (json.RawMessage, error) {
+	// Abort blocked reads and writes when the request is cancelled
+	stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	} else {
+		c.SetDeadline(time.Time{})
+	}
+
+	if _, err := c.Write(append(bytes.TrimSpace(body), '\n')); err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err := c.decoder.Decode(&raw); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return raw, nil
+}
+
+// get returns an idle connection to the socket or dials a new one.
+func (t *ipcTransport) get(ctx context.Context, path string) (*ipcConn, error) {
+	t.mu.Lock()
+	if conns := t.idle[path]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		t.idle[path] = conns[:len(conns)-1]
+		t.mu.Unlock()
+		return conn, nil
+	}
+	t.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &ipcConn{Conn: conn, decoder: json.NewDecoder(conn)}, nil
+}
+
+// put returns a connection to the idle pool, closing it if the pool is full.
+func (t *ipcTransport) put(path string, conn *ipcConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.idle[path]) >= maxIdleIPCConns {
+		conn.Close()
+		return
+	}
+	t.idle[path] = append(t.idle[path], conn)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestIPCUpstream tests proxying calls to an upstream over a unix socket
+func TestIPCUpstream(t *testing.T) {
+	// Setup: a node answering a stream of JSON values on its IPC socket
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatalf("Failed to create socket directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "geth.ipc")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+				for {
+					var raw json.RawMessage
+					if err := decoder.Decode(&raw); err != nil {
+						return
+					}
+					if raw[0] == '[' {
+						var calls []JSONRPCRequest
+						json.Unmarshal(raw, &calls)
+						responses := make([]JSONRPCResponse, len(calls))
+						for i, call := range calls {
+							responses[i] = JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Result: call.Method}
+						}
+						encoder.Encode(responses)
+						continue
+					}
+					var call JSONRPCRequest
+					json.Unmarshal(raw, &call)
+					encoder.Encode(JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Result: call.Method})
+				}
+			}(conn)
+		}
+	}()
+
+	config = Config{DefaultURL: "unix://" + path}
+	defer func() { config = Config{} }()
+	buildMethodURLMap()
+
+	// Test: several single calls and a batch
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)))
+
+		// Verify
+		if w.Code != 200 || !strings.Contains(w.Body.String(), `"result":"eth_chainId"`) {
+			t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`)))
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil || len(responses) != 2 {
+		t.Fatalf("Expected 2 batch responses, got %s", w.Body.String())
+	}
+
+	// Verify the connection is reused
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected 1 connection to be reused, got %d", n)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// JSON structure limits
+//
+// Decoding a pathological body, such as params nested thousands of levels deep or an
+// array of millions of numbers, can pin a CPU before the request is even routed. Every
+// request body is therefore scanned before it is decoded, and rejected with HTTP 400
+// and a JSON-RPC -32600 error if it exceeds a limit:
+//
+//	json_limits:
+//	  max_depth: 64            # nesting of objects and arrays
+//	  max_array_length: 10000  # elements of any array but a batch
+//	  max_tokens: 100000       # values, keys, objects, and arrays in the body
+//
+// The limits always apply, with the defaults above. The scan does not validate the
+// JSON; malformed bodies are rejected by the decoder as before. Batch sizes are not
+// limited here, since batch calls count towards max_tokens.
+//
+// Calls are also rejected, the same way, when a member of the call is repeated or
+// spelled in another case, such as {"method": "admin_addPeer", "Method":
+// "eth_chainId"}. Go's decoder matches member names case-insensitively and keeps the
+// last duplicate, while the call is forwarded as sent and upstreams read the exact
+// name, so such a call would pass scopes and method blocking under one method and run
+// upstream as another.
+
+// JSONLimitsConfig configures the JSON structure limits.
+type JSONLimitsConfig struct {
+	MaxDepth       int `yaml:"max_depth"`        // Deepest nesting of objects and arrays (default: 64)
+	MaxArrayLength int `yaml:"max_array_length"` // Most elements of an array, other than a batch (default: 10000)
+	MaxTokens      int `yaml:"max_tokens"`       // Most values, keys, objects, and arrays in a body (default: 100000)
+}
+
+// Default JSON structure limits.
+const (
+	defaultMaxJSONDepth       = 64
+	defaultMaxJSONArrayLength = 10000
+	defaultMaxJSONTokens      = 100000
+)
+
+// setupJSONLimits validates the JSON structure limits.
+//
+// Returns:
+//   - error: An error if a limit is negative
+func setupJSONLimits() error {
+	jc := config.JSONLimits
+	if jc == nil {
+		return nil
+	}
+	if jc.MaxDepth < 0 || jc.MaxArrayLength < 0 || jc.MaxTokens < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	limits := jsonLimits()
+	log.Printf("Limiting request bodies to depth %d, arrays of %d, and %d tokens", limits.MaxDepth, limits.MaxArrayLength, limits.MaxTokens)
+	return nil
+}
+
+// jsonLimits returns the limits in effect.
+func jsonLimits() JSONLimitsConfig {
+	limits := JSONLimitsConfig{MaxDepth: defaultMaxJSONDepth, MaxArrayLength: defaultMaxJSONArrayLength, MaxTokens: defaultMaxJSONTokens}
+	if jc := config.JSONLimits; jc != nil {
+		if jc.MaxDepth > 0 {
+			limits.MaxDepth = jc.MaxDepth
+		}
+		if jc.MaxArrayLength > 0 {
+			limits.MaxArrayLength = jc.MaxArrayLength
+		}
+		if jc.MaxTokens > 0 {
+			limits.MaxTokens = jc.MaxTokens
+		}
+	}
+	return limits
+}
+
+// checkJSONLimits scans a body for structures beyond the limits, without decoding it.
+//
+// Parameters:
+//   - body: The request body
+//
+// Returns:
+//   - error: An error naming the exceeded limit
+func checkJSONLimits(body []byte) error {
+	limits := jsonLimits()
+	type frame struct {
+		array  bool
+		length int
+	}
+	var stack []frame
+	tokens := 0
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch c {
+		case ' ', '\t', '\n', '\r', ',', ':':
+			continue
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		// Anything else starts a value (or an object key)
+		if tokens++; tokens > limits.MaxTokens {
+			return fmt.Errorf("more than %d JSON tokens", limits.MaxTokens)
+		}
+		if n := len(stack); n > 0 && stack[n-1].array {
+			// The outermost array is a batch, whose size is bounded by the tokens
+			if stack[n-1].length++; n > 1 && stack[n-1].length > limits.MaxArrayLength {
+				return fmt.Errorf("array longer than %d elements", limits.MaxArrayLength)
+			}
+		}
+		switch c {
+		case '{', '[':
+			if len(stack) >= limits.MaxDepth {
+				return fmt.Errorf("nesting deeper than %d levels", limits.MaxDepth)
+			}
+			stack = append(stack, frame{array: c == '['})
+		case '"':
+			for i++; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' {
+					i++
+				}
+			}
+		default:
+			// Skip the rest of a number or literal
+			for i+1 < len(body) && !isJSONDelimiter(body[i+1]) {
+				i++
+			}
+		}
+	}
+	return nil
+}
+
+// callMembers are the members of a JSON-RPC call.
+var callMembers = []string{"jsonrpc", "method", "params", "id"}
+
+// checkCallMembers rejects calls whose members are repeated or spelled in another case.
+//
+// Parameters:
+//   - body: The request body, a call or a batch of calls
+//
+// Returns:
+//   - error: An error naming the ambiguous member, or nil; malformed bodies are left
+//     to the decoder
+func checkCallMembers(body []byte) error {
+	calls := []json.RawMessage{body}
+	if isBatch(body) && json.Unmarshal(body, &calls) != nil {
+		return nil
+	}
+	for _, call := range calls {
+		dec := json.NewDecoder(bytes.NewReader(call))
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			continue
+		}
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				break
+			}
+			key, _ := tok.(string)
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				break
+			}
+			for _, member := range callMembers {
+				switch {
+				case !strings.EqualFold(key, member):
+					continue
+				case key != member:
+					return fmt.Errorf("call member %q must be spelled %q", key, member)
+				case seen[member]:
+					return fmt.Errorf("call member %q appears more than once", member)
+				}
+				seen[member] = true
+			}
+		}
+	}
+	return nil
+}
+
+// isJSONDelimiter reports whether a byte ends a number or literal.
+func isJSONDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', ',', ':', '}', ']', '{', '[', '"':
+		return true
+	}
+	return false
+}
+
+// writeInvalidRequest rejects a request with HTTP 400 and a JSON-RPC -32600 error.
+func writeInvalidRequest(w http.ResponseWriter, err error) {
+	data, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: -32600, Message: "Invalid Request", Data: err.Error()}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(data)
+}
+
+// withJSONLimits wraps the proxy handler to reject bodies beyond the JSON structure
+// limits, or with ambiguous call members, before anything decodes them.
+func withJSONLimits(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		body, ok := peekBody(r)
+		if !ok {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		if err := checkJSONLimits(body); err != nil {
+			logWarn("router", "Rejecting request from %s: %v", clientIP(r), err)
+			writeInvalidRequest(w, err)
+			return
+		}
+		if err := checkCallMembers(body); err != nil {
+			logWarn("router", "Rejecting request from %s: %v", clientIP(r), err)
+			writeInvalidRequest(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
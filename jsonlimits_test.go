@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCheckJSONLimits tests detecting bodies beyond the JSON structure limits
+func TestCheckJSONLimits(t *testing.T) {
+	// Setup
+	config = Config{JSONLimits: &JSONLimitsConfig{MaxDepth: 4, MaxArrayLength: 3, MaxTokens: 40}}
+	defer func() { config = Config{} }()
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"Plain call", `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"to":"0x1","data":"0x"},"latest"]}`, ""},
+		{"Brackets in strings", `{"method":"eth_call","params":["[[[[[[","\"{{{{{{"]}`, ""},
+		{"Batch of many calls", `[{"id":1},{"id":2},{"id":3},{"id":4},{"id":5}]`, ""},
+		{"Deep params", `{"method":"x","params":[[[[1]]]]}`, "nesting deeper than 4 levels"},
+		{"Long array", `{"method":"x","params":[1,2,3,4]}`, "array longer than 3 elements"},
+		{"Too many tokens", `[` + strings.Repeat(`{"id":1},`, 20) + `{"id":1}]`, "more than 40 JSON tokens"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			err := checkJSONLimits([]byte(tc.body))
+
+			// Verify
+			if tc.expected == "" && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestJSONLimitsDefaults tests the default limits against a deeply nested body
+func TestJSONLimitsDefaults(t *testing.T) {
+	// Setup
+	config = Config{}
+	body := `{"method":"x","params":` + strings.Repeat("[", 10000) + strings.Repeat("]", 10000) + `}`
+
+	// Test
+	err := checkJSONLimits([]byte(body))
+
+	// Verify
+	if err == nil || !strings.Contains(err.Error(), "nesting deeper than 64 levels") {
+		t.Errorf("Expected the default depth limit, got %v", err)
+	}
+	if err := setupJSONLimits(); err != nil {
+		t.Errorf("Expected no error without configuration, got %v", err)
+	}
+	config = Config{JSONLimits: &JSONLimitsConfig{MaxTokens: -1}}
+	defer func() { config = Config{} }()
+	if err := setupJSONLimits(); err == nil {
+		t.Errorf("Expected an error for a negative limit")
+	}
+}
+
+// TestWithJSONLimits tests rejecting pathological bodies with -32600
+func TestWithJSONLimits(t *testing.T) {
+	// Setup
+	config = Config{JSONLimits: &JSONLimitsConfig{MaxArrayLength: 2}}
+	defer func() { config = Config{} }()
+	handler := withJSONLimits(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	})
+	request := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w
+	}
+
+	// Test
+	allowed := request(`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x1","latest"]}`)
+	rejected := request(`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x1","latest",1]}`)
+
+	// Verify
+	if allowed.Code != http.StatusOK {
+		t.Errorf("Expected a body within the limits to be served, got %d", allowed.Code)
+	}
+	if rejected.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rejected.Code)
+	}
+	if body := rejected.Body.String(); !strings.Contains(body, `"code":-32600`) || !strings.Contains(body, `"id":null`) {
+		t.Errorf("Expected a -32600 error with a null id, got %s", body)
+	}
+}
+
+// TestCheckCallMembers tests rejecting calls with repeated or case-variant members
+func TestCheckCallMembers(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"Plain call", `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"method":"x","Method":"y"}]}`, ""},
+		{"Other members", `{"jsonrpc":"2.0","id":1,"method":"eth_call","Extra":1,"extra":2}`, ""},
+		{"Case variant", `{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","Method":"eth_chainId"}`, `call member "Method" must be spelled "method"`},
+		{"Duplicate", `{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","method":"eth_chainId"}`, `call member "method" appears more than once`},
+		{"Escaped duplicate", `{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","\u006dethod":"eth_chainId"}`, `call member "method" appears more than once`},
+		{"Duplicate in a batch", `[{"id":1,"method":"eth_chainId"},{"id":2,"id":3,"method":"eth_chainId"}]`, `call member "id" appears more than once`},
+		{"Malformed", `{"method":`, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			err := checkCallMembers([]byte(tc.body))
+
+			// Verify
+			if tc.expected == "" && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
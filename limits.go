@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request queuing and backpressure
+//
+// The proxy can bound how many requests it processes at once, and how many calls are in
+// flight to each upstream. Requests beyond a limit wait in a queue for a free slot
+// instead of failing immediately, which smooths out short bursts without exceeding
+// upstream quotas. When the queue is full, or a request has waited longer than the
+// queue timeout, it is rejected with HTTP 429, a Retry-After header, and a JSON-RPC
+// -32005 error body. In a batch, only the calls to a saturated upstream get the error.
+
+// ConcurrencyConfig configures concurrency limits and queuing.
+type ConcurrencyConfig struct {
+	MaxInFlight         int                        `yaml:"max_in_flight"`          // Requests processed at once by the proxy (0: unlimited)
+	UpstreamMaxInFlight int                        `yaml:"upstream_max_in_flight"` // Calls in flight to each upstream (0: unlimited)
+	Adaptive            *AdaptiveConcurrencyConfig `yaml:"adaptive"`               // Per-upstream limits adjusted to latency and errors, instead of upstream_max_in_flight (optional, see adaptive.go)
+	MaxQueue            int                        `yaml:"max_queue"`              // Requests waiting for each limit before overflow is rejected (default: 100)
+	QueueTimeout        time.Duration              `yaml:"queue_timeout"`          // Longest wait for a slot (default: 5s)
+	RetryAfter          time.Duration              `yaml:"retry_after"`            // Retry-After sent with rejections (default: 1s)
+	Priorities          *PriorityConfig            `yaml:"priorities"`             // Priority classes of methods and clients (optional)
+	PerClient           *ClientConcurrencyConfig   `yaml:"per_client"`             // Requests in flight per client (optional, see clientlimits.go)
+}
+
+// errSaturated is returned when a limit's queue is full or the wait timed out.
+var errSaturated = errors.New("too many requests")
+
+// waiter is a request queued for a slot. It receives nil when handed a slot, or the
+// error that rejected it.
+type waiter struct {
+	ready chan error
+}
+
+// limiter bounds concurrent work and queues callers waiting for a slot. Free slots go
+// to the highest-priority waiter first, and a full queue sheds its lowest-priority
+// waiters to make room for more important requests.
+type limiter struct {
+	mu       sync.Mutex
+	size     int                      // Slots
+	inFlight int                      // Slots in use
+	waiting  [numPriorities][]*waiter // Queued callers by priority, oldest first
+	queued   int                      // Total queued callers
+	maxQueue int                      // Queue depth before overflow is rejected
+	timeout  time.Duration            // Longest wait for a slot
+	adaptive *adaptiveLimit           // Adjusts size from the calls served, or nil (see adaptive.go)
+}
+
+// newLimiter creates a limiter with the configured queue settings.
+func newLimiter(size int, cc *ConcurrencyConfig) *limiter {
+	maxQueue := cc.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = 100
+	}
+	timeout := cc.QueueTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &limiter{size: size, maxQueue: maxQueue, timeout: timeout}
+}
+
+// acquire takes a slot, waiting in the queue if none is free.
+//
+// Parameters:
+//   - ctx: The context of the waiting request
+//   - prio: The priority of the request
+//
+// Returns:
+//   - error: errSaturated if the request was rejected or shed or its wait timed out, or the context's error
+func (l *limiter) acquire(ctx context.Context, prio priority) error {
+	l.mu.Lock()
+	if l.inFlight < l.size {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queued >= l.maxQueue && !l.shed(prio) {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: queue full", errSaturated)
+	}
+	w := &waiter{ready: make(chan error, 1)}
+	l.waiting[prio] = append(l.waiting[prio], w)
+	l.queued++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-w.ready:
+		return err
+	case <-timer.C:
+		err = fmt.Errorf("%w: no capacity within %s", errSaturated, l.timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.remove(prio, w) {
+		return err
+	}
+	// The waiter was handed a slot or shed at the same time
+	if handed := <-w.ready; handed != nil {
+		return handed
+	}
+	l.releaseLocked()
+	return err
+}
+
+// shed rejects the newest waiter of the lowest priority below prio. Callers hold l.mu.
+//
+// Returns:
+//   - bool: True if a waiter was shed
+func (l *limiter) shed(prio priority) bool {
+	for p := priority(0); p < prio; p++ {
+		if n := len(l.waiting[p]); n > 0 {
+			w := l.waiting[p][n-1]
+			l.waiting[p] = l.waiting[p][:n-1]
+			l.queued--
+			w.ready <- fmt.Errorf("%w: shed for higher-priority traffic", errSaturated)
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes a waiter out of the queue. Callers hold l.mu.
+//
+// Returns:
+//   - bool: True if the waiter was still queued
+func (l *limiter) remove(prio priority, w *waiter) bool {
+	for i, queued := range l.waiting[prio] {
+		if queued == w {
+			l.waiting[prio] = append(l.waiting[prio][:i], l.waiting[prio][i+1:]...)
+			l.queued--
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot, handing it to the highest-priority waiter if any.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+// releaseLocked implements release. Callers hold l.mu.
+func (l *limiter) releaseLocked() {
+	if l.inFlight <= l.size {
+		// Hand the slot on, unless the limiter shrank below the slots in use
+		if l.handLocked() {
+			return
+		}
+	}
+	l.inFlight--
+}
+
+// handLocked gives a slot to the highest-priority waiter. Callers hold l.mu.
+//
+// Returns:
+//   - bool: True if a waiter got the slot
+func (l *limiter) handLocked() bool {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(l.waiting[p]) > 0 {
+			w := l.waiting[p][0]
+			l.waiting[p] = l.waiting[p][1:]
+			l.queued--
+			w.ready <- nil
+			return true
+		}
+	}
+	return false
+}
+
+// resize changes the number of slots, handing new slots to waiters. Slots in use
+// beyond a smaller size are given up as they are released.
+func (l *limiter) resize(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size = size
+	for l.inFlight < l.size && l.handLocked() {
+		l.inFlight++
+	}
+}
+
+var (
+	proxyLimiter      *limiter            // Proxy-wide limit (nil if unlimited)
+	upstreamLimitsMu  sync.Mutex          // Protects upstreamLimiters
+	upstreamLimiters  map[string]*limiter // Per-upstream limits, created on first use
+	upstreamLimitSize int                 // Per-upstream limit (0 if unlimited)
+)
+
+// setupConcurrency builds the limiters from the configuration.
+//
+// Returns:
+//   - error: An error if the priority classes are invalid
+func setupConcurrency() error {
+	proxyLimiter = nil
+	upstreamLimitsMu.Lock()
+	upstreamLimiters = make(map[string]*limiter)
+	upstreamLimitSize = 0
+	upstreamLimitsMu.Unlock()
+
+	adaptiveSettings = nil
+	cc := config.Concurrency
+	if cc == nil {
+		setupClientConcurrency(nil)
+		return setupPriorities(nil)
+	}
+	if err := setupPriorities(cc.Priorities); err != nil {
+		return err
+	}
+	if err := setupClientConcurrency(cc.PerClient); err != nil {
+		return err
+	}
+	if cc.MaxInFlight > 0 {
+		proxyLimiter = newLimiter(cc.MaxInFlight, cc)
+	}
+	upstreamLimitSize = cc.UpstreamMaxInFlight
+	return setupAdaptiveConcurrency(cc.Adaptive)
+}
+
+// acquireUpstream takes a slot for a call to an upstream, at the priority carried by ctx.
+//
+// Returns:
+//   - func(): Releases the slot (a no-op when upstreams are unlimited)
+//   - error: errSaturated if the upstream has no capacity, or the context's error
+func acquireUpstream(ctx context.Context, url string) (func(), error) {
+	adaptive := adaptiveSettings
+	if upstreamLimitSize <= 0 && adaptive == nil {
+		return func() {}, nil
+	}
+
+	upstreamLimitsMu.Lock()
+	l, ok := upstreamLimiters[url]
+	if !ok {
+		if adaptive != nil {
+			l = newLimiter(adaptive.InitialLimit, config.Concurrency)
+			l.adaptive = newAdaptiveLimit(adaptive)
+		} else {
+			l = newLimiter(upstreamLimitSize, config.Concurrency)
+		}
+		upstreamLimiters[url] = l
+	}
+	upstreamLimitsMu.Unlock()
+
+	if err := l.acquire(ctx, priorityFrom(ctx)); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	if l.adaptive == nil {
+		return func() { once.Do(l.release) }, nil
+	}
+	l.mu.Lock()
+	inFlight := l.inFlight
+	l.mu.Unlock()
+	l.adaptive.noteInFlight(inFlight)
+	start := time.Now()
+	return func() {
+		once.Do(func() {
+			l.release()
+			l.adaptive.sample(l, time.Since(start))
+		})
+	}, nil
+}
+
+// releasingBody releases an upstream slot when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close implements io.Closer.
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// saturatedError is the JSON-RPC error returned for rejected calls.
+func saturatedError(err error) *JSONRPCError {
+	return &JSONRPCError{Code: -32005, Message: "proxy is saturated, retry later", Data: err.Error()}
+}
+
+// writeSaturated rejects a request with 429, Retry-After, and a JSON-RPC error body.
+//
+// Parameters:
+//   - w: The HTTP response writer
+//   - body: The request body (to echo the call's id)
+//   - err: The saturation error
+func writeSaturated(w http.ResponseWriter, body []byte, err error) {
+	retryAfter := time.Second
+	if config.Concurrency != nil && config.Concurrency.RetryAfter > 0 {
+		retryAfter = config.Concurrency.RetryAfter
+	}
+
+	data, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: responseID(body), Error: saturatedError(err)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(data)
+}
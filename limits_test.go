@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLimiterQueue tests queuing, overflow, and queue timeouts
+func TestLimiterQueue(t *testing.T) {
+	// Setup
+	l := newLimiter(1, &ConcurrencyConfig{MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+
+	// Test: a queued caller gets the slot once it is released
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.acquire(context.Background(), priorityNormal) }()
+	waitQueued(l, 1)
+
+	// Verify overflow is rejected while the queue is full
+	if err := l.acquire(context.Background(), priorityNormal); !errors.Is(err, errSaturated) {
+		t.Errorf("Expected overflow to be rejected, got %v", err)
+	}
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the queued caller to get the slot, got %v", err)
+	}
+
+	// Verify waiting longer than the queue timeout is rejected
+	if err := l.acquire(context.Background(), priorityNormal); !errors.Is(err, errSaturated) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
+
+// TestSaturatedUpstream tests 429 responses when an upstream has no capacity
+func TestSaturatedUpstream(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_blockNumber", `{"jsonrpc":"2.0","id":8,"result":"0x10"}`)
+	defer server.Close()
+	config = Config{
+		DefaultURL:  server.URL,
+		Concurrency: &ConcurrencyConfig{UpstreamMaxInFlight: 1, QueueTimeout: 10 * time.Millisecond, RetryAfter: 2 * time.Second},
+	}
+	buildMethodURLMap()
+	if err := setupConcurrency(); err != nil {
+		t.Fatalf("Invalid concurrency configuration: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		setupConcurrency()
+	}()
+
+	// Test: a held slot leaves no capacity for the request
+	release, err := acquireUpstream(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Failed to hold the upstream slot: %v", err)
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_blockNumber","params":[]}`))
+	w := httptest.NewRecorder()
+	handleProxy(w, r)
+
+	// Verify
+	if w.Code != 429 {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", got)
+	}
+	var resp JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response body: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != -32005 || resp.ID != float64(7) {
+		t.Errorf("Expected a -32005 error for id 7, got %s", w.Body.String())
+	}
+
+	// Verify the request succeeds once the slot is released
+	release()
+	w = httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":8,"method":"eth_blockNumber","params":[]}`)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "0x10") {
+		t.Errorf("Expected the request to succeed, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestSaturatedBatch tests saturation errors for the calls of a batch
+func TestSaturatedBatch(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_blockNumber", "0x10")
+	defer server.Close()
+	config = Config{
+		DefaultURL:  server.URL,
+		Concurrency: &ConcurrencyConfig{UpstreamMaxInFlight: 1, QueueTimeout: 10 * time.Millisecond},
+	}
+	buildMethodURLMap()
+	if err := setupConcurrency(); err != nil {
+		t.Fatalf("Invalid concurrency configuration: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		setupConcurrency()
+	}()
+	release, err := acquireUpstream(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Failed to hold the upstream slot: %v", err)
+	}
+	defer release()
+
+	// Test
+	body := `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}]`
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+
+	// Verify
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Invalid response body: %v (%s)", err, w.Body.String())
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %s", w.Body.String())
+	}
+	for _, resp := range responses {
+		if resp.Error == nil || resp.Error.Code != -32005 {
+			t.Errorf("Expected a -32005 error, got %+v", resp)
+		}
+	}
+}
+
+// waitQueued waits until n callers are queued on a limiter.
+func waitQueued(l *limiter, n int) {
+	for {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
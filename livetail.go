@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Live request tail
+//
+// During an incident, operators want to watch traffic as it flows rather than grep
+// logs after the fact. The admin API streams the proxy's requests as server-sent
+// events, one access record (see accesslog.go) per request:
+//
+//	curl -N -H "Authorization: Bearer $TOKEN" 'http://localhost:8080/admin/tail?sample=0.1&method=eth_call'
+//
+//	event: request
+//	data: {"time":"...","client":"192.0.2.7","status":200,"duration_ms":12.5,"methods":["eth_call"],"upstreams":["primary"],...}
+//
+// sample is the fraction of requests streamed (default: 1), and method keeps the
+// requests calling a method (a name, or a prefix ending in *). Records are produced
+// whether or not the access log is enabled. A subscriber that cannot keep up misses
+// events rather than slowing the proxy down; a "dropped" event reports how many. At
+// most 16 subscribers are served at once.
+
+// maxTailSubscribers is the number of tails streamed at once.
+const maxTailSubscribers = 16
+
+// tailHeartbeat is how often an idle tail sends a comment to keep the connection open.
+const tailHeartbeat = 15 * time.Second
+
+// tailSubscriber is a client of the live tail.
+type tailSubscriber struct {
+	sample  float64
+	method  string // Method name or prefix ending in *, or "" for every method
+	events  chan *accessRecord
+	dropped atomic.Int64
+}
+
+var (
+	tailMu          sync.RWMutex
+	tailSubscribers = make(map[*tailSubscriber]bool)
+	tailActive      atomic.Bool // Whether anyone is subscribed, checked on every request
+)
+
+// setupLiveTail registers the admin endpoint streaming requests.
+func setupLiveTail() {
+	registerAdminHandler("/admin/tail", handleAdminTail)
+}
+
+// publishTail sends an access record to the subscribers whose filters it passes.
+func publishTail(rec *accessRecord) {
+	tailMu.RLock()
+	defer tailMu.RUnlock()
+	for sub := range tailSubscribers {
+		if !sub.wants(rec) {
+			continue
+		}
+		select {
+		case sub.events <- rec:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// wants reports whether a subscriber receives a record.
+func (s *tailSubscriber) wants(rec *accessRecord) bool {
+	if s.method != "" {
+		matched := false
+		for _, method := range rec.Methods {
+			if method == s.method || (strings.HasSuffix(s.method, "*") && strings.HasPrefix(method, strings.TrimSuffix(s.method, "*"))) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return s.sample >= 1 || rand.Float64() < s.sample
+}
+
+// subscribeTail adds a subscriber.
+//
+// Returns:
+//   - bool: False if the maximum number of subscribers is reached
+func subscribeTail(sub *tailSubscriber) bool {
+	tailMu.Lock()
+	defer tailMu.Unlock()
+	if len(tailSubscribers) >= maxTailSubscribers {
+		return false
+	}
+	tailSubscribers[sub] = true
+	tailActive.Store(true)
+	return true
+}
+
+// unsubscribeTail removes a subscriber.
+func unsubscribeTail(sub *tailSubscriber) {
+	tailMu.Lock()
+	defer tailMu.Unlock()
+	delete(tailSubscribers, sub)
+	tailActive.Store(len(tailSubscribers) > 0)
+}
+
+// handleAdminTail streams requests as server-sent events.
+func handleAdminTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sub := &tailSubscriber{sample: 1, method: r.URL.Query().Get("method"), events: make(chan *accessRecord, 256)}
+	if s := r.URL.Query().Get("sample"); s != "" {
+		sample, err := strconv.ParseFloat(s, 64)
+		if err != nil || sample <= 0 || sample > 1 {
+			http.Error(w, "sample must be a fraction above 0 and at most 1", http.StatusBadRequest)
+			return
+		}
+		sub.sample = sample
+	}
+	if !subscribeTail(sub) {
+		http.Error(w, "Too many tail subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribeTail(sub)
+	logInfo("router", "Live tail started by %s", clientIP(r))
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case rec := <-sub.events:
+			if dropped := sub.dropped.Swap(0); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+			}
+			data, _ := json.Marshal(rec)
+			fmt.Fprintf(w, "event: request\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLiveTail tests streaming filtered access records to a subscriber
+func TestLiveTail(t *testing.T) {
+	// Setup
+	tail := httptest.NewServer(http.HandlerFunc(handleAdminTail))
+	defer tail.Close()
+	proxy := withAccessLog(func(w http.ResponseWriter, r *http.Request) {
+		noteUpstream(r.Context(), "primary")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	})
+	resp, err := http.Get(tail.URL + "?method=eth_get*")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	for deadline := time.Now().Add(time.Second); !tailActive.Load() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Test
+	proxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)))
+	proxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":[]}`)))
+
+	// Verify
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	if event != "event: request\n" || !strings.HasPrefix(data, "data: ") {
+		t.Fatalf("Expected a request event, got %q %q", event, data)
+	}
+	var rec accessRecord
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &rec); err != nil {
+		t.Fatalf("Failed to parse the record: %v", err)
+	}
+	if len(rec.Methods) != 1 || rec.Methods[0] != "eth_getBalance" || len(rec.Upstreams) != 1 || rec.Upstreams[0] != "primary" || rec.Status != 200 {
+		t.Errorf("Expected the eth_getBalance request only, got %+v", rec)
+	}
+}
+
+// TestLiveTailLimits tests rejecting invalid samples and subscribers beyond the maximum
+func TestLiveTailLimits(t *testing.T) {
+	// Setup
+	for i := 0; i < maxTailSubscribers; i++ {
+		sub := &tailSubscriber{}
+		subscribeTail(sub)
+		defer unsubscribeTail(sub)
+	}
+
+	// Test
+	bad := httptest.NewRecorder()
+	handleAdminTail(bad, httptest.NewRequest("GET", "/admin/tail?sample=2", nil))
+	full := httptest.NewRecorder()
+	handleAdminTail(full, httptest.NewRequest("GET", "/admin/tail", nil))
+
+	// Verify
+	if bad.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid sample to be rejected, got %d", bad.Code)
+	}
+	if full.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a subscriber beyond the maximum to be rejected, got %d", full.Code)
+	}
+}
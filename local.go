@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Locally handled methods
+//
+// Some JSON-RPC methods are answered by the proxy itself instead of being forwarded.
+// Features register a localHandler for each such method; handleSingleRequest and
+// handleBatchRequest consult the registry before routing.
+
+// JSONRPCError is a JSON-RPC 2.0 error object.
+type JSONRPCError struct {
+	Code    int         `json:"code"`           // Error code (e.g., -32601 for method not found)
+	Message string      `json:"message"`        // Short error description
+	Data    interface{} `json:"data,omitempty"` // Additional error information
+}
+
+// Error implements the error interface.
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// JSONRPCResponse represents the structure of a JSON-RPC 2.0 response.
+type JSONRPCResponse struct {
+	JSONRPC string        `json:"jsonrpc"`          // JSON-RPC version ("2.0")
+	Result  interface{}   `json:"result,omitempty"` // Result on success
+	Error   *JSONRPCError `json:"error,omitempty"`  // Error on failure
+	ID      interface{}   `json:"id"`               // Identifier of the request
+}
+
+// localHandler answers a JSON-RPC call inside the proxy.
+// It returns either a result or an error object.
+type localHandler func(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError)
+
+var (
+	localMethodsMu sync.RWMutex
+	localMethods   = make(map[string]localHandler) // Locally handled methods by name
+)
+
+// registerLocalMethod makes the proxy answer a method itself.
+//
+// Parameters:
+//   - method: The JSON-RPC method name
+//   - handler: The function producing the result
+func registerLocalMethod(method string, handler localHandler) {
+	localMethodsMu.Lock()
+	defer localMethodsMu.Unlock()
+	localMethods[method] = handler
+}
+
+// unregisterLocalMethod stops the proxy from answering a method itself.
+func unregisterLocalMethod(method string) {
+	localMethodsMu.Lock()
+	defer localMethodsMu.Unlock()
+	delete(localMethods, method)
+}
+
+// handleLocalCall answers a call locally if a handler is registered for its method, or
+// its method is blocked (see methodblock.go).
+//
+// Parameters:
+//   - ctx: The context bounding any upstream calls the handler makes
+//   - r: The incoming HTTP request
+//   - req: The parsed JSON-RPC request
+//
+// Returns:
+//   - []byte: The marshaled JSON-RPC response
+//   - bool: Whether the method is handled locally
+func handleLocalCall(ctx context.Context, r *http.Request, req *JSONRPCRequest) ([]byte, bool) {
+	if rpcErr := blockedCallError(req); rpcErr != nil {
+		return localResponse(req, nil, rpcErr), true
+	}
+	if _, forced := forcedUpstream(ctx); forced {
+		return nil, false
+	}
+	localMethodsMu.RLock()
+	handler, ok := localMethods[req.Method]
+	localMethodsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	result, rpcErr := handler(ctx, r, req)
+	return localResponse(req, result, rpcErr), true
+}
+
+// localResponse marshals a locally produced result or error as the response to a call.
+// A nil result is sent as null.
+func localResponse(req *JSONRPCRequest, result interface{}, rpcErr *JSONRPCError) []byte {
+	resp := JSONRPCResponse{JSONRPC: "2.0", ID: req.ID}
+	if rpcErr != nil {
+		resp.Error = rpcErr
+	} else {
+		resp.Result = result
+		if result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error:   &JSONRPCError{Code: -32603, Message: "Internal error"},
+		})
+	}
+	return data
+}
+
+// callUpstream sends a single JSON-RPC call to an upstream on behalf of the proxy
+// and returns its result.
+//
+// Parameters:
+//   - ctx: The context bounding the call
+//   - targetURL: The upstream URL
+//   - method: The JSON-RPC method
+//   - params: The method parameters
+//
+// Returns:
+//   - json.RawMessage: The raw result
+//   - error: A transport error, or a *JSONRPCError returned by the upstream
+func callUpstream(ctx context.Context, targetURL, method string, params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := forwardRequest(ctx, targetURL, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, fmt.Errorf("error decoding %s response (HTTP %d): %w", method, resp.StatusCode, err)
+	}
+	if rpcResp.Error != nil {
+		return nil, rpcResp.Error
+	}
+	return rpcResp.Result, nil
+}
+
+// upstreamError converts an upstream call failure into a JSON-RPC error for the client.
+func upstreamError(err error) *JSONRPCError {
+	if rpcErr, ok := err.(*JSONRPCError); ok {
+		return rpcErr
+	}
+	return &JSONRPCError{Code: -32603, Message: "upstream error: " + err.Error()}
+}
+
+// routeFor returns the upstream URL the active router picks for a method with the given params.
+// It is used when the proxy makes its own calls on behalf of a client.
+func routeFor(method string, params interface{}) (string, error) {
+	upstream, err := router.Route(&JSONRPCRequest{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return "", err
+	}
+	return upstream.URL, nil
+}
+
+// parseQuantity parses a hex-encoded JSON-RPC quantity such as "0x1b4".
+func parseQuantity(s string) (uint64, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return 0, fmt.Errorf("quantity %q is missing 0x prefix", s)
+	}
+	return strconv.ParseUint(s[2:], 16, 64)
+}
+
+// parseQuantityResult parses a raw JSON result holding a hex quantity.
+func parseQuantityResult(raw json.RawMessage) (uint64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("expected quantity string, got %s", string(bytes.TrimSpace(raw)))
+	}
+	return parseQuantity(s)
+}
+
+// toQuantity encodes a number as a hex JSON-RPC quantity.
+func toQuantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
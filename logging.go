@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log files and rotation
+//
+// Logs go to standard error unless logging.file is set. A log file is rotated once it
+// reaches max_size megabytes: it is renamed with the time of the rotation (proxy.log
+// becomes proxy-2024-05-01T10-00-00.000000.log) and a new file is started. Rotated files
+// are optionally gzipped, and removed once there are more than max_backups of them or
+// they are older than max_age, so long-running instances do not fill their disks:
+//
+//	logging:
+//	  file: /var/log/jsonrpc-proxy/proxy.log
+//	  max_size: 100        # megabytes
+//	  max_backups: 7
+//	  max_age: 168h
+//	  compress: true
+
+// LoggingConfig configures where logs are written.
+type LoggingConfig struct {
+	Level      string             `yaml:"level"`       // Level of components without their own: debug, info (default), warn, or error (see loglevels.go)
+	Components map[string]string  `yaml:"components"`  // Levels by component
+	Routes     []RouteLogOverride `yaml:"routes"`      // Verbosity of matching calls (optional, see routelog.go)
+	File       string             `yaml:"file"`        // Log file (default: standard error)
+	MaxSize    int                `yaml:"max_size"`    // Size in megabytes at which the file is rotated (default: 100)
+	MaxBackups int                `yaml:"max_backups"` // Rotated files kept (default: all)
+	MaxAge     time.Duration      `yaml:"max_age"`     // Age after which rotated files are removed (default: never)
+	Compress   bool               `yaml:"compress"`    // Gzip rotated files
+	Syslog     *SyslogConfig      `yaml:"syslog"`      // Send logs to syslog (optional, see logsinks.go)
+	Journald   bool               `yaml:"journald"`    // Send logs to the systemd journal
+}
+
+// defaultLogMaxSize is the size in megabytes at which log files are rotated by default.
+const defaultLogMaxSize = 100
+
+// backupTimeFormat is the time format in the names of rotated log files.
+const backupTimeFormat = "2006-01-02T15-04-05.000000"
+
+// rotatingFile is a log file that rotates itself when it grows too large.
+type rotatingFile struct {
+	name       string        // Path of the current file
+	maxBytes   int64         // Size at which the file is rotated
+	maxBackups int           // Rotated files kept, 0 for all
+	maxAge     time.Duration // Age after which rotated files are removed, 0 for never
+	compress   bool          // Gzip rotated files
+
+	mu        sync.Mutex
+	file      *os.File       // The current file
+	size      int64          // Bytes in the current file
+	cleanupMu sync.Mutex     // Serializes cleanups, which may overlap under heavy logging
+	cleanup   sync.WaitGroup // Cleanups in progress, waited for by tests
+}
+
+// setupLogging applies the log levels and directs logs to the configured file and
+// sinks (see logsinks.go).
+//
+// Returns:
+//   - error: An error if the configuration is invalid, or the file or a sink cannot be opened
+func setupLogging() error {
+	if err := setupLogLevels(); err != nil {
+		return err
+	}
+	lc := config.Logging
+	if lc == nil {
+		return nil
+	}
+	var lines io.Writer
+	if lc.File != "" {
+		if lc.MaxSize < 0 || lc.MaxBackups < 0 || lc.MaxAge < 0 {
+			return fmt.Errorf("max_size, max_backups, and max_age cannot be negative")
+		}
+		w, err := openRotatingFile(lc)
+		if err != nil {
+			return err
+		}
+		log.Printf("Writing logs to %s", lc.File)
+		lines = w
+	}
+	if lc.Syslog != nil || lc.Journald {
+		if err := installLogSinks(lines); err != nil {
+			return err
+		}
+		log.Printf("Sending logs to syslog: %t, journald: %t", lc.Syslog != nil, lc.Journald)
+		return nil
+	}
+	if lines != nil {
+		log.SetOutput(lines)
+	}
+	return nil
+}
+
+// openRotatingFile opens a log file for appending.
+//
+// Parameters:
+//   - lc: The logging configuration
+//
+// Returns:
+//   - *rotatingFile: The log file
+//   - error: An error if the file cannot be opened
+func openRotatingFile(lc *LoggingConfig) (*rotatingFile, error) {
+	maxSize := lc.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultLogMaxSize
+	}
+	w := &rotatingFile{
+		name:       lc.File,
+		maxBytes:   int64(maxSize) << 20,
+		maxBackups: lc.MaxBackups,
+		maxAge:     lc.MaxAge,
+		compress:   lc.Compress,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the current file, creating it and its directory if needed.
+func (w *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if the write would overflow it.
+func (w *rotatingFile) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", w.name, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file with the time and starts a new one. Old files are
+// compressed and removed in the background.
+func (w *rotatingFile) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(w.name)
+	base := strings.TrimSuffix(w.name, ext) + "-" + time.Now().UTC().Format(backupTimeFormat)
+	backup := base + ext
+	for i := 1; fileExists(backup) || fileExists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err := os.Rename(w.name, backup); err != nil {
+		w.open()
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.cleanup.Add(1)
+	go func() {
+		defer w.cleanup.Done()
+		w.removeOldBackups(backup)
+	}()
+	return nil
+}
+
+// removeOldBackups compresses the newest backup if configured, then removes the
+// backups beyond max_backups or older than max_age.
+func (w *rotatingFile) removeOldBackups(newest string) {
+	w.cleanupMu.Lock()
+	defer w.cleanupMu.Unlock()
+	if w.compress {
+		if err := gzipFile(newest); err != nil {
+			fmt.Fprintf(os.Stderr, "Error compressing log file %s: %v\n", newest, err)
+		}
+	}
+
+	backups := w.backups()
+	for i, b := range backups {
+		if (w.maxBackups > 0 && i >= w.maxBackups) || (w.maxAge > 0 && time.Since(b.ModTime()) > w.maxAge) {
+			os.Remove(filepath.Join(filepath.Dir(w.name), b.Name()))
+		}
+	}
+}
+
+// backups returns the rotated files of the log, newest first.
+func (w *rotatingFile) backups() []os.FileInfo {
+	entries, err := os.ReadDir(filepath.Dir(w.name))
+	if err != nil {
+		return nil
+	}
+	ext := filepath.Ext(w.name)
+	prefix := strings.TrimSuffix(filepath.Base(w.name), ext) + "-"
+	var backups []os.FileInfo
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".gz"), ext), prefix)
+		if e.IsDir() || !ok || len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)]); err != nil {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			backups = append(backups, info)
+		}
+	}
+	// Names sort by rotation time
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name() > backups[j].Name() })
+	return backups
+}
+
+// gzipFile compresses a file to name.gz and removes the original.
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// fileExists reports whether a file exists.
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
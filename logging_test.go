@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRotatingFile tests that log files are rotated by size and old ones removed
+func TestRotatingFile(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	old := filepath.Join(dir, "proxy-2020-01-01T00-00-00.000000.log")
+	os.WriteFile(old, []byte("old\n"), 0o644)
+	os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+	unrelated := filepath.Join(dir, "proxy-access.log")
+	os.WriteFile(unrelated, []byte("keep\n"), 0o644)
+	w, err := openRotatingFile(&LoggingConfig{File: filepath.Join(dir, "proxy.log"), MaxBackups: 2, MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	w.maxBytes = 100
+
+	// Test
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(w, "line %02d %s\n", i, strings.Repeat("x", 30))
+	}
+	w.cleanup.Wait()
+
+	// Verify
+	current, _ := os.ReadFile(filepath.Join(dir, "proxy.log"))
+	if len(current) > 100 || !strings.HasPrefix(string(current), "line 08") {
+		t.Errorf("Expected the current file to hold the last lines, got %q", current)
+	}
+	backups := w.backups()
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %d", len(backups))
+	}
+	newest, _ := os.ReadFile(filepath.Join(dir, backups[0].Name()))
+	if !strings.HasPrefix(string(newest), "line 06") {
+		t.Errorf("Expected the newest backup first, got %q", newest)
+	}
+	if fileExists(old) {
+		t.Errorf("Expected the backup older than max_age to be removed")
+	}
+	if !fileExists(unrelated) {
+		t.Errorf("Expected files that are not backups to be kept")
+	}
+}
+
+// TestRotatingFileCompress tests that rotated log files are gzipped
+func TestRotatingFileCompress(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	w, err := openRotatingFile(&LoggingConfig{File: filepath.Join(dir, "proxy.log"), Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	w.maxBytes = 10
+
+	// Test
+	fmt.Fprintf(w, "first line\n")
+	fmt.Fprintf(w, "second line\n")
+	w.cleanup.Wait()
+
+	// Verify
+	backups := w.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0].Name(), ".log.gz") {
+		t.Fatalf("Expected one compressed backup, got %v", backups)
+	}
+	f, _ := os.Open(filepath.Join(dir, backups[0].Name()))
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "first line\n" {
+		t.Errorf("Expected the rotated lines in the backup, got %q", data)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Log levels
+//
+// Runtime messages have a level (debug, info, warn, or error) and belong to a
+// component:
+//
+//	router       routing and forwarding of each call
+//	healthcheck  upstream probes
+//	discovery    pool members discovered from Kubernetes and SRV records
+//	mirror       shadow traffic
+//
+// A message is logged if its level is at least the level of its component, which is
+// logging.components.<name> if set and logging.level (default: info) otherwise. Per-call
+// routing lines are logged at info, so `router: warn` keeps only problems, while
+// `router: debug` adds the details of routing decisions:
+//
+//	logging:
+//	  level: info
+//	  components:
+//	    router: warn
+//	    healthcheck: debug
+//
+// Levels can be changed at runtime through the admin API (GET and POST
+// /admin/log-level) without a restart. Startup messages are always logged.
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevelNames are the configuration names of the levels, by level.
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the configuration name of the level.
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// logComponents are the components whose level can be set.
+var logComponents = []string{"cache", "discovery", "healthcheck", "mirror", "router"}
+
+var (
+	logLevelsMu     sync.RWMutex        // Protects the levels
+	globalLogLevel  = levelInfo         // Level of components without their own
+	componentLevels map[string]logLevel // Levels set per component
+)
+
+// parseLogLevel parses a level name.
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q (expected %s)", name, strings.Join(logLevelNames, ", "))
+}
+
+// parseComponentLevels parses levels by component name.
+func parseComponentLevels(levels map[string]string) (map[string]logLevel, error) {
+	out := make(map[string]logLevel, len(levels))
+	for component, name := range levels {
+		if !isLogComponent(component) {
+			return nil, fmt.Errorf("unknown log component %q (expected %s)", component, strings.Join(logComponents, ", "))
+		}
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("component %s: %w", component, err)
+		}
+		out[component] = level
+	}
+	return out, nil
+}
+
+// isLogComponent reports whether a name is a known component.
+func isLogComponent(name string) bool {
+	for _, c := range logComponents {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setupLogLevels applies the configured log levels.
+//
+// Returns:
+//   - error: An error if a level or component is unknown
+func setupLogLevels() error {
+	global := levelInfo
+	var components map[string]logLevel
+	var rules []routeLogRule
+	if lc := config.Logging; lc != nil {
+		var err error
+		if lc.Level != "" {
+			if global, err = parseLogLevel(lc.Level); err != nil {
+				return err
+			}
+		}
+		if components, err = parseComponentLevels(lc.Components); err != nil {
+			return err
+		}
+		if rules, err = parseRouteLogOverrides(lc.Routes); err != nil {
+			return err
+		}
+	}
+
+	logLevelsMu.Lock()
+	globalLogLevel, componentLevels, routeLogRules = global, components, rules
+	logLevelsMu.Unlock()
+	registerAdminHandler("/admin/log-level", handleAdminLogLevel)
+	return nil
+}
+
+// componentLevel returns the level of a component.
+func componentLevel(component string) logLevel {
+	logLevelsMu.RLock()
+	defer logLevelsMu.RUnlock()
+	if level, ok := componentLevels[component]; ok {
+		return level
+	}
+	return globalLogLevel
+}
+
+// logAt logs a message of a component if its level is enabled.
+//
+// Parameters:
+//   - component: The component logging the message
+//   - level: The level of the message
+//   - format: The message format, as for log.Printf
+//   - args: The message arguments
+func logAt(component string, level logLevel, format string, args ...interface{}) {
+	if level < componentLevel(component) {
+		return
+	}
+	if out := currentLogOutput.Load(); out != nil {
+		out.emit(level, component, fmt.Sprintf(format, args...))
+		return
+	}
+	log.Output(3, fmt.Sprintf(format, args...))
+}
+
+// logDebug logs a debug message of a component.
+func logDebug(component, format string, args ...interface{}) {
+	logAt(component, levelDebug, format, args...)
+}
+
+// logInfo logs an informational message of a component.
+func logInfo(component, format string, args ...interface{}) {
+	logAt(component, levelInfo, format, args...)
+}
+
+// logWarn logs a warning of a component.
+func logWarn(component, format string, args ...interface{}) {
+	logAt(component, levelWarn, format, args...)
+}
+
+// logError logs an error of a component.
+func logError(component, format string, args ...interface{}) {
+	logAt(component, levelError, format, args...)
+}
+
+// logLevelsStatus is the body of /admin/log-level.
+type logLevelsStatus struct {
+	Level      string             `json:"level"`            // Level of components without their own
+	Components map[string]string  `json:"components"`       // Effective level of every component
+	Routes     []RouteLogOverride `json:"routes,omitempty"` // Per-route overrides (see routelog.go)
+}
+
+// handleAdminLogLevel reports the log levels on GET, and changes them on POST with a
+// body of {"level": "...", "components": {"router": "..."}, "routes": [...]}. All fields
+// are optional; a component set to "" goes back to the global level, and routes
+// replaces the per-route overrides.
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Level      string              `json:"level"`
+			Components map[string]string   `json:"components"`
+			Routes     *[]RouteLogOverride `json:"routes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `Expected a body of {"level": "...", "components": {...}}`, http.StatusBadRequest)
+			return
+		}
+		if req.Routes != nil {
+			if _, err := parseRouteLogOverrides(*req.Routes); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := setLogLevels(req.Level, req.Components); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Routes != nil {
+			setRouteLogOverrides(*req.Routes)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logLevelsMu.RLock()
+	status := logLevelsStatus{Level: globalLogLevel.String(), Components: make(map[string]string)}
+	logLevelsMu.RUnlock()
+	status.Routes = routeLogOverrides()
+	for _, c := range logComponents {
+		status.Components[c] = componentLevel(c).String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// setLogLevels changes the global level and component levels at runtime.
+//
+// Parameters:
+//   - level: The new global level, or "" to keep it
+//   - components: New component levels; "" resets a component to the global level
+//
+// Returns:
+//   - error: An error if a level or component is unknown, in which case nothing changes
+func setLogLevels(level string, components map[string]string) error {
+	global := logLevel(-1)
+	if level != "" {
+		var err error
+		if global, err = parseLogLevel(level); err != nil {
+			return err
+		}
+	}
+	set := make(map[string]string)
+	var reset []string
+	for component, name := range components {
+		if name == "" {
+			if !isLogComponent(component) {
+				return fmt.Errorf("unknown log component %q", component)
+			}
+			reset = append(reset, component)
+			continue
+		}
+		set[component] = name
+	}
+	levels, err := parseComponentLevels(set)
+	if err != nil {
+		return err
+	}
+
+	logLevelsMu.Lock()
+	if global >= 0 {
+		globalLogLevel = global
+	}
+	updated := make(map[string]logLevel, len(componentLevels)+len(levels))
+	for c, l := range componentLevels {
+		updated[c] = l
+	}
+	for c, l := range levels {
+		updated[c] = l
+	}
+	for _, c := range reset {
+		delete(updated, c)
+	}
+	componentLevels = updated
+	logLevelsMu.Unlock()
+
+	changes := make([]string, 0, len(components)+1)
+	if level != "" {
+		changes = append(changes, "level="+level)
+	}
+	for _, c := range sortedKeys(components) {
+		changes = append(changes, c+"="+components[c])
+	}
+	log.Printf("Log levels changed via admin API: %s", strings.Join(changes, " "))
+	return nil
+}
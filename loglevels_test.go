@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestLogLevels tests that messages are filtered by the level of their component
+func TestLogLevels(t *testing.T) {
+	// Setup
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	config = Config{Logging: &LoggingConfig{Level: "warn", Components: map[string]string{"healthcheck": "debug"}}}
+	defer func() {
+		config = Config{}
+		setupLogLevels()
+	}()
+	defer delete(adminHandlers, "/admin/log-level")
+
+	// Test
+	if err := setupLogLevels(); err != nil {
+		t.Fatalf("Failed to set up log levels: %v", err)
+	}
+	logInfo("router", "router info")
+	logWarn("router", "router warning")
+	logDebug("healthcheck", "healthcheck debug")
+	logError("mirror", "mirror error")
+
+	// Verify
+	for msg, expected := range map[string]bool{"router info": false, "router warning": true, "healthcheck debug": true, "mirror error": true} {
+		if strings.Contains(out.String(), msg) != expected {
+			t.Errorf("Expected %q logged: %v, got:\n%s", msg, expected, out.String())
+		}
+	}
+}
+
+// TestLogLevelErrors tests that unknown levels and components are rejected
+func TestLogLevelErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		logging  LoggingConfig
+		expected string
+	}{
+		{"Unknown level", LoggingConfig{Level: "verbose"}, `unknown log level "verbose"`},
+		{"Unknown component", LoggingConfig{Components: map[string]string{"routr": "debug"}}, `unknown log component "routr"`},
+		{"Unknown component level", LoggingConfig{Components: map[string]string{"router": "loud"}}, `component router: unknown log level "loud"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{Logging: &tc.logging}
+			defer func() { config = Config{} }()
+
+			// Test
+			err := setupLogLevels()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestAdminLogLevel tests changing log levels at runtime through the admin API
+func TestAdminLogLevel(t *testing.T) {
+	// Setup
+	config = Config{Admin: &AdminConfig{Enabled: true, Token: "s3cret"}, Logging: &LoggingConfig{Components: map[string]string{"mirror": "error"}}}
+	defer func() {
+		config = Config{}
+		setupLogLevels()
+	}()
+	if err := setupLogLevels(); err != nil {
+		t.Fatalf("Failed to set up log levels: %v", err)
+	}
+	defer delete(adminHandlers, "/admin/log-level")
+	handler := adminHandler()
+	request := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/log-level", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Test
+	changed := request("POST", `{"level": "error", "components": {"router": "debug", "mirror": ""}}`)
+	invalid := request("POST", `{"components": {"router": "chatty"}}`)
+	status := request("GET", "")
+
+	// Verify
+	if changed.Code != http.StatusOK || invalid.Code != http.StatusBadRequest {
+		t.Errorf("Expected 200 and 400, got %d and %d", changed.Code, invalid.Code)
+	}
+	expected := `{"level":"error","components":{"cache":"error","discovery":"error","healthcheck":"error","mirror":"error","router":"debug"}}`
+	if strings.TrimSpace(status.Body.String()) != expected {
+		t.Errorf("Expected %s, got %s", expected, status.Body.String())
+	}
+	if componentLevel("router") != levelDebug || componentLevel("mirror") != levelError {
+		t.Errorf("Expected router at debug and mirror back at the global level")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Syslog and journald output
+//
+// Besides standard error and log files, logs can be sent to syslog or to the systemd
+// journal, for fleets that centralize logs without a file-shipping agent:
+//
+//	logging:
+//	  syslog:
+//	    address: udp://logs.internal:514   # or tcp://host:601, unix:///dev/log
+//	    facility: local0
+//	  journald: true
+//
+// Syslog messages follow RFC 5424, framed by octet counting over TCP, with the
+// component as MSGID and the level and component as structured data. Journal entries
+// carry PRIORITY, SYSLOG_IDENTIFIER, and JSONRPC_PROXY_COMPONENT fields. Lines are
+// still written to logging.file if set; without a file, standard error is only used
+// when no sink is configured, so that a service under systemd does not log twice.
+
+// SyslogConfig configures the syslog sink.
+type SyslogConfig struct {
+	Address  string `yaml:"address"`  // udp://host:port, tcp://host:port, or unix:///path
+	Facility string `yaml:"facility"` // Facility name, e.g. "local0" (default: daemon)
+	AppName  string `yaml:"app_name"` // APP-NAME of the messages (default: jsonrpc-proxy)
+}
+
+// syslogFacilities are the facility codes by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the syslog severities of the log levels, by level.
+var syslogSeverities = []int{7, 6, 4, 3}
+
+// journalSocket is the socket of the systemd journal's native protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// logEntry is a message sent to the sinks.
+type logEntry struct {
+	time      time.Time
+	level     logLevel
+	component string // "" for messages outside the components
+	message   string
+}
+
+// logSink receives log entries.
+type logSink interface {
+	send(e logEntry) error
+}
+
+// logOutput writes log entries to the log lines and the sinks. Once installed, it also
+// receives the messages of the standard logger, as info messages without a component.
+type logOutput struct {
+	lines *log.Logger // Timestamped lines, or nil
+	sinks []logSink
+}
+
+// currentLogOutput is the installed output, or nil if logs only go through the standard
+// logger.
+var currentLogOutput atomic.Pointer[logOutput]
+
+// installLogSinks opens the configured sinks and routes logs through them.
+//
+// Parameters:
+//   - lines: Where timestamped lines are written, or nil for standard error
+//
+// Returns:
+//   - error: An error if a sink is misconfigured or cannot be reached
+func installLogSinks(lines io.Writer) error {
+	lc := config.Logging
+	if lc == nil || (lc.Syslog == nil && !lc.Journald) {
+		return nil
+	}
+	out := &logOutput{}
+	if lc.Syslog != nil {
+		s, err := newSyslogSink(lc.Syslog)
+		if err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+		out.sinks = append(out.sinks, s)
+	}
+	if lc.Journald {
+		s, err := newJournalSink()
+		if err != nil {
+			return fmt.Errorf("journald: %w", err)
+		}
+		out.sinks = append(out.sinks, s)
+	}
+	if lines != nil {
+		out.lines = log.New(lines, log.Prefix(), log.Flags())
+	}
+
+	// The standard logger hands over bare messages; the lines get their timestamps here
+	currentLogOutput.Store(out)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(out)
+	return nil
+}
+
+// Write implements io.Writer for the standard logger.
+func (o *logOutput) Write(p []byte) (int, error) {
+	o.emit(levelInfo, "", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// emit writes a message to the lines and every sink. Sink errors are reported on
+// standard error, since logging them would loop.
+func (o *logOutput) emit(level logLevel, component, message string) {
+	if o.lines != nil {
+		o.lines.Output(4, message)
+	}
+	e := logEntry{time: time.Now(), level: level, component: component, message: message}
+	for _, s := range o.sinks {
+		if err := s.send(e); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending log message: %v\n", err)
+		}
+	}
+}
+
+// syslogSink sends RFC 5424 messages to a syslog server.
+type syslogSink struct {
+	network  string // "udp", "tcp", or "unixgram"
+	address  string
+	facility int
+	appName  string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn // Current connection, redialed after a failure
+}
+
+// newSyslogSink connects to a syslog server.
+func newSyslogSink(sc *SyslogConfig) (*syslogSink, error) {
+	u, err := url.Parse(sc.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", sc.Address, err)
+	}
+	s := &syslogSink{facility: syslogFacilities["daemon"], appName: "jsonrpc-proxy"}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network, s.address = u.Scheme, u.Host
+	case "unix":
+		s.network, s.address = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("address %q must start with udp://, tcp://, or unix://", sc.Address)
+	}
+	if sc.Facility != "" {
+		f, ok := syslogFacilities[sc.Facility]
+		if !ok {
+			return nil, fmt.Errorf("unknown facility %q", sc.Facility)
+		}
+		s.facility = f
+	}
+	if sc.AppName != "" {
+		s.appName = sc.AppName
+	}
+	if s.hostname, err = os.Hostname(); err != nil || s.hostname == "" {
+		s.hostname = "-"
+	}
+	if s.conn, err = net.Dial(s.network, s.address); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// send implements logSink.
+func (s *syslogSink) send(e logEntry) error {
+	msg := s.format(e)
+	if s.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.Dial(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	_, err = conn.Write(msg)
+	return err
+}
+
+// format renders an entry as an RFC 5424 message.
+func (s *syslogSink) format(e logEntry) []byte {
+	msgID, data := "-", fmt.Sprintf(`[meta@32473 level="%s"]`, e.level)
+	if e.component != "" {
+		msgID = e.component
+		data = fmt.Sprintf(`[meta@32473 level="%s" component="%s"]`, e.level, sdEscape(e.component))
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		s.facility*8+syslogSeverities[e.level], e.time.UTC().Format(time.RFC3339Nano),
+		s.hostname, s.appName, os.Getpid(), msgID, data, e.message))
+}
+
+// sdEscape escapes a structured data parameter value.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// journalSink sends entries to the systemd journal with its native protocol.
+type journalSink struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// newJournalSink opens a socket to the journal.
+func newJournalSink() (*journalSink, error) {
+	if _, err := os.Stat(journalSocket); err != nil {
+		return nil, fmt.Errorf("journal socket not available: %w", err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn, addr: &net.UnixAddr{Name: journalSocket, Net: "unixgram"}}, nil
+}
+
+// send implements logSink.
+func (s *journalSink) send(e logEntry) error {
+	var buf bytes.Buffer
+	journalField(&buf, "MESSAGE", e.message)
+	journalField(&buf, "PRIORITY", fmt.Sprint(syslogSeverities[e.level]))
+	journalField(&buf, "SYSLOG_IDENTIFIER", "jsonrpc-proxy")
+	if e.component != "" {
+		journalField(&buf, "JSONRPC_PROXY_COMPONENT", e.component)
+	}
+	_, err := s.conn.WriteToUnix(buf.Bytes(), s.addr)
+	return err
+}
+
+// journalField appends a field in the journal's native format. Values with newlines
+// are sent length-prefixed.
+func journalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetLogOutput restores the standard logger after sinks were installed
+func resetLogOutput() {
+	currentLogOutput.Store(nil)
+	log.SetFlags(log.LstdFlags)
+	log.SetOutput(os.Stderr)
+}
+
+// TestSyslogSink tests sending RFC 5424 messages over UDP and TCP
+func TestSyslogSink(t *testing.T) {
+	// Setup
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer tcp.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		received <- string(buf[:n])
+	}()
+	udpSink, err := newSyslogSink(&SyslogConfig{Address: "udp://" + udp.LocalAddr().String(), Facility: "local0"})
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	tcpSink, err := newSyslogSink(&SyslogConfig{Address: "tcp://" + tcp.Addr().String(), AppName: "edge-proxy"})
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+
+	// Test
+	if err := udpSink.send(logEntry{time: time.Now(), level: levelWarn, component: "router", message: "main is saturated"}); err != nil {
+		t.Fatalf("Failed to send over UDP: %v", err)
+	}
+	if err := tcpSink.send(logEntry{time: time.Now(), level: levelInfo, message: "Starting"}); err != nil {
+		t.Fatalf("Failed to send over TCP: %v", err)
+	}
+
+	// Verify
+	buf := make([]byte, 1024)
+	udp.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read UDP message: %v", err)
+	}
+	expected := regexp.MustCompile(`^<132>1 \S+Z \S+ jsonrpc-proxy \d+ router \[meta@32473 level="warn" component="router"\] main is saturated$`)
+	if !expected.Match(buf[:n]) {
+		t.Errorf("Unexpected UDP message: %s", buf[:n])
+	}
+	select {
+	case msg := <-received:
+		m := regexp.MustCompile(`^(\d+) (<30>1 \S+ \S+ edge-proxy \d+ - \[meta@32473 level="info"\] Starting)$`).FindStringSubmatch(msg)
+		if m == nil || m[1] != strconv.Itoa(len(m[2])) {
+			t.Errorf("Unexpected TCP message: %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No TCP message received")
+	}
+}
+
+// TestSyslogSinkErrors tests that invalid syslog settings are rejected
+func TestSyslogSinkErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   SyslogConfig
+		expected string
+	}{
+		{"Unknown scheme", SyslogConfig{Address: "http://logs.example.com"}, "must start with udp://, tcp://, or unix://"},
+		{"Unknown facility", SyslogConfig{Address: "udp://127.0.0.1:514", Facility: "local9"}, `unknown facility "local9"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			_, err := newSyslogSink(&tc.config)
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestJournaldSink tests sending leveled messages to the journal with their fields
+func TestJournaldSink(t *testing.T) {
+	// Setup
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer journal.Close()
+	defer func(old string) { journalSocket = old }(journalSocket)
+	journalSocket = socket
+	logFile := filepath.Join(t.TempDir(), "proxy.log")
+	config = Config{Logging: &LoggingConfig{File: logFile, Journald: true, Components: map[string]string{"router": "warn"}}}
+	defer func() {
+		config = Config{}
+		setupLogLevels()
+		resetLogOutput()
+	}()
+	defer delete(adminHandlers, "/admin/log-level")
+	if err := setupLogging(); err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Test
+	logInfo("router", "dropped")
+	logWarn("router", "line one\nline two")
+	log.Printf("Plain message")
+
+	// Verify
+	var entries []string
+	journal.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(entries) < 3 {
+		buf := make([]byte, 4096)
+		n, err := journal.Read(buf)
+		if err != nil {
+			t.Fatalf("Failed to read journal entry %d: %v", len(entries), err)
+		}
+		entries = append(entries, string(buf[:n]))
+	}
+	var multiline bytes.Buffer
+	multiline.WriteString("MESSAGE\n")
+	binary.Write(&multiline, binary.LittleEndian, uint64(len("line one\nline two")))
+	multiline.WriteString("line one\nline two\nPRIORITY=4\nSYSLOG_IDENTIFIER=jsonrpc-proxy\nJSONRPC_PROXY_COMPONENT=router\n")
+	if entries[1] != multiline.String() {
+		t.Errorf("Unexpected journal entry: %q", entries[1])
+	}
+	if entries[2] != "MESSAGE=Plain message\nPRIORITY=6\nSYSLOG_IDENTIFIER=jsonrpc-proxy\n" {
+		t.Errorf("Unexpected journal entry: %q", entries[2])
+	}
+	lines, _ := os.ReadFile(logFile)
+	if strings.Contains(string(lines), "dropped") || !regexp.MustCompile(`\d{2}:\d{2}:\d{2} Plain message\n`).Match(lines) {
+		t.Errorf("Expected timestamped lines in the log file, got:\n%s", lines)
+	}
+}
@@ -36,20 +36,28 @@
 //
 // # Options
 //
-//   -config: Path to the YAML configuration file (default: "config.yaml")
-//   -port:   Port to run the proxy server on (default: 8080)
+//	-config: Path to the YAML configuration file (default: "config.yaml")
+//	-port:   Port to run the proxy server on (default: 8080)
+//
+// Every configuration option can also be given as a flag or a JSONRPC_PROXY_*
+// environment variable, such as -default-url or JSONRPC_PROXY_DEFAULT_URL (see overrides.go).
 package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -59,17 +67,90 @@ import (
 // Route defines a single method-to-URL mapping for JSON-RPC method routing.
 // Each Route specifies which JSON-RPC method should be forwarded to a particular URL.
 type Route struct {
-	Method string `yaml:"method"` // The JSON-RPC method name (e.g., "eth_chainId")
-	URL    string `yaml:"url"`    // The destination URL for this method
-	Name   string `yaml:"name"`   // A human-readable name for this URL (for logging)
+	Method            string           `yaml:"method"`              // The JSON-RPC method name (e.g., "eth_chainId")
+	URL               string           `yaml:"url"`                 // The destination URL for this method
+	Name              string           `yaml:"name"`                // A human-readable name for this URL (for logging)
+	Transport         string           `yaml:"transport"`           // Name of a registered transport for this URL (optional)
+	When              string           `yaml:"when"`                // Expression that must hold for the route to match (optional)
+	Schedule          *RouteSchedule   `yaml:"schedule"`            // Times the route is active (optional, see schedule.go)
+	Rewrite           *MethodRewrite   `yaml:"rewrite"`             // Outbound method and params rewriting (optional, see rewrite.go)
+	ParamRules        []ParamRule      `yaml:"param_rules"`         // Param fixes applied to every call (optional)
+	Stub              *StubResponse    `yaml:"stub"`                // Canned response served without contacting an upstream (optional)
+	Headers           *HeaderRules     `yaml:"headers"`             // Outbound header rules applied after the global ones (optional)
+	Mirror            *MirrorConfig    `yaml:"mirror"`              // Shadow upstream receiving copies of the calls (optional)
+	Pool              string           `yaml:"pool"`                // Named pool serving this route instead of url (optional)
+	BlueGreen         *BlueGreenConfig `yaml:"blue_green"`          // Blue and green pools serving this route, with a runtime switchover (optional, see bluegreen.go)
+	Tags              []string         `yaml:"tags"`                // Labels grouping the route in metrics and logs (optional)
+	Description       string           `yaml:"description"`         // What the route is for, shown by proxy_routes and OpenRPC (optional)
+	Retries           *BackoffPolicy   `yaml:"retries"`             // Retry backoff of the route's calls, over the global one (optional)
+	FallbackToDefault bool             `yaml:"fallback_to_default"` // Serve the calls by the default route while the route's upstream is unhealthy
 }
 
 // Config holds the complete proxy configuration loaded from the YAML file.
 // It contains the default fallback URL and a list of method-specific routes.
 type Config struct {
-	DefaultURL  string  `yaml:"default_url"`  // URL for methods without specific routes
-	DefaultName string  `yaml:"default_name"` // A human-readable name for the default URL (for logging)
-	Routes      []Route `yaml:"routes"`       // List of method-specific routes
+	Version            int                           `yaml:"version,omitempty"`    // Configuration layout version (see versioning.go)
+	Chain              string                        `yaml:"chain"`                // Chain served, for default upstreams and chain ID verification (optional, see chains.go)
+	DefaultURL         string                        `yaml:"default_url"`          // URL for methods without specific routes
+	DefaultName        string                        `yaml:"default_name"`         // A human-readable name for the default URL (for logging)
+	DefaultTransport   string                        `yaml:"default_transport"`    // Name of a registered transport for the default URL (optional)
+	Routes             []Route                       `yaml:"routes"`               // List of method-specific routes
+	RouteGroups        []RouteGroup                  `yaml:"route_groups"`         // Routes sharing default settings (optional)
+	Plugins            []string                      `yaml:"plugins"`              // Paths of Go plugins providing extension hooks
+	Router             string                        `yaml:"router"`               // Name of the routing implementation (default: "method")
+	PrivateTx          *PrivateTxConfig              `yaml:"private_tx"`           // Private relay for transaction submissions (optional)
+	Probe              *ProbeConfig                  `yaml:"probe"`                // Periodic upstream probing and head tracking (optional)
+	Filters            *FiltersConfig                `yaml:"filters"`              // Local filter API emulation (optional)
+	ErrorNormalization *ErrorNormalizationConfig     `yaml:"error_normalization"`  // Provider error normalization (optional)
+	FeeAggregation     *FeeAggregationConfig         `yaml:"fee_aggregation"`      // Fee estimates aggregated across upstreams (optional)
+	MetaMethods        *MetaMethodsConfig            `yaml:"meta_methods"`         // proxy_* introspection methods (optional)
+	ResponseHeaders    *ResponseHeadersConfig        `yaml:"response_headers"`     // Upstream headers added to responses (optional)
+	WriteRouting       *WriteRoutingConfig           `yaml:"write_routing"`        // Dedicated pool for write methods (optional)
+	ReceiptWait        *ReceiptWaitConfig            `yaml:"receipt_wait"`         // proxy_waitForTransactionReceipt helper (optional)
+	ResponseRules      []ResponseRule                `yaml:"response_rules"`       // Response rewriting rules (optional)
+	Headers            *HeaderRules                  `yaml:"headers"`              // Outbound header rules for every forwarded request (optional)
+	Pools              map[string]*PoolConfig        `yaml:"pools"`                // Named upstream pools (optional)
+	DefaultPool        string                        `yaml:"default_pool"`         // Pool serving methods without specific routes, instead of default_url (optional)
+	TrustForwardedFor  bool                          `yaml:"trust_forwarded_for"`  // Identify clients by X-Forwarded-For (behind a load balancer)
+	TrustedProxies     []string                      `yaml:"trusted_proxies"`      // Peers whose X-Forwarded-For and X-Real-IP are believed (optional, see clients.go)
+	ProxyProtocol      *ProxyProtocolConfig          `yaml:"proxy_protocol"`       // PROXY protocol headers on the proxy listener (optional, see proxyproto.go)
+	Server             *ServerConfig                 `yaml:"server"`               // Timeouts and limits of the proxy's HTTP server (optional, see server.go)
+	ResponseLimits     *ResponseLimitsConfig         `yaml:"response_limits"`      // Upstream response size limits by method (optional, see responselimits.go)
+	SRVRefreshInterval time.Duration                 `yaml:"srv_refresh_interval"` // How often SRV upstream addresses are resolved (default: 30s)
+	EgressProxies      map[string]*EgressProxyConfig `yaml:"egress_proxies"`       // Egress proxies, registered as transports by name (optional)
+	TLSPolicies        map[string]*TLSPolicyConfig   `yaml:"tls_policies"`         // Upstream TLS policies, registered as transports by name (optional)
+	AWSSigners         map[string]*AWSSignerConfig   `yaml:"aws_signers"`          // AWS SigV4 signers, registered as transports by name (optional)
+	EgressProxy        string                        `yaml:"egress_proxy"`         // Egress proxy for upstreams without a transport (optional)
+	Region             string                        `yaml:"region"`               // Region the proxy runs in, to prefer upstreams of the same region (optional)
+	OpenRPC            *OpenRPCConfig                `yaml:"openrpc"`              // OpenRPC discovery document (optional)
+	Metrics            *MetricsConfig                `yaml:"metrics"`              // Prometheus metrics endpoint (optional)
+	Concurrency        *ConcurrencyConfig            `yaml:"concurrency"`          // Concurrency limits and request queuing (optional)
+	MemoryShedding     *MemorySheddingConfig         `yaml:"memory_shedding"`      // Load shedding under memory pressure (optional, see memshed.go)
+	RateLimits         *RateLimitConfig              `yaml:"rate_limits"`          // Compute-unit rate limits per client (optional, see ratelimit.go)
+	GlobalRateLimit    *GlobalRateLimitConfig        `yaml:"global_rate_limit"`    // Instance-wide call rate ceiling (optional, see globallimit.go)
+	JSONLimits         *JSONLimitsConfig             `yaml:"json_limits"`          // Request body structure limits (optional, see jsonlimits.go)
+	MaxBatchSizes      map[string]int                `yaml:"max_batch_sizes"`      // Most calls per batch by upstream name or URL (optional, see batchsplit.go)
+	BatchTimeout       *BatchTimeoutConfig           `yaml:"batch_timeout"`        // Timeouts of the upstream batches of a client batch (optional, see batchsplit.go)
+	BatchStreaming     *BatchStreamingConfig         `yaml:"batch_streaming"`      // Streaming of large batch responses (optional, see batchstream.go)
+	UpstreamTimeouts   *UpstreamTimeoutsConfig       `yaml:"upstream_timeouts"`    // Dial, TLS handshake, response header, and total timeouts per upstream (optional)
+	Retries            *RetryConfig                  `yaml:"retries"`              // Retries of failed upstream requests within a budget (optional)
+	MethodBlocking     *MethodBlockingConfig         `yaml:"method_blocking"`      // Node-management methods rejected by default, and exceptions (optional, see methodblock.go)
+	UpstreamPacing     *UpstreamPacingConfig         `yaml:"upstream_pacing"`      // Outbound request rate and concurrency quotas per upstream (optional, see pacing.go)
+	DNSCache           *DNSCacheConfig               `yaml:"dns_cache"`            // Cache of upstream DNS lookups, re-resolved in the background (optional, see dnscache.go)
+	UpstreamQuotas     *UpstreamQuotasConfig         `yaml:"upstream_quotas"`      // Monthly and daily provider quotas shifting traffic away as they run out (optional, see quotas.go)
+	Failback           *FailbackConfig               `yaml:"failback"`             // When routes falling back to the default route return to their upstream (optional, see fallback.go)
+	BatchConcurrency   *BatchConcurrencyConfig       `yaml:"batch_concurrency"`    // Batches of a client batch sent at once (optional, see batchsplit.go)
+	MicroBatch         *MicroBatchConfig             `yaml:"micro_batch"`          // Batching of single calls toward upstreams (optional, see microbatch.go)
+	Cache              *CacheConfig                  `yaml:"cache"`                // Response caching and warming (optional, see cache.go)
+	Stats              *StatsConfig                  `yaml:"stats"`                // Method usage statistics (optional, see topmethods.go)
+	Faults             *FaultConfig                  `yaml:"faults"`               // Fault injection for resiliency testing (optional)
+	Admin              *AdminConfig                  `yaml:"admin"`                // Admin API (optional)
+	Recording          *RecordingConfig              `yaml:"recording"`            // Traffic recording for offline replay (optional)
+	GRPC               *GRPCConfig                   `yaml:"grpc"`                 // gRPC front-end (optional)
+	REST               *RESTConfig                   `yaml:"rest"`                 // REST-to-JSON-RPC gateway (optional)
+	Logging            *LoggingConfig                `yaml:"logging"`              // Log file and rotation (optional)
+	AccessLog          *AccessLogConfig              `yaml:"access_log"`           // Per-request access log (optional, see accesslog.go)
+	Auth               *AuthConfig                   `yaml:"auth"`                 // Client authentication (optional, see auth.go)
 }
 
 // JSONRPCRequest represents the structure of a JSON-RPC 2.0 request.
@@ -79,28 +160,92 @@ type JSONRPCRequest struct {
 	Method  string      `json:"method"`  // The method to invoke
 	Params  interface{} `json:"params"`  // Method parameters
 	ID      interface{} `json:"id"`      // Request identifier
+
+	client clientInfo // The client that sent the call (not part of the JSON encoding)
+}
+
+// conditionalRoute is a route with a compiled `when` expression or schedule.
+type conditionalRoute struct {
+	route    Route
+	cond     expr      // The when expression, or nil
+	schedule *schedule // The schedule, or nil
 }
 
 // Global variables
-var config Config                  // Holds the loaded configuration
-var methodToURL map[string]string  // Maps method names to destination URLs
-var methodToName map[string]string // Maps method names to URL display names
+var config Config                        // Holds the loaded configuration
+var methodToURL map[string]string        // Maps method names to destination URLs
+var methodToName map[string]string       // Maps method names to URL display names
+var methodToRoute map[string]*Route      // Maps method names to their configured routes
+var conditionalRoutes []conditionalRoute // Routes with `when` expressions or schedules, in configuration order
 
 // main is the entry point of the application.
 // It loads the configuration, sets up the HTTP server, and starts listening for requests.
 // It also supports overriding configuration via environment variables.
 func main() {
-	// Parse command line flags
-	configFile := flag.String("config", "config.yaml", "Path to configuration file")
-	port := flag.Int("port", 8080, "Port to run the proxy server on")
+	// Write an example configuration with `jsonrpc-proxy init`
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if err := runInit(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			log.Fatalf("init: %v", err)
+		}
+		return
+	}
+
+	// Print the bcrypt hash of a basic auth password with `jsonrpc-proxy hash-password`
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		if err := runHashPassword(os.Args[2:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			log.Fatalf("hash-password: %v", err)
+		}
+		return
+	}
+
+	// Upgrade a configuration file to the current layout with `jsonrpc-proxy migrate`
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	// Parse command line flags, including one for every configuration option
+	configFile := flag.String("config", "config.yaml", "Path to configuration file, or none to run from flags and environment variables (env JSONRPC_PROXY_CONFIG)")
+	port := flag.Int("port", 8080, "Port to run the proxy server on (env JSONRPC_PROXY_PORT)")
+	replayFile := flag.String("replay", "", "Replay a traffic recording against the configuration and exit")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+	valuesFile := flag.String("values", "", "Values file rendering a configuration template (env JSONRPC_PROXY_VALUES)")
+	overrides := newConfigOverrides()
+	overrides.registerFlags(flag.CommandLine)
 	flag.Parse()
+	overrides.resolve(os.Environ(), envPrefix+"CONFIG", envPrefix+"PORT", envPrefix+"VALUES")
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
 
-	// Allow overriding via environment variables (for Docker/container usage)
+	// Allow overriding via environment variables (for Docker/container usage).
+	// Flags take precedence, except over the older CONFIG_PATH and PORT variables.
+	envPort := os.Getenv("PORT")
+	if v := os.Getenv(envPrefix + "PORT"); v != "" && !setFlags["port"] {
+		envPort = v
+	}
+	if envConfig := os.Getenv(envPrefix + "CONFIG"); envConfig != "" && !setFlags["config"] {
+		*configFile = envConfig
+	}
 	if envConfig := os.Getenv("CONFIG_PATH"); envConfig != "" {
 		*configFile = envConfig
 	}
+	if envValues := os.Getenv(envPrefix + "VALUES"); envValues != "" && !setFlags["values"] {
+		*valuesFile = envValues
+	}
 
-	if envPort := os.Getenv("PORT"); envPort != "" {
+	if envPort != "" {
 		if p, err := strconv.Atoi(envPort); err == nil {
 			*port = p
 		} else {
@@ -108,17 +253,238 @@ func main() {
 		}
 	}
 
+	// Run from flags and environment variables alone if asked to or if there is no
+	// configuration file, unless one was explicitly requested with -config
+	if *configFile == noConfigFile {
+		*configFile = ""
+	} else if _, err := os.Stat(*configFile); errors.Is(err, fs.ErrNotExist) && !setFlags["config"] {
+		log.Printf("Configuration file %s not found, using flags and environment variables only", *configFile)
+		*configFile = ""
+	}
+
 	// Load configuration
-	if err := loadConfig(*configFile); err != nil {
+	if err := loadConfigWith(*configFile, *valuesFile, overrides); err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *printConfig {
+		if err := printEffectiveConfig(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	// Write logs to a rotated file if configured
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	if err := setupAccessLog(); err != nil {
+		log.Fatalf("Invalid access log configuration: %v", err)
+	}
 
 	// Create method to URL mapping for faster lookups
 	buildMethodURLMap()
 
+	// Load extension plugins
+	if err := loadPlugins(config.Plugins); err != nil {
+		log.Fatalf("Failed to load plugins: %v", err)
+	}
+	if len(config.Plugins) > 0 {
+		log.Printf("Loaded %d plugins", len(config.Plugins))
+	}
+
+	// Select the routing implementation
+	if err := setupRouter(); err != nil {
+		log.Fatalf("Invalid router configuration: %v", err)
+	}
+
+	// Prefer upstreams in the proxy's region
+	setupRegion()
+
+	// Set up the write pool
+	if err := setupWriteRouting(); err != nil {
+		log.Fatalf("Invalid write_routing configuration: %v", err)
+	}
+
+	// Resolve upstream hosts through the DNS cache
+	if err := setupDNSCache(); err != nil {
+		log.Fatalf("Invalid dns_cache configuration: %v", err)
+	}
+
+	// Register egress proxies as transports
+	if err := setupEgressProxies(); err != nil {
+		log.Fatalf("Invalid egress proxy configuration: %v", err)
+	}
+
+	// Register TLS policies and AWS SigV4 signers as transports
+	if err := setupTLSPolicies(); err != nil {
+		log.Fatalf("Invalid tls_policies configuration: %v", err)
+	}
+	if err := setupAWSSigners(); err != nil {
+		log.Fatalf("Invalid aws_signers configuration: %v", err)
+	}
+
+	// Set up private transaction routing
+	if err := setupPrivateTx(); err != nil {
+		log.Fatalf("Invalid private_tx configuration: %v", err)
+	}
+
+	// Resolve the transport used for each upstream
+	if err := buildTransportMap(); err != nil {
+		log.Fatalf("Invalid transport configuration: %v", err)
+	}
+	if err := validateTransports(); err != nil {
+		log.Fatalf("Invalid transport configuration: %v", err)
+	}
+	if err := setupUpstreamTimeouts(); err != nil {
+		log.Fatalf("Invalid upstream_timeouts configuration: %v", err)
+	}
+	if err := setupRetries(); err != nil {
+		log.Fatalf("Invalid retries configuration: %v", err)
+	}
+	if err := setupUpstreamPacing(); err != nil {
+		log.Fatalf("Invalid upstream_pacing configuration: %v", err)
+	}
+	if err := setupUpstreamQuotas(); err != nil {
+		log.Fatalf("Invalid upstream_quotas configuration: %v", err)
+	}
+	if err := setupFailback(); err != nil {
+		log.Fatalf("Invalid failback configuration: %v", err)
+	}
+	setupBlueGreen()
+	if err := verifyChainID(context.Background()); err != nil {
+		log.Fatalf("Wrong chain: %v", err)
+	}
+
+	// Compile error normalization rules
+	if err := setupErrorNormalization(); err != nil {
+		log.Fatalf("Invalid error_normalization configuration: %v", err)
+	}
+
+	// Apply response rewriting rules after normalization
+	if err := setupResponseRules(); err != nil {
+		log.Fatalf("Invalid response_rules configuration: %v", err)
+	}
+
+	// Aggregate fee estimates across upstreams if enabled
+	if err := setupFeeAggregation(); err != nil {
+		log.Fatalf("Invalid fee_aggregation configuration: %v", err)
+	}
+
+	// Answer proxy_* introspection methods if enabled
+	setupMetaMethods()
+
+	// Serve the OpenRPC discovery document if enabled
+	setupOpenRPC()
+
+	// Answer proxy_waitForTransactionReceipt if enabled
+	setupReceiptWait()
+
+	// Bound concurrent requests and queue the overflow if configured
+	if err := setupConcurrency(); err != nil {
+		log.Fatalf("Invalid concurrency configuration: %v", err)
+	}
+	if err := setupRateLimits(); err != nil {
+		log.Fatalf("Invalid rate_limits configuration: %v", err)
+	}
+	if err := setupGlobalRateLimit(); err != nil {
+		log.Fatalf("Invalid global_rate_limit configuration: %v", err)
+	}
+	if err := setupCache(); err != nil {
+		log.Fatalf("Invalid cache configuration: %v", err)
+	}
+	if err := setupJSONLimits(); err != nil {
+		log.Fatalf("Invalid json_limits configuration: %v", err)
+	}
+	if err := setupResponseLimits(); err != nil {
+		log.Fatalf("Invalid response_limits configuration: %v", err)
+	}
+	if err := setupBatchSplitting(); err != nil {
+		log.Fatalf("Invalid batch configuration: %v", err)
+	}
+	if err := setupMemoryShedding(); err != nil {
+		log.Fatalf("Invalid memory_shedding configuration: %v", err)
+	}
+	if err := setupBatchStreaming(); err != nil {
+		log.Fatalf("Invalid batch_streaming configuration: %v", err)
+	}
+	if err := setupMicroBatching(); err != nil {
+		log.Fatalf("Invalid micro_batch configuration: %v", err)
+	}
+
+	if err := setupTrustedProxies(); err != nil {
+		log.Fatalf("Invalid trusted_proxies configuration: %v", err)
+	}
+
+	if err := setupResponseHeaders(); err != nil {
+		log.Fatalf("Invalid response_headers configuration: %v", err)
+	}
+
+	// Prepare fault injection, switched on and off through the admin API
+	if err := setupMethodBlocking(); err != nil {
+		log.Fatalf("Invalid method_blocking configuration: %v", err)
+	}
+	setupFaults()
+	setupConfigDump()
+	setupLiveTail()
+	if err := setupAdmin(); err != nil {
+		log.Fatalf("Invalid admin configuration: %v", err)
+	}
+
+	// Discover pool members from Kubernetes and DNS SRV records if configured
+	if err := startDiscovery(context.Background()); err != nil {
+		log.Fatalf("Invalid pool discovery configuration: %v", err)
+	}
+	startSRVDiscovery(context.Background())
+
+	// Answer filter methods locally if enabled, and start tracking upstream heads
+	setupFilters()
+	startProbes(context.Background())
+	startQuotaPersistence(context.Background())
+	startDNSRefresh(context.Background())
+	startMemoryMonitor(context.Background())
+
+	// Replay a recording instead of serving traffic
+	if *replayFile != "" {
+		result, err := runReplay(*replayFile, os.Stdout)
+		if err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		if result.Differs > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Record a sample of the traffic if configured
+	if err := setupRecording(); err != nil {
+		log.Fatalf("Invalid recording configuration: %v", err)
+	}
+
+	// Authenticate clients if configured
+	if err := setupAuth(); err != nil {
+		log.Fatalf("Invalid auth configuration: %v", err)
+	}
+
+	// Keep method usage statistics if configured
+	if err := setupStats(); err != nil {
+		log.Fatalf("Invalid stats configuration: %v", err)
+	}
+
 	// Set up HTTP server
-	http.HandleFunc("/", handleProxy)
+	proxyHandler := withAccessLog(withBranding(withJSONLimits(withAuth(withRouteDebug(withForcedUpstream(withStats(withFaults(withRecording(handleProxy)))))))))
+	http.HandleFunc("/", proxyHandler)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("GET /health/details", handleHealthDetails)
+	http.HandleFunc("GET "+openRPCPath, handleOpenRPC)
+	http.HandleFunc("GET /stats/top", handleStatsTop)
+	if err := setupREST(http.DefaultServeMux, proxyHandler); err != nil {
+		log.Fatalf("Invalid rest configuration: %v", err)
+	}
+	startAdmin()
+	startMetrics()
+	if err := startGRPC(proxyHandler); err != nil {
+		log.Fatalf("Failed to start gRPC front-end: %v", err)
+	}
 	serverAddr := fmt.Sprintf(":%d", *port)
 	log.Printf("Starting JSON-RPC HTTP proxy server on %s", serverAddr)
 
@@ -130,7 +496,15 @@ func main() {
 
 	log.Printf("Loaded %d method-specific routes", len(config.Routes))
 
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
+	server, err := newServer(http.DefaultServeMux)
+	if err != nil {
+		log.Fatalf("Invalid server configuration: %v", err)
+	}
+	listener, err := listen(serverAddr)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	if err := server.Serve(listener); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -144,19 +518,109 @@ func main() {
 // Returns:
 //   - error: An error if the configuration cannot be loaded or is invalid
 func loadConfig(filename string) error {
-	data, err := os.ReadFile(filename)
+	return loadConfigWith(filename, "", nil)
+}
+
+// loadConfigWith is loadConfig with options set by flags and environment variables
+// applied on top of the file.
+//
+// Parameters:
+//   - filename: The path to the configuration file, or "" to use the overrides alone
+//   - valuesFile: The values file rendering a configuration template, or "" for none
+//   - overrides: The options set by flags and environment variables (may be nil)
+//
+// Returns:
+//   - error: An error if the configuration cannot be loaded or is invalid
+func loadConfigWith(filename, valuesFile string, overrides *configOverrides) error {
+	var root *yaml.Node
+	source := "from flags and environment"
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("error reading config file: %w", err)
+		}
+		if isConfigTemplate(filename) {
+			if data, err = renderConfigTemplate(filename, data, valuesFile); err != nil {
+				return fmt.Errorf("error rendering config template: %w", err)
+			}
+		}
+		root, err = parseConfigDocument(filename, data)
+		if err != nil {
+			return fmt.Errorf("error parsing config file %s: %w", filename, err)
+		}
+		if err := checkConfigVersion(filename, root); err != nil {
+			return fmt.Errorf("invalid configuration %s: %w", filename, err)
+		}
+		source = filename
+	}
+	root, err := overrides.apply(root)
 	if err != nil {
-		return fmt.Errorf("error reading config file: %w", err)
+		return fmt.Errorf("invalid configuration override: %w", err)
+	}
+	if err := decodeConfig(root, &config); err != nil {
+		return fmt.Errorf("invalid configuration %s: %w", source, err)
+	}
+	if err := expandRouteGroups(); err != nil {
+		return err
+	}
+	if err := applyChainPreset(); err != nil {
+		return err
+	}
+	if err := validateURLs(); err != nil {
+		return err
 	}
 
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return fmt.Errorf("error unmarshaling YAML: %w", err)
+	// Turn SRV addresses into pools of their targets
+	if err := expandSRVURLs(); err != nil {
+		return err
 	}
 
 	// Validate configuration
-	if config.DefaultURL == "" {
-		return fmt.Errorf("default_url is required in configuration")
+	if config.DefaultURL == "" && config.DefaultPool == "" {
+		return fmt.Errorf("default_url or default_pool is required in configuration")
+	}
+	if err := validatePools(); err != nil {
+		return err
+	}
+
+	// Check that routing expressions and schedules compile
+	for i, route := range config.Routes {
+		if route.When != "" {
+			if _, err := compileExpr(route.When); err != nil {
+				return configErrorf(fmt.Sprintf("routes[%d].when", i), "route %d (%s): invalid when expression: %w", i, route.Method, err)
+			}
+		}
+		if route.Schedule != nil {
+			if _, err := compileSchedule(route.Schedule); err != nil {
+				return configErrorf(fmt.Sprintf("routes[%d].schedule", i), "route %d (%s): invalid schedule: %w", i, route.Method, err)
+			}
+		}
+	}
+
+	// Check that rewrite templates compile and param rules are well-formed
+	for i, route := range config.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		if err := validateParamRules(route.ParamRules); err != nil {
+			return configErrorf(path+".param_rules", "route %d (%s): %w", i, route.Method, err)
+		}
+		if err := validateStub(route.Stub); err != nil {
+			return configErrorf(path+".stub", "route %d (%s): %w", i, route.Method, err)
+		}
+		if route.Mirror != nil && route.Mirror.URL == "" {
+			return configErrorf(path+".mirror", "route %d (%s): mirror url is required", i, route.Method)
+		}
+		if err := validateFallback(&route); err != nil {
+			return configErrorf(path+".fallback_to_default", "route %d (%s): %w", i, route.Method, err)
+		}
+		if err := validateBlueGreen(&route); err != nil {
+			return configErrorf(path+".blue_green", "route %d (%s): %w", i, route.Method, err)
+		}
+		if route.Rewrite == nil {
+			continue
+		}
+		if err := route.Rewrite.compile(); err != nil {
+			return configErrorf(path+".rewrite", "route %d (%s): invalid rewrite: %w", i, route.Method, err)
+		}
 	}
 
 	// If default_name isn't provided, set a generic name
@@ -170,12 +634,45 @@ func loadConfig(filename string) error {
 // buildMethodURLMap creates a lookup map from method names to their destination URLs.
 // This improves performance by allowing O(1) lookups instead of iterating through routes.
 // It also builds a map of method names to human-readable URL names for logging.
+// Routes with a `when` expression or schedule are kept in order in conditionalRoutes instead.
 func buildMethodURLMap() {
 	methodToURL = make(map[string]string)
 	methodToName = make(map[string]string)
+	methodToRoute = make(map[string]*Route)
+	conditionalRoutes = nil
+
+	for i := range config.Routes {
+		route := config.Routes[i]
+		if route.Rewrite != nil {
+			if err := route.Rewrite.compile(); err != nil {
+				log.Printf("Skipping route for method '%s': invalid rewrite: %v", route.Method, err)
+				continue
+			}
+		}
+		if route.When != "" || route.Schedule != nil {
+			cr := conditionalRoute{route: route}
+			if route.When != "" {
+				cond, err := compileExpr(route.When)
+				if err != nil {
+					log.Printf("Skipping route for method '%s': invalid when expression: %v", route.Method, err)
+					continue
+				}
+				cr.cond = cond
+			}
+			if route.Schedule != nil {
+				sched, err := compileSchedule(route.Schedule)
+				if err != nil {
+					log.Printf("Skipping route for method '%s': invalid schedule: %v", route.Method, err)
+					continue
+				}
+				cr.schedule = sched
+			}
+			conditionalRoutes = append(conditionalRoutes, cr)
+			continue
+		}
 
-	for _, route := range config.Routes {
 		methodToURL[route.Method] = route.URL
+		methodToRoute[route.Method] = &config.Routes[i]
 
 		// Use the provided name or the URL if name is empty
 		displayName := route.Name
@@ -186,6 +683,67 @@ func buildMethodURLMap() {
 	}
 }
 
+// resolveTarget determines the destination URL and display name for a JSON-RPC request.
+// Conditional routes are tried first, in configuration order; a conditional route without
+// a method matches any method. Then the method lookup map is consulted, and finally the
+// default URL is used.
+//
+// Parameters:
+//   - req: The parsed JSON-RPC request
+//
+// Returns:
+//   - string: The destination URL
+//   - string: The display name of the destination for logging
+func resolveTarget(req *JSONRPCRequest) (string, string) {
+	targetURL, displayName, _ := resolveRoute(req)
+	return targetURL, displayName
+}
+
+// resolveRoute is resolveTarget that also returns the configured route that matched,
+// or nil when the default URL is used.
+func resolveRoute(req *JSONRPCRequest) (string, string, *Route) {
+	for i := range conditionalRoutes {
+		cr := &conditionalRoutes[i]
+		if cr.route.Method != "" && cr.route.Method != req.Method {
+			continue
+		}
+		if cr.schedule != nil && !cr.schedule.active(time.Now()) {
+			continue
+		}
+		if cr.cond != nil {
+			matched, err := evalCondition(cr.cond, req)
+			if err != nil {
+				logError("router", "Error evaluating when expression for method '%s': %v", req.Method, err)
+				continue
+			}
+			if !matched {
+				continue
+			}
+		}
+		if cr.route.Name != "" {
+			return cr.route.URL, cr.route.Name, &cr.route
+		}
+		return cr.route.URL, cr.route.URL, &cr.route
+	}
+
+	// Determine target URL based on the method
+	targetURL, exists := methodToURL[req.Method]
+	if !exists {
+		targetURL = config.DefaultURL
+	}
+
+	// Get display name for logging
+	displayName := config.DefaultName
+	if dn, exists := methodToName[req.Method]; exists {
+		displayName = dn
+	}
+	if displayName == "" {
+		displayName = "default"
+	}
+
+	return targetURL, displayName, methodToRoute[req.Method]
+}
+
 // handleProxy processes incoming HTTP requests, extracts the JSON-RPC method,
 // determines the appropriate destination URL, and forwards the request.
 // It then relays the response back to the original client.
@@ -209,6 +767,51 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// Give extension hooks a chance to inspect or replace the body
+	body, err = runRequestReceivedHooks(r, body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Request rejected: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Keep the instance under its global call rate
+	if !checkGlobalRateLimit(w, r, body) {
+		return
+	}
+
+	// Charge the request's compute units to the client
+	if !checkRateLimit(w, r, body) {
+		return
+	}
+
+	// Wait for capacity on the proxy
+	prio := requestPriority(r, body)
+	r = withTrace(r.WithContext(withPriority(r.Context(), prio)))
+	if shedForMemory(prio, body) {
+		logWarn("router", "Rejecting %s priority request: memory pressure (%d MB of heap)", prio, heapBytes.Load()>>20)
+		writeSaturated(w, body, errMemoryPressure)
+		return
+	}
+	releaseClient, err := acquireClient(r.Context(), r, prio)
+	if err != nil {
+		if errors.Is(err, errSaturated) {
+			logWarn("router", "Rejecting %s priority request from %s: client is at its concurrency cap", prio, clientIP(r))
+			writeSaturated(w, body, err)
+		}
+		return
+	}
+	defer releaseClient()
+	if proxyLimiter != nil {
+		if err := proxyLimiter.acquire(r.Context(), prio); err != nil {
+			if errors.Is(err, errSaturated) {
+				logWarn("router", "Rejecting %s priority request: proxy is saturated", prio)
+				writeSaturated(w, body, err)
+			}
+			return
+		}
+		defer proxyLimiter.release()
+	}
+
 	// Determine if this is a batch request (array) or single request
 	isBatchRequest := false
 	var rawMessage json.RawMessage
@@ -225,176 +828,588 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
 
 	if isBatchRequest {
 		// Handle batch request
-		handleBatchRequest(w, body)
+		handleBatchRequest(w, r, body)
 	} else {
 		// Handle single request
-		handleSingleRequest(w, body)
+		handleSingleRequest(w, r, body)
 	}
 }
 
 // handleSingleRequest processes a single JSON-RPC request.
 // It extracts the method, determines the target URL, and forwards the request.
+// The upstream call is bound to the incoming request's context, so it is cancelled
+// as soon as the client goes away.
 //
 // Parameters:
 //   - w: The HTTP response writer
+//   - r: The incoming HTTP request
 //   - body: The raw request body bytes
-func handleSingleRequest(w http.ResponseWriter, body []byte) {
+func handleSingleRequest(w http.ResponseWriter, r *http.Request, body []byte) {
 	// Parse the JSON-RPC request
 	var rpcRequest JSONRPCRequest
 	if err := json.Unmarshal(body, &rpcRequest); err != nil {
 		http.Error(w, "Invalid JSON-RPC request", http.StatusBadRequest)
 		return
 	}
+	rpcRequest.client = clientFromRequest(r)
 
-	// Determine target URL based on the method
-	targetURL, exists := methodToURL[rpcRequest.Method]
-	if !exists {
-		targetURL = config.DefaultURL
+	// Answer locally handled methods without contacting an upstream
+	start := time.Now()
+	if localResp, ok := handleLocalCall(r.Context(), r, &rpcRequest); ok {
+		logInfo("router", "Answered method '%s' locally", rpcRequest.Method)
+		noteRoute(r.Context(), rpcRequest.Method, "local", Upstream{Name: "local"}, "")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(localResp)
+		observeLocalCall(r.Context(), time.Since(start))
+		return
 	}
 
-	// Get display name for logging
-	displayName := config.DefaultName
-	if dn, exists := methodToName[rpcRequest.Method]; exists {
-		displayName = dn
+	// Determine target URL based on the routing rules
+	upstream, forced, err := routeCall(r.Context(), &rpcRequest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Routing error: %v", err), http.StatusInternalServerError)
+		return
 	}
-	if displayName == "" {
-		displayName = "default"
+	route := upstream.Route
+	if stubResp, ok := stubResponse(upstream, &rpcRequest); ok {
+		logCall(route, rpcRequest.Method, levelInfo, "Answered method '%s' with a stub%s", rpcRequest.Method, routeLogSuffix(route))
+		noteRoute(r.Context(), rpcRequest.Method, routeName(route), Upstream{Name: "stub"}, "")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(stubResp)
+		observeCall(r.Context(), route, "stub", time.Since(start))
+		return
+	}
+	if write, ok := writeTarget(&rpcRequest); ok && !forced {
+		upstream = write
+	}
+	if private, ok := privateTxTarget(r, rpcRequest.Method); ok && !forced {
+		upstream = private
+	}
+	targetURL, displayName := upstream.URL, upstream.Name
+
+	// Apply the route's param rules and method rewriting
+	body, err = rewriteBody(r.Context(), upstream, &rpcRequest, body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Routing error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	mirror := mirrorFor(upstream)
+
+	// Let extension hooks override the upstream
+	overrideURL, err := runPreRouteHooks(rpcRequest.Method, body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Routing error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if overrideURL != "" && !forced {
+		targetURL = overrideURL
+		displayName = overrideURL
+	}
+
+	// Serve fresh cached results without contacting the upstream
+	var cacheHead uint64
+	var cacheStatus string
+	cache := responseCache
+	if cache != nil {
+		cacheStatus = cacheMiss
+	}
+	if cache != nil && cache.cacheable(rpcRequest.Method) {
+		var cached []byte
+		if !cacheBypassed(r) {
+			cached, cacheStatus = cache.lookup(targetURL, &rpcRequest)
+		}
+		if cacheStatus == cacheHit {
+			noteRoute(r.Context(), rpcRequest.Method, ruleName(route, forced), Upstream{Name: displayName, URL: targetURL}, cacheHit)
+			w.Header().Set("X-Cache", cacheHit)
+			if hasResponseTransforms() {
+				cached = applyResponseTransforms(&rpcRequest, http.StatusOK, cached)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(cached)
+			observeCall(r.Context(), route, "cache", time.Since(start))
+			return
+		}
+		cacheHead, _ = trackedHead(targetURL)
+	} else {
+		cache = nil
 	}
 
-	log.Printf("Proxying method '%s' to %s", rpcRequest.Method, displayName)
+	logCall(route, rpcRequest.Method, levelInfo, "Proxying method '%s' to %s%s", rpcRequest.Method, displayName, routeLogSuffix(route))
+	logPayload(route, rpcRequest.Method, "Request", body)
+	noteUpstream(r.Context(), displayName)
+	noteRoute(r.Context(), rpcRequest.Method, ruleName(route, forced), Upstream{Name: displayName, URL: targetURL}, cacheStatus)
+
+	// Keep a compressed response compressed for the client when nothing inspects it
+	payloads := capturesPayloads(route, rpcRequest.Method)
+	sizeLimit, sizePattern := responseLimit(rpcRequest.Method)
+	passthrough := cache == nil && !payloads && (mirror == nil || !mirror.Diff) && !hasResponseTransforms() && sizeLimit == 0 && acceptsGzip(r)
 
 	// Forward the request to the target URL
-	resp, err := forwardRequest(targetURL, body)
+	resp, err := forwardSingle(withCompressedPassthrough(withRetryBackoff(r.Context(), route), passthrough), Upstream{Name: displayName, URL: targetURL}, rpcRequest.Method, outboundHeadersFor(r, upstream), body)
+	outcome := forwardOutcome(resp, err)
+	defer func() { observeCall(r.Context(), route, outcome, time.Since(start)) }()
 	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			logCall(route, rpcRequest.Method, levelInfo, "Client disconnected, cancelled method '%s' to %s", rpcRequest.Method, displayName)
+			return
+		}
+		if errors.Is(err, errSaturated) {
+			logCall(route, rpcRequest.Method, levelWarn, "Rejecting method '%s': %s is saturated", rpcRequest.Method, displayName)
+			writeSaturated(w, body, err)
+			return
+		}
+		if cache != nil && cache.serveStale(w, r, targetURL, &rpcRequest) {
+			logCall(route, rpcRequest.Method, levelWarn, "Serving a stale result of method '%s': %s failed: %v", rpcRequest.Method, displayName, err)
+			outcome = "stale"
+			return
+		}
+		if total := upstreamTimeoutsFor(targetURL).Total; total > 0 && errors.Is(err, context.DeadlineExceeded) {
+			logCall(route, rpcRequest.Method, levelWarn, "Method '%s' to %s timed out after %v", rpcRequest.Method, displayName, total)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(timeoutResponses([]json.RawMessage{body}, displayName, total)[0])
+			return
+		}
 		http.Error(w, fmt.Sprintf("Proxy error: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
+	if cache != nil && resp.StatusCode >= http.StatusInternalServerError && cache.serveStale(w, r, targetURL, &rpcRequest) {
+		logCall(route, rpcRequest.Method, levelWarn, "Serving a stale result of method '%s': %s answered HTTP %d", rpcRequest.Method, displayName, resp.StatusCode)
+		observeUpstreamErrors(Upstream{Name: displayName, URL: targetURL}, resp.StatusCode)
+		outcome = "stale"
+		return
+	}
 
-	// Copy response headers
-	for k, v := range resp.Header {
-		for _, val := range v {
-			w.Header().Add(k, val)
+	// Read a size-limited response in full before anything is sent
+	if sizeLimit > 0 {
+		limited, err := readLimited(resp.Body, resp.ContentLength, sizeLimit)
+		if errors.Is(err, errResponseTooLarge) {
+			logCall(route, rpcRequest.Method, levelWarn, "Response of method '%s' from %s exceeds %d bytes", rpcRequest.Method, displayName, sizeLimit)
+			responseTooLargeTotal.inc(upstreamLabel(Upstream{Name: displayName, URL: targetURL}), sizePattern)
+			outcome = "too_large"
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(tooLargeResponses([]json.RawMessage{body}, rpcRequest.Method+" response", displayName, sizeLimit)[0])
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Proxy error: %v", err), http.StatusBadGateway)
+			return
 		}
+		resp.Body = io.NopCloser(bytes.NewReader(limited))
 	}
 
-	// Set response status code
-	w.WriteHeader(resp.StatusCode)
+	// Copy the upstream headers passed to clients
+	copyUpstreamHeaders(w.Header(), resp.Header)
+	setUpstreamHeaders(w.Header(), r, []Upstream{{Name: displayName, URL: targetURL}})
+	if cacheStatus != "" {
+		w.Header().Set("X-Cache", cacheStatus)
+	}
+
+	// Stream the body untouched unless a response transform needs to see it
+	if !hasResponseTransforms() {
+		var primary bytes.Buffer
+		var src io.Reader = resp.Body
+		if (mirror != nil && mirror.Diff) || payloads || cache != nil {
+			src = io.TeeReader(resp.Body, &primary)
+		}
+		prefix := &prefixWriter{limit: errorPrefixSize}
+		src = io.TeeReader(src, prefix)
+		w.WriteHeader(resp.StatusCode)
+		_, err := io.Copy(w, src)
+		if gzipEncoded(resp.Header) {
+			prefix.buf = decompressPrefix(prefix.buf)
+		}
+		observeUpstreamErrors(Upstream{Name: displayName, URL: targetURL}, resp.StatusCode, prefix.buf)
+		if err != nil {
+			logError("router", "Error copying response: %v", err)
+			return
+		}
+		if payloads {
+			logPayload(route, rpcRequest.Method, "Response", primary.Bytes())
+		}
+		if cache != nil && resp.StatusCode == http.StatusOK {
+			cache.store(targetURL, &rpcRequest, cacheHead, primary.Bytes())
+		}
+		if mirror != nil {
+			mirrorCall(mirror, rpcRequest.Method, body, primary.Bytes())
+		}
+		return
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Proxy error: %v", err), http.StatusBadGateway)
+		return
+	}
+	observeUpstreamErrors(Upstream{Name: displayName, URL: targetURL}, resp.StatusCode, respBody)
+	if mirror != nil {
+		defer mirrorCall(mirror, rpcRequest.Method, body, respBody)
+	}
+	if cache != nil && resp.StatusCode == http.StatusOK {
+		cache.store(targetURL, &rpcRequest, cacheHead, respBody)
+	}
+	respBody = applyResponseTransforms(&rpcRequest, resp.StatusCode, respBody)
+	logPayload(route, rpcRequest.Method, "Response", respBody)
 
-	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.Printf("Error copying response: %v", err)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := w.Write(respBody); err != nil {
+		logError("router", "Error writing response: %v", err)
 	}
 }
 
 // handleBatchRequest processes a batch of JSON-RPC requests.
 // It parses each request in the batch, routes them to appropriate targets,
-// and combines the responses.
+// and combines the responses. If the client disconnects, in-flight upstream
+// calls are cancelled and the remaining groups are not sent.
 //
 // Parameters:
 //   - w: The HTTP response writer
+//   - r: The incoming HTTP request
 //   - body: The raw request body bytes containing an array of requests
-func handleBatchRequest(w http.ResponseWriter, body []byte) {
-	// Parse the batch of requests
-	var batchRequests []JSONRPCRequest
-	if err := json.Unmarshal(body, &batchRequests); err != nil {
+func handleBatchRequest(w http.ResponseWriter, r *http.Request, body []byte) {
+	ctx := r.Context()
+
+	// Parse the batch of requests, keeping each call as sent so that fields unknown to
+	// JSONRPCRequest are forwarded
+	var rawCalls []json.RawMessage
+	if err := json.Unmarshal(body, &rawCalls); err != nil {
 		http.Error(w, "Invalid JSON-RPC batch request", http.StatusBadRequest)
 		return
 	}
+	client := clientFromRequest(r)
+	batchRequests := make([]JSONRPCRequest, len(rawCalls))
+	for i, raw := range rawCalls {
+		if err := json.Unmarshal(raw, &batchRequests[i]); err != nil {
+			http.Error(w, "Invalid JSON-RPC batch request", http.StatusBadRequest)
+			return
+		}
+		batchRequests[i].client = client
+	}
 
 	// Group requests by target URL for efficiency
 	requestsByURL := make(map[string][]json.RawMessage)
-	methodByID := make(map[interface{}]string) // To log methods by ID
-	nameByURL := make(map[string]string)       // For logging URL names
+	callByID := make(map[interface{}]*JSONRPCRequest) // To match responses to calls
+	routeByID := make(map[interface{}]*Route)         // Route of each call, for payload logging
+	nameByURL := make(map[string]string)              // For logging URL names
+	headersByURL := make(map[string]*outboundHeaders) // Header rules of each group's first call
+	backoffRoutes := make(map[string]*Route)          // Route of each group's first call, for its retry backoff
+	var mirrored []mirroredCall                       // Calls to duplicate to a mirror
+	primaryByID := make(map[interface{}][]byte)       // Untransformed responses of mirrored calls
+	var served []Upstream                             // Upstreams that answered a group
+	routesByURL := make(map[string][]*Route)          // Route of each call in a group, for metrics
+	methodsByURL := make(map[string][]string)         // Method of each call in a group, for response size limits
+	allResponses := make([]json.RawMessage, 0)
+	var batchCacheStatus string // X-Cache status of the calls
+	if responseCache != nil {
+		batchCacheStatus = cacheMiss
+	}
 
 	// First pass: unmarshall to get method and ID for grouping
-	for _, req := range batchRequests {
-		// Determine target URL based on the method
-		targetURL, exists := methodToURL[req.Method]
-		if !exists {
-			targetURL = config.DefaultURL
+	for i, req := range batchRequests {
+		// Answer locally handled methods without contacting an upstream
+		start := time.Now()
+		if localResp, ok := handleLocalCall(ctx, r, &req); ok {
+			logInfo("router", "Batch request: method '%s' (ID: %v) answered locally", req.Method, req.ID)
+			noteRoute(ctx, req.Method, "local", Upstream{Name: "local"}, "")
+			allResponses = append(allResponses, localResp)
+			observeLocalCall(ctx, time.Since(start))
+			continue
 		}
 
-		// Get display name for logging
-		displayName := config.DefaultName
-		if dn, exists := methodToName[req.Method]; exists {
-			displayName = dn
+		// Determine target URL based on the routing rules
+		upstream, forced, err := routeCall(ctx, &req)
+		if err != nil {
+			logError("router", "Error routing method '%s' (ID: %v): %v", req.Method, req.ID, err)
+			continue
 		}
-		if displayName == "" {
-			displayName = "default"
+		route := upstream.Route
+		if stubResp, ok := stubResponse(upstream, &req); ok {
+			logCall(route, req.Method, levelInfo, "Batch request: method '%s' (ID: %v) answered with a stub%s", req.Method, req.ID, routeLogSuffix(route))
+			noteRoute(ctx, req.Method, routeName(route), Upstream{Name: "stub"}, "")
+			allResponses = append(allResponses, stubResp)
+			observeCall(ctx, route, "stub", time.Since(start))
+			continue
 		}
-		nameByURL[targetURL] = displayName
+		if write, ok := writeTarget(&req); ok && !forced {
+			upstream = write
+		}
+		if private, ok := privateTxTarget(r, req.Method); ok && !forced {
+			upstream = private
+		}
+		targetURL, displayName := upstream.URL, upstream.Name
 
-		// Convert the request back to raw JSON
-		rawRequest, err := json.Marshal(req)
+		// Apply the route's param rules and method rewriting
+		rawRequest, err := rewriteBody(ctx, upstream, &req, rawCalls[i])
 		if err != nil {
-			log.Printf("Error marshaling request: %v", err)
+			logError("router", "Error routing method '%s' (ID: %v): %v", req.Method, req.ID, err)
 			continue
 		}
+		if mirror := mirrorFor(upstream); mirror != nil {
+			mirrored = append(mirrored, mirroredCall{mirror: mirror, method: req.Method, id: req.ID, body: rawRequest})
+		}
+
+		// Let extension hooks override the upstream
+		overrideURL, err := runPreRouteHooks(req.Method, rawRequest)
+		if err != nil {
+			logError("router", "Error routing method '%s' (ID: %v): %v", req.Method, req.ID, err)
+			continue
+		}
+		if overrideURL != "" && !forced {
+			targetURL = overrideURL
+			displayName = overrideURL
+		}
+		nameByURL[targetURL] = displayName
+		if _, ok := headersByURL[targetURL]; !ok {
+			headersByURL[targetURL] = outboundHeadersFor(r, upstream)
+			backoffRoutes[targetURL] = route
+		}
 
 		requestsByURL[targetURL] = append(requestsByURL[targetURL], rawRequest)
+		routesByURL[targetURL] = append(routesByURL[targetURL], route)
+		methodsByURL[targetURL] = append(methodsByURL[targetURL], req.Method)
 
-		// Store method by ID for logging
-		methodByID[req.ID] = req.Method
+		// Store the call by ID for response transforms and payload logging
+		callByID[req.ID] = &req
+		routeByID[req.ID] = route
 
-		log.Printf("Batch request: method '%s' (ID: %v) to %s", req.Method, req.ID, displayName)
+		logCall(route, req.Method, levelInfo, "Batch request: method '%s' (ID: %v) to %s%s", req.Method, req.ID, displayName, routeLogSuffix(route))
+		logPayload(route, req.Method, "Request", rawRequest)
+		noteUpstream(ctx, displayName)
+		noteRoute(ctx, req.Method, ruleName(route, forced), Upstream{Name: displayName, URL: targetURL}, batchCacheStatus)
 	}
 
-	// Process each group of requests to their target URL
-	allResponses := make([]json.RawMessage, 0)
-
-	for targetURL, requests := range requestsByURL {
-		// Create a JSON array for this batch of requests
-		batchJSON, err := json.Marshal(requests)
-		if err != nil {
-			log.Printf("Error creating batch request: %v", err)
-			continue
+	// Process each group of requests to their target URL, split to the upstream's
+	// batch size limit. Groups and their batches are sent as concurrently as the batch
+	// concurrency settings allow; responses are merged in a stable order.
+	type batchChunk struct {
+		targetURL  string
+		start, end int
+		responses  []json.RawMessage
+	}
+	var chunks []*batchChunk
+	for _, targetURL := range sortedKeys(requestsByURL) {
+		group := requestsByURL[targetURL]
+		limit := maxBatchSize(targetURL, nameByURL[targetURL])
+		if limit < len(group) {
+			logDebug("router", "Splitting %d calls to %s into batches of %d", len(group), nameByURL[targetURL], limit)
+		}
+		for offset := 0; offset < len(group); offset += limit {
+			chunks = append(chunks, &batchChunk{targetURL: targetURL, start: offset, end: min(offset+limit, len(group))})
 		}
+	}
 
-		// Unwrap the batch to get array of raw requests
-		var rawBatch []json.RawMessage
-		if err := json.Unmarshal(batchJSON, &rawBatch); err != nil {
-			log.Printf("Error unwrapping batch: %v", err)
-			continue
+	// Start streaming large batches, with the calls answered by the proxy
+	var targeted []Upstream
+	for _, targetURL := range sortedKeys(requestsByURL) {
+		targeted = append(targeted, Upstream{Name: nameByURL[targetURL], URL: targetURL})
+	}
+	stream := startBatchStream(w, r, len(rawCalls), targeted)
+	if stream != nil {
+		for _, response := range allResponses {
+			stream.write(response)
 		}
+		stream.flush()
+	}
+
+	var mu sync.Mutex // Protects primaryByID and answeredURLs
+	answeredURLs := make(map[string]bool)
+	send := func(chunk *batchChunk) {
+		targetURL := chunk.targetURL
+		requests, routes := requestsByURL[targetURL][chunk.start:chunk.end], routesByURL[targetURL][chunk.start:chunk.end]
+		sizeLimit := batchResponseLimit(methodsByURL[targetURL][chunk.start:chunk.end])
 
 		// Convert each json.RawMessage to []byte for joining
-		byteBatch := make([][]byte, len(rawBatch))
-		for i, raw := range rawBatch {
+		byteBatch := make([][]byte, len(requests))
+		for i, raw := range requests {
 			byteBatch[i] = []byte(raw)
 		}
 
 		// Create a proper JSON array for the batch
 		batchBody := []byte("[" + string(bytes.Join(byteBatch, []byte(","))) + "]")
 
-		// Forward this batch to the target URL
-		resp, err := forwardRequest(targetURL, batchBody)
+		// Forward this batch to the target URL, within the upstream's batch timeout
+		chunkCtx := ctx
+		timeout := batchTimeout(targetURL, nameByURL[targetURL])
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			chunkCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		resp, err := forwardRequest(withRetryBackoff(withOutboundHeaders(chunkCtx, headersByURL[targetURL]), backoffRoutes[targetURL]), targetURL, batchBody)
+		var respBody []byte
+		if err == nil && stream == nil {
+			// Read the response body
+			respBody, err = readLimited(resp.Body, resp.ContentLength, sizeLimit)
+			resp.Body.Close()
+		}
+		outcome := forwardOutcome(resp, err)
+		observe := func() {
+			for _, route := range routes {
+				observeCall(ctx, route, outcome, time.Since(start))
+			}
+		}
+		if stream != nil {
+			// The responses are written as they are read, then sent on
+			defer stream.flush()
+			defer observe()
+		} else {
+			observe()
+		}
+		expired := func() time.Duration {
+			if total := upstreamTimeoutsFor(targetURL).Total; total > 0 && (timeout == 0 || total < timeout) {
+				return total // The upstream's total timeout expired first
+			}
+			return timeout
+		}
+		if err != nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			// Answer the batch's calls with the timeout error; the rest of the client
+			// batch is served
+			timeout = expired()
+			logWarn("router", "Batch of %d calls to %s timed out after %v", len(requests), nameByURL[targetURL], timeout)
+			chunk.responses = timeoutResponses(requests, nameByURL[targetURL], timeout)
+			if stream != nil {
+				for _, response := range chunk.responses {
+					stream.write(response)
+				}
+			}
+			return
+		}
+		if errors.Is(err, errResponseTooLarge) {
+			logWarn("router", "Response of a batch of %d calls from %s exceeds %d bytes", len(requests), nameByURL[targetURL], sizeLimit)
+			responseTooLargeTotal.inc(upstreamLabel(Upstream{Name: nameByURL[targetURL], URL: targetURL}), "batch")
+			chunk.responses = tooLargeResponses(requests, "batch response", nameByURL[targetURL], sizeLimit)
+			return
+		}
 		if err != nil {
-			log.Printf("Error forwarding batch to %s: %v", nameByURL[targetURL], err)
-			continue
+			logError("router", "Error forwarding batch to %s: %v", nameByURL[targetURL], err)
+			if errors.Is(err, errSaturated) {
+				// Answer the group's calls with the saturation error
+				for _, raw := range requests {
+					data, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: responseID(raw), Error: saturatedError(err)})
+					chunk.responses = append(chunk.responses, data)
+					if stream != nil {
+						stream.write(data)
+					}
+				}
+			}
+			return
 		}
+		upstream := Upstream{Name: nameByURL[targetURL], URL: targetURL}
+		observeUpstreamErrors(upstream, resp.StatusCode)
 
-		// Read the response body
-		respBody, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error reading response: %v", err)
-			continue
+		// Pass each call's response through mirror diffing, response transforms, and
+		// payload logging
+		deliver := func(response json.RawMessage) {
+			observeUpstreamErrors(upstream, 0, response)
+			id := responseID(response)
+			call, ok := callByID[id]
+			if len(mirrored) > 0 {
+				// Keep the untransformed responses of mirrored calls for diffing
+				mu.Lock()
+				primaryByID[id] = response
+				mu.Unlock()
+			}
+			if hasResponseTransforms() {
+				if !ok {
+					call = &JSONRPCRequest{ID: id}
+				}
+				response = applyResponseTransforms(call, resp.StatusCode, response)
+			}
+			if ok {
+				logPayload(routeByID[call.ID], call.Method, "Response", response)
+			}
+			if stream != nil {
+				stream.write(response)
+			} else {
+				chunk.responses = append(chunk.responses, response)
+			}
+		}
+
+		// Stream the response's calls as they are decoded
+		if stream != nil {
+			defer resp.Body.Close()
+			mu.Lock()
+			answeredURLs[targetURL] = true
+			mu.Unlock()
+			delivered := make(map[interface{}]bool)
+			err := decodeBatchResponse(limitReader(resp.Body, sizeLimit), func(response json.RawMessage) {
+				delivered[responseID(response)] = true
+				deliver(response)
+			})
+			if errors.Is(err, errResponseTooLarge) {
+				// Answer the calls not sent yet
+				logWarn("router", "Response of a batch of %d calls from %s exceeds %d bytes", len(requests), nameByURL[targetURL], sizeLimit)
+				responseTooLargeTotal.inc(upstreamLabel(upstream), "batch")
+				for i, response := range tooLargeResponses(requests, "batch response", nameByURL[targetURL], sizeLimit) {
+					if !delivered[responseID(requests[i])] {
+						stream.write(response)
+					}
+				}
+			} else if err != nil && (errors.Is(err, context.DeadlineExceeded) || chunkCtx.Err() != nil) && ctx.Err() == nil {
+				// Answer the calls not sent before the deadline with the timeout error
+				timeout = expired()
+				logWarn("router", "Batch of %d calls to %s timed out after %v while streaming its response", len(requests), nameByURL[targetURL], timeout)
+				for i, response := range timeoutResponses(requests, nameByURL[targetURL], timeout) {
+					if !delivered[responseID(requests[i])] {
+						stream.write(response)
+					}
+				}
+			} else if err != nil {
+				logError("router", "Error parsing batch response: %v", err)
+			}
+			return
 		}
 
 		// Parse the response to get the array of results
 		var responses []json.RawMessage
 		if err := json.Unmarshal(respBody, &responses); err != nil {
-			log.Printf("Error parsing batch response: %v", err)
-			continue
+			logError("router", "Error parsing batch response: %v", err)
+			return
 		}
+		mu.Lock()
+		answeredURLs[targetURL] = true
+		mu.Unlock()
+		for _, response := range responses {
+			deliver(response)
+		}
+	}
+	sends := make(map[string][]func())
+	for _, chunk := range chunks {
+		sends[chunk.targetURL] = append(sends[chunk.targetURL], func() { send(chunk) })
+	}
+	fanOutBatches(ctx, sends, nameByURL)
 
-		// Add these responses to the combined result
-		allResponses = append(allResponses, responses...)
+	// Stop once the client is gone; the remaining batches were not sent
+	if ctx.Err() != nil {
+		logInfo("router", "Client disconnected, abandoning remaining batch groups")
+		return
+	}
+	if stream != nil {
+		stream.finish()
+		for _, mc := range mirrored {
+			mirrorCall(mc.mirror, mc.method, mc.body, primaryByID[mc.id])
+		}
+		return
+	}
+
+	// Add the responses to the combined result
+	for _, chunk := range chunks {
+		allResponses = append(allResponses, chunk.responses...)
+	}
+	for _, targetURL := range sortedKeys(answeredURLs) {
+		served = append(served, Upstream{Name: nameByURL[targetURL], URL: targetURL})
 	}
 
 	// Send the combined batch response
 	w.Header().Set("Content-Type", "application/json")
+	setUpstreamHeaders(w.Header(), r, served)
+	if responseCache != nil {
+		// Batches are not cached
+		w.Header().Set("X-Cache", cacheMiss)
+	}
 	if len(allResponses) == 0 {
 		// If no responses (all failed), return an empty array
 		w.Write([]byte("[]"))
@@ -409,6 +1424,11 @@ func handleBatchRequest(w http.ResponseWriter, body []byte) {
 	}
 
 	w.Write(responseBody)
+
+	// Duplicate mirrored calls once the client has its response
+	for _, mc := range mirrored {
+		mirrorCall(mc.mirror, mc.method, mc.body, primaryByID[mc.id])
+	}
 }
 
 // handleHealth responds to health check requests with a 200 OK status.
@@ -423,18 +1443,64 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
+// peekBody reads a request's body and replaces it so handlers can read it again.
+//
+// Returns:
+//   - []byte: The body
+//   - bool: False if the body could not be read
+func peekBody(r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err == nil
+}
+
+// isBatch reports whether a request body is a batch.
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// parseCalls decodes the calls of a single or batch request body, skipping calls that
+// do not parse.
+func parseCalls(body []byte) []JSONRPCRequest {
+	if !isBatch(body) {
+		var req JSONRPCRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil
+		}
+		return []JSONRPCRequest{req}
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return nil
+	}
+	calls := make([]JSONRPCRequest, 0, len(raws))
+	for _, raw := range raws {
+		var req JSONRPCRequest
+		if json.Unmarshal(raw, &req) == nil {
+			calls = append(calls, req)
+		}
+	}
+	return calls
+}
+
 // forwardRequest sends the JSON-RPC request to the target URL and returns the response.
-// It sets appropriate headers for JSON-RPC communication.
+// It sets appropriate headers for JSON-RPC communication and sends the request through
+// the transport configured for the target URL.
+//
+// The request is cancelled when ctx is done.
 //
 // Parameters:
+//   - ctx: The context bounding the upstream call (normally the client request's context)
 //   - targetURL: The destination URL to forward the request to
 //   - body: The raw request body bytes
 //
 // Returns:
 //   - *http.Response: The response from the target server
 //   - error: An error if the request fails
-func forwardRequest(targetURL string, body []byte) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(body))
+func forwardRequest(ctx context.Context, targetURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -442,8 +1508,69 @@ func forwardRequest(targetURL string, body []byte) (*http.Response, error) {
 	// Set common headers for JSON-RPC
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	applyOutboundHeaders(req)
+	requestCompression(req)
 
-	// Send the request
-	client := &http.Client{}
-	return client.Do(req)
+	if err := runPreForwardHooks(req); err != nil {
+		return nil, err
+	}
+
+	// Send the request through the upstream's transport
+	client, err := clientForURL(targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for capacity on the upstream; the slot is held until the body is closed
+	release, err := acquireUpstream(ctx, targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep within the upstream's quota
+	paced, err := paceUpstream(ctx, targetURL)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	slot := release
+	release = func() { slot(); paced() }
+
+	// Charge the upstream's quota for the first attempt; retries are charged as sent
+	chargeUpstreamQuota(targetURL, body)
+
+	// Bound the request and the reading of its body by the upstream's total timeout
+	totalCtx, cancel := withTotalTimeout(ctx, targetURL)
+	totalCtx, connDone := traceConnections(totalCtx, targetURL)
+	req = req.WithContext(totalCtx)
+	var resp *http.Response
+	if r := upstreamRetries; r != nil {
+		// Each retry is paced and charged to the upstream's quota like the first attempt
+		resp, err = r.do(totalCtx, client, req, func() error {
+			if err := paceRetry(totalCtx, targetURL); err != nil {
+				return err
+			}
+			chargeUpstreamQuota(targetURL, body)
+			return nil
+		})
+	} else {
+		resp, err = client.Do(req)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) || err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) {
+		upstreamCongested(targetURL)
+	}
+	if err != nil {
+		connDone()
+		cancel()
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { connDone(); cancel(); release() }}
+	decodeResponse(ctx, targetURL, resp)
+
+	if err := runPostResponseHooks(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -417,7 +419,7 @@ func TestForwardRequest(t *testing.T) {
 	defer server.Close()
 
 	// Test
-	resp, err := forwardRequest(server.URL, []byte(`{"jsonrpc":"2.0","method":"test_method","params":[],"id":1}`))
+	resp, err := forwardRequest(context.Background(), server.URL, []byte(`{"jsonrpc":"2.0","method":"test_method","params":[],"id":1}`))
 	if err != nil {
 		t.Fatalf("Failed to forward request: %v", err)
 	}
@@ -440,3 +442,45 @@ func TestForwardRequest(t *testing.T) {
 		t.Errorf("Expected result test_response, got %s", result.Result)
 	}
 }
+
+// TestClientDisconnectCancelsUpstream tests that the upstream call is cancelled when the client goes away
+func TestClientDisconnectCancelsUpstream(t *testing.T) {
+	// Setup mock server that blocks until its request is cancelled
+	arrived := make(chan struct{})
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		close(arrived)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	config = Config{DefaultURL: server.URL}
+	buildMethodURLMap()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"slow","params":[],"id":1}`))).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	// Test
+	done := make(chan struct{})
+	go func() {
+		handleProxy(w, req)
+		close(done)
+	}()
+	<-arrived
+	cancel()
+
+	// Verify
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upstream request was not cancelled after client disconnect")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler did not return after client disconnect")
+	}
+}
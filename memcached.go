@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memcached cache backend
+//
+// The response cache (see cache.go) lives in each proxy's memory by default, so every
+// replica fetches the same results. Shops running memcached can share the cache
+// between replicas instead:
+//
+//	cache:
+//	  backend: memcached
+//	  memcached:
+//	    servers: [memcached-0:11211, memcached-1:11211, memcached-2:11211]
+//	    timeout: 500ms             # default
+//	    key_prefix: jsonrpc-proxy: # default
+//
+// Keys are spread over the servers by consistent hashing (a ring of 160 points per
+// server, as in ketama), so adding or removing a server only moves the keys of its
+// neighbours on the ring. Head-scoped entries expire after ttl, and immutable ones stay
+// until memcached evicts them. Memcached is best effort: a server that cannot be
+// reached or answers too slowly counts as a miss, and a failed set is dropped. The
+// persistent cache is not available with memcached, which outlives proxy restarts.
+
+// MemcachedConfig configures the memcached cache backend.
+type MemcachedConfig struct {
+	Servers   []string      `yaml:"servers"`    // Server addresses as host:port
+	Timeout   time.Duration `yaml:"timeout"`    // Timeout of a get or set (default: 500ms)
+	KeyPrefix string        `yaml:"key_prefix"` // Prefix of the keys, to share servers with other applications (default: jsonrpc-proxy:)
+}
+
+// memcachedPointsPerServer is the number of points each server has on the hash ring.
+const memcachedPointsPerServer = 160
+
+// memcachedIdleConns is the number of idle connections kept per server.
+const memcachedIdleConns = 8
+
+// memcachedClient stores cache entries on memcached servers.
+type memcachedClient struct {
+	prefix  string
+	timeout time.Duration
+	ring    []ringPoint // Sorted by hash
+	servers map[string]*memcachedServer
+}
+
+// ringPoint is a point of a server on the hash ring.
+type ringPoint struct {
+	hash   uint32
+	server string
+}
+
+// memcachedServer is a server with its idle connections.
+type memcachedServer struct {
+	addr string
+	idle chan *memcachedConn
+
+	mu      sync.Mutex
+	failing bool // Whether the last operation failed, so that failures are logged once
+}
+
+// memcachedConn is a connection to a server.
+type memcachedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// memcachedEntry is the encoding of a cache entry in memcached.
+type memcachedEntry struct {
+	Result    json.RawMessage `json:"r,omitempty"`
+	Deflated  []byte          `json:"z,omitempty"` // Compressed result (see cachecompress.go)
+	Head      uint64          `json:"h,omitempty"`
+	Immutable bool            `json:"i,omitempty"`
+	Stored    int64           `json:"t"` // Unix nanoseconds
+}
+
+// newMemcachedClient creates a client for the configured servers.
+//
+// Parameters:
+//   - mc: The memcached configuration
+//
+// Returns:
+//   - *memcachedClient: The client
+//   - error: An error if no servers are configured or an address is invalid
+func newMemcachedClient(mc *MemcachedConfig) (*memcachedClient, error) {
+	if mc == nil || len(mc.Servers) == 0 {
+		return nil, fmt.Errorf("memcached.servers is required for the memcached backend")
+	}
+	c := &memcachedClient{prefix: "jsonrpc-proxy:", timeout: 500 * time.Millisecond, servers: make(map[string]*memcachedServer)}
+	if mc.KeyPrefix != "" {
+		c.prefix = mc.KeyPrefix
+	}
+	if mc.Timeout > 0 {
+		c.timeout = mc.Timeout
+	}
+	if strings.ContainsAny(c.prefix, " \t\r\n") || len(c.prefix) > 250-2*sha256.Size {
+		return nil, fmt.Errorf("memcached.key_prefix must be at most %d characters without whitespace", 250-2*sha256.Size)
+	}
+	for _, addr := range mc.Servers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("memcached.servers: %w", err)
+		}
+		if _, dup := c.servers[addr]; dup {
+			return nil, fmt.Errorf("memcached.servers: %s is listed twice", addr)
+		}
+		c.servers[addr] = &memcachedServer{addr: addr, idle: make(chan *memcachedConn, memcachedIdleConns)}
+		registerDependency("memcached "+addr, "cache", "cache lookups miss and results are not shared")
+		for i := 0; i < memcachedPointsPerServer/4; i++ {
+			// Each SHA-1 digest yields four points, as ketama does with MD5
+			digest := sha1.Sum([]byte(addr + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				c.ring = append(c.ring, ringPoint{hash: binary.LittleEndian.Uint32(digest[j*4:]), server: addr})
+			}
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i].hash < c.ring[j].hash })
+	return c, nil
+}
+
+// serverFor returns the server owning a key: the first point on the ring at or after
+// the key's hash.
+func (c *memcachedClient) serverFor(key string) *memcachedServer {
+	digest := sha1.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= hash })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.servers[c.ring[i].server]
+}
+
+// memcachedKey turns a cache key into a memcached key, which is limited to 250
+// characters without whitespace.
+func (c *memcachedClient) memcachedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.prefix + hex.EncodeToString(sum[:])
+}
+
+// get fetches an entry.
+//
+// Returns:
+//   - *cacheEntry: The entry
+//   - bool: Whether the entry was found
+func (c *memcachedClient) get(key string) (*cacheEntry, bool) {
+	mkey := c.memcachedKey(key)
+	server := c.serverFor(mkey)
+	var value []byte
+	err := server.do(c.timeout, "get "+mkey+"\r\n", func(r *bufio.Reader) error {
+		line, err := readMemcachedLine(r)
+		if err != nil || line == "END" {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+		value = make([]byte, size+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		value = value[:size]
+		if line, err = readMemcachedLine(r); err == nil && line != "END" {
+			err = fmt.Errorf("unexpected reply %q", line)
+		}
+		return err
+	})
+	if err != nil || value == nil {
+		return nil, false
+	}
+	var me memcachedEntry
+	if json.Unmarshal(value, &me) != nil {
+		return nil, false
+	}
+	return &cacheEntry{result: me.Result, deflated: me.Deflated, head: me.Head, immutable: me.Immutable, stored: time.Unix(0, me.Stored)}, true
+}
+
+// set stores an entry. Immutable entries do not expire; others expire after ttl.
+func (c *memcachedClient) set(key string, entry *cacheEntry, ttl time.Duration) {
+	value, err := json.Marshal(memcachedEntry{Result: entry.result, Deflated: entry.deflated, Head: entry.head, Immutable: entry.immutable, Stored: entry.stored.UnixNano()})
+	if err != nil {
+		return
+	}
+	expiry := 0
+	if !entry.immutable {
+		expiry = int(math.Ceil(ttl.Seconds()))
+	}
+	mkey := c.memcachedKey(key)
+	cmd := fmt.Sprintf("set %s 0 %d %d\r\n%s\r\n", mkey, expiry, len(value), value)
+	c.serverFor(mkey).do(c.timeout, cmd, func(r *bufio.Reader) error {
+		line, err := readMemcachedLine(r)
+		if err == nil && line != "STORED" {
+			err = fmt.Errorf("unexpected reply %q", line)
+		}
+		return err
+	})
+}
+
+// do sends a command on an idle or new connection and reads the reply. Connections
+// are returned to the pool only after a complete exchange.
+func (s *memcachedServer) do(timeout time.Duration, cmd string, read func(*bufio.Reader) error) error {
+	var conn *memcachedConn
+	select {
+	case conn = <-s.idle:
+	default:
+		nc, err := net.DialTimeout("tcp", s.addr, timeout)
+		if err != nil {
+			s.record(err)
+			return err
+		}
+		conn = &memcachedConn{Conn: nc, reader: bufio.NewReader(nc)}
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err := conn.Write([]byte(cmd))
+	if err == nil {
+		err = read(conn.reader)
+	}
+	if err != nil {
+		conn.Close()
+		s.record(err)
+		return err
+	}
+	s.record(nil)
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return nil
+}
+
+// record logs when a server starts failing and when it recovers.
+func (s *memcachedServer) record(err error) {
+	reportDependency("memcached "+s.addr, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && !s.failing:
+		logWarn("cache", "Memcached server %s failed: %v", s.addr, err)
+	case err == nil && s.failing:
+		logInfo("cache", "Memcached server %s recovered", s.addr)
+	}
+	s.failing = err != nil
+}
+
+// readMemcachedLine reads a reply line without its line ending, turning error replies
+// into errors.
+func readMemcachedLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("memcached: %s", line)
+	}
+	return line, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMemcached serves get and set of the memcached text protocol.
+type fakeMemcached struct {
+	listener net.Listener
+	mu       sync.Mutex
+	items    map[string]string
+	expiries map[string]string
+}
+
+// startFakeMemcached starts a fake memcached server.
+func startFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeMemcached{listener: listener, items: make(map[string]string), expiries: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		f.mu.Lock()
+		switch fields[0] {
+		case "get":
+			if value, ok := f.items[fields[1]]; ok {
+				fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+			}
+			conn.Write([]byte("END\r\n"))
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			io.ReadFull(reader, data)
+			f.items[fields[1]] = string(data[:size])
+			f.expiries[fields[1]] = fields[3]
+			conn.Write([]byte("STORED\r\n"))
+		default:
+			conn.Write([]byte("ERROR\r\n"))
+		}
+		f.mu.Unlock()
+	}
+}
+
+// TestMemcachedRing tests that removing a server only moves its own keys
+func TestMemcachedRing(t *testing.T) {
+	// Setup
+	three, _ := newMemcachedClient(&MemcachedConfig{Servers: []string{"a:11211", "b:11211", "c:11211"}})
+	two, _ := newMemcachedClient(&MemcachedConfig{Servers: []string{"a:11211", "b:11211"}})
+
+	// Test
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before := three.serverFor(key).addr
+		counts[before]++
+		if after := two.serverFor(key).addr; before != "c:11211" && after != before {
+			moved++
+		}
+	}
+
+	// Verify
+	if moved != 0 {
+		t.Errorf("Expected only the removed server's keys to move, %d others moved", moved)
+	}
+	for server, n := range counts {
+		if n < 600 || n > 1400 {
+			t.Errorf("Expected keys to be spread evenly, %s owns %d of 3000", server, n)
+		}
+	}
+}
+
+// TestMemcachedBackend tests sharing cached results between proxies through memcached
+func TestMemcachedBackend(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x3b9aca00"}`))
+	}))
+	defer server.Close()
+	mc1, mc2 := startFakeMemcached(t), startFakeMemcached(t)
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{
+		Methods:   []string{"eth_gasPrice", "eth_chainId"},
+		TTL:       1500 * time.Millisecond,
+		Backend:   "memcached",
+		Memcached: &MemcachedConfig{Servers: []string{mc1.listener.Addr().String(), mc2.listener.Addr().String()}},
+	}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		responseCache = nil
+	}()
+	request := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[]}`)))
+		return w
+	}
+
+	// Test: a second proxy finds the results cached by the first
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	request("eth_gasPrice")
+	request("eth_chainId")
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up the second cache: %v", err)
+	}
+	shared := request("eth_gasPrice")
+
+	// Verify
+	if calls.Load() != 2 || shared.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected a hit from memcached, got %s after %d upstream calls", shared.Header().Get("X-Cache"), calls.Load())
+	}
+	expiries := make(map[string]bool)
+	for _, mc := range []*fakeMemcached{mc1, mc2} {
+		mc.mu.Lock()
+		for _, expiry := range mc.expiries {
+			expiries[expiry] = true
+		}
+		mc.mu.Unlock()
+	}
+	if !expiries["2"] || !expiries["0"] {
+		t.Errorf("Expected head-scoped entries to expire after ttl and immutable ones never, got %v", expiries)
+	}
+	if stats := responseCache.stats(); stats["backend"] != "memcached" {
+		t.Errorf("Expected the backend in the stats, got %v", stats)
+	}
+
+	// Verify that unreachable servers count as misses
+	mc1.listener.Close()
+	mc2.listener.Close()
+	// Drop the pooled connections, which outlive the listeners
+	responseCache.shared.servers[mc1.listener.Addr().String()].idle = make(chan *memcachedConn, 1)
+	responseCache.shared.servers[mc2.listener.Addr().String()].idle = make(chan *memcachedConn, 1)
+	if w := request("eth_gasPrice"); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected a miss while memcached is down, got %d %s", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+// TestMemcachedConfigErrors tests that invalid memcached configurations are rejected
+func TestMemcachedConfigErrors(t *testing.T) {
+	defer func() { config = Config{} }()
+	testCases := []struct {
+		name     string
+		cache    *CacheConfig
+		expected string
+	}{
+		{"No servers", &CacheConfig{Methods: []string{"eth_chainId"}, Backend: "memcached"}, "memcached.servers is required"},
+		{"Missing port", &CacheConfig{Methods: []string{"eth_chainId"}, Backend: "memcached", Memcached: &MemcachedConfig{Servers: []string{"memcached"}}}, "memcached.servers"},
+		{"Persist", &CacheConfig{Methods: []string{"eth_chainId"}, Backend: "memcached", Persist: &CachePersistConfig{Path: "/tmp/cache"}}, "persist is not available"},
+		{"Unknown backend", &CacheConfig{Methods: []string{"eth_chainId"}, Backend: "redis"}, "unknown backend"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{Cache: tc.cache}
+
+			// Test
+			err := setupCache()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
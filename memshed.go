@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Memory-pressure load shedding
+//
+// A storm of eth_getLogs calls over wide block ranges can buffer gigabytes of results
+// at once and get the proxy killed for running out of memory, taking every client down
+// with it. The heap can be watched, and traffic shed before it gets that far:
+//
+//	memory_shedding:
+//	  soft_limit: 1024    # heap megabytes above which low-priority and heavy calls are rejected
+//	  hard_limit: 1536    # heap megabytes above which all but high-priority calls are rejected
+//	  heavy_methods: [eth_getLogs, debug_trace*, trace_*] # (default: these)
+//	  interval: 1s        # how often the heap is sampled (default: 1s)
+//
+// Above soft_limit, requests of the low priority class (see priorities.go) are
+// rejected, and so are normal-priority requests calling a heavy method, whose results
+// tend to be the largest. Above hard_limit, every request that is not high priority is
+// rejected. Rejected requests get HTTP 429, Retry-After, and a -32005 error, as when the
+// proxy is saturated. The heap is the memory of live and not yet swept objects, read
+// from runtime/metrics, so it reflects what the proxy holds rather than what the
+// operating system has not reclaimed yet. Heavy methods are names, or prefixes ending
+// in *.
+//
+// The sampled heap is exported as jsonrpc_proxy_heap_bytes, the pressure level (0
+// below soft_limit, 1 above it, 2 above hard_limit) as jsonrpc_proxy_memory_pressure,
+// and rejections as jsonrpc_proxy_memory_shed_total by priority.
+
+// MemorySheddingConfig configures load shedding under memory pressure.
+type MemorySheddingConfig struct {
+	SoftLimit    int           `yaml:"soft_limit"`    // Heap megabytes above which low-priority and heavy calls are rejected
+	HardLimit    int           `yaml:"hard_limit"`    // Heap megabytes above which all but high-priority calls are rejected (optional)
+	HeavyMethods []string      `yaml:"heavy_methods"` // Methods rejected from normal priority above soft_limit (default: eth_getLogs, debug_trace*, trace_*)
+	Interval     time.Duration `yaml:"interval"`      // How often the heap is sampled (default: 1s)
+}
+
+// defaultHeavyMethods are the methods rejected from normal priority above soft_limit.
+var defaultHeavyMethods = []string{"eth_getLogs", "debug_trace*", "trace_*"}
+
+const (
+	defaultMemoryInterval = time.Second
+	heapMetric            = "/memory/classes/heap/objects:bytes"
+)
+
+// Memory pressure levels.
+const (
+	pressureNone = iota
+	pressureSoft
+	pressureHard
+)
+
+// errMemoryPressure is returned for requests shed under memory pressure.
+var errMemoryPressure = fmt.Errorf("%w: memory pressure", errSaturated)
+
+var (
+	heapBytes        atomic.Uint64 // Last sampled heap size
+	memoryShedTotal  = newCounterVec("jsonrpc_proxy_memory_shed_total", "Requests rejected under memory pressure, by priority.", "priority")
+	memoryRegistered sync.Once
+)
+
+// setupMemoryShedding validates the memory watermarks.
+//
+// Returns:
+//   - error: An error if a limit is missing, negative, or out of order
+func setupMemoryShedding() error {
+	heapBytes.Store(0)
+	mc := config.MemoryShedding
+	if mc == nil {
+		return nil
+	}
+	if mc.SoftLimit <= 0 {
+		return fmt.Errorf("soft_limit must be positive")
+	}
+	if mc.HardLimit < 0 || mc.HardLimit > 0 && mc.HardLimit < mc.SoftLimit {
+		return fmt.Errorf("hard_limit cannot be below soft_limit")
+	}
+	if mc.Interval < 0 {
+		return fmt.Errorf("interval cannot be negative")
+	}
+	memoryRegistered.Do(func() { registerMetric(memoryCollector{}) })
+	if mc.HardLimit > 0 {
+		log.Printf("Shedding load above %d MB of heap, and all but high-priority calls above %d MB", mc.SoftLimit, mc.HardLimit)
+	} else {
+		log.Printf("Shedding load above %d MB of heap", mc.SoftLimit)
+	}
+	return nil
+}
+
+// startMemoryMonitor samples the heap every interval until ctx is done.
+func startMemoryMonitor(ctx context.Context) {
+	mc := config.MemoryShedding
+	if mc == nil {
+		return
+	}
+	interval := mc.Interval
+	if interval == 0 {
+		interval = defaultMemoryInterval
+	}
+	sampleHeap()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				before := memoryPressure()
+				sampleHeap()
+				if after := memoryPressure(); after != before {
+					logWarn("router", "Memory pressure changed from level %d to %d (%d MB of heap)", before, after, heapBytes.Load()>>20)
+				}
+			}
+		}
+	}()
+}
+
+// sampleHeap reads the heap size from runtime/metrics.
+func sampleHeap() {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		heapBytes.Store(sample[0].Value.Uint64())
+	}
+}
+
+// memoryPressure returns the pressure level of the last heap sample.
+func memoryPressure() int {
+	mc := config.MemoryShedding
+	if mc == nil {
+		return pressureNone
+	}
+	heap := heapBytes.Load()
+	switch {
+	case mc.HardLimit > 0 && heap > uint64(mc.HardLimit)<<20:
+		return pressureHard
+	case heap > uint64(mc.SoftLimit)<<20:
+		return pressureSoft
+	}
+	return pressureNone
+}
+
+// shedForMemory reports whether a request is rejected under the current memory pressure.
+//
+// Parameters:
+//   - prio: The request's priority class
+//   - body: The request body, a single call or a batch
+//
+// Returns:
+//   - bool: Whether the request is rejected
+func shedForMemory(prio priority, body []byte) bool {
+	level := memoryPressure()
+	shed := false
+	switch {
+	case level == pressureNone || prio == priorityHigh:
+	case level == pressureHard || prio == priorityLow:
+		shed = true
+	default:
+		for _, call := range parseCalls(body) {
+			shed = shed || heavyMethod(call.Method)
+		}
+	}
+	if shed {
+		memoryShedTotal.inc(prio.String())
+	}
+	return shed
+}
+
+// heavyMethod reports whether a method is listed in heavy_methods.
+func heavyMethod(method string) bool {
+	patterns := config.MemoryShedding.HeavyMethods
+	if len(patterns) == 0 {
+		patterns = defaultHeavyMethods
+	}
+	for _, pattern := range patterns {
+		if methodPatternMatches(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryCollector exports the sampled heap and the pressure level.
+type memoryCollector struct{}
+
+// write implements metricsCollector.
+func (memoryCollector) write(w io.Writer, openMetrics bool) {
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_heap_bytes Heap in use at the last sample, for memory shedding.\n# TYPE jsonrpc_proxy_heap_bytes gauge\n")
+	fmt.Fprintf(w, "jsonrpc_proxy_heap_bytes %d\n", heapBytes.Load())
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_memory_pressure Memory pressure level: 0 below soft_limit, 1 above it, 2 above hard_limit.\n# TYPE jsonrpc_proxy_memory_pressure gauge\n")
+	fmt.Fprintf(w, "jsonrpc_proxy_memory_pressure %d\n", memoryPressure())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMemoryShedding tests which requests are rejected at each memory pressure level.
+func TestMemoryShedding(t *testing.T) {
+	// Setup
+	config = Config{MemoryShedding: &MemorySheddingConfig{SoftLimit: 100, HardLimit: 200}}
+	defer func() {
+		config = Config{}
+		heapBytes.Store(0)
+	}()
+	if err := setupMemoryShedding(); err != nil {
+		t.Fatalf("Failed to set up memory shedding: %v", err)
+	}
+	logs := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[]}`)
+	trace := []byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction"}]`)
+	light := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+	testCases := []struct {
+		name     string
+		heapMB   uint64
+		prio     priority
+		body     []byte
+		expected bool
+	}{
+		{"Below soft_limit", 50, priorityLow, logs, false},
+		{"Low priority above soft_limit", 150, priorityLow, light, true},
+		{"Heavy method above soft_limit", 150, priorityNormal, logs, true},
+		{"Heavy method in a batch above soft_limit", 150, priorityNormal, trace, true},
+		{"Light method above soft_limit", 150, priorityNormal, light, false},
+		{"High priority above soft_limit", 150, priorityHigh, logs, false},
+		{"Normal priority above hard_limit", 250, priorityNormal, light, true},
+		{"High priority above hard_limit", 250, priorityHigh, logs, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			heapBytes.Store(tc.heapMB << 20)
+
+			// Test
+			shed := shedForMemory(tc.prio, tc.body)
+
+			// Verify
+			if shed != tc.expected {
+				t.Errorf("Expected shed %v, got %v", tc.expected, shed)
+			}
+		})
+	}
+}
+
+// TestMemorySheddingResponse tests that a shed request gets a 429 with a -32005 error.
+func TestMemorySheddingResponse(t *testing.T) {
+	// Setup
+	config = Config{DefaultURL: "http://127.0.0.1:0", MemoryShedding: &MemorySheddingConfig{SoftLimit: 1, HardLimit: 1}}
+	defer func() {
+		config = Config{}
+		heapBytes.Store(0)
+	}()
+	buildMethodURLMap()
+	if err := setupConcurrency(); err != nil {
+		t.Fatalf("Failed to set up priorities: %v", err)
+	}
+	heapBytes.Store(2 << 20)
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_blockNumber","params":[]}`)))
+
+	// Verify
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"code":-32005`) || !strings.Contains(body, "memory pressure") || !strings.Contains(body, `"id":7`) {
+		t.Errorf("Expected a -32005 memory pressure error, got %s", body)
+	}
+}
+
+// TestMemorySheddingConfig tests the validation of the watermarks.
+func TestMemorySheddingConfig(t *testing.T) {
+	defer func() { config = Config{} }()
+	for name, mc := range map[string]*MemorySheddingConfig{
+		"missing soft_limit":          {HardLimit: 100},
+		"hard_limit below soft_limit": {SoftLimit: 200, HardLimit: 100},
+		"negative interval":           {SoftLimit: 100, Interval: -1},
+	} {
+		config = Config{MemoryShedding: mc}
+		if err := setupMemoryShedding(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Proxy meta-methods
+//
+// When enabled, the proxy answers a small set of proxy_* JSON-RPC methods itself so
+// that RPC-native tooling can inspect it without separate HTTP endpoints:
+//
+//	proxy_version     the proxy build version
+//	proxy_upstreams   upstream health, latency, and head height from the probes
+//	proxy_routes      the routing table
+//	proxy_cacheStats  response cache statistics
+//
+// Upstream URLs often embed provider API keys, so only scheme and host are reported
+// unless expose_urls is set.
+
+// version is the proxy build version, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// MetaMethodsConfig configures the proxy_* introspection methods.
+type MetaMethodsConfig struct {
+	Enabled    bool `yaml:"enabled"`     // Answer proxy_* methods
+	ExposeURLs bool `yaml:"expose_urls"` // Report full upstream URLs instead of scheme and host only
+}
+
+// metaMethods maps the meta-method names to their handlers.
+var metaMethods = map[string]localHandler{
+	"proxy_version":    handleProxyVersion,
+	"proxy_upstreams":  handleProxyUpstreams,
+	"proxy_routes":     handleProxyRoutes,
+	"proxy_cacheStats": handleProxyCacheStats,
+}
+
+// setupMetaMethods registers or removes the proxy_* methods according to the configuration.
+func setupMetaMethods() {
+	enabled := config.MetaMethods != nil && config.MetaMethods.Enabled
+	for method, handler := range metaMethods {
+		if enabled {
+			registerLocalMethod(method, handler)
+		} else {
+			unregisterLocalMethod(method)
+		}
+	}
+}
+
+// displayURL returns the URL as it may be shown to clients.
+func displayURL(raw string) string {
+	if config.MetaMethods != nil && config.MetaMethods.ExposeURLs {
+		return raw
+	}
+	return redactURL(raw)
+}
+
+// redactURL reduces a URL to its scheme and host.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err == nil && u.Scheme == "unix" {
+		return "unix://" + u.Path
+	}
+	if err != nil || u.Host == "" {
+		return "(redacted)"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// handleProxyVersion returns the proxy version.
+func handleProxyVersion(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	return version, nil
+}
+
+// upstreamInfo describes an upstream in proxy_upstreams.
+type upstreamInfo struct {
+	Name      string  `json:"name"`
+	URL       string  `json:"url"`
+	Probed    bool    `json:"probed"`
+	Healthy   bool    `json:"healthy"`
+	Height    string  `json:"height,omitempty"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	LastError string  `json:"lastError,omitempty"`
+	LastProbe string  `json:"lastProbe,omitempty"`
+}
+
+// handleProxyUpstreams returns the state of every upstream.
+func handleProxyUpstreams(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	statuses := upstreamStatusSnapshot()
+	if len(statuses) == 0 {
+		// Probing is disabled: report the configured upstreams without health data
+		out := []upstreamInfo{}
+		for u, name := range probeTargets() {
+			out = append(out, upstreamInfo{Name: name, URL: displayURL(u)})
+		}
+		return out, nil
+	}
+
+	out := make([]upstreamInfo, 0, len(statuses))
+	for _, s := range statuses {
+		info := upstreamInfo{
+			Name:      s.Name,
+			URL:       displayURL(s.URL),
+			Probed:    !s.LastProbe.IsZero(),
+			Healthy:   s.Healthy,
+			LastError: s.LastError,
+		}
+		if s.Height > 0 {
+			info.Height = toQuantity(s.Height)
+		}
+		if s.Latency > 0 {
+			info.LatencyMs = float64(s.Latency.Microseconds()) / 1000
+		}
+		if !s.LastProbe.IsZero() {
+			info.LastProbe = s.LastProbe.UTC().Format("2006-01-02T15:04:05Z")
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// routeInfo describes a route in proxy_routes.
+type routeInfo struct {
+	Method      string   `json:"method,omitempty"`
+	When        string   `json:"when,omitempty"`
+	Upstream    string   `json:"upstream"`
+	URL         string   `json:"url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// handleProxyRoutes returns the routing table, ending with the default route.
+func handleProxyRoutes(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	out := make([]routeInfo, 0, len(config.Routes)+1)
+	for _, route := range config.Routes {
+		info := routeInfo{Method: route.Method, When: route.When, Tags: route.Tags, Description: route.Description}
+		if route.Pool != "" {
+			info.Upstream = "pool:" + route.Pool
+			out = append(out, info)
+			continue
+		}
+		if route.BlueGreen != nil {
+			info.Upstream = "blue_green:" + route.BlueGreen.Blue + "/" + route.BlueGreen.Green
+			out = append(out, info)
+			continue
+		}
+		info.Upstream = route.Name
+		if info.Upstream == "" {
+			info.Upstream = displayURL(route.URL)
+		}
+		info.URL = displayURL(route.URL)
+		out = append(out, info)
+	}
+	if config.DefaultPool != "" {
+		out = append(out, routeInfo{Method: "*", Upstream: "pool:" + config.DefaultPool})
+	} else {
+		out = append(out, routeInfo{Method: "*", Upstream: config.DefaultName, URL: displayURL(config.DefaultURL)})
+	}
+	return out, nil
+}
+
+// handleProxyCacheStats returns response cache statistics.
+func handleProxyCacheStats(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	if rc := responseCache; rc != nil {
+		return rc.stats(), nil
+	}
+	return map[string]interface{}{"enabled": false}, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMetaMethods tests the proxy_* introspection methods
+func TestMetaMethods(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL:  "https://mainnet.example.com/v3/secret-key",
+		DefaultName: "mainnet",
+		Routes: []Route{
+			{Method: "eth_call", URL: "https://archive.example.com/key", Name: "archive"},
+		},
+		MetaMethods: &MetaMethodsConfig{Enabled: true},
+	}
+	buildMethodURLMap()
+	setupMetaMethods()
+	statusMu.Lock()
+	upstreamStatuses = map[string]*upstreamStatus{
+		config.DefaultURL: {Name: "mainnet", URL: config.DefaultURL, Height: 256, Latency: 1500 * time.Microsecond, Healthy: true, LastProbe: time.Now()},
+	}
+	statusMu.Unlock()
+	defer func() {
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+		config.MetaMethods = nil
+		setupMetaMethods()
+	}()
+
+	// Test
+	var v string
+	if err := callProxy(t, "proxy_version", nil, &v); err != nil {
+		t.Fatalf("proxy_version failed: %v", err)
+	}
+	var upstreams []upstreamInfo
+	if err := callProxy(t, "proxy_upstreams", nil, &upstreams); err != nil {
+		t.Fatalf("proxy_upstreams failed: %v", err)
+	}
+	var routes []routeInfo
+	if err := callProxy(t, "proxy_routes", nil, &routes); err != nil {
+		t.Fatalf("proxy_routes failed: %v", err)
+	}
+	var stats map[string]interface{}
+	if err := callProxy(t, "proxy_cacheStats", nil, &stats); err != nil {
+		t.Fatalf("proxy_cacheStats failed: %v", err)
+	}
+
+	// Verify
+	if v != version {
+		t.Errorf("Expected version %s, got %s", version, v)
+	}
+	if len(upstreams) != 1 || upstreams[0].Height != "0x100" || !upstreams[0].Healthy || upstreams[0].LatencyMs != 1.5 {
+		t.Errorf("Unexpected upstreams: %+v", upstreams)
+	}
+	if upstreams[0].URL != "https://mainnet.example.com" {
+		t.Errorf("Expected redacted URL, got %s", upstreams[0].URL)
+	}
+	if len(routes) != 2 || routes[0].Method != "eth_call" || routes[0].Upstream != "archive" || routes[1].Method != "*" {
+		t.Errorf("Unexpected routes: %+v", routes)
+	}
+	if stats["enabled"] != false {
+		t.Errorf("Expected cache to be reported as disabled, got %v", stats)
+	}
+}
+
+// TestMetaMethodsDisabled tests that proxy_* methods are forwarded when disabled
+func TestMetaMethodsDisabled(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "proxy_version", `{"jsonrpc":"2.0","id":1,"result":"upstream"}`)
+	defer server.Close()
+	config = Config{DefaultURL: server.URL}
+	buildMethodURLMap()
+	setupMetaMethods()
+
+	// Test
+	var v string
+	if err := callProxy(t, "proxy_version", nil, &v); err != nil {
+		t.Fatalf("proxy_version failed: %v", err)
+	}
+
+	// Verify
+	if v != "upstream" {
+		t.Errorf("Expected the upstream to answer, got %s", v)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Blocked namespaces
+//
+// Self-hosted nodes expose management methods next to the public API: admin_ adds
+// peers and stops the RPC server, personal_ unlocks accounts, miner_ changes the
+// coinbase, and txpool_content dumps the whole pool, which is both expensive and
+// useful to front-runners. The proxy rejects them by default, so exposing a node through
+// it does not expose them. Methods or namespaces needed by trusted deployments are
+// allowed explicitly, and more can be blocked:
+//
+//	method_blocking:
+//	  allow: [txpool_content, "admin_peers"]   # exempt from blocking
+//	  block: ["debug_*"]                       # blocked in addition to the defaults
+//
+// Patterns are method names, or prefixes ending with *. A method matching allow is
+// never blocked. Blocked calls are answered with a -32601 error, in batches too,
+// without reaching an upstream, and counted in
+// jsonrpc_proxy_blocked_calls_total{pattern="..."}. Calls spelling a member twice, or
+// in another case, are rejected before they are checked (see jsonlimits.go), so that
+// the method checked is the method sent upstream.
+
+// MethodBlockingConfig configures the methods rejected by the proxy.
+type MethodBlockingConfig struct {
+	Allow []string `yaml:"allow"` // Methods or namespace patterns exempt from blocking
+	Block []string `yaml:"block"` // Patterns blocked in addition to the defaults
+}
+
+// defaultBlockedMethods are the node-management methods blocked unless allowed.
+var defaultBlockedMethods = []string{"admin_*", "personal_*", "miner_*", "txpool_content"}
+
+// blockedCalls counts the calls rejected by blocking, by the pattern they matched.
+var blockedCalls = newCounterVec("jsonrpc_proxy_blocked_calls_total", "Calls rejected because their method is blocked, by blocking pattern.", "pattern")
+
+// setupMethodBlocking validates the blocking patterns.
+//
+// Returns:
+//   - error: An error if a pattern is empty or has * elsewhere than at its end
+func setupMethodBlocking() error {
+	mb := config.MethodBlocking
+	if mb == nil {
+		return nil
+	}
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"allow", mb.Allow}, {"block", mb.Block}} {
+		for _, pattern := range list.patterns {
+			if pattern == "" || strings.Contains(strings.TrimSuffix(pattern, "*"), "*") {
+				return fmt.Errorf("%s: invalid pattern %q (expected a method name or a prefix ending with *)", list.name, pattern)
+			}
+		}
+	}
+	if len(mb.Allow) > 0 {
+		log.Printf("Allowing blocked methods matching %s", strings.Join(mb.Allow, ", "))
+	}
+	return nil
+}
+
+// blockedMethod returns the blocking pattern a method matches, unless it is allowed.
+//
+// Returns:
+//   - string: The pattern
+//   - bool: Whether the method is blocked
+func blockedMethod(method string) (string, bool) {
+	var extra []string
+	if mb := config.MethodBlocking; mb != nil {
+		for _, pattern := range mb.Allow {
+			if methodPatternMatches(pattern, method) {
+				return "", false
+			}
+		}
+		extra = mb.Block
+	}
+	for _, patterns := range [][]string{extra, defaultBlockedMethods} {
+		for _, pattern := range patterns {
+			if methodPatternMatches(pattern, method) {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// methodPatternMatches reports whether a method is the pattern, or starts with a
+// pattern ending with *.
+func methodPatternMatches(pattern, method string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(method, prefix)
+	}
+	return method == pattern
+}
+
+// blockedCallError rejects a call to a blocked method.
+//
+// Returns:
+//   - *JSONRPCError: The error answering the call, or nil if the method is not blocked
+func blockedCallError(req *JSONRPCRequest) *JSONRPCError {
+	pattern, blocked := blockedMethod(req.Method)
+	if !blocked {
+		return nil
+	}
+	blockedCalls.inc(pattern)
+	logInfo("router", "Rejecting method '%s': blocked by %s", req.Method, pattern)
+	return &JSONRPCError{Code: -32601, Message: fmt.Sprintf("method %s is not available through this proxy", req.Method)}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMethodBlocking tests rejecting node-management methods unless allowed
+func TestMethodBlocking(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "ok"})
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, MethodBlocking: &MethodBlockingConfig{
+		Allow: []string{"txpool_content", "admin_nodeInfo"},
+		Block: []string{"debug_*"},
+	}}
+	buildMethodURLMap()
+	if err := setupMethodBlocking(); err != nil {
+		t.Fatalf("Failed to set up method blocking: %v", err)
+	}
+	defer func() { config = Config{} }()
+	blockedBefore := blockedCalls.value("admin_*")
+
+	// Test
+	admin := callProxy(t, "admin_addPeer", []interface{}{"enode://..."}, nil)
+	personal := callProxy(t, "personal_unlockAccount", nil, nil)
+	debug := callProxy(t, "debug_traceTransaction", nil, nil)
+	nodeInfo := callProxy(t, "admin_nodeInfo", nil, nil)
+	txpool := callProxy(t, "txpool_content", nil, nil)
+	status := callProxy(t, "txpool_status", nil, nil)
+
+	// Verify
+	for name, err := range map[string]*JSONRPCError{"admin_addPeer": admin, "personal_unlockAccount": personal, "debug_traceTransaction": debug} {
+		if err == nil || err.Code != -32601 {
+			t.Errorf("Expected %s to be blocked, got %v", name, err)
+		}
+	}
+	for name, err := range map[string]*JSONRPCError{"admin_nodeInfo": nodeInfo, "txpool_content": txpool, "txpool_status": status} {
+		if err != nil {
+			t.Errorf("Expected %s to be forwarded, got %v", name, err)
+		}
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected 3 upstream calls, got %d", got)
+	}
+	if got := blockedCalls.value("admin_*") - blockedBefore; got != 1 {
+		t.Errorf("Expected 1 call blocked by admin_*, got %v", got)
+	}
+}
+
+// TestMethodBlockingBatch tests that blocked calls of a batch are answered without the others failing
+func TestMethodBlockingBatch(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		var resps []map[string]interface{}
+		for _, req := range reqs {
+			resps = append(resps, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Method})
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"miner_setEtherbase","params":["0x1"]}]`)))
+
+	// Verify
+	var resps []JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil || len(resps) != 2 {
+		t.Fatalf("Expected 2 responses, got %s", w.Body.String())
+	}
+	for _, resp := range resps {
+		switch resp.ID {
+		case float64(1):
+			if resp.Result != "eth_blockNumber" {
+				t.Errorf("Expected eth_blockNumber to be forwarded, got %+v", resp)
+			}
+		case float64(2):
+			if resp.Error == nil || resp.Error.Code != -32601 {
+				t.Errorf("Expected miner_setEtherbase to be blocked, got %+v", resp)
+			}
+		}
+	}
+}
+
+// TestMethodBlockingConfig tests rejecting malformed patterns
+func TestMethodBlockingConfig(t *testing.T) {
+	// Setup
+	config = Config{MethodBlocking: &MethodBlockingConfig{Block: []string{"debug_*_trace"}}}
+	defer func() { config = Config{} }()
+
+	// Test
+	err := setupMethodBlocking()
+
+	// Verify
+	if err == nil {
+		t.Errorf("Expected an error for * inside a pattern")
+	}
+}
+
+// TestMethodBlockingAmbiguousMethod tests that a blocked method cannot hide behind a case-variant duplicate
+func TestMethodBlockingAmbiguousMethod(t *testing.T) {
+	// Setup
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"ok"}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	handler := withJSONLimits(handleProxy)
+	w := httptest.NewRecorder()
+
+	// Test
+	handler(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","Method":"eth_chainId","params":[]}`)))
+
+	// Verify
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":-32600`) {
+		t.Errorf("Expected HTTP 400 with -32600, got %d %s", w.Code, w.Body.String())
+	}
+	if calls.Load() != 0 {
+		t.Errorf("Expected the call not to reach the upstream, got %d requests", calls.Load())
+	}
+}
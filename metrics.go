@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics
+//
+// When enabled, the proxy serves Prometheus metrics in the text exposition format on
+// /metrics (or metrics.path). Calls are labeled with the name and tags of the route
+// that served them, so dashboards can group traffic by meaning ("archive-traffic",
+// "wallet-writes") rather than by upstream URL:
+//
+//	jsonrpc_proxy_calls_total{route="archive",tags="archive-traffic",outcome="ok"} 42
+//	jsonrpc_proxy_call_duration_seconds_bucket{route="archive",tags="archive-traffic",le="0.1"} 40
+//
+// The route label is the route's name, its method if it has no name, "default" for
+// calls served by the default route, and "local" for methods answered by the proxy.
+// The tags label holds the route's tags, sorted and comma-separated. Outcomes are ok,
+// http_error (an upstream status of 400 or more), error, timeout (a batch timeout, see
+// batchsplit.go), saturated, cancelled, stub, cache, and local. With exemplars enabled,
+// latency observations carry the trace IDs of traced requests (see exemplars.go).
+
+// MetricsConfig configures the metrics endpoint.
+type MetricsConfig struct {
+	Enabled   bool   `yaml:"enabled"`   // Serve metrics
+	Path      string `yaml:"path"`      // HTTP path of the metrics (default: /metrics)
+	Exemplars bool   `yaml:"exemplars"` // Attach trace IDs to latency observations
+}
+
+// durationBuckets are the upper bounds of the latency histogram buckets, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64 // By encoded label values
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram // By encoded label values
+}
+
+// histogram holds the observations of one label set.
+type histogram struct {
+	counts    []uint64 // Observations per bucket (not cumulative); the last is +Inf
+	sum       float64
+	count     uint64
+	exemplars []*exemplar // Latest traced observation per bucket, allocated on the first
+}
+
+// metricsCollector writes metrics in the text exposition format, or in the OpenMetrics
+// format if openMetrics is set.
+type metricsCollector interface {
+	write(w io.Writer, openMetrics bool)
+}
+
+var (
+	metricsMu        sync.Mutex
+	metricsCollected []metricsCollector // Registered metrics, in registration order
+)
+
+// newCounterVec creates and registers a counter.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registerMetric(c)
+	return c
+}
+
+// newHistogramVec creates and registers a histogram.
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+	registerMetric(h)
+	return h
+}
+
+// registerMetric adds a metric to the output.
+func registerMetric(m metricsCollector) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsCollected = append(metricsCollected, m)
+}
+
+// add increases the counter of a label set.
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := encodeLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// inc increases the counter of a label set by one.
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// value returns the counter of a label set.
+func (c *counterVec) value(labelValues ...string) float64 {
+	key := encodeLabels(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+// write implements metricsCollector.
+func (c *counterVec) write(w io.Writer, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	family := c.name
+	if openMetrics {
+		family = strings.TrimSuffix(family, "_total") // OpenMetrics names counter families without the suffix
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", family, c.help, family)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// observe records an observation for a label set.
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.observeWithExemplar(v, "", labelValues...)
+}
+
+// observeWithExemplar records an observation for a label set, keeping it as the
+// exemplar of its bucket if traceID is set.
+func (h *histogramVec) observeWithExemplar(v float64, traceID string, labelValues ...string) {
+	key := encodeLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = hist
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	hist.counts[i]++
+	hist.sum += v
+	hist.count++
+	if traceID != "" {
+		if hist.exemplars == nil {
+			hist.exemplars = make([]*exemplar, len(hist.counts))
+		}
+		hist.exemplars[i] = &exemplar{traceID: traceID, value: v, at: time.Now()}
+	}
+}
+
+// count returns the number of observations of a label set.
+func (h *histogramVec) count(labelValues ...string) uint64 {
+	key := encodeLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hist, ok := h.values[key]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+// write implements metricsCollector.
+func (h *histogramVec) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, count := range hist.counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d", h.name, withLabel(key, "le", formatFloat(bound)), cumulative)
+			if openMetrics && hist.exemplars != nil && hist.exemplars[i] != nil {
+				e := hist.exemplars[i]
+				fmt.Fprintf(w, " # {trace_id=%q} %s %s", e.traceID, formatFloat(e.value), formatFloat(float64(e.at.UnixMilli())/1000))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, hist.count)
+	}
+}
+
+// encodeLabels formats label values as a Prometheus label set, such as {a="1",b="2"}.
+func encodeLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel adds a label to an encoded label set.
+func withLabel(key, name, value string) string {
+	label := name + "=" + strconv.Quote(value)
+	if key == "" {
+		return "{" + label + "}"
+	}
+	return key[:len(key)-1] + "," + label + "}"
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a map in order, for stable output.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleMetrics writes every registered metric.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	collected := append([]metricsCollector(nil), metricsCollected...)
+	metricsMu.Unlock()
+
+	openMetrics := wantsOpenMetrics(r)
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	for _, m := range collected {
+		m.write(w, openMetrics)
+	}
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
+
+// startMetrics serves the metrics endpoint on the default mux if enabled.
+func startMetrics() {
+	if config.Metrics == nil || !config.Metrics.Enabled {
+		return
+	}
+	path := config.Metrics.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	http.HandleFunc("GET "+path, handleMetrics)
+	log.Printf("Serving metrics on %s", path)
+}
+
+// Call metrics, labeled by route.
+var (
+	callsTotal   = newCounterVec("jsonrpc_proxy_calls_total", "JSON-RPC calls by route and outcome.", "route", "tags", "outcome")
+	callDuration = newHistogramVec("jsonrpc_proxy_call_duration_seconds", "Time to serve JSON-RPC calls by route.", durationBuckets, "route", "tags")
+)
+
+// routeName returns the name of a route in metrics and logs.
+func routeName(route *Route) string {
+	switch {
+	case route == nil:
+		return "default"
+	case route.Name != "":
+		return route.Name
+	case route.Method != "":
+		return route.Method
+	}
+	return "conditional"
+}
+
+// routeTags returns the tags of a route in metrics and logs, sorted and comma-separated.
+func routeTags(route *Route) string {
+	if route == nil || len(route.Tags) == 0 {
+		return ""
+	}
+	tags := append([]string(nil), route.Tags...)
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// routeLogSuffix describes a route's tags for log lines, or returns an empty string.
+func routeLogSuffix(route *Route) string {
+	if tags := routeTags(route); tags != "" {
+		return " [" + tags + "]"
+	}
+	return ""
+}
+
+// observeCall records a served call.
+//
+// Parameters:
+//   - ctx: The context of the request, carrying its trace ID if it is traced
+//   - route: The route that served the call (nil for the default route)
+//   - outcome: How the call ended
+//   - d: The time taken to serve the call
+func observeCall(ctx context.Context, route *Route, outcome string, d time.Duration) {
+	name, tags := routeName(route), routeTags(route)
+	callsTotal.inc(name, tags, outcome)
+	callDuration.observeWithExemplar(d.Seconds(), traceIDFromContext(ctx), name, tags)
+}
+
+// observeLocalCall records a call answered by the proxy itself.
+func observeLocalCall(ctx context.Context, d time.Duration) {
+	callsTotal.inc("local", "", "local")
+	callDuration.observeWithExemplar(d.Seconds(), traceIDFromContext(ctx), "local", "")
+}
+
+// forwardOutcome classifies the result of forwarding a call.
+func forwardOutcome(resp *http.Response, err error) string {
+	switch {
+	case err == nil && resp.StatusCode >= 400:
+		return "http_error"
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, errSaturated):
+		return "saturated"
+	}
+	return "error"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRouteMetrics tests that calls are counted by route name and tags
+func TestRouteMetrics(t *testing.T) {
+	// Setup
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if isBatch(body) {
+			w.Write([]byte(`[{"jsonrpc":"2.0","result":[],"id":1}]`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","result":[],"id":1}`))
+	}))
+	defer archive.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	config = Config{
+		DefaultURL: failing.URL,
+		Routes: []Route{
+			{Method: "eth_getLogs", URL: archive.URL, Name: "archive", Tags: []string{"archive-traffic", "analytics"}},
+			{Method: "eth_chainId", Stub: &StubResponse{Result: "0x1"}, Tags: []string{"static"}},
+		},
+	}
+	buildMethodURLMap()
+	logsBefore := callsTotal.value("archive", "analytics,archive-traffic", "ok")
+	stubBefore := callsTotal.value("eth_chainId", "static", "stub")
+	defaultBefore := callsTotal.value("default", "", "http_error")
+	batchBefore := callDuration.count("archive", "analytics,archive-traffic")
+
+	// Test
+	callProxy(t, "eth_getLogs", []interface{}{}, nil)
+	callProxy(t, "eth_chainId", nil, nil)
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_blockNumber","id":1}`)))
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`[{"jsonrpc":"2.0","method":"eth_getLogs","params":[],"id":1}]`)))
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Verify
+	if got := callsTotal.value("archive", "analytics,archive-traffic", "ok") - logsBefore; got != 2 {
+		t.Errorf("Expected 2 archive calls, got %v", got)
+	}
+	if got := callsTotal.value("eth_chainId", "static", "stub") - stubBefore; got != 1 {
+		t.Errorf("Expected 1 stub call, got %v", got)
+	}
+	if got := callsTotal.value("default", "", "http_error") - defaultBefore; got != 1 {
+		t.Errorf("Expected 1 failed default call, got %v", got)
+	}
+	if got := callDuration.count("archive", "analytics,archive-traffic") - batchBefore; got != 2 {
+		t.Errorf("Expected 2 archive latency observations, got %d", got)
+	}
+	out := rec.Body.String()
+	for _, s := range []string{
+		"# TYPE jsonrpc_proxy_calls_total counter",
+		`jsonrpc_proxy_calls_total{route="archive",tags="analytics,archive-traffic",outcome="ok"}`,
+		`jsonrpc_proxy_call_duration_seconds_bucket{route="archive",tags="analytics,archive-traffic",le="+Inf"}`,
+		`jsonrpc_proxy_call_duration_seconds_count{route="archive",tags="analytics,archive-traffic"}`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", s, out)
+		}
+	}
+}
+
+// TestHistogramBuckets tests that histogram buckets are cumulative
+func TestHistogramBuckets(t *testing.T) {
+	// Setup
+	h := &histogramVec{name: "test_seconds", labels: []string{"a"}, buckets: []float64{0.1, 1}, values: make(map[string]*histogram)}
+
+	// Test
+	h.observe(0.05, "x")
+	h.observe(0.1, "x")
+	h.observe(0.5, "x")
+	h.observe(5, "x")
+	var buf bytes.Buffer
+	h.write(&buf, false)
+
+	// Verify
+	for _, s := range []string{
+		`test_seconds_bucket{a="x",le="0.1"} 2`,
+		`test_seconds_bucket{a="x",le="1"} 3`,
+		`test_seconds_bucket{a="x",le="+Inf"} 4`,
+		`test_seconds_sum{a="x"} 5.65`,
+		`test_seconds_count{a="x"} 4`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Expected %s, got:\n%s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Micro-batching
+//
+// Some providers bill per HTTP request rather than per call. Single requests arriving
+// close together can be sent to their upstream as one batch instead:
+//
+//	micro_batch:
+//	  window: 5ms        # how long the first call waits for company (default)
+//	  max_size: 100      # calls per batch (default)
+//	  methods: [eth_getBalance, eth_call]   # default: every method
+//
+// The first single call to an upstream opens a batch, which is sent when the window
+// has passed or max_size calls (or the upstream's max_batch_sizes limit, see
+// batchsplit.go) have joined it. Each call gets a proxy-assigned id in the batch, so
+// that clients using the same ids do not collide, and each client receives its own
+// response with its own id. If the upstream does not answer with an array, every call
+// receives the upstream's response as it is.
+//
+// Batching trades up to window of latency for fewer upstream requests, so it is opt-in.
+// Notifications and calls with outbound header rules are sent on their own, since the
+// rules of the calls in a batch could differ. A batch waits for upstream capacity at
+// the priority of its first call, and is cancelled once every client in it has gone.
+
+// MicroBatchConfig configures micro-batching of single requests.
+type MicroBatchConfig struct {
+	Window  time.Duration `yaml:"window"`   // How long a batch collects calls (default: 5ms)
+	MaxSize int           `yaml:"max_size"` // Most calls in a batch (default: 100)
+	Methods []string      `yaml:"methods"`  // Methods batched (default: every method)
+}
+
+// Default micro-batching settings.
+const (
+	defaultMicroBatchWindow  = 5 * time.Millisecond
+	defaultMicroBatchMaxSize = 100
+)
+
+// microBatching is the micro-batcher, or nil when micro-batching is off.
+var microBatching *microBatcher
+
+// microBatcher collects single calls into batches per upstream.
+type microBatcher struct {
+	window  time.Duration
+	maxSize int
+	methods map[string]bool // nil for every method
+
+	mu      sync.Mutex
+	pending map[string]*microBatch // Open batch of each upstream URL
+	nextID  uint64                 // Last id assigned to a call
+}
+
+// microBatch is a batch collecting calls to an upstream.
+type microBatch struct {
+	url   string
+	name  string
+	ctx   context.Context
+	calls []*batchedCall
+	timer *time.Timer
+
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	waiting int // Calls whose client is still waiting
+}
+
+// batchedCall is a call waiting in a batch.
+type batchedCall struct {
+	id   string // Proxy-assigned id
+	body []byte // The call, with the proxy-assigned id
+	done chan batchedReply
+}
+
+// batchedReply is the upstream's answer to a batched call.
+type batchedReply struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// setupMicroBatching creates the micro-batcher.
+//
+// Returns:
+//   - error: An error if the window or maximum size is negative
+func setupMicroBatching() error {
+	microBatching = nil
+	mc := config.MicroBatch
+	if mc == nil {
+		return nil
+	}
+	if mc.Window < 0 || mc.MaxSize < 0 {
+		return fmt.Errorf("window and max_size cannot be negative")
+	}
+	b := &microBatcher{window: defaultMicroBatchWindow, maxSize: defaultMicroBatchMaxSize, pending: make(map[string]*microBatch)}
+	if mc.Window > 0 {
+		b.window = mc.Window
+	}
+	if mc.MaxSize > 0 {
+		b.maxSize = mc.MaxSize
+	}
+	if len(mc.Methods) > 0 {
+		b.methods = make(map[string]bool)
+		for _, method := range mc.Methods {
+			b.methods[method] = true
+		}
+	}
+	microBatching = b
+	log.Printf("Batching single calls to upstreams within %v, up to %d calls", b.window, b.maxSize)
+	return nil
+}
+
+// forwardSingle forwards a single call, through the micro-batcher when it applies.
+//
+// Parameters:
+//   - ctx: The client request's context
+//   - upstream: The upstream serving the call, for its name and URL
+//   - method: The method called
+//   - headers: The call's outbound header changes, or nil
+//   - body: The call
+//
+// Returns:
+//   - *http.Response: The upstream's response to the call
+//   - error: An error if the call could not be forwarded
+func forwardSingle(ctx context.Context, upstream Upstream, method string, headers *outboundHeaders, body []byte) (*http.Response, error) {
+	b := microBatching
+	if b == nil || headers != nil || (b.methods != nil && !b.methods[method]) {
+		return forwardRequest(withOutboundHeaders(ctx, headers), upstream.URL, body)
+	}
+	var call map[string]json.RawMessage
+	if json.Unmarshal(body, &call) != nil || call["id"] == nil || string(call["id"]) == "null" {
+		return forwardRequest(ctx, upstream.URL, body)
+	}
+	return b.forward(withCompressedPassthrough(ctx, false), upstream, call)
+}
+
+// forward adds a call to the upstream's open batch and waits for its response.
+func (b *microBatcher) forward(ctx context.Context, upstream Upstream, call map[string]json.RawMessage) (*http.Response, error) {
+	clientID := call["id"]
+	b.mu.Lock()
+	b.nextID++
+	bc := &batchedCall{id: strconv.FormatUint(b.nextID, 10), done: make(chan batchedReply, 1)}
+	call["id"] = json.RawMessage(bc.id)
+	bc.body, _ = json.Marshal(call)
+
+	mb := b.pending[upstream.URL]
+	if mb == nil || mb.ctx.Err() != nil {
+		batchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		mb = &microBatch{url: upstream.URL, name: upstream.Name, ctx: batchCtx, cancel: cancel}
+		b.pending[upstream.URL] = mb
+		mb.timer = time.AfterFunc(b.window, func() { b.flush(mb) })
+	}
+	mb.calls = append(mb.calls, bc)
+	mb.mu.Lock()
+	mb.waiting++
+	mb.mu.Unlock()
+	full := len(mb.calls) >= min(b.maxSize, maxBatchSize(upstream.URL, upstream.Name))
+	if full {
+		delete(b.pending, upstream.URL)
+	}
+	b.mu.Unlock()
+	if full && mb.timer.Stop() {
+		go b.flush(mb)
+	}
+
+	select {
+	case reply := <-bc.done:
+		if reply.err != nil {
+			return nil, reply.err
+		}
+		var response map[string]json.RawMessage
+		if json.Unmarshal(reply.body, &response) == nil && string(response["id"]) == bc.id {
+			response["id"] = clientID
+			reply.body, _ = json.Marshal(response)
+		}
+		header := reply.header.Clone()
+		header.Del("Content-Length")
+		return &http.Response{
+			StatusCode:    reply.status,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(reply.body)),
+			ContentLength: int64(len(reply.body)),
+		}, nil
+	case <-ctx.Done():
+		mb.mu.Lock()
+		mb.waiting--
+		if mb.waiting == 0 {
+			mb.cancel()
+		}
+		mb.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// flush closes a batch and sends it to its upstream.
+func (b *microBatcher) flush(mb *microBatch) {
+	b.mu.Lock()
+	if b.pending[mb.url] == mb {
+		delete(b.pending, mb.url)
+	}
+	b.mu.Unlock()
+	defer mb.cancel()
+
+	bodies := make([][]byte, len(mb.calls))
+	for i, bc := range mb.calls {
+		bodies[i] = bc.body
+	}
+	logDebug("router", "Sending %d single calls to %s as one batch", len(mb.calls), mb.name)
+	resp, err := forwardRequest(mb.ctx, mb.url, append(append([]byte("["), bytes.Join(bodies, []byte(","))...), ']'))
+	var body []byte
+	if err == nil {
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err != nil {
+		for _, bc := range mb.calls {
+			bc.done <- batchedReply{err: err}
+		}
+		return
+	}
+
+	var responses []json.RawMessage
+	if json.Unmarshal(body, &responses) != nil {
+		// Not a batch response, such as an HTTP error: every call gets it
+		for _, bc := range mb.calls {
+			bc.done <- batchedReply{status: resp.StatusCode, header: resp.Header, body: body}
+		}
+		return
+	}
+	byID := make(map[string]json.RawMessage, len(responses))
+	for _, response := range responses {
+		var r struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if json.Unmarshal(response, &r) == nil {
+			byID[string(r.ID)] = response
+		}
+	}
+	for _, bc := range mb.calls {
+		if response, ok := byID[bc.id]; ok {
+			bc.done <- batchedReply{status: resp.StatusCode, header: resp.Header, body: response}
+		} else {
+			bc.done <- batchedReply{err: fmt.Errorf("batch response from %s lacks the call", mb.name)}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMicroBatching tests sending concurrent single calls as one batch and answering each client
+func TestMicroBatching(t *testing.T) {
+	// Setup
+	var mu sync.Mutex
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var requests []map[string]interface{}
+		json.Unmarshal(body, &requests)
+		mu.Lock()
+		sizes = append(sizes, len(requests))
+		mu.Unlock()
+		responses := make([]string, len(requests))
+		for i, req := range requests {
+			responses[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":%q}`, req["id"], req["params"].([]interface{})[0])
+		}
+		w.Write([]byte("[" + strings.Join(responses, ",") + "]"))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, MicroBatch: &MicroBatchConfig{Window: 50 * time.Millisecond}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		microBatching = nil
+	}()
+	if err := setupMicroBatching(); err != nil {
+		t.Fatalf("Failed to set up micro-batching: %v", err)
+	}
+
+	// Test
+	bodies := make([]string, 3)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{"jsonrpc":"2.0","id":"client","method":"eth_getBalance","params":["0x%d"]}`, i))))
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	// Verify
+	if fmt.Sprint(sizes) != "[3]" {
+		t.Errorf("Expected one batch of 3 calls, got %v", sizes)
+	}
+	for i, body := range bodies {
+		var response JSONRPCResponse
+		if err := json.Unmarshal([]byte(body), &response); err != nil || response.ID != "client" || response.Result != fmt.Sprintf("0x%d", i) {
+			t.Errorf("Expected the result of call %d with the client's id, got %s", i, body)
+		}
+	}
+}
+
+// TestMicroBatchingErrors tests answering every batched call with a non-batch upstream response
+func TestMicroBatchingErrors(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32005,"message":"rate limited"}}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, MicroBatch: &MicroBatchConfig{MaxSize: 1}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		microBatching = nil
+	}()
+	if err := setupMicroBatching(); err != nil {
+		t.Fatalf("Failed to set up micro-batching: %v", err)
+	}
+
+	// Test
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_chainId","params":[]}`)))
+
+	// Verify
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "rate limited") {
+		t.Errorf("Expected the upstream's error, got %d %s", w.Code, w.Body.String())
+	}
+	config.MicroBatch.Window = -time.Second
+	if err := setupMicroBatching(); err == nil {
+		t.Errorf("Expected a negative window to be rejected")
+	}
+}
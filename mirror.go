@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// Traffic mirroring
+//
+// A route with a mirror duplicates the calls it carries to a shadow upstream, so a new
+// provider or self-hosted node can be qualified against production traffic. Mirrored
+// calls are sent in the background after the client has been answered and their
+// responses are discarded; with diff enabled, results that differ from the primary
+// upstream's are logged. Mirroring never delays or fails client requests: when too many
+// mirrored calls are in flight, new ones are dropped.
+
+// MirrorConfig configures traffic mirroring for a route.
+type MirrorConfig struct {
+	URL        string        `yaml:"url"`         // Shadow upstream URL
+	Name       string        `yaml:"name"`        // Human-readable name for logging (optional)
+	Transport  string        `yaml:"transport"`   // Registered transport for the shadow upstream (optional)
+	SampleRate float64       `yaml:"sample_rate"` // Fraction of calls to mirror, 0 to 1 (default: 1)
+	Diff       bool          `yaml:"diff"`        // Log calls whose result differs from the primary's
+	Timeout    time.Duration `yaml:"timeout"`     // Timeout for mirrored calls (default: 10s)
+}
+
+// maxMirrorsInFlight bounds the mirrored calls running at once.
+const maxMirrorsInFlight = 64
+
+// mirrorSlots limits concurrent mirrored calls.
+var mirrorSlots = make(chan struct{}, maxMirrorsInFlight)
+
+// mirroredCall is a batch call waiting to be duplicated to its route's mirror.
+type mirroredCall struct {
+	mirror *MirrorConfig
+	method string
+	id     interface{}
+	body   []byte
+}
+
+// mirrorFor returns the mirror of the upstream's route if this call is sampled.
+func mirrorFor(upstream Upstream) *MirrorConfig {
+	if upstream.Route == nil || upstream.Route.Mirror == nil {
+		return nil
+	}
+	m := upstream.Route.Mirror
+	if m.SampleRate > 0 && m.SampleRate < 1 && rand.Float64() >= m.SampleRate {
+		return nil
+	}
+	return m
+}
+
+// mirrorCall sends a call to the mirror in the background. If the mirror diffs, primary
+// is the primary upstream's response object for the call.
+//
+// Parameters:
+//   - m: The mirror
+//   - method: The method of the call (for logging)
+//   - body: The call as sent to the primary upstream
+//   - primary: The primary response object (only used when diffing)
+func mirrorCall(m *MirrorConfig, method string, body, primary []byte) {
+	select {
+	case mirrorSlots <- struct{}{}:
+	default:
+		logWarn("mirror", "Mirror %s saturated, dropping mirrored method '%s'", mirrorName(m), method)
+		return
+	}
+
+	go func() {
+		defer func() { <-mirrorSlots }()
+
+		timeout := m.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(withPriority(context.Background(), priorityLow), timeout)
+		defer cancel()
+
+		resp, err := forwardRequest(ctx, m.URL, body)
+		if err != nil {
+			logWarn("mirror", "Mirror %s failed for method '%s': %v", mirrorName(m), method, err)
+			return
+		}
+		defer resp.Body.Close()
+		if !m.Diff {
+			io.Copy(io.Discard, resp.Body)
+			return
+		}
+
+		shadow, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logWarn("mirror", "Mirror %s failed for method '%s': %v", mirrorName(m), method, err)
+			return
+		}
+		if !sameOutcome(primary, shadow) {
+			logInfo("mirror", "Mirror %s differs for method '%s': primary %s, mirror %s",
+				mirrorName(m), method, truncate(string(bytes.TrimSpace(primary)), 300), truncate(string(bytes.TrimSpace(shadow)), 300))
+		}
+	}()
+}
+
+// mirrorName returns the mirror's display name.
+func mirrorName(m *MirrorConfig) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return redactURL(m.URL)
+}
+
+// sameOutcome reports whether two JSON-RPC response objects carry the same result or
+// the same error code. IDs and formatting are ignored.
+func sameOutcome(a, b []byte) bool {
+	var ra, rb struct {
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	if json.Unmarshal(a, &ra) != nil || json.Unmarshal(b, &rb) != nil {
+		return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+	}
+	if ra.Error != nil || rb.Error != nil {
+		return ra.Error != nil && rb.Error != nil && ra.Error.Code == rb.Error.Code
+	}
+
+	ca, okA := canonicalJSON(ra.Result)
+	cb, okB := canonicalJSON(rb.Result)
+	if !okA || !okB {
+		return bytes.Equal(ra.Result, rb.Result)
+	}
+	return bytes.Equal(ca, cb)
+}
+
+// canonicalJSON re-encodes a JSON value with sorted keys and no insignificant
+// whitespace, keeping numbers exactly as written.
+func canonicalJSON(raw []byte) ([]byte, bool) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, false
+	}
+	out, err := json.Marshal(v)
+	return out, err == nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMirror tests duplicating calls to a shadow upstream and logging differences
+func TestMirror(t *testing.T) {
+	// Setup
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.HasPrefix(body, []byte("[")) {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":2,"result":"0x1"}]`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"a":1,"b":2}}`))
+	}))
+	defer primary.Close()
+
+	mirrored := make(chan JSONRPCRequest, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req JSONRPCRequest
+		json.Unmarshal(body, &req)
+		mirrored <- req
+		if req.ID == float64(2) {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":2,"result":"0x2"}`))
+			return
+		}
+		// Same result with different formatting and key order
+		w.Write([]byte(`{"id":1, "jsonrpc":"2.0", "result":{"b":2,"a":1}}`))
+	}))
+	defer shadow.Close()
+
+	config = Config{
+		DefaultURL: primary.URL,
+		Routes: []Route{
+			{Method: "eth_call", URL: primary.URL, Mirror: &MirrorConfig{URL: shadow.URL, Name: "shadow", Diff: true}},
+		},
+	}
+	buildMethodURLMap()
+
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	log.SetOutput(&lockedWriter{w: &logs, mu: &logsMu})
+	defer log.SetOutput(os.Stderr)
+
+	wait := func() JSONRPCRequest {
+		select {
+		case req := <-mirrored:
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for the mirrored call")
+		}
+		return JSONRPCRequest{}
+	}
+
+	// Test single request with identical results
+	callProxy(t, "eth_call", []interface{}{}, nil)
+	if req := wait(); req.Method != "eth_call" {
+		t.Errorf("Expected eth_call to be mirrored, got %s", req.Method)
+	}
+
+	// Test batch request with differing results
+	batch := []byte(`[{"jsonrpc":"2.0","method":"eth_call","params":[],"id":2}]`)
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader(batch)))
+	wait()
+
+	// Test unmirrored methods
+	callProxy(t, "eth_blockNumber", []interface{}{}, nil)
+
+	// Verify
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		logsMu.Lock()
+		out := logs.String()
+		logsMu.Unlock()
+		if strings.Contains(out, "Mirror shadow differs") || time.Now().After(deadline) {
+			if strings.Count(out, "Mirror shadow differs") != 1 || !strings.Contains(out, `"0x2"`) {
+				t.Errorf("Expected exactly one difference (the batch call) to be logged, got:\n%s", out)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case req := <-mirrored:
+		t.Errorf("Expected only eth_call to be mirrored, got %s", req.Method)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// lockedWriter serializes writes to a shared buffer.
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Provider error normalization
+//
+// Providers report the same failure in different ways: Infura returns -32005 for rate
+// limits, Alchemy returns 429 as a JSON-RPC code, QuickNode uses -32007, and some
+// providers answer with a bare HTTP 429 and a text body. When normalization is enabled,
+// every error in an upstream response is matched against an ordered list of rules
+// (configured rules first, then the built-in ones) and rewritten to a consistent
+// code and message. Error bodies that are not JSON-RPC at all are turned into proper
+// JSON-RPC error responses.
+
+// ErrorNormalizationConfig configures provider error normalization.
+type ErrorNormalizationConfig struct {
+	Enabled        bool        `yaml:"enabled"`         // Rewrite upstream errors using the rules
+	DisableBuiltin bool        `yaml:"disable_builtin"` // Do not apply the built-in rules
+	Rules          []ErrorRule `yaml:"rules"`           // Rules checked before the built-in ones
+}
+
+// ErrorRule maps matching upstream errors to a normalized code and message.
+// All configured match conditions must hold for the rule to apply.
+type ErrorRule struct {
+	Name           string `yaml:"name"`            // Rule name (for logging)
+	Codes          []int  `yaml:"codes"`           // Upstream JSON-RPC error codes to match (optional)
+	HTTPStatus     []int  `yaml:"http_status"`     // Upstream HTTP status codes to match (optional)
+	MessagePattern string `yaml:"message_pattern"` // Regular expression matched against the error message (optional)
+	Code           int    `yaml:"code"`            // Normalized error code
+	Message        string `yaml:"message"`         // Normalized message; the upstream message is kept if empty
+	DropData       bool   `yaml:"drop_data"`       // Remove the provider-specific data field
+
+	pattern *regexp.Regexp
+}
+
+// builtinErrorRules cover the common provider error shapes. Codes follow EIP-1474.
+var builtinErrorRules = []ErrorRule{
+	{
+		Name:           "range-limit",
+		MessagePattern: `(?i)block range|range (is )?too (large|wide)|query returned more than|more than \d+ (logs|results)|response size|exceeds? .*max.*range`,
+		Code:           -32005,
+		Message:        "query exceeds provider limits",
+	},
+	{
+		Name:           "rate-limit",
+		MessagePattern: `(?i)rate.?limit|too many requests|request limit|exceeded .*(quota|limit|capacity|units)|compute units|throughput|daily request count`,
+		Code:           -32005,
+		Message:        "rate limit exceeded",
+	},
+	{Name: "rate-limit-status", HTTPStatus: []int{http.StatusTooManyRequests}, Code: -32005, Message: "rate limit exceeded"},
+	{Name: "rate-limit-code", Codes: []int{429, -32005, -32007, -32029}, Code: -32005, Message: "rate limit exceeded"},
+	{
+		Name:           "timeout",
+		MessagePattern: `(?i)time(d)? ?out|deadline exceeded`,
+		Code:           -32002,
+		Message:        "request timed out",
+	},
+	{Name: "timeout-status", HTTPStatus: []int{http.StatusRequestTimeout, http.StatusGatewayTimeout}, Code: -32002, Message: "request timed out"},
+	{
+		Name:           "method-unsupported",
+		MessagePattern: `(?i)method .*(not (found|supported|available)|does not exist|is not whitelisted)|unsupported method|unknown method`,
+		Code:           -32601,
+		Message:        "the method does not exist/is not available",
+	},
+}
+
+// activeErrorRules holds the compiled rules in matching order.
+var activeErrorRules []ErrorRule
+
+// setupErrorNormalization compiles the configured and built-in rules and registers
+// the response transform when normalization is enabled.
+//
+// Returns:
+//   - error: An error if a rule's message pattern is not a valid regular expression
+func setupErrorNormalization() error {
+	activeErrorRules = nil
+	unregisterResponseTransform("normalize-errors")
+
+	nc := config.ErrorNormalization
+	if nc == nil || !nc.Enabled {
+		return nil
+	}
+
+	rules := append([]ErrorRule{}, nc.Rules...)
+	if !nc.DisableBuiltin {
+		rules = append(rules, builtinErrorRules...)
+	}
+	for i := range rules {
+		if rules[i].MessagePattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(rules[i].MessagePattern)
+		if err != nil {
+			return fmt.Errorf("error rule %d (%s): invalid message_pattern: %w", i, rules[i].Name, err)
+		}
+		rules[i].pattern = re
+	}
+	activeErrorRules = rules
+
+	registerResponseTransform("normalize-errors", normalizeErrorResponse)
+	return nil
+}
+
+// matches reports whether the rule applies to an error.
+func (rule *ErrorRule) matches(code, status int, message string) bool {
+	if len(rule.Codes) == 0 && len(rule.HTTPStatus) == 0 && rule.pattern == nil {
+		return false
+	}
+	if len(rule.Codes) > 0 && !containsInt(rule.Codes, code) {
+		return false
+	}
+	if len(rule.HTTPStatus) > 0 && !containsInt(rule.HTTPStatus, status) {
+		return false
+	}
+	if rule.pattern != nil && !rule.pattern.MatchString(message) {
+		return false
+	}
+	return true
+}
+
+// normalizeError rewrites an error object according to the first matching rule.
+// It reports whether a rule matched.
+func normalizeError(e *JSONRPCError, status int) bool {
+	for i := range activeErrorRules {
+		rule := &activeErrorRules[i]
+		if !rule.matches(e.Code, status, e.Message) {
+			continue
+		}
+		e.Code = rule.Code
+		if rule.Message != "" {
+			e.Message = rule.Message
+		}
+		if rule.DropData {
+			e.Data = nil
+		}
+		return true
+	}
+	return false
+}
+
+// normalizeErrorResponse is the response transform that normalizes errors in one
+// JSON-RPC response object. Non-JSON-RPC error bodies are replaced by a JSON-RPC error.
+func normalizeErrorResponse(call *JSONRPCRequest, status int, body []byte) []byte {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil || (resp["result"] == nil && resp["error"] == nil) {
+		if status < http.StatusBadRequest {
+			return body
+		}
+		// Not a JSON-RPC response: build one from the HTTP status and body text
+		rpcErr := &JSONRPCError{
+			Code:    -32603,
+			Message: fmt.Sprintf("upstream error: HTTP %d: %s", status, strings.TrimSpace(truncate(string(body), 200))),
+		}
+		normalizeError(rpcErr, status)
+		data, err := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Error: rpcErr})
+		if err != nil {
+			return body
+		}
+		return data
+	}
+
+	rawErr, ok := resp["error"]
+	if !ok {
+		return body
+	}
+
+	var rpcErr JSONRPCError
+	if err := json.Unmarshal(rawErr, &rpcErr); err != nil {
+		// Some providers send the error as a bare string
+		var message string
+		if json.Unmarshal(rawErr, &message) != nil {
+			return body
+		}
+		rpcErr = JSONRPCError{Code: -32603, Message: message}
+		normalizeError(&rpcErr, status)
+	} else if !normalizeError(&rpcErr, status) {
+		return body
+	}
+
+	data, err := json.Marshal(&rpcErr)
+	if err != nil {
+		return body
+	}
+	resp["error"] = data
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// containsInt reports whether v is in list.
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
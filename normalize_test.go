@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestNormalizeErrorResponse tests built-in and configured error rules
+func TestNormalizeErrorResponse(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default-url.com",
+		ErrorNormalization: &ErrorNormalizationConfig{
+			Enabled: true,
+			Rules: []ErrorRule{
+				{Name: "nonce", MessagePattern: `(?i)nonce too low`, Code: -32003, Message: "nonce too low"},
+			},
+		},
+	}
+	if err := setupErrorNormalization(); err != nil {
+		t.Fatalf("Failed to set up error normalization: %v", err)
+	}
+	defer func() {
+		config.ErrorNormalization = nil
+		setupErrorNormalization()
+	}()
+
+	testCases := []struct {
+		name            string
+		status          int
+		body            string
+		expectedCode    int
+		expectedMessage string
+	}{
+		{"Infura rate limit", 200, `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"daily request count exceeded, request rate limited"}}`, -32005, "rate limit exceeded"},
+		{"Alchemy rate limit", 200, `{"jsonrpc":"2.0","id":1,"error":{"code":429,"message":"Your app has exceeded its compute units per second capacity"}}`, -32005, "rate limit exceeded"},
+		{"Plain text 429", 429, `Too Many Requests`, -32005, "rate limit exceeded"},
+		{"Log range limit", 200, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"query returned more than 10000 results"}}`, -32005, "query exceeds provider limits"},
+		{"Unsupported method", 200, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"the method trace_block does not exist/is not available"}}`, -32601, "the method does not exist/is not available"},
+		{"Configured rule", 200, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"Nonce too low"}}`, -32003, "nonce too low"},
+		{"Bad gateway", 502, `<html>bad gateway</html>`, -32603, "upstream error: HTTP 502: <html>bad gateway</html>"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			out := normalizeErrorResponse(&JSONRPCRequest{ID: float64(1)}, tc.status, []byte(tc.body))
+
+			// Verify
+			var resp JSONRPCResponse
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatalf("Failed to parse normalized response: %v\n%s", err, string(out))
+			}
+			if resp.Error == nil {
+				t.Fatalf("Expected an error in %s", string(out))
+			}
+			if resp.Error.Code != tc.expectedCode || resp.Error.Message != tc.expectedMessage {
+				t.Errorf("Expected %d %q, got %d %q", tc.expectedCode, tc.expectedMessage, resp.Error.Code, resp.Error.Message)
+			}
+			if resp.ID != float64(1) {
+				t.Errorf("Expected ID 1 to be preserved, got %v", resp.ID)
+			}
+		})
+	}
+
+	// Successful responses are untouched
+	ok := []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+	if out := normalizeErrorResponse(&JSONRPCRequest{ID: 1}, 200, ok); !bytes.Equal(out, ok) {
+		t.Errorf("Expected successful response to be untouched, got %s", string(out))
+	}
+}
+
+// TestNormalizeErrorsThroughProxy tests normalization of a single proxied request
+func TestNormalizeErrorsThroughProxy(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer server.Close()
+
+	config = Config{DefaultURL: server.URL, ErrorNormalization: &ErrorNormalizationConfig{Enabled: true}}
+	buildMethodURLMap()
+	if err := setupErrorNormalization(); err != nil {
+		t.Fatalf("Failed to set up error normalization: %v", err)
+	}
+	defer func() {
+		config.ErrorNormalization = nil
+		setupErrorNormalization()
+	}()
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"eth_call","params":[],"id":"abc"}`)))
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, req)
+
+	// Verify
+	body, _ := io.ReadAll(w.Result().Body)
+	var resp JSONRPCResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Expected JSON-RPC error body, got %s", string(body))
+	}
+	if resp.ID != "abc" || resp.Error == nil || resp.Error.Code != -32005 {
+		t.Errorf("Expected rate limit error for ID abc, got %s", string(body))
+	}
+	if ct := w.Result().Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", ct)
+	}
+}
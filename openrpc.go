@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// OpenRPC discovery document
+//
+// When enabled, the proxy describes the methods it serves as an OpenRPC document,
+// returned by the rpc.discover method and by GET /openrpc.json. The document is
+// generated from the configuration: the standard methods forwarded by the default
+// route, every routed method, the methods the proxy answers itself, and any custom
+// methods documented under openrpc.methods. Route descriptions become method
+// descriptions. Each method carries an x-served-by extension naming the upstreams
+// that serve it, or "proxy" for local methods, so tooling can tell where custom
+// methods live. Upstreams are shown as in proxy_routes.
+
+// openRPCVersion is the version of the OpenRPC specification the document follows.
+const openRPCVersion = "1.2.6"
+
+// openRPCPath is the HTTP path serving the document.
+const openRPCPath = "/openrpc.json"
+
+// OpenRPCConfig configures the discovery document.
+type OpenRPCConfig struct {
+	Enabled bool                        `yaml:"enabled"` // Serve rpc.discover and /openrpc.json
+	Title   string                      `yaml:"title"`   // Title of the document (default: "JSON-RPC proxy")
+	Methods map[string]OpenRPCMethodDoc `yaml:"methods"` // Documentation of custom methods by name (optional)
+}
+
+// OpenRPCMethodDoc documents a method in the configuration.
+type OpenRPCMethodDoc struct {
+	Summary string   `yaml:"summary"` // Short description of the method
+	Params  []string `yaml:"params"`  // Names of the positional parameters
+	Result  string   `yaml:"result"`  // Name of the result (default: "result")
+}
+
+// openRPCContentDescriptor is a parameter or result in the document.
+type openRPCContentDescriptor struct {
+	Name     string                 `json:"name"`
+	Required bool                   `json:"required,omitempty"`
+	Schema   map[string]interface{} `json:"schema"`
+}
+
+// openRPCMethod is a method in the document.
+type openRPCMethod struct {
+	Name        string                     `json:"name"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Params      []openRPCContentDescriptor `json:"params"`
+	Result      openRPCContentDescriptor   `json:"result"`
+	ServedBy    []string                   `json:"x-served-by"`
+}
+
+// openRPCDocument is the discovery document.
+type openRPCDocument struct {
+	OpenRPC string `json:"openrpc"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Methods []openRPCMethod `json:"methods"`
+}
+
+// Schemas of the common Ethereum value types.
+var (
+	quantitySchema = map[string]interface{}{"type": "string", "pattern": "^0x(0|[1-9a-f][0-9a-f]*)$"}
+	addressSchema  = map[string]interface{}{"type": "string", "pattern": "^0x[0-9a-fA-F]{40}$"}
+	hashSchema     = map[string]interface{}{"type": "string", "pattern": "^0x[0-9a-f]{64}$"}
+	bytesSchema    = map[string]interface{}{"type": "string", "pattern": "^0x[0-9a-f]*$"}
+	booleanSchema  = map[string]interface{}{"type": "boolean"}
+	objectSchema   = map[string]interface{}{"type": "object"}
+	arraySchema    = map[string]interface{}{"type": "array"}
+	anySchema      = map[string]interface{}{}
+	blockSchema    = map[string]interface{}{"oneOf": []interface{}{
+		quantitySchema,
+		map[string]interface{}{"type": "string", "enum": []string{"earliest", "latest", "pending", "safe", "finalized"}},
+	}}
+)
+
+// knownMethod is the schema of a well-known method.
+type knownMethod struct {
+	summary string
+	params  []openRPCContentDescriptor
+	result  openRPCContentDescriptor
+}
+
+// param and optional build the parameter descriptors of knownMethods.
+func param(name string, schema map[string]interface{}) openRPCContentDescriptor {
+	return openRPCContentDescriptor{Name: name, Required: true, Schema: schema}
+}
+
+func optional(name string, schema map[string]interface{}) openRPCContentDescriptor {
+	return openRPCContentDescriptor{Name: name, Schema: schema}
+}
+
+// knownMethods are the standard methods documented by default, since they are
+// forwarded by the default route without being configured.
+var knownMethods = map[string]knownMethod{
+	"eth_chainId":               {"Returns the chain ID", nil, param("chainId", quantitySchema)},
+	"eth_blockNumber":           {"Returns the number of the most recent block", nil, param("blockNumber", quantitySchema)},
+	"eth_gasPrice":              {"Returns the current gas price", nil, param("gasPrice", quantitySchema)},
+	"eth_maxPriorityFeePerGas":  {"Returns the suggested priority fee", nil, param("maxPriorityFeePerGas", quantitySchema)},
+	"eth_getBalance":            {"Returns the balance of an account", []openRPCContentDescriptor{param("address", addressSchema), param("block", blockSchema)}, param("balance", quantitySchema)},
+	"eth_getTransactionCount":   {"Returns the nonce of an account", []openRPCContentDescriptor{param("address", addressSchema), param("block", blockSchema)}, param("transactionCount", quantitySchema)},
+	"eth_getCode":               {"Returns the code of a contract", []openRPCContentDescriptor{param("address", addressSchema), param("block", blockSchema)}, param("code", bytesSchema)},
+	"eth_getStorageAt":          {"Returns the value of a storage slot", []openRPCContentDescriptor{param("address", addressSchema), param("position", quantitySchema), param("block", blockSchema)}, param("value", bytesSchema)},
+	"eth_call":                  {"Executes a call without creating a transaction", []openRPCContentDescriptor{param("transaction", objectSchema), optional("block", blockSchema)}, param("returnData", bytesSchema)},
+	"eth_estimateGas":           {"Estimates the gas a transaction needs", []openRPCContentDescriptor{param("transaction", objectSchema), optional("block", blockSchema)}, param("gas", quantitySchema)},
+	"eth_getBlockByNumber":      {"Returns a block by number", []openRPCContentDescriptor{param("block", blockSchema), param("hydrated", booleanSchema)}, param("block", objectSchema)},
+	"eth_getBlockByHash":        {"Returns a block by hash", []openRPCContentDescriptor{param("blockHash", hashSchema), param("hydrated", booleanSchema)}, param("block", objectSchema)},
+	"eth_getTransactionByHash":  {"Returns a transaction by hash", []openRPCContentDescriptor{param("transactionHash", hashSchema)}, param("transaction", objectSchema)},
+	"eth_getTransactionReceipt": {"Returns the receipt of a transaction", []openRPCContentDescriptor{param("transactionHash", hashSchema)}, param("receipt", objectSchema)},
+	"eth_getLogs":               {"Returns the logs matching a filter", []openRPCContentDescriptor{param("filter", objectSchema)}, param("logs", arraySchema)},
+	"eth_feeHistory":            {"Returns historical fee data", []openRPCContentDescriptor{param("blockCount", quantitySchema), param("newestBlock", blockSchema), optional("rewardPercentiles", arraySchema)}, param("feeHistory", objectSchema)},
+	"eth_sendRawTransaction":    {"Submits a signed transaction", []openRPCContentDescriptor{param("transaction", bytesSchema)}, param("transactionHash", hashSchema)},
+	"net_version":               {"Returns the network ID", nil, param("networkId", map[string]interface{}{"type": "string"})},
+	"web3_clientVersion":        {"Returns the client version", nil, param("clientVersion", map[string]interface{}{"type": "string"})},
+}
+
+// setupOpenRPC registers or removes rpc.discover according to the configuration.
+func setupOpenRPC() {
+	if config.OpenRPC != nil && config.OpenRPC.Enabled {
+		registerLocalMethod("rpc.discover", handleRPCDiscover)
+	} else {
+		unregisterLocalMethod("rpc.discover")
+	}
+}
+
+// handleRPCDiscover returns the discovery document.
+func handleRPCDiscover(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	return buildOpenRPCDocument(), nil
+}
+
+// handleOpenRPC serves the discovery document over HTTP.
+func handleOpenRPC(w http.ResponseWriter, r *http.Request) {
+	if config.OpenRPC == nil || !config.OpenRPC.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildOpenRPCDocument())
+}
+
+// buildOpenRPCDocument generates the discovery document from the configuration.
+func buildOpenRPCDocument() openRPCDocument {
+	var doc openRPCDocument
+	doc.OpenRPC = openRPCVersion
+	doc.Info.Title = "JSON-RPC proxy"
+	if config.OpenRPC != nil && config.OpenRPC.Title != "" {
+		doc.Info.Title = config.OpenRPC.Title
+	}
+	doc.Info.Version = version
+
+	servedBy := make(map[string][]string)
+	descriptions := make(map[string]string)
+	for name := range knownMethods {
+		servedBy[name] = nil
+	}
+	for _, route := range config.Routes {
+		if route.Method != "" {
+			servedBy[route.Method] = append(servedBy[route.Method], routeLabel(route))
+			if descriptions[route.Method] == "" {
+				descriptions[route.Method] = route.Description
+			}
+		}
+	}
+	if config.OpenRPC != nil {
+		for name := range config.OpenRPC.Methods {
+			if _, ok := servedBy[name]; !ok {
+				servedBy[name] = nil
+			}
+		}
+	}
+	localMethodsMu.RLock()
+	for name := range localMethods {
+		servedBy[name] = []string{"proxy"}
+	}
+	localMethodsMu.RUnlock()
+
+	names := make([]string, 0, len(servedBy))
+	for name := range servedBy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	doc.Methods = make([]openRPCMethod, 0, len(names))
+	for _, name := range names {
+		upstreams := servedBy[name]
+		if len(upstreams) == 0 {
+			upstreams = []string{defaultRouteLabel()}
+		}
+		m := describeMethod(name, upstreams)
+		m.Description = descriptions[name]
+		doc.Methods = append(doc.Methods, m)
+	}
+	return doc
+}
+
+// describeMethod builds the entry of a method, preferring configured documentation
+// over the built-in schemas. Undocumented methods accept any parameters.
+func describeMethod(name string, servedBy []string) openRPCMethod {
+	m := openRPCMethod{
+		Name:     name,
+		Params:   []openRPCContentDescriptor{},
+		Result:   openRPCContentDescriptor{Name: "result", Schema: anySchema},
+		ServedBy: servedBy,
+	}
+	if config.OpenRPC != nil {
+		if d, ok := config.OpenRPC.Methods[name]; ok {
+			m.Summary = d.Summary
+			for _, p := range d.Params {
+				m.Params = append(m.Params, optional(p, anySchema))
+			}
+			if d.Result != "" {
+				m.Result.Name = d.Result
+			}
+			return m
+		}
+	}
+	if k, ok := knownMethods[name]; ok {
+		m.Summary = k.summary
+		if k.params != nil {
+			m.Params = k.params
+		}
+		m.Result = k.result
+	}
+	return m
+}
+
+// routeLabel returns the name under which a route's upstream is shown to clients.
+func routeLabel(route Route) string {
+	switch {
+	case route.Pool != "":
+		return "pool:" + route.Pool
+	case route.BlueGreen != nil:
+		return "blue_green:" + route.BlueGreen.Blue + "/" + route.BlueGreen.Green
+	case route.Stub != nil:
+		return "stub"
+	case route.Name != "":
+		return route.Name
+	}
+	return displayURL(route.URL)
+}
+
+// defaultRouteLabel returns the name under which the default upstream is shown to clients.
+func defaultRouteLabel() string {
+	if config.DefaultPool != "" {
+		return "pool:" + config.DefaultPool
+	}
+	if config.DefaultName != "" {
+		return config.DefaultName
+	}
+	return displayURL(config.DefaultURL)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOpenRPCDocument tests the discovery document served by rpc.discover and /openrpc.json
+func TestOpenRPCDocument(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL:  "https://mainnet.example.com/v3/secret-key",
+		DefaultName: "mainnet",
+		Routes: []Route{
+			{Method: "eth_getLogs", URL: "https://archive.example.com/key"},
+			{Method: "linea_estimateGas", URL: "https://linea.example.com", Name: "linea"},
+		},
+		OpenRPC: &OpenRPCConfig{
+			Enabled: true,
+			Title:   "Test proxy",
+			Methods: map[string]OpenRPCMethodDoc{
+				"linea_estimateGas": {Summary: "Estimates gas and fees", Params: []string{"transaction"}, Result: "estimate"},
+			},
+		},
+	}
+	buildMethodURLMap()
+	setupOpenRPC()
+	defer func() {
+		config.OpenRPC = nil
+		setupOpenRPC()
+	}()
+
+	// Test
+	var doc openRPCDocument
+	if err := callProxy(t, "rpc.discover", nil, &doc); err != nil {
+		t.Fatalf("rpc.discover failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handleOpenRPC(rec, httptest.NewRequest("GET", openRPCPath, nil))
+	var served openRPCDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Invalid document from %s: %v", openRPCPath, err)
+	}
+
+	// Verify
+	if doc.OpenRPC != openRPCVersion || doc.Info.Title != "Test proxy" || doc.Info.Version != version {
+		t.Errorf("Unexpected document header: %+v", doc)
+	}
+	if len(served.Methods) != len(doc.Methods) {
+		t.Errorf("Expected %s to match rpc.discover, got %d methods instead of %d", openRPCPath, len(served.Methods), len(doc.Methods))
+	}
+	methods := make(map[string]openRPCMethod)
+	for i, m := range doc.Methods {
+		if i > 0 && doc.Methods[i-1].Name >= m.Name {
+			t.Errorf("Expected methods sorted by name, got %s after %s", m.Name, doc.Methods[i-1].Name)
+		}
+		methods[m.Name] = m
+	}
+
+	testCases := []struct {
+		method   string
+		servedBy string
+		params   int
+		result   string
+	}{
+		{"eth_chainId", "mainnet", 0, "chainId"},
+		{"eth_getBalance", "mainnet", 2, "balance"},
+		{"eth_getLogs", "https://archive.example.com", 1, "logs"},
+		{"linea_estimateGas", "linea", 1, "estimate"},
+		{"rpc.discover", "proxy", 0, "result"},
+	}
+	for _, tc := range testCases {
+		m, ok := methods[tc.method]
+		if !ok {
+			t.Errorf("Expected %s in the document", tc.method)
+			continue
+		}
+		if len(m.ServedBy) != 1 || m.ServedBy[0] != tc.servedBy {
+			t.Errorf("Expected %s served by %s, got %v", tc.method, tc.servedBy, m.ServedBy)
+		}
+		if len(m.Params) != tc.params || m.Result.Name != tc.result {
+			t.Errorf("Unexpected schema for %s: %+v", tc.method, m)
+		}
+	}
+}
+
+// TestOpenRPCDisabled tests that the document is not served unless enabled
+func TestOpenRPCDisabled(t *testing.T) {
+	// Setup
+	config = Config{DefaultURL: "http://default.example.com"}
+	setupOpenRPC()
+
+	// Test
+	rec := httptest.NewRecorder()
+	handleOpenRPC(rec, httptest.NewRequest("GET", openRPCPath, nil))
+
+	// Verify
+	if rec.Code != 404 {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+	localMethodsMu.RLock()
+	_, ok := localMethods["rpc.discover"]
+	localMethodsMu.RUnlock()
+	if ok {
+		t.Errorf("Expected rpc.discover not to be registered")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Outbound header rules
+//
+// By default only Content-Type and Accept are set on forwarded requests and client
+// headers are dropped. Header rules, configured globally and per route, change that:
+// listed client headers are passed through, configured headers are added or override,
+// and listed headers are removed. Global rules apply first, then the route's. In a batch,
+// calls sent to the same upstream share one request, so the rules of the first call's
+// route are used for the group.
+
+// HeaderRules controls the HTTP headers of forwarded requests.
+type HeaderRules struct {
+	Passthrough []string          `yaml:"passthrough"` // Client headers forwarded as is
+	Set         map[string]string `yaml:"set"`         // Headers added or overridden
+	Remove      []string          `yaml:"remove"`      // Headers removed
+}
+
+// outboundHeaders are the header changes for one forwarded request.
+type outboundHeaders struct {
+	set    http.Header
+	remove []string
+}
+
+type outboundHeadersKey struct{}
+
+// outboundHeadersFor computes the header changes for calls to an upstream.
+//
+// Parameters:
+//   - r: The incoming client request
+//   - upstream: The upstream selected for the call
+//
+// Returns:
+//   - *outboundHeaders: The header changes, or nil if no rules apply
+func outboundHeadersFor(r *http.Request, upstream Upstream) *outboundHeaders {
+	var rules []*HeaderRules
+	if config.Headers != nil {
+		rules = append(rules, config.Headers)
+	}
+	if upstream.Route != nil && upstream.Route.Headers != nil {
+		rules = append(rules, upstream.Route.Headers)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	out := &outboundHeaders{set: make(http.Header)}
+	for _, hr := range rules {
+		for _, name := range hr.Passthrough {
+			if values := r.Header.Values(name); len(values) > 0 {
+				out.set[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+			}
+		}
+		for name, value := range hr.Set {
+			out.set.Set(name, value)
+		}
+		for _, name := range hr.Remove {
+			out.set.Del(name)
+			out.remove = append(out.remove, name)
+		}
+	}
+	return out
+}
+
+// withOutboundHeaders returns a context carrying header changes for forwardRequest.
+func withOutboundHeaders(ctx context.Context, h *outboundHeaders) context.Context {
+	if h == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outboundHeadersKey{}, h)
+}
+
+// applyOutboundHeaders applies the header changes carried by the request's context.
+func applyOutboundHeaders(req *http.Request) {
+	h, ok := req.Context().Value(outboundHeadersKey{}).(*outboundHeaders)
+	if !ok {
+		return
+	}
+	for name, values := range h.set {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+	for _, name := range h.remove {
+		req.Header.Del(name)
+		if http.CanonicalHeaderKey(name) == "User-Agent" {
+			// An empty entry stops the client from adding its default User-Agent
+			req.Header["User-Agent"] = nil
+		}
+	}
+}
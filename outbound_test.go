@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOutboundHeaderRules tests passthrough, set, and remove rules on forwarded requests
+func TestOutboundHeaderRules(t *testing.T) {
+	// Setup
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	config = Config{
+		DefaultURL: server.URL,
+		Headers: &HeaderRules{
+			Passthrough: []string{"traceparent", "X-Request-ID"},
+			Set:         map[string]string{"X-Proxy": "jsonrpc-proxy"},
+		},
+		Routes: []Route{{
+			Method: "eth_call",
+			URL:    server.URL,
+			Headers: &HeaderRules{
+				Set:    map[string]string{"Origin": "https://app.example.com", "X-Proxy": "route"},
+				Remove: []string{"User-Agent", "X-Request-ID"},
+			},
+		}},
+	}
+	buildMethodURLMap()
+
+	send := func(method string) {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"`+method+`","params":[],"id":1}`)))
+		req.Header.Set("Traceparent", "00-abc-def-01")
+		req.Header.Set("X-Request-ID", "req-1")
+		req.Header.Set("Authorization", "Bearer secret")
+		handleProxy(httptest.NewRecorder(), req)
+	}
+
+	// Test default route
+	send("eth_blockNumber")
+
+	// Verify
+	if received.Get("Traceparent") != "00-abc-def-01" || received.Get("X-Request-Id") != "req-1" {
+		t.Errorf("Expected passthrough headers to be forwarded, got %v", received)
+	}
+	if received.Get("X-Proxy") != "jsonrpc-proxy" {
+		t.Errorf("Expected global X-Proxy header, got %q", received.Get("X-Proxy"))
+	}
+	if received.Get("Authorization") != "" {
+		t.Errorf("Expected unlisted client headers to be dropped")
+	}
+
+	// Test route rules
+	send("eth_call")
+
+	// Verify
+	if received.Get("Origin") != "https://app.example.com" || received.Get("X-Proxy") != "route" {
+		t.Errorf("Expected route headers to be set, got %v", received)
+	}
+	if received.Get("User-Agent") != "" || received.Get("X-Request-Id") != "" {
+		t.Errorf("Expected removed headers to be absent, got %v", received)
+	}
+	if received.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type to be kept, got %q", received.Get("Content-Type"))
+	}
+}
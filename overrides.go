@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration flags and environment variables
+//
+// Every configuration option can also be set with a command-line flag or a
+// JSONRPC_PROXY_* environment variable, so the proxy can run without a configuration
+// file. Names are derived from the option's path in the configuration file: the option
+// concurrency.max_in_flight is set with -concurrency.max-in-flight or
+// JSONRPC_PROXY_CONCURRENCY_MAX_IN_FLIGHT. Options holding lists or maps, such as
+// routes and pools, take a YAML or JSON value.
+//
+// Values are applied in this order of precedence, highest first:
+//
+//	command-line flags
+//	JSONRPC_PROXY_* environment variables
+//	the configuration file
+//	built-in defaults
+//
+// Overrides are merged into the configuration document before it is decoded, so they
+// go through the same strict validation as the file.
+
+// envPrefix is the prefix of the environment variables setting configuration options.
+const envPrefix = "JSONRPC_PROXY_"
+
+// configOption is a configuration option that can be set by flag or environment variable.
+type configOption struct {
+	path []string     // YAML keys leading to the option
+	typ  reflect.Type // Go type of the option
+}
+
+// flagName returns the command-line flag of the option.
+func (o configOption) flagName() string {
+	return strings.ReplaceAll(strings.Join(o.path, "."), "_", "-")
+}
+
+// envName returns the environment variable of the option.
+func (o configOption) envName() string {
+	return envPrefix + strings.ToUpper(strings.Join(o.path, "_"))
+}
+
+// structured reports whether the option takes a YAML or JSON value.
+func (o configOption) structured() bool {
+	switch o.typ.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Interface, reflect.Struct:
+		return true
+	}
+	return false
+}
+
+// configOptions lists every option of the configuration, sorted by path. Sections are
+// expanded into their options; lists and maps are options of their own.
+func configOptions() []configOption {
+	var options []configOption
+	var walk func(t reflect.Type, path []string)
+	walk = func(t reflect.Type, path []string) {
+		for name, field := range yamlFields(t) {
+			if len(path) == 0 && name == "version" {
+				// The layout version describes the file, not an option
+				continue
+			}
+			p := append(append([]string(nil), path...), name)
+			ft := field
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && len(p) < 4 {
+				walk(ft, p)
+				continue
+			}
+			options = append(options, configOption{path: p, typ: ft})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil)
+
+	sort.Slice(options, func(i, j int) bool {
+		return strings.Join(options[i].path, ".") < strings.Join(options[j].path, ".")
+	})
+	return options
+}
+
+// configOverride is a value set for an option outside the configuration file.
+type configOverride struct {
+	option configOption
+	value  string
+	source string // The flag or environment variable that set the value, for errors
+}
+
+// configOverrides collects the options set by flags and environment variables.
+type configOverrides struct {
+	options    []configOption
+	flags      map[string]configOverride // Values set by flags, by flag name
+	routeFlags []methodRoute             // Routes set by -route flags (see envconfig.go)
+	values     []configOverride          // Values to apply, after resolve
+	routes     []methodRoute             // Routes to apply, after resolve
+}
+
+// newConfigOverrides creates an empty set of overrides for every configuration option.
+func newConfigOverrides() *configOverrides {
+	return &configOverrides{options: configOptions(), flags: make(map[string]configOverride)}
+}
+
+// registerFlags defines a flag for every configuration option.
+func (c *configOverrides) registerFlags(fs *flag.FlagSet) {
+	for _, o := range c.options {
+		o := o
+		usage := fmt.Sprintf("Set %s (env %s)", strings.Join(o.path, "."), o.envName())
+		if o.structured() {
+			usage += " as YAML or JSON"
+		}
+		fs.Func(o.flagName(), usage, func(value string) error {
+			c.flags[o.flagName()] = configOverride{option: o, value: value, source: "-" + o.flagName()}
+			return nil
+		})
+	}
+	fs.Func("route", "Route a method to a URL or pool:<name>, as method=url (repeatable, env "+routeEnvPrefix+"<method>)", func(value string) error {
+		r, err := parseRouteFlag(value)
+		if err != nil {
+			return err
+		}
+		c.routeFlags = append(c.routeFlags, r)
+		return nil
+	})
+}
+
+// resolve combines the flags with the environment, letting flags take precedence.
+// Environment variables with the prefix that do not name an option are reported, so
+// that misspelled names are noticed.
+//
+// Parameters:
+//   - environ: The environment, as returned by os.Environ
+//   - reserved: Variables with the prefix that are not configuration options
+func (c *configOverrides) resolve(environ []string, reserved ...string) {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, envPrefix) {
+			env[name] = value
+		}
+	}
+
+	c.values = nil
+	known := make(map[string]bool)
+	for _, name := range reserved {
+		known[name] = true
+	}
+	for _, o := range c.options {
+		known[o.envName()] = true
+		if v, ok := c.flags[o.flagName()]; ok {
+			c.values = append(c.values, v)
+		} else if value, ok := env[o.envName()]; ok {
+			c.values = append(c.values, configOverride{option: o, value: value, source: o.envName()})
+		}
+	}
+	c.routes = resolveMethodRoutes(c.routeFlags, env, known)
+	for name := range env {
+		if !known[name] {
+			log.Printf("Warning: Ignoring unknown environment variable %s", name)
+		}
+	}
+}
+
+// apply merges the overrides into a configuration document.
+//
+// Parameters:
+//   - root: The root node of the document (nil if there is no file)
+//
+// Returns:
+//   - *yaml.Node: The root node with the overrides applied
+//   - error: An error naming the flag or variable whose value is invalid
+func (c *configOverrides) apply(root *yaml.Node) (*yaml.Node, error) {
+	if c == nil || (len(c.values) == 0 && len(c.routes) == 0) {
+		return root, nil
+	}
+	if root == nil || root.Kind != yaml.MappingNode {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	for _, v := range c.values {
+		value, err := v.node()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.source, err)
+		}
+		setNode(root, v.option.path, value)
+	}
+	if err := addMethodRoutes(root, c.routes); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// node parses the value of an override. String options are taken literally; other
+// values are parsed as YAML (which includes JSON) and checked against the option's type.
+func (v configOverride) node() (*yaml.Node, error) {
+	if v.option.typ.Kind() == reflect.String {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.value}, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(v.value), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	if err := doc.Content[0].Decode(reflect.New(v.option.typ).Interface()); err != nil {
+		return nil, err
+	}
+	clearPositions(doc.Content[0])
+	return doc.Content[0], nil
+}
+
+// clearPositions removes the line and column of a node and its children, since they
+// refer to the override's value rather than to a file.
+func clearPositions(n *yaml.Node) {
+	n.Line, n.Column = 0, 0
+	for _, child := range n.Content {
+		clearPositions(child)
+	}
+}
+
+// setNode sets the value at a path of mapping keys, creating or replacing mappings
+// along the way.
+func setNode(m *yaml.Node, path []string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			m.Content[i+1] = value
+			return
+		}
+		if m.Content[i+1].Kind != yaml.MappingNode {
+			m.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		setNode(m.Content[i+1], path[1:], value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		m.Content = append(m.Content, key, value)
+		return
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, key, child)
+	setNode(child, path[1:], value)
+}
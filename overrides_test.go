@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigOptions tests the flag and environment variable names derived from the configuration
+func TestConfigOptions(t *testing.T) {
+	// Setup
+	names := make(map[string]string)
+	for _, o := range configOptions() {
+		names[o.flagName()] = o.envName()
+	}
+
+	// Verify
+	expected := map[string]string{
+		"default-url":               "JSONRPC_PROXY_DEFAULT_URL",
+		"routes":                    "JSONRPC_PROXY_ROUTES",
+		"pools":                     "JSONRPC_PROXY_POOLS",
+		"concurrency.max-in-flight": "JSONRPC_PROXY_CONCURRENCY_MAX_IN_FLIGHT",
+		"concurrency.queue-timeout": "JSONRPC_PROXY_CONCURRENCY_QUEUE_TIMEOUT",
+		"admin.token":               "JSONRPC_PROXY_ADMIN_TOKEN",
+	}
+	for flagName, envName := range expected {
+		if names[flagName] != envName {
+			t.Errorf("Expected option -%s with variable %s, got %q", flagName, envName, names[flagName])
+		}
+	}
+	if _, ok := names["concurrency"]; ok {
+		t.Errorf("Expected sections to be expanded into their options")
+	}
+}
+
+// TestConfigOverrides tests the precedence of flags, environment variables, and the file
+func TestConfigOverrides(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigFile(t, `
+default_url: http://file.example.com
+default_name: file
+concurrency:
+  max_in_flight: 5
+  max_queue: 50
+`)
+	overrides := newConfigOverrides()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides.registerFlags(fs)
+	if err := fs.Parse([]string{"-default-name=flag", "-concurrency.queue-timeout=3s"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	overrides.resolve([]string{
+		"JSONRPC_PROXY_DEFAULT_NAME=env",
+		"JSONRPC_PROXY_CONCURRENCY_MAX_IN_FLIGHT=20",
+		`JSONRPC_PROXY_ROUTES=[{"method": "eth_call", "url": "http://archive.example.com"}]`,
+		"HOME=/root",
+	})
+
+	// Test
+	err := loadConfigWith(path, "", overrides)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.DefaultURL != "http://file.example.com" {
+		t.Errorf("Expected default_url from the file, got %s", config.DefaultURL)
+	}
+	if config.DefaultName != "flag" {
+		t.Errorf("Expected default_name from the flag, got %s", config.DefaultName)
+	}
+	cc := config.Concurrency
+	if cc == nil || cc.MaxInFlight != 20 || cc.MaxQueue != 50 || cc.QueueTimeout != 3*time.Second {
+		t.Errorf("Expected concurrency merged from all sources, got %+v", cc)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].URL != "http://archive.example.com" {
+		t.Errorf("Expected routes from the environment, got %+v", config.Routes)
+	}
+}
+
+// TestConfigOverridesWithoutFile tests configuring the proxy from the environment alone
+func TestConfigOverridesWithoutFile(t *testing.T) {
+	// Setup
+	config = Config{}
+	overrides := newConfigOverrides()
+	overrides.resolve([]string{
+		"JSONRPC_PROXY_DEFAULT_POOL=main",
+		"JSONRPC_PROXY_POOLS=main: {upstreams: [{url: http://a.example.com}, {url: http://b.example.com}]}",
+	})
+
+	// Test
+	err := loadConfigWith("", "", overrides)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if pool := config.Pools["main"]; pool == nil || len(pool.Upstreams) != 2 {
+		t.Errorf("Unexpected pools: %+v", config.Pools)
+	}
+}
+
+// TestConfigOverrideErrors tests that invalid overrides are reported by name
+func TestConfigOverrideErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      string
+		expected string
+	}{
+		{"Invalid number", "JSONRPC_PROXY_CONCURRENCY_MAX_IN_FLIGHT=many", "JSONRPC_PROXY_CONCURRENCY_MAX_IN_FLIGHT"},
+		{"Invalid duration", "JSONRPC_PROXY_CONCURRENCY_QUEUE_TIMEOUT=soon", "JSONRPC_PROXY_CONCURRENCY_QUEUE_TIMEOUT"},
+		{"Unknown field in a structured value", `JSONRPC_PROXY_ROUTES=[{"method": "eth_call", "ulr": "http://a.example.com"}]`, "unknown field ulr in routes[0]"},
+		{"Invalid url", "JSONRPC_PROXY_DEFAULT_URL=ftp://a.example.com", `unsupported scheme "ftp"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+			overrides := newConfigOverrides()
+			overrides.resolve([]string{"JSONRPC_PROXY_DEFAULT_URL=http://default.example.com", tc.env})
+
+			// Test
+			err := loadConfigWith("", "", overrides)
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestMethodRoutes tests routing single methods with -route flags and environment variables
+func TestMethodRoutes(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigFile(t, `
+default_url: http://default.example.com
+routes:
+  - method: eth_call
+    url: http://file.example.com
+  - method: eth_call
+    when: params[1] == "earliest"
+    url: http://archive.example.com
+  - method: eth_getBalance
+    url: http://balance.example.com
+`)
+	overrides := newConfigOverrides()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides.registerFlags(fs)
+	if err := fs.Parse([]string{"-route", "eth_chainId=http://flag.example.com"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	overrides.resolve([]string{
+		"JSONRPC_PROXY_ROUTE_eth_chainId=http://env.example.com",
+		"JSONRPC_PROXY_ROUTE_eth_call=http://env.example.com",
+		"JSONRPC_PROXY_ROUTE_eth_getLogs=pool:archive",
+		"JSONRPC_PROXY_POOLS=archive: {upstreams: [{url: http://a.example.com}]}",
+	})
+
+	// Test
+	err := loadConfigWith(path, "", overrides)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var got []string
+	for _, route := range config.Routes {
+		got = append(got, route.Method+" "+route.URL+route.Pool)
+	}
+	expected := []string{
+		"eth_call http://archive.example.com",
+		"eth_getBalance http://balance.example.com",
+		"eth_call http://env.example.com",
+		"eth_chainId http://flag.example.com",
+		"eth_getLogs archive",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected routes %v, got %v", expected, got)
+	}
+}
+
+// TestMethodRouteErrors tests that invalid single-method routes are reported
+func TestMethodRouteErrors(t *testing.T) {
+	// Setup
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	newConfigOverrides().registerFlags(fs)
+	config = Config{}
+	overrides := newConfigOverrides()
+	overrides.resolve([]string{"JSONRPC_PROXY_DEFAULT_URL=http://default.example.com", "JSONRPC_PROXY_ROUTE_eth_call="})
+
+	// Test
+	flagErr := fs.Parse([]string{"-route", "eth_call"})
+	envErr := loadConfigWith("", "", overrides)
+
+	// Verify
+	if flagErr == nil || !strings.Contains(flagErr.Error(), "expected method=url") {
+		t.Errorf("Expected a -route syntax error, got %v", flagErr)
+	}
+	if envErr == nil || !strings.Contains(envErr.Error(), "JSONRPC_PROXY_ROUTE_eth_call: empty value") {
+		t.Errorf("Expected an empty value error, got %v", envErr)
+	}
+}
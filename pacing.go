@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream pacing
+//
+// Providers sell quotas: so many requests per second, so many at once. Exceeding them
+// gets calls rejected with 429s, or the account throttled for minutes. The proxy can
+// pace the calls it sends to each upstream, by name or URL, so that it never exceeds
+// the quota itself:
+//
+//	upstream_pacing:
+//	  max_wait: 1s              # longest a call waits for quota (default: 1s)
+//	  upstreams:
+//	    infura:
+//	      rps: 50               # requests per second
+//	      burst: 100            # requests sent at once after idle time (default: rps)
+//	      max_concurrent: 20    # requests in flight
+//
+// A call over the quota waits until the upstream has quota again, for at most
+// max_wait, after which it is answered with a -32005 error, as when the proxy is
+// saturated (see limits.go). Pools spill excess traffic instead: round robin passes
+// over members without quota left while another member has some, and only queues
+// once every member is at its quota. A batch counts as one request, and so does each
+// retry of it (see retries.go), which keeps the concurrency slot of the first attempt
+// but waits for rate quota of its own, giving up the retry if none comes within
+// max_wait. Calls waiting for
+// quota are counted in jsonrpc_proxy_upstream_paced_total{upstream,result}, with
+// result waited or rejected. Quotas apply to the upstreams configured at startup, not
+// to pool members discovered at runtime.
+
+// UpstreamPacingConfig configures the outbound quotas of upstreams.
+type UpstreamPacingConfig struct {
+	MaxWait   time.Duration            `yaml:"max_wait"`  // Longest a call waits for quota before it is rejected (default: 1s)
+	Upstreams map[string]*UpstreamPace `yaml:"upstreams"` // Quotas by upstream name or URL
+}
+
+// UpstreamPace is the quota of an upstream.
+type UpstreamPace struct {
+	RPS           float64 `yaml:"rps"`            // Requests per second (0: unlimited)
+	Burst         float64 `yaml:"burst"`          // Requests sent at once after idle time (default: rps, at least 1)
+	MaxConcurrent int     `yaml:"max_concurrent"` // Requests in flight (0: unlimited)
+}
+
+// String describes the quota.
+func (p *UpstreamPace) String() string {
+	var limits []string
+	if p.RPS > 0 {
+		limits = append(limits, fmt.Sprintf("%v requests per second", p.RPS))
+	}
+	if p.MaxConcurrent > 0 {
+		limits = append(limits, fmt.Sprintf("%d requests in flight", p.MaxConcurrent))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// defaultPacingMaxWait is the longest a call waits for quota by default.
+const defaultPacingMaxWait = time.Second
+
+// upstreamPaced counts the calls that waited for quota, by result.
+var upstreamPaced = newCounterVec("jsonrpc_proxy_upstream_paced_total", "Upstream calls that waited for the upstream's quota, by result.", "upstream", "result")
+
+// pacer holds the quota of an upstream.
+type pacer struct {
+	label      string
+	rate       float64
+	burst      float64
+	concurrent *limiter // Concurrency quota, or nil
+
+	mu      sync.Mutex
+	tokens  float64   // Requests available at updated, negative when reserved ahead
+	updated time.Time // When tokens was last computed
+}
+
+// pacers holds the pacer of each paced upstream by URL, or is nil when pacing is off.
+var pacers map[string]*pacer
+
+// setupUpstreamPacing builds the pacers of the configured upstreams.
+//
+// Returns:
+//   - error: An error if a quota is negative
+func setupUpstreamPacing() error {
+	pacers = nil
+	pc := config.UpstreamPacing
+	if pc == nil {
+		return nil
+	}
+	if pc.MaxWait < 0 {
+		return fmt.Errorf("max_wait cannot be negative")
+	}
+	for _, key := range sortedKeys(pc.Upstreams) {
+		if p := pc.Upstreams[key]; p != nil && (p.RPS < 0 || p.Burst < 0 || p.MaxConcurrent < 0) {
+			return fmt.Errorf("upstreams: %s: rps, burst, and max_concurrent cannot be negative", upstreamKeyLabel(key))
+		}
+	}
+	maxWait := pc.MaxWait
+	if maxWait == 0 {
+		maxWait = defaultPacingMaxWait
+	}
+
+	pacers = make(map[string]*pacer)
+	for url, name := range probeTargets() {
+		p, ok := pc.Upstreams[name]
+		if !ok || name == "" {
+			p = pc.Upstreams[url]
+		}
+		if p == nil || p.RPS == 0 && p.MaxConcurrent == 0 {
+			continue
+		}
+		u := Upstream{Name: name, URL: url}
+		pa := &pacer{label: upstreamLabel(u), rate: p.RPS, burst: p.Burst, updated: time.Now()}
+		if pa.burst == 0 {
+			pa.burst = math.Max(1, p.RPS)
+		}
+		pa.tokens = pa.burst
+		if p.MaxConcurrent > 0 {
+			pa.concurrent = newLimiter(p.MaxConcurrent, &ConcurrencyConfig{QueueTimeout: maxWait})
+		}
+		pacers[url] = pa
+		log.Printf("Pacing %s to %s", pa.label, p)
+	}
+	return nil
+}
+
+// paceUpstream waits until an upstream has quota for a call, at the priority carried
+// by ctx.
+//
+// Returns:
+//   - func(): Releases the call's concurrency slot (a no-op for upstreams not paced)
+//   - error: errSaturated if the upstream has no quota within max_wait, or the context's error
+func paceUpstream(ctx context.Context, url string) (func(), error) {
+	pa := pacers[url]
+	if pa == nil {
+		return func() {}, nil
+	}
+	release := func() {}
+	if pa.concurrent != nil {
+		if err := pa.concurrent.acquire(ctx, priorityFrom(ctx)); err != nil {
+			upstreamPaced.inc(pa.label, "rejected")
+			return nil, err
+		}
+		var once sync.Once
+		release = func() { once.Do(pa.concurrent.release) }
+	}
+	if err := pa.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// paceRetry waits until an upstream has rate quota for the retry of a call, which
+// keeps the concurrency slot of its first attempt.
+//
+// Returns:
+//   - error: errSaturated if the upstream has no quota within max_wait, or the context's error
+func paceRetry(ctx context.Context, url string) error {
+	pa := pacers[url]
+	if pa == nil {
+		return nil
+	}
+	return pa.wait(ctx)
+}
+
+// wait takes a request from the bucket, waiting until it may be sent.
+//
+// Returns:
+//   - error: errSaturated if that is longer than max_wait, or the context's error
+func (pa *pacer) wait(ctx context.Context) error {
+	if pa.rate == 0 {
+		return nil
+	}
+	wait, ok := pa.reserve(time.Now())
+	if !ok {
+		upstreamPaced.inc(pa.label, "rejected")
+		return errSaturated
+	}
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		upstreamPaced.inc(pa.label, "waited")
+		return nil
+	case <-ctx.Done():
+		pa.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a request from the bucket, ahead of time if it is empty.
+//
+// Returns:
+//   - time.Duration: How long until the reserved request may be sent
+//   - bool: False if that is longer than max_wait, in which case nothing is reserved
+func (pa *pacer) reserve(now time.Time) (time.Duration, bool) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	pa.tokens = math.Min(pa.burst, pa.tokens+now.Sub(pa.updated).Seconds()*pa.rate)
+	pa.updated = now
+	wait := time.Duration(0)
+	if pa.tokens < 1 {
+		wait = time.Duration((1 - pa.tokens) / pa.rate * float64(time.Second))
+	}
+	if wait > pacingMaxWait() {
+		return 0, false
+	}
+	pa.tokens--
+	return wait, true
+}
+
+// cancel returns a reserved request to the bucket.
+func (pa *pacer) cancel() {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	pa.tokens = math.Min(pa.burst, pa.tokens+1)
+}
+
+// pacingMaxWait returns the longest a call waits for quota.
+func pacingMaxWait() time.Duration {
+	if pc := config.UpstreamPacing; pc != nil && pc.MaxWait > 0 {
+		return pc.MaxWait
+	}
+	return defaultPacingMaxWait
+}
+
+// upstreamHasQuota reports whether a call could be sent to an upstream right away.
+func upstreamHasQuota(url string) bool {
+	pa := pacers[url]
+	if pa == nil {
+		return true
+	}
+	if pa.concurrent != nil {
+		pa.concurrent.mu.Lock()
+		full := pa.concurrent.inFlight >= pa.concurrent.size
+		pa.concurrent.mu.Unlock()
+		if full {
+			return false
+		}
+	}
+	if pa.rate == 0 {
+		return true
+	}
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	return pa.tokens+time.Since(pa.updated).Seconds()*pa.rate >= 1
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPacerReserve tests waiting for the rate quota up to max_wait
+func TestPacerReserve(t *testing.T) {
+	// Setup
+	config = Config{UpstreamPacing: &UpstreamPacingConfig{MaxWait: 150 * time.Millisecond}}
+	defer func() { config = Config{} }()
+	now := time.Now()
+	pa := &pacer{rate: 10, burst: 1, tokens: 1, updated: now}
+
+	// Test
+	first, firstOK := pa.reserve(now)
+	second, secondOK := pa.reserve(now)
+	_, thirdOK := pa.reserve(now)
+	pa.cancel()
+	refilled, refilledOK := pa.reserve(now.Add(time.Second))
+
+	// Verify
+	if !firstOK || first != 0 {
+		t.Errorf("Expected the burst to be sent at once, got %v %v", first, firstOK)
+	}
+	if !secondOK || second != 100*time.Millisecond {
+		t.Errorf("Expected to wait 100ms for the next request, got %v %v", second, secondOK)
+	}
+	if thirdOK {
+		t.Errorf("Expected a request 200ms ahead to be rejected")
+	}
+	if !refilledOK || refilled != 0 {
+		t.Errorf("Expected the bucket to refill, got %v %v", refilled, refilledOK)
+	}
+}
+
+// TestPaceUpstreamConcurrency tests rejecting calls over the concurrency quota once max_wait passes
+func TestPaceUpstreamConcurrency(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL:     "http://provider",
+		DefaultName:    "provider",
+		UpstreamPacing: &UpstreamPacingConfig{MaxWait: 20 * time.Millisecond, Upstreams: map[string]*UpstreamPace{"provider": {MaxConcurrent: 1}}},
+	}
+	if err := setupUpstreamPacing(); err != nil {
+		t.Fatalf("Failed to set up pacing: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		pacers = nil
+	}()
+	rejectedBefore := upstreamPaced.value("provider", "rejected")
+
+	// Test
+	release, err := paceUpstream(context.Background(), "http://provider")
+	_, overErr := paceUpstream(context.Background(), "http://provider")
+	release()
+	again, againErr := paceUpstream(context.Background(), "http://provider")
+
+	// Verify
+	if err != nil || againErr != nil {
+		t.Fatalf("Expected calls within the quota to proceed, got %v, %v", err, againErr)
+	}
+	again()
+	if !errors.Is(overErr, errSaturated) {
+		t.Errorf("Expected a call over the quota to be rejected, got %v", overErr)
+	}
+	if got := upstreamPaced.value("provider", "rejected") - rejectedBefore; got != 1 {
+		t.Errorf("Expected 1 rejected call, got %v", got)
+	}
+}
+
+// TestPacingRetries tests that each retry of a call takes quota like its first attempt
+func TestPacingRetries(t *testing.T) {
+	// Setup
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL:     server.URL,
+		DefaultName:    "provider",
+		Retries:        &RetryConfig{Max: 3, Backoff: time.Millisecond, Budget: RetryBudgetConfig{MinRetries: 10}},
+		UpstreamPacing: &UpstreamPacingConfig{MaxWait: 20 * time.Millisecond, Upstreams: map[string]*UpstreamPace{"provider": {RPS: 1}}},
+	}
+	buildMethodURLMap()
+	if err := setupRetries(); err != nil {
+		t.Fatalf("Failed to set up retries: %v", err)
+	}
+	if err := setupUpstreamPacing(); err != nil {
+		t.Fatalf("Failed to set up pacing: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		upstreamRetries = nil
+		pacers = nil
+	}()
+	rejectedBefore := upstreamPaced.value("provider", "rejected")
+
+	// Test
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_blockNumber","id":1}`)))
+
+	// Verify
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected retries without quota left not to be sent, got %d attempts", got)
+	}
+	if got := upstreamPaced.value("provider", "rejected") - rejectedBefore; got != 1 {
+		t.Errorf("Expected the retry to be rejected by pacing, got %v", got)
+	}
+}
+
+// TestPoolSpillsOverQuota tests that round robin passes over members without quota
+func TestPoolSpillsOverQuota(t *testing.T) {
+	// Setup
+	members := []UpstreamConfig{{URL: "http://a", Name: "a"}, {URL: "http://b", Name: "b"}}
+	config = Config{
+		Pools:          map[string]*PoolConfig{"main": {Upstreams: members}},
+		UpstreamPacing: &UpstreamPacingConfig{Upstreams: map[string]*UpstreamPace{"a": {RPS: 0.001, Burst: 1}}},
+	}
+	if err := setupUpstreamPacing(); err != nil {
+		t.Fatalf("Failed to set up pacing: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		pacers = nil
+	}()
+	var next atomic.Uint64
+	pacers["http://a"].reserve(time.Now())
+
+	// Test
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, roundRobinMember(members, &next).Name)
+	}
+
+	// Verify
+	for _, name := range picked {
+		if name != "b" {
+			t.Errorf("Expected every call to spill to b while a is at its quota, got %v", picked)
+			break
+		}
+	}
+}
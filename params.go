@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Parameter rules
+//
+// Some clients send calls that violate provider constraints, such as eth_call without
+// a block tag or eth_getLogs over a range the provider rejects. A route can fix these
+// centrally with param_rules, applied in order to the positional params of every call
+// it carries (before any method rewriting):
+//
+//	param_rules:
+//	  - {index: 1, default: "latest"}       # inject when omitted or null
+//	  - {index: 0, set: "0x0"}               # always overwrite
+//	  - {index: 0, max_log_range: 2000}      # clamp a log filter's block range
+//
+// A clamped range keeps fromBlock and moves toBlock back; "latest" and other tags are
+// resolved against the upstream's head.
+
+// ParamRule transforms one positional param of the calls carried by a route.
+type ParamRule struct {
+	Index       int         `yaml:"index"`         // Position of the param the rule applies to
+	Default     interface{} `yaml:"default"`       // Value injected when the param is omitted or null
+	Set         interface{} `yaml:"set"`           // Value that always replaces the param
+	MaxLogRange uint64      `yaml:"max_log_range"` // Maximum number of blocks in a log filter's range
+}
+
+// validateParamRules checks the param rules of a route.
+func validateParamRules(rules []ParamRule) error {
+	for i, rule := range rules {
+		if rule.Index < 0 {
+			return fmt.Errorf("param rule %d: index must not be negative", i)
+		}
+		actions := 0
+		if rule.Default != nil {
+			actions++
+		}
+		if rule.Set != nil {
+			actions++
+		}
+		if rule.MaxLogRange > 0 {
+			actions++
+		}
+		if actions != 1 {
+			return fmt.Errorf("param rule %d: exactly one of default, set, or max_log_range is required", i)
+		}
+	}
+	return nil
+}
+
+// applyParamRules applies the route's param rules to a call's params.
+//
+// Parameters:
+//   - ctx: The context bounding head lookups
+//   - upstream: The selected upstream (its route supplies the rules)
+//   - req: The call
+//
+// Returns:
+//   - interface{}: The transformed params
+//   - bool: Whether any param changed
+//   - error: An error if a log range cannot be resolved
+func applyParamRules(ctx context.Context, upstream Upstream, req *JSONRPCRequest) (interface{}, bool, error) {
+	if upstream.Route == nil || len(upstream.Route.ParamRules) == 0 {
+		return req.Params, false, nil
+	}
+	var params []interface{}
+	switch p := req.Params.(type) {
+	case nil:
+	case []interface{}:
+		params = append([]interface{}{}, p...)
+	default:
+		// Named params are left alone
+		return req.Params, false, nil
+	}
+
+	changed := false
+	for _, rule := range upstream.Route.ParamRules {
+		switch {
+		case rule.Set != nil:
+			params = setParam(params, rule.Index, rule.Set)
+			changed = true
+		case rule.Default != nil:
+			if rule.Index >= len(params) || params[rule.Index] == nil {
+				params = setParam(params, rule.Index, rule.Default)
+				changed = true
+			}
+		case rule.MaxLogRange > 0:
+			if rule.Index >= len(params) {
+				continue
+			}
+			filter, ok := params[rule.Index].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			clamped, err := clampLogRange(ctx, upstream.URL, filter, rule.MaxLogRange)
+			if err != nil {
+				return nil, false, err
+			}
+			if clamped != nil {
+				logInfo("router", "Clamped %s range to %d blocks", req.Method, rule.MaxLogRange)
+				params[rule.Index] = clamped
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return req.Params, false, nil
+	}
+	return params, true, nil
+}
+
+// setParam sets a positional param, padding omitted params with null.
+func setParam(params []interface{}, index int, value interface{}) []interface{} {
+	for len(params) <= index {
+		params = append(params, nil)
+	}
+	params[index] = value
+	return params
+}
+
+// clampLogRange limits a log filter's block range. It returns nil if the filter does
+// not need clamping.
+func clampLogRange(ctx context.Context, url string, filter map[string]interface{}, maxRange uint64) (map[string]interface{}, error) {
+	if _, ok := filter["blockHash"]; ok {
+		return nil, nil
+	}
+
+	var head uint64
+	resolve := func(v interface{}) (uint64, error) {
+		tag, _ := v.(string)
+		switch tag {
+		case "earliest":
+			return 0, nil
+		case "", "latest", "safe", "finalized", "pending":
+			if head == 0 {
+				h, err := currentHead(ctx, url)
+				if err != nil {
+					return 0, fmt.Errorf("error resolving %q for log range: %w", tag, err)
+				}
+				head = h
+			}
+			return head, nil
+		}
+		return parseQuantity(tag)
+	}
+
+	from, err := resolve(filter["fromBlock"])
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolve(filter["toBlock"])
+	if err != nil {
+		return nil, err
+	}
+	if to < from || to-from+1 <= maxRange {
+		return nil, nil
+	}
+
+	out := make(map[string]interface{}, len(filter))
+	for k, v := range filter {
+		out[k] = v
+	}
+	out["fromBlock"] = toQuantity(from)
+	out["toBlock"] = toQuantity(from + maxRange - 1)
+	return out, nil
+}
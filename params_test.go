@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestApplyParamRules tests default injection, overwriting, and log range clamping
+func TestApplyParamRules(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_blockNumber", `{"jsonrpc":"2.0","id":1,"result":"0x2710"}`)
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		rules    []ParamRule
+		params   interface{}
+		expected interface{}
+	}{
+		{
+			"Default block tag",
+			[]ParamRule{{Index: 1, Default: "latest"}},
+			[]interface{}{map[string]interface{}{"to": "0x1"}},
+			[]interface{}{map[string]interface{}{"to": "0x1"}, "latest"},
+		},
+		{
+			"Default keeps given value",
+			[]ParamRule{{Index: 1, Default: "latest"}},
+			[]interface{}{"0x1", "0x10"},
+			[]interface{}{"0x1", "0x10"},
+		},
+		{
+			"Set overwrites",
+			[]ParamRule{{Index: 0, Set: false}},
+			[]interface{}{true},
+			[]interface{}{false},
+		},
+		{
+			"Clamp numeric range",
+			[]ParamRule{{Index: 0, MaxLogRange: 100}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x0", "toBlock": "0x3e8"}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x0", "toBlock": "0x63"}},
+		},
+		{
+			"Clamp range to latest",
+			[]ParamRule{{Index: 0, MaxLogRange: 1000}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x1", "address": "0xabc"}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x1", "toBlock": "0x3e8", "address": "0xabc"}},
+		},
+		{
+			"Range within limit",
+			[]ParamRule{{Index: 0, MaxLogRange: 1000}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x2700"}},
+			[]interface{}{map[string]interface{}{"fromBlock": "0x2700"}},
+		},
+		{
+			"Named params untouched",
+			[]ParamRule{{Index: 0, Set: "x"}},
+			map[string]interface{}{"a": "b"},
+			map[string]interface{}{"a": "b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateParamRules(tc.rules); err != nil {
+				t.Fatalf("Invalid rules: %v", err)
+			}
+			upstream := Upstream{URL: server.URL, Route: &Route{ParamRules: tc.rules}}
+
+			// Test
+			params, _, err := applyParamRules(context.Background(), upstream, &JSONRPCRequest{Method: "eth_getLogs", Params: tc.params})
+
+			// Verify
+			if err != nil {
+				t.Fatalf("Failed to apply rules: %v", err)
+			}
+			if !reflect.DeepEqual(params, tc.expected) {
+				t.Errorf("Expected params %v, got %v", tc.expected, params)
+			}
+		})
+	}
+}
+
+// TestValidateParamRules tests rejection of ambiguous rules
+func TestValidateParamRules(t *testing.T) {
+	invalid := [][]ParamRule{
+		{{Index: 0}},
+		{{Index: 0, Set: "a", Default: "b"}},
+		{{Index: -1, Set: "a"}},
+	}
+	for i, rules := range invalid {
+		if err := validateParamRules(rules); err == nil {
+			t.Errorf("Expected rules %d to be rejected", i)
+		}
+	}
+}
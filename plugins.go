@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"plugin"
+	"sync"
+)
+
+// Extension hooks
+//
+// Hooks let custom code observe and alter a request at four stages of its life:
+//
+//   - request-received: the raw client body, before it is parsed
+//   - pre-route:        a single JSON-RPC call, before the upstream is chosen
+//   - pre-forward:      the outbound HTTP request, before it is sent upstream
+//   - post-response:    the upstream HTTP response, before it is relayed
+//
+// Hooks can be registered in-process with RegisterHooks, or loaded at startup from
+// Go plugins listed under `plugins` in the configuration. A plugin exports any subset
+// of the following symbols, using only standard library types so that it does not
+// need to import this package:
+//
+//	func OnRequestReceived(r *http.Request, body []byte) ([]byte, error)
+//	func PreRoute(method string, call []byte) (targetURL string, err error)
+//	func PreForward(req *http.Request) error
+//	func PostResponse(resp *http.Response) error
+//
+// Go plugins require a cgo-enabled build of the proxy on Linux, FreeBSD, or macOS,
+// and must be compiled with the same Go toolchain and dependency versions.
+
+// Hooks is a set of optional extension callbacks. Nil fields are skipped.
+type Hooks struct {
+	Name string // Name used in log messages (the plugin path for loaded plugins)
+
+	// OnRequestReceived may replace the raw request body. Returning an error rejects the request.
+	OnRequestReceived func(r *http.Request, body []byte) ([]byte, error)
+
+	// PreRoute may return a non-empty URL to override the upstream chosen for a call.
+	// Returning an error fails the call.
+	PreRoute func(method string, call []byte) (string, error)
+
+	// PreForward may modify the outbound request. Returning an error aborts forwarding.
+	PreForward func(req *http.Request) error
+
+	// PostResponse may inspect or replace the upstream response body. Returning an error
+	// discards the response and reports a proxy error instead.
+	PostResponse func(resp *http.Response) error
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hooks // Registered hooks, run in registration order
+)
+
+// RegisterHooks adds a set of extension hooks. Hooks run in the order they were registered.
+//
+// Parameters:
+//   - h: The hooks to register
+func RegisterHooks(h Hooks) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, h)
+}
+
+// registeredHooks returns a snapshot of the registered hooks.
+func registeredHooks() []Hooks {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return hooks
+}
+
+// hasPostResponseHooks reports whether a registered hook inspects upstream responses.
+func hasPostResponseHooks() bool {
+	for _, h := range registeredHooks() {
+		if h.PostResponse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPlugins opens each Go plugin and registers the hooks it exports.
+//
+// Parameters:
+//   - paths: Filesystem paths of the plugin shared objects
+//
+// Returns:
+//   - error: An error if a plugin cannot be opened or exports a hook with the wrong signature
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening plugin %s: %w", path, err)
+		}
+
+		h, err := pluginHooks(path, p)
+		if err != nil {
+			return err
+		}
+		RegisterHooks(h)
+	}
+	return nil
+}
+
+// pluginHooks builds a Hooks value from the symbols exported by a plugin.
+//
+// Parameters:
+//   - path: The plugin path, used as the hooks name
+//   - p: The opened plugin
+//
+// Returns:
+//   - Hooks: The hooks found in the plugin
+//   - error: An error if a known symbol has an unexpected type
+func pluginHooks(path string, p *plugin.Plugin) (Hooks, error) {
+	h := Hooks{Name: path}
+
+	lookup := func(name string) plugin.Symbol {
+		sym, err := p.Lookup(name)
+		if err != nil {
+			return nil
+		}
+		return sym
+	}
+
+	if sym := lookup("OnRequestReceived"); sym != nil {
+		fn, ok := sym.(func(*http.Request, []byte) ([]byte, error))
+		if !ok {
+			return h, fmt.Errorf("plugin %s: OnRequestReceived has wrong signature %T", path, sym)
+		}
+		h.OnRequestReceived = fn
+	}
+	if sym := lookup("PreRoute"); sym != nil {
+		fn, ok := sym.(func(string, []byte) (string, error))
+		if !ok {
+			return h, fmt.Errorf("plugin %s: PreRoute has wrong signature %T", path, sym)
+		}
+		h.PreRoute = fn
+	}
+	if sym := lookup("PreForward"); sym != nil {
+		fn, ok := sym.(func(*http.Request) error)
+		if !ok {
+			return h, fmt.Errorf("plugin %s: PreForward has wrong signature %T", path, sym)
+		}
+		h.PreForward = fn
+	}
+	if sym := lookup("PostResponse"); sym != nil {
+		fn, ok := sym.(func(*http.Response) error)
+		if !ok {
+			return h, fmt.Errorf("plugin %s: PostResponse has wrong signature %T", path, sym)
+		}
+		h.PostResponse = fn
+	}
+
+	return h, nil
+}
+
+// runRequestReceivedHooks passes the raw request body through every OnRequestReceived hook.
+//
+// Parameters:
+//   - r: The incoming HTTP request
+//   - body: The raw request body
+//
+// Returns:
+//   - []byte: The (possibly replaced) request body
+//   - error: The first error returned by a hook
+func runRequestReceivedHooks(r *http.Request, body []byte) ([]byte, error) {
+	for _, h := range registeredHooks() {
+		if h.OnRequestReceived == nil {
+			continue
+		}
+		newBody, err := h.OnRequestReceived(r, body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h.Name, err)
+		}
+		if newBody != nil {
+			body = newBody
+		}
+	}
+	return body, nil
+}
+
+// runPreRouteHooks asks each PreRoute hook for an upstream override.
+// The first hook returning a non-empty URL wins.
+//
+// Parameters:
+//   - method: The JSON-RPC method of the call
+//   - call: The raw JSON of the call
+//
+// Returns:
+//   - string: The override URL, or an empty string to keep the normal routing
+//   - error: The first error returned by a hook
+func runPreRouteHooks(method string, call []byte) (string, error) {
+	for _, h := range registeredHooks() {
+		if h.PreRoute == nil {
+			continue
+		}
+		targetURL, err := h.PreRoute(method, call)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", h.Name, err)
+		}
+		if targetURL != "" {
+			return targetURL, nil
+		}
+	}
+	return "", nil
+}
+
+// runPreForwardHooks passes the outbound request through every PreForward hook.
+//
+// Parameters:
+//   - req: The outbound HTTP request
+//
+// Returns:
+//   - error: The first error returned by a hook
+func runPreForwardHooks(req *http.Request) error {
+	for _, h := range registeredHooks() {
+		if h.PreForward == nil {
+			continue
+		}
+		if err := h.PreForward(req); err != nil {
+			return fmt.Errorf("%s: %w", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// runPostResponseHooks passes the upstream response through every PostResponse hook.
+//
+// Parameters:
+//   - resp: The upstream HTTP response
+//
+// Returns:
+//   - error: The first error returned by a hook
+func runPostResponseHooks(resp *http.Response) error {
+	for _, h := range registeredHooks() {
+		if h.PostResponse == nil {
+			continue
+		}
+		if err := h.PostResponse(resp); err != nil {
+			return fmt.Errorf("%s: %w", h.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// resetHooks removes all registered hooks after a test
+func resetHooks() {
+	hooksMu.Lock()
+	hooks = nil
+	hooksMu.Unlock()
+}
+
+// TestHooksAllStages tests that hooks run at every stage of a proxied request
+func TestHooksAllStages(t *testing.T) {
+	// Setup
+	defer resetHooks()
+
+	var gotHeader, gotMethod string
+	override := mockHTTPServer(t, "renamed_method", `{"jsonrpc":"2.0","result":"override","id":1}`)
+	defer override.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Request should have been routed to the override upstream")
+	}))
+	defer upstream.Close()
+
+	config = Config{DefaultURL: upstream.URL}
+	buildMethodURLMap()
+
+	var stages []string
+	RegisterHooks(Hooks{
+		Name: "test",
+		OnRequestReceived: func(r *http.Request, body []byte) ([]byte, error) {
+			stages = append(stages, "received")
+			return bytes.Replace(body, []byte("original_method"), []byte("renamed_method"), 1), nil
+		},
+		PreRoute: func(method string, call []byte) (string, error) {
+			stages = append(stages, "route")
+			gotMethod = method
+			return override.URL, nil
+		},
+		PreForward: func(req *http.Request) error {
+			stages = append(stages, "forward")
+			req.Header.Set("X-Plugin", "yes")
+			gotHeader = req.Header.Get("X-Plugin")
+			return nil
+		},
+		PostResponse: func(resp *http.Response) error {
+			stages = append(stages, "response")
+			return nil
+		},
+	})
+
+	reqBytes, _ := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", Method: "original_method", ID: 1})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(reqBytes))
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, req)
+
+	// Verify
+	body, _ := io.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), "override") {
+		t.Errorf("Expected response from override upstream, got %s", string(body))
+	}
+	if gotMethod != "renamed_method" {
+		t.Errorf("Expected PreRoute to see rewritten method, got %s", gotMethod)
+	}
+	if gotHeader != "yes" {
+		t.Errorf("Expected PreForward to set header, got %q", gotHeader)
+	}
+	if strings.Join(stages, ",") != "received,route,forward,response" {
+		t.Errorf("Unexpected hook order: %v", stages)
+	}
+}
+
+// TestRequestReceivedHookRejects tests that a hook error rejects the request
+func TestRequestReceivedHookRejects(t *testing.T) {
+	// Setup
+	defer resetHooks()
+	RegisterHooks(Hooks{
+		Name: "deny",
+		OnRequestReceived: func(r *http.Request, body []byte) ([]byte, error) {
+			return nil, errors.New("denied")
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"m","id":1}`))
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, req)
+
+	// Verify
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Result().StatusCode)
+	}
+}
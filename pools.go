@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Upstream pools
+//
+// A pool is a named group of interchangeable upstreams. Routes (and the default route)
+// can send their calls to a pool instead of a single URL; each call then goes to one
+// member, chosen by the pool's strategy:
+//
+//	round_robin  members in turn (default)
+//	client_hash  each client consistently to the same member, so stateful sequences
+//	             (filters, pending-transaction views) stay on one node
+//
+// client_hash identifies clients by API key or IP (see clients.go) and maps them to
+// members with rendezvous hashing, so losing a member only moves its own clients.
+// Members that the probes report as unhealthy are skipped by both strategies. Pools can
+// also discover their members at runtime (see discovery.go).
+
+// PoolConfig defines a named upstream pool.
+type PoolConfig struct {
+	Upstreams []UpstreamConfig `yaml:"upstreams"` // Pool members
+	Strategy  string           `yaml:"strategy"`  // "round_robin" (default) or "client_hash"
+	Discovery *DiscoveryConfig `yaml:"discovery"` // Discover members at runtime instead of listing them (optional)
+
+	mu         sync.RWMutex                // Protects Upstreams once discovery runs
+	static     []UpstreamConfig            // Listed members, kept when discovered ones change
+	discovered map[string][]UpstreamConfig // Discovered members by discovery source
+	srv        []string                    // SRV addresses whose targets join the pool (see srv.go)
+	next       atomic.Uint64               // Round-robin position
+}
+
+// members returns the pool's current members.
+func (p *PoolConfig) members() []UpstreamConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Upstreams
+}
+
+// setSourceMembers replaces the members found by one discovery source. The pool's
+// members become its listed members followed by every source's discovered members.
+//
+// Parameters:
+//   - source: The discovery source, e.g. "kubernetes"
+//   - members: The members the source currently finds, in a stable order
+//
+// Returns:
+//   - bool: Whether the source's members changed
+func (p *PoolConfig) setSourceMembers(source string, members []UpstreamConfig) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered == nil {
+		p.static = p.Upstreams
+		p.discovered = make(map[string][]UpstreamConfig)
+	} else if old, ok := p.discovered[source]; ok && sameMembers(old, members) {
+		return false
+	}
+	p.discovered[source] = members
+
+	sources := make([]string, 0, len(p.discovered))
+	for name := range p.discovered {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	all := append([]UpstreamConfig{}, p.static...)
+	for _, name := range sources {
+		all = append(all, p.discovered[name]...)
+	}
+	p.Upstreams = all
+	return true
+}
+
+// sameMembers reports whether two member lists are identical.
+func sameMembers(a, b []UpstreamConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validatePools checks the pools and the pool references of the routes.
+//
+// Returns:
+//   - error: An error describing the first invalid pool or reference
+func validatePools() error {
+	for name, pool := range config.Pools {
+		if pool == nil || (len(pool.Upstreams) == 0 && pool.Discovery == nil && len(pool.srv) == 0) {
+			return configErrorf("pools."+name, "pool %s must list at least one upstream", name)
+		}
+		if pool.Discovery != nil {
+			if err := pool.Discovery.validate(); err != nil {
+				return configErrorf("pools."+name+".discovery", "pool %s: %w", name, err)
+			}
+		}
+		for i, uc := range pool.Upstreams {
+			if uc.URL == "" {
+				return configErrorf(fmt.Sprintf("pools.%s.upstreams[%d]", name, i), "pool %s: upstreams[%d]: url is required", name, i)
+			}
+		}
+		switch pool.Strategy {
+		case "", "round_robin", "client_hash":
+		default:
+			return configErrorf("pools."+name+".strategy", "pool %s: unknown strategy %q (expected round_robin or client_hash)", name, pool.Strategy)
+		}
+	}
+
+	if config.DefaultPool != "" && config.Pools[config.DefaultPool] == nil {
+		return configErrorf("default_pool", "default_pool: unknown pool %s", config.DefaultPool)
+	}
+	for i, route := range config.Routes {
+		if route.Pool != "" && config.Pools[route.Pool] == nil {
+			return configErrorf(fmt.Sprintf("routes[%d].pool", i), "route %d (%s): unknown pool %s", i, route.Method, route.Pool)
+		}
+	}
+	return nil
+}
+
+// pickPoolMember selects the member of a pool that serves a call.
+//
+// Parameters:
+//   - name: The pool name
+//   - req: The call (its client is used by client_hash)
+//
+// Returns:
+//   - Upstream: The selected member
+//   - error: An error if the pool does not exist
+func pickPoolMember(name string, req *JSONRPCRequest) (Upstream, error) {
+	pool := config.Pools[name]
+	if pool == nil {
+		return Upstream{}, fmt.Errorf("unknown pool %s", name)
+	}
+	members := pool.members()
+	if len(members) == 0 {
+		return Upstream{}, fmt.Errorf("pool %s has no members", name)
+	}
+	members = regionalMembers(name, members)
+
+	if pool.Strategy == "client_hash" && req.client != (clientInfo{}) {
+		return stickyMember(members, req.client.key()).upstream(), nil
+	}
+	return roundRobinMember(members, &pool.next).upstream(), nil
+}
+
+// roundRobinMember returns the next healthy member in turn, passing over members in
+// slow start for the share of calls they are not given yet, and members without
+// quota left (see pacing.go and quotas.go). If every healthy member is passed over, the first one is
+// returned; if no member is known to be healthy, the next member is returned anyway.
+func roundRobinMember(members []UpstreamConfig, next *atomic.Uint64) UpstreamConfig {
+	start := next.Add(1) - 1
+	var passed *UpstreamConfig // First healthy member passed over
+	for i := range members {
+		member := members[(start+uint64(i))%uint64(len(members))]
+		if !upstreamHealthy(member.URL) {
+			continue
+		}
+		if w := upstreamWeight(member.URL); w < 1 && rand.Float64() >= w || !upstreamHasQuota(member.URL) || upstreamQuotaExhausting(member.URL) {
+			if passed == nil {
+				passed = &member
+			}
+			continue
+		}
+		return member
+	}
+	if passed != nil {
+		return *passed
+	}
+	return members[start%uint64(len(members))]
+}
+
+// poolMembers returns the members of every configured pool.
+func poolMembers() []UpstreamConfig {
+	var out []UpstreamConfig
+	for _, pool := range config.Pools {
+		if pool != nil {
+			out = append(out, pool.members()...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPoolRoundRobin tests spreading calls over a pool in turn
+func TestPoolRoundRobin(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultPool: "main",
+		Pools: map[string]*PoolConfig{
+			"main": {Upstreams: []UpstreamConfig{{URL: "http://a.example.com", Name: "a"}, {URL: "http://b.example.com", Name: "b"}}},
+		},
+	}
+	if err := validatePools(); err != nil {
+		t.Fatalf("Invalid pools: %v", err)
+	}
+	buildMethodURLMap()
+
+	// Test
+	var names []string
+	for i := 0; i < 4; i++ {
+		upstream, err := router.Route(&JSONRPCRequest{Method: "eth_call"})
+		if err != nil {
+			t.Fatalf("Routing failed: %v", err)
+		}
+		names = append(names, upstream.Name)
+	}
+
+	// Verify
+	if names[0] == names[1] || names[0] != names[2] || names[1] != names[3] {
+		t.Errorf("Expected calls to alternate between members, got %v", names)
+	}
+}
+
+// TestPoolClientHash tests consistent mapping of clients to pool members
+func TestPoolClientHash(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://default.example.com",
+		Routes:     []Route{{Method: "eth_getFilterChanges", Pool: "nodes"}},
+		Pools: map[string]*PoolConfig{
+			"nodes": {
+				Strategy: "client_hash",
+				Upstreams: []UpstreamConfig{
+					{URL: "http://node-1.example.com", Name: "node-1"},
+					{URL: "http://node-2.example.com", Name: "node-2"},
+					{URL: "http://node-3.example.com", Name: "node-3"},
+				},
+			},
+		},
+	}
+	if err := validatePools(); err != nil {
+		t.Fatalf("Invalid pools: %v", err)
+	}
+	buildMethodURLMap()
+
+	route := func(remoteAddr, apiKey string) string {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		upstream, err := router.Route(&JSONRPCRequest{Method: "eth_getFilterChanges", client: clientFromRequest(r)})
+		if err != nil {
+			t.Fatalf("Routing failed: %v", err)
+		}
+		return upstream.Name
+	}
+
+	// Test
+	members := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		ip := "10.0.0." + string(rune('0'+i%10)) + string(rune('0'+i/10))
+		first := route(ip+":1234", "")
+		members[first] = true
+
+		// Verify the same IP sticks regardless of source port
+		if again := route(ip+":5678", ""); again != first {
+			t.Errorf("Expected client %s to stick to %s, got %s", ip, first, again)
+		}
+	}
+	if len(members) < 2 {
+		t.Errorf("Expected clients to spread over the pool, got %v", members)
+	}
+
+	// API keys take precedence over the IP address
+	byKey := route("10.0.0.1:1", "key-1")
+	for i := 0; i < 10; i++ {
+		if got := route("10.0.1."+string(rune('0'+i))+":1", "key-1"); got != byKey {
+			t.Errorf("Expected API key to stick to %s from any IP, got %s", byKey, got)
+		}
+	}
+}
+
+// TestValidatePools tests rejection of invalid pool configuration
+func TestValidatePools(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  Config
+	}{
+		{"Empty pool", Config{Pools: map[string]*PoolConfig{"p": {}}}},
+		{"Unknown strategy", Config{Pools: map[string]*PoolConfig{"p": {Strategy: "random", Upstreams: []UpstreamConfig{{URL: "http://a"}}}}}},
+		{"Unknown route pool", Config{Routes: []Route{{Method: "m", Pool: "missing"}}}},
+		{"Unknown default pool", Config{DefaultPool: "missing"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config = tc.cfg
+			if err := validatePools(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Priority classes
+//
+// Under overload, not all traffic is equally important: a transaction submission or a
+// paying tenant's call should survive where a bulk log scan can be retried later.
+// Methods and clients (by API key or IP) can be assigned a priority class of high,
+// normal, or low. The concurrency limiters hand free slots to the highest-priority
+// waiter first, and when a queue is full, a new request sheds the newest queued request
+// of a lower class instead of being rejected. A listed client's class applies to all of
+// its requests; other requests get the highest class of the methods they call, with
+// unlisted methods counting as normal. Mirrored calls are always low priority.
+
+// PriorityConfig assigns priority classes to methods and clients.
+type PriorityConfig struct {
+	Methods map[string]string `yaml:"methods"` // Method name to class ("high", "normal", or "low")
+	Clients map[string]string `yaml:"clients"` // API key or client IP to class
+}
+
+// priority is a priority class. Higher values are served first.
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+	numPriorities
+)
+
+// String returns the class name.
+func (p priority) String() string {
+	switch p {
+	case priorityLow:
+		return "low"
+	case priorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// parsePriority parses a class name.
+func parsePriority(s string) (priority, error) {
+	switch s {
+	case "high":
+		return priorityHigh, nil
+	case "normal", "":
+		return priorityNormal, nil
+	case "low":
+		return priorityLow, nil
+	}
+	return priorityNormal, fmt.Errorf("unknown priority %q (expected high, normal, or low)", s)
+}
+
+var (
+	methodPriorities map[string]priority // Priorities of listed methods
+	clientPriorities map[string]priority // Priorities of listed API keys and IPs
+)
+
+// setupPriorities parses the configured priority classes.
+//
+// Returns:
+//   - error: An error if a class name is invalid
+func setupPriorities(pc *PriorityConfig) error {
+	methodPriorities = make(map[string]priority)
+	clientPriorities = make(map[string]priority)
+	if pc == nil {
+		return nil
+	}
+	for method, class := range pc.Methods {
+		p, err := parsePriority(class)
+		if err != nil {
+			return fmt.Errorf("priorities.methods.%s: %w", method, err)
+		}
+		methodPriorities[method] = p
+	}
+	for client, class := range pc.Clients {
+		p, err := parsePriority(class)
+		if err != nil {
+			return fmt.Errorf("priorities.clients.%s: %w", client, err)
+		}
+		clientPriorities[client] = p
+	}
+	return nil
+}
+
+// requestPriority returns the priority of an incoming request: its client's class if
+// the client is listed, otherwise the highest class of the methods it calls.
+//
+// Parameters:
+//   - r: The incoming HTTP request
+//   - body: The request body, a single call or a batch
+//
+// Returns:
+//   - priority: The request's priority class
+func requestPriority(r *http.Request, body []byte) priority {
+	client := clientFromRequest(r)
+	if p, ok := clientPriorities[client.APIKey]; ok && client.APIKey != "" {
+		return p
+	}
+	if p, ok := clientPriorities[client.IP]; ok {
+		return p
+	}
+	if len(methodPriorities) == 0 {
+		return priorityNormal
+	}
+
+	calls := parseCalls(body)
+	if len(calls) == 0 {
+		return priorityNormal
+	}
+	prio := priorityLow
+	for _, c := range calls {
+		p, ok := methodPriorities[c.Method]
+		if !ok {
+			p = priorityNormal
+		}
+		prio = max(prio, p)
+	}
+	return prio
+}
+
+type priorityKey struct{}
+
+// withPriority returns a context carrying a request's priority for the upstream limiters.
+func withPriority(ctx context.Context, p priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority carried by a context, or normal.
+func priorityFrom(ctx context.Context) priority {
+	if p, ok := ctx.Value(priorityKey{}).(priority); ok {
+		return p
+	}
+	return priorityNormal
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPriorityOrder tests that free slots go to higher-priority waiters first
+func TestPriorityOrder(t *testing.T) {
+	// Setup
+	l := newLimiter(1, &ConcurrencyConfig{MaxQueue: 10, QueueTimeout: time.Second})
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+
+	// Test: a low-priority caller queues before a high-priority one
+	order := make(chan priority, 2)
+	for i, p := range []priority{priorityLow, priorityHigh} {
+		go func(p priority) {
+			if err := l.acquire(context.Background(), p); err == nil {
+				order <- p
+				l.release()
+			}
+		}(p)
+		waitQueued(l, i+1)
+	}
+	l.release()
+
+	// Verify
+	if first, second := <-order, <-order; first != priorityHigh || second != priorityLow {
+		t.Errorf("Expected high then low, got %s then %s", first, second)
+	}
+}
+
+// TestPriorityShedding tests that a full queue sheds lower-priority waiters
+func TestPriorityShedding(t *testing.T) {
+	// Setup
+	l := newLimiter(1, &ConcurrencyConfig{MaxQueue: 1, QueueTimeout: time.Second})
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+	lowResult := make(chan error, 1)
+	go func() { lowResult <- l.acquire(context.Background(), priorityLow) }()
+	waitQueued(l, 1)
+
+	// Test
+	highResult := make(chan error, 1)
+	go func() { highResult <- l.acquire(context.Background(), priorityHigh) }()
+
+	// Verify the low-priority waiter is shed and the high-priority one served
+	if err := <-lowResult; !errors.Is(err, errSaturated) {
+		t.Errorf("Expected the low-priority waiter to be shed, got %v", err)
+	}
+	l.release()
+	if err := <-highResult; err != nil {
+		t.Errorf("Expected the high-priority waiter to get the slot, got %v", err)
+	}
+
+	// Verify an equal-priority request does not shed
+	go l.acquire(context.Background(), priorityLow)
+	waitQueued(l, 1)
+	if err := l.acquire(context.Background(), priorityLow); !errors.Is(err, errSaturated) {
+		t.Errorf("Expected overflow to be rejected, got %v", err)
+	}
+}
+
+// TestRequestPriority tests classifying requests by client and method
+func TestRequestPriority(t *testing.T) {
+	// Setup
+	err := setupPriorities(&PriorityConfig{
+		Methods: map[string]string{"eth_sendRawTransaction": "high", "eth_getLogs": "low"},
+		Clients: map[string]string{"tenant-key": "high", "10.0.0.9": "low"},
+	})
+	if err != nil {
+		t.Fatalf("Invalid priorities: %v", err)
+	}
+	defer setupPriorities(nil)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		apiKey     string
+		body       string
+		expected   priority
+	}{
+		{"unlisted method", "10.0.0.1:1000", "", `{"method":"eth_call"}`, priorityNormal},
+		{"low method", "10.0.0.1:1000", "", `{"method":"eth_getLogs"}`, priorityLow},
+		{"high method", "10.0.0.1:1000", "", `{"method":"eth_sendRawTransaction"}`, priorityHigh},
+		{"batch takes highest", "10.0.0.1:1000", "", `[{"method":"eth_getLogs"},{"method":"eth_call"}]`, priorityNormal},
+		{"listed API key", "10.0.0.1:1000", "tenant-key", `{"method":"eth_getLogs"}`, priorityHigh},
+		{"listed IP", "10.0.0.9:1000", "", `{"method":"eth_sendRawTransaction"}`, priorityLow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.RemoteAddr = tt.remoteAddr
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			got := requestPriority(r, []byte(tt.body))
+
+			// Verify
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// Verify unknown classes are rejected
+	if err := setupPriorities(&PriorityConfig{Methods: map[string]string{"eth_call": "urgent"}}); err == nil {
+		t.Errorf("Expected an error for an unknown class")
+	}
+}
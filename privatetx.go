@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// Private transaction routing
+//
+// Transaction submissions can be sent to a private relay such as Flashbots Protect
+// instead of the public mempool. Routing can be limited to clients that send a gate
+// header (optionally with one of a set of accepted values, which can act as keys).
+// When a signing key is configured, every request to the relay carries a
+// Flashbots-style signature header: "<address>:<signature>", where the signature is an
+// EIP-191 personal_sign of the hex-encoded keccak256 hash of the request body.
+// Submissions sent to the relay skip the rewriting, param rules, header rules, and
+// mirror of the route matching them, so that nothing meant for the route's own
+// upstream, such as its credentials, reaches the relay; a warning is logged at startup
+// for each such route.
+
+// PrivateTxConfig configures routing of transaction submissions to a private relay.
+type PrivateTxConfig struct {
+	URL             string   `yaml:"url"`              // Private relay URL (e.g., "https://rpc.flashbots.net")
+	Name            string   `yaml:"name"`             // A human-readable name for the relay (for logging)
+	Methods         []string `yaml:"methods"`          // Methods to route privately (default: eth_sendRawTransaction)
+	GateHeader      string   `yaml:"gate_header"`      // Only route privately when this request header is present (optional)
+	GateValues      []string `yaml:"gate_values"`      // Accepted values of the gate header; any non-empty value if unset
+	SigningKey      string   `yaml:"signing_key"`      // Hex secp256k1 private key used to sign relay requests (optional)
+	SigningKeyEnv   string   `yaml:"signing_key_env"`  // Environment variable holding the signing key (optional)
+	SignatureHeader string   `yaml:"signature_header"` // Header carrying the signature (default: X-Flashbots-Signature)
+}
+
+// privateTxTransportName is the transport name registered for the private relay.
+const privateTxTransportName = "private-tx"
+
+// privateTxMethods holds the methods routed to the private relay.
+var privateTxMethods map[string]bool
+
+// privateTxSigned records whether requests to the relay are signed.
+var privateTxSigned bool
+
+// setupPrivateTx validates the private relay configuration, builds the method set,
+// and registers the signing transport when a key is configured. It must run before
+// buildTransportMap so the relay URL is bound to the signing transport.
+//
+// Returns:
+//   - error: An error if the signing key is invalid
+func setupPrivateTx() error {
+	privateTxMethods = nil
+	privateTxSigned = false
+	pc := config.PrivateTx
+	if pc == nil || pc.URL == "" {
+		return nil
+	}
+
+	privateTxMethods = make(map[string]bool)
+	methods := pc.Methods
+	if len(methods) == 0 {
+		methods = []string{"eth_sendRawTransaction"}
+	}
+	for _, m := range methods {
+		privateTxMethods[m] = true
+	}
+	warnBypassedRouteRules("private_tx", privateTxMethods)
+
+	keyHex := pc.SigningKey
+	if pc.SigningKeyEnv != "" {
+		keyHex = os.Getenv(pc.SigningKeyEnv)
+	}
+	if keyHex == "" {
+		return nil
+	}
+
+	key, err := parsePrivateKey(keyHex)
+	if err != nil {
+		return fmt.Errorf("private_tx signing key: %w", err)
+	}
+	header := pc.SignatureHeader
+	if header == "" {
+		header = "X-Flashbots-Signature"
+	}
+	RegisterTransport(privateTxTransportName, &signingTransport{key: key, header: header, next: defaultTransport})
+	privateTxSigned = true
+	return nil
+}
+
+// privateTxTarget reports whether a call should go to the private relay and returns the relay upstream.
+//
+// Parameters:
+//   - r: The incoming HTTP request (for the gate header)
+//   - method: The JSON-RPC method of the call
+//
+// Returns:
+//   - Upstream: The private relay
+//   - bool: Whether the call should be routed to the relay
+func privateTxTarget(r *http.Request, method string) (Upstream, bool) {
+	pc := config.PrivateTx
+	if pc == nil || !privateTxMethods[method] {
+		return Upstream{}, false
+	}
+
+	if pc.GateHeader != "" {
+		value := r.Header.Get(pc.GateHeader)
+		if value == "" {
+			return Upstream{}, false
+		}
+		if len(pc.GateValues) > 0 {
+			accepted := false
+			for _, v := range pc.GateValues {
+				if v == value {
+					accepted = true
+					break
+				}
+			}
+			if !accepted {
+				return Upstream{}, false
+			}
+		}
+	}
+
+	name := pc.Name
+	if name == "" {
+		name = pc.URL
+	}
+	return Upstream{Name: name, URL: pc.URL}, true
+}
+
+// signingTransport adds a Flashbots-style signature header to each request.
+type signingTransport struct {
+	key    *secp256k1.PrivateKey
+	header string
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signature, err := flashbotsSignature(t.key, body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	out.Header.Set(t.header, signature)
+	return t.next.RoundTrip(out)
+}
+
+// flashbotsSignature computes the "<address>:<signature>" header value for a request body.
+//
+// Parameters:
+//   - key: The signing key
+//   - body: The exact request body sent to the relay
+//
+// Returns:
+//   - string: The header value
+//   - error: An error if signing fails
+func flashbotsSignature(key *secp256k1.PrivateKey, body []byte) (string, error) {
+	bodyHash := "0x" + hex.EncodeToString(keccak256(body))
+	digest := keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(bodyHash), bodyHash)))
+
+	// SignCompact returns [27+recovery id][R][S]; Ethereum expects [R][S][V]
+	compact := ecdsa.SignCompact(key, digest, false)
+	if len(compact) != 65 {
+		return "", fmt.Errorf("unexpected signature length %d", len(compact))
+	}
+	sig := append(compact[1:65:65], compact[0])
+
+	return ethereumAddress(key.PubKey()) + ":0x" + hex.EncodeToString(sig), nil
+}
+
+// parsePrivateKey parses a hex-encoded secp256k1 private key, with or without a 0x prefix.
+func parsePrivateKey(keyHex string) (*secp256k1.PrivateKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(keyHex), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes, got %d", len(raw))
+	}
+	return secp256k1.PrivKeyFromBytes(raw), nil
+}
+
+// ethereumAddress derives the EIP-55 checksummed address of a public key.
+func ethereumAddress(pub *secp256k1.PublicKey) string {
+	addr := keccak256(pub.SerializeUncompressed()[1:])[12:]
+	return checksumAddress(hex.EncodeToString(addr))
+}
+
+// checksumAddress applies EIP-55 mixed-case checksum encoding to a lowercase hex address.
+func checksumAddress(lowerHex string) string {
+	hash := hex.EncodeToString(keccak256([]byte(lowerHex)))
+	out := []byte(lowerHex)
+	for i, c := range out {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			out[i] = c - 32
+		}
+	}
+	return "0x" + string(out)
+}
+
+// keccak256 returns the legacy Keccak-256 hash used by Ethereum.
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
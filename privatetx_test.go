@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// testSigningKey is a throwaway secp256k1 key used only in tests
+const testSigningKey = "0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+// TestPrivateTxRouting tests that gated transaction submissions go to the signed private relay
+func TestPrivateTxRouting(t *testing.T) {
+	// Setup
+	var gotSignature string
+	var gotBody []byte
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get("X-Flashbots-Signature")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"jsonrpc":"2.0","result":"private","id":1}`))
+	}))
+	defer relay.Close()
+
+	public := mockHTTPServer(t, "eth_sendRawTransaction", `{"jsonrpc":"2.0","result":"public","id":1}`)
+	defer public.Close()
+
+	config = Config{
+		DefaultURL: public.URL,
+		PrivateTx: &PrivateTxConfig{
+			URL:        relay.URL,
+			GateHeader: "X-Private-Tx",
+			SigningKey: testSigningKey,
+		},
+	}
+	buildMethodURLMap()
+	if err := setupPrivateTx(); err != nil {
+		t.Fatalf("Failed to set up private tx routing: %v", err)
+	}
+	if err := buildTransportMap(); err != nil {
+		t.Fatalf("Failed to build transport map: %v", err)
+	}
+	defer func() {
+		config.PrivateTx = nil
+		privateTxMethods = nil
+		privateTxSigned = false
+		urlToTransport = nil
+	}()
+
+	body := `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x00"],"id":1}`
+	testCases := []struct {
+		name     string
+		gate     string
+		expected string
+	}{
+		{"Without gate header", "", "public"},
+		{"With gate header", "1", "private"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			if tc.gate != "" {
+				req.Header.Set("X-Private-Tx", tc.gate)
+			}
+			w := httptest.NewRecorder()
+
+			// Test
+			handleProxy(w, req)
+
+			// Verify
+			respBody, _ := io.ReadAll(w.Result().Body)
+			if !strings.Contains(string(respBody), tc.expected) {
+				t.Errorf("Expected %s upstream, got %s", tc.expected, string(respBody))
+			}
+		})
+	}
+
+	// Verify the relay request was signed over the exact body it received
+	parts := strings.SplitN(gotSignature, ":", 2)
+	if len(parts) != 2 {
+		t.Fatalf("Expected address:signature header, got %q", gotSignature)
+	}
+	if !bytes.Equal(gotBody, []byte(body)) {
+		t.Errorf("Relay received modified body: %s", string(gotBody))
+	}
+	assertFlashbotsSignature(t, parts[0], parts[1], gotBody)
+}
+
+// assertFlashbotsSignature recovers the signer of a Flashbots signature and compares addresses
+func assertFlashbotsSignature(t *testing.T, address, signature string, body []byte) {
+	t.Helper()
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		t.Fatalf("Invalid signature encoding %q", signature)
+	}
+
+	bodyHash := "0x" + hex.EncodeToString(keccak256(body))
+	digest := keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(bodyHash), bodyHash)))
+	compact := append([]byte{sig[64]}, sig[:64]...)
+	pub, _, err := ecdsa.RecoverCompact(compact, digest)
+	if err != nil {
+		t.Fatalf("Failed to recover signer: %v", err)
+	}
+
+	if recovered := ethereumAddress(pub); recovered != address {
+		t.Errorf("Expected signer %s, recovered %s", address, recovered)
+	}
+}
+
+// TestChecksumAddress tests EIP-55 encoding against a known vector
+func TestChecksumAddress(t *testing.T) {
+	expected := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	if got := checksumAddress(strings.ToLower(expected[2:])); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
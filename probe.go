@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upstream probing and head tracking
+//
+// When enabled, every configured upstream is polled with eth_blockNumber at a fixed
+// interval. The probe records each upstream's chain head, response latency, and
+// whether the last probe succeeded. Other features read this state instead of
+// issuing their own calls.
+
+// ProbeConfig configures periodic upstream probing.
+type ProbeConfig struct {
+	Interval  time.Duration `yaml:"interval"`   // Time between probes (e.g., "5s"); probing is disabled when zero
+	Timeout   time.Duration `yaml:"timeout"`    // Timeout for a single probe (default: 3s)
+	SlowStart time.Duration `yaml:"slow_start"` // Time over which a recovered upstream regains its share of traffic (see slowstart.go)
+}
+
+// upstreamStatus is the last known state of an upstream.
+type upstreamStatus struct {
+	Name        string        // Display name of the upstream
+	URL         string        // Upstream URL
+	Height      uint64        // Latest block number reported
+	Latency     time.Duration // Round-trip time of the last successful probe
+	Healthy     bool          // Whether the last probe succeeded
+	LastError   string        // Error of the last failed probe
+	LastProbe   time.Time     // Time of the last probe
+	RecoveredAt time.Time     // Time the upstream last recovered from a failed probe
+}
+
+var (
+	statusMu         sync.RWMutex
+	upstreamStatuses map[string]*upstreamStatus // Probe state by upstream URL
+)
+
+// defaultProbeInterval is used when a feature needs head tracking but no interval is configured.
+const defaultProbeInterval = 5 * time.Second
+
+// probeInterval returns the configured probe interval, or the default when a feature
+// that depends on head tracking is enabled. It returns zero when probing is off.
+func probeInterval() time.Duration {
+	if config.Probe != nil && config.Probe.Interval > 0 {
+		return config.Probe.Interval
+	}
+	if config.Filters != nil && config.Filters.Enabled {
+		return defaultProbeInterval
+	}
+	if config.Cache != nil && len(config.Cache.Warm) > 0 {
+		return defaultProbeInterval
+	}
+	return 0
+}
+
+// probeTargets returns the distinct upstreams to probe, keyed by URL with their display names.
+func probeTargets() map[string]string {
+	targets := make(map[string]string)
+	if config.DefaultURL != "" {
+		targets[config.DefaultURL] = config.DefaultName
+	}
+	for _, route := range config.Routes {
+		if _, ok := targets[route.URL]; ok || route.URL == "" {
+			continue
+		}
+		name := route.Name
+		if name == "" {
+			name = route.URL
+		}
+		targets[route.URL] = name
+	}
+	members := poolMembers()
+	if config.WriteRouting != nil && config.WriteRouting.Enabled {
+		members = append(members, config.WriteRouting.Upstreams...)
+	}
+	for _, uc := range members {
+		if _, ok := targets[uc.URL]; !ok {
+			targets[uc.URL] = uc.upstream().Name
+		}
+	}
+	return targets
+}
+
+// startProbes begins probing all upstreams in the background until ctx is done.
+// It does nothing if probing is disabled.
+//
+// Parameters:
+//   - ctx: The context that stops probing when cancelled
+func startProbes(ctx context.Context) {
+	interval := probeInterval()
+	if interval <= 0 {
+		return
+	}
+
+	targets := probeTargets()
+	statusMu.Lock()
+	upstreamStatuses = make(map[string]*upstreamStatus, len(targets))
+	for url, name := range targets {
+		upstreamStatuses[url] = &upstreamStatus{Name: name, URL: url}
+	}
+	statusMu.Unlock()
+
+	log.Printf("Probing %d upstreams every %s", len(targets), interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			probeAll(ctx, targets)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Pick up upstreams discovered since the last round
+			targets = probeTargets()
+			trackTargets(targets)
+		}
+	}()
+}
+
+// trackTargets starts tracking the status of upstreams not tracked yet.
+func trackTargets(targets map[string]string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for url, name := range targets {
+		if _, ok := upstreamStatuses[url]; !ok {
+			upstreamStatuses[url] = &upstreamStatus{Name: name, URL: url}
+		}
+	}
+}
+
+// probeAll probes every upstream concurrently and waits for the probes to finish.
+func probeAll(ctx context.Context, targets map[string]string) {
+	var wg sync.WaitGroup
+	for url := range targets {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			probeUpstream(ctx, url)
+		}(url)
+	}
+	wg.Wait()
+}
+
+// probeUpstream queries an upstream's block number and records the outcome.
+//
+// Parameters:
+//   - ctx: The parent context
+//   - url: The upstream URL
+func probeUpstream(ctx context.Context, url string) {
+	timeout := 3 * time.Second
+	if config.Probe != nil && config.Probe.Timeout > 0 {
+		timeout = config.Probe.Timeout
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	raw, err := callUpstream(probeCtx, url, "eth_blockNumber", nil)
+	latency := time.Since(start)
+
+	var height uint64
+	if err == nil {
+		height, err = parseQuantityResult(raw)
+	}
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status, ok := upstreamStatuses[url]
+	if !ok {
+		return
+	}
+	status.LastProbe = time.Now()
+	if err != nil {
+		if status.Healthy || status.LastError == "" {
+			logWarn("healthcheck", "Upstream %s failed probe: %v", status.Name, err)
+		}
+		status.Healthy = false
+		status.LastError = err.Error()
+		return
+	}
+	if !status.Healthy && status.LastError != "" {
+		logInfo("healthcheck", "Upstream %s recovered at block %d", status.Name, height)
+		status.RecoveredAt = time.Now()
+	}
+	status.Healthy = true
+	status.LastError = ""
+	status.Latency = latency
+	if height > status.Height {
+		status.Height = height
+		go onNewHead(url, height)
+	}
+}
+
+// trackedHead returns the last head observed for an upstream by a successful probe.
+//
+// Parameters:
+//   - url: The upstream URL
+//
+// Returns:
+//   - uint64: The block number
+//   - bool: Whether a head is known for the upstream
+func trackedHead(url string) (uint64, bool) {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	status, ok := upstreamStatuses[url]
+	if !ok || status.Height == 0 {
+		return 0, false
+	}
+	return status.Height, true
+}
+
+// upstreamHealthy reports whether an upstream may receive traffic. Upstreams that
+// are not probed, or not probed yet, are assumed healthy.
+func upstreamHealthy(url string) bool {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	status, ok := upstreamStatuses[url]
+	return !ok || status.LastProbe.IsZero() || status.Healthy
+}
+
+// currentHead returns the upstream's head, using the tracked value when available and
+// querying the upstream otherwise.
+//
+// Parameters:
+//   - ctx: The context bounding a direct query
+//   - url: The upstream URL
+//
+// Returns:
+//   - uint64: The block number
+//   - error: An error if the head is unknown and the query fails
+func currentHead(ctx context.Context, url string) (uint64, error) {
+	if head, ok := trackedHead(url); ok {
+		return head, nil
+	}
+	raw, err := callUpstream(ctx, url, "eth_blockNumber", nil)
+	if err != nil {
+		return 0, err
+	}
+	return parseQuantityResult(raw)
+}
+
+// upstreamStatusSnapshot returns copies of all upstream statuses sorted by name.
+func upstreamStatusSnapshot() []upstreamStatus {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+
+	out := make([]upstreamStatus, 0, len(upstreamStatuses))
+	for _, status := range upstreamStatuses {
+		out = append(out, *status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
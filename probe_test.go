@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProbeUpstream tests that probes record heads and failures
+func TestProbeUpstream(t *testing.T) {
+	// Setup
+	head := &atomic.Uint64{}
+	head.Store(42)
+	healthy := mockChainServer(t, head)
+	defer healthy.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	config = Config{
+		DefaultURL:  healthy.URL,
+		DefaultName: "healthy",
+		Routes:      []Route{{Method: "m", URL: broken.URL, Name: "broken"}},
+	}
+	targets := probeTargets()
+	statusMu.Lock()
+	upstreamStatuses = map[string]*upstreamStatus{}
+	for url, name := range targets {
+		upstreamStatuses[url] = &upstreamStatus{Name: name, URL: url}
+	}
+	statusMu.Unlock()
+	defer func() { upstreamStatuses = nil }()
+
+	// Test
+	probeAll(context.Background(), targets)
+
+	// Verify
+	if h, ok := trackedHead(healthy.URL); !ok || h != 42 {
+		t.Errorf("Expected tracked head 42, got %d (known: %v)", h, ok)
+	}
+	if _, ok := trackedHead(broken.URL); ok {
+		t.Error("Expected no tracked head for broken upstream")
+	}
+
+	statuses := upstreamStatusSnapshot()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %d", len(statuses))
+	}
+	if statuses[0].Name != "broken" || statuses[0].Healthy || statuses[0].LastError == "" {
+		t.Errorf("Expected broken upstream to be unhealthy with an error, got %+v", statuses[0])
+	}
+	if statuses[1].Name != "healthy" || !statuses[1].Healthy {
+		t.Errorf("Expected healthy upstream to be healthy, got %+v", statuses[1])
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol
+//
+// TCP load balancers cannot add X-Forwarded-For, so behind one every request seems to
+// come from the balancer. Balancers such as HAProxy, AWS NLB, and GCP TCP proxies can
+// instead announce the client's address in a PROXY protocol header (version 1, text,
+// or version 2, binary) sent before the first byte of the connection:
+//
+//	proxy_protocol:
+//	  enabled: true
+//	  trusted: [10.0.0.0/8]   # peers allowed to send the header (required)
+//	  header_timeout: 5s      # longest wait for the header (default: 5s)
+//
+// trusted is required, since a peer allowed to send the header chooses the client IP
+// seen by rate limits, ACLs, and the logs. A connection from a trusted peer that
+// starts with a PROXY header takes the client address from it, for client IPs
+// everywhere (see clients.go); one without a header, such as a health check, or with a
+// LOCAL or UNKNOWN header, keeps the peer address. Connections with a malformed header
+// are closed. Untrusted peers are never parsed, so their header fails as a malformed
+// HTTP request. Only the proxy port accepts the header; the admin, metrics, and gRPC
+// listeners do not.
+
+// ProxyProtocolConfig configures PROXY protocol headers on the proxy listener.
+type ProxyProtocolConfig struct {
+	Enabled       bool          `yaml:"enabled"`        // Accept PROXY protocol v1 and v2 headers
+	Trusted       []string      `yaml:"trusted"`        // Peer IPs or CIDRs allowed to send the header (required)
+	HeaderTimeout time.Duration `yaml:"header_timeout"` // Longest wait for the header (default: 5s)
+}
+
+// defaultProxyHeaderTimeout is the default header_timeout.
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest PROXY protocol v1 header, CRLF included.
+const proxyV1MaxLength = 107
+
+// listen opens the proxy listener, limiting its connections (see server.go) and
+// accepting PROXY protocol headers if configured.
+//
+// Parameters:
+//   - addr: The listen address
+//
+// Returns:
+//   - net.Listener: The listener
+//   - error: An error if the configuration is invalid or the address cannot be bound
+func listen(addr string) (net.Listener, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if sc := config.Server; sc != nil {
+		lis = limitConnections(lis, sc.MaxConnections)
+	}
+	pc := config.ProxyProtocol
+	if pc == nil || !pc.Enabled {
+		return lis, nil
+	}
+	if len(pc.Trusted) == 0 {
+		lis.Close()
+		return nil, fmt.Errorf("proxy_protocol.trusted is required, since any peer allowed to send the header can choose its client IP")
+	}
+	trusted, err := parseNets("proxy_protocol.trusted", pc.Trusted)
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+	if pc.HeaderTimeout < 0 {
+		lis.Close()
+		return nil, fmt.Errorf("proxy_protocol.header_timeout cannot be negative")
+	}
+	timeout := pc.HeaderTimeout
+	if timeout == 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	log.Printf("Accepting PROXY protocol headers on %s", addr)
+	return &proxyProtoListener{Listener: lis, trusted: trusted, timeout: timeout}, nil
+}
+
+// proxyProtoListener accepts connections that may start with a PROXY header.
+type proxyProtoListener struct {
+	net.Listener
+	trusted []*net.IPNet  // Peers allowed to send the header
+	timeout time.Duration // Longest wait for the header
+}
+
+// Accept implements net.Listener. Headers are read by the connection's first Read or
+// RemoteAddr, on the goroutine serving it, so a slow peer does not hold up others.
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !inNets(addr.IP, l.trusted) {
+		return conn, nil
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyProtoConn is a connection whose client address may come from a PROXY header.
+type proxyProtoConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr // Client address from the header, or nil to keep the peer's
+	err     error    // Error reading the header
+}
+
+// Read implements net.Conn.
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr implements net.Conn, returning the client address of the header.
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader reads the PROXY header at the start of the connection, if any.
+func (c *proxyProtoConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		// Let the HTTP server see the closed or silent connection
+		return
+	}
+	switch first[0] {
+	case 'P':
+		c.remote, c.err = readProxyV1(c.reader)
+	case proxyV2Signature[0]:
+		c.remote, c.err = readProxyV2(c.reader)
+	}
+	if c.err != nil {
+		logWarn("router", "Closing connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+		c.Conn.Close()
+	}
+}
+
+// readProxyV1 reads a text header, such as "PROXY TCP4 192.0.2.1 10.0.0.1 56324 8545".
+//
+// Parameters:
+//   - r: The connection's reader, positioned at a byte 'P'
+//
+// Returns:
+//   - net.Addr: The client address, or nil for an UNKNOWN header or plain HTTP
+//   - error: An error if the header is malformed
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(6)
+	if err != nil || string(prefix) != "PROXY " {
+		// An HTTP method starting with P
+		return nil, nil
+	}
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("PROXY v1 header longer than %d bytes", proxyV1MaxLength)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+//
+// Parameters:
+//   - r: The connection's reader, positioned at the first byte of the signature
+//
+// Returns:
+//   - net.Addr: The client address, or nil for a LOCAL header, a non-IP family, or plain HTTP
+//   - error: An error if the header is malformed
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header, err := r.Peek(16)
+	if err != nil {
+		return nil, fmt.Errorf("reading PROXY v2 header: %w", err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	command, family := header[12]&0x0f, header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	block := make([]byte, 16+length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, fmt.Errorf("reading PROXY v2 header: %w", err)
+	}
+	addresses := block[16:]
+
+	if command == 0x0 {
+		// LOCAL: a connection of the balancer's own, such as a health check
+		return nil, nil
+	}
+	if command != 0x1 {
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+	switch family >> 4 {
+	case 0x1:
+		if len(addresses) < 12 {
+			return nil, fmt.Errorf("PROXY v2 IPv4 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(addresses[0:4]), Port: int(binary.BigEndian.Uint16(addresses[8:10]))}, nil
+	case 0x2:
+		if len(addresses) < 36 {
+			return nil, fmt.Errorf("PROXY v2 IPv6 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(addresses[0:16]), Port: int(binary.BigEndian.Uint16(addresses[32:34]))}, nil
+	}
+	// Unix sockets and unspecified families keep the peer address
+	return nil, nil
+}
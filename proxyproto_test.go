@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a PROXY protocol v2 header for a TCP over IPv4 connection.
+func proxyV2Header(command byte, src net.IP, port uint16) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, 0x11, 0, 12)
+	header = append(header, src.To4()...)
+	header = append(header, 127, 0, 0, 1)
+	header = binary.BigEndian.AppendUint16(header, port)
+	return binary.BigEndian.AppendUint16(header, 8545)
+}
+
+// TestProxyProtocol tests recovering client addresses from PROXY protocol headers
+func TestProxyProtocol(t *testing.T) {
+	// Setup
+	config = Config{ProxyProtocol: &ProxyProtocolConfig{Enabled: true, Trusted: []string{"127.0.0.1"}}}
+	defer func() { config = Config{} }()
+	listener, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, clientIP(r))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+	request := func(header []byte) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.Write(append(header, "GET / HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n\r\n"...))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "closed"
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"no header", nil, "127.0.0.1"},
+		{"v1 header", []byte("PROXY TCP4 198.51.100.1 127.0.0.1 56324 8545\r\n"), "198.51.100.1"},
+		{"v1 IPv6 header", []byte("PROXY TCP6 2001:db8::1 ::1 56324 8545\r\n"), "2001:db8::1"},
+		{"v1 UNKNOWN header", []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"v2 header", proxyV2Header(0x1, net.IPv4(198, 51, 100, 2), 40000), "198.51.100.2"},
+		{"v2 LOCAL header", proxyV2Header(0x0, net.IPv4(198, 51, 100, 2), 40000), "127.0.0.1"},
+		{"malformed v1 header", []byte("PROXY TCP4 nonsense\r\n"), "closed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			got := request(tt.header)
+
+			// Verify
+			if got != tt.expected {
+				t.Errorf("Expected client %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestProxyProtocolUntrustedPeer tests that headers from untrusted peers are not parsed
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	// Setup
+	config = Config{ProxyProtocol: &ProxyProtocolConfig{Enabled: true, Trusted: []string{"192.0.2.0/24"}}}
+	defer func() { config = Config{} }()
+	listener, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, clientIP(r))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// Test
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 198.51.100.1 127.0.0.1 56324 8545\r\nGET / HTTP/1.1\r\nHost: proxy\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Expected an HTTP error response, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || strings.Contains(string(body), "198.51.100.1") {
+		t.Errorf("Expected the header to be rejected as HTTP, got %d %s", resp.StatusCode, body)
+	}
+}
+
+// TestProxyProtocolRequiresTrusted tests that the header is not accepted from every peer
+func TestProxyProtocolRequiresTrusted(t *testing.T) {
+	// Setup
+	config = Config{ProxyProtocol: &ProxyProtocolConfig{Enabled: true}}
+	defer func() { config = Config{} }()
+
+	// Test
+	listener, err := listen("127.0.0.1:0")
+
+	// Verify
+	if err == nil {
+		listener.Close()
+		t.Fatalf("Expected an error without trusted peers")
+	}
+	if !strings.Contains(err.Error(), "proxy_protocol.trusted is required") {
+		t.Errorf("Expected the error to name proxy_protocol.trusted, got %v", err)
+	}
+}
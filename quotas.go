@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider quotas
+//
+// Plans with providers come with monthly or daily allowances of requests or compute
+// units, billed at a premium, or cut off, once exceeded. The proxy can count what it
+// sends to each upstream, by name or URL, against its allowances, and move traffic to
+// other providers before they run out:
+//
+//	upstream_quotas:
+//	  upstreams:
+//	    alchemy:
+//	      monthly: 300000000     # units per calendar month (UTC)
+//	      daily: 12000000        # units per calendar day (UTC)
+//	  costs:                     # units by method, or prefix ending with * (default: 1 per call)
+//	    eth_getLogs: 75
+//	    debug_*: 300
+//	  shift_at: 0.9              # share of a quota at which traffic shifts away (default: 0.9)
+//	  alert_at: [0.8, 0.9, 1]    # shares of a quota at which alerts are raised (default)
+//	  alert_webhook: https://hooks.example.com/quotas
+//	  state_file: /var/lib/jsonrpc-proxy/quotas.json
+//
+// Every call of a request sent to an upstream is charged its cost, whatever the
+// response, since providers bill failed calls as well, and so is every retry of the
+// request (see retries.go). Once an upstream has used shift_at of a quota, round robin
+// pools pass over it while another member is below its own (see pools.go); it is
+// still used when every member is past its quota, so that calls are served rather
+// than failed. Calls routed to the upstream alone, rather
+// than through a pool, are not shifted.
+//
+// Crossing an alert_at share logs a warning and, with alert_webhook, posts
+// {"upstream","period","threshold","used","quota"} to the webhook, once per threshold
+// and period. Usage is exported as jsonrpc_proxy_upstream_quota_used{upstream,period}
+// next to jsonrpc_proxy_upstream_quota_limit, for alerting rules. Usage starts over
+// with each day and month, and survives restarts when kept in state_file, which is
+// written every 30 seconds.
+
+// UpstreamQuotasConfig configures the provider quotas.
+type UpstreamQuotasConfig struct {
+	Upstreams    map[string]*UpstreamQuota `yaml:"upstreams"`     // Quotas by upstream name or URL
+	Costs        map[string]float64        `yaml:"costs"`         // Units by method, or prefix ending with * (default: 1)
+	ShiftAt      float64                   `yaml:"shift_at"`      // Share of a quota at which traffic shifts away (default: 0.9)
+	AlertAt      []float64                 `yaml:"alert_at"`      // Shares of a quota at which alerts are raised (default: 0.8, 0.9, 1)
+	AlertWebhook string                    `yaml:"alert_webhook"` // URL receiving alerts as JSON (optional)
+	StateFile    string                    `yaml:"state_file"`    // File keeping usage across restarts (optional)
+}
+
+// UpstreamQuota is the allowance of an upstream.
+type UpstreamQuota struct {
+	Monthly float64 `yaml:"monthly"` // Units per calendar month, in UTC (0: unlimited)
+	Daily   float64 `yaml:"daily"`   // Units per calendar day, in UTC (0: unlimited)
+}
+
+// Quota defaults.
+const (
+	defaultQuotaShiftAt  = 0.9
+	quotaPersistInterval = 30 * time.Second
+	quotaWebhookTimeout  = 5 * time.Second
+)
+
+// Quota periods
+const (
+	quotaPeriodDaily   = "daily"
+	quotaPeriodMonthly = "monthly"
+)
+
+// defaultQuotaAlerts are the shares of a quota at which alerts are raised by default.
+var defaultQuotaAlerts = []float64{0.8, 0.9, 1}
+
+// quotaUsage is an upstream's usage in the current day and month.
+type quotaUsage struct {
+	Day     string  `json:"day"`   // Current day, as 2006-01-02
+	Daily   float64 `json:"daily"` // Units used on that day
+	Month   string  `json:"month"` // Current month, as 2006-01
+	Monthly float64 `json:"monthly"`
+}
+
+// upstreamQuota tracks the usage of an upstream against its quota.
+type upstreamQuota struct {
+	key     string // Name or URL the quota is configured under
+	label   string
+	limits  UpstreamQuota
+	webhook string // URL receiving alerts, or empty for none
+
+	mu      sync.Mutex
+	usage   quotaUsage
+	alerted map[string]float64 // Highest threshold alerted in the current period, by period
+}
+
+// quotaAlert is the body posted to the alert webhook.
+type quotaAlert struct {
+	Upstream  string    `json:"upstream"`
+	Period    string    `json:"period"` // daily or monthly
+	Threshold float64   `json:"threshold"`
+	Used      float64   `json:"used"`
+	Quota     float64   `json:"quota"`
+	Time      time.Time `json:"time"`
+}
+
+var (
+	quotas        map[string]*upstreamQuota // Quotas by upstream URL, or nil when quotas are off
+	quotaShiftAt  float64
+	quotaAlertsAt []float64 // Sorted
+)
+
+// quotaRegistered registers the quota gauges with the first quota configuration.
+var quotaRegistered sync.Once
+
+// setupUpstreamQuotas builds the quotas of the configured upstreams, restoring their
+// usage from the state file.
+//
+// Returns:
+//   - error: An error if a quota, cost, or share is invalid, or the state file cannot be read
+func setupUpstreamQuotas() error {
+	quotas = nil
+	qc := config.UpstreamQuotas
+	if qc == nil {
+		return nil
+	}
+	for _, key := range sortedKeys(qc.Upstreams) {
+		if q := qc.Upstreams[key]; q != nil && (q.Monthly < 0 || q.Daily < 0) {
+			return fmt.Errorf("upstreams: %s: monthly and daily cannot be negative", upstreamKeyLabel(key))
+		}
+	}
+	for _, method := range sortedKeys(qc.Costs) {
+		if qc.Costs[method] < 0 {
+			return fmt.Errorf("costs.%s cannot be negative", method)
+		}
+	}
+	quotaShiftAt = defaultQuotaShiftAt
+	if qc.ShiftAt < 0 || qc.ShiftAt > 1 {
+		return fmt.Errorf("shift_at must be between 0 and 1")
+	}
+	if qc.ShiftAt > 0 {
+		quotaShiftAt = qc.ShiftAt
+	}
+	quotaAlertsAt = append([]float64{}, defaultQuotaAlerts...)
+	if len(qc.AlertAt) > 0 {
+		quotaAlertsAt = append([]float64{}, qc.AlertAt...)
+	}
+	for _, share := range quotaAlertsAt {
+		if share <= 0 {
+			return fmt.Errorf("alert_at shares must be positive")
+		}
+	}
+	sort.Float64s(quotaAlertsAt)
+	if qc.AlertWebhook != "" {
+		if err := checkUpstreamURL(qc.AlertWebhook); err != nil {
+			return fmt.Errorf("alert_webhook: %w", err)
+		}
+	}
+
+	quotas = make(map[string]*upstreamQuota)
+	for url, name := range probeTargets() {
+		key := name
+		q, ok := qc.Upstreams[key]
+		if !ok || name == "" {
+			key = url
+			q = qc.Upstreams[key]
+		}
+		if q == nil || q.Monthly == 0 && q.Daily == 0 {
+			continue
+		}
+		quotas[url] = &upstreamQuota{key: key, label: upstreamLabel(Upstream{Name: name, URL: url}), limits: *q, webhook: qc.AlertWebhook, alerted: make(map[string]float64)}
+	}
+	if qc.StateFile != "" {
+		if err := loadQuotaState(qc.StateFile); err != nil {
+			return fmt.Errorf("state_file: %w", err)
+		}
+		registerDependency("quota state "+qc.StateFile, "quota_state", "quota usage is lost on restart")
+	}
+	quotaRegistered.Do(func() { registerMetric(quotaCollector{}) })
+	for _, url := range sortedKeys(quotas) {
+		q := quotas[url]
+		log.Printf("Counting usage of %s against %s", q.label, q.limits)
+	}
+	return nil
+}
+
+// String describes the quota.
+func (q UpstreamQuota) String() string {
+	var limits []string
+	if q.Monthly > 0 {
+		limits = append(limits, fmt.Sprintf("%s units per month", formatFloat(q.Monthly)))
+	}
+	if q.Daily > 0 {
+		limits = append(limits, fmt.Sprintf("%s units per day", formatFloat(q.Daily)))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// quotaCost returns the units of a call: the cost of its method, or of the longest
+// prefix matching it.
+func quotaCost(method string) float64 {
+	costs := config.UpstreamQuotas.Costs
+	if cost, ok := costs[method]; ok {
+		return cost
+	}
+	cost, longest := 1.0, -1
+	for pattern, c := range costs {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(method, prefix) && len(prefix) > longest {
+			cost, longest = c, len(prefix)
+		}
+	}
+	return cost
+}
+
+// chargeUpstreamQuota charges the calls of a request sent to an upstream to its quota.
+//
+// Parameters:
+//   - url: The upstream URL
+//   - body: The request body
+func chargeUpstreamQuota(url string, body []byte) {
+	q := quotas[url]
+	if q == nil {
+		return
+	}
+	units := 0.0
+	for _, call := range parseCalls(body) {
+		units += quotaCost(call.Method)
+	}
+	q.charge(units, time.Now())
+}
+
+// roll starts a new day or month if the period has changed. Callers hold q.mu.
+func (q *upstreamQuota) roll(now time.Time) {
+	now = now.UTC()
+	if day := now.Format("2006-01-02"); q.usage.Day != day {
+		q.usage.Day, q.usage.Daily = day, 0
+		delete(q.alerted, quotaPeriodDaily)
+	}
+	if month := now.Format("2006-01"); q.usage.Month != month {
+		q.usage.Month, q.usage.Monthly = month, 0
+		delete(q.alerted, quotaPeriodMonthly)
+	}
+}
+
+// charge adds units to the usage, raising alerts for the thresholds crossed.
+func (q *upstreamQuota) charge(units float64, now time.Time) {
+	q.mu.Lock()
+	q.roll(now)
+	q.usage.Daily += units
+	q.usage.Monthly += units
+	var alerts []quotaAlert
+	for _, period := range []struct {
+		name        string
+		used, limit float64
+	}{{quotaPeriodDaily, q.usage.Daily, q.limits.Daily}, {quotaPeriodMonthly, q.usage.Monthly, q.limits.Monthly}} {
+		if period.limit == 0 {
+			continue
+		}
+		crossed := 0.0
+		for _, share := range quotaAlertsAt {
+			if period.used >= share*period.limit {
+				crossed = share
+			}
+		}
+		if crossed > q.alerted[period.name] {
+			q.alerted[period.name] = crossed
+			alerts = append(alerts, quotaAlert{Upstream: q.label, Period: period.name, Threshold: crossed, Used: period.used, Quota: period.limit, Time: now.UTC()})
+		}
+	}
+	q.mu.Unlock()
+
+	for _, alert := range alerts {
+		log.Printf("Warning: %s has used %.0f%% of its %s quota (%s of %s units)", alert.Upstream, alert.Threshold*100, alert.Period, formatFloat(alert.Used), formatFloat(alert.Quota))
+		if q.webhook != "" {
+			go postQuotaAlert(q.webhook, alert)
+		}
+	}
+}
+
+// usedShare returns the larger share used of the daily and monthly quotas.
+func (q *upstreamQuota) usedShare(now time.Time) float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	share := 0.0
+	if q.limits.Daily > 0 {
+		share = q.usage.Daily / q.limits.Daily
+	}
+	if q.limits.Monthly > 0 && q.usage.Monthly/q.limits.Monthly > share {
+		share = q.usage.Monthly / q.limits.Monthly
+	}
+	return share
+}
+
+// upstreamQuotaExhausting reports whether traffic shifts away from an upstream because
+// it has used shift_at of a quota.
+func upstreamQuotaExhausting(url string) bool {
+	q := quotas[url]
+	return q != nil && q.usedShare(time.Now()) >= quotaShiftAt
+}
+
+// postQuotaAlert sends an alert to the webhook.
+func postQuotaAlert(webhook string, alert quotaAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quotaWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: failed to post quota alert for %s: %v", alert.Upstream, err)
+	}
+}
+
+// loadQuotaState restores the usage kept in the state file. A missing file is not an
+// error.
+func loadQuotaState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state map[string]quotaUsage // By quota key
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for _, q := range quotas {
+		if usage, ok := state[q.key]; ok {
+			q.usage = usage
+		}
+	}
+	return nil
+}
+
+// saveQuotaState writes the usage to the state file, through a temporary file.
+func saveQuotaState(path string) error {
+	state := make(map[string]quotaUsage, len(quotas))
+	for _, q := range quotas {
+		q.mu.Lock()
+		state[q.key] = q.usage
+		q.mu.Unlock()
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// startQuotaPersistence writes the usage to the state file periodically until ctx is
+// done. It does nothing without a state file.
+func startQuotaPersistence(ctx context.Context) {
+	qc := config.UpstreamQuotas
+	if quotas == nil || qc.StateFile == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(quotaPersistInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := saveQuotaState(qc.StateFile)
+				reportDependency("quota state "+qc.StateFile, err)
+				if err != nil {
+					log.Printf("Warning: failed to save quota usage: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// quotaCollector exports the usage and limits of the quotas.
+type quotaCollector struct{}
+
+// write implements metricsCollector.
+func (quotaCollector) write(w io.Writer, openMetrics bool) {
+	now := time.Now()
+	type sample struct {
+		upstream, period string
+		used, limit      float64
+	}
+	var samples []sample
+	for _, url := range sortedKeys(quotas) {
+		q := quotas[url]
+		q.mu.Lock()
+		q.roll(now)
+		if q.limits.Daily > 0 {
+			samples = append(samples, sample{q.label, quotaPeriodDaily, q.usage.Daily, q.limits.Daily})
+		}
+		if q.limits.Monthly > 0 {
+			samples = append(samples, sample{q.label, quotaPeriodMonthly, q.usage.Monthly, q.limits.Monthly})
+		}
+		q.mu.Unlock()
+	}
+	labels := []string{"upstream", "period"}
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_upstream_quota_used Units used of an upstream's quota in the current period.\n# TYPE jsonrpc_proxy_upstream_quota_used gauge\n")
+	for _, s := range samples {
+		fmt.Fprintf(w, "jsonrpc_proxy_upstream_quota_used%s %s\n", encodeLabels(labels, []string{s.upstream, s.period}), formatFloat(s.used))
+	}
+	fmt.Fprintf(w, "# HELP jsonrpc_proxy_upstream_quota_limit Units allowed by an upstream's quota per period.\n# TYPE jsonrpc_proxy_upstream_quota_limit gauge\n")
+	for _, s := range samples {
+		fmt.Fprintf(w, "jsonrpc_proxy_upstream_quota_limit%s %s\n", encodeLabels(labels, []string{s.upstream, s.period}), formatFloat(s.limit))
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestUpstreamQuotaAlerts tests charging calls to a quota and alerting at thresholds
+func TestUpstreamQuotaAlerts(t *testing.T) {
+	// Setup
+	alerts := make(chan quotaAlert, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert quotaAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer webhook.Close()
+	config = Config{
+		DefaultURL:  "http://provider",
+		DefaultName: "provider",
+		UpstreamQuotas: &UpstreamQuotasConfig{
+			Upstreams:    map[string]*UpstreamQuota{"provider": {Daily: 100}},
+			Costs:        map[string]float64{"eth_getLogs": 20, "debug_*": 50},
+			AlertWebhook: webhook.URL,
+		},
+	}
+	if err := setupUpstreamQuotas(); err != nil {
+		t.Fatalf("Failed to set up quotas: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		quotas = nil
+	}()
+
+	// Test
+	chargeUpstreamQuota("http://provider", []byte(`[{"method":"eth_getLogs"},{"method":"eth_getLogs"},{"method":"eth_blockNumber"}]`))
+	below := upstreamQuotaExhausting("http://provider")
+	chargeUpstreamQuota("http://provider", []byte(`{"method":"debug_traceTransaction"}`))
+	var alert quotaAlert
+	select {
+	case alert = <-alerts:
+	case <-time.After(time.Second):
+		t.Fatal("Expected an alert to be posted")
+	}
+	var gauges bytes.Buffer
+	quotaCollector{}.write(&gauges, false)
+
+	// Verify
+	if below {
+		t.Errorf("Expected traffic to stay below shift_at at 41 units")
+	}
+	if !upstreamQuotaExhausting("http://provider") {
+		t.Errorf("Expected traffic to shift away at 91 units")
+	}
+	if alert.Upstream != "provider" || alert.Period != "daily" || alert.Threshold != 0.9 || alert.Used != 91 {
+		t.Errorf("Expected a single alert for the 90%% threshold, got %+v", alert)
+	}
+	if !strings.Contains(gauges.String(), `jsonrpc_proxy_upstream_quota_used{upstream="provider",period="daily"} 91`) {
+		t.Errorf("Expected the usage gauge, got:\n%s", gauges.String())
+	}
+}
+
+// TestUpstreamQuotaPeriods tests that usage starts over with each day and month
+func TestUpstreamQuotaPeriods(t *testing.T) {
+	// Setup
+	quotaAlertsAt = defaultQuotaAlerts
+	q := &upstreamQuota{label: "provider", limits: UpstreamQuota{Daily: 100, Monthly: 1000}, alerted: make(map[string]float64)}
+	day := time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC)
+
+	// Test
+	q.charge(90, day)
+	q.charge(5, day.Add(2*time.Hour))
+	nextMonth := q.usage
+	q.charge(5, day.Add(26*time.Hour))
+
+	// Verify
+	if nextMonth.Daily != 5 || nextMonth.Monthly != 5 || nextMonth.Month != "2026-11" {
+		t.Errorf("Expected usage to start over in November, got %+v", nextMonth)
+	}
+	if q.usage.Daily != 5 || q.usage.Monthly != 10 || q.usage.Day != "2026-11-02" {
+		t.Errorf("Expected only the daily usage to start over the next day, got %+v", q.usage)
+	}
+}
+
+// TestUpstreamQuotaState tests keeping usage across restarts
+func TestUpstreamQuotaState(t *testing.T) {
+	// Setup
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	config = Config{
+		DefaultURL:     "http://provider",
+		DefaultName:    "provider",
+		UpstreamQuotas: &UpstreamQuotasConfig{Upstreams: map[string]*UpstreamQuota{"provider": {Monthly: 1000}}, StateFile: path},
+	}
+	defer func() {
+		config = Config{}
+		quotas = nil
+	}()
+	if err := setupUpstreamQuotas(); err != nil {
+		t.Fatalf("Failed to set up quotas: %v", err)
+	}
+	chargeUpstreamQuota("http://provider", []byte(`{"method":"eth_call"}`))
+
+	// Test
+	err := saveQuotaState(path)
+	restartErr := setupUpstreamQuotas()
+
+	// Verify
+	if err != nil || restartErr != nil {
+		t.Fatalf("Failed to save or restore usage: %v, %v", err, restartErr)
+	}
+	if got := quotas["http://provider"].usage.Monthly; got != 1 {
+		t.Errorf("Expected the restored usage to be 1, got %v", got)
+	}
+}
+
+// TestPoolShiftsFromExhaustedQuota tests that round robin passes over members past shift_at
+func TestPoolShiftsFromExhaustedQuota(t *testing.T) {
+	// Setup
+	members := []UpstreamConfig{{URL: "http://a", Name: "a"}, {URL: "http://b", Name: "b"}}
+	config = Config{
+		Pools:          map[string]*PoolConfig{"main": {Upstreams: members}},
+		UpstreamQuotas: &UpstreamQuotasConfig{Upstreams: map[string]*UpstreamQuota{"a": {Daily: 10}, "b": {Daily: 10}}},
+	}
+	if err := setupUpstreamQuotas(); err != nil {
+		t.Fatalf("Failed to set up quotas: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		quotas = nil
+	}()
+	var next atomic.Uint64
+	quotas["http://a"].charge(9, time.Now())
+
+	// Test
+	shifted := roundRobinMember(members, &next).Name
+	shiftedAgain := roundRobinMember(members, &next).Name
+	quotas["http://b"].charge(9, time.Now())
+	exhausted := roundRobinMember(members, &next).Name
+
+	// Verify
+	if shifted != "b" || shiftedAgain != "b" {
+		t.Errorf("Expected calls to shift to b, got %s and %s", shifted, shiftedAgain)
+	}
+	if exhausted == "" {
+		t.Errorf("Expected a member to be picked once every quota is nearly used")
+	}
+}
+
+// TestUpstreamQuotaChargesRetries tests charging every attempt sent to an upstream
+func TestUpstreamQuotaChargesRetries(t *testing.T) {
+	// Setup
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL:     server.URL,
+		DefaultName:    "provider",
+		Retries:        &RetryConfig{Max: 2, Backoff: time.Millisecond, Budget: RetryBudgetConfig{MinRetries: 10}},
+		UpstreamQuotas: &UpstreamQuotasConfig{Upstreams: map[string]*UpstreamQuota{"provider": {Daily: 100}}},
+	}
+	buildMethodURLMap()
+	if err := setupRetries(); err != nil {
+		t.Fatalf("Failed to set up retries: %v", err)
+	}
+	if err := setupUpstreamQuotas(); err != nil {
+		t.Fatalf("Failed to set up quotas: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		upstreamRetries = nil
+		quotas = nil
+	}()
+
+	// Test
+	err := callProxy(t, "eth_blockNumber", nil, nil)
+
+	// Verify
+	if err != nil || hits.Load() != 3 {
+		t.Fatalf("Expected the call to succeed on its third attempt, got %v after %d", err, hits.Load())
+	}
+	q := quotas[server.URL]
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.usage.Daily != 3 {
+		t.Errorf("Expected each attempt to be charged, got %g units", q.usage.Daily)
+	}
+}
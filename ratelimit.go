@@ -0,0 +1,242 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compute-unit rate limiting
+//
+// Methods differ wildly in what they cost an upstream: one debug_traceBlock can take as
+// long as hundreds of eth_blockNumber calls. Rate limits are therefore expressed in
+// compute units per second, like provider quotas, rather than in requests. Each method
+// has a cost (default_cost unless listed in costs, by name or prefix ending in *), a
+// request costs the sum of its calls, and each client spends from a token bucket that
+// refills at units_per_second up to burst units:
+//
+//	rate_limits:
+//	  units_per_second: 500
+//	  burst: 2000
+//	  default_cost: 10
+//	  costs:
+//	    eth_blockNumber: 1
+//	    eth_getLogs: 75
+//	    debug_trace*: 500
+//
+// Clients are told apart by the identity auth validated, or by IP address (see
+// clients.go), never by API keys nothing validated. The buckets of at most 10000
+// clients are kept, the least recently used being forgotten. A request that
+// its client cannot afford is rejected with HTTP 429, a Retry-After header giving the
+// time until it can be afforded, and a JSON-RPC -32005 error for each call; it costs
+// nothing. A request costing more than burst can never be afforded and is rejected
+// with the same error.
+
+// RateLimitConfig configures compute-unit rate limits.
+type RateLimitConfig struct {
+	UnitsPerSecond float64            `yaml:"units_per_second"` // Compute units each client may spend per second
+	Burst          float64            `yaml:"burst"`            // Compute units a client may spend at once (default: units_per_second)
+	DefaultCost    float64            `yaml:"default_cost"`     // Cost of methods not listed in costs (default: 1)
+	Costs          map[string]float64 `yaml:"costs"`            // Cost by method, or prefix ending in *
+}
+
+// maxRateLimitBuckets is the number of client buckets kept; the least recently used
+// are dropped beyond it.
+const maxRateLimitBuckets = 10000
+
+// computeUnitsTotal counts the compute units charged to clients.
+var computeUnitsTotal = newCounterVec("jsonrpc_proxy_compute_units_total", "Compute units requested by clients, by result.", "result")
+
+// unitBucket is a client's token bucket.
+type unitBucket struct {
+	units   float64   // Units available at updated
+	updated time.Time // When units was last computed
+}
+
+// clientBucket is a client's bucket in the rate limiter's LRU list.
+type clientBucket struct {
+	client string
+	bucket unitBucket
+}
+
+// rateLimiter charges requests to their clients' buckets.
+type rateLimiter struct {
+	rate        float64
+	burst       float64
+	defaultCost float64
+	costs       map[string]float64 // Exact method costs
+	prefixes    []methodCost       // Prefix costs, longest prefix first
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element // Elements of lru, by client key
+	lru     *list.List               // Client buckets, most recently used first
+}
+
+// methodCost is the cost of the methods starting with a prefix.
+type methodCost struct {
+	prefix string
+	cost   float64
+}
+
+// rateLimits is the rate limiter, or nil if rate limits are off.
+var rateLimits *rateLimiter
+
+// setupRateLimits builds the rate limiter from the configuration.
+//
+// Returns:
+//   - error: An error if a rate, burst, or cost is invalid
+func setupRateLimits() error {
+	rateLimits = nil
+	rc := config.RateLimits
+	if rc == nil {
+		return nil
+	}
+	if rc.UnitsPerSecond <= 0 {
+		return fmt.Errorf("units_per_second must be positive")
+	}
+	if rc.Burst < 0 || rc.DefaultCost < 0 {
+		return fmt.Errorf("burst and default_cost cannot be negative")
+	}
+	rl := &rateLimiter{
+		rate:        rc.UnitsPerSecond,
+		burst:       rc.Burst,
+		defaultCost: rc.DefaultCost,
+		costs:       make(map[string]float64),
+		buckets:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+	if rl.burst == 0 {
+		rl.burst = rl.rate
+	}
+	if rl.defaultCost == 0 {
+		rl.defaultCost = 1
+	}
+	for _, method := range sortedKeys(rc.Costs) {
+		cost := rc.Costs[method]
+		if cost < 0 {
+			return fmt.Errorf("costs.%s cannot be negative", method)
+		}
+		if prefix, ok := strings.CutSuffix(method, "*"); ok {
+			rl.prefixes = append(rl.prefixes, methodCost{prefix: prefix, cost: cost})
+		} else {
+			rl.costs[method] = cost
+		}
+	}
+	sort.SliceStable(rl.prefixes, func(i, j int) bool { return len(rl.prefixes[i].prefix) > len(rl.prefixes[j].prefix) })
+	rateLimits = rl
+	return nil
+}
+
+// cost returns the compute units of a method.
+func (rl *rateLimiter) cost(method string) float64 {
+	if cost, ok := rl.costs[method]; ok {
+		return cost
+	}
+	for _, p := range rl.prefixes {
+		if strings.HasPrefix(method, p.prefix) {
+			return p.cost
+		}
+	}
+	return rl.defaultCost
+}
+
+// charge spends a request's units from a client's bucket.
+//
+// Parameters:
+//   - client: The client's key
+//   - units: The cost of the request
+//   - now: The current time
+//
+// Returns:
+//   - time.Duration: Zero if the request was charged, or how long until the client can afford it
+//   - bool: Whether the request was charged
+func (rl *rateLimiter) charge(client string, units float64, now time.Time) (time.Duration, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b := rl.bucket(client, now)
+	b.units = math.Min(rl.burst, b.units+now.Sub(b.updated).Seconds()*rl.rate)
+	b.updated = now
+	if units > rl.burst {
+		return time.Duration(math.MaxInt64), false
+	}
+	if b.units < units {
+		wait := time.Duration((units - b.units) / rl.rate * float64(time.Second))
+		return wait, false
+	}
+	b.units -= units
+	return 0, true
+}
+
+// bucket returns a client's bucket, creating a full one for a new client and dropping
+// the least recently used beyond maxRateLimitBuckets. Callers hold rl.mu.
+func (rl *rateLimiter) bucket(client string, now time.Time) *unitBucket {
+	if e, ok := rl.buckets[client]; ok {
+		rl.lru.MoveToFront(e)
+		return &e.Value.(*clientBucket).bucket
+	}
+	for rl.lru.Len() >= maxRateLimitBuckets {
+		oldest := rl.lru.Back()
+		rl.lru.Remove(oldest)
+		delete(rl.buckets, oldest.Value.(*clientBucket).client)
+	}
+	cb := &clientBucket{client: client, bucket: unitBucket{units: rl.burst, updated: now}}
+	rl.buckets[client] = rl.lru.PushFront(cb)
+	return &cb.bucket
+}
+
+// checkRateLimit charges a request to its client, answering it with HTTP 429 if the
+// client cannot afford it.
+//
+// Parameters:
+//   - w: The HTTP response writer
+//   - r: The incoming HTTP request
+//   - body: The request body
+//
+// Returns:
+//   - bool: Whether the request may proceed
+func checkRateLimit(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	rl := rateLimits
+	if rl == nil {
+		return true
+	}
+	calls := parseCalls(body)
+	var units float64
+	for _, call := range calls {
+		units += rl.cost(call.Method)
+	}
+	client := clientFromRequest(r)
+	wait, ok := rl.charge(client.limitKey(), units, time.Now())
+	if ok {
+		computeUnitsTotal.add(units, "allowed")
+		return true
+	}
+	computeUnitsTotal.add(units, "limited")
+
+	message := fmt.Sprintf("rate limit exceeded: request costs %g compute units", units)
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if units > rl.burst {
+		message = fmt.Sprintf("request costs %g compute units, more than the limit of %g", units, rl.burst)
+		retryAfter = 0
+	}
+	logWarn("router", "Rate limiting client %s: %s", client.IP, message)
+	writeRateLimited(w, body, calls, message, retryAfter)
+	return false
+}
+
+// writeRateLimited rejects a request with 429, Retry-After, and a JSON-RPC error for
+// each call.
+func writeRateLimited(w http.ResponseWriter, body []byte, calls []JSONRPCRequest, message string, retryAfter int) {
+	data := callErrorsBody(body, calls, &JSONRPCError{Code: -32005, Message: message})
+	w.Header().Set("Content-Type", "application/json")
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(data)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimitCharge tests spending and refilling compute units
+func TestRateLimitCharge(t *testing.T) {
+	// Setup
+	config = Config{RateLimits: &RateLimitConfig{UnitsPerSecond: 100, Burst: 500, Costs: map[string]float64{"debug_*": 50, "debug_traceBlock*": 400}}}
+	if err := setupRateLimits(); err != nil {
+		t.Fatalf("Failed to set up rate limits: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		rateLimits = nil
+	}()
+	rl := rateLimits
+	now := time.Now()
+
+	// Test and verify costs
+	for method, expected := range map[string]float64{"eth_blockNumber": 1, "debug_traceCall": 50, "debug_traceBlockByNumber": 400} {
+		if cost := rl.cost(method); cost != expected {
+			t.Errorf("Expected %s to cost %g, got %g", method, expected, cost)
+		}
+	}
+
+	// Test and verify the bucket
+	if _, ok := rl.charge("key:a", 400, now); !ok {
+		t.Errorf("Expected a full bucket to afford 400 units")
+	}
+	if wait, ok := rl.charge("key:a", 400, now); ok || wait != 3*time.Second {
+		t.Errorf("Expected to wait 3s for 400 more units, got %v %v", wait, ok)
+	}
+	if _, ok := rl.charge("key:b", 400, now); !ok {
+		t.Errorf("Expected other clients to have their own bucket")
+	}
+	if _, ok := rl.charge("key:a", 400, now.Add(3*time.Second)); !ok {
+		t.Errorf("Expected the bucket to refill")
+	}
+	if _, ok := rl.charge("key:c", 501, now); ok {
+		t.Errorf("Expected a request costing more than burst to be rejected")
+	}
+}
+
+// TestRateLimitProxy tests rejecting requests whose client ran out of compute units
+func TestRateLimitProxy(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, RateLimits: &RateLimitConfig{UnitsPerSecond: 1, Burst: 300, Costs: map[string]float64{"debug_traceBlockByNumber": 250}}}
+	buildMethodURLMap()
+	if err := setupRateLimits(); err != nil {
+		t.Fatalf("Failed to set up rate limits: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		rateLimits = nil
+	}()
+	request := func(ip, apiKey, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.RemoteAddr = ip + ":4000"
+		r.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		handleProxy(w, r)
+		return w
+	}
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"debug_traceBlockByNumber","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}]`
+
+	// Test
+	first := request("192.0.2.1", "alice", batch)
+	second := request("192.0.2.1", "alice", batch)
+	rotated := request("192.0.2.1", "mallory", batch)
+	other := request("192.0.2.2", "bob", batch)
+
+	// Verify
+	if first.Code != http.StatusOK || other.Code != http.StatusOK {
+		t.Errorf("Expected affordable requests to be served, got %d and %d", first.Code, other.Code)
+	}
+	if rotated.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a new unvalidated API key to share its IP's bucket, got %d", rotated.Code)
+	}
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") != "202" {
+		t.Errorf("Expected 429 with Retry-After 202, got %d %q", second.Code, second.Header().Get("Retry-After"))
+	}
+	if strings.Count(second.Body.String(), `"code":-32005`) != 2 || !strings.Contains(second.Body.String(), "costs 251 compute units") {
+		t.Errorf("Expected an error for each call, got %s", second.Body.String())
+	}
+}
+
+// TestRateLimitClients tests telling clients apart by validated identity, and bounding the buckets kept
+func TestRateLimitClients(t *testing.T) {
+	// Setup
+	config = Config{RateLimits: &RateLimitConfig{UnitsPerSecond: 1, Burst: 10}}
+	if err := setupRateLimits(); err != nil {
+		t.Fatalf("Failed to set up rate limits: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		rateLimits = nil
+	}()
+	rl := rateLimits
+	now := time.Now()
+	client := func(identity string) string {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-API-Key", "sent-key")
+		if identity != "" {
+			r = withAuthenticatedClient(r, identity)
+		}
+		return clientFromRequest(r).limitKey()
+	}
+
+	// Test
+	rl.charge("key:first", 10, now)
+	for i := 0; i < maxRateLimitBuckets+100; i++ {
+		rl.charge(fmt.Sprintf("key:%d", i), 1, now)
+	}
+	_, firstOK := rl.charge("key:first", 10, now)
+
+	// Verify
+	if key := client(""); key != "ip:192.0.2.1" {
+		t.Errorf("Expected an unvalidated API key to be ignored, got %s", key)
+	}
+	if key := client("tenant"); key != "auth:tenant" {
+		t.Errorf("Expected the validated identity to be used, got %s", key)
+	}
+	if len(rl.buckets) != maxRateLimitBuckets || rl.lru.Len() != maxRateLimitBuckets {
+		t.Errorf("Expected %d buckets to be kept, got %d", maxRateLimitBuckets, len(rl.buckets))
+	}
+	if !firstOK {
+		t.Errorf("Expected the least recently used bucket to be dropped")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Receipt wait helper
+//
+// proxy_waitForTransactionReceipt(hash, confirmations, timeout) polls the upstream that
+// serves eth_getTransactionReceipt until the transaction is mined and has the requested
+// number of confirmations, then returns the receipt. Clients make one long call instead
+// of each running their own polling loop against the proxy. The receipt is re-read on
+// every poll, so a transaction that is reorged out is waited for again.
+
+// ReceiptWaitConfig configures proxy_waitForTransactionReceipt.
+type ReceiptWaitConfig struct {
+	Enabled        bool          `yaml:"enabled"`         // Answer proxy_waitForTransactionReceipt
+	PollInterval   time.Duration `yaml:"poll_interval"`   // Delay between receipt polls (default: 1s)
+	DefaultTimeout time.Duration `yaml:"default_timeout"` // Timeout when the call does not give one (default: 60s)
+	MaxTimeout     time.Duration `yaml:"max_timeout"`     // Upper bound for client timeouts (default: 5m)
+}
+
+const receiptWaitMethod = "proxy_waitForTransactionReceipt"
+
+// setupReceiptWait registers or removes proxy_waitForTransactionReceipt according to the configuration.
+func setupReceiptWait() {
+	if config.ReceiptWait != nil && config.ReceiptWait.Enabled {
+		registerLocalMethod(receiptWaitMethod, handleWaitForReceipt)
+	} else {
+		unregisterLocalMethod(receiptWaitMethod)
+	}
+}
+
+// receiptWaitParams holds the parsed parameters of proxy_waitForTransactionReceipt.
+type receiptWaitParams struct {
+	hash          string
+	confirmations uint64
+	timeout       time.Duration
+}
+
+// parseReceiptWaitParams parses [hash, confirmations?, timeout?]. Confirmations may be a
+// number or hex quantity; the timeout may be a number of seconds or a duration string.
+func parseReceiptWaitParams(params interface{}) (receiptWaitParams, error) {
+	rc := config.ReceiptWait
+	out := receiptWaitParams{confirmations: 1, timeout: rc.DefaultTimeout}
+	if out.timeout <= 0 {
+		out.timeout = 60 * time.Second
+	}
+	maxTimeout := rc.MaxTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = 5 * time.Minute
+	}
+
+	list, ok := params.([]interface{})
+	if !ok || len(list) == 0 {
+		return out, fmt.Errorf("expected params [hash, confirmations, timeout]")
+	}
+	hash, ok := list[0].(string)
+	if !ok || len(hash) != 66 || !strings.HasPrefix(hash, "0x") {
+		return out, fmt.Errorf("invalid transaction hash")
+	}
+	out.hash = hash
+
+	if len(list) > 1 && list[1] != nil {
+		switch v := list[1].(type) {
+		case float64:
+			if v < 0 {
+				return out, fmt.Errorf("invalid confirmations %v", v)
+			}
+			out.confirmations = uint64(v)
+		case string:
+			n, err := parseQuantity(v)
+			if err != nil {
+				return out, fmt.Errorf("invalid confirmations: %w", err)
+			}
+			out.confirmations = n
+		default:
+			return out, fmt.Errorf("invalid confirmations %v", v)
+		}
+		if out.confirmations == 0 {
+			out.confirmations = 1
+		}
+	}
+
+	if len(list) > 2 && list[2] != nil {
+		switch v := list[2].(type) {
+		case float64:
+			out.timeout = time.Duration(v * float64(time.Second))
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return out, fmt.Errorf("invalid timeout: %w", err)
+			}
+			out.timeout = d
+		default:
+			return out, fmt.Errorf("invalid timeout %v", v)
+		}
+		if out.timeout <= 0 {
+			return out, fmt.Errorf("timeout must be positive")
+		}
+	}
+	if out.timeout > maxTimeout {
+		out.timeout = maxTimeout
+	}
+	return out, nil
+}
+
+// handleWaitForReceipt waits for a transaction receipt with the requested confirmations.
+func handleWaitForReceipt(ctx context.Context, r *http.Request, req *JSONRPCRequest) (interface{}, *JSONRPCError) {
+	p, err := parseReceiptWaitParams(req.Params)
+	if err != nil {
+		return nil, &JSONRPCError{Code: -32602, Message: err.Error()}
+	}
+	interval := config.ReceiptWait.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	receiptParams := []interface{}{p.hash}
+	url, err := routeFor("eth_getTransactionReceipt", receiptParams)
+	if err != nil {
+		return nil, &JSONRPCError{Code: -32603, Message: fmt.Sprintf("routing error: %v", err)}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		receipt, done, rpcErr := checkReceipt(ctx, url, receiptParams, p.confirmations)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		if done {
+			return receipt, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, &JSONRPCError{
+				Code:    -32002,
+				Message: fmt.Sprintf("transaction %s did not reach %d confirmations within %s", p.hash, p.confirmations, p.timeout),
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReceipt polls the receipt once and reports whether it has enough confirmations.
+// Transient upstream failures are treated as "not yet" so the wait continues.
+func checkReceipt(ctx context.Context, url string, params []interface{}, confirmations uint64) (json.RawMessage, bool, *JSONRPCError) {
+	raw, err := callUpstream(ctx, url, "eth_getTransactionReceipt", params)
+	if err != nil {
+		if rpcErr, ok := err.(*JSONRPCError); ok && (rpcErr.Code == -32601 || rpcErr.Code == -32602) {
+			// The upstream rejected the call itself; polling again will not help
+			return nil, false, rpcErr
+		}
+		return nil, false, nil
+	}
+
+	var receipt struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if string(raw) == "null" || json.Unmarshal(raw, &receipt) != nil || receipt.BlockNumber == "" {
+		return nil, false, nil
+	}
+	if confirmations <= 1 {
+		return raw, true, nil
+	}
+
+	mined, err := parseQuantity(receipt.BlockNumber)
+	if err != nil {
+		return nil, false, nil
+	}
+	head, err := currentHead(ctx, url)
+	if err != nil || head < mined {
+		return nil, false, nil
+	}
+	return raw, head-mined+1 >= confirmations, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWaitForTransactionReceipt tests waiting for a receipt with confirmations
+func TestWaitForTransactionReceipt(t *testing.T) {
+	// Setup
+	hash := "0x" + strings.Repeat("ab", 32)
+	var polls, head atomic.Uint64
+	head.Store(99)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req JSONRPCRequest
+		json.Unmarshal(body, &req)
+
+		var result interface{}
+		switch req.Method {
+		case "eth_getTransactionReceipt":
+			// Mined in block 100 on the third poll; one block per poll after that
+			if polls.Add(1) >= 3 {
+				result = map[string]interface{}{"transactionHash": hash, "blockNumber": "0x64", "status": "0x1"}
+				head.Add(1)
+			}
+		case "eth_blockNumber":
+			result = toQuantity(head.Load())
+		}
+		resp, _ := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID})
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	config = Config{
+		DefaultURL:  server.URL,
+		ReceiptWait: &ReceiptWaitConfig{Enabled: true, PollInterval: 10 * time.Millisecond},
+	}
+	buildMethodURLMap()
+	setupReceiptWait()
+	defer func() {
+		config.ReceiptWait = nil
+		setupReceiptWait()
+	}()
+
+	// Test
+	var receipt map[string]interface{}
+	if err := callProxy(t, receiptWaitMethod, []interface{}{hash, 3, "5s"}, &receipt); err != nil {
+		t.Fatalf("%s failed: %v", receiptWaitMethod, err)
+	}
+
+	// Verify
+	if receipt["transactionHash"] != hash {
+		t.Errorf("Expected receipt for %s, got %v", hash, receipt)
+	}
+	if head.Load() < 102 {
+		t.Errorf("Expected 3 confirmations (head >= 102), returned at head %d", head.Load())
+	}
+}
+
+// TestWaitForTransactionReceiptTimeout tests the timeout error
+func TestWaitForTransactionReceiptTimeout(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "eth_getTransactionReceipt", `{"jsonrpc":"2.0","id":1,"result":null}`)
+	defer server.Close()
+	config = Config{
+		DefaultURL:  server.URL,
+		ReceiptWait: &ReceiptWaitConfig{Enabled: true, PollInterval: 10 * time.Millisecond},
+	}
+	buildMethodURLMap()
+	setupReceiptWait()
+	defer func() {
+		config.ReceiptWait = nil
+		setupReceiptWait()
+	}()
+
+	// Test
+	err := callProxy(t, receiptWaitMethod, []interface{}{"0x" + strings.Repeat("cd", 32), nil, 0.05}, nil)
+
+	// Verify
+	if err == nil || err.Code != -32002 {
+		t.Errorf("Expected -32002 timeout error, got %v", err)
+	}
+
+	// Invalid hashes are rejected
+	if err := callProxy(t, receiptWaitMethod, []interface{}{"0x1234"}, nil); err == nil || err.Code != -32602 {
+		t.Errorf("Expected -32602 for an invalid hash, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Traffic recording and replay
+//
+// The proxy can record a sample of the calls it serves to a JSON Lines file, one call
+// per line with its method, params, and the response the client received. Recordings
+// are sanitized: no client addresses, headers, or API keys are stored, and the params
+// of transaction submission and signing methods (or any listed method) are dropped.
+//
+// Running the proxy with -replay <file> re-runs a recording against the loaded
+// configuration instead of serving traffic, so a config change or a new provider can be
+// validated offline. Each call goes through the full request pipeline; the report lists
+// the upstream each call is routed to and whether its response differs from the
+// recorded one. Calls whose params were redacted, and write methods, are never replayed.
+
+// RecordingConfig configures traffic recording.
+type RecordingConfig struct {
+	File          string   `yaml:"file"`           // JSON Lines file the calls are appended to
+	SampleRate    float64  `yaml:"sample_rate"`    // Fraction of requests to record, 0 to 1 (default: 1)
+	RedactMethods []string `yaml:"redact_methods"` // Methods whose params are not recorded (in addition to the built-in ones)
+}
+
+// builtinRedactedMethods are methods whose params are never recorded.
+var builtinRedactedMethods = []string{"eth_sendRawTransaction", "eth_sendTransaction", "eth_sign", "eth_signTransaction", "eth_signTypedData_v4", "personal_*"}
+
+// maxRecordedResponse bounds the size of a recorded response.
+const maxRecordedResponse = 1 << 20
+
+// recordedCall is one line of a recording.
+type recordedCall struct {
+	Time     time.Time       `json:"time"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Redacted bool            `json:"redacted,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"` // Response object received by the client
+}
+
+var recordings chan recordedCall // Calls waiting to be written (nil if recording is off)
+
+// setupRecording opens the recording file and starts its writer.
+//
+// Returns:
+//   - error: An error if the file cannot be opened
+func setupRecording() error {
+	recordings = nil
+	rc := config.Recording
+	if rc == nil || rc.File == "" {
+		return nil
+	}
+	f, err := os.OpenFile(rc.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("recording: %w", err)
+	}
+
+	recordings = make(chan recordedCall, 1024)
+	go writeRecordings(f, recordings)
+	log.Printf("Recording traffic to %s", rc.File)
+	return nil
+}
+
+// writeRecordings appends recorded calls to the file until the channel is closed.
+func writeRecordings(f *os.File, calls <-chan recordedCall) {
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for call := range calls {
+		if err := encoder.Encode(call); err != nil {
+			log.Printf("Error writing recording: %v", err)
+		}
+		// Flush when idle so the file is usable while the proxy runs
+		if len(calls) == 0 {
+			w.Flush()
+		}
+	}
+	w.Flush()
+}
+
+// withRecording wraps the proxy handler with traffic recording.
+//
+// Parameters:
+//   - next: The proxy handler
+//
+// Returns:
+//   - http.HandlerFunc: The handler recording a sample of its calls
+func withRecording(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := config.Recording
+		if recordings == nil || r.Method != http.MethodPost ||
+			(rc.SampleRate > 0 && rc.SampleRate < 1 && rand.Float64() >= rc.SampleRate) {
+			next(w, r)
+			return
+		}
+		body, ok := peekBody(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		tee := &teeResponse{ResponseWriter: w}
+		next(tee, r)
+		recordCalls(body, tee.body.Bytes(), tee.overflow)
+	}
+}
+
+// recordCalls queues the calls of a request with their responses. Calls are dropped
+// if the writer falls behind.
+func recordCalls(body, respBody []byte, overflow bool) {
+	responses := make(map[string]json.RawMessage)
+	if !overflow {
+		var list []json.RawMessage
+		if isBatch(respBody) {
+			json.Unmarshal(respBody, &list)
+		} else if len(bytes.TrimSpace(respBody)) > 0 {
+			list = []json.RawMessage{respBody}
+		}
+		for _, resp := range list {
+			responses[fmt.Sprint(responseID(resp))] = resp
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, call := range parseCalls(body) {
+		rec := recordedCall{Time: now, Method: call.Method, Response: responses[fmt.Sprint(call.ID)]}
+		if redactedMethod(call.Method) {
+			rec.Redacted = true
+		} else if call.Params != nil {
+			rec.Params, _ = json.Marshal(call.Params)
+		}
+		select {
+		case recordings <- rec:
+		default:
+			log.Printf("Recording is falling behind, dropping method '%s'", call.Method)
+		}
+	}
+}
+
+// redactedMethod reports whether a method's params must not be recorded.
+func redactedMethod(method string) bool {
+	var extra []string
+	if config.Recording != nil {
+		extra = config.Recording.RedactMethods
+	}
+	for _, pattern := range append(extra, builtinRedactedMethods...) {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		} else if method == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// teeResponse copies what a handler writes to the client, up to maxRecordedResponse.
+type teeResponse struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+// Write implements http.ResponseWriter.
+func (t *teeResponse) Write(p []byte) (int, error) {
+	if !t.overflow {
+		if t.body.Len()+len(p) > maxRecordedResponse {
+			t.overflow = true
+			t.body.Reset()
+		} else {
+			t.body.Write(p)
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, so that streamed responses are still sent as they are
+// written.
+func (t *teeResponse) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// replayResult summarizes a replay.
+type replayResult struct {
+	Replayed int // Calls replayed
+	Same     int // Calls whose outcome matched the recording
+	Differs  int // Calls whose outcome differed
+	Skipped  int // Redacted, write, or unparsable calls
+}
+
+// runReplay re-runs a recording against the loaded configuration and writes a report.
+//
+// Parameters:
+//   - path: The recording file
+//   - out: Where the report is written
+//
+// Returns:
+//   - replayResult: The summary of the replay
+//   - error: An error if the recording cannot be read
+func runReplay(path string, out io.Writer) (replayResult, error) {
+	var result replayResult
+	f, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxRecordedResponse)
+	for line := 1; scanner.Scan(); line++ {
+		var rec recordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			fmt.Fprintf(out, "%d: skipped, invalid record: %v\n", line, err)
+			result.Skipped++
+			continue
+		}
+		if rec.Redacted || isBuiltinWrite(rec.Method) {
+			fmt.Fprintf(out, "%d: %s skipped, not replayable\n", line, rec.Method)
+			result.Skipped++
+			continue
+		}
+
+		params := rec.Params
+		if len(params) == 0 {
+			params = json.RawMessage("[]")
+		}
+		body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": line, "method": rec.Method, "params": params})
+
+		// Run the call through the full pipeline, tracing where it is routed
+		resp := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		trace := &routeTrace{}
+		req, _ := http.NewRequestWithContext(context.WithValue(context.Background(), routeTraceKey{}, trace), http.MethodPost, "/", bytes.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:0"
+		handleProxy(resp, req)
+		target := replayTarget(trace)
+
+		result.Replayed++
+		switch {
+		case len(rec.Response) == 0:
+			fmt.Fprintf(out, "%d: %s -> %s: no recorded response (HTTP %d)\n", line, rec.Method, target, resp.status)
+		case sameOutcome(rec.Response, resp.body):
+			result.Same++
+			fmt.Fprintf(out, "%d: %s -> %s: same\n", line, rec.Method, target)
+		default:
+			result.Differs++
+			fmt.Fprintf(out, "%d: %s -> %s: differs: recorded %s, replayed %s\n", line, rec.Method, target,
+				truncate(string(bytes.TrimSpace(rec.Response)), 300), truncate(string(bytes.TrimSpace(resp.body)), 300))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	fmt.Fprintf(out, "Replayed %d calls: %d same, %d differ, %d skipped\n", result.Replayed, result.Same, result.Differs, result.Skipped)
+	return result, nil
+}
+
+// isBuiltinWrite reports whether a method submits a transaction.
+func isBuiltinWrite(method string) bool {
+	for _, m := range builtinWriteMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// replayTarget describes where a replayed call was sent, from the routing decision
+// traced while serving it (see debugroute.go).
+func replayTarget(trace *routeTrace) string {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	if len(trace.calls) == 0 {
+		return "unroutable"
+	}
+	d := trace.calls[0]
+	if d.rule == "local" {
+		return "local"
+	}
+	return d.upstream
+}
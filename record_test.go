@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRecordAndReplay tests recording sanitized traffic and replaying it
+func TestRecordAndReplay(t *testing.T) {
+	// Setup: an upstream whose block number can change between recording and replay
+	var block atomic.Value
+	block.Store("0x10")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: block.Load()})
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	config = Config{DefaultURL: server.URL, DefaultName: "primary", Recording: &RecordingConfig{File: path}}
+	buildMethodURLMap()
+	if err := setupRecording(); err != nil {
+		t.Fatalf("Failed to set up recording: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		recordings = nil
+	}()
+
+	// Test: record a read and a transaction submission
+	handler := withRecording(handleProxy)
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`,
+		`{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction","params":["0xdeadbeef"]}`,
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("X-API-Key", "secret-key")
+		handler(httptest.NewRecorder(), r)
+	}
+	close(recordings)
+	recordings = nil
+
+	// Verify the recording is sanitized
+	var data []byte
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, _ = os.ReadFile(path)
+		if bytes.Count(data, []byte("\n")) == 2 {
+			break
+		}
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Fatalf("Expected 2 recorded calls, got %d: %s", lines, data)
+	}
+	if bytes.Contains(data, []byte("deadbeef")) || bytes.Contains(data, []byte("secret-key")) {
+		t.Errorf("Expected sensitive data to be left out, got %s", data)
+	}
+	if !bytes.Contains(data, []byte(`"result":"0x10"`)) {
+		t.Errorf("Expected the response to be recorded, got %s", data)
+	}
+
+	// Test: replay against an unchanged upstream
+	var out bytes.Buffer
+	result, err := runReplay(path, &out)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	// Verify
+	if result.Same != 1 || result.Skipped != 1 || result.Differs != 0 {
+		t.Errorf("Expected 1 same and 1 skipped call, got %+v:\n%s", result, out.String())
+	}
+	if !strings.Contains(out.String(), "eth_blockNumber -> primary: same") {
+		t.Errorf("Expected the routing decision in the report, got:\n%s", out.String())
+	}
+
+	// Test: replay after the upstream's answer changed
+	block.Store("0x11")
+	out.Reset()
+	result, err = runReplay(path, &out)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	// Verify
+	if result.Differs != 1 {
+		t.Errorf("Expected 1 differing call, got %+v:\n%s", result, out.String())
+	}
+}
+
+// TestReplayReportsServingUpstream tests that the report names the pool member that served each call
+func TestReplayReportsServingUpstream(t *testing.T) {
+	// Setup: two pool members answering with their own name
+	member := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req JSONRPCRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: name})
+		}))
+	}
+	a, b := member("a"), member("b")
+	defer a.Close()
+	defer b.Close()
+	config = Config{
+		DefaultPool: "main",
+		Pools:       map[string]*PoolConfig{"main": {Upstreams: []UpstreamConfig{{URL: a.URL, Name: "a"}, {URL: b.URL, Name: "b"}}}},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	record := `{"method":"eth_chainId","params":[],"response":{"jsonrpc":"2.0","id":1,"result":"a"}}` + "\n"
+	if err := os.WriteFile(path, []byte(strings.Repeat(record, 4)), 0o644); err != nil {
+		t.Fatalf("Failed to write the recording: %v", err)
+	}
+
+	// Test
+	var out bytes.Buffer
+	if _, err := runReplay(path, &out); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	// Verify: calls served by a match the recording, and calls served by b do not
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[:4] {
+		if strings.Contains(line, "-> a: same") || (strings.Contains(line, "-> b: differs") && strings.Contains(line, `"result":"b"`)) {
+			continue
+		}
+		t.Errorf("Expected each call reported with the member that served it, got %q", line)
+	}
+}
+
+// TestTeeResponseFlush tests that recording passes flushes through to the client
+func TestTeeResponseFlush(t *testing.T) {
+	// Setup
+	w := httptest.NewRecorder()
+	tee := &teeResponse{ResponseWriter: w}
+
+	// Test
+	tee.Write([]byte("partial"))
+	tee.Flush()
+
+	// Verify
+	if !w.Flushed || tee.body.String() != "partial" {
+		t.Errorf("Expected the flush to reach the client and the body to be recorded, got %v %q", w.Flushed, tee.body.String())
+	}
+}
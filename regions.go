@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// Region preference
+//
+// In a multi-region deployment, pool members (and write pool members) can be tagged
+// with the region they run in, and the proxy told its own region with `region` or the
+// PROXY_REGION environment variable. Calls then go to healthy members of the proxy's
+// region, and fail over to other regions only when no local member is healthy, as
+// reported by the probes. Members without a region count as remote. Without a proxy
+// region, every member is used alike.
+
+var (
+	crossRegionMu sync.Mutex      // Protects crossRegion
+	crossRegion   map[string]bool // Pools currently served from other regions, for logging transitions
+)
+
+// setupRegion applies the PROXY_REGION environment variable.
+func setupRegion() {
+	if region := os.Getenv("PROXY_REGION"); region != "" {
+		config.Region = region
+	}
+	crossRegionMu.Lock()
+	crossRegion = make(map[string]bool)
+	crossRegionMu.Unlock()
+	if config.Region != "" {
+		log.Printf("Preferring upstreams in region %s", config.Region)
+	}
+}
+
+// regionalMembers narrows pool members to those that should receive traffic: the
+// healthy members of the proxy's region, or if there are none, the healthy members of
+// other regions. If no member is known to be healthy, all members are returned.
+//
+// Parameters:
+//   - pool: The pool name (for logging failovers)
+//   - members: The pool members
+//
+// Returns:
+//   - []UpstreamConfig: The candidate members
+func regionalMembers(pool string, members []UpstreamConfig) []UpstreamConfig {
+	if config.Region == "" {
+		return members
+	}
+
+	var local, remote []UpstreamConfig
+	for _, member := range members {
+		if !upstreamHealthy(member.URL) {
+			continue
+		}
+		if member.Region == config.Region {
+			local = append(local, member)
+		} else {
+			remote = append(remote, member)
+		}
+	}
+
+	candidates, failover := local, false
+	if len(local) == 0 && len(remote) > 0 {
+		candidates, failover = remote, true
+	}
+	noteFailover(pool, failover)
+	if len(candidates) == 0 {
+		return members
+	}
+	return candidates
+}
+
+// noteFailover logs when a pool starts or stops being served from other regions.
+func noteFailover(pool string, failover bool) {
+	crossRegionMu.Lock()
+	defer crossRegionMu.Unlock()
+	if crossRegion == nil {
+		crossRegion = make(map[string]bool)
+	}
+	if crossRegion[pool] == failover {
+		return
+	}
+	crossRegion[pool] = failover
+	if failover {
+		logWarn("router", "No healthy upstream of pool %s in region %s, failing over to other regions", pool, config.Region)
+	} else {
+		logInfo("router", "Pool %s is served from region %s again", pool, config.Region)
+	}
+}
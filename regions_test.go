@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRegionPreference tests preferring same-region pool members and failing over
+func TestRegionPreference(t *testing.T) {
+	// Setup
+	config = Config{
+		Region:      "eu-west",
+		DefaultPool: "nodes",
+		Pools: map[string]*PoolConfig{"nodes": {Upstreams: []UpstreamConfig{
+			{URL: "http://eu-1.example.com", Name: "eu-1", Region: "eu-west"},
+			{URL: "http://eu-2.example.com", Name: "eu-2", Region: "eu-west"},
+			{URL: "http://us-1.example.com", Name: "us-1", Region: "us-east"},
+		}}},
+	}
+	defer func() {
+		config = Config{}
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	setHealth := func(healthy map[string]bool) {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		upstreamStatuses = make(map[string]*upstreamStatus)
+		for name, ok := range healthy {
+			url := "http://" + name + ".example.com"
+			upstreamStatuses[url] = &upstreamStatus{URL: url, Healthy: ok, LastProbe: time.Now()}
+		}
+	}
+	served := func() map[string]bool {
+		names := make(map[string]bool)
+		for i := 0; i < 6; i++ {
+			upstream, err := pickPoolMember("nodes", &JSONRPCRequest{Method: "eth_call"})
+			if err != nil {
+				t.Fatalf("Routing failed: %v", err)
+			}
+			names[upstream.Name] = true
+		}
+		return names
+	}
+
+	// Test: all members healthy
+	setHealth(map[string]bool{"eu-1": true, "eu-2": true, "us-1": true})
+	if names := served(); len(names) != 2 || names["us-1"] {
+		t.Errorf("Expected only same-region members, got %v", names)
+	}
+
+	// Test: one local member down
+	setHealth(map[string]bool{"eu-1": false, "eu-2": true, "us-1": true})
+	if names := served(); len(names) != 1 || !names["eu-2"] {
+		t.Errorf("Expected the healthy local member, got %v", names)
+	}
+
+	// Test: every local member down
+	setHealth(map[string]bool{"eu-1": false, "eu-2": false, "us-1": true})
+	if names := served(); len(names) != 1 || !names["us-1"] {
+		t.Errorf("Expected failover to the other region, got %v", names)
+	}
+
+	// Test: local members recover
+	setHealth(map[string]bool{"eu-1": true, "eu-2": true, "us-1": true})
+	if names := served(); names["us-1"] {
+		t.Errorf("Expected traffic back in the local region, got %v", names)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replay protection
+//
+// Signed requests (see hmacauth.go) are rejected if their nonce was already used. The
+// nonces of the replay window are kept in memory by default, which protects a single
+// proxy. Replicas behind a load balancer must share them, or a request replayed to
+// another replica would be accepted, so they can be kept in Redis instead:
+//
+//	auth:
+//	  replay:
+//	    store: redis://:password@redis:6379/0
+//
+// Each nonce is claimed with SET NX and expires after twice the window, which covers
+// the timestamps accepted on either side of the proxy's clock. The store fails closed:
+// requests are rejected while Redis cannot be reached.
+
+// ReplayConfig configures replay protection for signed requests.
+type ReplayConfig struct {
+	Window time.Duration `yaml:"window"` // Accepted distance between a request's timestamp and the proxy's clock (default: 5m)
+	Store  string        `yaml:"store"`  // "memory" or a redis:// URL (default: memory)
+}
+
+// maxMemoryNonces is the number of nonces beyond which expired ones are swept.
+const maxMemoryNonces = 100000
+
+// nonceStore remembers the nonces used within the replay window.
+type nonceStore interface {
+	// claim records a nonce, reporting false if it was already recorded and has not
+	// expired.
+	claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+	// name describes the store for logging.
+	name() string
+}
+
+// newNonceStore creates the nonce store for the store option.
+//
+// Parameters:
+//   - store: "", "memory", or a redis:// URL
+//
+// Returns:
+//   - nonceStore: The store
+//   - error: An error if the option is invalid
+func newNonceStore(store string) (nonceStore, error) {
+	if store == "" || store == "memory" {
+		return &memoryNonceStore{nonces: make(map[[sha256.Size]byte]time.Time)}, nil
+	}
+	u, err := url.Parse(store)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported store %q (expected memory or a redis:// URL)", displayURL(store))
+	}
+	rs := &redisNonceStore{addr: u.Host, timeout: 2 * time.Second}
+	if u.Port() == "" {
+		rs.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rs.password, _ = u.User.Password()
+		if rs.password == "" {
+			rs.password = u.User.Username()
+		} else {
+			rs.username = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if rs.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	registerDependency(rs.name(), "nonce_store", "signed requests are rejected")
+	return rs, nil
+}
+
+// memoryNonceStore keeps nonces in memory.
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[[sha256.Size]byte]time.Time // Expiry by nonce hash
+}
+
+func (s *memoryNonceStore) name() string {
+	return "memory"
+}
+
+func (s *memoryNonceStore) claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	key := sha256.Sum256([]byte(nonce))
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expires, ok := s.nonces[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+	if len(s.nonces) >= maxMemoryNonces {
+		for k, expires := range s.nonces {
+			if !now.Before(expires) {
+				delete(s.nonces, k)
+			}
+		}
+	}
+	s.nonces[key] = now.Add(ttl)
+	return true, nil
+}
+
+// redisNonceStore keeps nonces in Redis, so that replicas share them. It speaks just
+// enough of the Redis protocol for AUTH, SELECT, and SET, over one connection.
+type redisNonceStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *redisNonceStore) name() string {
+	return "redis " + s.addr
+}
+
+func (s *redisNonceStore) claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	sum := sha256.Sum256([]byte(nonce))
+	key := "jsonrpc-proxy:nonce:" + hex.EncodeToString(sum[:])
+	reply, err := s.do(ctx, "SET", key, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	reportDependency(s.name(), err)
+	if err != nil {
+		return false, err
+	}
+	// SET NX replies OK when the key was set, and nil when it already existed
+	return reply == "OK", nil
+}
+
+// do sends a command and reads its reply, reconnecting once if the connection broke.
+//
+// Returns:
+//   - string: The reply of a simple or bulk string, or "" for nil
+//   - error: An error if Redis cannot be reached or replied with an error
+func (s *redisNonceStore) do(ctx context.Context, args ...string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(ctx); err != nil {
+				return "", fmt.Errorf("redis %s: %w", s.addr, err)
+			}
+		}
+		reply, err := s.roundTrip(ctx, args)
+		if err == nil {
+			return reply, nil
+		}
+		if _, isReply := err.(redisError); isReply {
+			return "", fmt.Errorf("redis %s: %w", s.addr, err)
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return "", fmt.Errorf("redis %s: %w", s.addr, err)
+		}
+	}
+}
+
+// connect dials Redis and authenticates the connection. Callers hold s.mu.
+func (s *redisNonceStore) connect(ctx context.Context) error {
+	conn, err := (&net.Dialer{Timeout: s.timeout}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := s.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// roundTrip writes a command and reads its reply. Callers hold s.mu.
+func (s *redisNonceStore) roundTrip(ctx context.Context, args []string) (string, error) {
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetDeadline(deadline)
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := s.conn.Write([]byte(cmd.String())); err != nil {
+		return "", err
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid reply %q", line)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(s.reader, data); err != nil {
+			return "", err
+		}
+		return string(data[:n]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
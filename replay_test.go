@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the subset of the Redis protocol used by the nonce store.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	keys     map[string]bool
+	commands []string
+}
+
+// startFakeRedis starts a fake Redis server requiring the given password.
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeRedis{listener: listener, keys: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, password)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn, password string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := password == ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			reader.ReadString('\n')
+			arg, _ := reader.ReadString('\n')
+			args[i] = strings.TrimRight(arg, "\r\n")
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		switch {
+		case args[0] == "AUTH" && args[len(args)-1] == password:
+			authenticated = true
+			conn.Write([]byte("+OK\r\n"))
+		case !authenticated:
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+		case args[0] == "SELECT":
+			conn.Write([]byte("+OK\r\n"))
+		case args[0] == "SET" && f.keys[args[1]]:
+			conn.Write([]byte("$-1\r\n"))
+		case args[0] == "SET":
+			f.keys[args[1]] = true
+			conn.Write([]byte("+OK\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+		f.mu.Unlock()
+	}
+}
+
+// TestMemoryNonceStore tests claiming nonces in memory
+func TestMemoryNonceStore(t *testing.T) {
+	// Setup
+	store, _ := newNonceStore("")
+	ctx := context.Background()
+
+	// Test
+	first, _ := store.claim(ctx, "a", time.Minute)
+	second, _ := store.claim(ctx, "a", time.Minute)
+	expiring, _ := store.claim(ctx, "b", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	reused, _ := store.claim(ctx, "b", time.Minute)
+
+	// Verify
+	if !first || second {
+		t.Errorf("Expected a nonce to be claimed once, got %v then %v", first, second)
+	}
+	if !expiring || !reused {
+		t.Errorf("Expected an expired nonce to be claimable again")
+	}
+}
+
+// TestRedisNonceStore tests claiming nonces in Redis
+func TestRedisNonceStore(t *testing.T) {
+	// Setup
+	redis := startFakeRedis(t, "secret")
+	store, err := newNonceStore("redis://:secret@" + redis.listener.Addr().String() + "/2")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+
+	// Test
+	first, err1 := store.claim(ctx, "partner-a\x00n1", time.Minute)
+	second, err2 := store.claim(ctx, "partner-a\x00n1", time.Minute)
+	other, err3 := store.claim(ctx, "partner-b\x00n1", time.Minute)
+
+	// Verify
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Fatalf("Expected no errors, got %v, %v, %v", err1, err2, err3)
+	}
+	if !first || second || !other {
+		t.Errorf("Expected each nonce to be claimed once, got %v, %v, %v", first, second, other)
+	}
+	if got := strings.Join(redis.commands, " "); got != "AUTH SELECT SET SET SET" {
+		t.Errorf("Expected one authenticated connection, got commands %s", got)
+	}
+
+	// Verify that a wrong password and an unreachable server fail closed
+	wrong, _ := newNonceStore("redis://:wrong@" + redis.listener.Addr().String())
+	if _, err := wrong.claim(ctx, "n", time.Minute); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+	redis.listener.Close()
+	down, _ := newNonceStore("redis://" + redis.listener.Addr().String())
+	if _, err := down.claim(ctx, "n", time.Minute); err == nil {
+		t.Errorf("Expected an error for an unreachable server")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Response transforms
+//
+// Features that rewrite upstream responses (error normalization, body rewriting, and
+// so on) register a responseTransform. Transforms run in registration order on every
+// JSON-RPC response object: once for a single request, and once per element for a
+// batch. When no transform is registered, single responses are streamed to the client
+// untouched.
+
+// responseTransform rewrites one upstream JSON-RPC response object.
+//
+// Parameters:
+//   - call: The request the response answers (only Method and ID are guaranteed for batches)
+//   - status: The upstream HTTP status code
+//   - body: The response object
+//
+// Returns:
+//   - []byte: The rewritten response object (or body unchanged)
+type responseTransform func(call *JSONRPCRequest, status int, body []byte) []byte
+
+type namedTransform struct {
+	name string
+	fn   responseTransform
+}
+
+var (
+	transformsMu       sync.RWMutex
+	responseTransforms []namedTransform // Registered transforms in order
+)
+
+// registerResponseTransform adds or replaces a named response transform.
+func registerResponseTransform(name string, fn responseTransform) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	for i, t := range responseTransforms {
+		if t.name == name {
+			responseTransforms[i].fn = fn
+			return
+		}
+	}
+	responseTransforms = append(responseTransforms, namedTransform{name: name, fn: fn})
+}
+
+// unregisterResponseTransform removes a named response transform.
+func unregisterResponseTransform(name string) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	for i, t := range responseTransforms {
+		if t.name == name {
+			responseTransforms = append(responseTransforms[:i], responseTransforms[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasResponseTransforms reports whether any transform is registered.
+func hasResponseTransforms() bool {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	return len(responseTransforms) > 0
+}
+
+// applyResponseTransforms runs every registered transform over a response object.
+func applyResponseTransforms(call *JSONRPCRequest, status int, body []byte) []byte {
+	transformsMu.RLock()
+	transforms := responseTransforms
+	transformsMu.RUnlock()
+
+	for _, t := range transforms {
+		body = t.fn(call, status, body)
+	}
+	return body
+}
+
+// responseID extracts the id of a JSON-RPC response object, decoded like request ids.
+func responseID(body []byte) interface{} {
+	var resp struct {
+		ID interface{} `json:"id"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+	return resp.ID
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Response size limits
+//
+// A wide eth_getLogs or a debug trace of a busy block can return hundreds of megabytes,
+// which the proxy may have to hold in memory and the client may choke on. Upstream
+// responses can be limited by method:
+//
+//	response_limits:
+//	  max_bytes: 104857600      # any method (default: unlimited)
+//	  methods:
+//	    eth_getLogs: 52428800   # by name, or prefix ending in *
+//	    "debug_*": 209715200
+//
+// A method's own limit applies over a matching prefix, the longest prefix over shorter
+// ones, and max_bytes over none. A response over the limit is not read any further:
+// the call is answered with a JSON-RPC -32008 error naming the method and the limit,
+// and counted in jsonrpc_proxy_response_too_large_total. Sizes are counted after
+// decompression, so limited methods are never passed on compressed (see
+// decompress.go). In a batch sent to an upstream, the limit is the sum of its calls'
+// limits, and none applies if any of its calls is unlimited. Calls of a streamed batch
+// (see batchstream.go) already sent are kept; the others get the error.
+
+// ResponseLimitsConfig configures upstream response size limits.
+type ResponseLimitsConfig struct {
+	MaxBytes int64            `yaml:"max_bytes"` // Largest response of any method, in bytes (default: unlimited)
+	Methods  map[string]int64 `yaml:"methods"`   // Largest response by method name or prefix ending in *, in bytes (optional)
+}
+
+// errResponseTooLarge reports a response over its size limit.
+var errResponseTooLarge = errors.New("response too large")
+
+// responseTooLargeTotal counts responses over their size limit by upstream and limit.
+var responseTooLargeTotal = newCounterVec("jsonrpc_proxy_response_too_large_total", "Upstream responses cut off at their size limit.", "upstream", "limit")
+
+// setupResponseLimits validates the response size limits.
+//
+// Returns:
+//   - error: An error if a limit is negative
+func setupResponseLimits() error {
+	rc := config.ResponseLimits
+	if rc == nil {
+		return nil
+	}
+	if rc.MaxBytes < 0 {
+		return fmt.Errorf("max_bytes cannot be negative")
+	}
+	for _, method := range sortedKeys(rc.Methods) {
+		if rc.Methods[method] < 0 {
+			return fmt.Errorf("methods.%s cannot be negative", method)
+		}
+	}
+	log.Printf("Limiting upstream response sizes of %d method pattern(s)", len(rc.Methods))
+	return nil
+}
+
+// responseLimit returns the response size limit of a method.
+//
+// Parameters:
+//   - method: The called method
+//
+// Returns:
+//   - int64: The limit in bytes, or 0 if the method is unlimited
+//   - string: The method or pattern that set the limit, or "max_bytes"
+func responseLimit(method string) (int64, string) {
+	rc := config.ResponseLimits
+	if rc == nil {
+		return 0, ""
+	}
+	if limit, ok := rc.Methods[method]; ok {
+		return limit, method
+	}
+	best := ""
+	for pattern := range rc.Methods {
+		if strings.HasSuffix(pattern, "*") && methodPatternMatches(pattern, method) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best != "" {
+		return rc.Methods[best], best
+	}
+	return rc.MaxBytes, "max_bytes"
+}
+
+// batchResponseLimit returns the response size limit of a batch of calls: the sum of
+// the calls' limits, or 0 if any of them is unlimited.
+func batchResponseLimit(methods []string) int64 {
+	var total int64
+	for _, method := range methods {
+		limit, _ := responseLimit(method)
+		if limit == 0 {
+			return 0
+		}
+		total += limit
+	}
+	return total
+}
+
+// readLimited reads a response body of at most limit bytes.
+//
+// Parameters:
+//   - body: The response body
+//   - contentLength: The announced length of the body, or -1 if unknown
+//   - limit: The size limit, or 0 for none
+//
+// Returns:
+//   - []byte: The body
+//   - error: errResponseTooLarge if the body is over the limit, or the read error
+func readLimited(body io.Reader, contentLength, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(body)
+	}
+	if contentLength > limit {
+		return nil, errResponseTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errResponseTooLarge
+	}
+	return data, nil
+}
+
+// limitReader returns a reader failing with errResponseTooLarge past limit bytes, or
+// the reader itself for a zero limit.
+func limitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &sizeLimitedReader{r: r, remaining: limit}
+}
+
+// sizeLimitedReader reads up to a size limit.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, errResponseTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// tooLargeError is the error answering a call whose response is over its limit.
+func tooLargeError(subject, name string, limit int64) *JSONRPCError {
+	return &JSONRPCError{
+		Code:    -32008,
+		Message: "response too large",
+		Data:    fmt.Sprintf("%s from %s exceeds the limit of %d bytes; narrow the request", subject, name, limit),
+	}
+}
+
+// tooLargeResponses answers calls whose response is over its limit.
+//
+// Parameters:
+//   - requests: The calls
+//   - subject: What exceeded the limit, such as "eth_getLogs response"
+//   - name: The upstream name
+//   - limit: The exceeded limit in bytes
+//
+// Returns:
+//   - []json.RawMessage: A -32008 error response for each call
+func tooLargeResponses(requests []json.RawMessage, subject, name string, limit int64) []json.RawMessage {
+	responses := make([]json.RawMessage, len(requests))
+	for i, raw := range requests {
+		responses[i], _ = json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: responseID(raw), Error: tooLargeError(subject, name, limit)})
+	}
+	return responses
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sizedResultServer answers eth_getLogs with a 3000-byte result and other methods with
+// a short one, for single calls and batches.
+func sizedResultServer() *httptest.Server {
+	result := func(call JSONRPCRequest) JSONRPCResponse {
+		if call.Method == "eth_getLogs" {
+			return JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Result: strings.Repeat("x", 3000)}
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", ID: call.ID, Result: "0x1"}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var calls []JSONRPCRequest
+		if json.Unmarshal(body, &calls) == nil {
+			responses := make([]JSONRPCResponse, len(calls))
+			for i, call := range calls {
+				responses[i] = result(call)
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+		var call JSONRPCRequest
+		json.Unmarshal(body, &call)
+		json.NewEncoder(w).Encode(result(call))
+	}))
+}
+
+// TestResponseLimit tests choosing the limit of a method
+func TestResponseLimit(t *testing.T) {
+	// Setup
+	config = Config{ResponseLimits: &ResponseLimitsConfig{MaxBytes: 1000, Methods: map[string]int64{
+		"debug_*":                100,
+		"debug_trace*":           200,
+		"debug_traceTransaction": 300,
+	}}}
+	defer func() { config = Config{} }()
+
+	tests := []struct {
+		method  string
+		limit   int64
+		pattern string
+	}{
+		{"debug_traceTransaction", 300, "debug_traceTransaction"},
+		{"debug_traceBlockByNumber", 200, "debug_trace*"},
+		{"debug_getRawBlock", 100, "debug_*"},
+		{"eth_getLogs", 1000, "max_bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			// Test
+			limit, pattern := responseLimit(tt.method)
+
+			// Verify
+			if limit != tt.limit || pattern != tt.pattern {
+				t.Errorf("Expected limit %d from %s, got %d from %s", tt.limit, tt.pattern, limit, pattern)
+			}
+		})
+	}
+	if limit := batchResponseLimit([]string{"eth_call", "debug_getRawBlock"}); limit != 1100 {
+		t.Errorf("Expected the batch limit to be the sum of its calls' limits, got %d", limit)
+	}
+}
+
+// TestResponseSizeLimits tests answering calls whose response is over the limit with an error
+func TestResponseSizeLimits(t *testing.T) {
+	// Setup
+	server := sizedResultServer()
+	defer server.Close()
+	config = Config{
+		DefaultURL:     server.URL,
+		DefaultName:    "main",
+		ResponseLimits: &ResponseLimitsConfig{MaxBytes: 1000, Methods: map[string]int64{"eth_getLogs": 100}},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	request := func(body string) string {
+		w := httptest.NewRecorder()
+		handleProxy(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	// Test
+	limited := request(`{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[]}`)
+	allowed := request(`{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}`)
+	batch := request(`[{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":4,"method":"eth_getLogs"}]`)
+	config.BatchStreaming = &BatchStreamingConfig{MinCalls: 3}
+	streamed := request(`[{"jsonrpc":"2.0","id":5,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":6,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":7,"method":"eth_getLogs"}]`)
+
+	// Verify
+	if !strings.Contains(limited, `"code":-32008`) || !strings.Contains(limited, "eth_getLogs response from main exceeds the limit of 100 bytes") {
+		t.Errorf("Expected a -32008 error naming the method and limit, got %s", limited)
+	}
+	if !strings.Contains(allowed, `"result":"0x1"`) {
+		t.Errorf("Expected the response under the limit to be passed on, got %s", allowed)
+	}
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal([]byte(batch), &responses); err != nil || len(responses) != 2 || responses[0].Error == nil || responses[1].Error == nil {
+		t.Errorf("Expected both calls of the batch over its limit to get the error, got %s", batch)
+	}
+	responses = nil
+	if err := json.Unmarshal([]byte(streamed), &responses); err != nil || len(responses) != 3 {
+		t.Fatalf("Expected a streamed array of 3 responses, got %s", streamed)
+	}
+	for _, response := range responses {
+		failed := response.Error != nil && response.Error.Code == -32008
+		if failed != (response.ID == float64(7)) {
+			t.Errorf("Expected only the call past the limit to fail, got %+v", response)
+		}
+	}
+}
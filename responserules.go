@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Response rewriting rules
+//
+// Response rules present a uniform node "personality" whatever provider serves a call.
+// Each rule applies to the responses of one method (or every method) and can replace
+// the result of successful responses, strip fields that providers inject, and rewrite
+// error messages. Rules run in configuration order, after error normalization.
+
+// ResponseRule rewrites upstream responses.
+type ResponseRule struct {
+	Method       string      `yaml:"method"`        // Method whose responses are rewritten (empty: every method)
+	Result       interface{} `yaml:"result"`        // Replaces the result of successful responses (optional)
+	StripFields  []string    `yaml:"strip_fields"`  // Dotted paths to remove, e.g. "usage" or "result.l1Fee" (optional)
+	ErrorPattern string      `yaml:"error_pattern"` // Regular expression matched against error messages (optional)
+	ErrorMessage string      `yaml:"error_message"` // Replacement for matching error messages; supports $1 expansions
+
+	pattern *regexp.Regexp
+}
+
+// activeResponseRules holds the compiled rules in order.
+var activeResponseRules []ResponseRule
+
+// setupResponseRules compiles the configured response rules and registers the response
+// transform when there are any.
+//
+// Returns:
+//   - error: An error if a rule is invalid
+func setupResponseRules() error {
+	activeResponseRules = nil
+	unregisterResponseTransform("response-rules")
+	if len(config.ResponseRules) == 0 {
+		return nil
+	}
+
+	rules := append([]ResponseRule{}, config.ResponseRules...)
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Result == nil && len(rule.StripFields) == 0 && rule.ErrorPattern == "" {
+			return fmt.Errorf("response rule %d (%s): nothing to rewrite", i, rule.Method)
+		}
+		if rule.ErrorPattern != "" {
+			re, err := regexp.Compile(rule.ErrorPattern)
+			if err != nil {
+				return fmt.Errorf("response rule %d (%s): invalid error_pattern: %w", i, rule.Method, err)
+			}
+			rule.pattern = re
+		}
+	}
+	activeResponseRules = rules
+
+	registerResponseTransform("response-rules", applyResponseRules)
+	return nil
+}
+
+// applyResponseRules is the response transform that applies the matching rules to one
+// JSON-RPC response object. Bodies that are not JSON objects are returned unchanged.
+func applyResponseRules(call *JSONRPCRequest, status int, body []byte) []byte {
+	var resp map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&resp); err != nil {
+		return body
+	}
+
+	changed := false
+	for i := range activeResponseRules {
+		rule := &activeResponseRules[i]
+		if rule.Method != "" && rule.Method != call.Method {
+			continue
+		}
+
+		if _, ok := resp["result"]; ok && rule.Result != nil {
+			resp["result"] = rule.Result
+			changed = true
+		}
+		for _, path := range rule.StripFields {
+			if stripPath(resp, strings.Split(path, ".")) {
+				changed = true
+			}
+		}
+		if rule.pattern != nil {
+			if e, ok := resp["error"].(map[string]interface{}); ok {
+				if message, ok := e["message"].(string); ok && rule.pattern.MatchString(message) {
+					e["message"] = rule.pattern.ReplaceAllString(message, rule.ErrorMessage)
+					changed = true
+				}
+			}
+		}
+	}
+	if !changed {
+		return body
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// stripPath removes the field at a dotted path. Arrays along the path are traversed
+// element by element, so "result.l1Fee" also strips the field from every receipt in
+// an array result. It reports whether anything was removed.
+func stripPath(v interface{}, path []string) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			if _, ok := v[path[0]]; ok {
+				delete(v, path[0])
+				return true
+			}
+			return false
+		}
+		return stripPath(v[path[0]], path[1:])
+	case []interface{}:
+		removed := false
+		for _, item := range v {
+			if stripPath(item, path) {
+				removed = true
+			}
+		}
+		return removed
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestResponseRules tests result overrides, field stripping, and error rewriting
+func TestResponseRules(t *testing.T) {
+	// Setup
+	config = Config{
+		ResponseRules: []ResponseRule{
+			{Method: "net_version", Result: "59144"},
+			{StripFields: []string{"usage", "result.l1Fee"}},
+			{ErrorPattern: `(?i)^execution reverted: (.*)$`, ErrorMessage: "reverted: $1"},
+		},
+	}
+	if err := setupResponseRules(); err != nil {
+		t.Fatalf("Failed to set up response rules: %v", err)
+	}
+	defer func() {
+		config.ResponseRules = nil
+		setupResponseRules()
+	}()
+
+	testCases := []struct {
+		name     string
+		method   string
+		body     string
+		expected string
+	}{
+		{"Result override", "net_version", `{"jsonrpc":"2.0","id":1,"result":"1"}`, `{"jsonrpc":"2.0","id":1,"result":"59144"}`},
+		{"Strip top-level field", "eth_call", `{"jsonrpc":"2.0","id":1,"result":"0x","usage":{"cu":26}}`, `{"jsonrpc":"2.0","id":1,"result":"0x"}`},
+		{"Strip from array result", "eth_getBlockReceipts", `{"jsonrpc":"2.0","id":1,"result":[{"status":"0x1","l1Fee":"0x5"},{"status":"0x0"}]}`, `{"jsonrpc":"2.0","id":1,"result":[{"status":"0x1"},{"status":"0x0"}]}`},
+		{"Error message rewrite", "eth_call", `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted: not owner"}}`, `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"reverted: not owner"}}`},
+		{"Error keeps result override off", "net_version", `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"down"}}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"down"}}`},
+		{"Large numbers preserved", "eth_call", `{"jsonrpc":"2.0","id":18446744073709551615,"result":"0x","usage":1}`, `{"id":18446744073709551615,"jsonrpc":"2.0","result":"0x"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			out := applyResponseRules(&JSONRPCRequest{Method: tc.method}, 200, []byte(tc.body))
+
+			// Verify
+			var got, expected interface{}
+			json.Unmarshal(out, &got)
+			json.Unmarshal([]byte(tc.expected), &expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected %s, got %s", tc.expected, string(out))
+			}
+			if tc.name == "Large numbers preserved" && string(out) != tc.expected {
+				t.Errorf("Expected exact output %s, got %s", tc.expected, string(out))
+			}
+		})
+	}
+}
+
+// TestResponseRulesInvalid tests rejection of invalid rules
+func TestResponseRulesInvalid(t *testing.T) {
+	defer func() {
+		config.ResponseRules = nil
+		setupResponseRules()
+	}()
+	for _, rule := range []ResponseRule{{Method: "eth_call"}, {ErrorPattern: "("}} {
+		config = Config{ResponseRules: []ResponseRule{rule}}
+		if err := setupResponseRules(); err == nil {
+			t.Errorf("Expected rule %+v to be rejected", rule)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// REST gateway
+//
+// Consumers that just want to curl a value can use REST-style GET endpoints defined in
+// the configuration, each translated to a JSON-RPC call:
+//
+//	rest:
+//	  endpoints:
+//	    - path: /v1/accounts/{addr}/balance
+//	      method: eth_getBalance
+//	      params: ["${path.addr}", "${query.block}"]
+//	      defaults: {block: latest}
+//
+// Path segments in braces become path variables, and query parameters are available as
+// query.<name>, with defaults for absent ones. The params template works like a route's
+// rewrite template (see rewrite.go). The call goes through the same pipeline as JSON-RPC
+// requests, so routing and every other feature apply. The response body is the call's
+// result as JSON; a JSON-RPC error is returned as {"error": {...}} with status 400 for
+// invalid params and 502 otherwise.
+
+// RESTConfig configures the REST gateway.
+type RESTConfig struct {
+	Endpoints []RESTEndpoint `yaml:"endpoints"` // REST endpoints
+}
+
+// RESTEndpoint maps a GET endpoint to a JSON-RPC call.
+type RESTEndpoint struct {
+	Path     string            `yaml:"path"`     // URL path, with {name} segments for path variables
+	Method   string            `yaml:"method"`   // JSON-RPC method called
+	Params   interface{}       `yaml:"params"`   // Params template (optional)
+	Defaults map[string]string `yaml:"defaults"` // Values of absent query parameters
+
+	params paramTemplate // Compiled params template
+}
+
+// setupREST compiles the REST endpoints and registers them on a mux.
+//
+// Parameters:
+//   - mux: The mux serving the proxy
+//   - handler: The proxy handler the translated calls are sent through
+//
+// Returns:
+//   - error: An error describing the first invalid endpoint
+func setupREST(mux *http.ServeMux, handler http.HandlerFunc) error {
+	if config.REST == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for i := range config.REST.Endpoints {
+		ep := &config.REST.Endpoints[i]
+		if !strings.HasPrefix(ep.Path, "/") {
+			return fmt.Errorf("rest.endpoints[%d]: path must start with /", i)
+		}
+		if ep.Method == "" {
+			return fmt.Errorf("rest.endpoints[%d] (%s): method is required", i, ep.Path)
+		}
+		if seen[ep.Path] {
+			return fmt.Errorf("rest.endpoints[%d]: duplicate path %s", i, ep.Path)
+		}
+		seen[ep.Path] = true
+		if ep.Params != nil {
+			t, err := compileParamTemplate(ep.Params)
+			if err != nil {
+				return fmt.Errorf("rest.endpoints[%d] (%s): %w", i, ep.Path, err)
+			}
+			ep.params = t
+		}
+		if err := registerPattern(mux, http.MethodGet+" "+ep.Path, restHandler(ep, handler)); err != nil {
+			return fmt.Errorf("rest.endpoints[%d]: %w", i, err)
+		}
+	}
+	if len(config.REST.Endpoints) > 0 {
+		log.Printf("Serving %d REST endpoints", len(config.REST.Endpoints))
+	}
+	return nil
+}
+
+// registerPattern registers a handler, turning the mux's panic on an invalid or
+// conflicting pattern into an error.
+func registerPattern(mux *http.ServeMux, pattern string, handler http.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.HandleFunc(pattern, handler)
+	return nil
+}
+
+// restHandler returns the handler of a REST endpoint.
+func restHandler(ep *RESTEndpoint, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ep.call(r)
+		if err != nil {
+			writeRESTError(w, http.StatusBadRequest, &JSONRPCError{Code: -32602, Message: err.Error()})
+			return
+		}
+
+		// Send the call through the proxy pipeline
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, "/", bytes.NewReader(body))
+		req.Header = r.Header.Clone()
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = r.RemoteAddr
+		resp := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		handler(resp, req)
+		if resp.status != http.StatusOK {
+			w.WriteHeader(resp.status)
+			w.Write(resp.body)
+			return
+		}
+
+		var rpcResp struct {
+			Result json.RawMessage `json:"result"`
+			Error  *JSONRPCError   `json:"error"`
+		}
+		if err := json.Unmarshal(resp.body, &rpcResp); err != nil {
+			writeRESTError(w, http.StatusBadGateway, &JSONRPCError{Code: -32603, Message: "invalid upstream response"})
+			return
+		}
+		if rpcResp.Error != nil {
+			status := http.StatusBadGateway
+			if rpcResp.Error.Code == -32602 {
+				status = http.StatusBadRequest
+			}
+			writeRESTError(w, status, rpcResp.Error)
+			return
+		}
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(rpcResp.Result)
+	}
+}
+
+// call builds the JSON-RPC request body for a REST request.
+func (ep *RESTEndpoint) call(r *http.Request) ([]byte, error) {
+	req := JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: ep.Method, Params: []interface{}{}}
+	if ep.params != nil {
+		params, err := ep.params(restVars(ep, r))
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := params.([]interface{}); ok {
+			for len(list) > 0 && list[len(list)-1] == nil {
+				list = list[:len(list)-1]
+			}
+			params = list
+		}
+		req.Params = params
+	}
+	return json.Marshal(req)
+}
+
+// restVars returns the expression variables of a REST request: path and query.
+func restVars(ep *RESTEndpoint, r *http.Request) map[string]interface{} {
+	path := make(map[string]interface{})
+	for _, segment := range strings.Split(ep.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := strings.TrimSuffix(strings.TrimSuffix(segment[1:len(segment)-1], "..."), "$")
+			path[name] = r.PathValue(name)
+		}
+	}
+	query := make(map[string]interface{})
+	for name, value := range ep.Defaults {
+		query[name] = value
+	}
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+	return map[string]interface{}{"path": path, "query": query}
+}
+
+// writeRESTError writes a JSON-RPC error as a REST error response.
+func writeRESTError(w http.ResponseWriter, status int, rpcErr *JSONRPCError) {
+	data, _ := json.Marshal(map[string]*JSONRPCError{"error": rpcErr})
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
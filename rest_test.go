@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRESTGateway tests translating REST requests to JSON-RPC calls
+func TestRESTGateway(t *testing.T) {
+	// Setup: an upstream answering eth_getBalance with its params
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JSONRPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if params, _ := req.Params.([]interface{}); len(params) != 2 {
+			json.NewEncoder(w).Encode(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &JSONRPCError{Code: -32602, Message: "missing block"}})
+			return
+		}
+		json.NewEncoder(w).Encode(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.Params})
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL: server.URL,
+		REST: &RESTConfig{Endpoints: []RESTEndpoint{
+			{Path: "/v1/accounts/{addr}/balance", Method: "eth_getBalance", Params: []interface{}{"${path.addr}", "${query.block}"}, Defaults: map[string]string{"block": "latest"}},
+			{Path: "/v1/accounts/{addr}/raw", Method: "eth_getBalance", Params: []interface{}{"${path.addr}", "${query.block}"}},
+		}},
+	}
+	defer func() { config = Config{} }()
+	buildMethodURLMap()
+	mux := http.NewServeMux()
+	if err := setupREST(mux, handleProxy); err != nil {
+		t.Fatalf("Invalid REST configuration: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		status   int
+		expected string
+	}{
+		{"default query value", "/v1/accounts/0xabc/balance", 200, `["0xabc","latest"]`},
+		{"query value", "/v1/accounts/0xabc/balance?block=0x10", 200, `["0xabc","0x10"]`},
+		{"JSON-RPC error", "/v1/accounts/0xabc/raw", 400, `{"error":{"code":-32602,"message":"missing block"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+
+			// Verify
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if got := string(bytes.TrimSpace(w.Body.Bytes())); got != tt.expected {
+				t.Errorf("Expected body %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// Verify conflicting paths are rejected
+	config.REST = &RESTConfig{Endpoints: []RESTEndpoint{{Path: "/v1/{a}", Method: "m"}, {Path: "/v1/{b}", Method: "m"}}}
+	if err := setupREST(http.NewServeMux(), handleProxy); err == nil {
+		t.Errorf("Expected an error for conflicting paths")
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Upstream retries
+//
+// A request that fails to connect, or that an upstream answers with 502, 503, or 504,
+// can be retried on the same upstream after a short backoff:
+//
+//	retries:
+//	  max: 2                        # retries of a failed request (default: 0, no retries)
+//	  backoff: 100ms                # wait before the first retry (default)
+//	  backoff_strategy: exponential # constant, exponential (default), or exponential_jitter
+//	  max_backoff: 5s               # cap on the wait before a retry (default)
+//	  budget:
+//	    ratio: 0.2         # retries per request sent, over the window (default)
+//	    min_retries: 10    # retries allowed per window whatever the traffic (default)
+//	    window: 10s        # default
+//
+// During a provider outage every request fails, and retrying each of them would
+// multiply the traffic on an upstream that is already struggling. Retries are therefore
+// drawn from a budget: each upstream may retry at most ratio times the requests sent to
+// it over the last window, plus min_retries so that a quiet upstream can still retry
+// at all, and the same limit applies across all upstreams together. A failure that the
+// budget cannot cover is returned as is. Retries and exhausted budgets are counted as
+// jsonrpc_proxy_upstream_retries_total{upstream,result="retried"|"budget_exhausted"}.
+//
+// With exponential backoff, the wait doubles for each further retry, up to max_backoff.
+// exponential_jitter waits a random time between zero and the exponential wait ("full
+// jitter"), so that clients failing together do not retry together. Routes can use a
+// backoff of their own, inheriting the settings they leave unset:
+//
+//	routes:
+//	  - method: debug_traceTransaction
+//	    url: https://archive.example.com
+//	    retries:
+//	      backoff: 1s
+//	      backoff_strategy: constant
+//
+// Retries stay within the upstream's total timeout (see timeouts.go) and stop when the
+// client disconnects. Each retry waits for the upstream's pacing quota like a first
+// attempt (see pacing.go), and is given up if none comes within max_wait. A 429 is never retried, since it asks for less traffic, and
+// neither is a response carrying a JSON-RPC error.
+
+// RetryConfig configures upstream retries.
+type RetryConfig struct {
+	Max             int               `yaml:"max"`              // Retries of a failed request (default: 0, no retries)
+	Backoff         time.Duration     `yaml:"backoff"`          // Wait before the first retry (default: 100ms)
+	BackoffStrategy string            `yaml:"backoff_strategy"` // "constant", "exponential", or "exponential_jitter" (default: exponential)
+	MaxBackoff      time.Duration     `yaml:"max_backoff"`      // Cap on the wait before a retry (default: 5s)
+	Budget          RetryBudgetConfig `yaml:"budget"`           // Limit on retries relative to traffic
+}
+
+// BackoffPolicy sets the wait before each retry of a route's calls, over the global
+// settings.
+type BackoffPolicy struct {
+	Backoff    time.Duration `yaml:"backoff"`          // Wait before the first retry (default: retries.backoff)
+	Strategy   string        `yaml:"backoff_strategy"` // "constant", "exponential", or "exponential_jitter" (default: retries.backoff_strategy)
+	MaxBackoff time.Duration `yaml:"max_backoff"`      // Cap on the wait before a retry (default: retries.max_backoff)
+}
+
+// RetryBudgetConfig limits retries relative to the requests sent.
+type RetryBudgetConfig struct {
+	Ratio      float64       `yaml:"ratio"`       // Retries per request sent (default: 0.2)
+	MinRetries int           `yaml:"min_retries"` // Retries allowed per window regardless of traffic (default: 10)
+	Window     time.Duration `yaml:"window"`      // Period the budget covers (default: 10s)
+}
+
+// Default retry settings.
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+	defaultRetryRatio      = 0.2
+	defaultRetryMinRetries = 10
+	defaultRetryWindow     = 10 * time.Second
+)
+
+// retrier retries failed upstream requests within budgets.
+type retrier struct {
+	max     int
+	backoff BackoffPolicy // With defaults applied
+	ratio   float64
+	min     int64
+	seconds int // Width of the budget window
+
+	mu      sync.Mutex
+	global  *retryBudget
+	budgets map[string]*retryBudget // By upstream URL
+}
+
+// retryBudget counts requests and retries in a ring of one-second buckets.
+type retryBudget struct {
+	buckets []retryBucket
+}
+
+// retryBucket is the traffic of one second.
+type retryBucket struct {
+	second   int64
+	requests int64
+	retries  int64
+}
+
+// upstreamRetries is the retrier, or nil when retries are off.
+var upstreamRetries *retrier
+
+// upstreamRetriesTotal counts retries by upstream and result.
+var upstreamRetriesTotal = newCounterVec("jsonrpc_proxy_upstream_retries_total", "Retries of failed upstream requests, and retries refused by the retry budget.", "upstream", "result")
+
+// setupRetries creates the retrier.
+//
+// Returns:
+//   - error: An error if a setting is invalid
+func setupRetries() error {
+	upstreamRetries = nil
+	rc := config.Retries
+	if rc == nil || rc.Max == 0 {
+		return nil
+	}
+	if rc.Max < 0 || rc.Budget.Ratio < 0 || rc.Budget.MinRetries < 0 || rc.Budget.Window < 0 {
+		return fmt.Errorf("settings cannot be negative")
+	}
+	global := BackoffPolicy{Backoff: rc.Backoff, Strategy: rc.BackoffStrategy, MaxBackoff: rc.MaxBackoff}
+	if err := global.validate(); err != nil {
+		return err
+	}
+	for i, route := range config.Routes {
+		if route.Retries != nil {
+			if err := route.Retries.validate(); err != nil {
+				return fmt.Errorf("routes[%d] (%s): %w", i, route.Method, err)
+			}
+		}
+	}
+	r := &retrier{
+		max:     rc.Max,
+		backoff: BackoffPolicy{Backoff: defaultRetryBackoff, Strategy: "exponential", MaxBackoff: defaultRetryMaxBackoff}.with(&global),
+		ratio:   defaultRetryRatio,
+		min:     defaultRetryMinRetries,
+		seconds: int(defaultRetryWindow / time.Second),
+		budgets: make(map[string]*retryBudget),
+	}
+	if rc.Budget.Ratio > 0 {
+		r.ratio = rc.Budget.Ratio
+	}
+	if rc.Budget.MinRetries > 0 {
+		r.min = int64(rc.Budget.MinRetries)
+	}
+	if rc.Budget.Window > 0 {
+		if rc.Budget.Window < time.Second {
+			return fmt.Errorf("budget.window must be at least 1s")
+		}
+		r.seconds = int(rc.Budget.Window / time.Second)
+	}
+	r.global = r.newBudget()
+	upstreamRetries = r
+	log.Printf("Retrying failed upstream requests up to %d times, within %g retries per request over %v", r.max, r.ratio, time.Duration(r.seconds)*time.Second)
+	return nil
+}
+
+// validate checks the backoff settings.
+func (p *BackoffPolicy) validate() error {
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("backoff and max_backoff cannot be negative")
+	}
+	switch p.Strategy {
+	case "", "constant", "exponential", "exponential_jitter":
+	default:
+		return fmt.Errorf("unknown backoff_strategy %q (expected constant, exponential, or exponential_jitter)", p.Strategy)
+	}
+	return nil
+}
+
+// with returns the policy with the settings that override sets.
+func (p BackoffPolicy) with(override *BackoffPolicy) BackoffPolicy {
+	if override == nil {
+		return p
+	}
+	if override.Backoff > 0 {
+		p.Backoff = override.Backoff
+	}
+	if override.Strategy != "" {
+		p.Strategy = override.Strategy
+	}
+	if override.MaxBackoff > 0 {
+		p.MaxBackoff = override.MaxBackoff
+	}
+	return p
+}
+
+// wait returns the wait before a retry.
+//
+// Parameters:
+//   - retry: The number of the retry, from 0 for the first
+func (p BackoffPolicy) wait(retry int) time.Duration {
+	d := p.Backoff
+	if p.Strategy != "constant" {
+		for i := 0; i < retry && d < p.MaxBackoff; i++ {
+			d *= 2
+		}
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Strategy == "exponential_jitter" {
+		d = time.Duration(rand.Int63n(int64(d) + 1))
+	}
+	return d
+}
+
+type retryBackoffKey struct{}
+
+// withRetryBackoff returns a context carrying the backoff of a route for forwardRequest.
+func withRetryBackoff(ctx context.Context, route *Route) context.Context {
+	if route == nil || route.Retries == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBackoffKey{}, route.Retries)
+}
+
+// newBudget creates an empty budget.
+func (r *retrier) newBudget() *retryBudget {
+	return &retryBudget{buckets: make([]retryBucket, r.seconds)}
+}
+
+// bucket returns the bucket of a second, resetting it if it held an older second.
+func (b *retryBudget) bucket(second int64) *retryBucket {
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		*bucket = retryBucket{second: second}
+	}
+	return bucket
+}
+
+// totals returns the requests and retries of the window ending at second.
+func (b *retryBudget) totals(second int64) (requests, retries int64) {
+	oldest := second - int64(len(b.buckets)) + 1
+	for _, bucket := range b.buckets {
+		if bucket.second >= oldest && bucket.second <= second {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	return requests, retries
+}
+
+// allows reports whether a budget can cover one more retry.
+func (r *retrier) allows(b *retryBudget, second int64) bool {
+	requests, retries := b.totals(second)
+	return float64(retries+1) <= float64(r.min)+r.ratio*float64(requests)
+}
+
+// recordRequest counts a request sent to an upstream.
+func (r *retrier) recordRequest(url string, now time.Time) {
+	second := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.budgets[url]
+	if !ok {
+		b = r.newBudget()
+		r.budgets[url] = b
+	}
+	b.bucket(second).requests++
+	r.global.bucket(second).requests++
+}
+
+// withdraw takes a retry from the upstream's budget and the global one.
+//
+// Returns:
+//   - bool: False if either budget is exhausted
+func (r *retrier) withdraw(url string, now time.Time) bool {
+	second := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.budgets[url]
+	if !ok || !r.allows(b, second) || !r.allows(r.global, second) {
+		return false
+	}
+	b.bucket(second).retries++
+	r.global.bucket(second).retries++
+	return true
+}
+
+// retryable reports whether a failed attempt may be retried: the request did not reach
+// the upstream, or the upstream or a gateway in front of it was unavailable.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request, retrying it while it fails, retries remain, and the budgets allow.
+//
+// Parameters:
+//   - ctx: The context bounding the attempts and backoffs
+//   - client: The client of the upstream
+//   - req: The request, whose body can be replayed
+//   - beforeRetry: Called before each retry is sent, which is given up if it fails
+//
+// Returns:
+//   - *http.Response: The response of the last attempt
+//   - error: The error of the last attempt
+func (r *retrier) do(ctx context.Context, client *http.Client, req *http.Request, beforeRetry func() error) (*http.Response, error) {
+	url := req.URL.String()
+	label := upstreamLabel(Upstream{URL: url})
+	r.recordRequest(url, time.Now())
+	resp, err := client.Do(req)
+	backoff := r.backoff
+	if override, ok := ctx.Value(retryBackoffKey{}).(*BackoffPolicy); ok {
+		backoff = backoff.with(override)
+	}
+	for attempt := 0; attempt < r.max && retryable(ctx, resp, err); attempt++ {
+		if !r.withdraw(url, time.Now()) {
+			upstreamRetriesTotal.inc(label, "budget_exhausted")
+			logDebug("router", "Not retrying request to %s: retry budget exhausted", label)
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(backoff.wait(attempt)):
+		}
+		if retryErr := beforeRetry(); retryErr != nil {
+			logDebug("router", "Not retrying request to %s: %v", label, retryErr)
+			break
+		}
+
+		retry := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				break
+			}
+			retry.Body = body
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		upstreamRetriesTotal.inc(label, "retried")
+		noteRetry(ctx, url)
+		logDebug("router", "Retrying request to %s after %v", label, describeFailure(resp, err))
+		resp, err = client.Do(retry)
+	}
+	return resp, err
+}
+
+// describeFailure describes a failed attempt for logging.
+func describeFailure(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
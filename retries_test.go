@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRetryBudget tests that retries recover a transient failure but stay within the budget during an outage
+func TestRetryBudget(t *testing.T) {
+	// Setup
+	var flakyHits, downHits atomic.Int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flakyHits.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer flaky.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	config = Config{
+		DefaultURL: down.URL,
+		Routes:     []Route{{Method: "eth_chainId", URL: flaky.URL}},
+		Retries:    &RetryConfig{Max: 2, Backoff: time.Millisecond, Budget: RetryBudgetConfig{Ratio: 0.2, MinRetries: 1}},
+	}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		upstreamRetries = nil
+	}()
+	if err := setupRetries(); err != nil {
+		t.Fatalf("Failed to set up retries: %v", err)
+	}
+	exhaustedBefore := upstreamRetriesTotal.value(upstreamLabel(Upstream{URL: down.URL}), "budget_exhausted")
+
+	// Test
+	flakyErr := callProxy(t, "eth_chainId", nil, nil)
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handleProxy(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"eth_blockNumber","id":1}`)))
+	}
+
+	// Verify
+	if flakyErr != nil || flakyHits.Load() != 2 {
+		t.Errorf("Expected the transient failure to be retried once, got %v after %d attempts", flakyErr, flakyHits.Load())
+	}
+	// 10 requests allow 1 + 0.2*10 retries across both upstreams, one of which went to the flaky one
+	if hits := downHits.Load(); hits < 11 || hits > 12 {
+		t.Errorf("Expected 1 or 2 retries of the failing upstream, got %d", hits-10)
+	}
+	if upstreamRetriesTotal.value(upstreamLabel(Upstream{URL: down.URL}), "budget_exhausted") == exhaustedBefore {
+		t.Errorf("Expected exhausted budgets to be counted")
+	}
+}
+
+// TestBackoffStrategies tests the wait before each retry under each strategy
+func TestBackoffStrategies(t *testing.T) {
+	base := BackoffPolicy{Backoff: 100 * time.Millisecond, Strategy: "exponential", MaxBackoff: time.Second}
+	testCases := []struct {
+		name     string
+		override *BackoffPolicy
+		expected []time.Duration // Waits before retries 0 to 4
+	}{
+		{"Exponential", nil, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}},
+		{"Constant", &BackoffPolicy{Strategy: "constant", Backoff: 300 * time.Millisecond}, []time.Duration{300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}},
+		{"Capped", &BackoffPolicy{MaxBackoff: 250 * time.Millisecond}, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			policy := base.with(tc.override)
+
+			// Verify
+			for retry, expected := range tc.expected {
+				if got := policy.wait(retry); got != expected {
+					t.Errorf("Expected a wait of %v before retry %d, got %v", expected, retry, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Full jitter", func(t *testing.T) {
+		// Setup
+		policy := base.with(&BackoffPolicy{Strategy: "exponential_jitter"})
+
+		// Test
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			if d := policy.wait(2); d > longest {
+				longest = d
+			}
+		}
+
+		// Verify
+		if longest > 400*time.Millisecond || longest < 300*time.Millisecond {
+			t.Errorf("Expected jittered waits of up to 400ms, got a longest of %v", longest)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Method rewriting
+//
+// A route can rewrite the calls it carries so that clients written for one node flavor
+// can be served by another, for example sending trace_transaction to a Geth node as
+// debug_traceTransaction:
+//
+//	rewrite:
+//	  method: debug_traceTransaction
+//	  params: ["${params[0]}", {tracer: callTracer}]
+//
+// The params template is any YAML value. A string of the form "${expression}" is
+// replaced by the value of the routing expression (see expr.go) evaluated against the
+// original call; everything else is copied as is. Trailing null params are dropped so
+// that optional params the client omitted stay omitted. Without a params template the
+// original params are sent unchanged. Fields of the call besides jsonrpc, method, params,
+// and id are kept. Responses are returned to the client as is.
+
+// MethodRewrite changes the method and params of calls sent through a route.
+type MethodRewrite struct {
+	Method string      `yaml:"method"` // Outbound method name (optional; the original is kept if empty)
+	Params interface{} `yaml:"params"` // Outbound params template (optional)
+
+	params paramTemplate // Compiled params template
+}
+
+// paramTemplate produces a param value from expression variables (normally those of
+// the original call, see requestVars).
+type paramTemplate func(vars map[string]interface{}) (interface{}, error)
+
+// compile compiles the params template.
+//
+// Returns:
+//   - error: An error if an embedded expression does not compile
+func (rw *MethodRewrite) compile() error {
+	if rw.Method == "" && rw.Params == nil {
+		return fmt.Errorf("rewrite needs a method or params")
+	}
+	if rw.Params == nil {
+		rw.params = nil
+		return nil
+	}
+	t, err := compileParamTemplate(rw.Params)
+	if err != nil {
+		return err
+	}
+	rw.params = t
+	return nil
+}
+
+// compileParamTemplate compiles a template value.
+func compileParamTemplate(v interface{}) (paramTemplate, error) {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "${") && strings.HasSuffix(v, "}") {
+			e, err := compileExpr(v[2 : len(v)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid expression %q: %w", v, err)
+			}
+			return func(vars map[string]interface{}) (interface{}, error) { return e.eval(vars) }, nil
+		}
+	case []interface{}:
+		items := make([]paramTemplate, len(v))
+		for i, item := range v {
+			t, err := compileParamTemplate(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = t
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			out := make([]interface{}, len(items))
+			for i, t := range items {
+				value, err := t(vars)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = value
+			}
+			return out, nil
+		}, nil
+	case map[string]interface{}:
+		fields := make(map[string]paramTemplate, len(v))
+		for k, item := range v {
+			t, err := compileParamTemplate(item)
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = t
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			out := make(map[string]interface{}, len(fields))
+			for k, t := range fields {
+				value, err := t(vars)
+				if err != nil {
+					return nil, err
+				}
+				out[k] = value
+			}
+			return out, nil
+		}, nil
+	}
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }, nil
+}
+
+// rewriteCall returns the call as it should be sent through the upstream's route,
+// after its param rules and method rewriting. It returns the original call when the
+// route changes nothing.
+//
+// Parameters:
+//   - ctx: The context bounding lookups made by param rules
+//   - upstream: The selected upstream
+//   - req: The original call
+//
+// Returns:
+//   - *JSONRPCRequest: The outbound call
+//   - bool: Whether the call was changed
+//   - error: An error if a param rule or the params template fails
+func rewriteCall(ctx context.Context, upstream Upstream, req *JSONRPCRequest) (*JSONRPCRequest, bool, error) {
+	params, changed, err := applyParamRules(ctx, upstream, req)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		fixed := *req
+		fixed.Params = params
+		req = &fixed
+	}
+	if upstream.Route == nil || upstream.Route.Rewrite == nil {
+		return req, changed, nil
+	}
+	rw := upstream.Route.Rewrite
+
+	out := *req
+	if rw.Method != "" {
+		out.Method = rw.Method
+	}
+	if rw.params != nil {
+		params, err := rw.params(requestVars(req))
+		if err != nil {
+			return nil, false, fmt.Errorf("error rewriting params for method '%s': %w", req.Method, err)
+		}
+		if list, ok := params.([]interface{}); ok {
+			for len(list) > 0 && list[len(list)-1] == nil {
+				list = list[:len(list)-1]
+			}
+			params = list
+		}
+		out.Params = params
+	}
+	return &out, true, nil
+}
+
+// rewriteBody applies the route's rewriting to a call and returns the outbound body.
+// The original body is returned untouched when the route changes nothing; otherwise
+// fields of the original call unknown to JSONRPCRequest, such as options some
+// providers require, are kept (see marshalCall).
+func rewriteBody(ctx context.Context, upstream Upstream, req *JSONRPCRequest, body []byte) ([]byte, error) {
+	if _, forced := forcedUpstream(ctx); forced {
+		return body, nil
+	}
+	out, rewritten, err := rewriteCall(ctx, upstream, req)
+	if err != nil || !rewritten {
+		return body, err
+	}
+	return marshalCall(body, out)
+}
+
+// marshalCall encodes a rewritten call with the fields of the original call that
+// JSONRPCRequest does not know.
+//
+// Parameters:
+//   - original: The call as the client sent it
+//   - out: The rewritten call
+//
+// Returns:
+//   - []byte: The encoded call
+//   - error: An error if the call cannot be encoded
+func marshalCall(original []byte, out *JSONRPCRequest) ([]byte, error) {
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	var fields, known map[string]json.RawMessage
+	if json.Unmarshal(original, &fields) != nil || json.Unmarshal(encoded, &known) != nil {
+		return encoded, nil
+	}
+	extra := false
+	for name := range fields {
+		// Decoding matches field names case-insensitively, and so does this
+		isKnown := false
+		for knownName := range known {
+			if strings.EqualFold(name, knownName) {
+				isKnown = true
+				break
+			}
+		}
+		if isKnown {
+			delete(fields, name)
+		} else {
+			extra = true
+		}
+	}
+	if !extra {
+		return encoded, nil
+	}
+	for name, value := range known {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestMethodRewrite tests rewriting the method and params of routed calls
+func TestMethodRewrite(t *testing.T) {
+	// Setup
+	var received []JSONRPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var calls []JSONRPCRequest
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			json.Unmarshal(body, &calls)
+		} else {
+			var call JSONRPCRequest
+			json.Unmarshal(body, &call)
+			calls = append(calls, call)
+		}
+		received = append(received, calls...)
+
+		responses := make([]JSONRPCResponse, len(calls))
+		for i, call := range calls {
+			responses[i] = JSONRPCResponse{JSONRPC: "2.0", Result: "ok", ID: call.ID}
+		}
+		if len(calls) == 1 && !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			json.NewEncoder(w).Encode(responses[0])
+			return
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	yamlConfig := `
+default_url: "` + server.URL + `"
+routes:
+  - method: "trace_transaction"
+    url: "` + server.URL + `"
+    rewrite:
+      method: "debug_traceTransaction"
+      params: ["${params[0]}", {tracer: "callTracer"}, "${params[5]}"]
+  - method: "legacy_chainId"
+    url: "` + server.URL + `"
+    rewrite:
+      method: "eth_chainId"
+`
+	config = Config{}
+	if err := yaml.Unmarshal([]byte(yamlConfig), &config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	buildMethodURLMap()
+
+	// Test
+	callProxy(t, "trace_transaction", []interface{}{"0xabc"}, nil)
+	callProxy(t, "legacy_chainId", []interface{}{}, nil)
+	batch := []byte(`[{"jsonrpc":"2.0","method":"trace_transaction","params":["0xdef"],"id":7}]`)
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader(batch)))
+
+	// Verify
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 upstream calls, got %d", len(received))
+	}
+	expected := []interface{}{"0xabc", map[string]interface{}{"tracer": "callTracer"}}
+	if received[0].Method != "debug_traceTransaction" || !reflect.DeepEqual(received[0].Params, expected) {
+		t.Errorf("Expected debug_traceTransaction %v, got %s %v", expected, received[0].Method, received[0].Params)
+	}
+	if received[1].Method != "eth_chainId" || !reflect.DeepEqual(received[1].Params, []interface{}{}) {
+		t.Errorf("Expected eth_chainId with original params, got %s %v", received[1].Method, received[1].Params)
+	}
+	if received[2].Method != "debug_traceTransaction" || received[2].ID != float64(7) {
+		t.Errorf("Expected batch call to be rewritten with ID 7, got %s %v", received[2].Method, received[2].ID)
+	}
+}
+
+// TestMethodRewriteInvalid tests that invalid templates are rejected
+func TestMethodRewriteInvalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rewrite MethodRewrite
+	}{
+		{"Empty rewrite", MethodRewrite{}},
+		{"Invalid expression", MethodRewrite{Method: "m", Params: []interface{}{"${params[}"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			err := tc.rewrite.compile()
+
+			// Verify
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// TestUnknownFieldsPreserved tests forwarding fields unknown to the proxy, with and without rewriting
+func TestUnknownFieldsPreserved(t *testing.T) {
+	// Setup
+	var received [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, body)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	config = Config{
+		DefaultURL: server.URL,
+		Routes:     []Route{{Method: "trace_transaction", URL: server.URL, Rewrite: &MethodRewrite{Method: "debug_traceTransaction"}}},
+	}
+	if err := config.Routes[0].Rewrite.compile(); err != nil {
+		t.Fatalf("Failed to compile rewrites: %v", err)
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+	single := `{"jsonrpc":"2.0", "id":1, "method":"eth_call", "params":[{"value":123456789012345678901234567890}], "metadata":{"tenant":"a"}}`
+	rewritten := `{"jsonrpc":"2.0","id":2,"method":"trace_transaction","params":["0x1"],"traceOptions":{"timeout":"5s"}}`
+
+	// Test
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte(single))))
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte(rewritten))))
+	handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader([]byte("["+single+","+rewritten+"]"))))
+
+	// Verify
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 upstream requests, got %d", len(received))
+	}
+	if string(received[0]) != single {
+		t.Errorf("Expected the single call byte for byte, got %s", received[0])
+	}
+	var call map[string]interface{}
+	json.Unmarshal(received[1], &call)
+	if call["method"] != "debug_traceTransaction" || call["traceOptions"] == nil {
+		t.Errorf("Expected the rewritten call with its trace options, got %s", received[1])
+	}
+	var batch []json.RawMessage
+	json.Unmarshal(received[2], &batch)
+	if len(batch) != 2 || string(batch[0]) != single || !bytes.Contains(batch[1], []byte(`"traceOptions":{"timeout":"5s"}`)) {
+		t.Errorf("Expected both calls with their extra fields, got %s", received[2])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Route groups
+//
+// Configurations with many methods tend to repeat the same upstream, pool, headers,
+// and tags on every route. A route group holds these shared settings once, as a
+// defaults route, and lists the routes that inherit them:
+//
+//	route_groups:
+//	  - name: archive
+//	    defaults:
+//	      pool: archive-nodes
+//	      tags: ["archive-traffic"]
+//	    methods: ["eth_getLogs", "trace_block", "trace_transaction"]
+//	    routes:
+//	      - method: debug_traceTransaction
+//	        url: https://tracer.example.com
+//
+// Every setting a group route leaves empty is taken from the defaults, so any route
+// option can be shared. Setting url, stub, pool, or blue_green on a route replaces
+// whichever of url, pool, and blue_green it would inherit. Routes without a name are
+// named after their group. Group routes are added after the top-level routes, in
+// configuration order.
+
+// RouteGroup is a set of routes sharing default settings.
+type RouteGroup struct {
+	Name     string   `yaml:"name"`     // Name of the group, used as the name of routes without one
+	Defaults Route    `yaml:"defaults"` // Settings inherited by the group's routes
+	Methods  []string `yaml:"methods"`  // Methods routed with the defaults alone (optional)
+	Routes   []Route  `yaml:"routes"`   // Routes overriding some of the defaults (optional)
+}
+
+// expandRouteGroups appends the routes of every group to the configured routes, with
+// the group's defaults applied. Validation errors in the expanded routes point at the
+// group's configuration.
+//
+// Returns:
+//   - error: An error if a group is invalid
+func expandRouteGroups() error {
+	for g, group := range config.RouteGroups {
+		groupPath := fmt.Sprintf("route_groups[%d]", g)
+		if group.Defaults.Method != "" {
+			return configErrorf(groupPath+".defaults.method", "route group %s: defaults cannot set a method", group.Name)
+		}
+		if len(group.Methods) == 0 && len(group.Routes) == 0 {
+			return configErrorf(groupPath, "route group %s must list methods or routes", group.Name)
+		}
+
+		for i, method := range group.Methods {
+			aliasConfigPath(groupPath+".defaults", fmt.Sprintf("routes[%d]", len(config.Routes)))
+			aliasConfigPath(fmt.Sprintf("%s.methods[%d]", groupPath, i), fmt.Sprintf("routes[%d].method", len(config.Routes)))
+			config.Routes = append(config.Routes, inheritRoute(group, Route{Method: method}))
+		}
+		for i, route := range group.Routes {
+			aliasConfigPath(groupPath+".defaults", fmt.Sprintf("routes[%d]", len(config.Routes)))
+			aliasConfigPath(fmt.Sprintf("%s.routes[%d]", groupPath, i), fmt.Sprintf("routes[%d]", len(config.Routes)))
+			config.Routes = append(config.Routes, inheritRoute(group, route))
+		}
+	}
+	return nil
+}
+
+// inheritRoute fills the empty settings of a group route from the group's defaults.
+func inheritRoute(group RouteGroup, route Route) Route {
+	defaults := group.Defaults
+	if route.URL != "" || route.Stub != nil {
+		defaults.Pool = ""
+		defaults.BlueGreen = nil
+	}
+	if route.Pool != "" {
+		defaults.URL = ""
+		defaults.BlueGreen = nil
+	}
+	if route.BlueGreen != nil {
+		defaults.URL = ""
+		defaults.Pool = ""
+	}
+
+	out := route
+	dv := reflect.ValueOf(defaults)
+	ov := reflect.ValueOf(&out).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		if f := ov.Field(i); f.CanSet() && f.IsZero() {
+			f.Set(dv.Field(i))
+		}
+	}
+	if out.Name == "" {
+		out.Name = group.Name
+	}
+	return out
+}
+
+// aliasConfigPath makes the positions recorded under one configuration path available
+// under another, so errors in expanded routes point at the configuration they came from.
+func aliasConfigPath(from, to string) {
+	for path, n := range configNodes {
+		if path == from || strings.HasPrefix(path, from+".") || strings.HasPrefix(path, from+"[") {
+			configNodes[to+strings.TrimPrefix(path, from)] = n
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRouteGroups tests that group routes inherit and override the group's defaults
+func TestRouteGroups(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigFile(t, `
+default_url: http://default.example.com
+pools:
+  archive-nodes:
+    upstreams:
+      - url: http://archive-1.example.com
+routes:
+  - method: eth_chainId
+    stub: {result: "0x1"}
+route_groups:
+  - name: archive
+    defaults:
+      pool: archive-nodes
+      tags: ["archive-traffic"]
+      headers:
+        set: {X-Tier: archive}
+    methods: ["eth_getLogs", "trace_block"]
+    routes:
+      - method: debug_traceTransaction
+        url: http://tracer.example.com
+        name: tracer
+      - method: trace_filter
+        tags: ["heavy"]
+`)
+
+	// Test
+	err := loadConfig(path)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	routes := make(map[string]Route)
+	for _, route := range config.Routes {
+		routes[route.Method] = route
+	}
+	if len(config.Routes) != 5 || config.Routes[0].Method != "eth_chainId" {
+		t.Fatalf("Expected top-level routes followed by 4 group routes, got %+v", config.Routes)
+	}
+
+	testCases := []struct {
+		method string
+		pool   string
+		url    string
+		name   string
+		tags   string
+	}{
+		{"eth_getLogs", "archive-nodes", "", "archive", "archive-traffic"},
+		{"trace_block", "archive-nodes", "", "archive", "archive-traffic"},
+		{"debug_traceTransaction", "", "http://tracer.example.com", "tracer", "archive-traffic"},
+		{"trace_filter", "archive-nodes", "", "archive", "heavy"},
+	}
+	for _, tc := range testCases {
+		route := routes[tc.method]
+		if route.Pool != tc.pool || route.URL != tc.url || route.Name != tc.name || strings.Join(route.Tags, ",") != tc.tags {
+			t.Errorf("Unexpected route for %s: %+v", tc.method, route)
+		}
+		if route.Headers == nil || route.Headers.Set["X-Tier"] != "archive" {
+			t.Errorf("Expected %s to inherit the group headers, got %+v", tc.method, route.Headers)
+		}
+	}
+}
+
+// TestRouteGroupErrors tests that errors in group routes point at the group
+func TestRouteGroupErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		yaml     string
+		expected string
+	}{
+		{
+			"Invalid inherited url",
+			"default_url: http://default.example.com\nroute_groups:\n  - name: g\n    defaults:\n      url: ftp://a.example.com\n    methods: [eth_call]\n",
+			"line 5, column 12: route 0 (eth_call)",
+		},
+		{
+			"Invalid route url",
+			"default_url: http://default.example.com\nroute_groups:\n  - name: g\n    defaults: {pool: p}\n    routes:\n      - method: eth_call\n        url: ftp://a.example.com\n",
+			"line 7, column 14: route 0 (eth_call)",
+		},
+		{
+			"Method in defaults",
+			"default_url: http://default.example.com\nroute_groups:\n  - name: g\n    defaults: {method: eth_call, url: http://a.example.com}\n    methods: [eth_call]\n",
+			"route group g: defaults cannot set a method",
+		},
+		{
+			"Empty group",
+			"default_url: http://default.example.com\nroute_groups:\n  - name: g\n    defaults: {url: http://a.example.com}\n",
+			"line 3, column 5: route group g must list methods or routes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+
+			// Test
+			err := loadConfig(writeConfigFile(t, tc.yaml))
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Per-route log verbosity
+//
+// When one method misbehaves, its calls can be logged in detail while the rest of the
+// proxy stays quiet. An override matches calls by method (a name, or a prefix ending
+// in *) or by route name, and sets the level of the router messages about them; with
+// payloads, the request and response bodies are logged at debug level as well:
+//
+//	logging:
+//	  components:
+//	    router: warn
+//	  routes:
+//	    - method: eth_getLogs
+//	      level: debug
+//	      payloads: true
+//	    - route: archive
+//	      level: info
+//
+// The first matching override applies; other calls use the router level. Overrides can
+// be replaced at runtime by posting {"routes": [...]} to /admin/log-level, and removed
+// with {"routes": []}. Payloads are truncated to maxLoggedPayload bytes.
+
+// RouteLogOverride sets the log verbosity of matching calls.
+type RouteLogOverride struct {
+	Method   string `yaml:"method" json:"method,omitempty"`     // Method, or prefix ending in * (optional)
+	Route    string `yaml:"route" json:"route,omitempty"`       // Route name (optional)
+	Level    string `yaml:"level" json:"level"`                 // Level of the router messages about matching calls (default: debug)
+	Payloads bool   `yaml:"payloads" json:"payloads,omitempty"` // Log request and response bodies at debug level
+}
+
+// maxLoggedPayload is the size at which logged payloads are truncated.
+const maxLoggedPayload = 4096
+
+// routeLogRule is a parsed override.
+type routeLogRule struct {
+	RouteLogOverride
+	level logLevel
+}
+
+// routeLogRules are the overrides in effect, protected by logLevelsMu.
+var routeLogRules []routeLogRule
+
+// parseRouteLogOverrides validates overrides.
+func parseRouteLogOverrides(overrides []RouteLogOverride) ([]routeLogRule, error) {
+	rules := make([]routeLogRule, 0, len(overrides))
+	for i, o := range overrides {
+		if o.Method == "" && o.Route == "" {
+			return nil, fmt.Errorf("routes[%d]: method or route is required", i)
+		}
+		rule := routeLogRule{RouteLogOverride: o, level: levelDebug}
+		if o.Level != "" {
+			level, err := parseLogLevel(o.Level)
+			if err != nil {
+				return nil, fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			rule.level = level
+		}
+		rule.Level = rule.level.String()
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches reports whether an override applies to a call.
+func (o *RouteLogOverride) matches(route *Route, method string) bool {
+	if o.Route != "" && (route == nil || route.Name != o.Route) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(o.Method, "*"); ok {
+		return strings.HasPrefix(method, prefix)
+	}
+	return o.Method == "" || o.Method == method
+}
+
+// callLogLevel returns the level of the router messages about a call.
+//
+// Parameters:
+//   - route: The route serving the call, or nil
+//   - method: The method called
+//
+// Returns:
+//   - logLevel: The level of the matching override, or of the router component
+//   - bool: Whether the call's payloads are logged
+func callLogLevel(route *Route, method string) (logLevel, bool) {
+	logLevelsMu.RLock()
+	for _, rule := range routeLogRules {
+		if rule.matches(route, method) {
+			logLevelsMu.RUnlock()
+			return rule.level, rule.Payloads
+		}
+	}
+	logLevelsMu.RUnlock()
+	return componentLevel("router"), false
+}
+
+// logCall logs a router message about a call, at the verbosity of the call.
+//
+// Parameters:
+//   - route: The route serving the call, or nil
+//   - method: The method called
+//   - level: The level of the message
+//   - format: The message format, as for log.Printf
+//   - args: The message arguments
+func logCall(route *Route, method string, level logLevel, format string, args ...interface{}) {
+	if threshold, _ := callLogLevel(route, method); level < threshold {
+		return
+	}
+	if out := currentLogOutput.Load(); out != nil {
+		out.emit(level, "router", fmt.Sprintf(format, args...))
+		return
+	}
+	log.Output(2, fmt.Sprintf(format, args...))
+}
+
+// logPayload logs a request or response body of a call if its override captures
+// payloads.
+//
+// Parameters:
+//   - route: The route serving the call, or nil
+//   - method: The method called
+//   - kind: "Request" or "Response"
+//   - payload: The body
+func logPayload(route *Route, method, kind string, payload []byte) {
+	if !capturesPayloads(route, method) {
+		return
+	}
+	if len(payload) > maxLoggedPayload {
+		payload = append(payload[:maxLoggedPayload:maxLoggedPayload], "...(truncated)"...)
+	}
+	logCall(route, method, levelDebug, "%s payload of method '%s': %s", kind, method, payload)
+}
+
+// capturesPayloads reports whether a call's payloads are logged.
+func capturesPayloads(route *Route, method string) bool {
+	level, payloads := callLogLevel(route, method)
+	return payloads && level == levelDebug
+}
+
+// setRouteLogOverrides replaces the overrides at runtime.
+//
+// Returns:
+//   - error: An error if an override is invalid, in which case nothing changes
+func setRouteLogOverrides(overrides []RouteLogOverride) error {
+	rules, err := parseRouteLogOverrides(overrides)
+	if err != nil {
+		return err
+	}
+	logLevelsMu.Lock()
+	routeLogRules = rules
+	logLevelsMu.Unlock()
+	log.Printf("Route log overrides changed via admin API: %d override(s)", len(rules))
+	return nil
+}
+
+// routeLogOverrides returns the overrides in effect.
+func routeLogOverrides() []RouteLogOverride {
+	logLevelsMu.RLock()
+	defer logLevelsMu.RUnlock()
+	out := make([]RouteLogOverride, len(routeLogRules))
+	for i, rule := range routeLogRules {
+		out[i] = rule.RouteLogOverride
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRouteLogOverrides tests that one method is logged in detail while others stay quiet
+func TestRouteLogOverrides(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xlogs"}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	config = Config{DefaultURL: server.URL, DefaultName: "primary", Logging: &LoggingConfig{
+		Components: map[string]string{"router": "warn"},
+		Routes:     []RouteLogOverride{{Method: "eth_get*", Payloads: true}},
+	}}
+	buildMethodURLMap()
+	if err := setupLogLevels(); err != nil {
+		t.Fatalf("Failed to set up log levels: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		setupLogLevels()
+	}()
+	defer delete(adminHandlers, "/admin/log-level")
+
+	// Test
+	for _, method := range []string{"eth_getLogs", "eth_chainId"} {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`
+		handleProxy(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	}
+
+	// Verify
+	logged := out.String()
+	for msg, expected := range map[string]bool{
+		"Routing method 'eth_getLogs' to primary":                                              true,
+		"Proxying method 'eth_getLogs'":                                                        true,
+		`Request payload of method 'eth_getLogs': {"`:                                          true,
+		`Response payload of method 'eth_getLogs': {"jsonrpc":"2.0","id":1,"result":"0xlogs"}`: true,
+		"eth_chainId": false,
+	} {
+		if strings.Contains(logged, msg) != expected {
+			t.Errorf("Expected %q logged: %v, got:\n%s", msg, expected, logged)
+		}
+	}
+}
+
+// TestRouteLogOverrideMatching tests matching overrides by method and route name
+func TestRouteLogOverrideMatching(t *testing.T) {
+	archive := &Route{Method: "eth_call", Name: "archive"}
+	testCases := []struct {
+		name     string
+		override RouteLogOverride
+		route    *Route
+		method   string
+		expected bool
+	}{
+		{"Exact method", RouteLogOverride{Method: "eth_call"}, nil, "eth_call", true},
+		{"Other method", RouteLogOverride{Method: "eth_call"}, nil, "eth_callBundle", false},
+		{"Method prefix", RouteLogOverride{Method: "debug_*"}, nil, "debug_traceCall", true},
+		{"Route name", RouteLogOverride{Route: "archive"}, archive, "eth_call", true},
+		{"Default route", RouteLogOverride{Route: "archive"}, nil, "eth_call", false},
+		{"Route and method", RouteLogOverride{Route: "archive", Method: "eth_getLogs"}, archive, "eth_call", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			matched := tc.override.matches(tc.route, tc.method)
+
+			// Verify
+			if matched != tc.expected {
+				t.Errorf("Expected match %v, got %v", tc.expected, matched)
+			}
+		})
+	}
+}
+
+// TestAdminRouteLogOverrides tests replacing the overrides through the admin API
+func TestAdminRouteLogOverrides(t *testing.T) {
+	// Setup
+	config = Config{Admin: &AdminConfig{Enabled: true, Token: "s3cret"}}
+	defer func() {
+		config = Config{}
+		setupLogLevels()
+	}()
+	if err := setupLogLevels(); err != nil {
+		t.Fatalf("Failed to set up log levels: %v", err)
+	}
+	defer delete(adminHandlers, "/admin/log-level")
+	handler := adminHandler()
+	request := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/admin/log-level", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Test
+	invalid := request(`{"level": "warn", "routes": [{"level": "debug"}]}`)
+	changed := request(`{"routes": [{"method": "eth_getLogs", "payloads": true}]}`)
+
+	// Verify
+	if invalid.Code != http.StatusBadRequest || componentLevel("router") != levelInfo {
+		t.Errorf("Expected an invalid override to change nothing, got %d", invalid.Code)
+	}
+	if changed.Code != http.StatusOK || !strings.Contains(changed.Body.String(), `"routes":[{"method":"eth_getLogs","level":"debug","payloads":true}]`) {
+		t.Errorf("Expected the override in the status, got %d %s", changed.Code, changed.Body.String())
+	}
+	if !capturesPayloads(nil, "eth_getLogs") || capturesPayloads(nil, "eth_call") {
+		t.Errorf("Expected payloads captured for eth_getLogs only")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Upstream identifies the backend a JSON-RPC call is sent to.
+type Upstream struct {
+	Name  string // Human-readable name for logging
+	URL   string // Destination URL
+	Route *Route // The configured route that selected this upstream (nil if none)
+}
+
+// Router decides which upstream serves a JSON-RPC call.
+// Implementations must be safe for concurrent use.
+type Router interface {
+	Route(req *JSONRPCRequest) (Upstream, error)
+}
+
+// RouterFactory builds a Router from the loaded configuration.
+type RouterFactory func(cfg *Config) (Router, error)
+
+var (
+	routerFactoriesMu sync.RWMutex
+	routerFactories   = map[string]RouterFactory{
+		"method": func(*Config) (Router, error) { return methodRouter{}, nil },
+	}
+)
+
+// router is the active Router. It defaults to the method-based router.
+var router Router = methodRouter{}
+
+// RegisterRouter makes a Router implementation selectable with the `router` configuration option.
+// Registering the same name twice replaces the previous factory.
+//
+// Parameters:
+//   - name: The name referenced by the `router` configuration option
+//   - factory: A function building the Router from the configuration
+func RegisterRouter(name string, factory RouterFactory) {
+	if name == "" || factory == nil {
+		panic("jsonrpc-proxy: RegisterRouter called with empty name or nil factory")
+	}
+
+	routerFactoriesMu.Lock()
+	defer routerFactoriesMu.Unlock()
+	routerFactories[name] = factory
+}
+
+// setupRouter builds the router named in the configuration and makes it active.
+// An empty name selects the method-based router.
+//
+// Returns:
+//   - error: An error if the router is unknown or fails to build
+func setupRouter() error {
+	name := config.Router
+	if name == "" {
+		name = "method"
+	}
+
+	routerFactoriesMu.RLock()
+	factory, ok := routerFactories[name]
+	names := make([]string, 0, len(routerFactories))
+	for n := range routerFactories {
+		names = append(names, n)
+	}
+	routerFactoriesMu.RUnlock()
+
+	if !ok {
+		sort.Strings(names)
+		return fmt.Errorf("unknown router %q (available: %v)", name, names)
+	}
+
+	r, err := factory(&config)
+	if err != nil {
+		return fmt.Errorf("error building router %q: %w", name, err)
+	}
+	router = r
+	return nil
+}
+
+// methodRouter is the default Router. It matches conditional routes first, then the
+// method lookup map, and falls back to the default URL. Routes served by a pool get
+// a member chosen by the pool's strategy. Routes with fallback_to_default are served
+// by the default route while their upstream is unhealthy (see fallback.go).
+type methodRouter struct{}
+
+// Route implements Router.
+func (methodRouter) Route(req *JSONRPCRequest) (Upstream, error) {
+	targetURL, displayName, route := resolveRoute(req)
+	upstream, err := routeUpstream(req, route, targetURL, displayName)
+	if err != nil || route == nil || !route.FallbackToDefault {
+		return upstream, err
+	}
+
+	if !routeFallsBack(route, upstream.URL) {
+		return upstream, nil
+	}
+	defaultName := config.DefaultName
+	if defaultName == "" {
+		defaultName = "default"
+	}
+	routeFallbackCalls.inc(routeName(route))
+	logCall(route, req.Method, levelDebug, "Falling back to the default route for method '%s' instead of %s", req.Method, upstream.Name)
+	return routeUpstream(req, nil, config.DefaultURL, defaultName)
+}
+
+// routeUpstream selects the upstream of a route: a member of its pool, or of the pool
+// picked for a blue/green route (see bluegreen.go), or its URL.
+//
+// Parameters:
+//   - req: The call
+//   - route: The route, or nil for the default route
+//   - targetURL, displayName: The URL and name of the route's upstream, when it has no pool
+//
+// Returns:
+//   - Upstream: The upstream
+//   - error: An error if the pool cannot serve the call
+func routeUpstream(req *JSONRPCRequest, route *Route, targetURL, displayName string) (Upstream, error) {
+	pool := config.DefaultPool
+	if route != nil {
+		pool = route.Pool
+		if route.BlueGreen != nil {
+			pool = blueGreenPool(route)
+		}
+	}
+	if pool != "" {
+		member, err := pickPoolMember(pool, req)
+		if err != nil {
+			return Upstream{}, err
+		}
+		member.Route = route
+		logCall(route, req.Method, levelDebug, "Routing method '%s' to member %s of pool %s (route %s)", req.Method, member.Name, pool, routeName(route))
+		return member, nil
+	}
+	logCall(route, req.Method, levelDebug, "Routing method '%s' to %s (route %s)", req.Method, displayName, routeName(route))
+	return Upstream{Name: displayName, URL: targetURL, Route: route}, nil
+}
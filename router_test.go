@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fixedRouter sends every call to one upstream, or fails every call
+type fixedRouter struct {
+	upstream Upstream
+	err      error
+}
+
+func (r fixedRouter) Route(req *JSONRPCRequest) (Upstream, error) {
+	return r.upstream, r.err
+}
+
+// TestCustomRouter tests that a registered router selected via config handles routing
+func TestCustomRouter(t *testing.T) {
+	// Setup
+	server := mockHTTPServer(t, "method1", `{"jsonrpc":"2.0","result":"custom","id":1}`)
+	defer server.Close()
+
+	RegisterRouter("test-fixed", func(cfg *Config) (Router, error) {
+		return fixedRouter{upstream: Upstream{Name: "fixed", URL: server.URL}}, nil
+	})
+	config = Config{DefaultURL: "http://default-url.com", Router: "test-fixed"}
+	buildMethodURLMap()
+	if err := setupRouter(); err != nil {
+		t.Fatalf("Failed to set up router: %v", err)
+	}
+	defer func() { router = methodRouter{} }()
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"method1","params":[],"id":1}`)))
+	w := httptest.NewRecorder()
+
+	// Test
+	handleProxy(w, req)
+
+	// Verify
+	body, _ := io.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), "custom") {
+		t.Errorf("Expected response from custom router's upstream, got %s", string(body))
+	}
+}
+
+// TestRouterErrors tests unknown router names and routing failures
+func TestRouterErrors(t *testing.T) {
+	defer func() { router = methodRouter{} }()
+
+	// Unknown router
+	config = Config{DefaultURL: "http://default-url.com", Router: "does-not-exist"}
+	if err := setupRouter(); err == nil {
+		t.Error("Expected error for unknown router, got nil")
+	}
+
+	// Routing failure
+	router = fixedRouter{err: errors.New("no upstream available")}
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"method1","params":[],"id":1}`)))
+	w := httptest.NewRecorder()
+	handleProxy(w, req)
+
+	if w.Result().StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Configuration scaffolding
+//
+// `jsonrpc-proxy init` writes a commented example configuration to get started
+// quickly. With -chain, it is pre-filled for a chain preset (see chains.go): a public
+// endpoint as the default upstream, and a stub answering eth_chainId locally.
+//
+//	jsonrpc-proxy init -chain linea -output config.yaml
+
+// scaffoldTemplate is the example configuration written by init.
+var scaffoldTemplate = template.Must(template.New("config").Parse(`# JSON-RPC proxy configuration{{if .Title}} for {{.Title}}{{end}}
+# Generated by "jsonrpc-proxy init". See the README for every option.
+
+version: 2
+
+# Upstreams, in named pools. List several members to spread calls over providers.
+pools:
+  {{.Name}}:
+    upstreams:
+      - url: "{{.URL}}"
+      # - url: "https://another-provider.example.com"
+
+# Pool for methods without a specific route
+default_pool: "{{.Name}}"
+
+# Method-specific routes
+routes:
+{{- if .ChainID}}
+  # Answer eth_chainId locally: the chain ID never changes
+  - method: "eth_chainId"
+    stub:
+      result: "{{.ChainID}}"
+{{- else}}
+  # Answer eth_chainId locally: the chain ID never changes
+  # - method: "eth_chainId"
+  #   stub:
+  #     result: "0x1"
+{{- end}}
+
+  # Send log queries to an archive node, declared as another pool
+  # - method: "eth_getLogs"
+  #   pool: "archive"
+
+# Probe upstream health and head height
+probe:
+  interval: 10s
+
+# Answer proxy_* introspection methods
+meta_methods:
+  enabled: true
+
+# Bound concurrent requests and queue short bursts
+# concurrency:
+#   max_in_flight: 256
+#   queue_timeout: 5s
+`))
+
+// scaffoldConfig renders the example configuration.
+//
+// Parameters:
+//   - chain: The name of a chain preset, or "" for a generic configuration
+//
+// Returns:
+//   - []byte: The configuration
+//   - error: An error if the chain is unknown
+func scaffoldConfig(chain string) ([]byte, error) {
+	data := struct {
+		chainPreset
+		Name string
+		URL  string
+	}{chainPreset{}, "default", "https://mainnet.infura.io/v3/your-project-id"}
+	if chain != "" {
+		preset, ok := chainPresets[chain]
+		if !ok {
+			return nil, fmt.Errorf("unknown chain %q (known: %s)", chain, strings.Join(chainPresetNames(), ", "))
+		}
+		data.chainPreset = preset
+		data.Name = chain
+		data.URL = preset.URLs[0]
+	}
+
+	var buf bytes.Buffer
+	if err := scaffoldTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runInit implements the init subcommand.
+//
+// Parameters:
+//   - args: The arguments following "init"
+//   - stdout: Where the configuration is written with -output -
+//   - stderr: Where usage and progress messages are written
+//
+// Returns:
+//   - error: An error if the arguments are invalid or the file cannot be written
+func runInit(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	chain := fs.String("chain", "", "Pre-fill the configuration for a chain ("+strings.Join(chainPresetNames(), ", ")+")")
+	output := fs.String("output", "config.yaml", "File to write, or - for standard output")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if f := configFormat(*output); f != "yaml" {
+		return fmt.Errorf("init writes YAML, not %s: choose a .yaml output file", strings.ToUpper(f))
+	}
+
+	data, err := scaffoldConfig(*chain)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := writeNewFile(*output, data, *force); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Wrote %s. Start the proxy with: jsonrpc-proxy -config=%s\n", *output, *output)
+	return nil
+}
+
+// writeNewFile writes a file, refusing to replace an existing one unless force is set.
+func writeNewFile(name string, data []byte, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(name, flags, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScaffoldConfigLoads tests that every generated configuration is valid
+func TestScaffoldConfigLoads(t *testing.T) {
+	for _, chain := range append([]string{""}, chainPresetNames()...) {
+		t.Run("chain="+chain, func(t *testing.T) {
+			// Setup
+			data, err := scaffoldConfig(chain)
+			if err != nil {
+				t.Fatalf("Failed to scaffold: %v", err)
+			}
+			config = Config{}
+
+			// Test
+			err = loadConfig(writeConfigFile(t, string(data)))
+
+			// Verify
+			if err != nil {
+				t.Fatalf("Generated configuration is invalid: %v\n%s", err, data)
+			}
+			if chain == "" {
+				if len(config.Routes) != 0 {
+					t.Errorf("Expected no routes without a chain, got %+v", config.Routes)
+				}
+				return
+			}
+			preset := chainPresets[chain]
+			if pool := config.Pools[config.DefaultPool]; pool == nil || pool.Upstreams[0].URL != preset.URLs[0] {
+				t.Errorf("Expected a default pool with %s, got %+v", preset.URLs[0], config.Pools)
+			}
+			if len(config.Routes) != 1 || config.Routes[0].Stub == nil || config.Routes[0].Stub.Result != preset.ChainID {
+				t.Errorf("Expected an eth_chainId stub returning %s, got %+v", preset.ChainID, config.Routes)
+			}
+		})
+	}
+}
+
+// TestRunInit tests writing the configuration file
+func TestRunInit(t *testing.T) {
+	// Setup
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	var stdout, stderr bytes.Buffer
+
+	// Test
+	err := runInit([]string{"-chain", "polygon", "-output", path}, &stdout, &stderr)
+	again := runInit([]string{"-output", path}, &stdout, &stderr)
+	forced := runInit([]string{"-output", path, "-force"}, &stdout, &stderr)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if again == nil || !strings.Contains(again.Error(), "already exists") {
+		t.Errorf("Expected init to refuse overwriting, got %v", again)
+	}
+	if forced != nil {
+		t.Errorf("Expected -force to overwrite, got %v", forced)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "mainnet.infura.io") {
+		t.Errorf("Expected the generic configuration after -force, got:\n%s", data)
+	}
+}
+
+// TestRunInitErrors tests rejection of invalid init arguments
+func TestRunInitErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"Unknown chain", []string{"-chain", "solana", "-output", "-"}, `unknown chain "solana"`},
+		{"Non-YAML output", []string{"-output", "config.json"}, "init writes YAML"},
+		{"Extra arguments", []string{"-output", "-", "extra"}, "unexpected arguments"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := runInit(tc.args, &stdout, &stderr)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+			if stdout.Len() > 0 {
+				t.Errorf("Expected nothing written, got %s", stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones of schedules, on images without a zoneinfo database
+)
+
+// Route schedules
+//
+// Some traffic is only worth routing differently at certain times: analytics jobs
+// running at night can go to a cheaper provider while daytime traffic needs the fast
+// one. Routes with a schedule are only active during its time windows, or the minutes
+// matching its cron expressions, and are skipped by the router otherwise, so the call
+// falls through to the next matching route or the default:
+//
+//	routes:
+//	  - method: eth_getLogs
+//	    url: https://cheap.example.com
+//	    schedule:
+//	      windows: ["Mon-Fri 22:00-06:00", "Sat,Sun"]
+//	      cron: ["*/10 12 * * 1-5"]       # minute hour day-of-month month day-of-week
+//	      timezone: Europe/Berlin         # default: UTC
+//	  - method: eth_getLogs
+//	    url: https://fast.example.com
+//
+// A window lists days (Mon to Sun, as ranges or comma-separated), a time range, or
+// both; days alone cover the whole day, and a time range alone every day. A range ending
+// before it starts runs past midnight, into the next day. Cron expressions follow the
+// usual five fields, with *, ranges, lists, and steps, days of the week numbered from 0
+// (Sunday) to 7 (Sunday again), and a day matched by either of its two day fields when
+// both are restricted. The route is active when any window or cron expression matches.
+// Like routes with a when expression, scheduled routes are matched in configuration
+// order before the routes without one (see expr.go), and the schedule is evaluated
+// with every call.
+
+// RouteSchedule configures when a route is active.
+type RouteSchedule struct {
+	Windows  []string `yaml:"windows"`  // Time windows, such as "Mon-Fri 22:00-06:00"
+	Cron     []string `yaml:"cron"`     // Cron expressions matching the minutes the route is active
+	Timezone string   `yaml:"timezone"` // IANA time zone of the windows and expressions (default: UTC)
+}
+
+// schedule is a compiled route schedule.
+type schedule struct {
+	loc     *time.Location
+	windows []timeWindow
+	crons   []cronExpr
+}
+
+// timeWindow is a daily time range on some days of the week.
+type timeWindow struct {
+	days       [7]bool // By time.Weekday
+	start, end int     // Minutes since midnight; end before start runs into the next day
+}
+
+// cronExpr is a compiled cron expression.
+type cronExpr struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool // Whether the day fields are *
+}
+
+// weekdays maps day abbreviations to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compileSchedule checks and compiles a route schedule.
+//
+// Returns:
+//   - *schedule: The compiled schedule
+//   - error: An error if the time zone, a window, or a cron expression is invalid
+func compileSchedule(rs *RouteSchedule) (*schedule, error) {
+	if len(rs.Windows) == 0 && len(rs.Cron) == 0 {
+		return nil, fmt.Errorf("windows or cron is required")
+	}
+	s := &schedule{loc: time.UTC}
+	if rs.Timezone != "" {
+		loc, err := time.LoadLocation(rs.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		s.loc = loc
+	}
+	for _, src := range rs.Windows {
+		w, err := parseTimeWindow(src)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", src, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	for _, src := range rs.Cron {
+		c, err := parseCron(src)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", src, err)
+		}
+		s.crons = append(s.crons, c)
+	}
+	return s, nil
+}
+
+// active reports whether the schedule covers a time.
+func (s *schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	for _, w := range s.windows {
+		if w.covers(t) {
+			return true
+		}
+	}
+	for _, c := range s.crons {
+		if c.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether a window covers a time.
+func (w timeWindow) covers(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	return w.days[day] && minute >= w.start || w.days[(day+6)%7] && minute < w.end
+}
+
+// parseTimeWindow parses a window of days, a time range, or both.
+func parseTimeWindow(src string) (timeWindow, error) {
+	w := timeWindow{start: 0, end: 24 * 60}
+	fields := strings.Fields(src)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("expected days, a time range, or both")
+	}
+	days, times := "", ""
+	switch {
+	case len(fields) == 2:
+		days, times = fields[0], fields[1]
+	case strings.Contains(fields[0], ":"):
+		times = fields[0]
+	default:
+		days = fields[0]
+	}
+
+	if days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, part := range strings.Split(days, ",") {
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		last := first
+		if ok && isRange {
+			last, ok = weekdays[strings.ToLower(to)]
+		}
+		if !ok {
+			return w, fmt.Errorf("unknown day in %q (expected Mon to Sun)", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	if times != "" {
+		from, to, ok := strings.Cut(times, "-")
+		if !ok {
+			return w, fmt.Errorf("expected a time range such as 22:00-06:00")
+		}
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return w, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return w, err
+		}
+		if w.start == w.end {
+			return w, fmt.Errorf("the time range is empty")
+		}
+	}
+	return w, nil
+}
+
+// parseClock parses a time of day as HH:MM, 24:00 included, into minutes since midnight.
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// matches reports whether a time falls in a minute matched by the expression.
+func (c cronExpr) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// parseCron parses a five-field cron expression.
+func parseCron(src string) (cronExpr, error) {
+	fields := strings.Fields(src)
+	if len(fields) != 5 {
+		return cronExpr{}, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	var c cronExpr
+	var err error
+	for _, f := range []struct {
+		name     string
+		src      string
+		min, max int
+		set      *[]bool
+	}{
+		{"minute", fields[0], 0, 59, &c.minute},
+		{"hour", fields[1], 0, 23, &c.hour},
+		{"day-of-month", fields[2], 1, 31, &c.dom},
+		{"month", fields[3], 1, 12, &c.month},
+		{"day-of-week", fields[4], 0, 7, &c.dow},
+	} {
+		if *f.set, err = parseCronField(f.src, f.min, f.max); err != nil {
+			return c, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of *, values, and ranges, each with an
+// optional step.
+//
+// Returns:
+//   - []bool: Whether each value up to max is matched
+//   - error: An error if the field is malformed or out of range
+func parseCronField(src string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(src, ",") {
+		spec, stepSrc, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepSrc); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if spec != "*" {
+			from, to, isRange := strings.Cut(spec, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestScheduleWindows tests matching times against day and time windows
+func TestScheduleWindows(t *testing.T) {
+	// Setup
+	s, err := compileSchedule(&RouteSchedule{Windows: []string{"Mon-Fri 22:00-06:00", "Sat,Sun"}, Timezone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatalf("Failed to compile the schedule: %v", err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		// October 2026 starts on a Thursday, so the 5th is a Monday
+		return time.Date(2026, 10, day, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"Monday night", at(5, 23, 0), true},
+		{"Tuesday early morning", at(6, 5, 59), true},
+		{"Tuesday morning", at(6, 6, 0), false},
+		{"Monday early morning, before the first night", at(5, 3, 0), false},
+		{"Saturday early morning, after Friday night", at(10, 3, 0), true},
+		{"Saturday midday", at(10, 12, 0), true},
+		{"Friday afternoon", at(9, 15, 0), false},
+		{"Monday night in UTC", time.Date(2026, 10, 5, 21, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test
+			got := s.active(tt.t)
+
+			// Verify
+			if got != tt.want {
+				t.Errorf("Expected active %v at %v, got %v", tt.want, tt.t, got)
+			}
+		})
+	}
+}
+
+// TestScheduleCron tests matching times against cron expressions
+func TestScheduleCron(t *testing.T) {
+	tests := []struct {
+		cron string
+		t    time.Time
+		want bool
+	}{
+		{"*/15 9-17 * * 1-5", time.Date(2026, 10, 5, 9, 30, 0, 0, time.UTC), true},
+		{"*/15 9-17 * * 1-5", time.Date(2026, 10, 5, 9, 31, 0, 0, time.UTC), false},
+		{"*/15 9-17 * * 1-5", time.Date(2026, 10, 4, 9, 30, 0, 0, time.UTC), false},
+		{"0 0 1 * 7", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1 * 7", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), true},
+		{"* * * 1,12 *", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s at %v", tt.cron, tt.t), func(t *testing.T) {
+			// Setup
+			c, err := parseCron(tt.cron)
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+
+			// Test
+			got := c.matches(tt.t)
+
+			// Verify
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestScheduleInvalid tests rejecting malformed schedules
+func TestScheduleInvalid(t *testing.T) {
+	for _, rs := range []RouteSchedule{
+		{},
+		{Windows: []string{"Funday"}},
+		{Windows: []string{"Mon 25:00-26:00"}},
+		{Windows: []string{"10:00-10:00"}},
+		{Cron: []string{"* * * *"}},
+		{Cron: []string{"60 * * * *"}},
+		{Cron: []string{"*/0 * * * *"}},
+		{Windows: []string{"Mon"}, Timezone: "Mars/Olympus_Mons"},
+	} {
+		// Test
+		_, err := compileSchedule(&rs)
+
+		// Verify
+		if err == nil {
+			t.Errorf("Expected an error for %+v", rs)
+		}
+	}
+}
+
+// TestScheduledRoutes tests that the router skips routes outside their schedule
+func TestScheduledRoutes(t *testing.T) {
+	// Setup
+	otherMonth := int(time.Now().UTC().Month())%12 + 1
+	config = Config{
+		DefaultURL: "http://default",
+		Routes: []Route{
+			{Method: "eth_getLogs", URL: "http://off", Name: "off", Schedule: &RouteSchedule{Cron: []string{fmt.Sprintf("* * * %d *", otherMonth)}}},
+			{Method: "eth_getLogs", URL: "http://cheap", Name: "cheap", Schedule: &RouteSchedule{Windows: []string{"00:00-24:00"}}},
+			{Method: "eth_getLogs", URL: "http://fast", Name: "fast"},
+			{Method: "eth_call", URL: "http://night", Name: "night", Schedule: &RouteSchedule{Cron: []string{fmt.Sprintf("* * * %d *", otherMonth)}}},
+		},
+	}
+	buildMethodURLMap()
+	defer func() { config = Config{} }()
+
+	// Test
+	logs, _ := router.Route(&JSONRPCRequest{Method: "eth_getLogs"})
+	call, _ := router.Route(&JSONRPCRequest{Method: "eth_call"})
+
+	// Verify
+	if logs.URL != "http://cheap" {
+		t.Errorf("Expected the active scheduled route, got %s", logs.URL)
+	}
+	if call.URL != "http://default" {
+		t.Errorf("Expected the default route outside the schedule, got %s", call.URL)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strict configuration decoding
+//
+// The configuration is first parsed into a yaml.Node tree and checked against the
+// Config type before it is decoded, so that misspelled options such as defualt_url
+// are rejected instead of being silently ignored. Every unknown field is reported with
+// its line and column, and a suggestion when a known field has a similar name.
+//
+// The position of each value is kept by its path in the configuration (for example
+// routes[2].url), so validation errors found after decoding point at the offending
+// line as well. JSON and TOML configurations go through the same checks (see
+// formats.go); TOML values carry no position, so their errors name the path only.
+
+// configNodes maps configuration paths to their YAML nodes, for error positions.
+// It is nil when the configuration was not loaded from a file.
+var configNodes map[string]*yaml.Node
+
+// upstreamSchemes are the URL schemes accepted for upstreams.
+var upstreamSchemes = []string{"http", "https", "unix", "srv+http", "srv+https"}
+
+// decodeConfig strictly decodes a configuration document.
+//
+// Parameters:
+//   - root: The root node of the document (nil for an empty document)
+//   - out: The configuration to decode into
+//
+// Returns:
+//   - error: Every unknown field with its position, or the first decoding error
+func decodeConfig(root *yaml.Node, out *Config) error {
+	configNodes = make(map[string]*yaml.Node)
+	if root == nil {
+		return nil
+	}
+
+	if errs := checkNode(root, reflect.TypeOf(out).Elem(), ""); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return root.Decode(out)
+}
+
+// checkNode records the positions of a node and its children, and reports the
+// mapping keys that do not correspond to a field of the target type.
+//
+// Parameters:
+//   - n: The YAML node
+//   - t: The Go type the node decodes into
+//   - path: The path of the node in the configuration
+//
+// Returns:
+//   - []error: The unknown fields below the node
+func checkNode(n *yaml.Node, t reflect.Type, path string) []error {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if path != "" {
+		configNodes[path] = n
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var errs []error
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "<<" {
+				errs = append(errs, checkNode(value, t, path)...)
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, unknownFieldError(key, path, fields))
+				continue
+			}
+			errs = append(errs, checkNode(value, field, joinPath(path, key.Value))...)
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			errs = append(errs, checkNode(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value))...)
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for i, item := range n.Content {
+			errs = append(errs, checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+// yamlFields returns the fields of a struct type by their YAML key.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// unknownFieldError reports an unknown key, suggesting a known field with a similar name.
+func unknownFieldError(key *yaml.Node, path string, fields map[string]reflect.Type) error {
+	where := "configuration"
+	if path != "" {
+		where = path
+	}
+	msg := fmt.Sprintf("%sunknown field %s in %s", position(key), key.Value, where)
+
+	best, bestDistance := "", 3
+	for name := range fields {
+		if d := editDistance(key.Value, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best != "" {
+		msg += fmt.Sprintf(" (did you mean %s?)", best)
+	}
+	return errors.New(msg)
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// joinPath appends a key to a configuration path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// configErrorf formats a validation error, prefixed with the line and column of the
+// value at path when the configuration was loaded from a file and it has a position.
+//
+// Parameters:
+//   - path: The path of the offending value (for example routes[2].url)
+//   - format: The error message format, as in fmt.Errorf
+//   - args: The format arguments
+//
+// Returns:
+//   - error: The formatted error
+func configErrorf(path string, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if n, ok := configNodes[path]; ok {
+		return fmt.Errorf("%s%w", position(n), err)
+	}
+	return err
+}
+
+// position returns the "line L, column C: " prefix of a node, or an empty string
+// for nodes without a position (configurations converted from TOML).
+func position(n *yaml.Node) string {
+	if n.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("line %d, column %d: ", n.Line, n.Column)
+}
+
+// checkUpstreamURL checks the syntax and scheme of an upstream URL.
+//
+// Returns:
+//   - error: An error describing the problem with the URL
+func checkUpstreamURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", raw, err)
+	}
+	known := false
+	for _, scheme := range upstreamSchemes {
+		known = known || u.Scheme == scheme
+	}
+	if !known {
+		return fmt.Errorf("invalid url %q: unsupported scheme %q (expected one of %s)", raw, u.Scheme, strings.Join(upstreamSchemes, ", "))
+	}
+	if u.Scheme == "unix" {
+		if u.Path == "" {
+			return fmt.Errorf("invalid url %q: missing socket path", raw)
+		}
+		return nil
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q: missing host", raw)
+	}
+	return nil
+}
+
+// validateURLs checks every upstream URL in the configuration. It runs before SRV
+// addresses are expanded, so that errors point at the configured values.
+//
+// Returns:
+//   - error: The first invalid or missing URL, with its position
+func validateURLs() error {
+	if config.DefaultURL != "" {
+		if err := checkUpstreamURL(config.DefaultURL); err != nil {
+			return configErrorf("default_url", "default_url: %w", err)
+		}
+	}
+	for i, route := range config.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		switch {
+		case route.URL != "":
+			if err := checkUpstreamURL(route.URL); err != nil {
+				return configErrorf(path+".url", "route %d (%s): %w", i, route.Method, err)
+			}
+		case route.Pool == "" && route.Stub == nil && route.BlueGreen == nil:
+			return configErrorf(path, "route %d (%s): url, pool, or stub is required", i, route.Method)
+		}
+		if route.Mirror != nil && route.Mirror.URL != "" {
+			if err := checkUpstreamURL(route.Mirror.URL); err != nil {
+				return configErrorf(path+".mirror.url", "route %d (%s): mirror: %w", i, route.Method, err)
+			}
+		}
+	}
+	for name, pool := range config.Pools {
+		if pool == nil {
+			continue
+		}
+		for i, uc := range pool.Upstreams {
+			if uc.URL == "" {
+				continue
+			}
+			if err := checkUpstreamURL(uc.URL); err != nil {
+				return configErrorf(fmt.Sprintf("pools.%s.upstreams[%d].url", name, i), "pool %s: upstreams[%d]: %w", name, i, err)
+			}
+		}
+	}
+	if wr := config.WriteRouting; wr != nil {
+		for i, uc := range wr.Upstreams {
+			if uc.URL == "" {
+				continue
+			}
+			if err := checkUpstreamURL(uc.URL); err != nil {
+				return configErrorf(fmt.Sprintf("write_routing.upstreams[%d].url", i), "write_routing: upstreams[%d]: %w", i, err)
+			}
+		}
+	}
+	if pt := config.PrivateTx; pt != nil && pt.URL != "" {
+		if err := checkUpstreamURL(pt.URL); err != nil {
+			return configErrorf("private_tx.url", "private_tx: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a YAML configuration to a temporary file
+func writeConfigFile(t *testing.T, data string) string {
+	return writeConfigAs(t, "config.yaml", data)
+}
+
+// TestStrictConfig tests rejection of unknown fields and invalid values with their positions
+func TestStrictConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		yaml     string
+		expected []string
+	}{
+		{
+			"Misspelled top-level field",
+			"defualt_url: http://default.example.com\n",
+			[]string{"line 1, column 1: unknown field defualt_url in configuration (did you mean default_url?)"},
+		},
+		{
+			"Unknown fields in routes",
+			"default_url: http://default.example.com\nroutes:\n  - method: eth_call\n    ulr: http://a.example.com\n  - method: eth_getLogs\n    url: http://b.example.com\n    mirorr: {}\n",
+			[]string{
+				"line 4, column 5: unknown field ulr in routes[0] (did you mean url?)",
+				"line 7, column 5: unknown field mirorr in routes[1] (did you mean mirror?)",
+			},
+		},
+		{
+			"Unknown field in a pool member",
+			"default_pool: main\npools:\n  main:\n    upstreams:\n      - url: http://a.example.com\n        weight: 2\n",
+			[]string{"line 6, column 9: unknown field weight in pools.main.upstreams[0]"},
+		},
+		{
+			"Unsupported scheme",
+			"default_url: http://default.example.com\nroutes:\n  - method: eth_call\n    url: ftp://a.example.com\n",
+			[]string{"line 4, column 10: route 0 (eth_call)", `unsupported scheme "ftp"`},
+		},
+		{
+			"Missing host",
+			"default_url: http:///path\n",
+			[]string{"line 1, column 14: default_url", "missing host"},
+		},
+		{
+			"Invalid write upstream",
+			"default_url: http://default.example.com\nwrite_routing:\n  enabled: true\n  upstreams:\n    - url: htp://writes.example.com\n",
+			[]string{"line 5, column 12: write_routing: upstreams[0]", `unsupported scheme "htp"`},
+		},
+		{
+			"Invalid private relay",
+			"default_url: http://default.example.com\nprivate_tx:\n  url: https:///relay\n",
+			[]string{"line 3, column 8: private_tx", "missing host"},
+		},
+		{
+			"Route without upstream",
+			"default_url: http://default.example.com\nroutes:\n  - method: eth_call\n",
+			[]string{"line 3, column 5: route 0 (eth_call): url, pool, or stub is required"},
+		},
+		{
+			"Unknown pool",
+			"default_url: http://default.example.com\nroutes:\n  - method: eth_call\n    pool: archive\n",
+			[]string{"line 4, column 11: route 0 (eth_call): unknown pool archive"},
+		},
+		{
+			"Invalid when expression",
+			"default_url: http://default.example.com\nroutes:\n  - method: eth_call\n    url: http://a.example.com\n    when: \"params[0] ==\"\n",
+			[]string{"line 5, column 11: route 0 (eth_call): invalid when expression"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+			path := writeConfigFile(t, tc.yaml)
+
+			// Test
+			err := loadConfig(path)
+
+			// Verify
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			for _, s := range tc.expected {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("Expected error to contain %q, got %q", s, err)
+				}
+			}
+		})
+	}
+}
+
+// TestStrictConfigValid tests that valid configurations, including merge keys, still load
+func TestStrictConfigValid(t *testing.T) {
+	// Setup
+	config = Config{}
+	path := writeConfigFile(t, `
+default_url: unix:///var/run/geth.ipc
+routes:
+  - &archive
+    method: eth_getLogs
+    url: https://archive.example.com/key
+    name: archive
+  - <<: *archive
+    method: trace_block
+  - method: eth_chainId
+    stub:
+      result: "0x1"
+`)
+
+	// Test
+	err := loadConfig(path)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Expected config to load, got %v", err)
+	}
+	if len(config.Routes) != 3 || config.Routes[1].URL != "https://archive.example.com/key" || config.Routes[1].Method != "trace_block" {
+		t.Errorf("Unexpected routes: %+v", config.Routes)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTP server limits
+//
+// A server without timeouts keeps a connection, and its goroutine and buffers, for as
+// long as the client cares to trickle in its headers (slowloris), or to hold it idle.
+// The proxy listener has conservative defaults, which can be tuned:
+//
+//	server:
+//	  read_header_timeout: 10s   # longest wait for the request headers (default: 10s)
+//	  read_timeout: 30s          # longest wait for the whole request (default: none)
+//	  write_timeout: 2m          # longest time to write the response (default: none)
+//	  idle_timeout: 2m           # keep-alive connections idle longer are closed (default: 2m)
+//	  max_header_bytes: 65536    # largest request headers (default: 1 MiB)
+//	  max_connections: 10000     # connections served at once (default: unlimited)
+//
+// read_timeout and write_timeout run from the start of the request, so they bound
+// every response, including slow archive calls and streamed batches (see
+// batchstream.go); set them above the longest upstream timeout. Beyond
+// max_connections, new connections wait in the kernel's accept queue until a
+// connection closes. The limits apply to the proxy port; the admin, metrics, and gRPC
+// listeners keep their own.
+
+// ServerConfig configures the proxy's HTTP server.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // Longest wait for the request headers (default: 10s)
+	ReadTimeout       time.Duration `yaml:"read_timeout"`        // Longest wait for the whole request (default: none)
+	WriteTimeout      time.Duration `yaml:"write_timeout"`       // Longest time to write the response (default: none)
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Idle time after which keep-alive connections are closed (default: 2m)
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`    // Largest request headers in bytes (default: 1 MiB)
+	MaxConnections    int           `yaml:"max_connections"`     // Connections served at once (default: unlimited)
+}
+
+// Server defaults.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// newServer builds the proxy's HTTP server.
+//
+// Parameters:
+//   - handler: The handler serving requests
+//
+// Returns:
+//   - *http.Server: The server
+//   - error: An error if a limit is negative
+func newServer(handler http.Handler) (*http.Server, error) {
+	sc := config.Server
+	if sc == nil {
+		sc = &ServerConfig{}
+	}
+	if sc.ReadHeaderTimeout < 0 || sc.ReadTimeout < 0 || sc.WriteTimeout < 0 || sc.IdleTimeout < 0 {
+		return nil, fmt.Errorf("timeouts cannot be negative")
+	}
+	if sc.MaxHeaderBytes < 0 || sc.MaxConnections < 0 {
+		return nil, fmt.Errorf("max_header_bytes and max_connections cannot be negative")
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: sc.ReadHeaderTimeout,
+		ReadTimeout:       sc.ReadTimeout,
+		WriteTimeout:      sc.WriteTimeout,
+		IdleTimeout:       sc.IdleTimeout,
+		MaxHeaderBytes:    sc.MaxHeaderBytes,
+	}
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = defaultIdleTimeout
+	}
+	return server, nil
+}
+
+// limitConnections caps the connections accepted by a listener and not yet closed.
+// A zero limit leaves the listener as it is.
+func limitConnections(lis net.Listener, limit int) net.Listener {
+	if limit <= 0 {
+		return lis
+	}
+	log.Printf("Serving at most %d connections at once", limit)
+	return &limitedListener{Listener: lis, slots: make(chan struct{}, limit)}
+}
+
+// limitedListener accepts connections while slots are free.
+type limitedListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+// Accept implements net.Listener, waiting for a free slot first.
+func (l *limitedListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// limitedConn frees its slot when closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close implements net.Conn.
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestNewServer tests the server's default and configured limits
+func TestNewServer(t *testing.T) {
+	// Setup
+	defer func() { config = Config{} }()
+
+	// Test
+	config = Config{}
+	defaults, err := newServer(nil)
+	if err != nil {
+		t.Fatalf("Failed to build the default server: %v", err)
+	}
+	config = Config{Server: &ServerConfig{ReadHeaderTimeout: time.Second, WriteTimeout: time.Minute, MaxHeaderBytes: 4096}}
+	configured, err := newServer(nil)
+	if err != nil {
+		t.Fatalf("Failed to build the configured server: %v", err)
+	}
+	config = Config{Server: &ServerConfig{ReadTimeout: -time.Second}}
+	_, invalid := newServer(nil)
+
+	// Verify
+	if defaults.ReadHeaderTimeout != defaultReadHeaderTimeout || defaults.IdleTimeout != defaultIdleTimeout {
+		t.Errorf("Expected default header and idle timeouts, got %v and %v", defaults.ReadHeaderTimeout, defaults.IdleTimeout)
+	}
+	if configured.ReadHeaderTimeout != time.Second || configured.WriteTimeout != time.Minute || configured.MaxHeaderBytes != 4096 {
+		t.Errorf("Expected the configured limits, got %+v", configured)
+	}
+	if invalid == nil {
+		t.Errorf("Expected an error for a negative timeout")
+	}
+}
+
+// TestLimitConnections tests holding connections back while the limit is reached
+func TestLimitConnections(t *testing.T) {
+	// Setup
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	limited := limitConnections(lis, 1)
+	defer limited.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+	}
+
+	// Test
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatalf("Expected the second connection to wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	first.Close()
+	first.Close()
+
+	// Verify
+	select {
+	case second := <-accepted:
+		second.Close()
+	case <-time.After(time.Second):
+		t.Errorf("Expected the second connection once the first was closed")
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWS SigV4 signing
+//
+// Amazon Managed Blockchain and other AWS-hosted endpoints authenticate requests with
+// Signature Version 4. Signers are declared by name and registered as transports, so an
+// upstream selects one with its `transport` option:
+//
+//	aws_signers:
+//	  amb:
+//	    region: us-east-1
+//	    service: managedblockchain   # default
+//	    profile: blockchain          # optional
+//	    transport: corp-proxy        # optional transport used after signing
+//	routes:
+//	  - method: eth_getBalance
+//	    url: https://mainnet.ethereum.managedblockchain.us-east-1.amazonaws.com/
+//	    transport: amb
+//
+// Credentials are looked up like the AWS SDKs do: the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables, then the profile
+// of the shared credentials file (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials;
+// profile, then AWS_PROFILE, then "default"), then the role of the EC2 instance through
+// IMDSv2. Setting profile skips the environment. Instance credentials are refreshed
+// before they expire.
+
+// AWSSignerConfig defines a SigV4 signer.
+type AWSSignerConfig struct {
+	Region    string `yaml:"region"`    // AWS region of the endpoint, e.g. "us-east-1"
+	Service   string `yaml:"service"`   // Signing name of the service (default: managedblockchain)
+	Profile   string `yaml:"profile"`   // Profile of the shared credentials file (optional)
+	Transport string `yaml:"transport"` // Transport sending the signed requests (default: the default transport)
+}
+
+// imdsEndpoint is the EC2 instance metadata service, overridden by
+// AWS_EC2_METADATA_SERVICE_ENDPOINT.
+var imdsEndpoint = "http://169.254.169.254"
+
+// credentialRefreshMargin is how long before their expiry credentials are refreshed.
+const credentialRefreshMargin = 5 * time.Minute
+
+// awsCredentials are the credentials requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // Zero for credentials that do not expire
+	Source          string    // Where the credentials came from, for logging
+}
+
+// setupAWSSigners registers the SigV4 signers as transports.
+//
+// Returns:
+//   - error: An error if a signer is misconfigured or has no credentials
+func setupAWSSigners() error {
+	for _, name := range sortedKeys(config.AWSSigners) {
+		sc := config.AWSSigners[name]
+		if sc == nil || sc.Region == "" {
+			return fmt.Errorf("aws_signers.%s: region is required", name)
+		}
+		service := sc.Service
+		if service == "" {
+			service = "managedblockchain"
+		}
+		next, err := lookupTransport(sc.Transport)
+		if err != nil {
+			return fmt.Errorf("aws_signers.%s: %w", name, err)
+		}
+		provider := &awsCredentialProvider{profile: sc.Profile}
+		creds, err := provider.get(context.Background())
+		if err != nil {
+			return fmt.Errorf("aws_signers.%s: %w", name, err)
+		}
+		RegisterTransport(name, &sigv4Transport{region: sc.Region, service: service, credentials: provider, next: next})
+		log.Printf("Registered AWS signer %s for %s in %s (credentials from %s)", name, service, sc.Region, creds.Source)
+	}
+	return nil
+}
+
+// sigv4Transport signs each request with AWS Signature Version 4.
+type sigv4Transport struct {
+	region      string
+	service     string
+	credentials *awsCredentialProvider
+	next        http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *sigv4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	creds, err := t.credentials.get(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("aws credentials: %w", err)
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	signV4(out, body, creds, t.region, t.service, time.Now())
+	return t.next.RoundTrip(out)
+}
+
+// signV4 adds the SigV4 headers to a request.
+//
+// Parameters:
+//   - req: The request to sign
+//   - body: The request body
+//   - creds: The credentials to sign with
+//   - region: The AWS region
+//   - service: The signing name of the service
+//   - now: The signing time
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// Sign the host and the headers that describe the request
+	headers := map[string]string{"host": host}
+	for _, name := range []string{"Content-Type", "X-Amz-Date", "X-Amz-Security-Token"} {
+		if v := req.Header.Get(name); v != "" {
+			headers[strings.ToLower(name)] = strings.TrimSpace(v)
+		}
+	}
+	names := sortedKeys(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(path, false),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string of a request in SigV4 canonical form.
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := make([]string, 0, len(query))
+	for _, key := range sortedKeys(query) {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and slashes if
+// encodeSlash is false.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hmacSHA256 computes an HMAC-SHA256.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsCredentialProvider finds credentials and caches them until they are about to expire.
+type awsCredentialProvider struct {
+	profile string // Configured profile, which skips the environment
+
+	mu     sync.Mutex
+	cached *awsCredentials
+}
+
+// get returns the current credentials.
+func (p *awsCredentialProvider) get(ctx context.Context) (awsCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.cached; c != nil && (c.Expires.IsZero() || time.Until(c.Expires) > credentialRefreshMargin) {
+		return *c, nil
+	}
+	creds, err := p.resolve(ctx)
+	if err != nil {
+		if c := p.cached; c != nil && time.Now().Before(c.Expires) {
+			// Keep signing with credentials that are still valid
+			logWarn("router", "Failed to refresh AWS credentials: %v", err)
+			return *c, nil
+		}
+		return awsCredentials{}, err
+	}
+	p.cached = &creds
+	return creds, nil
+}
+
+// resolve looks up credentials in the environment, the shared credentials file, and
+// the instance metadata service, in that order.
+func (p *awsCredentialProvider) resolve(ctx context.Context) (awsCredentials, error) {
+	if p.profile == "" {
+		id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if id != "" && secret != "" {
+			return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+		}
+	}
+
+	profile := p.profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	creds, found, err := profileCredentials(profile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if found {
+		return creds, nil
+	}
+	if p.profile != "" {
+		return awsCredentials{}, fmt.Errorf("profile %q not found in the shared credentials file", p.profile)
+	}
+	if creds, err = instanceCredentials(ctx); err != nil {
+		return awsCredentials{}, fmt.Errorf("no credentials in the environment or shared credentials file, and instance metadata failed: %w", err)
+	}
+	return creds, nil
+}
+
+// profileCredentials reads a profile of the shared credentials file.
+//
+// Returns:
+//   - awsCredentials: The profile's credentials
+//   - bool: Whether the file has the profile
+//   - error: An error if the file exists but cannot be read, or the profile is incomplete
+func profileCredentials(profile string) (awsCredentials, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return awsCredentials{}, false, nil
+	}
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	found, section := false, ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+		case section == profile:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, false, err
+	}
+	if !found {
+		return awsCredentials{}, false, nil
+	}
+	creds := awsCredentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+		Source:          "profile " + profile,
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, true, fmt.Errorf("profile %q in %s has no aws_access_key_id or aws_secret_access_key", profile, path)
+	}
+	return creds, true, nil
+}
+
+// instanceCredentials fetches the credentials of the EC2 instance's role through IMDSv2.
+func instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := imdsEndpoint
+	if e := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"); e != "" {
+		endpoint = e
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	client := &http.Client{}
+
+	// IMDSv2 requires a session token
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := imdsGet(client, req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	get := func(path string) ([]byte, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return imdsGet(client, req)
+	}
+
+	role, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	roleName, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+	if roleName == "" {
+		return awsCredentials{}, fmt.Errorf("instance has no IAM role")
+	}
+	data, err := get("/latest/meta-data/iam/security-credentials/" + roleName)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var doc struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid instance credentials: %w", err)
+	}
+	return awsCredentials{
+		AccessKeyID:     doc.AccessKeyId,
+		SecretAccessKey: doc.SecretAccessKey,
+		SessionToken:    doc.Token,
+		Expires:         doc.Expiration,
+		Source:          "instance role " + roleName,
+	}, nil
+}
+
+// imdsGet sends a request to the instance metadata service and returns the body.
+func imdsGet(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata %s: HTTP %d", req.URL.Path, resp.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4 tests signing against the get-vanilla case of the AWS SigV4 test suite
+func TestSignV4(t *testing.T) {
+	// Setup
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	// Test
+	signV4(req, nil, creds, "us-east-1", "service", now)
+
+	// Verify
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Expected the signing time, got %q", got)
+	}
+}
+
+// TestSigV4Transport tests forwarding signed requests with instance role credentials
+func TestSigV4Transport(t *testing.T) {
+	// Setup: an instance metadata service and an upstream checking signatures
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("proxy-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/proxy-role":
+			w.Write([]byte(`{"AccessKeyId":"ASIAROLE","SecretAccessKey":"secret","Token":"session","Expiration":"` +
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer imds.Close()
+	var auth, token, body string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, token = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Security-Token")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", imds.URL)
+	config = Config{
+		DefaultURL:       upstream.URL,
+		DefaultTransport: "amb",
+		AWSSigners:       map[string]*AWSSignerConfig{"amb": {Region: "us-east-1"}},
+	}
+	defer func() {
+		config = Config{}
+		transportsMu.Lock()
+		delete(transports, "amb")
+		transportsMu.Unlock()
+	}()
+	if err := setupAWSSigners(); err != nil {
+		t.Fatalf("Failed to set up signers: %v", err)
+	}
+	if err := buildTransportMap(); err != nil {
+		t.Fatalf("Invalid transports: %v", err)
+	}
+
+	// Test
+	resp, err := forwardRequest(context.Background(), config.DefaultURL, []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Verify
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ASIAROLE/") || !strings.Contains(auth, "/us-east-1/managedblockchain/aws4_request") {
+		t.Errorf("Expected a SigV4 signature with the role's key, got %q", auth)
+	}
+	if !strings.Contains(auth, "x-amz-security-token") || token != "session" {
+		t.Errorf("Expected the session token to be sent and signed, got %q and %q", token, auth)
+	}
+	if body != `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}` {
+		t.Errorf("Expected the body to be forwarded, got %q", body)
+	}
+}
+
+// TestAWSCredentialChain tests the order in which credentials are looked up
+func TestAWSCredentialChain(t *testing.T) {
+	// Setup
+	path := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(path, []byte("[default]\naws_access_key_id = AKIADEFAULT\naws_secret_access_key = s1\n\n[blockchain]\naws_access_key_id = AKIABLOCKCHAIN\naws_secret_access_key = s2\naws_session_token = t2\n"), 0o600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s0")
+
+	testCases := []struct {
+		name     string
+		profile  string
+		unsetEnv bool
+		expected string
+	}{
+		{"Environment first", "", false, "AKIAENV"},
+		{"Configured profile skips the environment", "blockchain", false, "AKIABLOCKCHAIN"},
+		{"Default profile", "", true, "AKIADEFAULT"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.unsetEnv {
+				t.Setenv("AWS_ACCESS_KEY_ID", "")
+			}
+
+			// Test
+			creds, err := (&awsCredentialProvider{profile: tc.profile}).get(context.Background())
+
+			// Verify
+			if err != nil {
+				t.Fatalf("Failed to get credentials: %v", err)
+			}
+			if creds.AccessKeyID != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, creds.AccessKeyID)
+			}
+		})
+	}
+
+	// Verify an unknown configured profile is an error
+	if _, err := (&awsCredentialProvider{profile: "missing"}).get(context.Background()); err == nil || !strings.Contains(err.Error(), `profile "missing" not found`) {
+		t.Errorf("Expected an error for an unknown profile, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+)
+
+// Slow start
+//
+// Pools skip members that the probes report as unhealthy. When such a member recovers,
+// it is often still warming up (filling caches, catching up with peers), and sending it
+// its full share of traffic at once can make it fail again. With slow start, a
+// recovered member's share grows linearly from nothing to full over a window:
+//
+//	probe:
+//	  interval: 5s
+//	  slow_start: 1m
+//
+// A round_robin pool passes over a warming member for a growing fraction of calls,
+// and a client_hash pool moves a growing fraction of the member's clients back to it,
+// each client staying where it is until its turn comes. A member failing a probe while
+// warming up leaves the pool again and restarts its slow start when it recovers. Members
+// start at full weight when the proxy starts.
+
+// upstreamWeight returns the share of its traffic an upstream receives, from 0 while it
+// has just recovered to 1 once its slow start is over.
+func upstreamWeight(url string) float64 {
+	if config.Probe == nil || config.Probe.SlowStart <= 0 {
+		return 1
+	}
+	statusMu.RLock()
+	status, ok := upstreamStatuses[url]
+	var recovered time.Time
+	if ok {
+		recovered = status.RecoveredAt
+	}
+	statusMu.RUnlock()
+	if recovered.IsZero() {
+		return 1
+	}
+	if elapsed := time.Since(recovered); elapsed < config.Probe.SlowStart {
+		return float64(elapsed) / float64(config.Probe.SlowStart)
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSlowStart tests that a recovered member regains its share of traffic gradually
+func TestSlowStart(t *testing.T) {
+	// Setup
+	config = Config{Probe: &ProbeConfig{Interval: time.Second, SlowStart: time.Minute}}
+	defer func() {
+		config = Config{}
+		upstreamStatuses = nil
+	}()
+	now := time.Now()
+	upstreamStatuses = map[string]*upstreamStatus{
+		"http://a": {URL: "http://a", Healthy: true, LastProbe: now},
+		"http://b": {URL: "http://b", Healthy: true, LastProbe: now, RecoveredAt: now.Add(-15 * time.Second)},
+	}
+	members := []UpstreamConfig{{URL: "http://a"}, {URL: "http://b"}}
+	var next atomic.Uint64
+
+	// Test
+	picked := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		picked[roundRobinMember(members, &next).URL]++
+	}
+	sticky := 0
+	for i := 0; i < 1000; i++ {
+		if stickyMember(members, fmt.Sprintf("client-%d", i)).URL == "http://b" {
+			sticky++
+		}
+	}
+	upstreamStatuses["http://b"].RecoveredAt = now.Add(-2 * time.Minute)
+
+	// Verify
+	// A quarter of the way through its slow start, b takes a quarter of its usual half
+	if share := float64(picked["http://b"]) / 4000; share < 0.08 || share > 0.17 {
+		t.Errorf("Expected b to get about 12.5%% of the calls, got %.1f%%", share*100)
+	}
+	if share := float64(sticky) / 1000; share < 0.06 || share > 0.2 {
+		t.Errorf("Expected b to get about 12.5%% of the clients, got %.1f%%", share*100)
+	}
+	if w := upstreamWeight("http://b"); w != 1 {
+		t.Errorf("Expected full weight after the slow start, got %v", w)
+	}
+	if w := upstreamWeight("http://a"); w != 1 {
+		t.Errorf("Expected full weight for a member that never failed, got %v", w)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS SRV discovery
+//
+// An upstream address of the form srv+http://_rpc._tcp.nodes.internal/path names a set
+// of upstreams through DNS SRV records instead of a single host. The records are
+// resolved at startup and then periodically, and each target becomes an upstream at
+// http://<target>:<port>/path. A default_url or route url with such an address becomes
+// a pool of the resolved targets (named after the address), and pool members may use
+// it too, adding every target to the pool. Calls are spread over the targets in turn,
+// skipping those the probes report as unhealthy. Only the targets with the lowest SRV
+// priority value are used, and weights are ignored. If a lookup fails, the previous
+// targets are kept.
+
+// srvLookup resolves SRV records; tests replace it.
+var srvLookup = net.DefaultResolver.LookupSRV
+
+// isSRVURL reports whether an upstream address uses SRV discovery.
+func isSRVURL(u string) bool {
+	return strings.HasPrefix(u, "srv+http://") || strings.HasPrefix(u, "srv+https://")
+}
+
+// expandSRVURLs turns default and route SRV addresses into pools, and moves SRV pool
+// members out of the pools' listed members.
+//
+// Returns:
+//   - error: An error if an SRV address is malformed
+func expandSRVURLs() error {
+	addPool := func(address string) error {
+		if _, err := parseSRVURL(address); err != nil {
+			return err
+		}
+		if config.Pools == nil {
+			config.Pools = make(map[string]*PoolConfig)
+		}
+		if config.Pools[address] == nil {
+			config.Pools[address] = &PoolConfig{srv: []string{address}}
+		}
+		return nil
+	}
+
+	if isSRVURL(config.DefaultURL) {
+		if err := addPool(config.DefaultURL); err != nil {
+			return fmt.Errorf("default_url: %w", err)
+		}
+		config.DefaultPool = config.DefaultURL
+		config.DefaultURL = ""
+	}
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if !isSRVURL(route.URL) {
+			continue
+		}
+		if err := addPool(route.URL); err != nil {
+			return fmt.Errorf("route %d (%s): %w", i, route.Method, err)
+		}
+		route.Pool = route.URL
+		route.URL = ""
+	}
+	for name, pool := range config.Pools {
+		if pool == nil {
+			continue
+		}
+		var listed []UpstreamConfig
+		for _, uc := range pool.Upstreams {
+			if !isSRVURL(uc.URL) {
+				listed = append(listed, uc)
+				continue
+			}
+			if _, err := parseSRVURL(uc.URL); err != nil {
+				return fmt.Errorf("pool %s: %w", name, err)
+			}
+			pool.srv = append(pool.srv, uc.URL)
+		}
+		pool.Upstreams = listed
+	}
+	return nil
+}
+
+// srvAddress is a parsed SRV upstream address.
+type srvAddress struct {
+	scheme string // Scheme of the resolved upstreams
+	name   string // SRV record name
+	path   string // Path of the resolved upstreams
+}
+
+// parseSRVURL parses an SRV upstream address.
+func parseSRVURL(address string) (srvAddress, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return srvAddress{}, fmt.Errorf("invalid SRV address %q: %w", address, err)
+	}
+	if u.Host == "" || u.Port() != "" {
+		return srvAddress{}, fmt.Errorf("invalid SRV address %q: expected srv+http://<record name>[/path]", address)
+	}
+	return srvAddress{scheme: strings.TrimPrefix(u.Scheme, "srv+"), name: u.Host, path: u.RequestURI()}, nil
+}
+
+// startSRVDiscovery resolves the SRV addresses of every pool, then keeps refreshing
+// them in the background until ctx is done.
+//
+// Parameters:
+//   - ctx: The context that stops refreshing when cancelled
+func startSRVDiscovery(ctx context.Context) {
+	interval := config.SRVRefreshInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	for name, pool := range config.Pools {
+		if pool == nil || len(pool.srv) == 0 {
+			continue
+		}
+		for _, address := range pool.srv {
+			resolveSRV(ctx, name, pool, address)
+			go func(name string, pool *PoolConfig, address string) {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						resolveSRV(ctx, name, pool, address)
+					}
+				}
+			}(name, pool, address)
+		}
+	}
+}
+
+// resolveSRV looks up an SRV address and updates the pool's members from it.
+func resolveSRV(ctx context.Context, name string, pool *PoolConfig, address string) {
+	addr, _ := parseSRVURL(address)
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, records, err := srvLookup(lookupCtx, "", "", addr.name)
+	if err != nil || len(records) == 0 {
+		logWarn("discovery", "SRV lookup for %s failed, keeping current members: %v", addr.name, err)
+		return
+	}
+
+	// Use the targets of the lowest priority value
+	lowest := records[0].Priority
+	for _, r := range records {
+		lowest = min(lowest, r.Priority)
+	}
+	var members []UpstreamConfig
+	for _, r := range records {
+		if r.Priority != lowest {
+			continue
+		}
+		host := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		members = append(members, UpstreamConfig{URL: addr.scheme + "://" + host + addr.path, Name: host})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].URL < members[j].URL })
+
+	if pool.setSourceMembers("srv:"+address, members) {
+		logInfo("discovery", "Pool %s now has %d members from SRV record %s", name, len(members), addr.name)
+	}
+}
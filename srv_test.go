@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// TestSRVDiscovery tests resolving SRV upstream addresses into pool members
+func TestSRVDiscovery(t *testing.T) {
+	// Setup
+	records := []*net.SRV{
+		{Target: "node-2.nodes.internal.", Port: 8545, Priority: 10},
+		{Target: "node-1.nodes.internal.", Port: 8545, Priority: 10},
+		{Target: "backup.nodes.internal.", Port: 8545, Priority: 20},
+	}
+	var lookupErr error
+	srvLookup = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_rpc._tcp.nodes.internal" {
+			t.Errorf("Unexpected SRV lookup for %s", name)
+		}
+		return "", records, lookupErr
+	}
+	defer func() {
+		srvLookup = net.DefaultResolver.LookupSRV
+		config = Config{}
+	}()
+	config = Config{
+		DefaultURL: "srv+http://_rpc._tcp.nodes.internal/rpc",
+		Routes:     []Route{{Method: "eth_chainId", URL: "https://chain.example.com"}},
+	}
+	if err := expandSRVURLs(); err != nil {
+		t.Fatalf("Invalid SRV address: %v", err)
+	}
+	if err := validatePools(); err != nil {
+		t.Fatalf("Invalid pools: %v", err)
+	}
+	buildMethodURLMap()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Test
+	startSRVDiscovery(ctx)
+
+	// Verify the lowest-priority targets serve the default route
+	names := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		upstream, err := router.Route(&JSONRPCRequest{Method: "eth_blockNumber"})
+		if err != nil {
+			t.Fatalf("Routing failed: %v", err)
+		}
+		names[upstream.URL] = true
+	}
+	if len(names) != 2 || !names["http://node-1.nodes.internal:8545/rpc"] || !names["http://node-2.nodes.internal:8545/rpc"] {
+		t.Errorf("Expected calls spread over the two priority-10 targets, got %v", names)
+	}
+
+	// Verify a failed lookup keeps the current members
+	lookupErr = errors.New("no such host")
+	pool := config.Pools[config.DefaultPool]
+	resolveSRV(ctx, config.DefaultPool, pool, config.DefaultPool)
+	if len(pool.members()) != 2 {
+		t.Errorf("Expected members to be kept, got %+v", pool.members())
+	}
+
+	// Verify malformed addresses are rejected
+	config = Config{DefaultURL: "srv+http://_rpc._tcp.nodes.internal:8545"}
+	if err := expandSRVURLs(); err == nil {
+		t.Errorf("Expected an error for an SRV address with a port")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Serving stale results
+//
+// When the upstream of a read fails, an outdated answer is often better than none: a
+// wallet showing a balance from a minute ago beats one showing an error. The cache can
+// serve the last result it holds for a call when the upstream cannot be reached, times
+// out, or answers with an HTTP 5xx error, after any retries:
+//
+//	cache:
+//	  methods: [eth_getBalance, eth_call, eth_getBlockByNumber]
+//	  serve_stale:
+//	    max_age: 1h                   # oldest result served (default: 1h)
+//	    methods: [eth_getBalance]     # methods served stale (default: every cached method)
+//
+// A stale response is a plain JSON-RPC response, marked with an X-Cache:
+// STALE-IF-ERROR header, an Age header giving its age in seconds, and an
+// X-Stale-Block header giving the upstream head it was stored at, if known:
+//
+//	X-Cache: STALE-IF-ERROR
+//	Age: 42
+//	X-Stale-Block: 19234567
+//
+// Only single calls of cached methods are served stale, never writes, and not for
+// clients bypassing the cache. Entries are kept for max_age rather than ttl so that
+// they are still there when needed. Stale responses are counted with the
+// stale_if_error result in jsonrpc_proxy_cache_requests_total, and with the stale
+// outcome in the call metrics.
+
+// ServeStaleConfig configures serving stale results when upstreams fail.
+type ServeStaleConfig struct {
+	MaxAge  time.Duration `yaml:"max_age"` // Oldest result served (default: 1h)
+	Methods []string      `yaml:"methods"` // Methods served stale (default: every cached method)
+}
+
+// defaultStaleMaxAge is the oldest result served stale by default.
+const defaultStaleMaxAge = time.Hour
+
+// cacheStaleIfError is the X-Cache status of stale results served for a failed upstream.
+const cacheStaleIfError = "STALE-IF-ERROR"
+
+// staleBlockHeader carries the upstream head a stale result was stored at.
+const staleBlockHeader = "X-Stale-Block"
+
+// staleMaxAge returns the oldest result served stale, or 0 if stale results are not
+// served.
+func staleMaxAge() time.Duration {
+	if config.Cache == nil || config.Cache.ServeStale == nil {
+		return 0
+	}
+	if age := config.Cache.ServeStale.MaxAge; age > 0 {
+		return age
+	}
+	return defaultStaleMaxAge
+}
+
+// retention returns how long entries are kept: their ttl, or longer to serve them stale.
+func (rc *resultCache) retention() time.Duration {
+	if age := staleMaxAge(); age > rc.ttl {
+		return age
+	}
+	return rc.ttl
+}
+
+// serveStale answers a call whose upstream failed with its last cached result.
+//
+// Parameters:
+//   - w: The HTTP response writer
+//   - r: The incoming HTTP request
+//   - url: The upstream that failed
+//   - req: The call
+//
+// Returns:
+//   - bool: Whether a stale result was served
+func (rc *resultCache) serveStale(w http.ResponseWriter, r *http.Request, url string, req *JSONRPCRequest) bool {
+	maxAge := staleMaxAge()
+	if maxAge == 0 || cacheBypassed(r) {
+		return false
+	}
+	if methods := config.Cache.ServeStale.Methods; len(methods) > 0 {
+		listed := false
+		for _, method := range methods {
+			listed = listed || method == req.Method
+		}
+		if !listed {
+			return false
+		}
+	}
+	key, ok := rc.key(url, req.Method, req.Params)
+	if !ok {
+		return false
+	}
+	var entry *cacheEntry
+	if rc.shared != nil {
+		entry, ok = rc.shared.get(key)
+	} else {
+		rc.mu.Lock()
+		entry, ok = rc.entries[key]
+		rc.mu.Unlock()
+	}
+	if !ok {
+		return false
+	}
+	age := time.Since(entry.stored)
+	if age > maxAge && !entry.immutable {
+		return false
+	}
+	result, err := entry.value()
+	if err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+	if err != nil {
+		return false
+	}
+	cacheRequestsTotal.inc("stale_if_error")
+	w.Header().Set("Content-Type", "application/json")
+	noteRouteCache(r.Context(), url, cacheStaleIfError)
+	w.Header().Set("X-Cache", cacheStaleIfError)
+	w.Header().Set("Age", strconv.FormatInt(int64(age.Seconds()), 10))
+	if entry.head > 0 {
+		w.Header().Set(staleBlockHeader, strconv.FormatUint(entry.head, 10))
+	}
+	w.Write(data)
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestServeStale tests that cached results are served, marked, when the upstream fails.
+func TestServeStale(t *testing.T) {
+	// Setup
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Cache: &CacheConfig{
+		Methods:    []string{"eth_getBalance", "eth_gasPrice"},
+		TTL:        time.Millisecond,
+		ServeStale: &ServeStaleConfig{Methods: []string{"eth_getBalance"}},
+	}}
+	buildMethodURLMap()
+	if err := setupCache(); err != nil {
+		t.Fatalf("Failed to set up cache: %v", err)
+	}
+	defer func() {
+		config = Config{}
+		responseCache = nil
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	setTrackedHead(server.URL, 100)
+	request := func(method string, id int, header string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":`+string(rune('0'+id))+`,"method":"`+method+`","params":[]}`))
+		if header != "" {
+			r.Header.Set(header, "1")
+		}
+		w := httptest.NewRecorder()
+		handleProxy(w, r)
+		return w
+	}
+
+	// Test
+	request("eth_getBalance", 1, "")
+	request("eth_gasPrice", 1, "")
+	time.Sleep(5 * time.Millisecond) // Past ttl
+	failing.Store(true)
+	stale := request("eth_getBalance", 7, "")
+	unlisted := request("eth_gasPrice", 7, "")
+	bypassed := request("eth_getBalance", 7, "X-No-Cache")
+	server.Close()
+	unreachable := request("eth_getBalance", 8, "")
+
+	// Verify
+	for name, w := range map[string]*httptest.ResponseRecorder{"failing upstream": stale, "unreachable upstream": unreachable} {
+		if got := w.Header().Get("X-Cache"); got != cacheStaleIfError {
+			t.Errorf("Expected X-Cache %s for the %s, got %q", cacheStaleIfError, name, got)
+		}
+		if w.Header().Get("Age") == "" {
+			t.Errorf("Expected an Age header for the %s", name)
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode the response for the %s: %v (%s)", name, err, w.Body.String())
+		}
+		if resp["result"] != "0x10" || resp["id"].(float64) < 7 || len(resp) != 3 {
+			t.Errorf("Expected a plain response with the stale result for the %s, got %s", name, w.Body.String())
+		}
+		if got := w.Header().Get(staleBlockHeader); got != "100" {
+			t.Errorf("Expected %s 100 for the %s, got %q", staleBlockHeader, name, got)
+		}
+	}
+	if unlisted.Code != http.StatusBadGateway {
+		t.Errorf("Expected the error for a method not served stale, got %d: %s", unlisted.Code, unlisted.Body.String())
+	}
+	if bypassed.Code != http.StatusBadGateway {
+		t.Errorf("Expected the error for a client bypassing the cache, got %d: %s", bypassed.Code, bypassed.Body.String())
+	}
+	if got := cacheRequestsTotal.value("stale_if_error"); got < 2 {
+		t.Errorf("Expected at least 2 stale_if_error lookups, got %v", got)
+	}
+}
+
+// TestServeStaleMaxAge tests that results older than max_age are not served.
+func TestServeStaleMaxAge(t *testing.T) {
+	// Setup
+	rc := &resultCache{methods: map[string]bool{"eth_getBalance": true}, ttl: time.Second, maxEntries: 10, entries: make(map[string]*cacheEntry)}
+	config = Config{Cache: &CacheConfig{ServeStale: &ServeStaleConfig{MaxAge: time.Minute}}}
+	defer func() { config = Config{} }()
+	req := &JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "eth_getBalance"}
+	rc.put("http://node", req.Method, req.Params, 0, json.RawMessage(`"0x1"`))
+	key, _ := rc.key("http://node", req.Method, req.Params)
+
+	// Test
+	w := httptest.NewRecorder()
+	fresh := rc.serveStale(w, httptest.NewRequest("POST", "/", nil), "http://node", req)
+	rc.entries[key].stored = time.Now().Add(-2 * time.Minute)
+	old := rc.serveStale(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil), "http://node", req)
+	retention := rc.retention()
+
+	// Verify
+	if !fresh || w.Body.String() != `{"jsonrpc":"2.0","result":"0x1","id":1}` || w.Header().Get("Age") != "0" {
+		t.Errorf("Expected a result within max_age to be served, got %v: %s", fresh, w.Body.String())
+	}
+	if got := w.Header().Get(staleBlockHeader); got != "" {
+		t.Errorf("Expected no %s for a result stored at an unknown head, got %q", staleBlockHeader, got)
+	}
+	if old {
+		t.Error("Expected a result older than max_age not to be served")
+	}
+	if retention != time.Minute {
+		t.Errorf("Expected entries to be kept for max_age, got %v", retention)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// Static stub responses
+//
+// A route can answer with a canned response instead of forwarding, for constant
+// queries such as eth_chainId or a branded web3_clientVersion. Stubbed calls never
+// reach an upstream, which saves cost and latency and keeps them working when every
+// upstream is down. A stub on a conditional route only answers the calls its `when`
+// expression matches.
+
+// StubResponse is a canned response served by a route.
+type StubResponse struct {
+	Result interface{}   `yaml:"result"` // The result to return (null if neither result nor error is set)
+	Error  *JSONRPCError `yaml:"error"`  // An error to return instead of a result (optional)
+}
+
+// validateStub checks a route's stub.
+func validateStub(stub *StubResponse) error {
+	if stub == nil {
+		return nil
+	}
+	if stub.Result != nil && stub.Error != nil {
+		return fmt.Errorf("stub cannot have both a result and an error")
+	}
+	if stub.Error != nil && stub.Error.Message == "" {
+		return fmt.Errorf("stub error needs a message")
+	}
+	return nil
+}
+
+// stubResponse returns the canned response for a call if its route has a stub.
+//
+// Parameters:
+//   - upstream: The upstream selected for the call
+//   - req: The call
+//
+// Returns:
+//   - []byte: The marshaled JSON-RPC response
+//   - bool: Whether the route answers with a stub
+func stubResponse(upstream Upstream, req *JSONRPCRequest) ([]byte, bool) {
+	if upstream.Route == nil || upstream.Route.Stub == nil {
+		return nil, false
+	}
+	stub := upstream.Route.Stub
+	if stub.Error != nil {
+		return localResponse(req, nil, stub.Error), true
+	}
+	return localResponse(req, stub.Result, nil), true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestStubResponses tests canned responses served without an upstream
+func TestStubResponses(t *testing.T) {
+	// Setup
+	yamlConfig := `
+default_url: "http://127.0.0.1:1"
+routes:
+  - method: "eth_chainId"
+    stub:
+      result: "0xe708"
+  - method: "web3_clientVersion"
+    stub:
+      result: "linea-rpc/v1"
+  - method: "eth_sign"
+    stub:
+      error:
+        code: -32601
+        message: "the method eth_sign does not exist/is not available"
+`
+	config = Config{}
+	if err := yaml.Unmarshal([]byte(yamlConfig), &config); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	buildMethodURLMap()
+
+	// Test
+	var chainID, version string
+	if err := callProxy(t, "eth_chainId", []interface{}{}, &chainID); err != nil {
+		t.Fatalf("eth_chainId failed: %v", err)
+	}
+	if err := callProxy(t, "web3_clientVersion", nil, &version); err != nil {
+		t.Fatalf("web3_clientVersion failed: %v", err)
+	}
+	signErr := callProxy(t, "eth_sign", []interface{}{}, nil)
+
+	batch := []byte(`[{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1},{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":2}]`)
+	w := httptest.NewRecorder()
+	handleProxy(w, httptest.NewRequest("POST", "/", bytes.NewReader(batch)))
+	body, _ := io.ReadAll(w.Result().Body)
+	var batchResp []JSONRPCResponse
+	json.Unmarshal(body, &batchResp)
+
+	// Verify
+	if chainID != "0xe708" {
+		t.Errorf("Expected chain ID 0xe708, got %s", chainID)
+	}
+	if version != "linea-rpc/v1" {
+		t.Errorf("Expected client version linea-rpc/v1, got %s", version)
+	}
+	if signErr == nil || signErr.Code != -32601 {
+		t.Errorf("Expected -32601 stub error, got %v", signErr)
+	}
+	if len(batchResp) != 2 || batchResp[0].Result != "0xe708" || batchResp[1].ID != float64(2) {
+		t.Errorf("Expected two stubbed batch responses, got %s", string(body))
+	}
+}
+
+// TestValidateStub tests rejection of invalid stubs
+func TestValidateStub(t *testing.T) {
+	invalid := []*StubResponse{
+		{Result: "0x1", Error: &JSONRPCError{Code: 1, Message: "x"}},
+		{Error: &JSONRPCError{Code: 1}},
+	}
+	for i, stub := range invalid {
+		if err := validateStub(stub); err == nil {
+			t.Errorf("Expected stub %d to be rejected", i)
+		}
+	}
+}
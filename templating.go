@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration templates
+//
+// A configuration file whose name ends in .tmpl (such as config.yaml.tmpl) is a Go
+// text/template, rendered when the configuration is loaded. The rest of the name
+// selects the format as usual. This lets one template render the configuration of
+// several environments that differ only in providers or keys:
+//
+//	default_url: {{ required "provider URL" .Values.provider_url }}
+//	default_name: {{ .Values.provider_name | default "primary" }}
+//	routes:
+//	  - method: eth_sendRawTransaction
+//	    url: https://relay.example.com/{{ env "RELAY_KEY" }}
+//
+// Templates see .Values, read from the YAML or JSON file given with -values (or
+// JSONRPC_PROXY_VALUES), and .Env, the environment. A missing value is an error unless
+// it goes through default or required. Besides the built-in template functions, env,
+// default, required, and quote are available. Line numbers in errors found after
+// rendering refer to the rendered configuration.
+
+// isConfigTemplate reports whether a configuration file is a template.
+func isConfigTemplate(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".tmpl")
+}
+
+// configTemplateFuncs are the functions available to configuration templates.
+var configTemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	"required": func(what string, value interface{}) (interface{}, error) {
+		if value == nil || value == "" {
+			return nil, fmt.Errorf("%s is required", what)
+		}
+		return value, nil
+	},
+	"quote": func(value interface{}) string {
+		return strconv.Quote(fmt.Sprint(value))
+	},
+}
+
+// renderConfigTemplate renders a configuration template.
+//
+// Parameters:
+//   - filename: The name of the template, for errors
+//   - data: The template
+//   - valuesFile: The file providing .Values, or "" for none
+//
+// Returns:
+//   - []byte: The rendered configuration
+//   - error: An error if the values cannot be read or the template fails
+func renderConfigTemplate(filename string, data []byte, valuesFile string) ([]byte, error) {
+	values := map[string]interface{}{}
+	if valuesFile != "" {
+		raw, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading values file: %w", err)
+		}
+		if err := yaml.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("error parsing values file %s: %w", valuesFile, err)
+		}
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+
+	tmpl, err := template.New(filepath.Base(filename)).Funcs(configTemplateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, map[string]interface{}{"Values": values, "Env": env}); err != nil {
+		return nil, err
+	}
+
+	// Missing map entries render as "<no value>" rather than failing, so that default
+	// and required can see them
+	for i, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "<no value>") {
+			return nil, fmt.Errorf("%s: line %d of the rendered configuration uses a missing value", filepath.Base(filename), i+1)
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// configTemplate renders the same configuration for several environments
+const configTemplate = `
+default_url: {{ required "provider_url" .Values.provider_url }}
+default_name: {{ .Values.provider_name | default "primary" }}
+routes:
+  - method: eth_sendRawTransaction
+    url: https://relay.example.com/{{ env "TEST_RELAY_KEY" }}
+{{- range .Values.archive_methods }}
+  - method: {{ . }}
+    url: {{ $.Values.archive_url }}
+{{- end }}
+`
+
+// TestConfigTemplate tests rendering a configuration template with values and the environment
+func TestConfigTemplate(t *testing.T) {
+	// Setup
+	t.Setenv("TEST_RELAY_KEY", "secret")
+	tmpl := writeConfigAs(t, "config.yaml.tmpl", configTemplate)
+	staging := writeConfigAs(t, "staging.yaml", "provider_url: https://staging.example.com\narchive_url: https://archive-staging.example.com\narchive_methods: [eth_getLogs]\n")
+	production := writeConfigAs(t, "production.json", `{"provider_url": "https://prod.example.com", "provider_name": "prod", "archive_url": "https://archive.example.com", "archive_methods": ["eth_getLogs", "trace_block"]}`)
+
+	// Test
+	config = Config{}
+	if err := loadConfigWith(tmpl, staging, nil); err != nil {
+		t.Fatalf("Failed to load staging config: %v", err)
+	}
+	stagingConfig := config
+	config = Config{}
+	if err := loadConfigWith(tmpl, production, nil); err != nil {
+		t.Fatalf("Failed to load production config: %v", err)
+	}
+
+	// Verify
+	if stagingConfig.DefaultURL != "https://staging.example.com" || stagingConfig.DefaultName != "primary" || len(stagingConfig.Routes) != 2 {
+		t.Errorf("Unexpected staging config: %+v", stagingConfig)
+	}
+	if config.DefaultURL != "https://prod.example.com" || config.DefaultName != "prod" || len(config.Routes) != 3 {
+		t.Errorf("Unexpected production config: %+v", config)
+	}
+	if config.Routes[0].URL != "https://relay.example.com/secret" {
+		t.Errorf("Expected the relay key from the environment, got %s", config.Routes[0].URL)
+	}
+}
+
+// TestConfigTemplateErrors tests that template errors are reported
+func TestConfigTemplateErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tmpl     string
+		values   string
+		expected string
+	}{
+		{"Required value", configTemplate, "archive_url: https://archive.example.com\n", "provider_url is required"},
+		{"Missing value", "default_url: {{ .Values.provider_url }}\n", "", "line 1 of the rendered configuration uses a missing value"},
+		{"Syntax error", "default_url: {{ .Values.provider_url \n", "", "config.yaml.tmpl:1"},
+		{"Invalid rendered configuration", "defualt_url: {{ .Values.provider_url }}\n", "provider_url: https://a.example.com\n", "unknown field defualt_url"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+			values := ""
+			if tc.values != "" {
+				values = writeConfigAs(t, "values.yaml", tc.values)
+			}
+
+			// Test
+			err := loadConfigWith(writeConfigAs(t, "config.yaml.tmpl", tc.tmpl), values, nil)
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Upstream timeouts
+//
+// One timeout does not fit every upstream: an archive node tracing transactions may
+// legitimately take minutes, while a node that does not answer eth_chainId within two
+// seconds is better given up on. Each phase of an upstream request can be bounded per
+// upstream, by name or URL, with the default applying to upstreams not listed and to
+// the fields they leave unset:
+//
+//	upstream_timeouts:
+//	  default:
+//	    dial: 3s               # establishing the TCP connection
+//	    tls_handshake: 3s      # completing the TLS handshake
+//	    response_header: 10s   # waiting for the response headers once the request is sent
+//	    total: 30s             # the whole request, including reading the response body
+//	  upstreams:
+//	    archive:
+//	      response_header: 5m
+//	      total: 10m
+//
+// Unset timeouts are unlimited, except that Go's default transport bounds dial to 30s
+// and the TLS handshake to 10s. A call that exceeds total is answered with a -32002
+// "request timed out" error, as with batch timeouts (see batchsplit.go), and counted
+// with the timeout outcome in metrics; the other timeouts fail the call as any
+// connection error does. Dial, tls_handshake, and response_header apply to upstreams
+// on the default transport, egress proxies, and TLS policies, but not to IPC upstreams
+// or transports registered by embedding programs, which manage their own connections.
+
+// UpstreamTimeoutsConfig configures the timeouts of upstream requests.
+type UpstreamTimeoutsConfig struct {
+	Default   UpstreamTimeouts             `yaml:"default"`   // Timeouts of upstreams not listed (default: none)
+	Upstreams map[string]*UpstreamTimeouts `yaml:"upstreams"` // Timeouts by upstream name or URL, unset fields falling back to default
+}
+
+// UpstreamTimeouts bounds the phases of an upstream request.
+type UpstreamTimeouts struct {
+	Dial           time.Duration `yaml:"dial"`            // Establishing the connection
+	TLSHandshake   time.Duration `yaml:"tls_handshake"`   // Completing the TLS handshake
+	ResponseHeader time.Duration `yaml:"response_header"` // Waiting for the response headers after sending the request
+	Total          time.Duration `yaml:"total"`           // The whole request, including reading the response body
+}
+
+var (
+	timeoutsByURL       map[string]UpstreamTimeouts // Resolved timeouts of the configured upstreams
+	timeoutTransportsMu sync.Mutex
+	timeoutTransports   map[string]http.RoundTripper // Transports with connection timeouts, by upstream URL
+)
+
+// setupUpstreamTimeouts resolves the timeouts of the configured upstreams. It is
+// called once the transports are known.
+//
+// Returns:
+//   - error: An error if a timeout is negative
+func setupUpstreamTimeouts() error {
+	timeoutsByURL = make(map[string]UpstreamTimeouts)
+	timeoutTransportsMu.Lock()
+	timeoutTransports = make(map[string]http.RoundTripper)
+	timeoutTransportsMu.Unlock()
+	tc := config.UpstreamTimeouts
+	if tc == nil {
+		return nil
+	}
+	if err := tc.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, upstream := range sortedKeys(tc.Upstreams) {
+		if t := tc.Upstreams[upstream]; t != nil {
+			if err := t.validate(); err != nil {
+				return fmt.Errorf("upstreams: %s: %w", upstreamKeyLabel(upstream), err)
+			}
+		}
+	}
+
+	upstreams := []Upstream{{Name: config.DefaultName, URL: config.DefaultURL}}
+	for _, route := range config.Routes {
+		upstreams = append(upstreams, Upstream{Name: route.Name, URL: route.URL})
+	}
+	for _, uc := range poolMembers() {
+		upstreams = append(upstreams, Upstream{Name: uc.Name, URL: uc.URL})
+	}
+	if config.WriteRouting != nil && config.WriteRouting.Enabled {
+		for _, uc := range config.WriteRouting.Upstreams {
+			upstreams = append(upstreams, Upstream{Name: uc.Name, URL: uc.URL})
+		}
+	}
+	for _, u := range upstreams {
+		if u.URL == "" {
+			continue
+		}
+		t := resolveTimeouts(u.URL, u.Name)
+		if t == (UpstreamTimeouts{}) {
+			continue
+		}
+		timeoutsByURL[u.URL] = t
+		if t.connectionTimeouts() && !isIPCURL(u.URL) {
+			if _, ok := timeoutTransport(u.URL); !ok {
+				log.Printf("Warning: the transport of %s does not support dial, tls_handshake, or response_header timeouts", upstreamLabel(u))
+			}
+		}
+		log.Printf("Timeouts for %s: %s", upstreamLabel(u), t)
+	}
+	return nil
+}
+
+// validate checks that no timeout is negative.
+func (t *UpstreamTimeouts) validate() error {
+	if t.Dial < 0 || t.TLSHandshake < 0 || t.ResponseHeader < 0 || t.Total < 0 {
+		return fmt.Errorf("timeouts cannot be negative")
+	}
+	return nil
+}
+
+// connectionTimeouts reports whether timeouts applied by the transport are set.
+func (t UpstreamTimeouts) connectionTimeouts() bool {
+	return t.Dial > 0 || t.TLSHandshake > 0 || t.ResponseHeader > 0
+}
+
+// String describes the timeouts that are set.
+func (t UpstreamTimeouts) String() string {
+	s := ""
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{{"dial", t.Dial}, {"tls_handshake", t.TLSHandshake}, {"response_header", t.ResponseHeader}, {"total", t.Total}} {
+		if phase.d > 0 {
+			if s != "" {
+				s += ", "
+			}
+			s += fmt.Sprintf("%s %v", phase.name, phase.d)
+		}
+	}
+	return s
+}
+
+// resolveTimeouts merges the timeouts listed for an upstream, by name before URL, over
+// the default ones.
+func resolveTimeouts(url, name string) UpstreamTimeouts {
+	tc := config.UpstreamTimeouts
+	if tc == nil {
+		return UpstreamTimeouts{}
+	}
+	t := tc.Default
+	listed, ok := tc.Upstreams[name]
+	if !ok || name == "" {
+		listed = tc.Upstreams[url]
+	}
+	if listed != nil {
+		if listed.Dial > 0 {
+			t.Dial = listed.Dial
+		}
+		if listed.TLSHandshake > 0 {
+			t.TLSHandshake = listed.TLSHandshake
+		}
+		if listed.ResponseHeader > 0 {
+			t.ResponseHeader = listed.ResponseHeader
+		}
+		if listed.Total > 0 {
+			t.Total = listed.Total
+		}
+	}
+	return t
+}
+
+// upstreamTimeoutsFor returns the timeouts of an upstream. Upstreams discovered after
+// startup are matched by URL.
+func upstreamTimeoutsFor(url string) UpstreamTimeouts {
+	if t, ok := timeoutsByURL[url]; ok {
+		return t
+	}
+	return resolveTimeouts(url, "")
+}
+
+// timeoutTransport returns the upstream's transport with its connection timeouts
+// applied, creating it on first use.
+//
+// Returns:
+//   - http.RoundTripper: The transport
+//   - bool: False if the upstream's transport is not an *http.Transport
+func timeoutTransport(url string) (http.RoundTripper, bool) {
+	timeoutTransportsMu.Lock()
+	defer timeoutTransportsMu.Unlock()
+	if rt, ok := timeoutTransports[url]; ok {
+		return rt, true
+	}
+	rt, err := lookupTransport(urlToTransport[url])
+	if err != nil {
+		return nil, false
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, false
+	}
+	t := upstreamTimeoutsFor(url)
+	transport := base.Clone()
+	if t.Dial > 0 {
+		transport.DialContext = cachedDialer(&net.Dialer{Timeout: t.Dial, KeepAlive: 30 * time.Second})
+		countConnections(transport)
+	}
+	trackDNSTransport(transport)
+	if t.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = t.TLSHandshake
+	}
+	if t.ResponseHeader > 0 {
+		transport.ResponseHeaderTimeout = t.ResponseHeader
+	}
+	if timeoutTransports == nil {
+		timeoutTransports = make(map[string]http.RoundTripper)
+	}
+	timeoutTransports[url] = transport
+	return transport, true
+}
+
+// withTotalTimeout bounds an upstream request by the upstream's total timeout.
+//
+// Returns:
+//   - context.Context: The context of the request
+//   - context.CancelFunc: Releases the timer, once the response body is closed
+func withTotalTimeout(ctx context.Context, url string) (context.Context, context.CancelFunc) {
+	if total := upstreamTimeoutsFor(url).Total; total > 0 {
+		return context.WithTimeout(ctx, total)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestUpstreamTotalTimeout tests that an upstream's total timeout answers slow calls with a timeout error
+func TestUpstreamTotalTimeout(t *testing.T) {
+	// Setup
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("debug_traceTransaction")) {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+	config = Config{
+		DefaultURL:       upstream.URL,
+		DefaultName:      "node",
+		UpstreamTimeouts: &UpstreamTimeoutsConfig{Upstreams: map[string]*UpstreamTimeouts{"node": {Total: 100 * time.Millisecond}}},
+	}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		setupUpstreamTimeouts()
+	}()
+	if err := setupUpstreamTimeouts(); err != nil {
+		t.Fatalf("Failed to set up timeouts: %v", err)
+	}
+
+	// Test
+	fastErr := callProxy(t, "eth_chainId", nil, nil)
+	slowErr := callProxy(t, "debug_traceTransaction", []interface{}{"0x1"}, nil)
+	rec := httptest.NewRecorder()
+	handleProxy(rec, httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`[{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0x1"],"id":7}]`))))
+
+	// Verify
+	if fastErr != nil {
+		t.Errorf("Expected the fast call to succeed, got %v", fastErr)
+	}
+	if slowErr == nil || slowErr.Code != -32002 || slowErr.Data != "node did not answer within 100ms" {
+		t.Errorf("Expected a -32002 timeout error, got %+v", slowErr)
+	}
+	var batch []JSONRPCResponse
+	json.Unmarshal(rec.Body.Bytes(), &batch)
+	if len(batch) != 1 || batch[0].Error == nil || batch[0].Error.Code != -32002 {
+		t.Errorf("Expected the batch call to time out, got %s", rec.Body.String())
+	}
+}
+
+// TestUpstreamConnectionTimeouts tests merging timeouts over the default and applying them to the transport
+func TestUpstreamConnectionTimeouts(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://10.0.0.1:8545",
+		Routes:     []Route{{Method: "debug_traceTransaction", URL: "http://archive:8545", Name: "archive"}},
+		UpstreamTimeouts: &UpstreamTimeoutsConfig{
+			Default:   UpstreamTimeouts{Dial: time.Second, ResponseHeader: 2 * time.Second},
+			Upstreams: map[string]*UpstreamTimeouts{"archive": {ResponseHeader: 5 * time.Minute}},
+		},
+	}
+	buildTransportMap()
+	defer func() {
+		config = Config{}
+		setupUpstreamTimeouts()
+	}()
+
+	// Test
+	err := setupUpstreamTimeouts()
+	client, _ := clientForURL("http://archive:8545")
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to set up timeouts: %v", err)
+	}
+	expected := UpstreamTimeouts{Dial: time.Second, ResponseHeader: 5 * time.Minute}
+	if got := upstreamTimeoutsFor("http://archive:8545"); got != expected {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if transport, ok := client.Transport.(*http.Transport); !ok || transport.ResponseHeaderTimeout != 5*time.Minute {
+		t.Errorf("Expected a transport with a 5m response header timeout, got %#v", client.Transport)
+	}
+	if upstreamTimeoutsFor("http://10.0.0.1:8545").ResponseHeader != 2*time.Second {
+		t.Errorf("Expected the default timeouts for the default upstream")
+	}
+	config.UpstreamTimeouts.Default.Total = -time.Second
+	if setupUpstreamTimeouts() == nil {
+		t.Errorf("Expected negative timeouts to be rejected")
+	}
+}
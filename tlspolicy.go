@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Upstream TLS policies
+//
+// Upstreams differ in what TLS they need: a provider may require TLS 1.3, an internal
+// node may present a certificate from a private CA or for a name other than its address.
+// TLS policies are declared by name and registered as transports, so an upstream
+// selects one with its `transport` option:
+//
+//	tls_policies:
+//	  internal:
+//	    min_version: "1.2"                  # 1.0, 1.1, 1.2 (default), or 1.3
+//	    cipher_suites:                      # TLS 1.2 and below (optional)
+//	      - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+//	    ca_bundle: /etc/ssl/internal-ca.pem # trusted instead of the system roots (optional)
+//	    server_name: node.internal          # SNI and verified name (optional)
+//	    egress_proxy: corp                  # egress proxy to connect through (optional)
+//	routes:
+//	  - method: debug_traceTransaction
+//	    url: https://10.0.0.5:8545
+//	    transport: internal
+//
+// Policies connect through the egress proxy selected by egress_proxy unless they name
+// one of their own. For self-signed internal nodes, insecure_skip_verify: true turns
+// off certificate verification altogether; it is logged as a warning at startup and
+// for each host the first time it is reached, since it lets anyone on the path
+// impersonate the upstream. Prefer ca_bundle wherever the certificate can be obtained.
+//
+// Pinning guards against a compromised CA or a DNS hijack redirecting traffic to a node
+// with a valid certificate. A policy with pins only accepts connections whose
+// certificate chain contains a pinned key or certificate:
+//
+//	tls_policies:
+//	  provider:
+//	    pins:
+//	      - sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=  # SHA-256 of a SubjectPublicKeyInfo
+//	      - cert-sha256/3b:a7:...:f2                              # SHA-256 of a certificate, hex or base64
+//
+// Pins are checked against the verified chain, so an intermediate or root can be
+// pinned, or only against the leaf with insecure_skip_verify, which makes pinning a
+// self-signed node safe. List a backup pin so that a key rotation does not cut the
+// upstream off. `openssl x509 -pubkey -noout | openssl pkey -pubin -outform der |
+// openssl dgst -sha256 -binary | base64` prints the SPKI pin of a certificate.
+
+// TLSPolicyConfig defines a TLS policy for upstream connections.
+type TLSPolicyConfig struct {
+	MinVersion         string   `yaml:"min_version"`          // Minimum TLS version: 1.0, 1.1, 1.2, or 1.3 (default: 1.2)
+	CipherSuites       []string `yaml:"cipher_suites"`        // Allowed cipher suites for TLS 1.2 and below (default: Go's defaults)
+	CABundle           string   `yaml:"ca_bundle"`            // PEM file of CA certificates trusted instead of the system roots (optional)
+	ServerName         string   `yaml:"server_name"`          // Server name sent with SNI and verified against the certificate (optional)
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"` // Accept any certificate; for self-signed internal nodes only
+	EgressProxy        string   `yaml:"egress_proxy"`         // Egress proxy to connect through (default: egress_proxy)
+	Pins               []string `yaml:"pins"`                 // Accepted SPKI ("sha256/") or certificate ("cert-sha256/") hashes (optional)
+}
+
+// certificatePins are the hashes a policy accepts.
+type certificatePins struct {
+	spki  map[[sha256.Size]byte]bool // SHA-256 of SubjectPublicKeyInfo
+	certs map[[sha256.Size]byte]bool // SHA-256 of the DER certificate
+}
+
+// tlsVersions maps min_version values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// setupTLSPolicies registers the TLS policies as transports.
+//
+// Returns:
+//   - error: An error if a policy is invalid or its CA bundle cannot be read
+func setupTLSPolicies() error {
+	for _, name := range sortedKeys(config.TLSPolicies) {
+		pc := config.TLSPolicies[name]
+		if pc == nil {
+			pc = &TLSPolicyConfig{}
+		}
+		tlsConfig, err := buildTLSConfig(name, pc)
+		if err != nil {
+			return fmt.Errorf("tls_policies.%s: %w", name, err)
+		}
+
+		base, ok := defaultTransport.(*http.Transport)
+		if pc.EgressProxy != "" {
+			if _, exists := config.EgressProxies[pc.EgressProxy]; !exists {
+				return fmt.Errorf("tls_policies.%s: unknown egress proxy %q", name, pc.EgressProxy)
+			}
+			rt, _ := lookupTransport(pc.EgressProxy)
+			base, ok = rt.(*http.Transport)
+		}
+		if !ok {
+			base = http.DefaultTransport.(*http.Transport)
+		}
+		transport := countConnections(withDNSCache(base.Clone()))
+		transport.TLSClientConfig = tlsConfig
+		RegisterTransport(name, transport)
+
+		if pc.InsecureSkipVerify && len(pc.Pins) == 0 {
+			log.Printf("Warning: TLS policy %s does not verify upstream certificates; anyone on the network path can impersonate its upstreams", name)
+		} else if len(pc.Pins) > 0 {
+			log.Printf("Registered TLS policy %s (minimum TLS %s, %d pin(s))", name, tlsVersionName(tlsConfig.MinVersion), len(pc.Pins))
+		} else {
+			log.Printf("Registered TLS policy %s (minimum TLS %s)", name, tlsVersionName(tlsConfig.MinVersion))
+		}
+	}
+	return nil
+}
+
+// buildTLSConfig builds the client TLS configuration of a policy.
+//
+// Parameters:
+//   - name: The policy name, for logging
+//   - pc: The policy configuration
+//
+// Returns:
+//   - *tls.Config: The TLS configuration
+//   - error: An error if a setting is invalid
+func buildTLSConfig(name string, pc *TLSPolicyConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: pc.ServerName}
+	if pc.MinVersion != "" {
+		version, ok := tlsVersions[pc.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported min_version %q (expected 1.0, 1.1, 1.2, or 1.3)", pc.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(pc.CipherSuites) > 0 {
+		if tlsConfig.MinVersion == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher_suites cannot be configured for TLS 1.3")
+		}
+		ids := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			ids[suite.Name] = suite.ID
+		}
+		insecure := make(map[string]bool)
+		for _, suite := range tls.InsecureCipherSuites() {
+			insecure[suite.Name] = true
+		}
+		for _, suite := range pc.CipherSuites {
+			id, ok := ids[suite]
+			if !ok {
+				if insecure[suite] {
+					return nil, fmt.Errorf("cipher suite %s is insecure", suite)
+				}
+				return nil, fmt.Errorf("unknown cipher suite %q", suite)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+
+	if pc.CABundle != "" {
+		pem, err := os.ReadFile(pc.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("ca_bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_bundle: no certificates in %s", pc.CABundle)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	var pins *certificatePins
+	if len(pc.Pins) > 0 {
+		var err error
+		if pins, err = parsePins(pc.Pins); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig.InsecureSkipVerify = pc.InsecureSkipVerify
+	var warned sync.Map // Hosts already warned about
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if pins != nil {
+			if !pins.match(cs) {
+				log.Printf("Warning: Certificate of %s matches none of the pins of TLS policy %s", cs.ServerName, name)
+				return fmt.Errorf("certificate of %s matches none of the pins of TLS policy %s", cs.ServerName, name)
+			}
+			return nil
+		}
+		if pc.InsecureSkipVerify {
+			if _, loaded := warned.LoadOrStore(cs.ServerName, true); !loaded {
+				log.Printf("Warning: Connected to %s without verifying its certificate (TLS policy %s)", cs.ServerName, name)
+			}
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
+// parsePins parses the pins of a policy.
+//
+// Parameters:
+//   - values: Pins as "sha256/<base64>" or "cert-sha256/<hex or base64>"
+//
+// Returns:
+//   - *certificatePins: The parsed pins
+//   - error: An error if a pin is malformed
+func parsePins(values []string) (*certificatePins, error) {
+	pins := &certificatePins{spki: make(map[[sha256.Size]byte]bool), certs: make(map[[sha256.Size]byte]bool)}
+	for _, value := range values {
+		set := pins.spki
+		encoded, ok := strings.CutPrefix(value, "sha256/")
+		if !ok {
+			if encoded, ok = strings.CutPrefix(value, "cert-sha256/"); !ok {
+				return nil, fmt.Errorf("pin %q must start with sha256/ or cert-sha256/", value)
+			}
+			set = pins.certs
+		}
+		hash, err := base64.StdEncoding.DecodeString(encoded)
+		if digits := strings.ReplaceAll(encoded, ":", ""); len(digits) == 2*sha256.Size {
+			hash, err = hex.DecodeString(digits)
+		}
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a SHA-256 hash in hex or base64", value)
+		}
+		set[[sha256.Size]byte(hash)] = true
+	}
+	return pins, nil
+}
+
+// match reports whether a connection's certificates contain a pinned key or
+// certificate. Only the leaf is considered when the chain was not verified.
+func (p *certificatePins) match(cs tls.ConnectionState) bool {
+	var certs []*x509.Certificate
+	for _, chain := range cs.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+	for _, cert := range certs {
+		if p.spki[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] || p.certs[sha256.Sum256(cert.Raw)] {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsVersionName returns the min_version value of a TLS version.
+func tlsVersionName(version uint16) string {
+	return strings.TrimPrefix(tls.VersionName(version), "TLS ")
+}
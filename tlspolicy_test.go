@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTLSPolicies tests reaching a TLS upstream through TLS policies
+func TestTLSPolicies(t *testing.T) {
+	// Setup
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+	config = Config{TLSPolicies: map[string]*TLSPolicyConfig{
+		"trusted":     {MinVersion: "1.3", CABundle: bundle, ServerName: "example.com"},
+		"wrong-name":  {CABundle: bundle, ServerName: "node.internal"},
+		"self-signed": {InsecureSkipVerify: true},
+	}}
+	if err := setupTLSPolicies(); err != nil {
+		t.Fatalf("Failed to set up TLS policies: %v", err)
+	}
+	defer func() { config = Config{} }()
+	get := func(transport string) error {
+		rt, err := lookupTransport(transport)
+		if err != nil {
+			t.Fatalf("Transport %s not registered: %v", transport, err)
+		}
+		resp, err := (&http.Client{Transport: rt}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Test and verify
+	if err := get("trusted"); err != nil {
+		t.Errorf("Expected the CA bundle to be trusted, got %v", err)
+	}
+	if err := get("wrong-name"); err == nil || !strings.Contains(err.Error(), "node.internal") {
+		t.Errorf("Expected the server name to be verified, got %v", err)
+	}
+	if err := get("self-signed"); err != nil {
+		t.Errorf("Expected insecure_skip_verify to accept any certificate, got %v", err)
+	}
+	if err := get(""); err == nil {
+		t.Errorf("Expected the default transport to reject the self-signed certificate")
+	}
+}
+
+// TestTLSPolicyConfigErrors tests that invalid TLS policies are rejected
+func TestTLSPolicyConfigErrors(t *testing.T) {
+	defer func() { config = Config{} }()
+	testCases := []struct {
+		name     string
+		policy   *TLSPolicyConfig
+		expected string
+	}{
+		{"Unknown version", &TLSPolicyConfig{MinVersion: "1.4"}, "unsupported min_version"},
+		{"Unknown cipher suite", &TLSPolicyConfig{CipherSuites: []string{"TLS_FOO"}}, "unknown cipher suite"},
+		{"Insecure cipher suite", &TLSPolicyConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "is insecure"},
+		{"Cipher suites with TLS 1.3", &TLSPolicyConfig{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, "TLS 1.3"},
+		{"Missing CA bundle", &TLSPolicyConfig{CABundle: "/nonexistent/ca.pem"}, "ca_bundle"},
+		{"Unknown egress proxy", &TLSPolicyConfig{EgressProxy: "corp"}, "unknown egress proxy"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{TLSPolicies: map[string]*TLSPolicyConfig{"p": tc.policy}}
+
+			// Test
+			err := setupTLSPolicies()
+
+			// Verify
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestCertificatePinning tests accepting only upstreams presenting a pinned key or certificate
+func TestCertificatePinning(t *testing.T) {
+	// Setup
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	cert := server.Certificate()
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	certHash := sha256.Sum256(cert.Raw)
+	other := sha256.Sum256([]byte("another key"))
+	config = Config{TLSPolicies: map[string]*TLSPolicyConfig{
+		"spki":     {InsecureSkipVerify: true, Pins: []string{"sha256/" + base64.StdEncoding.EncodeToString(other[:]), "sha256/" + base64.StdEncoding.EncodeToString(spki[:])}},
+		"cert":     {InsecureSkipVerify: true, Pins: []string{"cert-sha256/" + hex.EncodeToString(certHash[:])}},
+		"mismatch": {InsecureSkipVerify: true, Pins: []string{"sha256/" + base64.StdEncoding.EncodeToString(other[:])}},
+	}}
+	if err := setupTLSPolicies(); err != nil {
+		t.Fatalf("Failed to set up TLS policies: %v", err)
+	}
+	defer func() { config = Config{} }()
+	get := func(transport string) error {
+		rt, _ := lookupTransport(transport)
+		resp, err := (&http.Client{Transport: rt}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Test and verify
+	if err := get("spki"); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, got %v", err)
+	}
+	if err := get("cert"); err != nil {
+		t.Errorf("Expected the pinned certificate to be accepted, got %v", err)
+	}
+	if err := get("mismatch"); err == nil || !strings.Contains(err.Error(), "matches none of the pins") {
+		t.Errorf("Expected an unpinned certificate to be rejected, got %v", err)
+	}
+
+	// Verify malformed pins are rejected
+	for _, pin := range []string{"md5/abc", "sha256/not-base64", "cert-sha256/abcd"} {
+		if _, err := parsePins([]string{pin}); err == nil {
+			t.Errorf("Expected pin %q to be rejected", pin)
+		}
+	}
+}
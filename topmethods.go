@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Top method usage
+//
+// Deciding what to cache, stub, or reroute first starts with knowing which methods
+// carry the traffic. The proxy can keep rolling statistics per method over a recent
+// window and report the top methods:
+//
+//	stats:
+//	  enabled: true
+//	  window: 15m   # default
+//	  top: 10       # methods reported (default)
+//
+// GET /stats/top returns the top methods as JSON, ranked by calls, or by response
+// bytes or average latency with ?by=bytes or ?by=latency, and ?n= overrides top:
+//
+//	{"window":"15m0s","by":"calls","methods":[
+//	  {"method":"eth_call","calls":5120,"bytes":2170880,"avg_latency_ms":41.2}, ...]}
+//
+// The top methods by calls are also exported as metrics, as
+// jsonrpc_proxy_top_method_calls, jsonrpc_proxy_top_method_bytes, and
+// jsonrpc_proxy_top_method_latency_seconds, labeled by method. Statistics are kept in
+// one-minute buckets, so the window slides by the minute. The calls of a batch share
+// its response bytes evenly and each count the batch's latency. Methods beyond the
+// first 1000 seen in a minute are counted as "(other)", so that clients calling
+// made-up methods cannot grow the statistics without bound.
+
+// StatsConfig configures the method usage statistics.
+type StatsConfig struct {
+	Enabled bool          `yaml:"enabled"` // Keep statistics and serve /stats/top
+	Window  time.Duration `yaml:"window"`  // Period the statistics cover (default: 15m)
+	Top     int           `yaml:"top"`     // Methods reported by default and in metrics (default: 10)
+}
+
+// Default statistics settings.
+const (
+	defaultStatsWindow = 15 * time.Minute
+	defaultStatsTop    = 10
+)
+
+// maxStatsMethods is the number of methods a bucket tracks before counting the rest
+// as "(other)".
+const maxStatsMethods = 1000
+
+// methodStats is the usage of a method.
+type methodStats struct {
+	calls   int64
+	bytes   int64
+	latency time.Duration // Total
+}
+
+// statsBucket is the usage of one minute.
+type statsBucket struct {
+	minute  int64 // Unix minute the bucket covers
+	methods map[string]*methodStats
+}
+
+// methodUsage keeps per-method statistics in a ring of one-minute buckets.
+type methodUsage struct {
+	window time.Duration
+	top    int
+
+	mu      sync.Mutex
+	buckets []statsBucket
+}
+
+// usageStats is the method usage, or nil when statistics are off.
+var usageStats *methodUsage
+
+// topMethodEntry is a method in a top list.
+type topMethodEntry struct {
+	Method       string  `json:"method"`
+	Calls        int64   `json:"calls"`
+	Bytes        int64   `json:"bytes"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// setupStats creates the method usage statistics.
+//
+// Returns:
+//   - error: An error if the window or top is invalid
+func setupStats() error {
+	usageStats = nil
+	sc := config.Stats
+	if sc == nil || !sc.Enabled {
+		return nil
+	}
+	if sc.Window < 0 || sc.Top < 0 {
+		return fmt.Errorf("window and top cannot be negative")
+	}
+	u := &methodUsage{window: defaultStatsWindow, top: defaultStatsTop}
+	if sc.Window > 0 {
+		u.window = sc.Window
+	}
+	if u.window < time.Minute {
+		return fmt.Errorf("window must be at least 1m")
+	}
+	if sc.Top > 0 {
+		u.top = sc.Top
+	}
+	u.buckets = make([]statsBucket, int(u.window/time.Minute))
+	usageStats = u
+	topMethodsRegistered.Do(func() { registerMetric(topMethodsCollector{}) })
+	log.Printf("Keeping method usage statistics over %v", u.window)
+	return nil
+}
+
+// record adds a call to the statistics.
+func (u *methodUsage) record(method string, bytes int64, d time.Duration, now time.Time) {
+	minute := now.Unix() / 60
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b := &u.buckets[minute%int64(len(u.buckets))]
+	if b.minute > minute {
+		return // The call is older than the window
+	}
+	if b.minute < minute || b.methods == nil {
+		*b = statsBucket{minute: minute, methods: make(map[string]*methodStats)}
+	}
+	ms, ok := b.methods[method]
+	if !ok {
+		if len(b.methods) >= maxStatsMethods {
+			method = "(other)"
+			ms = b.methods[method]
+		}
+		if ms == nil {
+			ms = &methodStats{}
+			b.methods[method] = ms
+		}
+	}
+	ms.calls++
+	ms.bytes += bytes
+	ms.latency += d
+}
+
+// topMethods ranks the methods of the window.
+//
+// Parameters:
+//   - by: "calls", "bytes", or "latency"
+//   - n: The number of methods returned
+//   - now: The end of the window
+//
+// Returns:
+//   - []topMethodEntry: The top methods, best first
+func (u *methodUsage) topMethods(by string, n int, now time.Time) []topMethodEntry {
+	oldest := now.Unix()/60 - int64(len(u.buckets)) + 1
+	totals := make(map[string]*methodStats)
+	u.mu.Lock()
+	for _, b := range u.buckets {
+		if b.minute < oldest {
+			continue
+		}
+		for method, ms := range b.methods {
+			total, ok := totals[method]
+			if !ok {
+				total = &methodStats{}
+				totals[method] = total
+			}
+			total.calls += ms.calls
+			total.bytes += ms.bytes
+			total.latency += ms.latency
+		}
+	}
+	u.mu.Unlock()
+
+	entries := make([]topMethodEntry, 0, len(totals))
+	for method, total := range totals {
+		entries = append(entries, topMethodEntry{
+			Method:       method,
+			Calls:        total.calls,
+			Bytes:        total.bytes,
+			AvgLatencyMs: float64(total.latency.Microseconds()) / 1000 / float64(total.calls),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case by == "bytes" && a.Bytes != b.Bytes:
+			return a.Bytes > b.Bytes
+		case by == "latency" && a.AvgLatencyMs != b.AvgLatencyMs:
+			return a.AvgLatencyMs > b.AvgLatencyMs
+		case a.Calls != b.Calls:
+			return a.Calls > b.Calls
+		}
+		return a.Method < b.Method
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// withStats wraps a proxy handler to record the usage of the methods it serves.
+func withStats(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := usageStats
+		if u == nil {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		var calls []JSONRPCRequest
+		if body, ok := peekBody(r); ok {
+			calls = parseCalls(body)
+		}
+		aw := &accessResponse{ResponseWriter: w}
+
+		next(aw, r)
+
+		if len(calls) == 0 {
+			return
+		}
+		d := time.Since(start)
+		for _, call := range calls {
+			u.record(call.Method, aw.bytes/int64(len(calls)), d, start)
+		}
+	}
+}
+
+// handleStatsTop serves the top methods.
+func handleStatsTop(w http.ResponseWriter, r *http.Request) {
+	u := usageStats
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = "calls"
+	case "calls", "bytes", "latency":
+	default:
+		http.Error(w, "by must be calls, bytes, or latency", http.StatusBadRequest)
+		return
+	}
+	n := u.top
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window":  u.window.String(),
+		"by":      by,
+		"methods": u.topMethods(by, n, time.Now()),
+	})
+}
+
+// topMethodsCollector exports the top methods by calls as metrics.
+type topMethodsCollector struct{}
+
+// topMethodsRegistered registers the collector on the first setup.
+var topMethodsRegistered sync.Once
+
+// write implements metricsCollector.
+func (topMethodsCollector) write(w io.Writer, openMetrics bool) {
+	u := usageStats
+	if u == nil {
+		return
+	}
+	top := u.topMethods("calls", u.top, time.Now())
+	for _, m := range []struct {
+		name, help string
+		value      func(topMethodEntry) float64
+	}{
+		{"jsonrpc_proxy_top_method_calls", "Calls of the top methods over the stats window.", func(e topMethodEntry) float64 { return float64(e.Calls) }},
+		{"jsonrpc_proxy_top_method_bytes", "Response bytes of the top methods over the stats window.", func(e topMethodEntry) float64 { return float64(e.Bytes) }},
+		{"jsonrpc_proxy_top_method_latency_seconds", "Average latency of the top methods over the stats window.", func(e topMethodEntry) float64 { return e.AvgLatencyMs / 1000 }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, e := range top {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, encodeLabels([]string{"method"}, []string{e.Method}), formatFloat(m.value(e)))
+		}
+	}
+}
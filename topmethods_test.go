@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTopMethods tests ranking methods over the rolling window
+func TestTopMethods(t *testing.T) {
+	// Setup
+	config = Config{Stats: &StatsConfig{Enabled: true, Window: 5 * time.Minute}}
+	defer func() {
+		config = Config{}
+		usageStats = nil
+	}()
+	if err := setupStats(); err != nil {
+		t.Fatalf("Failed to set up stats: %v", err)
+	}
+	now := time.Now()
+
+	// Test
+	for i := 0; i < 3; i++ {
+		usageStats.record("eth_blockNumber", 50, 10*time.Millisecond, now)
+	}
+	usageStats.record("eth_getLogs", 90000, 800*time.Millisecond, now.Add(-time.Minute))
+	usageStats.record("eth_call", 200, 40*time.Millisecond, now.Add(-2*time.Minute))
+	usageStats.record("eth_call", 200, 60*time.Millisecond, now)
+	usageStats.record("eth_chainId", 40, time.Millisecond, now.Add(-5*time.Minute))
+
+	// Verify
+	byCalls := usageStats.topMethods("calls", 10, now)
+	if len(byCalls) != 3 || byCalls[0].Method != "eth_blockNumber" || byCalls[1].Method != "eth_call" {
+		t.Errorf("Expected the methods of the window by calls, got %+v", byCalls)
+	}
+	if byCalls[1].AvgLatencyMs != 50 || byCalls[1].Bytes != 400 {
+		t.Errorf("Expected eth_call to average 50ms over 400 bytes, got %+v", byCalls[1])
+	}
+	if top := usageStats.topMethods("bytes", 1, now); len(top) != 1 || top[0].Method != "eth_getLogs" {
+		t.Errorf("Expected eth_getLogs first by bytes, got %+v", top)
+	}
+	if top := usageStats.topMethods("latency", 10, now); top[0].Method != "eth_getLogs" || top[2].Method != "eth_blockNumber" {
+		t.Errorf("Expected the methods by latency, got %+v", top)
+	}
+	if top := usageStats.topMethods("calls", 10, now.Add(5*time.Minute)); len(top) != 0 {
+		t.Errorf("Expected the window to have moved past every call, got %+v", top)
+	}
+}
+
+// TestStatsTopEndpoint tests recording proxied calls and serving them on /stats/top and metrics
+func TestStatsTopEndpoint(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.HasPrefix(body, []byte("[")) {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x2"}]`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+	config = Config{DefaultURL: server.URL, Stats: &StatsConfig{Enabled: true, Top: 1}}
+	buildMethodURLMap()
+	defer func() {
+		config = Config{}
+		usageStats = nil
+	}()
+	if err := setupStats(); err != nil {
+		t.Fatalf("Failed to set up stats: %v", err)
+	}
+	handler := withStats(handleProxy)
+
+	// Test
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)))
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice","params":[]}]`)))
+	w := httptest.NewRecorder()
+	handleStatsTop(w, httptest.NewRequest("GET", "/stats/top?n=5", nil))
+	bad := httptest.NewRecorder()
+	handleStatsTop(bad, httptest.NewRequest("GET", "/stats/top?by=size", nil))
+	metrics := httptest.NewRecorder()
+	handleMetrics(metrics, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Verify
+	var report struct {
+		Window  string           `json:"window"`
+		Methods []topMethodEntry `json:"methods"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report.Methods) != 2 {
+		t.Fatalf("Expected 2 methods, got %s", w.Body.String())
+	}
+	if report.Window != "15m0s" || report.Methods[0].Method != "eth_chainId" || report.Methods[0].Calls != 2 {
+		t.Errorf("Expected eth_chainId first with 2 calls, got %+v", report)
+	}
+	if bad.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown ranking to be rejected, got %d", bad.Code)
+	}
+	if !strings.Contains(metrics.Body.String(), `jsonrpc_proxy_top_method_calls{method="eth_chainId"} 2`) || strings.Contains(metrics.Body.String(), `top_method_calls{method="eth_gasPrice"}`) {
+		t.Errorf("Expected only the top method in metrics, got %s", metrics.Body.String())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Transport registry
+//
+// Upstream requests are sent through an http.RoundTripper. By default this is
+// http.DefaultTransport, but programs embedding the proxy (or init functions in
+// additional source files) can register their own RoundTripper under a name and
+// select it per upstream with the `transport` option in the configuration:
+//
+//	func init() {
+//	    RegisterTransport("corp-proxy", &http.Transport{Proxy: http.ProxyFromEnvironment})
+//	}
+//
+//	routes:
+//	  - method: "eth_call"
+//	    url: "https://node.internal"
+//	    transport: "corp-proxy"
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]http.RoundTripper) // Registered transports by name
+)
+
+// urlToTransport maps upstream URLs to the name of the transport used to reach them.
+// Upstreams without an explicit transport are absent from the map.
+var urlToTransport map[string]string
+
+// RegisterTransport makes a RoundTripper available to the configuration under the given name.
+// Registering the same name twice replaces the previous transport. It is safe to call
+// concurrently and is typically called from an init function.
+//
+// Parameters:
+//   - name: The name referenced by the `transport` configuration option
+//   - rt: The RoundTripper used for upstream requests
+func RegisterTransport(name string, rt http.RoundTripper) {
+	if name == "" {
+		panic("jsonrpc-proxy: RegisterTransport called with empty name")
+	}
+	if rt == nil {
+		panic("jsonrpc-proxy: RegisterTransport called with nil RoundTripper")
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = rt
+}
+
+// lookupTransport returns the RoundTripper registered under name.
+// An empty name selects the default transport: a copy of http.DefaultTransport, or the
+// egress proxy selected by egress_proxy (see egress.go).
+//
+// Parameters:
+//   - name: The registered transport name
+//
+// Returns:
+//   - http.RoundTripper: The transport to use
+//   - error: An error if no transport is registered under the name
+func lookupTransport(name string) (http.RoundTripper, error) {
+	if name == "" {
+		return defaultTransport, nil
+	}
+
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	rt, ok := transports[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", name)
+	}
+	return rt, nil
+}
+
+// registeredTransports returns the sorted names of all registered transports.
+func registeredTransports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildTransportMap records which transport each upstream URL uses.
+// A URL may appear in several routes, but all of them must agree on the transport.
+//
+// Returns:
+//   - error: An error if an upstream is configured with conflicting transports
+func buildTransportMap() error {
+	urlToTransport = make(map[string]string)
+
+	set := func(url, transport string) error {
+		if transport == "" {
+			return nil
+		}
+		if existing, ok := urlToTransport[url]; ok && existing != transport {
+			return fmt.Errorf("upstream %s has conflicting transports %q and %q", url, existing, transport)
+		}
+		urlToTransport[url] = transport
+		return nil
+	}
+
+	if err := set(config.DefaultURL, config.DefaultTransport); err != nil {
+		return err
+	}
+	for _, route := range config.Routes {
+		if err := set(route.URL, route.Transport); err != nil {
+			return err
+		}
+		if route.Mirror != nil {
+			if err := set(route.Mirror.URL, route.Mirror.Transport); err != nil {
+				return err
+			}
+		}
+	}
+	members := poolMembers()
+	if config.WriteRouting != nil && config.WriteRouting.Enabled {
+		members = append(members, config.WriteRouting.Upstreams...)
+	}
+	for _, uc := range members {
+		if err := set(uc.URL, uc.Transport); err != nil {
+			return err
+		}
+	}
+	if privateTxSigned {
+		if err := set(config.PrivateTx.URL, privateTxTransportName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTransports checks that every transport referenced by the configuration is registered.
+//
+// Returns:
+//   - error: An error naming the first unknown transport
+func validateTransports() error {
+	for url, name := range urlToTransport {
+		if _, err := lookupTransport(name); err != nil {
+			return fmt.Errorf("upstream %s: %w (registered: %v)", url, err, registeredTransports())
+		}
+	}
+	return nil
+}
+
+// clientForURL returns an HTTP client that sends requests through the transport
+// configured for the given upstream URL. IPC upstreams always use the IPC transport.
+//
+// Parameters:
+//   - targetURL: The upstream URL
+//
+// Returns:
+//   - *http.Client: A client using the upstream's transport
+//   - error: An error if the configured transport is not registered
+func clientForURL(targetURL string) (*http.Client, error) {
+	if isIPCURL(targetURL) {
+		return &http.Client{Transport: ipcRoundTripper}, nil
+	}
+	if upstreamTimeoutsFor(targetURL).connectionTimeouts() {
+		if rt, ok := timeoutTransport(targetURL); ok {
+			return &http.Client{Transport: rt}, nil
+		}
+	}
+	rt, err := lookupTransport(urlToTransport[targetURL])
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt}, nil
+}
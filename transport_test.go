@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// headerTransport is a RoundTripper that tags every request with a header
+type headerTransport struct {
+	calls int
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Test-Transport", "custom")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestRegisteredTransportIsUsed tests that a route's transport is used for forwarding
+func TestRegisteredTransportIsUsed(t *testing.T) {
+	// Setup
+	var gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Test-Transport")
+		w.Write([]byte(`{"jsonrpc":"2.0","result":"ok","id":1}`))
+	}))
+	defer server.Close()
+
+	rt := &headerTransport{}
+	RegisterTransport("test-custom", rt)
+
+	config = Config{
+		DefaultURL: "http://default-url.com",
+		Routes: []Route{
+			{Method: "method1", URL: server.URL, Transport: "test-custom"},
+		},
+	}
+	buildMethodURLMap()
+	if err := buildTransportMap(); err != nil {
+		t.Fatalf("Failed to build transport map: %v", err)
+	}
+	defer func() { urlToTransport = nil }()
+
+	// Test
+	resp, err := forwardRequest(context.Background(), server.URL, []byte(`{"jsonrpc":"2.0","method":"method1","params":[],"id":1}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+
+	// Verify
+	if rt.calls != 1 {
+		t.Errorf("Expected custom transport to be called once, got %d", rt.calls)
+	}
+	if gotHeader != "custom" {
+		t.Errorf("Expected upstream to see header from custom transport, got %q", gotHeader)
+	}
+}
+
+// TestTransportValidation tests conflicting and unknown transport detection
+func TestTransportValidation(t *testing.T) {
+	defer func() { urlToTransport = nil }()
+
+	// Conflicting transports for the same upstream
+	config = Config{
+		DefaultURL: "http://default-url.com",
+		Routes: []Route{
+			{Method: "method1", URL: "http://url1.com", Transport: "a"},
+			{Method: "method2", URL: "http://url1.com", Transport: "b"},
+		},
+	}
+	if err := buildTransportMap(); err == nil {
+		t.Error("Expected error for conflicting transports, got nil")
+	}
+
+	// Unknown transport
+	config = Config{
+		DefaultURL:       "http://default-url.com",
+		DefaultTransport: "does-not-exist",
+	}
+	if err := buildTransportMap(); err != nil {
+		t.Fatalf("Unexpected error building transport map: %v", err)
+	}
+	if err := validateTransports(); err == nil {
+		t.Error("Expected error for unknown transport, got nil")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// Transaction sender recovery
+//
+// The sender of a signed transaction is not part of its encoding; it is recovered
+// from the signature over the transaction's signing hash. Legacy (including EIP-155),
+// EIP-2930, EIP-1559, EIP-4844 (with or without the network wrapper), and EIP-7702
+// transactions are supported.
+
+// errNoSender is returned when a call does not identify a sender.
+var errNoSender = errors.New("call does not identify a sender")
+
+// callSender returns the lowercase hex sender address of a transaction submission.
+// eth_sendTransaction carries it in the "from" field; for eth_sendRawTransaction it is
+// recovered from the signature.
+//
+// Parameters:
+//   - req: The JSON-RPC call
+//
+// Returns:
+//   - string: The sender address with 0x prefix
+//   - error: An error if the call has no recognizable sender
+func callSender(req *JSONRPCRequest) (string, error) {
+	params, ok := req.Params.([]interface{})
+	if !ok || len(params) == 0 {
+		return "", errNoSender
+	}
+
+	switch req.Method {
+	case "eth_sendTransaction":
+		tx, ok := params[0].(map[string]interface{})
+		if !ok {
+			return "", errNoSender
+		}
+		from, ok := tx["from"].(string)
+		if !ok || len(from) != 42 || !strings.HasPrefix(from, "0x") {
+			return "", errNoSender
+		}
+		return strings.ToLower(from), nil
+	case "eth_sendRawTransaction":
+		rawHex, ok := params[0].(string)
+		if !ok {
+			return "", errNoSender
+		}
+		raw, err := hex.DecodeString(strings.TrimPrefix(rawHex, "0x"))
+		if err != nil {
+			return "", fmt.Errorf("invalid raw transaction: %w", err)
+		}
+		return recoverTxSender(raw)
+	}
+	return "", errNoSender
+}
+
+// recoverTxSender recovers the sender address of a signed raw transaction.
+//
+// Parameters:
+//   - raw: The transaction as submitted to eth_sendRawTransaction
+//
+// Returns:
+//   - string: The lowercase sender address with 0x prefix
+//   - error: An error if the transaction cannot be decoded or its signature is invalid
+func recoverTxSender(raw []byte) (string, error) {
+	if len(raw) == 0 {
+		return "", errors.New("empty transaction")
+	}
+
+	// Legacy transactions are a plain RLP list
+	if raw[0] >= 0xc0 {
+		fields, err := rlpSplitList(raw)
+		if err != nil {
+			return "", err
+		}
+		if len(fields) != 9 {
+			return "", fmt.Errorf("legacy transaction has %d fields, expected 9", len(fields))
+		}
+		v := new(big.Int).SetBytes(rlpContent(fields[6]))
+		var payload []byte
+		var recID uint64
+		switch {
+		case v.Cmp(big.NewInt(27)) == 0 || v.Cmp(big.NewInt(28)) == 0:
+			recID = v.Uint64() - 27
+			payload = rlpJoin(fields[:6])
+		case v.Cmp(big.NewInt(35)) >= 0:
+			// EIP-155: v = chainId*2 + 35 + recID
+			rest := new(big.Int).Sub(v, big.NewInt(35))
+			recID = new(big.Int).And(rest, big.NewInt(1)).Uint64()
+			chainID := rest.Rsh(rest, 1)
+			payload = rlpJoin(append(append([][]byte{}, fields[:6]...), rlpEncodeBytes(chainID.Bytes()), rlpEncodeBytes(nil), rlpEncodeBytes(nil)))
+		default:
+			return "", fmt.Errorf("invalid legacy signature v %s", v)
+		}
+		return recoverAddress(keccak256(payload), recID, rlpContent(fields[7]), rlpContent(fields[8]))
+	}
+
+	// Typed transactions: type byte followed by an RLP list ending in yParity, r, s
+	txType := raw[0]
+	if txType > 0x7f {
+		return "", fmt.Errorf("invalid transaction type 0x%x", txType)
+	}
+	fields, err := rlpSplitList(raw[1:])
+	if err != nil {
+		return "", err
+	}
+	if txType == 0x03 && len(fields) == 4 && len(fields[0]) > 0 && fields[0][0] >= 0xc0 {
+		// Blob transaction in network form: [tx, blobs, commitments, proofs]
+		if fields, err = rlpSplitList(fields[0]); err != nil {
+			return "", err
+		}
+	}
+	if len(fields) < 4 {
+		return "", fmt.Errorf("typed transaction has %d fields", len(fields))
+	}
+
+	n := len(fields)
+	yParity := new(big.Int).SetBytes(rlpContent(fields[n-3]))
+	if yParity.BitLen() > 1 {
+		return "", fmt.Errorf("invalid signature y parity %s", yParity)
+	}
+	payload := append([]byte{txType}, rlpJoin(fields[:n-3])...)
+	return recoverAddress(keccak256(payload), yParity.Uint64(), rlpContent(fields[n-2]), rlpContent(fields[n-1]))
+}
+
+// recoverAddress recovers the signer's address from a signature over hash.
+func recoverAddress(hash []byte, recID uint64, r, s []byte) (string, error) {
+	if len(r) > 32 || len(s) > 32 {
+		return "", errors.New("invalid signature length")
+	}
+	sig := make([]byte, 65)
+	sig[0] = 27 + byte(recID)
+	copy(sig[33-len(r):33], r)
+	copy(sig[65-len(s):], s)
+
+	pub, _, err := ecdsa.RecoverCompact(sig, hash)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %w", err)
+	}
+	return "0x" + hex.EncodeToString(keccak256(pub.SerializeUncompressed()[1:])[12:]), nil
+}
+
+// rlpSplitList decodes an RLP list and returns the complete encoding of each item.
+func rlpSplitList(data []byte) ([][]byte, error) {
+	isList, offset, size, err := rlpHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if !isList {
+		return nil, errors.New("rlp: expected a list")
+	}
+	if offset+size != len(data) {
+		return nil, errors.New("rlp: trailing bytes after list")
+	}
+
+	var items [][]byte
+	content := data[offset:]
+	for len(content) > 0 {
+		_, itemOffset, itemSize, err := rlpHeader(content)
+		if err != nil {
+			return nil, err
+		}
+		end := itemOffset + itemSize
+		items = append(items, content[:end])
+		content = content[end:]
+	}
+	return items, nil
+}
+
+// rlpHeader parses the header of the RLP item at the start of data and returns whether
+// it is a list, the offset of its content, and the content size.
+func rlpHeader(data []byte) (bool, int, int, error) {
+	if len(data) == 0 {
+		return false, 0, 0, errors.New("rlp: unexpected end of input")
+	}
+	b := data[0]
+	var isList bool
+	var offset, size int
+	switch {
+	case b < 0x80:
+		return false, 0, 1, nil
+	case b < 0xb8:
+		offset, size = 1, int(b-0x80)
+	case b < 0xc0:
+		offset, size = rlpLongSize(data, int(b-0xb7))
+	case b < 0xf8:
+		isList, offset, size = true, 1, int(b-0xc0)
+	default:
+		isList = true
+		offset, size = rlpLongSize(data, int(b-0xf7))
+	}
+	if offset == 0 || size < 0 || offset+size > len(data) {
+		return false, 0, 0, errors.New("rlp: item exceeds input")
+	}
+	return isList, offset, size, nil
+}
+
+// rlpLongSize decodes the big-endian length of a long RLP item. It returns a zero
+// offset if the length does not fit in the input.
+func rlpLongSize(data []byte, lenOfLen int) (int, int) {
+	if lenOfLen > 4 || 1+lenOfLen > len(data) {
+		return 0, 0
+	}
+	size := 0
+	for _, b := range data[1 : 1+lenOfLen] {
+		size = size<<8 | int(b)
+	}
+	return 1 + lenOfLen, size
+}
+
+// rlpContent returns the payload of a complete RLP string item.
+func rlpContent(item []byte) []byte {
+	_, offset, size, err := rlpHeader(item)
+	if err != nil {
+		return nil
+	}
+	if offset == 0 {
+		return item[:1]
+	}
+	return item[offset : offset+size]
+}
+
+// rlpEncodeBytes encodes a byte string as an RLP item.
+func rlpEncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append(rlpLength(len(b), 0x80), b...)
+}
+
+// rlpJoin encodes already-encoded items as an RLP list.
+func rlpJoin(items [][]byte) []byte {
+	size := 0
+	for _, item := range items {
+		size += len(item)
+	}
+	out := rlpLength(size, 0xc0)
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// rlpLength encodes an RLP string (base 0x80) or list (base 0xc0) header.
+func rlpLength(size int, base byte) []byte {
+	if size < 56 {
+		return []byte{base + byte(size)}
+	}
+	var be []byte
+	for n := size; n > 0; n >>= 8 {
+		be = append([]byte{byte(n)}, be...)
+	}
+	return append([]byte{base + 55 + byte(len(be))}, be...)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// TestRecoverTxSenderLegacy tests sender recovery for the EIP-155 example transaction
+func TestRecoverTxSenderLegacy(t *testing.T) {
+	// Setup
+	raw, _ := hex.DecodeString("f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83")
+
+	// Test
+	sender, err := recoverTxSender(raw)
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to recover sender: %v", err)
+	}
+	if sender != "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" {
+		t.Errorf("Expected sender 0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f, got %s", sender)
+	}
+}
+
+// TestRecoverTxSenderTyped tests sender recovery for an EIP-1559 transaction
+func TestRecoverTxSenderTyped(t *testing.T) {
+	// Setup
+	key, err := parsePrivateKey("0x4646464646464646464646464646464646464646464646464646464646464646")
+	if err != nil {
+		t.Fatalf("Failed to parse key: %v", err)
+	}
+	to, _ := hex.DecodeString("3535353535353535353535353535353535353535")
+	fields := [][]byte{
+		rlpEncodeBytes([]byte{0xe7, 0x08}), // chain ID 59144
+		rlpEncodeBytes([]byte{0x09}),       // nonce
+		rlpEncodeBytes([]byte{0x3b, 0x9a, 0xca, 0x00}),
+		rlpEncodeBytes([]byte{0x04, 0xa8, 0x17, 0xc8, 0x00}),
+		rlpEncodeBytes([]byte{0x52, 0x08}),
+		rlpEncodeBytes(to),
+		rlpEncodeBytes(nil),
+		rlpEncodeBytes(nil),
+		rlpJoin(nil), // access list
+	}
+	sig := ecdsa.SignCompact(key, keccak256(append([]byte{0x02}, rlpJoin(fields)...)), false)
+	fields = append(fields, rlpEncodeBytes(trimZeros([]byte{sig[0] - 27})), rlpEncodeBytes(trimZeros(sig[1:33])), rlpEncodeBytes(trimZeros(sig[33:])))
+	raw := append([]byte{0x02}, rlpJoin(fields)...)
+
+	// Test
+	sender, err := callSender(&JSONRPCRequest{Method: "eth_sendRawTransaction", Params: []interface{}{"0x" + hex.EncodeToString(raw)}})
+
+	// Verify
+	if err != nil {
+		t.Fatalf("Failed to recover sender: %v", err)
+	}
+	if expected := strings.ToLower(ethereumAddress(key.PubKey())); sender != expected {
+		t.Errorf("Expected sender %s, got %s", expected, sender)
+	}
+
+	// A corrupted transaction must not recover the same sender
+	raw[10] ^= 0xff
+	if corrupted, err := recoverTxSender(raw); err == nil && corrupted == sender {
+		t.Errorf("Expected corrupted transaction to fail recovery")
+	}
+}
+
+// trimZeros strips leading zero bytes for canonical RLP integers.
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// TestStickyBySender tests that writes from one account stay on one pool member
+func TestStickyBySender(t *testing.T) {
+	// Setup
+	config = Config{
+		WriteRouting: &WriteRoutingConfig{
+			Enabled:        true,
+			StickyBySender: true,
+			Upstreams: []UpstreamConfig{
+				{URL: "http://seq-1.example.com", Name: "seq-1"},
+				{URL: "http://seq-2.example.com", Name: "seq-2"},
+				{URL: "http://seq-3.example.com", Name: "seq-3"},
+			},
+		},
+	}
+	if err := setupWriteRouting(); err != nil {
+		t.Fatalf("Failed to set up write routing: %v", err)
+	}
+	defer func() {
+		config.WriteRouting = nil
+		setupWriteRouting()
+	}()
+
+	call := func(from string) string {
+		upstream, _ := writeTarget(&JSONRPCRequest{
+			Method: "eth_sendTransaction",
+			Params: []interface{}{map[string]interface{}{"from": from}},
+		})
+		return upstream.Name
+	}
+
+	// Test
+	members := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		from := "0x" + strings.Repeat(hex.EncodeToString([]byte{byte(i)}), 20)
+		first := call(from)
+		members[first] = true
+
+		// Verify
+		for j := 0; j < 5; j++ {
+			if got := call(from[:2] + strings.ToUpper(from[2:])); got != first {
+				t.Fatalf("Expected sender %s to stick to %s, got %s", from, first, got)
+			}
+		}
+	}
+	if len(members) < 2 {
+		t.Errorf("Expected senders to spread over the pool, got %v", members)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration versioning
+//
+// A configuration declares the layout it is written in with a top-level version:
+//
+//	version 1  the flat layout: each route and the default name their upstream inline
+//	           with url, name, and transport. Files without a version are version 1.
+//	version 2  the pooled layout: upstreams are declared once under pools and referred
+//	           to by default_pool and each route's pool (the current version)
+//
+// Older layouts keep loading: url, name, and transport remain valid shorthand for a
+// single upstream, so no existing configuration breaks. Loading one logs a warning
+// describing what the migration would change. `jsonrpc-proxy migrate` applies the
+// migrations to a file:
+//
+//	jsonrpc-proxy migrate -config config.yaml           # print the migrated configuration
+//	jsonrpc-proxy migrate -config config.yaml -write    # update the file, keeping a .bak copy
+//
+// Migrations work on the YAML document, so comments survive.
+// A configuration written for a newer version than the proxy supports is rejected.
+
+// currentConfigVersion is the configuration layout written by this version of the proxy.
+const currentConfigVersion = 2
+
+// configMigration upgrades a configuration document from one version to the next.
+type configMigration struct {
+	from    int                            // The version the migration upgrades from
+	migrate func(root *yaml.Node) []string // Rewrites the document, returning a note per change
+}
+
+// configMigrations are the migrations, in order.
+var configMigrations = []configMigration{
+	{from: 1, migrate: migrateToPools},
+}
+
+// configVersion returns the version declared by a configuration document.
+//
+// Parameters:
+//   - root: The root node of the document (nil for an empty document)
+//
+// Returns:
+//   - int: The version, 1 if the document does not declare one
+//   - error: An error if the version is invalid or newer than supported
+func configVersion(root *yaml.Node) (int, error) {
+	n := mappingNode(root, "version")
+	if n == nil {
+		return 1, nil
+	}
+	v, err := strconv.Atoi(n.Value)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%sversion: expected a positive integer, got %q", position(n), n.Value)
+	}
+	if v > currentConfigVersion {
+		return 0, fmt.Errorf("%sversion %d is newer than this proxy supports (up to %d)", position(n), v, currentConfigVersion)
+	}
+	return v, nil
+}
+
+// migrateConfig upgrades a configuration document to the current version in place.
+//
+// Parameters:
+//   - root: The root mapping of the document
+//
+// Returns:
+//   - int: The version the document declared
+//   - []string: A note for each change made
+//   - error: An error if the declared version is invalid
+func migrateConfig(root *yaml.Node) (int, []string, error) {
+	version, err := configVersion(root)
+	if err != nil || version == currentConfigVersion {
+		return version, nil, err
+	}
+	var notes []string
+	for _, m := range configMigrations {
+		if m.from >= version {
+			notes = append(notes, m.migrate(root)...)
+		}
+	}
+	setVersion(root, currentConfigVersion)
+	return version, notes, nil
+}
+
+// checkConfigVersion checks the version of a loaded configuration file and warns if it
+// uses an older layout, describing the changes a migration would make.
+//
+// Parameters:
+//   - filename: The configuration file, for messages
+//   - root: The root node of the document (nil for an empty document)
+//
+// Returns:
+//   - error: An error if the version is invalid or newer than supported
+func checkConfigVersion(filename string, root *yaml.Node) error {
+	version, err := configVersion(root)
+	if err != nil || version == currentConfigVersion {
+		return err
+	}
+
+	// Migrate a copy, so that the configuration loads exactly as written
+	var doc yaml.Node
+	if err := doc.Encode(root); err != nil {
+		return err
+	}
+	_, notes, err := migrateConfig(&doc)
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		log.Printf("Warning: Configuration %s uses layout version %d; add \"version: %d\" to it", filename, version, currentConfigVersion)
+		return nil
+	}
+	log.Printf("Warning: Configuration %s uses layout version %d, which still loads. Run \"jsonrpc-proxy migrate -config %s -write\" to upgrade it to version %d:", filename, version, filename, currentConfigVersion)
+	for _, note := range notes {
+		log.Printf("Warning:   %s", note)
+	}
+	return nil
+}
+
+// migrateToPools upgrades the flat layout to the pooled layout: the upstreams named
+// inline by the default, routes, and route groups move into pools, one per distinct
+// upstream, which are then referred to by name.
+func migrateToPools(root *yaml.Node) []string {
+	pools := mappingNode(root, "pools")
+	if pools == nil || pools.Kind != yaml.MappingNode {
+		pools = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	byUpstream := make(map[string]string)
+	var notes []string
+
+	// addPool returns the pool for an upstream, declaring it on first use
+	addPool := func(member *yaml.Node, hint string) string {
+		key := mappingValue(member, "url") + "\x00" + mappingValue(member, "name") + "\x00" + mappingValue(member, "transport")
+		if name, ok := byUpstream[key]; ok {
+			return name
+		}
+		name := uniquePoolName(pools, hint)
+		byUpstream[key] = name
+		setNode(pools, []string{name}, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "upstreams"},
+			{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{member}},
+		}})
+		return name
+	}
+
+	// moveUpstream moves the upstream of a route-like mapping into a pool. The keys of
+	// the default carry the default_ prefix.
+	moveUpstream := func(m *yaml.Node, what, prefix string) {
+		if mappingValue(m, prefix+"url") == "" || mappingNode(m, prefix+"pool") != nil || mappingNode(m, "stub") != nil {
+			return
+		}
+		member := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, field := range []string{"url", "name", "transport"} {
+			if n := mappingNode(m, prefix+field); n != nil {
+				setNode(member, []string{field}, n)
+			}
+		}
+		comment := ""
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			if m.Content[i].Value == prefix+"url" {
+				comment = m.Content[i].HeadComment
+			}
+		}
+		removeKeys(m, prefix+"url", prefix+"transport")
+		if prefix != "" {
+			// The default's name moves to the pool; routes keep theirs, which labels
+			// them in logs and metrics
+			removeKeys(m, prefix+"name")
+		}
+		hint := mappingValue(member, "name")
+		if hint == "" {
+			hint = poolNameHint(mappingValue(member, "url"))
+		}
+		name := addPool(member, hint)
+		m.Content = append(m.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: prefix + "pool", HeadComment: comment},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name})
+		notes = append(notes, fmt.Sprintf("%s: %surl moves to pool %s", what, prefix, name))
+	}
+
+	moveUpstream(root, "default", "default_")
+	if routes := mappingNode(root, "routes"); routes != nil {
+		for i, route := range routes.Content {
+			moveUpstream(route, fmt.Sprintf("route %d (%s)", i, mappingValue(route, "method")), "")
+		}
+	}
+	if groups := mappingNode(root, "route_groups"); groups != nil {
+		for _, group := range groups.Content {
+			what := "route group " + mappingValue(group, "name")
+			moveUpstream(mappingNode(group, "defaults"), what+" defaults", "")
+			if routes := mappingNode(group, "routes"); routes != nil {
+				for _, route := range routes.Content {
+					moveUpstream(route, what+" route "+mappingValue(route, "method"), "")
+				}
+			}
+		}
+	}
+
+	if len(pools.Content) > 0 {
+		setNode(root, []string{"pools"}, pools)
+	}
+	return notes
+}
+
+// poolNameHint derives a pool name from an upstream URL: its host name.
+func poolNameHint(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "upstream"
+}
+
+// uniquePoolName returns the hint, with a numeric suffix if a pool already has its name.
+func uniquePoolName(pools *yaml.Node, hint string) string {
+	name := hint
+	for i := 2; mappingNode(pools, name) != nil; i++ {
+		name = fmt.Sprintf("%s-%d", hint, i)
+	}
+	return name
+}
+
+// setVersion declares a version at the top of a configuration document.
+func setVersion(root *yaml.Node, version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if mappingNode(root, "version") != nil {
+		setNode(root, []string{"version"}, value)
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// mappingNode returns the value node of a key in a mapping node, or nil if the node is
+// not a mapping or the key is missing.
+func mappingNode(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKeys removes keys and their values from a mapping node.
+func removeKeys(m *yaml.Node, keys ...string) {
+	kept := m.Content[:0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		remove := false
+		for _, key := range keys {
+			remove = remove || m.Content[i].Value == key
+		}
+		if !remove {
+			kept = append(kept, m.Content[i], m.Content[i+1])
+		}
+	}
+	m.Content = kept
+}
+
+// runMigrate implements the migrate subcommand.
+//
+// Parameters:
+//   - args: The arguments following "migrate"
+//   - stdout: Where the migrated configuration is written without -write
+//   - stderr: Where usage and the changes made are written
+//
+// Returns:
+//   - error: An error if the configuration cannot be read, migrated, or written
+func runMigrate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "config.yaml", "Configuration file to migrate")
+	write := fs.Bool("write", false, "Update the file in place, keeping a .bak copy, instead of printing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if isConfigTemplate(*configFile) {
+		return fmt.Errorf("%s is a template: migrate the rendered configuration and update the template by hand", *configFile)
+	}
+	if *write && configFormat(*configFile) != "yaml" {
+		return fmt.Errorf("-write only updates YAML files: print the migrated %s configuration as YAML instead", strings.ToUpper(configFormat(*configFile)))
+	}
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	root, err := parseConfigDocument(*configFile, data)
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", *configFile, err)
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	version, notes, err := migrateConfig(root)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		fmt.Fprintf(stderr, "%s\n", note)
+	}
+
+	var out bytes.Buffer
+	if err := writeYAML(&out, root); err != nil {
+		return err
+	}
+	if !*write {
+		_, err := stdout.Write(out.Bytes())
+		return err
+	}
+	if version == currentConfigVersion {
+		fmt.Fprintf(stderr, "%s is already at version %d\n", *configFile, currentConfigVersion)
+		return nil
+	}
+	if err := os.WriteFile(*configFile+".bak", data, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*configFile, out.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Migrated %s from version %d to %d (previous version saved as %s.bak)\n", *configFile, version, currentConfigVersion, *configFile)
+	return nil
+}
+
+// writeYAML encodes a configuration document with the two-space indentation used by
+// the examples.
+func writeYAML(w io.Writer, root *yaml.Node) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
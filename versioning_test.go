@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// flatConfig is a configuration in the version 1 layout
+const flatConfig = `# Main provider
+default_url: http://main.example.com
+default_name: main
+pools:
+  archive:
+    upstreams:
+      - url: http://archive.example.com
+routes:
+  - method: eth_chainId
+    stub: {result: "0x1"}
+  - method: eth_getLogs
+    pool: archive
+  - method: eth_sendRawTransaction
+    url: http://relay.example.com/key
+    name: relay
+  - method: eth_call
+    url: http://relay.example.com/key
+    name: relay
+  - method: eth_getBalance
+    url: http://main.example.com
+route_groups:
+  - name: traces
+    defaults:
+      url: http://tracer.example.com
+    methods: [trace_block]
+`
+
+// TestMigrateConfig tests migrating the flat layout to the pooled layout
+func TestMigrateConfig(t *testing.T) {
+	// Setup
+	root, err := parseConfigDocument("config.yaml", []byte(flatConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	// Test
+	version, notes, err := migrateConfig(root)
+
+	// Verify
+	if err != nil || version != 1 {
+		t.Fatalf("Expected a version 1 configuration to migrate, got version %d: %v", version, err)
+	}
+	expected := []string{
+		"default: default_url moves to pool main",
+		"route 2 (eth_sendRawTransaction): url moves to pool relay",
+		"route 3 (eth_call): url moves to pool relay",
+		"route 4 (eth_getBalance): url moves to pool main.example.com",
+		"route group traces defaults: url moves to pool tracer.example.com",
+	}
+	if strings.Join(notes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected notes %v, got %v", expected, notes)
+	}
+
+	var out bytes.Buffer
+	if err := writeYAML(&out, root); err != nil {
+		t.Fatalf("Failed to encode config: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "version: 2\n") || !strings.Contains(out.String(), "# Main provider") {
+		t.Errorf("Expected the version first and comments kept, got:\n%s", out.String())
+	}
+	config = Config{}
+	if err := loadConfig(writeConfigFile(t, out.String())); err != nil {
+		t.Fatalf("Failed to load migrated config: %v\n%s", err, out.String())
+	}
+	if config.Version != 2 || config.DefaultURL != "" || config.DefaultPool != "main" || len(config.Pools) != 5 {
+		t.Errorf("Unexpected migrated config: %+v", config)
+	}
+	pools := make(map[string]string)
+	for _, route := range config.Routes {
+		pools[route.Method] = route.Pool
+		if route.URL != "" {
+			t.Errorf("Expected %s to use a pool, got url %s", route.Method, route.URL)
+		}
+	}
+	if pools["eth_call"] != "relay" || pools["eth_getLogs"] != "archive" || pools["trace_block"] != "tracer.example.com" || pools["eth_chainId"] != "" {
+		t.Errorf("Unexpected route pools: %v", pools)
+	}
+	if member := config.Pools["relay"].Upstreams[0]; member.URL != "http://relay.example.com/key" || member.Name != "relay" {
+		t.Errorf("Unexpected relay pool member: %+v", member)
+	}
+}
+
+// TestConfigVersion tests that older layouts load and unsupported versions are rejected
+func TestConfigVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		yaml     string
+		expected string
+	}{
+		{"Flat layout", flatConfig, ""},
+		{"Current version", "version: 2\ndefault_url: http://a.example.com\n", ""},
+		{"Newer version", "version: 3\ndefault_url: http://a.example.com\n", "line 1, column 10: version 3 is newer than this proxy supports (up to 2)"},
+		{"Invalid version", "version: two\ndefault_url: http://a.example.com\n", `version: expected a positive integer, got "two"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			config = Config{}
+
+			// Test
+			err := loadConfig(writeConfigFile(t, tc.yaml))
+
+			// Verify
+			if tc.expected == "" && err != nil {
+				t.Errorf("Failed to load config: %v", err)
+			}
+			if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestRunMigrate tests updating a configuration file with the migrate subcommand
+func TestRunMigrate(t *testing.T) {
+	// Setup
+	path := writeConfigFile(t, flatConfig)
+	var stdout, stderr bytes.Buffer
+
+	// Test
+	err := runMigrate([]string{"-config", path, "-write"}, &stdout, &stderr)
+	again := runMigrate([]string{"-config", path, "-write"}, &stdout, &stderr)
+
+	// Verify
+	if err != nil || again != nil {
+		t.Fatalf("Failed to migrate: %v, %v", err, again)
+	}
+	backup, _ := os.ReadFile(path + ".bak")
+	if string(backup) != flatConfig {
+		t.Errorf("Expected the original configuration in %s.bak", path)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "version: 2\n") {
+		t.Errorf("Expected the file to be migrated, got:\n%s", data)
+	}
+	if !strings.Contains(stderr.String(), "from version 1 to 2") || !strings.Contains(stderr.String(), "already at version 2") {
+		t.Errorf("Unexpected messages: %s", stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected nothing on standard output with -write, got %s", stdout.String())
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+)
+
+// Read/write split routing
+//
+// Methods that submit transactions are classified as writes. When write routing is
+// enabled, every write goes to a dedicated pool of upstreams (for example nodes close
+// to the sequencer) regardless of the method and conditional routes. Writes are spread
+// round-robin over the pool, skipping members that the probes report as unhealthy.
+// A private relay selected for a call still takes precedence. Like calls falling back
+// to the default route (see fallback.go), writes sent to the pool skip the rewriting,
+// param rules, header rules, and mirror of the route matching them, since those are
+// meant for the route's own upstream; a warning is logged at startup for each such
+// route.
+//
+// With sticky_by_sender, writes from the same account always go to the same pool
+// member, so that sequential nonces are not spread over nodes whose mempools may
+// diverge and report nonce gaps. The sender is taken from eth_sendTransaction's "from"
+// field or recovered from the signed raw transaction, and mapped to a member with
+// rendezvous hashing: removing or losing a member only moves the accounts it served.
+
+// builtinWriteMethods are always classified as writes.
+var builtinWriteMethods = []string{"eth_sendRawTransaction", "eth_sendTransaction"}
+
+// WriteRoutingConfig configures routing of write methods to a dedicated pool.
+type WriteRoutingConfig struct {
+	Enabled        bool             `yaml:"enabled"`          // Route writes to the pool
+	Methods        []string         `yaml:"methods"`          // Additional methods classified as writes
+	Upstreams      []UpstreamConfig `yaml:"upstreams"`        // The write pool
+	StickyBySender bool             `yaml:"sticky_by_sender"` // Send each account's writes to the same member
+}
+
+// UpstreamConfig describes one member of an upstream pool.
+type UpstreamConfig struct {
+	URL       string `yaml:"url"`       // Destination URL
+	Name      string `yaml:"name"`      // Human-readable name for logging (optional)
+	Transport string `yaml:"transport"` // Registered transport used for this upstream (optional)
+	Region    string `yaml:"region"`    // Region the upstream runs in, for region preference (optional)
+}
+
+// upstream returns the pool member as an Upstream.
+func (uc UpstreamConfig) upstream() Upstream {
+	if uc.Name == "" {
+		return Upstream{Name: uc.URL, URL: uc.URL}
+	}
+	return Upstream{Name: uc.Name, URL: uc.URL}
+}
+
+var (
+	writeMethods map[string]bool // Methods classified as writes
+	writeNext    atomic.Uint64   // Round-robin position in the write pool
+)
+
+// setupWriteRouting validates the write routing configuration and builds the set of write methods.
+//
+// Returns:
+//   - error: An error if write routing is enabled without a usable pool
+func setupWriteRouting() error {
+	writeMethods = make(map[string]bool)
+	for _, m := range builtinWriteMethods {
+		writeMethods[m] = true
+	}
+
+	wc := config.WriteRouting
+	if wc == nil || !wc.Enabled {
+		return nil
+	}
+	if len(wc.Upstreams) == 0 {
+		return fmt.Errorf("write_routing.upstreams must list at least one upstream")
+	}
+	for i, uc := range wc.Upstreams {
+		if uc.URL == "" {
+			return fmt.Errorf("write_routing.upstreams[%d]: url is required", i)
+		}
+	}
+	for _, m := range wc.Methods {
+		writeMethods[m] = true
+	}
+	warnBypassedRouteRules("write_routing", writeMethods)
+	return nil
+}
+
+// warnBypassedRouteRules warns about the routes matching methods that a feature sends
+// to its own upstreams, and whose rewriting, param rules, header rules, or mirror
+// therefore do not apply to those methods.
+//
+// Parameters:
+//   - feature: The configuration key of the feature
+//   - methods: The methods the feature sends to its own upstreams
+func warnBypassedRouteRules(feature string, methods map[string]bool) {
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if route.Rewrite == nil && len(route.ParamRules) == 0 && route.Headers == nil && route.Mirror == nil {
+			continue
+		}
+		for _, method := range sortedKeys(methods) {
+			if methodPatternMatches(route.Method, method) {
+				log.Printf("Warning: %s sends %s to its own upstreams, without the rewrite, param_rules, headers, and mirror of route %s", feature, method, routeName(route))
+			}
+		}
+	}
+}
+
+// isWriteMethod reports whether a method submits a transaction.
+func isWriteMethod(method string) bool {
+	return writeMethods[method]
+}
+
+// writeTarget reports whether a call should go to the write pool and returns the
+// selected pool member.
+//
+// Parameters:
+//   - req: The JSON-RPC call
+//
+// Returns:
+//   - Upstream: The pool member to use
+//   - bool: Whether the call is a write to be routed to the pool
+func writeTarget(req *JSONRPCRequest) (Upstream, bool) {
+	wc := config.WriteRouting
+	if wc == nil || !wc.Enabled || !isWriteMethod(req.Method) {
+		return Upstream{}, false
+	}
+
+	pool := regionalMembers("write_routing", wc.Upstreams)
+	if wc.StickyBySender {
+		sender, err := callSender(req)
+		if err == nil {
+			return stickyMember(pool, sender).upstream(), true
+		}
+		if err != errNoSender {
+			logWarn("router", "Cannot determine sender for method '%s', routing round-robin: %v", req.Method, err)
+		}
+	}
+
+	return roundRobinMember(pool, &writeNext).upstream(), true
+}
+
+// stickyMember selects the pool member for a sender using rendezvous hashing,
+// preferring healthy members. A member in slow start only takes the senders whose score
+// falls within its current share.
+func stickyMember(pool []UpstreamConfig, sender string) UpstreamConfig {
+	var best UpstreamConfig
+	var bestScore uint64
+	bestHealthy := false
+	for _, member := range pool {
+		h := fnv.New64a()
+		h.Write([]byte(sender))
+		h.Write([]byte{0})
+		h.Write([]byte(member.URL))
+		score := h.Sum64()
+
+		healthy := upstreamHealthy(member.URL)
+		if w := upstreamWeight(member.URL); healthy && w < 1 {
+			healthy = float64(score%10000) < w*10000
+		}
+		if best.URL == "" || (healthy && !bestHealthy) || (healthy == bestHealthy && score > bestScore) {
+			best, bestScore, bestHealthy = member, score, healthy
+		}
+	}
+	return best
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteRouting tests that writes go to the write pool regardless of other routes
+func TestWriteRouting(t *testing.T) {
+	// Setup
+	config = Config{
+		DefaultURL: "http://reads.example.com",
+		Routes: []Route{
+			{Method: "eth_sendRawTransaction", URL: "http://route.example.com"},
+		},
+		WriteRouting: &WriteRoutingConfig{
+			Enabled: true,
+			Methods: []string{"eth_sendBundle"},
+			Upstreams: []UpstreamConfig{
+				{URL: "http://seq-1.example.com", Name: "seq-1"},
+				{URL: "http://seq-2.example.com", Name: "seq-2"},
+				{URL: "http://seq-3.example.com", Name: "seq-3"},
+			},
+		},
+	}
+	buildMethodURLMap()
+	if err := setupWriteRouting(); err != nil {
+		t.Fatalf("Failed to set up write routing: %v", err)
+	}
+	writeNext.Store(0)
+	defer func() {
+		config.WriteRouting = nil
+		setupWriteRouting()
+	}()
+
+	// Test reads are not affected
+	if _, ok := writeTarget(&JSONRPCRequest{Method: "eth_call"}); ok {
+		t.Errorf("Expected eth_call to be classified as a read")
+	}
+
+	// Test writes rotate over the pool
+	for _, expected := range []string{"seq-1", "seq-2", "seq-3", "seq-1"} {
+		upstream, ok := writeTarget(&JSONRPCRequest{Method: "eth_sendRawTransaction"})
+		if !ok || upstream.Name != expected {
+			t.Errorf("Expected write to go to %s, got %v (%v)", expected, upstream.Name, ok)
+		}
+	}
+
+	// Test configured write methods
+	if _, ok := writeTarget(&JSONRPCRequest{Method: "eth_sendBundle"}); !ok {
+		t.Errorf("Expected eth_sendBundle to be classified as a write")
+	}
+
+	// Test unhealthy members are skipped
+	statusMu.Lock()
+	upstreamStatuses = map[string]*upstreamStatus{
+		"http://seq-2.example.com": {Healthy: false, LastProbe: time.Now()},
+	}
+	statusMu.Unlock()
+	defer func() {
+		statusMu.Lock()
+		upstreamStatuses = nil
+		statusMu.Unlock()
+	}()
+	writeNext.Store(1)
+	if upstream, _ := writeTarget(&JSONRPCRequest{Method: "eth_sendTransaction"}); upstream.Name != "seq-3" {
+		t.Errorf("Expected unhealthy seq-2 to be skipped, got %s", upstream.Name)
+	}
+}
+
+// TestWriteRoutingThroughProxy tests a write sent through the proxy handler
+func TestWriteRoutingThroughProxy(t *testing.T) {
+	// Setup
+	reads := mockHTTPServer(t, "eth_call", `{"jsonrpc":"2.0","id":1,"result":"0x"}`)
+	defer reads.Close()
+	writes := mockHTTPServer(t, "eth_sendRawTransaction", `{"jsonrpc":"2.0","id":1,"result":"0xabc"}`)
+	defer writes.Close()
+
+	config = Config{
+		DefaultURL:   reads.URL,
+		WriteRouting: &WriteRoutingConfig{Enabled: true, Upstreams: []UpstreamConfig{{URL: writes.URL}}},
+	}
+	buildMethodURLMap()
+	if err := setupWriteRouting(); err != nil {
+		t.Fatalf("Failed to set up write routing: %v", err)
+	}
+	defer func() {
+		config.WriteRouting = nil
+		setupWriteRouting()
+	}()
+
+	// Test
+	var hash string
+	if err := callProxy(t, "eth_sendRawTransaction", []interface{}{"0x00"}, &hash); err != nil {
+		t.Fatalf("eth_sendRawTransaction failed: %v", err)
+	}
+	var result string
+	if err := callProxy(t, "eth_call", []interface{}{}, &result); err != nil {
+		t.Fatalf("eth_call failed: %v", err)
+	}
+
+	// Verify
+	if hash != "0xabc" {
+		t.Errorf("Expected the write pool to answer, got %s", hash)
+	}
+}
+
+// TestWriteRoutingWarnsBypassedRules tests warning about route rules that writes sent to the pool skip
+func TestWriteRoutingWarnsBypassedRules(t *testing.T) {
+	// Setup
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	config = Config{
+		DefaultURL: "http://reads.example.com",
+		Routes: []Route{
+			{Method: "eth_send*", Name: "sends", URL: "http://route.example.com", Headers: &HeaderRules{}},
+			{Method: "eth_sendRawTransaction", URL: "http://plain.example.com"},
+		},
+		WriteRouting: &WriteRoutingConfig{Enabled: true, Upstreams: []UpstreamConfig{{URL: "http://seq-1.example.com"}}},
+	}
+	defer func() { config = Config{} }()
+
+	// Test
+	if err := setupWriteRouting(); err != nil {
+		t.Fatalf("Failed to set up write routing: %v", err)
+	}
+
+	// Verify
+	if !strings.Contains(out.String(), "write_routing sends eth_sendRawTransaction to its own upstreams, without the rewrite, param_rules, headers, and mirror of route sends") {
+		t.Errorf("Expected a warning about route sends, got:\n%s", out.String())
+	}
+	if strings.Count(out.String(), "Warning:") != 2 {
+		t.Errorf("Expected one warning per write method of route sends only, got:\n%s", out.String())
+	}
+}